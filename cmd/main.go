@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"bedrock-proxy-test/pkg"
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/apierror"
 	"bedrock-proxy-test/pkg/auth"
 	"bedrock-proxy-test/pkg/database"
 	"bedrock-proxy-test/pkg/metrics"
@@ -24,11 +31,48 @@ func chainMiddlewares(handler http.HandlerFunc, mws ...func(http.Handler) http.H
 	return h.ServeHTTP
 }
 
+// runSelfTest ejecuta las comprobaciones de --selftest, imprime el informe JSON y devuelve el exit code
+func runSelfTest() int {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	
+	report := pkg.RunSelfTest(ctx)
+	
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to encode self-test report: %v\n", err)
+		return 1
+	}
+	
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
+
 func main() {
+	selfTest := flag.Bool("selftest", false, "Validate config and connectivity, print a JSON report and exit")
+	flag.Parse()
+	
 	// Inicializar logger según Política de Logs v1.0
 	pkg.InitLogger()
+	
+	if *selfTest {
+		code := runSelfTest()
+		pkg.CloseLogger()
+		os.Exit(code)
+	}
 	defer pkg.CloseLogger()
 	
+	// Tracing OpenTelemetry opcional (no-op sin OTEL_EXPORTER_OTLP_ENDPOINT)
+	shutdownTracing, err := pkg.InitTracing(context.Background())
+	if err != nil {
+		fmt.Printf("Error: OpenTelemetry initialization failed: %v\n", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+	
 	// Cargar configuración desde variables de entorno
 	config := pkg.LoadBedrockConfigWithEnv()
 	
@@ -45,23 +89,61 @@ func main() {
 		os.Exit(1)
 	}
 	
-	// Inicializar conexión a PostgreSQL (opcional)
+	// Modelos mapeados sin anthropic_version explícito: warning (o error en modo estricto)
+	if err := pkg.ValidateVersionMappings(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	
+	// Tipo de error Anthropic por status en todas las respuestas de error (auth, cuotas y proxy)
+	apierror.SetStatusTypes(pkg.LoadErrorTypesByStatusWithEnv())
+	
+	// Modo proxy puro: sin BD, autenticación, cuotas ni métricas (solo firma y conversión)
+	pureProxyConfig := pkg.LoadPureProxyConfigWithEnv()
+	if err := pureProxyConfig.Validate(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	
+	// Configuración de arranque que expone /admin/config (sin secretos)
+	effectiveConfig := pkg.EffectiveConfig{PureProxy: pureProxyConfig}
+	
+	// Inicializar conexión a PostgreSQL (opcional, nunca en modo proxy puro)
 	var db *database.Database
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	
-	var err error
-	db, err = pkg.InitializeDatabase(ctx)
-	if err != nil {
-		db = nil
-	} else if err := db.Ping(ctx); err != nil {
-		db.Close()
-		db = nil
+	// Sin BD configurada se arranca en modo legacy (LEGACY_INFERENCE_PROFILE). Con la BD configurada pero
+	// inaccesible no se arranca: una caída de la BD no debe abrir el proxy sin autenticación ni cuotas
+	if !pureProxyConfig.Enabled && pkg.LoadDatabaseConnectionConfig().Configured() {
+		db, err = pkg.InitializeDatabase(ctx)
+		if err == nil {
+			if err = db.Ping(ctx); err != nil {
+				db.Close()
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error: database is configured but unavailable: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if db != nil {
+		db.SetTeamSchemas(pkg.LoadTeamSchemasWithEnv())
+		effectiveConfig.Database = pkg.LoadDatabaseConnectionConfig()
 	}
 	
 	// Crear cliente Bedrock
 	client := pkg.NewBedrockClient(config)
 	
+	// DLP opcional: un patrón inválido no debe desactivar el filtro en silencio
+	dlpFilter, err := pkg.LoadDLPFilterWithEnv()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	client.SetDLPFilter(dlpFilter)
+	client.SetCostFormatOptions(pkg.LoadCostFormatConfigWithEnv())
+	
 	// Inicializar middleware de autenticación (si BD disponible)
 	var authMiddleware *auth.AuthMiddleware
 	
@@ -76,10 +158,26 @@ func main() {
 			SecretKey: jwtConfig.SecretKey,
 			Issuer:    jwtConfig.Issuer,
 			Audience:  jwtConfig.Audience,
+
+			JWKSURL:                jwtConfig.JWKSURL,
+			JWKSRefreshInterval:    jwtConfig.JWKSRefreshInterval,
+			JWKSMinRefreshInterval: jwtConfig.JWKSMinRefreshInterval,
 		}
 		
 		authMiddleware = auth.NewAuthMiddleware(db, authConfig)
 		auth.Logger = pkg.Logger
+		authMiddleware.SetQuotaGrace(pkg.LoadQuotaGraceConfigWithEnv())
+		authMiddleware.SetDuplicateCredentialsMode(pkg.LoadDuplicateCredentialsModeWithEnv())
+		missingClaimsConfig := pkg.LoadMissingClaimsConfigWithEnv()
+		authMiddleware.SetMissingClaimsConfig(missingClaimsConfig)
+		authMiddleware.SetRateLimitBackendPolicy(pkg.LoadRateLimitBackendPolicyWithEnv())
+		authMiddleware.SetTokenPropagationGrace(pkg.LoadTokenPropagationGraceWithEnv())
+		authMiddleware.SetQuotaWebhook(pkg.LoadQuotaWebhookConfigWithEnv())
+		authMiddleware.SetQuotaBypass(pkg.LoadQuotaBypassConfigWithEnv().Matches)
+		authMiddleware.SetQuotaZeroLimit(pkg.LoadQuotaZeroLimitConfigWithEnv().ResolveDailyRequestLimit)
+		authMiddleware.SetQuotaReset(pkg.LoadQuotaResetConfigWithEnv().NextDailyReset)
+		effectiveConfig.JWT = jwtConfig
+		effectiveConfig.MissingClaims = missingClaimsConfig
 	}
 	
 	// Inicializar MetricsWorker y Scheduler (si BD disponible)
@@ -87,54 +185,125 @@ func main() {
 	var schedulerService *scheduler.SchedulerService
 	
 	if db != nil {
-		metricsConfig := metrics.DefaultConfig()
+		metricsConfig := pkg.LoadMetricsWorkerConfigWithEnv()
 		metricsWorker = metrics.NewMetricsWorker(db, metricsConfig)
 		metricsWorker.Start()
 		
 		schedulerService = scheduler.NewSchedulerService(db, pkg.Log)
+		schedulerService.SetResetConfig(pkg.LoadQuotaResetConfigWithEnv())
+		if exportConfig := pkg.LoadMetricsExportConfigWithEnv(); exportConfig.Enabled() {
+			writer, err := scheduler.NewS3ObjectWriter(context.Background(), exportConfig.Bucket, exportConfig.Region)
+			if err != nil {
+				pkg.Logger.Warning(amslog.Event{
+					Name:    "METRICS_EXPORT_DISABLED",
+					Message: "Metrics export disabled: failed to create S3 writer",
+					Error: &amslog.ErrorInfo{
+						Type:    "ConfigError",
+						Message: err.Error(),
+						Code:    "S3_WRITER_INIT_FAILED",
+					},
+				})
+			} else {
+				schedulerService.SetMetricsExporter(scheduler.NewMetricsExporter(db, writer, exportConfig))
+			}
+		}
+		if retentionConfig := pkg.LoadRetentionConfigWithEnv(); retentionConfig.Enabled() {
+			schedulerService.SetPartitionRetention(scheduler.NewPartitionRetention(db, retentionConfig))
+		}
 		schedulerService.Start()
 	}
 	
 	// Pasar dependencias al cliente Bedrock y AuthMiddleware
 	if db != nil && metricsWorker != nil {
 		client.SetDependencies(db, metricsWorker)
+		effectiveConfig.Pricing = pkg.LoadPricingConfigWithEnv()
+		client.SetPricingConfig(effectiveConfig.Pricing)
 		if authMiddleware != nil {
 			authMiddleware.SetMetricsWorker(metricsWorker)
 		}
 	}
 	
 	// Configurar rutas
+	var routeMiddlewares []func(http.Handler) http.Handler
 	if authMiddleware != nil {
-		middlewares := []func(http.Handler) http.Handler{
-			authMiddleware.Middleware,
-		}
-		http.HandleFunc("/v1/messages", chainMiddlewares(client.HandleProxy, middlewares...))
+		routeMiddlewares = append(routeMiddlewares, authMiddleware.Middleware)
+	} else if pureProxyConfig.Enabled {
+		// Modo proxy puro: todas las requests usan el inference profile de PURE_PROXY_INFERENCE_PROFILE
+		routeMiddlewares = append(routeMiddlewares, pkg.PureProxyUserMiddleware(pureProxyConfig))
 	} else {
-		http.HandleFunc("/v1/messages", client.HandleProxy)
+		// Modo legacy (sin BD): sin JWT todas las requests usan el inference profile configurado
+		legacyConfig := pkg.LoadLegacyModeConfigWithEnv()
+		if err := legacyConfig.Validate(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		routeMiddlewares = append(routeMiddlewares, pkg.LegacyUserMiddleware(legacyConfig))
 	}
+	// Filtro de User-Agent después de auth (desactivado si no hay listas configuradas)
+	effectiveConfig.ClientFilter = pkg.LoadClientFilterConfigWithEnv()
+	routeMiddlewares = append(routeMiddlewares, pkg.ClientFilterMiddleware(effectiveConfig.ClientFilter))
+	// El deadline va primero para acotar también la autenticación y la cuota en BD. El batch no lo lleva:
+	// cada una de sus requests tiene su propio deadline (un único deadline cortaría las últimas)
+	middlewares := append([]func(http.Handler) http.Handler{pkg.RequestDeadlineMiddleware(config.RequestTimeout)}, routeMiddlewares...)
+	http.HandleFunc("/v1/messages", chainMiddlewares(client.HandleProxy, middlewares...))
+	http.HandleFunc("/v1/messages/batch", chainMiddlewares(client.HandleBatch, routeMiddlewares...))
+	
+	// Endpoints de administración: solo con autenticación activa y grupo admin
+	if authMiddleware != nil {
+		adminMiddlewares := append(middlewares, auth.RequireGroups(pkg.LoadAdminGroupsWithEnv()))
+		http.HandleFunc("/admin/preview", chainMiddlewares(client.HandlePreview, adminMiddlewares...))
+		http.HandleFunc("/admin/maintenance", chainMiddlewares(client.HandleMaintenance, adminMiddlewares...))
+		http.HandleFunc("/admin/config", chainMiddlewares(client.HandleConfig, adminMiddlewares...))
+		http.HandleFunc("/admin/stats", chainMiddlewares(client.HandleStats, adminMiddlewares...))
+	}
+	
+	// Contadores en formato Prometheus (sin autenticación, como /health: solo datos agregados)
+	http.HandleFunc("/metrics", pkg.HandlePrometheusMetrics)
 	
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 	
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Readiness: no aceptar tráfico si no podemos facturar (MetricsWorker detenido) o si AWS rechaza las
+	// credenciales (comprobadas en segundo plano)
+	stopCredentialCheck := client.StartCredentialCheck(pkg.LoadCredentialCheckConfigWithEnv())
+	http.HandleFunc("/ready", client.HandleReady)
+	
+	// Listener: HTTP/1.1 por defecto (SSE), h2c y keep-alive configurables
+	serverConfig := pkg.LoadServerConfigWithEnv()
+	effectiveConfig.Server = serverConfig
+	client.SetEffectiveConfig(effectiveConfig)
+	server := pkg.NewHTTPServer(serverConfig, http.DefaultServeMux)
+	
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal(err)
 	}
 	
-	// Cerrar recursos al finalizar
-	if db != nil {
-		defer func() {
-			if metricsWorker != nil {
-				metricsWorker.Stop()
-			}
-			if schedulerService != nil {
-				schedulerService.Stop()
+	// Apagado ordenado en SIGTERM (ECS) / SIGINT: drenar requests, esperar el post-processing de
+	// métricas y después parar worker y scheduler y cerrar la BD
+	cleanup := []func(ctx context.Context){
+		func(context.Context) { stopCredentialCheck() },
+		func(ctx context.Context) {
+			if err := client.WaitPostProcessing(ctx); err != nil {
+				fmt.Printf("Warning: metrics post-processing did not finish: %v\n", err)
 			}
-			db.Close()
-		}()
+		},
+	}
+	if metricsWorker != nil {
+		cleanup = append(cleanup, func(context.Context) { metricsWorker.Stop() })
+	}
+	if schedulerService != nil {
+		cleanup = append(cleanup, func(context.Context) { schedulerService.Stop() })
+	}
+	if db != nil {
+		cleanup = append(cleanup, func(context.Context) { db.Close() })
 	}
 	
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	if err := pkg.ServeUntilSignal(server, listener, signals, serverConfig.ShutdownTimeout, cleanup...); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,16 @@
+-- Columnas de usage tracking añadidas tras el esquema inicial.
+-- Aplicar antes (o justo después) de desplegar el proxy; es idempotente.
+-- Hasta que se aplique, el proxy detecta las columnas que faltan y no las escribe.
+-- Los equipos con schema dedicado (METRICS_TEAM_SCHEMAS) necesitan el mismo ALTER
+-- sobre "<schema>"."bedrock-proxy-usage-tracking-tbl".
+
+ALTER TABLE "bedrock-proxy-usage-tracking-tbl"
+    ADD COLUMN IF NOT EXISTS conversation_id    VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS served_model_id    VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS bedrock_latency_ms BIGINT,
+    ADD COLUMN IF NOT EXISTS stream_duration_ms BIGINT,
+    ADD COLUMN IF NOT EXISTS requested_model    VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_usage_tracking_conversation
+    ON "bedrock-proxy-usage-tracking-tbl" (cognito_user_id, conversation_id)
+    WHERE conversation_id IS NOT NULL;
@@ -0,0 +1,6 @@
+-- Batch de /v1/messages/batch al que pertenece cada request (antes se guardaba como conversation_id).
+-- Idempotente; hasta que se aplique, el proxy no escribe la columna.
+-- Los equipos con schema dedicado (METRICS_TEAM_SCHEMAS) necesitan el mismo ALTER.
+
+ALTER TABLE "bedrock-proxy-usage-tracking-tbl"
+    ADD COLUMN IF NOT EXISTS batch_id VARCHAR(255);
@@ -0,0 +1,14 @@
+-- Proyecto al que se imputa cada request (header X-Project-Id o claim project del JWT).
+-- Idempotente; hasta que se aplique, el proxy no escribe la columna.
+-- Los equipos con schema dedicado (METRICS_TEAM_SCHEMAS) necesitan el mismo ALTER.
+
+ALTER TABLE "bedrock-proxy-usage-tracking-tbl"
+    ADD COLUMN IF NOT EXISTS project_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_usage_tracking_project
+    ON "bedrock-proxy-usage-tracking-tbl" (project_id, request_timestamp)
+    WHERE project_id IS NOT NULL;
+
+-- Tabla antigua (InsertMetric, deprecada)
+ALTER TABLE IF EXISTS request_metrics
+    ADD COLUMN IF NOT EXISTS project_id VARCHAR(255);
@@ -0,0 +1,11 @@
+-- ID único de cada request: hace idempotente el INSERT de usage tracking (los reintentos del
+-- worker de métricas tras un timeout o un fallo del batch no duplican registros).
+-- Idempotente; hasta que se aplique, el proxy no escribe la columna ni usa ON CONFLICT.
+-- Los equipos con schema dedicado (METRICS_TEAM_SCHEMAS) necesitan el mismo ALTER e índice.
+-- La tabla está particionada por request_timestamp: el índice único debe incluir la clave de partición.
+
+ALTER TABLE "bedrock-proxy-usage-tracking-tbl"
+    ADD COLUMN IF NOT EXISTS request_id VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_usage_tracking_request_id
+    ON "bedrock-proxy-usage-tracking-tbl" (request_id, request_timestamp);
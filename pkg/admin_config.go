@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// RedactedValue sustituye a los secretos configurados en /admin/config
+const RedactedValue = "[REDACTED]"
+
+// EffectiveConfig reúne la configuración cargada en el arranque que no está en BedrockConfig, para
+// exponerla en /admin/config. Los punteros nil indican que la funcionalidad no está activa
+type EffectiveConfig struct {
+	Server        ServerConfig
+	Database      *DatabaseConnectionConfig // nil = sin BD
+	JWT           *JWTConfig                // nil = sin autenticación
+	Pricing       PricingConfig
+	PureProxy     PureProxyConfig
+	ClientFilter  ClientFilterConfig
+	MissingClaims auth.MissingClaimsConfig
+}
+
+// SetEffectiveConfig guarda la configuración de arranque que devuelve HandleConfig
+func (this *BedrockClient) SetEffectiveConfig(config EffectiveConfig) {
+	this.effectiveConfig = config
+}
+
+// redact devuelve RedactedValue si el secreto está configurado ("" si no, para ver que falta)
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return RedactedValue
+}
+
+// sanitizedConfig construye la configuración efectiva sin secretos: credenciales de AWS, password de
+// BD y clave del JWT. Los mapas grandes (p.ej. el pricing por ARN) se resumen con su tamaño
+func (this *BedrockClient) sanitizedConfig() map[string]interface{} {
+	bedrock := *this.config
+	bedrock.AccessKey = redact(bedrock.AccessKey)
+	bedrock.SecretKey = redact(bedrock.SecretKey)
+	bedrock.MaintenanceMode = this.IsMaintenanceMode()
+
+	effective := this.effectiveConfig
+	result := map[string]interface{}{
+		"bedrock": bedrock,
+		"server": map[string]interface{}{
+			"port":                effective.Server.Port,
+			"http2_enabled":       effective.Server.HTTP2Enabled,
+			"keepalive_enabled":   effective.Server.KeepAlivesEnabled,
+			"idle_timeout_ms":     effective.Server.IdleTimeout.Milliseconds(),
+			"shutdown_timeout_ms": effective.Server.ShutdownTimeout.Milliseconds(),
+		},
+		"pricing": map[string]interface{}{
+			"profile_models_count": len(effective.Pricing.ProfileModels),
+			"default_model":        effective.Pricing.DefaultModel,
+			"input_includes_cache": effective.Pricing.InputIncludesCache,
+		},
+		"features": map[string]interface{}{
+			"auth_enabled":         effective.JWT != nil,
+			"database_enabled":     effective.Database != nil,
+			"pure_proxy_mode":      effective.PureProxy.Enabled,
+			"client_filter":        effective.ClientFilter.Enabled(),
+			"dlp_enabled":          this.dlp != nil,
+			"idempotency_enabled":  this.idempotency != nil,
+			"maintenance_mode":     this.IsMaintenanceMode(),
+			"streaming_disabled":   this.config.StreamingDisabled,
+			"force_prompt_caching": this.config.ForcePromptCaching,
+		},
+	}
+
+	if db := effective.Database; db != nil {
+		result["database"] = map[string]interface{}{
+			"use_secrets_manager": db.UseSecretsManager,
+			"secret_arn":          db.SecretARN,
+			"host":                db.Host,
+			"port":                db.Port,
+			"database":            db.Database,
+			"user":                db.User,
+			"password":            redact(db.Password),
+			"ssl_mode":            db.SSLMode,
+			"max_conns":           db.MaxConns,
+			"min_conns":           db.MinConns,
+			"replica_host":        db.ReplicaHost,
+			"replica_port":        db.ReplicaPort,
+		}
+	}
+	if jwt := effective.JWT; jwt != nil {
+		result["jwt"] = map[string]interface{}{
+			"secret_key":          redact(jwt.SecretKey),
+			"issuer":              jwt.Issuer,
+			"audience":            jwt.Audience,
+			"jwks_url":            jwt.JWKSURL,
+			"jwks_refresh_ms":     jwt.JWKSRefreshInterval.Milliseconds(),
+			"jwks_min_refresh_ms": jwt.JWKSMinRefreshInterval.Milliseconds(),
+		}
+		result["auth"] = map[string]interface{}{
+			"default_team":   effective.MissingClaims.DefaultTeam,
+			"default_person": effective.MissingClaims.DefaultPerson,
+			"require_team":   effective.MissingClaims.RequireTeam,
+		}
+	}
+	return result
+}
+
+// HandleConfig devuelve la configuración efectiva sin secretos (endpoint de administración)
+func (this *BedrockClient) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := ""
+	if user, err := auth.GetUserFromContext(r.Context()); err == nil {
+		userID = user.UserID
+	}
+	Logger.InfoContext(r.Context(), amslog.Event{
+		Name:    EventAdminConfigExport,
+		Message: "Effective configuration exported",
+		Fields: map[string]interface{}{
+			"user.id": userID,
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(this.sanitizedConfig())
+}
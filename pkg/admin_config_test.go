@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleConfigRedactsSecrets(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.SetEffectiveConfig(EffectiveConfig{
+		Database: &DatabaseConnectionConfig{Host: "db.internal", Port: 5432, User: "proxy", Password: "db-password-123"},
+		JWT:      &JWTConfig{SecretKey: "jwt-secret-key-with-at-least-32-characters", Issuer: "identity-manager"},
+		Pricing:  PricingConfig{ProfileModels: map[string]string{"arn:aws:bedrock:eu-west-1:123:application-inference-profile/abc": "claude"}},
+	})
+
+	rec := httptest.NewRecorder()
+	client.HandleConfig(rec, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	body := rec.Body.String()
+	for _, secret := range []string{"test-access-key", "test-secret-key", "db-password-123", "jwt-secret-key-with-at-least-32-characters"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("Expected secret %q to be redacted, got %s", secret, body)
+		}
+	}
+	for _, expected := range []string{`"region":"eu-west-1"`, `"host":"db.internal"`, `"issuer":"identity-manager"`, `"profile_models_count":1`, `"password":"` + RedactedValue + `"`} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %s in effective config, got %s", expected, body)
+		}
+	}
+
+	// El config del cliente no se modifica al sanear la copia
+	if client.config.SecretKey != "test-secret-key" {
+		t.Error("Expected client config to keep its credentials")
+	}
+}
@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"bedrock-proxy-test/pkg/retrystats"
+)
+
+// HandleStats devuelve los contadores operativos del proceso (endpoint de administración): reintentos
+// por categoría, fallos de escritura del logger, estado de las credenciales de AWS y del MetricsWorker y
+// el techo de coste por request (con el formato COST_DISPLAY_*)
+func (this *BedrockClient) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErrorResponse(w, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats := map[string]interface{}{
+		"retries": retrystats.Snapshot(),
+		"logger": map[string]interface{}{
+			"dropped_total":      Logger.DroppedCount(),
+			"output_errors":      Logger.OutputWriteErrors(),
+			"output_failed_over": Logger.OutputFailedOver(),
+		},
+		"credentials": this.CredentialStatus(),
+	}
+	if this.config.MaxRequestCostUSD > 0 {
+		stats["cost_ceiling"] = this.displayCost(this.config.MaxRequestCostUSD, 2)
+	}
+	if this.metricsWorker != nil {
+		workerStats := this.metricsWorker.Stats()
+		stats["metrics_worker"] = map[string]interface{}{
+			"buffer_size":    workerStats.BufferSize,
+			"buffered_count": workerStats.BufferedCount,
+			"dropped_total":  workerStats.DroppedCount,
+			"stopped":        workerStats.IsStopped,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// HandlePrometheusMetrics expone los contadores de reintentos y el histograma de esperas de admisión
+// del batch en formato de texto de Prometheus
+func HandlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	retrystats.Default.WritePrometheus(w)
+	batchQueueWaits.WritePrometheus(w)
+}
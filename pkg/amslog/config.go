@@ -73,14 +73,37 @@ type Config struct {
 	// EnableSanitization activa la sanitización de datos sensibles
 	EnableSanitization bool
 
+	// SanitizeMaxDepth es la profundidad máxima de Fields que se recorre (0 = DefaultSanitizeMaxDepth)
+	SanitizeMaxDepth int
+
+	// SanitizeMaxFields es el número máximo de valores de Fields procesados por evento (0 = DefaultSanitizeMaxFields)
+	SanitizeMaxFields int
+
 	// Output es el destino de los logs (por defecto os.Stdout)
 	Output io.Writer
 
+	// OnOutputFailure indica qué hacer cuando Output falla de forma persistente (por defecto fallback)
+	OnOutputFailure OutputFailureMode
+
+	// FallbackOutput recibe los logs tras los fallos de Output (por defecto os.Stderr)
+	FallbackOutput io.Writer
+
+	// MaxOutputFailures es el número de fallos seguidos de Output tras el que se deja de usar
+	// (0 = DefaultMaxOutputFailures)
+	MaxOutputFailures int
+
 	// Async activa el modo asíncrono
 	Async bool
 
 	// BufferSize es el tamaño del buffer para modo asíncrono
 	BufferSize int
+
+	// SampleRate emite 1 de cada N eventos de SampledEvents (<= 1 desactiva el muestreo)
+	SampleRate int
+
+	// SampledEvents son los nombres de eventos de alto volumen sujetos a muestreo
+	// Los eventos WARN/ERROR/FATAL o con Error nunca se muestrean
+	SampledEvents []string
 }
 
 // Validate valida la configuración
@@ -115,6 +138,16 @@ func (c *Config) SetDefaults() {
 		c.Output = os.Stdout
 	}
 
+	if c.OnOutputFailure == "" {
+		c.OnOutputFailure = OutputFailureFallback
+	}
+	if c.FallbackOutput == nil {
+		c.FallbackOutput = os.Stderr
+	}
+	if c.MaxOutputFailures <= 0 {
+		c.MaxOutputFailures = DefaultMaxOutputFailures
+	}
+
 	if c.BufferSize == 0 {
 		c.BufferSize = 1000
 	}
@@ -18,6 +18,8 @@ type Logger struct {
 	wg         sync.WaitGroup
 	closed     bool
 	closeMutex sync.Mutex
+	sampler    *sampler
+	output     *outputWriter
 }
 
 // NewLogger crea un nuevo logger con la configuración proporcionada
@@ -28,11 +30,13 @@ func NewLogger(config Config) *Logger {
 	}
 
 	logger := &Logger{
-		config: config,
+		config:  config,
+		sampler: newSampler(config.SampleRate, config.SampledEvents),
+		output:  newOutputWriter(config),
 	}
 
 	if config.EnableSanitization {
-		logger.sanitizer = NewSanitizer()
+		logger.sanitizer = NewSanitizerWithLimits(config.SanitizeMaxDepth, config.SanitizeMaxFields)
 	}
 
 	// Modo asíncrono
@@ -133,6 +137,11 @@ func (l *Logger) log(ctx context.Context, level LogLevel, event Event) {
 		return
 	}
 
+	// Contabilizar siempre el evento, aunque el muestreo lo descarte
+	if !l.sampler.record(level, event) {
+		return
+	}
+
 	// Crear entrada de log
 	entry := l.createLogEntry(ctx, level, event)
 
@@ -267,6 +276,6 @@ func (l *Logger) writeLog(entry *LogEntry) {
 		return
 	}
 
-	// Escribir al output
-	fmt.Fprintln(l.config.Output, string(jsonData))
+	// Escribir al output (con fallback si falla de forma persistente)
+	l.output.writeLine(jsonData)
 }
\ No newline at end of file
@@ -137,6 +137,45 @@ func TestSanitization(t *testing.T) {
 	}
 }
 
+func TestSanitizerDepthAndFieldLimits(t *testing.T) {
+	s := NewSanitizerWithLimits(3, 50)
+
+	// Estructura anidada 100 niveles: lo que está por debajo del nivel 3 se sustituye por el marcador
+	var nested interface{} = map[string]interface{}{"password": "secret123"}
+	for i := 0; i < 100; i++ {
+		nested = map[string]interface{}{"child": nested}
+	}
+	result := s.Sanitize(map[string]interface{}{"body": nested})
+
+	level2, ok := result["body"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected level 2 to be sanitized, got %v", result["body"])
+	}
+	level3, ok := level2["child"].(map[string]interface{})
+	if !ok || level3["child"] != TruncatedDepthMarker {
+		t.Errorf("Expected depth cap marker at level 4, got %v", level2["child"])
+	}
+
+	// Más valores que maxFields: se descarta el resto con el marcador
+	items := make([]interface{}, 200)
+	for i := range items {
+		items[i] = "user@example.com"
+	}
+	result = s.Sanitize(map[string]interface{}{"items": items})
+	truncated := result["items"].([]interface{})
+	if len(truncated) != 50 || truncated[len(truncated)-1] != TruncatedFieldsMarker {
+		t.Errorf("Expected 49 items plus marker, got %d items ending in %v", len(truncated), truncated[len(truncated)-1])
+	}
+	if truncated[0] != "u***@example.com" {
+		t.Errorf("Expected processed items to be sanitized, got %v", truncated[0])
+	}
+
+	// Límites <= 0 usan los valores por defecto
+	if d := NewSanitizerWithLimits(0, -1); d.maxDepth != DefaultSanitizeMaxDepth || d.maxFields != DefaultSanitizeMaxFields {
+		t.Errorf("Expected default limits, got depth=%d fields=%d", d.maxDepth, d.maxFields)
+	}
+}
+
 func TestLogLevels(t *testing.T) {
 	var buf bytes.Buffer
 	config := Config{
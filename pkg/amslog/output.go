@@ -0,0 +1,123 @@
+package amslog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OutputFailureMode define qué se hace con los logs cuando Output falla de forma persistente
+type OutputFailureMode string
+
+const (
+	// OutputFailureFallback escribe en FallbackOutput (por defecto os.Stderr)
+	OutputFailureFallback OutputFailureMode = "fallback"
+	// OutputFailureDrop descarta los logs y solo los contabiliza
+	OutputFailureDrop OutputFailureMode = "drop"
+)
+
+// Valores por defecto de la detección de fallos de escritura
+const (
+	DefaultMaxOutputFailures = 5
+	outputRetryBackoffMin    = 100 * time.Millisecond
+	outputRetryBackoffMax    = 30 * time.Second
+)
+
+// outputWriter escribe en Output y detecta fallos persistentes (p.ej. el pipe de stdout roto en un
+// contenedor). Tras un fallo no reintenta Output hasta que vence un backoff exponencial, y tras
+// maxFailures fallos seguidos deja de usarlo: los logs van al fallback o se descartan contándolos
+type outputWriter struct {
+	primary     io.Writer
+	fallback    io.Writer // nil = descartar
+	maxFailures int
+	now         func() time.Time
+
+	mu          sync.Mutex
+	failures    int
+	backoff     time.Duration
+	retryAt     time.Time
+	failedOver  bool
+	dropped     atomic.Int64
+	writeErrors atomic.Int64
+}
+
+func newOutputWriter(config Config) *outputWriter {
+	w := &outputWriter{
+		primary:     config.Output,
+		maxFailures: config.MaxOutputFailures,
+		now:         time.Now,
+	}
+	if config.OnOutputFailure != OutputFailureDrop {
+		w.fallback = config.FallbackOutput
+	}
+	return w
+}
+
+// writeLine escribe una línea de log aplicando la detección de fallos
+func (w *outputWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failedOver || w.now().Before(w.retryAt) {
+		w.writeFallback(line)
+		return
+	}
+
+	_, err := fmt.Fprintln(w.primary, string(line))
+	if err == nil {
+		w.failures = 0
+		w.backoff = 0
+		return
+	}
+
+	w.writeErrors.Add(1)
+	w.failures++
+	if w.failures >= w.maxFailures {
+		w.failedOver = true
+		w.notice(fmt.Sprintf("log output failed %d times in a row (%v), switching to fallback", w.failures, err))
+	} else {
+		w.backoff = min(max(2*w.backoff, outputRetryBackoffMin), outputRetryBackoffMax)
+		w.retryAt = w.now().Add(w.backoff)
+	}
+	w.writeFallback(line)
+}
+
+// writeFallback escribe en el fallback o descarta la línea si no hay o también falla
+func (w *outputWriter) writeFallback(line []byte) {
+	if w.fallback == nil {
+		w.dropped.Add(1)
+		return
+	}
+	if _, err := fmt.Fprintln(w.fallback, string(line)); err != nil {
+		w.dropped.Add(1)
+	}
+}
+
+// notice avisa una única vez del cambio de salida (en el fallback o, si se descarta, en stderr)
+func (w *outputWriter) notice(message string) {
+	target := w.fallback
+	if target == nil {
+		target = os.Stderr
+	}
+	fmt.Fprintf(target, "amslog: %s\n", message)
+}
+
+// DroppedCount devuelve cuántos logs se han descartado por fallos de escritura
+func (l *Logger) DroppedCount() int64 {
+	return l.output.dropped.Load()
+}
+
+// OutputWriteErrors devuelve cuántas escrituras en Output han fallado
+func (l *Logger) OutputWriteErrors() int64 {
+	return l.output.writeErrors.Load()
+}
+
+// OutputFailedOver indica si el logger dejó de usar Output por fallos persistentes
+func (l *Logger) OutputFailedOver() bool {
+	l.output.mu.Lock()
+	defer l.output.mu.Unlock()
+	return l.output.failedOver
+}
@@ -0,0 +1,98 @@
+package amslog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingWriter simula un stdout con el pipe roto: todas las escrituras fallan
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("write /dev/stdout: broken pipe")
+}
+
+func newOutputTestLogger(fallback *bytes.Buffer, broken *failingWriter, mode OutputFailureMode) *Logger {
+	config := Config{
+		ServiceName:       "bedrock-proxy",
+		ServiceVersion:    "1.0.0",
+		Environment:       "pro",
+		Output:            broken,
+		OnOutputFailure:   mode,
+		MaxOutputFailures: 3,
+	}
+	if fallback != nil {
+		config.FallbackOutput = fallback
+	}
+	return NewLogger(config)
+}
+
+func TestBrokenOutputFailsOverToFallback(t *testing.T) {
+	var fallback bytes.Buffer
+	broken := &failingWriter{}
+	logger := newOutputTestLogger(&fallback, broken, OutputFailureFallback)
+	defer logger.Close()
+
+	// Simular el paso del tiempo para que venzan los backoffs entre reintentos
+	now := time.Now()
+	logger.output.now = func() time.Time { return now }
+	for i := 0; i < 100; i++ {
+		logger.Info(Event{Name: "TEST_EVENT", Message: "hola"})
+		now = now.Add(time.Minute)
+	}
+
+	if broken.writes != 3 {
+		t.Errorf("Expected output to be abandoned after 3 failures, got %d writes", broken.writes)
+	}
+	if !logger.OutputFailedOver() {
+		t.Error("Expected logger to report the failover")
+	}
+	if got := strings.Count(fallback.String(), "TEST_EVENT"); got != 100 {
+		t.Errorf("Expected all 100 logs in the fallback, got %d", got)
+	}
+	if logger.DroppedCount() != 0 {
+		t.Errorf("Expected no drops with a working fallback, got %d", logger.DroppedCount())
+	}
+}
+
+func TestBrokenOutputBacksOffBetweenRetries(t *testing.T) {
+	var fallback bytes.Buffer
+	broken := &failingWriter{}
+	logger := newOutputTestLogger(&fallback, broken, OutputFailureFallback)
+	defer logger.Close()
+
+	// Sin que pase el tiempo, tras el primer fallo no se vuelve a intentar Output (sin bucle de fallos)
+	now := time.Now()
+	logger.output.now = func() time.Time { return now }
+	for i := 0; i < 1000; i++ {
+		logger.Info(Event{Name: "TEST_EVENT", Message: "hola"})
+	}
+	if broken.writes != 1 {
+		t.Errorf("Expected a single write attempt during the backoff, got %d", broken.writes)
+	}
+	if logger.OutputFailedOver() {
+		t.Error("Expected no failover before reaching the failure threshold")
+	}
+}
+
+func TestBrokenOutputDropModeCountsDrops(t *testing.T) {
+	broken := &failingWriter{}
+	logger := newOutputTestLogger(nil, broken, OutputFailureDrop)
+	defer logger.Close()
+
+	for i := 0; i < 10; i++ {
+		logger.Info(Event{Name: "TEST_EVENT", Message: "hola"})
+	}
+	if got := logger.DroppedCount(); got != 10 {
+		t.Errorf("Expected 10 dropped logs, got %d", got)
+	}
+	if got := logger.OutputWriteErrors(); got != 1 {
+		t.Errorf("Expected 1 output error (then backoff), got %d", got)
+	}
+}
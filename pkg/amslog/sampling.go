@@ -0,0 +1,65 @@
+package amslog
+
+import "sync"
+
+// sampler implementa el muestreo 1-de-N de eventos de alto volumen
+// y mantiene contadores por evento independientes del muestreo
+type sampler struct {
+	rate    uint64
+	events  map[string]bool
+	mu      sync.Mutex
+	counts  map[string]uint64
+	dropped map[string]uint64
+}
+
+// newSampler crea un sampler; con rate <= 1 solo cuenta eventos
+func newSampler(rate int, events []string) *sampler {
+	s := &sampler{
+		rate:    1,
+		events:  make(map[string]bool, len(events)),
+		counts:  make(map[string]uint64),
+		dropped: make(map[string]uint64),
+	}
+	if rate > 1 {
+		s.rate = uint64(rate)
+	}
+	for _, name := range events {
+		s.events[name] = true
+	}
+	return s
+}
+
+// record contabiliza el evento y devuelve si debe emitirse
+func (s *sampler) record(level LogLevel, event Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[event.Name]++
+
+	// Los errores y warnings nunca se muestrean
+	if s.rate <= 1 || level >= LevelWarn || event.Error != nil || !s.events[event.Name] {
+		return true
+	}
+
+	// Emitir el primero de cada bloque de N
+	if (s.counts[event.Name]-1)%s.rate == 0 {
+		return true
+	}
+
+	s.dropped[event.Name]++
+	return false
+}
+
+// EventCount retorna cuántas veces se ha registrado un evento (incluidos los descartados por muestreo)
+func (l *Logger) EventCount(name string) uint64 {
+	l.sampler.mu.Lock()
+	defer l.sampler.mu.Unlock()
+	return l.sampler.counts[name]
+}
+
+// SampledOutCount retorna cuántas veces se ha descartado un evento por muestreo
+func (l *Logger) SampledOutCount(name string) uint64 {
+	l.sampler.mu.Lock()
+	defer l.sampler.mu.Unlock()
+	return l.sampler.dropped[name]
+}
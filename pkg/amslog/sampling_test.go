@@ -0,0 +1,77 @@
+package amslog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func newSampledTestLogger(buf *bytes.Buffer, rate int) *Logger {
+	return NewLogger(Config{
+		ServiceName:    "bedrock-proxy",
+		ServiceVersion: "1.0.0",
+		Environment:    "pro",
+		Output:         buf,
+		SampleRate:     rate,
+		SampledEvents:  []string{"PROXY_REQUEST_START"},
+	})
+}
+
+func TestSamplingEmitsOneInN(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newSampledTestLogger(&buf, 10)
+	defer logger.Close()
+
+	for i := 0; i < 1000; i++ {
+		logger.Info(Event{Name: "PROXY_REQUEST_START", Message: "start"})
+	}
+
+	emitted := strings.Count(buf.String(), "PROXY_REQUEST_START")
+	if emitted < 90 || emitted > 110 {
+		t.Errorf("Expected roughly 100 emitted events, got %d", emitted)
+	}
+
+	// Los contadores reflejan todos los eventos, no solo los emitidos
+	if got := logger.EventCount("PROXY_REQUEST_START"); got != 1000 {
+		t.Errorf("Expected counter 1000, got %d", got)
+	}
+	if got := logger.SampledOutCount("PROXY_REQUEST_START"); got != uint64(1000-emitted) {
+		t.Errorf("Expected %d sampled out, got %d", 1000-emitted, got)
+	}
+}
+
+func TestSamplingNeverDropsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newSampledTestLogger(&buf, 10)
+	defer logger.Close()
+
+	for i := 0; i < 100; i++ {
+		logger.Error(Event{Name: "PROXY_REQUEST_START", Message: "failed"})
+		logger.Info(Event{
+			Name:    "PROXY_REQUEST_START",
+			Message: "failed with info level",
+			Error:   &ErrorInfo{Type: "TestError", Message: "boom"},
+		})
+	}
+
+	if emitted := strings.Count(buf.String(), "PROXY_REQUEST_START"); emitted != 200 {
+		t.Errorf("Expected all 200 error events to be emitted, got %d", emitted)
+	}
+	if got := logger.SampledOutCount("PROXY_REQUEST_START"); got != 0 {
+		t.Errorf("Expected no sampled out errors, got %d", got)
+	}
+}
+
+func TestSamplingIgnoresUnlistedEvents(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newSampledTestLogger(&buf, 10)
+	defer logger.Close()
+
+	for i := 0; i < 50; i++ {
+		logger.Info(Event{Name: "OTHER_EVENT", Message: "not sampled"})
+	}
+
+	if emitted := strings.Count(buf.String(), "OTHER_EVENT"); emitted != 50 {
+		t.Errorf("Expected all 50 unlisted events to be emitted, got %d", emitted)
+	}
+}
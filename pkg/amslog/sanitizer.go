@@ -5,15 +5,41 @@ import (
 	"strings"
 )
 
+// Límites por defecto del sanitizador para estructuras anidadas grandes
+const (
+	DefaultSanitizeMaxDepth  = 10
+	DefaultSanitizeMaxFields = 1000
+)
+
+// Marcadores que sustituyen a los valores no procesados al alcanzar los límites
+const (
+	TruncatedDepthMarker  = "***MAX_DEPTH***"
+	TruncatedFieldsMarker = "***MAX_FIELDS***"
+	TruncatedFieldsKey    = "_truncated"
+)
+
 // Sanitizer sanitiza datos sensibles en los logs
 type Sanitizer struct {
 	sensitiveKeys map[string]bool
 	emailRegex    *regexp.Regexp
 	dniRegex      *regexp.Regexp
+	maxDepth      int // Niveles de anidamiento procesados; los más profundos se sustituyen por un marcador
+	maxFields     int // Valores procesados por llamada a Sanitize; el resto se descarta con un marcador
 }
 
-// NewSanitizer crea un nuevo sanitizador
+// NewSanitizer crea un nuevo sanitizador con los límites por defecto
 func NewSanitizer() *Sanitizer {
+	return NewSanitizerWithLimits(DefaultSanitizeMaxDepth, DefaultSanitizeMaxFields)
+}
+
+// NewSanitizerWithLimits crea un sanitizador con profundidad y número de campos máximos (<= 0 usa el default)
+func NewSanitizerWithLimits(maxDepth, maxFields int) *Sanitizer {
+	if maxDepth <= 0 {
+		maxDepth = DefaultSanitizeMaxDepth
+	}
+	if maxFields <= 0 {
+		maxFields = DefaultSanitizeMaxFields
+	}
 	return &Sanitizer{
 		sensitiveKeys: map[string]bool{
 			"password":       true,
@@ -31,38 +57,73 @@ func NewSanitizer() *Sanitizer {
 		},
 		emailRegex: regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`),
 		dniRegex:   regexp.MustCompile(`\d{8}[A-Z]`),
+		maxDepth:   maxDepth,
+		maxFields:  maxFields,
 	}
 }
 
-// Sanitize sanitiza un mapa de datos
+// sanitizeState cuenta los valores procesados en una llamada a Sanitize
+type sanitizeState struct {
+	fields int
+}
+
+// take reserva un valor; devuelve false si ya se alcanzó el máximo de campos
+func (st *sanitizeState) take(maxFields int) bool {
+	if st.fields >= maxFields {
+		return false
+	}
+	st.fields++
+	return true
+}
+
+// Sanitize sanitiza un mapa de datos. Las estructuras más profundas que maxDepth se sustituyen por
+// TruncatedDepthMarker y, superados maxFields valores, el resto se descarta con TruncatedFieldsMarker
 func (s *Sanitizer) Sanitize(data map[string]interface{}) map[string]interface{} {
 	if data == nil {
 		return nil
 	}
+	return s.sanitizeMap(data, 1, &sanitizeState{})
+}
 
+// sanitizeMap sanitiza un mapa situado en el nivel depth
+func (s *Sanitizer) sanitizeMap(data map[string]interface{}, depth int, st *sanitizeState) map[string]interface{} {
 	result := make(map[string]interface{})
 	for key, value := range data {
-		result[key] = s.sanitizeValue(key, value)
+		if !st.take(s.maxFields) {
+			result[TruncatedFieldsKey] = TruncatedFieldsMarker
+			break
+		}
+		result[key] = s.sanitizeValue(key, value, depth, st)
 	}
 	return result
 }
 
 // sanitizeValue sanitiza un valor según su clave y tipo
-func (s *Sanitizer) sanitizeValue(key string, value interface{}) interface{} {
+func (s *Sanitizer) sanitizeValue(key string, value interface{}, depth int, st *sanitizeState) interface{} {
 	// Verificar si la clave es sensible
 	lowerKey := strings.ToLower(key)
 	if s.sensitiveKeys[lowerKey] {
 		return "***REDACTED***"
 	}
 
-	// Sanitizar según el tipo
+	return s.sanitizeItem(value, depth, st)
+}
+
+// sanitizeItem sanitiza un valor según su tipo; los mapas y slices anidados cuentan un nivel más
+func (s *Sanitizer) sanitizeItem(value interface{}, depth int, st *sanitizeState) interface{} {
 	switch v := value.(type) {
 	case string:
 		return s.sanitizeString(v)
 	case map[string]interface{}:
-		return s.Sanitize(v)
+		if depth >= s.maxDepth {
+			return TruncatedDepthMarker
+		}
+		return s.sanitizeMap(v, depth+1, st)
 	case []interface{}:
-		return s.sanitizeSlice(v)
+		if depth >= s.maxDepth {
+			return TruncatedDepthMarker
+		}
+		return s.sanitizeSlice(v, depth+1, st)
 	default:
 		return value
 	}
@@ -83,20 +144,15 @@ func (s *Sanitizer) sanitizeString(value string) string {
 	return value
 }
 
-// sanitizeSlice sanitiza un slice
-func (s *Sanitizer) sanitizeSlice(slice []interface{}) []interface{} {
-	result := make([]interface{}, len(slice))
-	for i, item := range slice {
-		switch v := item.(type) {
-		case string:
-			result[i] = s.sanitizeString(v)
-		case map[string]interface{}:
-			result[i] = s.Sanitize(v)
-		case []interface{}:
-			result[i] = s.sanitizeSlice(v)
-		default:
-			result[i] = item
+// sanitizeSlice sanitiza un slice situado en el nivel depth
+func (s *Sanitizer) sanitizeSlice(slice []interface{}, depth int, st *sanitizeState) []interface{} {
+	result := make([]interface{}, 0, len(slice))
+	for _, item := range slice {
+		if !st.take(s.maxFields) {
+			result = append(result, TruncatedFieldsMarker)
+			break
 		}
+		result = append(result, s.sanitizeItem(item, depth, st))
 	}
 	return result
 }
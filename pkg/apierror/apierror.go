@@ -0,0 +1,130 @@
+// Package apierror define el formato de error de la API de Anthropic que devuelven todas las capas
+// del proxy (autenticación, cuotas y proxy) y el tipo de error asociado a cada status HTTP
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Tipos de error de la API de Anthropic
+const (
+	TypeInvalidRequest  = "invalid_request_error"
+	TypeAuthentication  = "authentication_error"
+	TypeBilling         = "billing_error"
+	TypePermission      = "permission_error"
+	TypeNotFound        = "not_found_error"
+	TypeRequestTooLarge = "request_too_large"
+	TypeRateLimit       = "rate_limit_error"
+	TypeAPI             = "api_error"
+	TypeOverloaded      = "overloaded_error"
+)
+
+// validTypes son los tipos que se aceptan en la configuración
+var validTypes = map[string]bool{
+	TypeInvalidRequest:  true,
+	TypeAuthentication:  true,
+	TypeBilling:         true,
+	TypePermission:      true,
+	TypeNotFound:        true,
+	TypeRequestTooLarge: true,
+	TypeRateLimit:       true,
+	TypeAPI:             true,
+	TypeOverloaded:      true,
+}
+
+// defaultStatusTypes es el tipo que usa la API de Anthropic para cada status
+var defaultStatusTypes = map[int]string{
+	http.StatusBadRequest:            TypeInvalidRequest,
+	http.StatusUnauthorized:          TypeAuthentication,
+	http.StatusPaymentRequired:       TypeBilling,
+	http.StatusForbidden:             TypePermission,
+	http.StatusNotFound:              TypeNotFound,
+	http.StatusRequestEntityTooLarge: TypeRequestTooLarge,
+	http.StatusTooManyRequests:       TypeRateLimit,
+	http.StatusInternalServerError:   TypeAPI,
+	http.StatusServiceUnavailable:    TypeOverloaded,
+	529:                              TypeOverloaded,
+}
+
+var (
+	overridesMu sync.RWMutex
+	overrides   map[int]string
+)
+
+// SetStatusTypes fija el tipo de error de los status configurados (ERROR_TYPE_BY_STATUS). Tienen
+// prioridad sobre el tipo por defecto y sobre el que asigna cada capa a sus códigos internos
+func SetStatusTypes(types map[int]string) {
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+	overrides = types
+}
+
+// ParseStatusTypes convierte pares "status=tipo"; se ignoran los status no numéricos y los tipos desconocidos
+func ParseStatusTypes(mappings map[string]string) map[int]string {
+	types := map[int]string{}
+	for key, errorType := range mappings {
+		status, err := strconv.Atoi(key)
+		if err != nil || status < 400 || status > 599 || !validTypes[errorType] {
+			continue
+		}
+		types[status] = errorType
+	}
+	return types
+}
+
+func override(status int) (string, bool) {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	errorType, ok := overrides[status]
+	return errorType, ok
+}
+
+// TypeForStatus devuelve el tipo de error de un status: el configurado, el de Anthropic o, para
+// status sin tipo propio, invalid_request_error (4xx) o api_error (5xx)
+func TypeForStatus(status int) string {
+	return ResolveType(status, "")
+}
+
+// ResolveType devuelve el tipo de un error con status y tipo propuesto por la capa que lo genera
+// ("" = según el status). Un tipo configurado para el status tiene prioridad
+func ResolveType(status int, errorType string) string {
+	if configured, ok := override(status); ok {
+		return configured
+	}
+	if errorType != "" {
+		return errorType
+	}
+	if errorType, ok := defaultStatusTypes[status]; ok {
+		return errorType
+	}
+	if status >= 400 && status < 500 {
+		return TypeInvalidRequest
+	}
+	return TypeAPI
+}
+
+// Write responde con el error en formato Anthropic:
+// {"type":"error","error":{"type":"...","code":"...","message":"...", ...extra}}
+// errorType vacío se deduce del status; code es el código interno estable (se omite si está vacío)
+func Write(w http.ResponseWriter, status int, errorType, code, message string, extra map[string]interface{}) {
+	errorBody := make(map[string]interface{}, len(extra)+3)
+	for key, value := range extra {
+		errorBody[key] = value
+	}
+	errorBody["type"] = ResolveType(status, errorType)
+	errorBody["message"] = message
+	if code != "" {
+		errorBody["code"] = code
+	}
+
+	errorJSON, _ := json.Marshal(map[string]interface{}{
+		"type":  "error",
+		"error": errorBody,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(errorJSON)
+}
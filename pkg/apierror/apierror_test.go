@@ -0,0 +1,72 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTypeForStatusDefaults(t *testing.T) {
+	cases := map[int]string{
+		http.StatusBadRequest:          TypeInvalidRequest,
+		http.StatusUnauthorized:        TypeAuthentication,
+		http.StatusForbidden:           TypePermission,
+		http.StatusTooManyRequests:     TypeRateLimit,
+		http.StatusInternalServerError: TypeAPI,
+		http.StatusServiceUnavailable:  TypeOverloaded,
+		http.StatusMethodNotAllowed:    TypeInvalidRequest, // 4xx sin tipo propio
+		http.StatusBadGateway:          TypeAPI,            // 5xx sin tipo propio
+	}
+	for status, expected := range cases {
+		if got := TypeForStatus(status); got != expected {
+			t.Errorf("Status %d: expected %s, got %s", status, expected, got)
+		}
+	}
+
+	// El tipo de la capa que genera el error prevalece sobre el del status
+	if got := ResolveType(http.StatusUnauthorized, TypeRateLimit); got != TypeRateLimit {
+		t.Errorf("Expected proposed type to be kept, got %s", got)
+	}
+}
+
+func TestStatusTypesOverride(t *testing.T) {
+	SetStatusTypes(ParseStatusTypes(map[string]string{
+		"503": TypeAPI,
+		"401": TypeRateLimit,
+		"abc": TypeAPI,       // status no numérico
+		"200": TypeAPI,       // no es un error
+		"429": "quota_error", // tipo desconocido
+	}))
+	defer SetStatusTypes(nil)
+
+	if got := TypeForStatus(http.StatusServiceUnavailable); got != TypeAPI {
+		t.Errorf("Expected configured api_error for 503, got %s", got)
+	}
+	if got := ResolveType(http.StatusUnauthorized, TypeAuthentication); got != TypeRateLimit {
+		t.Errorf("Expected configured type to win over proposed type, got %s", got)
+	}
+	if got := TypeForStatus(http.StatusTooManyRequests); got != TypeRateLimit {
+		t.Errorf("Expected invalid override to be ignored, got %s", got)
+	}
+}
+
+func TestWriteShape(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, http.StatusForbidden, "", "user_blocked", "user is blocked", map[string]interface{}{"retry_after": "60"})
+
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected status or content type: %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Type  string                 `json:"type"`
+		Error map[string]interface{} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON body: %v", err)
+	}
+	if body.Type != "error" || body.Error["type"] != TypePermission || body.Error["code"] != "user_blocked" ||
+		body.Error["message"] != "user is blocked" || body.Error["retry_after"] != "60" {
+		t.Errorf("Unexpected error body %s", rec.Body.String())
+	}
+}
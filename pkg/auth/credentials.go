@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// DuplicateCredentialsMode define qué hacer cuando llegan Authorization y x-api-key a la vez con tokens distintos
+type DuplicateCredentialsMode string
+
+const (
+	// DuplicateCredentialsWarn usa el token de Authorization y registra un warning (por defecto)
+	DuplicateCredentialsWarn DuplicateCredentialsMode = "warn"
+	// DuplicateCredentialsReject rechaza la request con 401
+	DuplicateCredentialsReject DuplicateCredentialsMode = "reject"
+)
+
+// ParseDuplicateCredentialsMode interpreta el modo; un valor desconocido se trata como warn
+func ParseDuplicateCredentialsMode(value string) DuplicateCredentialsMode {
+	if DuplicateCredentialsMode(strings.ToLower(strings.TrimSpace(value))) == DuplicateCredentialsReject {
+		return DuplicateCredentialsReject
+	}
+	return DuplicateCredentialsWarn
+}
+
+// SetDuplicateCredentialsMode configura el comportamiento ante credenciales duplicadas que no coinciden
+func (am *AuthMiddleware) SetDuplicateCredentialsMode(mode DuplicateCredentialsMode) {
+	am.duplicateCredentials = mode
+}
+
+// credentialsError es un error de extracción de credenciales (mensaje y tipo para respondError)
+type credentialsError struct {
+	message   string
+	errorType string
+}
+
+// extractToken obtiene el token de la request. Authorization (Bearer) tiene prioridad sobre x-api-key
+// (formato usado por Cline). Si llegan ambos y no coinciden se rechaza o se avisa según el modo configurado
+func (am *AuthMiddleware) extractToken(r *http.Request) (string, *credentialsError) {
+	authHeader := r.Header.Get("Authorization")
+	apiKey := r.Header.Get("x-api-key")
+
+	if authHeader == "" {
+		if apiKey == "" {
+			return "", &credentialsError{"missing authorization header or x-api-key", "missing_auth"}
+		}
+		return apiKey, nil
+	}
+
+	tokenString, err := ExtractBearerToken(authHeader)
+	if err != nil {
+		return "", &credentialsError{"invalid authorization header: " + err.Error(), "invalid_header"}
+	}
+
+	if apiKey != "" && apiKey != tokenString {
+		if am.duplicateCredentials == DuplicateCredentialsReject {
+			return "", &credentialsError{"authorization header and x-api-key contain different tokens", "conflicting_credentials"}
+		}
+		if Logger != nil {
+			Logger.WarningContext(r.Context(), amslog.Event{
+				Name:    "AUTH_DUPLICATE_CREDENTIALS",
+				Message: "Authorization and x-api-key contain different tokens, using Authorization",
+				Fields: map[string]interface{}{
+					"client.ip":         getClientIP(r),
+					"http.request.path": r.URL.Path,
+				},
+			})
+		}
+	}
+
+	return tokenString, nil
+}
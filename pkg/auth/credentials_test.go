@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCredentialsRequest(authorization, apiKey string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if apiKey != "" {
+		req.Header.Set("x-api-key", apiKey)
+	}
+	return req
+}
+
+func TestExtractTokenBothHeadersAgree(t *testing.T) {
+	for _, mode := range []DuplicateCredentialsMode{DuplicateCredentialsWarn, DuplicateCredentialsReject} {
+		am := &AuthMiddleware{duplicateCredentials: mode}
+		token, credErr := am.extractToken(newCredentialsRequest("Bearer token-a", "token-a"))
+		if credErr != nil || token != "token-a" {
+			t.Errorf("%s: expected token-a, got %q (%v)", mode, token, credErr)
+		}
+	}
+}
+
+func TestExtractTokenBothHeadersDisagree(t *testing.T) {
+	am := &AuthMiddleware{duplicateCredentials: DuplicateCredentialsWarn}
+	token, credErr := am.extractToken(newCredentialsRequest("Bearer token-a", "token-b"))
+	if credErr != nil || token != "token-a" {
+		t.Errorf("warn: expected Authorization token, got %q (%v)", token, credErr)
+	}
+
+	am = &AuthMiddleware{duplicateCredentials: DuplicateCredentialsReject}
+	token, credErr = am.extractToken(newCredentialsRequest("Bearer token-a", "token-b"))
+	if credErr == nil || credErr.errorType != "conflicting_credentials" || token != "" {
+		t.Errorf("reject: expected conflicting_credentials, got %q (%v)", token, credErr)
+	}
+}
+
+func TestMiddlewareRateLimitsTokenActuallyUsed(t *testing.T) {
+	am := &AuthMiddleware{rateLimiter: NewRateLimiter(), duplicateCredentials: DuplicateCredentialsWarn}
+	handler := am.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected request with invalid token to be rejected")
+	}))
+
+	// Tokens sin formato JWT: el fallo de decodificación se registra contra el token usado
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newCredentialsRequest("Bearer token-a", "token-b"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", rec.Code)
+	}
+	if _, ok := am.rateLimiter.tokenAttempts[HashToken("token-a")]; !ok {
+		t.Error("Expected failed attempt recorded against the Authorization token")
+	}
+	if _, ok := am.rateLimiter.tokenAttempts[HashToken("token-b")]; ok {
+		t.Error("Expected no failed attempt recorded against the ignored x-api-key token")
+	}
+
+	// En modo reject no se usa ningún token: solo cuenta el intento de la IP
+	am.duplicateCredentials = DuplicateCredentialsReject
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newCredentialsRequest("Bearer token-c", "token-d"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for conflicting credentials, got %d", rec.Code)
+	}
+	if len(am.rateLimiter.tokenAttempts) != 1 {
+		t.Errorf("Expected no token attempts for rejected conflicting credentials, got %d", len(am.rateLimiter.tokenAttempts))
+	}
+}
+
+func TestParseDuplicateCredentialsMode(t *testing.T) {
+	cases := map[string]DuplicateCredentialsMode{
+		"":        DuplicateCredentialsWarn,
+		"warn":    DuplicateCredentialsWarn,
+		"REJECT ": DuplicateCredentialsReject,
+		"other":   DuplicateCredentialsWarn,
+	}
+	for value, expected := range cases {
+		if got := ParseDuplicateCredentialsMode(value); got != expected {
+			t.Errorf("%q: expected %s, got %s", value, expected, got)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// DefaultUnassignedTeam es el team (y person) que se asigna si el claim del JWT llega vacío
+const DefaultUnassignedTeam = "unassigned"
+
+// MissingClaimsConfig define qué hacer con tokens sin claims team/person. Ambos alimentan las
+// métricas (columnas team y person) y las funcionalidades por equipo
+type MissingClaimsConfig struct {
+	DefaultTeam   string // Team asignado si el claim está vacío ("" = se mantiene vacío)
+	DefaultPerson string // Person asignado si el claim está vacío ("" = se mantiene vacío)
+	RequireTeam   bool   // Modo estricto: rechaza con 403 los tokens sin team
+}
+
+// DefaultMissingClaimsConfig agrupa el uso sin team/person bajo "unassigned" sin rechazar requests
+func DefaultMissingClaimsConfig() MissingClaimsConfig {
+	return MissingClaimsConfig{
+		DefaultTeam:   DefaultUnassignedTeam,
+		DefaultPerson: DefaultUnassignedTeam,
+	}
+}
+
+// SetMissingClaimsConfig configura el tratamiento de los claims team/person vacíos
+func (am *AuthMiddleware) SetMissingClaimsConfig(config MissingClaimsConfig) {
+	am.missingClaims = config
+}
+
+// applyMissingClaims completa team/person vacíos con los valores por defecto o, en modo estricto,
+// responde 403 si falta el team. Devuelve false si la request se ha rechazado
+func (am *AuthMiddleware) applyMissingClaims(w http.ResponseWriter, r *http.Request, claims *JWTClaims, token string) bool {
+	if claims.Team == "" {
+		if am.missingClaims.RequireTeam {
+			am.RecordEarlyError(r, claims.UserID, claims.Email, claims.Team, claims.Person, "missing_team", "token has no team claim")
+			am.respondError(w, r, http.StatusForbidden, "token has no team claim", "missing_team", token)
+			return false
+		}
+		claims.Team = am.missingClaims.DefaultTeam
+	}
+	if claims.Person == "" {
+		claims.Person = am.missingClaims.DefaultPerson
+	}
+	return true
+}
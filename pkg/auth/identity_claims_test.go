@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMissingClaimsDefaultAssignment(t *testing.T) {
+	am := &AuthMiddleware{missingClaims: DefaultMissingClaimsConfig()}
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	claims := &JWTClaims{UserID: "user-1"}
+	rec := httptest.NewRecorder()
+	if !am.applyMissingClaims(rec, req, claims, "token") {
+		t.Fatalf("Expected request without team to continue, got %d", rec.Code)
+	}
+	if claims.Team != DefaultUnassignedTeam || claims.Person != DefaultUnassignedTeam {
+		t.Errorf("Expected unassigned team and person, got %q/%q", claims.Team, claims.Person)
+	}
+
+	// Los claims presentes no se modifican
+	claims = &JWTClaims{UserID: "user-2", Team: "platform", Person: "ana"}
+	am.applyMissingClaims(httptest.NewRecorder(), req, claims, "token")
+	if claims.Team != "platform" || claims.Person != "ana" {
+		t.Errorf("Expected claims to be kept, got %q/%q", claims.Team, claims.Person)
+	}
+}
+
+func TestMissingClaimsStrictRejection(t *testing.T) {
+	am := &AuthMiddleware{}
+	am.SetMissingClaimsConfig(MissingClaimsConfig{DefaultTeam: DefaultUnassignedTeam, RequireTeam: true})
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	rec := httptest.NewRecorder()
+	if am.applyMissingClaims(rec, req, &JWTClaims{UserID: "user-1", Person: "ana"}, "token") {
+		t.Fatal("Expected token without team to be rejected in strict mode")
+	}
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", rec.Code)
+	}
+
+	if !am.applyMissingClaims(httptest.NewRecorder(), req, &JWTClaims{UserID: "user-2", Team: "platform"}, "token") {
+		t.Error("Expected token with team to pass in strict mode")
+	}
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Valores por defecto de la caché de claves JWKS
+const (
+	DefaultJWKSRefreshInterval    = time.Hour
+	DefaultJWKSMinRefreshInterval = 30 * time.Second
+	jwksFetchTimeout              = 10 * time.Second
+)
+
+// errJWKSRefreshRateLimited indica que se ha descartado una descarga por estar dentro del intervalo mínimo
+var errJWKSRefreshRateLimited = errors.New("JWKS refresh rate limited")
+
+// jwk es una clave pública del documento JWKS (RFC 7517); solo se usan claves RSA y EC de firma
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jwksKeySet cachea las claves publicadas por el proveedor de identidad, indexadas por kid.
+// Se refrescan cuando superan refreshInterval y bajo demanda ante un kid desconocido, nunca más de
+// una vez por minRefreshInterval para que una avalancha de kids inválidos no sature el endpoint
+type jwksKeySet struct {
+	url                string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	now                func() time.Time
+
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+
+	refreshMu   sync.Mutex // Serializa las descargas
+	lastAttempt time.Time
+}
+
+func newJWKSKeySet(url string, refreshInterval, minRefreshInterval time.Duration) *jwksKeySet {
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJWKSRefreshInterval
+	}
+	if minRefreshInterval <= 0 {
+		minRefreshInterval = DefaultJWKSMinRefreshInterval
+	}
+	return &jwksKeySet{
+		url:                url,
+		client:             &http.Client{Timeout: jwksFetchTimeout},
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: minRefreshInterval,
+		now:                time.Now,
+		keys:               make(map[string]interface{}),
+	}
+}
+
+// key devuelve la clave pública del kid, refrescando el JWKS si está caducado o no contiene el kid.
+// Si el refresco falla se siguen usando las claves ya cargadas
+func (ks *jwksKeySet) key(ctx context.Context, kid string) (interface{}, error) {
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	stale := ks.now().Sub(ks.fetchedAt) >= ks.refreshInterval
+	ks.mu.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	if err := ks.refresh(ctx); err != nil && !errors.Is(err, errJWKSRefreshRateLimited) {
+		logJWKSRefreshFailed(ctx, ks.url, err)
+	}
+
+	ks.mu.RLock()
+	key, ok = ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key id %q", kid)
+	}
+	return key, nil
+}
+
+// refresh descarga el JWKS y sustituye las claves (como mucho una vez por minRefreshInterval)
+func (ks *jwksKeySet) refresh(ctx context.Context) error {
+	ks.refreshMu.Lock()
+	defer ks.refreshMu.Unlock()
+
+	now := ks.now()
+	if !ks.lastAttempt.IsZero() && now.Sub(ks.lastAttempt) < ks.minRefreshInterval {
+		return errJWKSRefreshRateLimited
+	}
+	ks.lastAttempt = now
+
+	keys, err := ks.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.fetchedAt = now
+	ks.mu.Unlock()
+	return nil
+}
+
+// fetch descarga y parsea el documento JWKS; las claves no soportadas se ignoran
+func (ks *jwksKeySet) fetch(ctx context.Context) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint responded %d", resp.StatusCode)
+	}
+
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(document.Keys))
+	for _, k := range document.Keys {
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey convierte la JWK en una clave pública RSA o ECDSA
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(bytes) == 0 {
+		return nil, fmt.Errorf("invalid JWK integer")
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+// keyfunc selecciona la clave de verificación: HMAC con la clave compartida (tokens emitidos por el
+// proxy) y RSA/ECDSA con la clave del JWKS indicada por el kid del header
+func (am *AuthMiddleware) keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if am.jwtConfig.SecretKey == "" {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(am.jwtConfig.SecretKey), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			if am.jwks == nil {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			kid, _ := token.Header["kid"].(string)
+			if kid == "" {
+				return nil, errors.New("token has no kid header")
+			}
+			return am.jwks.key(ctx, kid)
+		}
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+}
+
+// validateToken valida el JWT con la clave compartida o, si hay JWKS configurado, también con sus claves
+func (am *AuthMiddleware) validateToken(ctx context.Context, tokenString string) (*JWTClaims, error) {
+	if am.jwks == nil {
+		return ValidateToken(tokenString, am.jwtConfig.SecretKey)
+	}
+	return validateTokenWithKeyfunc(tokenString, am.keyfunc(ctx))
+}
+
+// logJWKSRefreshFailed registra un fallo al refrescar el JWKS (se siguen usando las claves cacheadas)
+func logJWKSRefreshFailed(ctx context.Context, url string, err error) {
+	if Logger == nil {
+		return
+	}
+	Logger.WarningContext(ctx, amslog.Event{
+		Name:    "JWKS_REFRESH_FAILED",
+		Message: "Failed to refresh JWKS, using cached keys",
+		Error: &amslog.ErrorInfo{
+			Type:    "JWKSError",
+			Message: err.Error(),
+		},
+		Fields: map[string]interface{}{
+			"jwks.url": url,
+		},
+	})
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeJWKSServer sirve un JWKS con las claves RSA publicadas y cuenta las descargas
+type fakeJWKSServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches atomic.Int32
+}
+
+func newFakeJWKSServer(t *testing.T, kids ...string) *fakeJWKSServer {
+	t.Helper()
+	fake := &fakeJWKSServer{keys: make(map[string]*rsa.PrivateKey)}
+	for _, kid := range kids {
+		fake.addKey(t, kid)
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.fetches.Add(1)
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		var document struct {
+			Keys []jwk `json:"keys"`
+		}
+		for kid, key := range fake.keys {
+			document.Keys = append(document.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(document)
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeJWKSServer) addKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	f.mu.Lock()
+	f.keys[kid] = key
+	f.mu.Unlock()
+}
+
+// sign firma un token RS256 del usuario con la clave del kid
+func (f *fakeJWKSServer) sign(t *testing.T, kid string) string {
+	t.Helper()
+	f.mu.Lock()
+	key := f.keys[kid]
+	f.mu.Unlock()
+	claims := JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		UserID:           "user-" + kid,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func TestJWKSValidatesTokensSignedByEachKey(t *testing.T) {
+	fake := newFakeJWKSServer(t, "key-1", "key-2")
+	am := NewAuthMiddleware(nil, JWTConfig{SecretKey: testTokenGraceSecret, JWKSURL: fake.URL})
+
+	for _, kid := range []string{"key-1", "key-2"} {
+		claims, err := am.validateToken(context.Background(), fake.sign(t, kid))
+		if err != nil || claims.UserID != "user-"+kid {
+			t.Errorf("Expected token signed by %s to validate, got %+v (err %v)", kid, claims, err)
+		}
+	}
+	if fake.fetches.Load() != 1 {
+		t.Errorf("Expected keys to be cached after the first fetch, got %d fetches", fake.fetches.Load())
+	}
+
+	// Los tokens HMAC del proxy siguen validándose con la clave compartida
+	if _, err := am.validateToken(context.Background(), newTokenIssuedAt(t, testTokenGraceSecret, time.Now())); err != nil {
+		t.Errorf("Expected HMAC token to validate with JWKS configured, got %v", err)
+	}
+}
+
+func TestJWKSRefreshesOnUnknownKidWithRateLimit(t *testing.T) {
+	fake := newFakeJWKSServer(t, "key-1")
+	am := NewAuthMiddleware(nil, JWTConfig{JWKSURL: fake.URL, JWKSMinRefreshInterval: time.Minute})
+	now := time.Now()
+	am.jwks.now = func() time.Time { return now }
+
+	if _, err := am.validateToken(context.Background(), fake.sign(t, "key-1")); err != nil {
+		t.Fatalf("Expected token to validate, got %v", err)
+	}
+
+	// Rotación: key-2 aún no está cacheada. Dentro del intervalo mínimo no se vuelve a descargar
+	fake.addKey(t, "key-2")
+	rotated := fake.sign(t, "key-2")
+	for i := 0; i < 5; i++ {
+		if _, err := am.validateToken(context.Background(), rotated); err == nil {
+			t.Fatal("Expected unknown kid to be rejected while refresh is rate limited")
+		}
+	}
+	if fake.fetches.Load() != 1 {
+		t.Errorf("Expected unknown kids not to hammer the JWKS endpoint, got %d fetches", fake.fetches.Load())
+	}
+
+	// Pasado el intervalo mínimo, el kid desconocido fuerza un refresco
+	now = now.Add(time.Minute)
+	if _, err := am.validateToken(context.Background(), rotated); err != nil {
+		t.Errorf("Expected rotated key to validate after on-demand refresh, got %v", err)
+	}
+	if fake.fetches.Load() != 2 {
+		t.Errorf("Expected 2 fetches, got %d", fake.fetches.Load())
+	}
+}
+
+func TestJWKSRefreshesStaleKeys(t *testing.T) {
+	fake := newFakeJWKSServer(t, "key-1")
+	am := NewAuthMiddleware(nil, JWTConfig{JWKSURL: fake.URL, JWKSRefreshInterval: time.Hour, JWKSMinRefreshInterval: time.Second})
+	now := time.Now()
+	am.jwks.now = func() time.Time { return now }
+	token := fake.sign(t, "key-1")
+
+	if _, err := am.validateToken(context.Background(), token); err != nil {
+		t.Fatalf("Expected token to validate, got %v", err)
+	}
+	now = now.Add(time.Hour)
+	fake.Close() // El endpoint cae: se siguen usando las claves cacheadas
+	if _, err := am.validateToken(context.Background(), token); err != nil {
+		t.Errorf("Expected cached key to be used when refresh fails, got %v", err)
+	}
+	if fake.fetches.Load() != 1 {
+		t.Errorf("Expected 1 successful fetch, got %d", fake.fetches.Load())
+	}
+}
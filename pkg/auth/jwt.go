@@ -15,6 +15,11 @@ type JWTConfig struct {
 	SecretKey string
 	Issuer    string
 	Audience  string
+
+	// JWKS del proveedor de identidad (opcional): tokens RSA/ECDSA verificados con la clave de su kid
+	JWKSURL                string
+	JWKSRefreshInterval    time.Duration // Antigüedad máxima de las claves cacheadas
+	JWKSMinRefreshInterval time.Duration // Intervalo mínimo entre descargas (kids desconocidos)
 }
 
 // JWTClaims representa los claims personalizados del JWT
@@ -27,6 +32,8 @@ type JWTClaims struct {
 	DefaultInferenceProfile string   `json:"default_inference_profile"`
 	Team                    string   `json:"team,omitempty"`
 	Person                  string   `json:"person,omitempty"`
+	Project                 string   `json:"project,omitempty"` // Proyecto por defecto al que se imputa el gasto
+	ToolFormat              string   `json:"tool_format,omitempty"` // Formato de tools en el system prompt (text o json)
 }
 
 // CreateToken genera un nuevo JWT (útil para testing)
@@ -62,13 +69,18 @@ func CreateToken(config JWTConfig, userID, email, iamUsername string, iamGroups
 
 // ValidateToken valida un JWT y retorna los claims
 func ValidateToken(tokenString, secretKey string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+	return validateTokenWithKeyfunc(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Verificar que el algoritmo sea HMAC
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(secretKey), nil
 	})
+}
+
+// validateTokenWithKeyfunc valida un JWT con la clave que devuelva keyfunc y retorna los claims
+func validateTokenWithKeyfunc(tokenString string, keyfunc jwt.Keyfunc) (*JWTClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, keyfunc)
 
 	if err != nil {
 		return nil, fmt.Errorf("error parsing token: %w", err)
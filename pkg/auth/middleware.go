@@ -6,12 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/apierror"
 	"bedrock-proxy-test/pkg/database"
 )
 
@@ -35,26 +37,44 @@ type UserContext struct {
 	DefaultInferenceProfile string
 	Team                    string
 	Person                  string
+	Project                 string // Claim project del JWT (sin normalizar)
 	JTI                     string
+	ToolFormat              string // Claim tool_format del JWT (vacío = según User-Agent)
 }
 
 // AuthMiddleware es el middleware de autenticación JWT
 type AuthMiddleware struct {
-	jwtConfig     JWTConfig
-	db            *database.Database
-	rateLimiter   *RateLimiter
-	metricsWorker interface{
+	jwtConfig            JWTConfig
+	jwks                 *jwksKeySet // nil = solo tokens HMAC con la clave compartida
+	db                   *database.Database
+	rateLimiter          *RateLimiter
+	quotaGrace           *quotaGraceTracker // nil = sin modo de gracia
+	tokenDBGrace         time.Duration      // 0 = el token debe existir en BD (estricto)
+	quotaWebhook         *quotaWebhook      // nil = sin notificaciones de cuota
+	duplicateCredentials DuplicateCredentialsMode
+	missingClaims        MissingClaimsConfig
+	quotaBypass          func(user *UserContext) bool  // nil = ningún usuario exento de cuota
+	quotaZeroLimit       func(dailyLimit int) int      // nil = el límite 0/NULL lo decide la BD
+	quotaReset           func(now time.Time) time.Time // nil = reset diario a medianoche UTC
+	metricsWorker        interface{
 		RecordUsageTracking(data *database.UsageTrackingData) error
 	}
 }
 
 // NewAuthMiddleware crea una nueva instancia del middleware de autenticación
+// Si jwtConfig.JWKSURL está configurado, los tokens RSA/ECDSA se verifican con las claves del JWKS
 func NewAuthMiddleware(db *database.Database, jwtConfig JWTConfig) *AuthMiddleware {
-	return &AuthMiddleware{
-		jwtConfig:   jwtConfig,
-		db:          db,
-		rateLimiter: NewRateLimiter(),
+	am := &AuthMiddleware{
+		jwtConfig:            jwtConfig,
+		db:                   db,
+		rateLimiter:          NewRateLimiter(),
+		duplicateCredentials: DuplicateCredentialsWarn,
+		missingClaims:        DefaultMissingClaimsConfig(),
 	}
+	if jwtConfig.JWKSURL != "" {
+		am.jwks = newJWKSKeySet(jwtConfig.JWKSURL, jwtConfig.JWKSRefreshInterval, jwtConfig.JWKSMinRefreshInterval)
+	}
+	return am
 }
 
 // SetMetricsWorker establece el MetricsWorker para registro de errores tempranos
@@ -77,31 +97,19 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 					clientIP, retryAfter.Seconds()), "rate_limit_ip")
 			return
 		}
+		if !am.checkRateLimitBackend(w, r, "ip:"+clientIP) {
+			return
+		}
 
-		var tokenString string
-		var err error
-
-		// Intentar extraer token del header Authorization (formato: Bearer <token>)
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
-			tokenString, err = ExtractBearerToken(authHeader)
-			if err != nil {
-				am.rateLimiter.RecordFailedAttempt(clientIP, "")
-				am.respondError(w, r, http.StatusUnauthorized, fmt.Sprintf("invalid authorization header: %v", err), "invalid_header")
-				return
-			}
-		} else {
-			// Si no hay Authorization, intentar con x-api-key (formato usado por Cline)
-			apiKey := r.Header.Get("x-api-key")
-			if apiKey == "" {
-				am.rateLimiter.RecordFailedAttempt(clientIP, "")
-				am.respondError(w, r, http.StatusUnauthorized, "missing authorization header or x-api-key", "missing_auth")
-				return
-			}
-			tokenString = apiKey
+		// Extraer token de Authorization (Bearer <token>) o x-api-key
+		tokenString, credErr := am.extractToken(r)
+		if credErr != nil {
+			am.rateLimiter.RecordFailedAttempt(clientIP, "")
+			am.respondError(w, r, http.StatusUnauthorized, credErr.message, credErr.errorType)
+			return
 		}
 
-		// Calcular hash del token para rate limiting y búsqueda en BD
+		// Calcular hash del token realmente usado para rate limiting y búsqueda en BD
 		tokenHash := HashToken(tokenString)
 
 		// 2. RATE LIMITING: Verificar límite de intentos por token
@@ -113,6 +121,9 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 					retryAfter.Seconds()), "rate_limit_token", tokenString)
 			return
 		}
+		if !am.checkRateLimitBackend(w, r, "token:"+tokenHash, tokenString) {
+			return
+		}
 
 		// PASO 1: Decodificar token sin validar expiración para obtener claims
 		unsafeClaims, decodeErr := DecodeTokenUnsafe(tokenString)
@@ -124,6 +135,9 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 
 		// PASO 2: Validar token contra base de datos (permitiendo expirados para regeneración)
 		tokenInfo, err := am.db.ValidateTokenAllowExpired(r.Context(), tokenHash)
+		if graceInfo, ok := am.tokenInfoWithinGrace(r, tokenString, err); ok {
+			tokenInfo, err = graceInfo, nil
+		}
 		if err != nil {
 			am.rateLimiter.RecordFailedAttempt(clientIP, tokenHash)
 			am.respondError(w, r, http.StatusUnauthorized, fmt.Sprintf("token validation failed: %v", err), "token_validation_failed", tokenString)
@@ -131,7 +145,7 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 		}
 
 		// PASO 3: Validar firma y expiración del JWT
-		claims, err := ValidateToken(tokenString, am.jwtConfig.SecretKey)
+		claims, err := am.validateToken(r.Context(), tokenString)
 		if err != nil {
 			// Verificar si el error es por expiración
 			if strings.Contains(err.Error(), "token expired") || strings.Contains(err.Error(), "token is expired") {
@@ -185,23 +199,35 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 		// 3. AUTENTICACIÓN EXITOSA: Registrar intento exitoso
 		am.rateLimiter.RecordSuccessfulAttempt(clientIP)
 
-		// 4. VERIFICACIÓN DE CUOTA DIARIA
-		// Verificar y actualizar la cuota del usuario (incluyendo team y person del JWT)
-		quotaResult, err := am.db.CheckAndUpdateQuota(r.Context(), claims.UserID, claims.Email, claims.Team, claims.Person)
-		if err != nil {
-			am.respondError(w, r, http.StatusInternalServerError, 
-				fmt.Sprintf("error checking quota: %v", err), "quota_check_error", tokenString)
+		// Team/Person vacíos: valor por defecto o rechazo en modo estricto (antes de cuotas y métricas)
+		if !am.applyMissingClaims(w, r, claims, tokenString) {
 			return
 		}
 
+		// 4. VERIFICACIÓN DE CUOTA DIARIA
+		// Cuentas exentas (QUOTA_BYPASS_USER_IDS/TEAMS): sin comprobación ni contador; las métricas se siguen registrando
+		quotaBypassed := am.quotaBypassed(r, claims)
+		quotaResult := &database.QuotaCheckResult{Allowed: true}
+		if !quotaBypassed {
+			// Verificar y actualizar la cuota del usuario (incluyendo team y person del JWT)
+			quotaResult, err = am.db.CheckAndUpdateQuota(r.Context(), claims.UserID, claims.Email, claims.Team, claims.Person)
+			if err != nil {
+				am.respondError(w, r, http.StatusInternalServerError, 
+					fmt.Sprintf("error checking quota: %v", err), "quota_check_error", tokenString)
+				return
+			}
+			am.applyZeroLimit(quotaResult)
+		}
+
 		// Si la cuota está excedida, retornar 401 Unauthorized (para compatibilidad con clientes)
 		// Nota: Usamos 401 en lugar de 429 porque algunos clientes no interpretan bien 429
-		if !quotaResult.Allowed {
+		// Con modo de gracia, una conversación en curso puede completar algunos turnos más
+		if !quotaResult.Allowed && !am.applyQuotaGrace(w, r, claims.UserID, quotaResult) {
 			// Añadir headers de rate limit
 			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", quotaResult.DailyLimit))
 			w.Header().Set("X-RateLimit-Remaining", "0")
-			w.Header().Set("X-RateLimit-Reset", getNextMidnightUTC())
-			w.Header().Set("Retry-After", getSecondsUntilMidnightUTC())
+			w.Header().Set("X-RateLimit-Reset", am.getQuotaResetAt())
+			w.Header().Set("Retry-After", am.getSecondsUntilQuotaReset())
 			
 			// Log del bloqueo por cuota
 			if Logger != nil {
@@ -221,7 +247,8 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 				})
 			}
 			
-			// Registrar error de cuota excedida
+			// Registrar error de cuota excedida y notificar al webhook de cuotas
+			am.notifyQuotaBlocked(claims, quotaResult)
 			am.RecordEarlyError(r, claims.UserID, claims.Email, claims.Team, claims.Person, "quota_exceeded", quotaResult.BlockReason)
 			
 			// Usar 401 para compatibilidad con clientes que no manejan bien 429
@@ -230,14 +257,21 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if quotaResult.Allowed {
+			am.markConversationActive(r, claims.UserID)
+			am.notifyQuotaThreshold(claims, quotaResult)
+		}
+
 		// Añadir headers de rate limit para peticiones exitosas
-		remaining := quotaResult.DailyLimit - quotaResult.RequestsToday
-		if remaining < 0 {
-			remaining = 0
+		if !quotaBypassed {
+			remaining := quotaResult.DailyLimit - quotaResult.RequestsToday
+			if remaining < 0 {
+				remaining = 0
+			}
+			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", quotaResult.DailyLimit))
+			w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+			w.Header().Set("X-RateLimit-Reset", am.getQuotaResetAt())
 		}
-		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", quotaResult.DailyLimit))
-		w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
-		w.Header().Set("X-RateLimit-Reset", getNextMidnightUTC())
 
 		// Crear contexto de usuario
 		// IMPORTANTE: Team y Person se extraen de los claims del JWT, no de la BD
@@ -250,6 +284,8 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 			Team:                    claims.Team,                 // Del JWT
 			Person:                  claims.Person,               // Del JWT
 			JTI:                     claims.ID,
+			ToolFormat:              claims.ToolFormat,
+			Project:                 claims.Project,
 		}
 
 		// Registrar evento de autenticación exitosa en formato JSON estructurado
@@ -331,35 +367,17 @@ func (am *AuthMiddleware) respondError(w http.ResponseWriter, r *http.Request, s
 		Logger.WarningContext(r.Context(), event)
 	}
 
-	// Construir respuesta de error más detallada
-	errorResponse := map[string]interface{}{
-		"error": map[string]interface{}{
-			"message": message,
-			"type":    errorType,
-			"code":    statusCode,
-		},
-	}
-	
 	// Añadir información adicional para errores de cuota
+	var extra map[string]interface{}
 	if errorType == "quota_exceeded" {
-		errorResponse["error"].(map[string]interface{})["retry_after"] = getSecondsUntilMidnightUTC()
-		errorResponse["error"].(map[string]interface{})["reset_at"] = getNextMidnightUTC()
-	}
-
-	// Serializar a JSON
-	jsonResponse, err := json.Marshal(errorResponse)
-	if err != nil {
-		// Fallback a respuesta simple si falla la serialización
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(statusCode)
-		fmt.Fprintf(w, `{"error":{"message":"%s","type":"%s","code":%d}}`, message, errorType, statusCode)
-		return
+		extra = map[string]interface{}{
+			"retry_after": am.getSecondsUntilQuotaReset(),
+			"reset_at":    am.getQuotaResetAt(),
+		}
 	}
 
-	// Responder al cliente
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(statusCode)
-	w.Write(jsonResponse)
+	// Responder en formato Anthropic; el tipo interno va en error.code
+	apierror.Write(w, statusCode, authErrorTypes[errorType], errorType, message, extra)
 	
 	// Forzar flush si el ResponseWriter lo soporta
 	if flusher, ok := w.(http.Flusher); ok {
@@ -367,6 +385,15 @@ func (am *AuthMiddleware) respondError(w http.ResponseWriter, r *http.Request, s
 	}
 }
 
+// authErrorTypes es el tipo Anthropic de los errores cuyo status no lo refleja (p.ej. límites que
+// responden 401 por compatibilidad); el resto se deduce del status
+var authErrorTypes = map[string]string{
+	"rate_limit_ip":     apierror.TypeRateLimit,
+	"rate_limit_token":  apierror.TypeRateLimit,
+	"rate_limit_shared": apierror.TypeRateLimit,
+	"quota_exceeded":    apierror.TypeRateLimit,
+}
+
 // GetUserFromContext extrae la información del usuario del contexto
 func GetUserFromContext(ctx context.Context) (*UserContext, error) {
 	user, ok := ctx.Value(UserContextKey).(UserContext)
@@ -382,9 +409,7 @@ func RequireGroups(requiredGroups []string) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user, err := GetUserFromContext(r.Context())
 			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				fmt.Fprintf(w, `{"error":"user not authenticated"}`)
+				apierror.Write(w, http.StatusUnauthorized, "", "user_not_authenticated", "user not authenticated", nil)
 				return
 			}
 
@@ -403,9 +428,7 @@ func RequireGroups(requiredGroups []string) func(http.Handler) http.Handler {
 			}
 
 			if !hasGroup {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusForbidden)
-				fmt.Fprintf(w, `{"error":"insufficient permissions"}`)
+				apierror.Write(w, http.StatusForbidden, "", "insufficient_permissions", "insufficient permissions", nil)
 				return
 			}
 
@@ -439,18 +462,27 @@ func getClientIP(r *http.Request) string {
 	return ip
 }
 
-// getNextMidnightUTC retorna el timestamp de la próxima medianoche UTC en formato Unix
-func getNextMidnightUTC() string {
-	now := time.Now().UTC()
-	nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
-	return fmt.Sprintf("%d", nextMidnight.Unix())
+// nextQuotaReset retorna el próximo reset de la cuota diaria (QUOTA_RESET_TIMEZONE/HOUR; por defecto medianoche UTC)
+func (am *AuthMiddleware) nextQuotaReset(now time.Time) time.Time {
+	if am.quotaReset != nil {
+		return am.quotaReset(now)
+	}
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// getQuotaResetAt retorna el timestamp del próximo reset de la cuota diaria en formato Unix
+func (am *AuthMiddleware) getQuotaResetAt() string {
+	return fmt.Sprintf("%d", am.nextQuotaReset(time.Now()).Unix())
 }
 
-// getSecondsUntilMidnightUTC retorna los segundos hasta la próxima medianoche UTC
-func getSecondsUntilMidnightUTC() string {
-	now := time.Now().UTC()
-	nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
-	seconds := int(nextMidnight.Sub(now).Seconds())
+// getSecondsUntilQuotaReset retorna los segundos (redondeados hacia arriba) hasta el próximo reset de la cuota diaria
+func (am *AuthMiddleware) getSecondsUntilQuotaReset() string {
+	now := time.Now()
+	seconds := int64(math.Ceil(am.nextQuotaReset(now).Sub(now).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
 	return fmt.Sprintf("%d", seconds)
 }
 
@@ -590,22 +622,12 @@ func (am *AuthMiddleware) handleExpiredToken(w http.ResponseWriter, r *http.Requ
 				// Añadir información adicional si está disponible
 				if activeCount, ok := result["active_tokens_count"].(float64); ok {
 					if maxAllowed, ok := result["max_tokens_allowed"].(float64); ok {
-						errorResponse := map[string]interface{}{
-							"error": map[string]interface{}{
-								"type":                "token_expired_max_tokens",
-								"message":             errorMsg,
-								"code":                401,
-								"auto_regenerated":    false,
-								"active_tokens_count": int(activeCount),
-								"max_tokens_allowed":  int(maxAllowed),
-								"action_required":     "revoke_old_tokens",
-							},
-						}
-						
-						jsonResponse, _ := json.Marshal(errorResponse)
-						w.Header().Set("Content-Type", "application/json; charset=utf-8")
-						w.WriteHeader(http.StatusUnauthorized)
-						w.Write(jsonResponse)
+						apierror.Write(w, http.StatusUnauthorized, "", "token_expired_max_tokens", errorMsg, map[string]interface{}{
+							"auto_regenerated":    false,
+							"active_tokens_count": int(activeCount),
+							"max_tokens_allowed":  int(maxAllowed),
+							"action_required":     "revoke_old_tokens",
+						})
 						return
 					}
 				}
@@ -641,18 +663,10 @@ func (am *AuthMiddleware) handleExpiredToken(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Responder con mensaje de regeneración exitosa
-	errorResponse := map[string]interface{}{
-		"error": map[string]interface{}{
-			"type":             "token_expired_regenerated",
-			"message":          "token has expired. A new token has been generated and sent to your email",
-			"code":             401,
+	apierror.Write(w, http.StatusUnauthorized, "", "token_expired_regenerated",
+		"token has expired. A new token has been generated and sent to your email",
+		map[string]interface{}{
 			"auto_regenerated": true,
 			"email_sent":       emailSent,
-		},
-	}
-
-	jsonResponse, _ := json.Marshal(errorResponse)
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusUnauthorized)
-	w.Write(jsonResponse)
+		})
 }
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/database"
+)
+
+// ConversationIDHeader es el header con el que el cliente agrupa peticiones de una misma conversación
+const ConversationIDHeader = "X-Conversation-ID"
+
+// Valores por defecto del modo de gracia de cuota
+const (
+	DefaultQuotaGraceWindow   = time.Hour
+	quotaGraceMaxTracked      = 10000 // Conversaciones a partir de las cuales se purgan las inactivas
+	QuotaGraceRemainingHeader = "X-Quota-Grace-Remaining"
+)
+
+// QuotaGraceConfig define cuántos turnos extra puede completar una conversación en curso
+// cuando el usuario supera su cuota. Turns <= 0 desactiva el modo de gracia
+type QuotaGraceConfig struct {
+	Turns        int           // Turnos adicionales permitidos por conversación
+	ActiveWindow time.Duration // Inactividad máxima para considerar la conversación "en curso"
+}
+
+// graceConversation guarda la última actividad y los turnos de gracia consumidos
+type graceConversation struct {
+	lastSeen  time.Time
+	graceUsed int
+}
+
+// quotaGraceTracker lleva en memoria las conversaciones activas de cada usuario
+type quotaGraceTracker struct {
+	mu            sync.Mutex
+	config        QuotaGraceConfig
+	conversations map[string]*graceConversation
+	now           func() time.Time
+}
+
+func newQuotaGraceTracker(config QuotaGraceConfig) *quotaGraceTracker {
+	if config.ActiveWindow <= 0 {
+		config.ActiveWindow = DefaultQuotaGraceWindow
+	}
+	return &quotaGraceTracker{
+		config:        config,
+		conversations: make(map[string]*graceConversation),
+		now:           time.Now,
+	}
+}
+
+func graceKey(userID, conversationID string) string {
+	return userID + "|" + conversationID
+}
+
+// markActive registra un turno permitido dentro de cuota (reinicia los turnos de gracia)
+func (t *quotaGraceTracker) markActive(userID, conversationID string) {
+	if conversationID == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if len(t.conversations) >= quotaGraceMaxTracked {
+		t.pruneLocked(now)
+	}
+	t.conversations[graceKey(userID, conversationID)] = &graceConversation{lastSeen: now}
+}
+
+// allow consume un turno de gracia si la conversación estaba en curso al superar la cuota
+// Devuelve los turnos de gracia restantes tras este turno
+func (t *quotaGraceTracker) allow(userID, conversationID string) (int, bool) {
+	if conversationID == "" {
+		return 0, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	conv, ok := t.conversations[graceKey(userID, conversationID)]
+	if !ok || now.Sub(conv.lastSeen) > t.config.ActiveWindow {
+		return 0, false
+	}
+	if conv.graceUsed >= t.config.Turns {
+		return 0, false
+	}
+
+	conv.graceUsed++
+	conv.lastSeen = now
+	return t.config.Turns - conv.graceUsed, true
+}
+
+// pruneLocked elimina las conversaciones inactivas (requiere t.mu)
+func (t *quotaGraceTracker) pruneLocked(now time.Time) {
+	for key, conv := range t.conversations {
+		if now.Sub(conv.lastSeen) > t.config.ActiveWindow {
+			delete(t.conversations, key)
+		}
+	}
+}
+
+// SetQuotaGrace activa el modo de gracia para conversaciones en curso (Turns <= 0 lo desactiva)
+func (am *AuthMiddleware) SetQuotaGrace(config QuotaGraceConfig) {
+	if config.Turns <= 0 {
+		am.quotaGrace = nil
+		return
+	}
+	am.quotaGrace = newQuotaGraceTracker(config)
+}
+
+// markConversationActive registra un turno permitido de la conversación de la request
+func (am *AuthMiddleware) markConversationActive(r *http.Request, userID string) {
+	if am.quotaGrace != nil {
+		am.quotaGrace.markActive(userID, r.Header.Get(ConversationIDHeader))
+	}
+}
+
+// applyQuotaGrace decide si una request fuera de cuota puede continuar como turno de gracia.
+// Solo aplica a denegaciones por límite de requests (no a bloqueos administrativos)
+// y añade un header de aviso con los turnos de gracia restantes
+func (am *AuthMiddleware) applyQuotaGrace(w http.ResponseWriter, r *http.Request, userID string, quotaResult *database.QuotaCheckResult) bool {
+	if am.quotaGrace == nil || quotaResult.RequestsToday < quotaResult.DailyLimit {
+		return false
+	}
+
+	conversationID := r.Header.Get(ConversationIDHeader)
+	remaining, ok := am.quotaGrace.allow(userID, conversationID)
+	if !ok {
+		return false
+	}
+
+	w.Header().Set(QuotaGraceRemainingHeader, fmt.Sprintf("%d", remaining))
+	w.Header().Set("Warning", fmt.Sprintf(`299 - "Daily quota exceeded; conversation allowed %d more grace turn(s)"`, remaining))
+
+	if Logger != nil {
+		Logger.WarningContext(r.Context(), amslog.Event{
+			Name:    "QUOTA_GRACE_TURN",
+			Message: "Quota exceeded, allowing grace turn for in-progress conversation",
+			Fields: map[string]interface{}{
+				"user.id":               userID,
+				"conversation.id":       conversationID,
+				"quota.limit":           quotaResult.DailyLimit,
+				"quota.used":            quotaResult.RequestsToday,
+				"quota.grace_remaining": remaining,
+			},
+		})
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+)
+
+func newGraceRequest(conversationID string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	if conversationID != "" {
+		req.Header.Set(ConversationIDHeader, conversationID)
+	}
+	return req
+}
+
+func TestQuotaGraceThenBlock(t *testing.T) {
+	am := &AuthMiddleware{}
+	am.SetQuotaGrace(QuotaGraceConfig{Turns: 2, ActiveWindow: time.Hour})
+
+	// Turno dentro de cuota: la conversación queda marcada como en curso
+	am.markConversationActive(newGraceRequest("conv-1"), "user-1")
+
+	exceeded := &database.QuotaCheckResult{Allowed: false, RequestsToday: 100, DailyLimit: 100}
+
+	for _, expectedRemaining := range []string{"1", "0"} {
+		rec := httptest.NewRecorder()
+		if !am.applyQuotaGrace(rec, newGraceRequest("conv-1"), "user-1", exceeded) {
+			t.Fatal("Expected grace turn for in-progress conversation")
+		}
+		if got := rec.Header().Get(QuotaGraceRemainingHeader); got != expectedRemaining {
+			t.Errorf("Expected %s grace turns remaining, got %q", expectedRemaining, got)
+		}
+		if rec.Header().Get("Warning") == "" {
+			t.Error("Expected Warning header on grace turn")
+		}
+	}
+
+	// Agotados los turnos de gracia: bloquear
+	if am.applyQuotaGrace(httptest.NewRecorder(), newGraceRequest("conv-1"), "user-1", exceeded) {
+		t.Error("Expected block after grace turns are exhausted")
+	}
+}
+
+func TestQuotaGraceRequiresInProgressConversation(t *testing.T) {
+	am := &AuthMiddleware{}
+	am.SetQuotaGrace(QuotaGraceConfig{Turns: 2, ActiveWindow: time.Hour})
+	am.markConversationActive(newGraceRequest("conv-1"), "user-1")
+
+	exceeded := &database.QuotaCheckResult{Allowed: false, RequestsToday: 100, DailyLimit: 100}
+
+	if am.applyQuotaGrace(httptest.NewRecorder(), newGraceRequest("conv-new"), "user-1", exceeded) {
+		t.Error("Expected no grace for a conversation started after the quota was exceeded")
+	}
+	if am.applyQuotaGrace(httptest.NewRecorder(), newGraceRequest(""), "user-1", exceeded) {
+		t.Error("Expected no grace without conversation header")
+	}
+	if am.applyQuotaGrace(httptest.NewRecorder(), newGraceRequest("conv-1"), "user-2", exceeded) {
+		t.Error("Expected no grace for another user's conversation")
+	}
+
+	// Bloqueo administrativo (sin superar el límite): sin gracia
+	blocked := &database.QuotaCheckResult{Allowed: false, RequestsToday: 10, DailyLimit: 100, IsBlocked: true}
+	if am.applyQuotaGrace(httptest.NewRecorder(), newGraceRequest("conv-1"), "user-1", blocked) {
+		t.Error("Expected no grace for administrative blocks")
+	}
+}
+
+func TestQuotaGraceExpiresAfterInactivity(t *testing.T) {
+	am := &AuthMiddleware{}
+	am.SetQuotaGrace(QuotaGraceConfig{Turns: 2, ActiveWindow: time.Minute})
+
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	am.quotaGrace.now = func() time.Time { return now }
+	am.markConversationActive(newGraceRequest("conv-1"), "user-1")
+
+	now = now.Add(2 * time.Minute)
+	exceeded := &database.QuotaCheckResult{Allowed: false, RequestsToday: 100, DailyLimit: 100}
+	if am.applyQuotaGrace(httptest.NewRecorder(), newGraceRequest("conv-1"), "user-1", exceeded) {
+		t.Error("Expected no grace for a conversation inactive longer than the window")
+	}
+}
+
+func TestQuotaGraceDisabledByDefault(t *testing.T) {
+	am := &AuthMiddleware{}
+	am.markConversationActive(newGraceRequest("conv-1"), "user-1")
+
+	exceeded := &database.QuotaCheckResult{Allowed: false, RequestsToday: 100, DailyLimit: 100}
+	if am.applyQuotaGrace(httptest.NewRecorder(), newGraceRequest("conv-1"), "user-1", exceeded) {
+		t.Error("Expected no grace when GRACE_TURNS is not configured")
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/database"
+)
+
+// SetQuotaBypass establece qué usuarios están exentos de la cuota diaria (quota.BypassConfig.Matches).
+// El paquete quota importa auth, así que la configuración se inyecta desde main
+func (am *AuthMiddleware) SetQuotaBypass(matches func(user *UserContext) bool) {
+	am.quotaBypass = matches
+}
+
+// quotaBypassed indica si el usuario del token está exento de la cuota y registra QUOTA_BYPASS
+func (am *AuthMiddleware) quotaBypassed(r *http.Request, claims *JWTClaims) bool {
+	if am.quotaBypass == nil || !am.quotaBypass(&UserContext{UserID: claims.UserID, Team: claims.Team}) {
+		return false
+	}
+	if Logger != nil {
+		Logger.InfoContext(r.Context(), amslog.Event{
+			Name:    "QUOTA_BYPASS",
+			Message: "Quota checks bypassed for exempt user",
+			Fields: map[string]interface{}{
+				"user.id":   claims.UserID,
+				"user.team": claims.Team,
+			},
+		})
+	}
+	return true
+}
+
+// SetQuotaZeroLimit establece cómo se interpreta un límite diario a 0/NULL (quota.ZeroLimitConfig.ResolveDailyRequestLimit):
+// resolve devuelve el límite efectivo (0 = ilimitado)
+func (am *AuthMiddleware) SetQuotaZeroLimit(resolve func(dailyLimit int) int) {
+	am.quotaZeroLimit = resolve
+}
+
+// applyZeroLimit reinterpreta el resultado de un usuario sin límite diario configurado, al que la función de
+// BD deniega desde la primera request (used >= 0). Los bloqueos administrativos se respetan
+func (am *AuthMiddleware) applyZeroLimit(quotaResult *database.QuotaCheckResult) {
+	if am.quotaZeroLimit == nil || quotaResult.DailyLimit > 0 || quotaResult.IsBlocked {
+		return
+	}
+	quotaResult.DailyLimit = am.quotaZeroLimit(quotaResult.DailyLimit)
+	quotaResult.Allowed = quotaResult.DailyLimit <= 0 || quotaResult.RequestsToday <= quotaResult.DailyLimit
+	if !quotaResult.Allowed && quotaResult.BlockReason == "" {
+		quotaResult.BlockReason = "daily request limit exceeded"
+	}
+}
+
+// SetQuotaReset establece el cálculo del próximo reset diario (quota.ResetConfig.NextDailyReset) usado en
+// X-RateLimit-Reset y Retry-After de las respuestas por cuota excedida
+func (am *AuthMiddleware) SetQuotaReset(nextDailyReset func(now time.Time) time.Time) {
+	am.quotaReset = nextDailyReset
+}
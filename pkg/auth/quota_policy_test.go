@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+)
+
+func TestQuotaBypassMatchesExemptUsers(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	am := &AuthMiddleware{}
+	if am.quotaBypassed(req, &JWTClaims{UserID: "eval-bot"}) {
+		t.Error("Expected no bypass by default")
+	}
+
+	am.SetQuotaBypass(func(user *UserContext) bool { return user.UserID == "eval-bot" || user.Team == "platform" })
+	if !am.quotaBypassed(req, &JWTClaims{UserID: "eval-bot", Team: "data"}) {
+		t.Error("Expected exempt user to bypass the quota")
+	}
+	if !am.quotaBypassed(req, &JWTClaims{UserID: "user-1", Team: "platform"}) {
+		t.Error("Expected exempt team to bypass the quota")
+	}
+	if am.quotaBypassed(req, &JWTClaims{UserID: "user-1", Team: "data"}) {
+		t.Error("Expected regular user to go through the quota check")
+	}
+}
+
+func TestQuotaZeroLimitPolicies(t *testing.T) {
+	zeroLimitUser := func() *database.QuotaCheckResult {
+		return &database.QuotaCheckResult{Allowed: false, RequestsToday: 3, DailyLimit: 0}
+	}
+
+	// Sin política la decisión de la BD se mantiene
+	am := &AuthMiddleware{}
+	result := zeroLimitUser()
+	am.applyZeroLimit(result)
+	if result.Allowed {
+		t.Error("Expected database decision to stand without a zero-limit policy")
+	}
+
+	// unlimited
+	am.SetQuotaZeroLimit(func(int) int { return 0 })
+	result = zeroLimitUser()
+	am.applyZeroLimit(result)
+	if !result.Allowed {
+		t.Error("Expected zero-limit user to be allowed as unlimited")
+	}
+
+	// default: se aplica el límite por defecto
+	am.SetQuotaZeroLimit(func(int) int { return 2 })
+	result = zeroLimitUser()
+	am.applyZeroLimit(result)
+	if result.Allowed || result.DailyLimit != 2 || result.BlockReason == "" {
+		t.Errorf("Expected default limit to be enforced, got %+v", result)
+	}
+
+	// Los usuarios con límite propio o bloqueados no cambian
+	configured := &database.QuotaCheckResult{Allowed: false, RequestsToday: 10, DailyLimit: 10}
+	blocked := &database.QuotaCheckResult{Allowed: false, IsBlocked: true}
+	am.SetQuotaZeroLimit(func(int) int { return 0 })
+	am.applyZeroLimit(configured)
+	am.applyZeroLimit(blocked)
+	if configured.Allowed || blocked.Allowed {
+		t.Error("Expected configured limits and administrative blocks to be kept")
+	}
+}
+
+func TestQuotaRetryAfterUsesResetConfig(t *testing.T) {
+	am := &AuthMiddleware{}
+	now := time.Now()
+	if reset := am.nextQuotaReset(now); reset.Hour() != 0 || reset.Location() != time.UTC || !reset.After(now) {
+		t.Errorf("Expected next UTC midnight by default, got %s", reset)
+	}
+
+	// Reset configurado dentro de 90 minutos: Retry-After y reset_at lo siguen
+	reset := now.Add(90 * time.Minute).Truncate(time.Second)
+	am.SetQuotaReset(func(time.Time) time.Time { return reset })
+	if got := am.getSecondsUntilQuotaReset(); got != "5400" && got != "5399" {
+		t.Errorf("Expected ~5400 seconds until the configured reset, got %s", got)
+	}
+	if got := am.getQuotaResetAt(); got != strconv.FormatInt(reset.Unix(), 10) {
+		t.Errorf("Expected reset_at %d, got %s", reset.Unix(), got)
+	}
+
+	rec := httptest.NewRecorder()
+	am.respondError(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil), http.StatusUnauthorized, "daily quota exceeded", "quota_exceeded")
+	if !strings.Contains(rec.Body.String(), `"reset_at":"`+strconv.FormatInt(reset.Unix(), 10)+`"`) {
+		t.Errorf("Expected reset_at from the reset config in the body, got %s", rec.Body.String())
+	}
+}
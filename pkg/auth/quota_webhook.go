@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/database"
+	"bedrock-proxy-test/pkg/retrystats"
+)
+
+// Valores por defecto del webhook de cuotas
+const (
+	DefaultQuotaWebhookTimeout     = 5 * time.Second
+	DefaultQuotaWebhookRetries     = 3
+	DefaultQuotaWebhookDedupWindow = time.Hour
+	quotaWebhookRetryBackoff       = 500 * time.Millisecond
+)
+
+// Tipos de evento enviados al webhook
+const (
+	QuotaWebhookEventBlocked   = "quota.blocked"
+	QuotaWebhookEventThreshold = "quota.threshold"
+)
+
+// QuotaWebhookConfig define el webhook que recibe los bloqueos y cruces de umbral de cuota.
+// URL vacía lo desactiva
+type QuotaWebhookConfig struct {
+	URL         string
+	Timeout     time.Duration // Timeout de cada intento
+	Retries     int           // Reintentos tras un fallo (error de red o 5xx)
+	Thresholds  []int         // Porcentajes del límite diario que generan aviso (p.ej. 80, 90)
+	DedupWindow time.Duration // Un bloqueo por usuario y motivo se notifica una vez por ventana
+}
+
+// quotaWebhook envía los eventos de cuota de forma asíncrona con reintentos.
+// El payload pasa por el sanitizador de logs para no enviar PII (emails, DNI)
+type quotaWebhook struct {
+	config    QuotaWebhookConfig
+	client    *http.Client
+	sanitizer *amslog.Sanitizer
+	mu        sync.Mutex
+	notified  map[string]time.Time
+	now       func() time.Time
+	backoff   time.Duration
+}
+
+func newQuotaWebhook(config QuotaWebhookConfig) *quotaWebhook {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultQuotaWebhookTimeout
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	}
+	if config.DedupWindow <= 0 {
+		config.DedupWindow = DefaultQuotaWebhookDedupWindow
+	}
+	return &quotaWebhook{
+		config:    config,
+		client:    &http.Client{Timeout: config.Timeout},
+		sanitizer: amslog.NewSanitizer(),
+		notified:  make(map[string]time.Time),
+		now:       time.Now,
+		backoff:   quotaWebhookRetryBackoff,
+	}
+}
+
+// SetQuotaWebhook activa el webhook de eventos de cuota (URL vacía lo desactiva)
+func (am *AuthMiddleware) SetQuotaWebhook(config QuotaWebhookConfig) {
+	if config.URL == "" {
+		am.quotaWebhook = nil
+		return
+	}
+	am.quotaWebhook = newQuotaWebhook(config)
+}
+
+// shouldNotify indica si el evento no se ha notificado ya dentro de la ventana de deduplicación
+func (wh *quotaWebhook) shouldNotify(key string) bool {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	now := wh.now()
+	if last, ok := wh.notified[key]; ok && now.Sub(last) < wh.config.DedupWindow {
+		return false
+	}
+	for k, last := range wh.notified {
+		if now.Sub(last) >= wh.config.DedupWindow {
+			delete(wh.notified, k)
+		}
+	}
+	wh.notified[key] = now
+	return true
+}
+
+// payload construye el JSON del evento sin PII
+func (wh *quotaWebhook) payload(event string, claims *JWTClaims, reason string, quotaResult *database.QuotaCheckResult, threshold int) ([]byte, error) {
+	percent := 0.0
+	if quotaResult.DailyLimit > 0 {
+		percent = float64(quotaResult.RequestsToday) / float64(quotaResult.DailyLimit) * 100
+	}
+	data := map[string]interface{}{
+		"event":     event,
+		"timestamp": wh.now().UTC().Format(time.RFC3339),
+		"user": map[string]interface{}{
+			"id":    claims.UserID,
+			"email": claims.Email,
+			"team":  claims.Team,
+		},
+		"reason": reason,
+		"usage": map[string]interface{}{
+			"requests_today": quotaResult.RequestsToday,
+			"daily_limit":    quotaResult.DailyLimit,
+			"percent":        percent,
+			"is_blocked":     quotaResult.IsBlocked,
+		},
+	}
+	if threshold > 0 {
+		data["threshold_percent"] = threshold
+	}
+	return json.Marshal(wh.sanitizer.Sanitize(data))
+}
+
+// send hace el POST con reintentos (errores de red y 5xx); se ejecuta en una goroutine propia
+func (wh *quotaWebhook) send(event string, body []byte) {
+	var lastErr error
+	exhausted := true
+	for attempt := 0; attempt <= wh.config.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * wh.backoff)
+		}
+		retrystats.Attempt(retrystats.CategoryWebhook, attempt+1)
+		resp, err := wh.client.Post(wh.config.URL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				if resp.StatusCode >= 400 {
+					lastErr = fmt.Errorf("webhook responded %d", resp.StatusCode)
+					exhausted = false // 4xx no se reintenta
+					break
+				}
+				retrystats.Succeeded(retrystats.CategoryWebhook, attempt+1)
+				return
+			}
+			err = fmt.Errorf("webhook responded %d", resp.StatusCode)
+		}
+		lastErr = err
+	}
+	if exhausted {
+		retrystats.Exhausted(retrystats.CategoryWebhook)
+	}
+
+	if Logger != nil {
+		Logger.WarningContext(context.Background(), amslog.Event{
+			Name:    "QUOTA_WEBHOOK_FAILED",
+			Message: "Quota webhook delivery failed",
+			Error: &amslog.ErrorInfo{
+				Type:    "WebhookError",
+				Message: lastErr.Error(),
+			},
+			Fields: map[string]interface{}{
+				"webhook.event":   event,
+				"webhook.retries": wh.config.Retries,
+			},
+		})
+	}
+}
+
+// dispatch serializa y envía el evento en segundo plano
+func (wh *quotaWebhook) dispatch(event string, claims *JWTClaims, reason string, quotaResult *database.QuotaCheckResult, threshold int) {
+	body, err := wh.payload(event, claims, reason, quotaResult, threshold)
+	if err != nil {
+		return
+	}
+	go wh.send(event, body)
+}
+
+// notifyQuotaBlocked notifica que la request del usuario se rechaza por cuota
+func (am *AuthMiddleware) notifyQuotaBlocked(claims *JWTClaims, quotaResult *database.QuotaCheckResult) {
+	wh := am.quotaWebhook
+	if wh == nil || !wh.shouldNotify(QuotaWebhookEventBlocked+"|"+claims.UserID+"|"+quotaResult.BlockReason) {
+		return
+	}
+	wh.dispatch(QuotaWebhookEventBlocked, claims, quotaResult.BlockReason, quotaResult, 0)
+}
+
+// notifyQuotaThreshold notifica los umbrales que cruza la request actual. La cuota se incrementa de una
+// en una, así que un umbral se cruza cuando la request anterior estaba por debajo y la actual lo alcanza
+func (am *AuthMiddleware) notifyQuotaThreshold(claims *JWTClaims, quotaResult *database.QuotaCheckResult) {
+	wh := am.quotaWebhook
+	if wh == nil || quotaResult.DailyLimit <= 0 {
+		return
+	}
+	for _, threshold := range wh.config.Thresholds {
+		limit := float64(quotaResult.DailyLimit) * float64(threshold) / 100
+		previous, current := float64(quotaResult.RequestsToday-1), float64(quotaResult.RequestsToday)
+		if previous < limit && current >= limit &&
+			wh.shouldNotify(fmt.Sprintf("%s|%s|%d", QuotaWebhookEventThreshold, claims.UserID, threshold)) {
+			reason := fmt.Sprintf("daily request usage reached %d%%", threshold)
+			wh.dispatch(QuotaWebhookEventThreshold, claims, reason, quotaResult, threshold)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+)
+
+// newWebhookServer crea un webhook stub que responde con statuses (el último se repite) y entrega los payloads
+func newWebhookServer(t *testing.T, statuses ...int) (*httptest.Server, chan map[string]interface{}, *atomic.Int32) {
+	t.Helper()
+	payloads := make(chan map[string]interface{}, 10)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		status := statuses[min(call, len(statuses))-1]
+		if status == http.StatusOK {
+			body, _ := io.ReadAll(r.Body)
+			var payload map[string]interface{}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Errorf("Invalid webhook payload %s: %v", body, err)
+			}
+			payloads <- payload
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, payloads, &calls
+}
+
+func waitWebhookPayload(t *testing.T, payloads chan map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	select {
+	case payload := <-payloads:
+		return payload
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for webhook delivery")
+		return nil
+	}
+}
+
+func TestQuotaWebhookBlockEventPayload(t *testing.T) {
+	server, payloads, calls := newWebhookServer(t, http.StatusServiceUnavailable, http.StatusOK)
+
+	am := &AuthMiddleware{}
+	am.SetQuotaWebhook(QuotaWebhookConfig{URL: server.URL, Retries: 2})
+	am.quotaWebhook.backoff = time.Millisecond
+
+	claims := &JWTClaims{UserID: "user-1", Email: "jane.doe@example.com", Team: "data", Person: "Jane Doe"}
+	blocked := &database.QuotaCheckResult{Allowed: false, RequestsToday: 100, DailyLimit: 100, IsBlocked: true, BlockReason: "daily limit reached"}
+	am.notifyQuotaBlocked(claims, blocked)
+
+	payload := waitWebhookPayload(t, payloads)
+	if calls.Load() != 2 {
+		t.Errorf("Expected delivery after one retry, got %d calls", calls.Load())
+	}
+	if payload["event"] != QuotaWebhookEventBlocked || payload["reason"] != "daily limit reached" {
+		t.Errorf("Unexpected event/reason: %v", payload)
+	}
+	user := payload["user"].(map[string]interface{})
+	if user["id"] != "user-1" || user["team"] != "data" {
+		t.Errorf("Unexpected user in payload: %v", user)
+	}
+	if user["email"] != "j***@example.com" {
+		t.Errorf("Expected masked email, got %v", user["email"])
+	}
+	if _, ok := user["person"]; ok {
+		t.Error("Expected person name not to be sent")
+	}
+	usage := payload["usage"].(map[string]interface{})
+	if usage["requests_today"] != float64(100) || usage["daily_limit"] != float64(100) || usage["is_blocked"] != true {
+		t.Errorf("Unexpected usage in payload: %v", usage)
+	}
+
+	// El mismo bloqueo no se vuelve a notificar dentro de la ventana
+	am.notifyQuotaBlocked(claims, blocked)
+	select {
+	case payload := <-payloads:
+		t.Errorf("Expected duplicate block to be suppressed, got %v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestQuotaWebhookThresholdCrossing(t *testing.T) {
+	server, payloads, _ := newWebhookServer(t, http.StatusOK)
+
+	am := &AuthMiddleware{}
+	am.SetQuotaWebhook(QuotaWebhookConfig{URL: server.URL, Thresholds: []int{80}})
+	claims := &JWTClaims{UserID: "user-1"}
+
+	// 79 -> sin aviso; 80 cruza el umbral; 81 ya estaba por encima
+	for _, requests := range []int{79, 80, 81} {
+		am.notifyQuotaThreshold(claims, &database.QuotaCheckResult{Allowed: true, RequestsToday: requests, DailyLimit: 100})
+	}
+
+	payload := waitWebhookPayload(t, payloads)
+	if payload["event"] != QuotaWebhookEventThreshold || payload["threshold_percent"] != float64(80) {
+		t.Errorf("Unexpected threshold payload: %v", payload)
+	}
+	select {
+	case payload := <-payloads:
+		t.Errorf("Expected a single threshold notification, got %v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
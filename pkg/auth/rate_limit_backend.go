@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// RateLimitBackend es un almacén compartido de límites (p.ej. PostgreSQL) que se consulta además del
+// rate limiter en memoria, para que los límites se apliquen entre todas las instancias del proxy.
+// key es "ip:<ip>" o "token:<hash>"
+type RateLimitBackend interface {
+	Check(ctx context.Context, key string) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// BackendFailurePolicy define qué hacer cuando el backend del rate limiter falla
+type BackendFailurePolicy string
+
+const (
+	// BackendFailOpen deja pasar la request (solo aplica el límite en memoria) y emite una alerta (por defecto)
+	BackendFailOpen BackendFailurePolicy = "fail-open"
+	// BackendFailClosed rechaza la request con 503 mientras el backend no responda
+	BackendFailClosed BackendFailurePolicy = "fail-closed"
+)
+
+// BackendFailureRetryAfter es el Retry-After de las requests rechazadas en modo fail-closed
+const BackendFailureRetryAfter = 5 * time.Second
+
+// ParseBackendFailurePolicy interpreta la política; un valor desconocido se trata como fail-open
+func ParseBackendFailurePolicy(value string) BackendFailurePolicy {
+	if BackendFailurePolicy(strings.ToLower(strings.TrimSpace(value))) == BackendFailClosed {
+		return BackendFailClosed
+	}
+	return BackendFailOpen
+}
+
+// SetBackend configura el backend compartido (nil = solo límites en memoria)
+func (rl *RateLimiter) SetBackend(backend RateLimitBackend) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.backend = backend
+}
+
+// SetBackendFailurePolicy configura la política ante errores del backend
+func (rl *RateLimiter) SetBackendFailurePolicy(policy BackendFailurePolicy) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.backendPolicy = ParseBackendFailurePolicy(string(policy))
+}
+
+// BackendErrorCount devuelve cuántos errores del backend se han producido
+func (rl *RateLimiter) BackendErrorCount() int64 {
+	return rl.backendErrors.Load()
+}
+
+// CheckBackend consulta el backend compartido para la clave. Sin backend siempre permite.
+// Si el backend falla (backendFailed) se contabiliza el error y se aplica la política: fail-open
+// permite y fail-closed rechaza (el llamador responde 503, no 401)
+func (rl *RateLimiter) CheckBackend(ctx context.Context, key string) (allowed bool, retryAfter time.Duration, backendFailed bool) {
+	rl.mu.RLock()
+	backend, policy := rl.backend, rl.backendPolicy
+	rl.mu.RUnlock()
+
+	if backend == nil {
+		return true, 0, false
+	}
+	if policy == "" {
+		policy = BackendFailOpen
+	}
+
+	allowed, retryAfter, err := backend.Check(ctx, key)
+	if err == nil {
+		return allowed, retryAfter, false
+	}
+
+	total := rl.backendErrors.Add(1)
+	if Logger != nil {
+		Logger.ErrorContext(ctx, amslog.Event{
+			Name:    "RATE_LIMIT_BACKEND_ERROR",
+			Message: "Rate limiter backend failed, applying failure policy",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    "RateLimitBackendError",
+				Message: err.Error(),
+			},
+			Fields: map[string]interface{}{
+				"rate_limit.key_type":       strings.SplitN(key, ":", 2)[0],
+				"rate_limit.policy":         string(policy),
+				"rate_limit.backend_errors": total,
+			},
+		})
+	}
+
+	if policy == BackendFailClosed {
+		return false, BackendFailureRetryAfter, true
+	}
+	return true, 0, true
+}
+
+// SetRateLimitBackend configura el backend compartido del rate limiter
+func (am *AuthMiddleware) SetRateLimitBackend(backend RateLimitBackend) {
+	am.rateLimiter.SetBackend(backend)
+}
+
+// SetRateLimitBackendPolicy configura la política ante errores del backend del rate limiter
+func (am *AuthMiddleware) SetRateLimitBackendPolicy(policy BackendFailurePolicy) {
+	am.rateLimiter.SetBackendFailurePolicy(policy)
+}
+
+// checkRateLimitBackend aplica el límite del backend compartido a la clave y responde si la
+// request queda rechazada: 401 si se supera el límite, 503 si el backend falla en modo fail-closed
+func (am *AuthMiddleware) checkRateLimitBackend(w http.ResponseWriter, r *http.Request, key string, token ...string) bool {
+	allowed, retryAfter, backendFailed := am.rateLimiter.CheckBackend(r.Context(), key)
+	if allowed {
+		return true
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprintf("%.0f", retryAfter.Seconds()))
+	if backendFailed {
+		am.respondError(w, r, http.StatusServiceUnavailable,
+			"rate limiter unavailable, please try again later", "rate_limit_backend_unavailable", token...)
+		return false
+	}
+	am.respondError(w, r, http.StatusUnauthorized,
+		fmt.Sprintf("too many authentication attempts, please try again in %.0f seconds", retryAfter.Seconds()),
+		"rate_limit_shared", token...)
+	return false
+}
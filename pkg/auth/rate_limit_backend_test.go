@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeRateLimitBackend devuelve siempre el mismo resultado y cuenta las consultas
+type fakeRateLimitBackend struct {
+	allowed bool
+	err     error
+	keys    []string
+}
+
+func (b *fakeRateLimitBackend) Check(ctx context.Context, key string) (bool, time.Duration, error) {
+	b.keys = append(b.keys, key)
+	return b.allowed, time.Minute, b.err
+}
+
+// authErrorType ejecuta el middleware sin credenciales y devuelve el status y el código interno del error
+func authErrorType(t *testing.T, am *AuthMiddleware) (int, string) {
+	t.Helper()
+	handler := am.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected request without credentials to be rejected")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid error body: %v (%s)", err, rec.Body.String())
+	}
+	return rec.Code, body.Error.Code
+}
+
+func TestRateLimitBackendErrorFailOpen(t *testing.T) {
+	am := &AuthMiddleware{rateLimiter: NewRateLimiter()}
+	backend := &fakeRateLimitBackend{err: errors.New("connection refused")}
+	am.SetRateLimitBackend(backend)
+	am.SetRateLimitBackendPolicy(BackendFailOpen)
+
+	// El backend falla pero la request sigue: llega a la extracción de credenciales
+	status, errorType := authErrorType(t, am)
+	if status != http.StatusUnauthorized || errorType != "missing_auth" {
+		t.Errorf("Expected fail-open to continue to credential check, got %d %s", status, errorType)
+	}
+	if am.rateLimiter.BackendErrorCount() != 1 || am.rateLimiter.GetStats()["backend_errors"] != int64(1) {
+		t.Errorf("Expected 1 backend error recorded, got %d", am.rateLimiter.BackendErrorCount())
+	}
+}
+
+func TestRateLimitBackendErrorFailClosed(t *testing.T) {
+	am := &AuthMiddleware{rateLimiter: NewRateLimiter()}
+	am.SetRateLimitBackend(&fakeRateLimitBackend{err: errors.New("connection refused")})
+	am.SetRateLimitBackendPolicy(BackendFailClosed)
+
+	status, errorType := authErrorType(t, am)
+	if status != http.StatusServiceUnavailable || errorType != "rate_limit_backend_unavailable" {
+		t.Errorf("Expected 503 with fail-closed, got %d %s", status, errorType)
+	}
+	if am.rateLimiter.BackendErrorCount() != 1 {
+		t.Errorf("Expected 1 backend error recorded, got %d", am.rateLimiter.BackendErrorCount())
+	}
+}
+
+func TestRateLimitBackendLimitsAndDefaults(t *testing.T) {
+	rl := NewRateLimiter()
+
+	// Sin backend no se consulta nada
+	if allowed, _, failed := rl.CheckBackend(context.Background(), "ip:1.2.3.4"); !allowed || failed {
+		t.Error("Expected requests to be allowed without backend")
+	}
+
+	// Límite superado en el backend: rechazo normal, no cuenta como error
+	backend := &fakeRateLimitBackend{allowed: false}
+	rl.SetBackend(backend)
+	allowed, retryAfter, failed := rl.CheckBackend(context.Background(), "ip:1.2.3.4")
+	if allowed || failed || retryAfter != time.Minute {
+		t.Errorf("Expected backend limit to reject without failure, got allowed=%v failed=%v retry=%v", allowed, failed, retryAfter)
+	}
+	if rl.BackendErrorCount() != 0 || len(backend.keys) != 1 || backend.keys[0] != "ip:1.2.3.4" {
+		t.Errorf("Unexpected backend usage: errors=%d keys=%v", rl.BackendErrorCount(), backend.keys)
+	}
+
+	// Sin política configurada se aplica fail-open
+	backend.err = errors.New("timeout")
+	if allowed, _, failed := rl.CheckBackend(context.Background(), "ip:1.2.3.4"); !allowed || !failed {
+		t.Error("Expected default policy to be fail-open")
+	}
+
+	if ParseBackendFailurePolicy(" Fail-Closed ") != BackendFailClosed || ParseBackendFailurePolicy("bogus") != BackendFailOpen {
+		t.Error("Unexpected policy parsing")
+	}
+}
+
+func TestAuthErrorsUseAnthropicTypes(t *testing.T) {
+	cases := []struct {
+		status    int
+		errorType string
+		expected  string
+	}{
+		{http.StatusUnauthorized, "missing_auth", "authentication_error"},
+		{http.StatusUnauthorized, "quota_exceeded", "rate_limit_error"},
+		{http.StatusUnauthorized, "rate_limit_ip", "rate_limit_error"},
+		{http.StatusServiceUnavailable, "rate_limit_backend_unavailable", "overloaded_error"},
+		{http.StatusInternalServerError, "quota_check_error", "api_error"},
+	}
+	am := &AuthMiddleware{}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		am.respondError(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", nil), c.status, "failed", c.errorType)
+
+		var body struct {
+			Type  string `json:"type"`
+			Error struct {
+				Type string `json:"type"`
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Invalid error body: %v (%s)", err, rec.Body.String())
+		}
+		if rec.Code != c.status || body.Type != "error" || body.Error.Type != c.expected || body.Error.Code != c.errorType {
+			t.Errorf("%s: expected %d %s, got %d %s", c.errorType, c.status, c.expected, rec.Code, rec.Body.String())
+		}
+	}
+}
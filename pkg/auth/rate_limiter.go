@@ -3,6 +3,7 @@ package auth
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +25,11 @@ type RateLimiter struct {
 	blockDuration       time.Duration
 	cleanupInterval     time.Duration
 	windowDuration      time.Duration
+
+	// Backend compartido opcional (ver rate_limit_backend.go)
+	backend       RateLimitBackend
+	backendPolicy BackendFailurePolicy
+	backendErrors atomic.Int64
 }
 
 // IPAttempts rastrea intentos de autenticación de una IP
@@ -258,6 +264,7 @@ func (rl *RateLimiter) GetStats() map[string]interface{} {
 		"max_per_token":    rl.maxAttemptsPerToken,
 		"block_duration":   rl.blockDuration.String(),
 		"window_duration":  rl.windowDuration.String(),
+		"backend_errors":   rl.backendErrors.Load(),
 	}
 }
 
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/database"
+)
+
+// SetTokenPropagationGrace permite durante window tras su IssuedAt los tokens válidos (firma y claims)
+// que aún no aparecen en BD por retraso de replicación. window <= 0 lo desactiva (modo estricto)
+func (am *AuthMiddleware) SetTokenPropagationGrace(window time.Duration) {
+	if window < 0 {
+		window = 0
+	}
+	am.tokenDBGrace = window
+}
+
+// tokenInfoWithinGrace devuelve la información del token construida desde sus claims cuando la BD
+// no lo encuentra pero el token es válido y se emitió hace menos de la ventana de gracia.
+// Sin fila en BD no se puede comprobar la revocación, así que la ventana debe ser corta. Solo aplica
+// a ErrTokenNotFound: un token cuyo perfil está desactivado (ErrProfileInactive) se rechaza siempre
+func (am *AuthMiddleware) tokenInfoWithinGrace(r *http.Request, tokenString string, lookupErr error) (*database.TokenInfo, bool) {
+	if am.tokenDBGrace <= 0 || !errors.Is(lookupErr, database.ErrTokenNotFound) {
+		return nil, false
+	}
+
+	claims, err := am.validateToken(r.Context(), tokenString)
+	if err != nil || claims.IssuedAt == nil {
+		return nil, false
+	}
+	age := time.Since(claims.IssuedAt.Time)
+	if age > am.tokenDBGrace || age < -am.tokenDBGrace {
+		return nil, false
+	}
+
+	if Logger != nil {
+		Logger.WarningContext(r.Context(), amslog.Event{
+			Name:    "TOKEN_PROPAGATION_GRACE",
+			Message: "Token not found in database yet, allowed within propagation grace window",
+			Fields: map[string]interface{}{
+				"user.id":               claims.UserID,
+				"user.jti":              claims.ID,
+				"token.age_ms":          age.Milliseconds(),
+				"token.grace_window_ms": am.tokenDBGrace.Milliseconds(),
+			},
+		})
+	}
+
+	info := &database.TokenInfo{
+		JTI:              claims.ID,
+		UserID:           claims.UserID,
+		Email:            claims.Email,
+		InferenceProfile: claims.DefaultInferenceProfile,
+	}
+	if claims.ExpiresAt != nil {
+		info.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return info, true
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testTokenGraceSecret = "test-secret-key-with-at-least-32-characters"
+
+// newTokenIssuedAt firma un token del usuario emitido en issuedAt
+func newTokenIssuedAt(t *testing.T, secret string, issuedAt time.Time) string {
+	t.Helper()
+	claims := JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-1",
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(24 * time.Hour)),
+		},
+		UserID:                  "user-1",
+		DefaultInferenceProfile: "eu.anthropic.claude-sonnet-4-5-20250929-v1:0",
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestTokenPropagationGraceWithinWindow(t *testing.T) {
+	am := &AuthMiddleware{jwtConfig: JWTConfig{SecretKey: testTokenGraceSecret}}
+	am.SetTokenPropagationGrace(30 * time.Second)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	token := newTokenIssuedAt(t, testTokenGraceSecret, time.Now().Add(-5*time.Second))
+	info, ok := am.tokenInfoWithinGrace(req, token, database.ErrTokenNotFound)
+	if !ok {
+		t.Fatal("Expected freshly minted token to be allowed within the grace window")
+	}
+	if info.UserID != "user-1" || info.JTI != "jti-1" || info.IsRevoked {
+		t.Errorf("Unexpected token info from claims: %+v", info)
+	}
+	if info.InferenceProfile != "eu.anthropic.claude-sonnet-4-5-20250929-v1:0" {
+		t.Errorf("Expected inference profile from claims, got %q", info.InferenceProfile)
+	}
+
+	// La firma se sigue verificando dentro de la ventana
+	forged := newTokenIssuedAt(t, "another-secret-key-with-at-least-32-chars", time.Now())
+	if _, ok := am.tokenInfoWithinGrace(req, forged, database.ErrTokenNotFound); ok {
+		t.Error("Expected token with invalid signature to be rejected")
+	}
+
+	// Solo aplica cuando el token no existe en BD, no a otros errores
+	if _, ok := am.tokenInfoWithinGrace(req, token, errors.New("error validating token: connection refused")); ok {
+		t.Error("Expected database errors not to be covered by the grace window")
+	}
+
+	// Un perfil desactivado no es un retraso de replicación: se rechaza aunque el token sea reciente
+	if _, ok := am.tokenInfoWithinGrace(req, token, database.ErrProfileInactive); ok {
+		t.Error("Expected tokens of inactive profiles not to be covered by the grace window")
+	}
+}
+
+func TestTokenPropagationGraceOutsideWindow(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	token := newTokenIssuedAt(t, testTokenGraceSecret, time.Now().Add(-2*time.Minute))
+
+	am := &AuthMiddleware{jwtConfig: JWTConfig{SecretKey: testTokenGraceSecret}}
+	am.SetTokenPropagationGrace(30 * time.Second)
+	if _, ok := am.tokenInfoWithinGrace(req, token, database.ErrTokenNotFound); ok {
+		t.Error("Expected token issued before the grace window to require the database row")
+	}
+
+	// Sin configurar (por defecto) el modo es estricto incluso para tokens recién emitidos
+	am = &AuthMiddleware{jwtConfig: JWTConfig{SecretKey: testTokenGraceSecret}}
+	fresh := newTokenIssuedAt(t, testTokenGraceSecret, time.Now())
+	if _, ok := am.tokenInfoWithinGrace(req, fresh, database.ErrTokenNotFound); ok {
+		t.Error("Expected strict mode by default")
+	}
+}
@@ -48,6 +48,7 @@ func (am *AuthMiddleware) RecordEarlyError(r *http.Request, userID, email, team,
 		ProcessingTimeMS:    0,
 		ResponseStatus:      errorType, // "token_invalid", "quota_exceeded", etc.
 		ErrorMessage:        errorMessage,
+		RequestID:           amslog.RequestIDFromContext(r.Context()),
 	}
 	
 	// Registrar de forma asíncrona
@@ -0,0 +1,287 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+	"bedrock-proxy-test/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultBatchMaxRequests = 100
+	DefaultBatchConcurrency = 4
+)
+
+// batchReleaseTimeout limita la devolución de la cuota de las requests fallidas de un batch
+const batchReleaseTimeout = 5 * time.Second
+
+// batchQuotaReserver reserva de una vez la cuota de todas las requests de un batch y devuelve la de las
+// que fallan (implementado por database.Database; sustituible en tests)
+type batchQuotaReserver interface {
+	ReserveRequests(ctx context.Context, cognitoUserID string, count int) (*database.QuotaCheckResult, error)
+	ReleaseRequests(ctx context.Context, cognitoUserID string, count int) error
+}
+
+// BatchResult es el resultado de una request del batch: status HTTP y respuesta (mensaje o error Anthropic)
+type BatchResult struct {
+	Index    int             `json:"index"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+// BatchResponse es la respuesta de /v1/messages/batch
+type BatchResponse struct {
+	BatchID string        `json:"batch_id"`
+	Results []BatchResult `json:"results"`
+}
+
+// batchIDContextKey guarda en el contexto de cada request del batch el ID del batch
+type batchIDContextKey struct{}
+
+// batchIDFromContext devuelve el ID del batch al que pertenece la request (vacío fuera de un batch)
+func batchIDFromContext(ctx context.Context) string {
+	batchID, _ := ctx.Value(batchIDContextKey{}).(string)
+	return batchID
+}
+
+// HandleBatch procesa un array de requests Anthropic (sin streaming) con concurrencia limitada.
+// La cuota de todo el batch se reserva antes de empezar: o caben todas las requests o no se procesa ninguna.
+// Al terminar se devuelve la cuota de las requests que han fallado.
+// Cada request se registra como una métrica independiente con su batch_id (el X-Conversation-ID del cliente se conserva).
+// La ruta del batch no pasa por RequestDeadlineMiddleware: cada request tiene su propio REQUEST_TIMEOUT_SECONDS
+func (this *BedrockClient) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	batchID := uuid.New().String()
+	startTime := time.Now()
+
+	if this.IsMaintenanceMode() {
+		writeMaintenanceResponse(w)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrorResponse(w, ErrCodeInvalidBatch, "Failed to read request: "+err.Error())
+		return
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		writeErrorResponse(w, ErrCodeInvalidBatch, "Batch body must be a JSON array of requests: "+err.Error())
+		return
+	}
+	if len(items) == 0 {
+		writeErrorResponse(w, ErrCodeInvalidBatch, "Batch must contain at least one request")
+		return
+	}
+	if len(items) > this.batchMaxRequests() {
+		writeErrorResponse(w, ErrCodeBatchTooLarge,
+			fmt.Sprintf("Batch has %d requests (max: %d)", len(items), this.batchMaxRequests()))
+		return
+	}
+
+	user, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		writeErrorResponse(w, ErrCodeNoInferenceProfile, "User must have default_inference_profile configured in JWT")
+		return
+	}
+
+	if code, message := this.reserveBatchQuota(ctx, user, len(items)); code != "" {
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventQuotaExceeded,
+			Message: "Batch rejected: quota cannot cover the whole batch",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    "QuotaError",
+				Message: message,
+				Code:    string(code),
+			},
+			Fields: map[string]interface{}{
+				"batch.id":   batchID,
+				"batch.size": len(items),
+				"user.id":    user.UserID,
+			},
+		})
+		writeErrorResponse(w, code, message)
+		return
+	}
+
+	Logger.InfoContext(ctx, amslog.Event{
+		Name:    EventBatchStart,
+		Message: "Batch accepted",
+		Fields: map[string]interface{}{
+			"batch.id":          batchID,
+			"batch.size":        len(items),
+			"batch.concurrency": this.batchConcurrency(),
+			"user.id":           user.UserID,
+		},
+	})
+
+	// Todas las requests llegan con el batch: la espera de cada una hasta obtener hueco en el
+	// semáforo es su fase queue_wait
+	results := make([]BatchResult, len(items))
+	queueWaits := make([]time.Duration, len(items))
+	semaphore := make(chan struct{}, this.batchConcurrency())
+	queuedAt := time.Now()
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		queueWaits[i] = time.Since(queuedAt)
+		batchQueueWaits.Observe(queueWaits[i])
+		go func(index int, item json.RawMessage) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			itemReq := r.WithContext(WithQueueWait(r.Context(), queueWaits[index]))
+			results[index] = this.processBatchItem(itemReq, batchID, index, item)
+		}(i, item)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Status != http.StatusOK {
+			failed++
+		}
+	}
+	refunded := this.releaseBatchQuota(ctx, user, batchID, len(items), failed)
+	Logger.InfoContext(ctx, amslog.Event{
+		Name:       EventBatchComplete,
+		Message:    "Batch completed",
+		Outcome:    amslog.OutcomeSuccess,
+		DurationMs: time.Since(startTime).Milliseconds(),
+		Fields: map[string]interface{}{
+			"batch.id":                batchID,
+			"batch.size":              len(items),
+			"batch.failed":            failed,
+			"batch.quota_refunded":    refunded,
+			"batch.queue_wait_p95_ms": queueWaitPercentile(queueWaits, 95).Milliseconds(),
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchResponse{BatchID: batchID, Results: results})
+}
+
+// reserveBatchQuota reserva la cuota del batch. El middleware de autenticación ya ha contado
+// la request HTTP, así que se reservan las n-1 restantes. Sin BD no hay cuota que reservar
+func (this *BedrockClient) reserveBatchQuota(ctx context.Context, user *auth.UserContext, size int) (ErrorCode, string) {
+	if this.batchQuota == nil || size <= 1 {
+		return "", ""
+	}
+
+	result, err := this.batchQuota.ReserveRequests(ctx, user.UserID, size-1)
+	if err != nil {
+		return ErrCodeQuotaCheckFailed, err.Error()
+	}
+	if !result.Allowed {
+		return ErrCodeBatchQuotaExceeded, result.BlockReason
+	}
+	return "", ""
+}
+
+// releaseBatchQuota devuelve la cuota reservada de las requests del batch que han fallado y retorna
+// cuántas se han devuelto. Como con /v1/messages, la request HTTP contada por el middleware no se
+// devuelve: como mucho se devuelven las size-1 reservadas por reserveBatchQuota
+func (this *BedrockClient) releaseBatchQuota(ctx context.Context, user *auth.UserContext, batchID string, size, failed int) int {
+	count := min(failed, size-1)
+	if this.batchQuota == nil || count <= 0 {
+		return 0
+	}
+
+	// Con un contexto propio: si el cliente se ha desconectado la cuota debe devolverse igualmente
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), batchReleaseTimeout)
+	defer cancel()
+	if err := this.batchQuota.ReleaseRequests(releaseCtx, user.UserID, count); err != nil {
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventQuotaUpdate,
+			Message: "Could not refund the quota of failed batch requests",
+			Error: &amslog.ErrorInfo{
+				Type:    "QuotaError",
+				Message: err.Error(),
+				Code:    string(ErrCodeQuotaCheckFailed),
+			},
+			Fields: map[string]interface{}{
+				"batch.id":     batchID,
+				"batch.failed": failed,
+				"user.id":      user.UserID,
+			},
+		})
+		return 0
+	}
+	return count
+}
+
+// processBatchItem ejecuta una request del batch por el mismo path que /v1/messages (Converse
+// con respuesta agregada) y devuelve su resultado. Los errores de una request no afectan al resto
+func (this *BedrockClient) processBatchItem(r *http.Request, batchID string, index int, item json.RawMessage) BatchResult {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(item, &payload); err != nil {
+		return batchErrorResult(index, ErrCodeInvalidJSON, "Failed to parse request: "+err.Error())
+	}
+	payload["stream"] = true
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return batchErrorResult(index, ErrCodeInvalidJSON, "Failed to encode request: "+err.Error())
+	}
+
+	// Deadline propio por request: un deadline único para todo el batch cortaría las últimas requests
+	ctx := context.WithValue(r.Context(), batchIDContextKey{}, batchID)
+	if this.config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this.config.RequestTimeout)
+		defer cancel()
+	}
+
+	itemReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return batchErrorResult(index, ErrCodeInvalidBatch, err.Error())
+	}
+	itemReq.Header = r.Header.Clone()
+	itemReq.Header.Set("Content-Type", "application/json")
+	itemReq.RemoteAddr = r.RemoteAddr
+
+	// El SSE de la request se acumula en memoria y se convierte en una única respuesta JSON.
+	// Los errores previos al stream (y las respuestas ya agregadas con STREAMING_DISABLED) son JSON
+	// Los items comparten las cabeceras del batch, así que no pasan por la deduplicación de Idempotency-Key
+	stream := newStreamAggregator()
+	this.handleProxy(stream, itemReq)
+	if stream.Header().Get("Content-Type") == "application/json" {
+		return BatchResult{Index: index, Status: stream.statusCode, Response: json.RawMessage(stream.buffer.Bytes())}
+	}
+
+	response := newStreamAggregator()
+	stream.writeResponse(response, http.StatusBadGateway)
+	return BatchResult{Index: index, Status: response.statusCode, Response: json.RawMessage(response.buffer.Bytes())}
+}
+
+// batchErrorResult construye el resultado de una request del batch que falla antes de procesarse
+func batchErrorResult(index int, code ErrorCode, message string) BatchResult {
+	response := newStreamAggregator()
+	writeErrorResponse(response, code, message)
+	return BatchResult{Index: index, Status: response.statusCode, Response: json.RawMessage(response.buffer.Bytes())}
+}
+
+func (this *BedrockClient) batchMaxRequests() int {
+	if this.config.BatchMaxRequests > 0 {
+		return this.config.BatchMaxRequests
+	}
+	return DefaultBatchMaxRequests
+}
+
+func (this *BedrockClient) batchConcurrency() int {
+	if this.config.BatchConcurrency > 0 {
+		return this.config.BatchConcurrency
+	}
+	return DefaultBatchConcurrency
+}
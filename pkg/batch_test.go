@@ -0,0 +1,306 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// fakeQuotaReserver simula la reserva atómica de cuota: admite mientras quepa en remaining
+type fakeQuotaReserver struct {
+	remaining int
+	reserved  []int
+	released  []int
+}
+
+func (f *fakeQuotaReserver) ReleaseRequests(ctx context.Context, cognitoUserID string, count int) error {
+	f.released = append(f.released, count)
+	f.remaining += count
+	return nil
+}
+
+func (f *fakeQuotaReserver) ReserveRequests(ctx context.Context, cognitoUserID string, count int) (*database.QuotaCheckResult, error) {
+	f.reserved = append(f.reserved, count)
+	if count > f.remaining {
+		return &database.QuotaCheckResult{Allowed: false, BlockReason: "daily request quota exceeded"}, nil
+	}
+	f.remaining -= count
+	return &database.QuotaCheckResult{Allowed: true}, nil
+}
+
+func newBatchTestClient(t *testing.T, calls *atomic.Int32) *BedrockClient {
+	body := newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"ok"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":5,"outputTokens":1,"totalTokens":6},"metrics":{"latencyMs":10}}`},
+	})
+
+	client := newTestBedrockClient()
+	client.client = bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		})},
+	})
+	return client
+}
+
+func decodeBatchResponse(t *testing.T, rec *httptest.ResponseRecorder) BatchResponse {
+	t.Helper()
+	var response BatchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid batch response: %v (%s)", err, rec.Body.String())
+	}
+	return response
+}
+
+func TestHandleBatchPartialFailure(t *testing.T) {
+	setupTestLogger(t)
+	var calls atomic.Int32
+	client := newBatchTestClient(t, &calls)
+
+	rec := httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(`[
+		{"messages": [{"role": "user", "content": "hola"}]},
+		{"top_k": -1, "messages": [{"role": "user", "content": "hola"}]},
+		"not a request"
+	]`))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a batch with partial failures, got %d: %s", rec.Code, rec.Body.String())
+	}
+	response := decodeBatchResponse(t, rec)
+	if response.BatchID == "" || len(response.Results) != 3 {
+		t.Fatalf("Expected batch ID and 3 results, got %+v", response)
+	}
+
+	ok := response.Results[0]
+	if ok.Index != 0 || ok.Status != http.StatusOK || !strings.Contains(string(ok.Response), `"text":"ok"`) {
+		t.Errorf("Expected successful first result, got %d %s", ok.Status, ok.Response)
+	}
+	if r := response.Results[1]; r.Status != http.StatusBadRequest || !strings.Contains(string(r.Response), string(ErrCodeInvalidTopK)) {
+		t.Errorf("Expected INVALID_TOP_K for second result, got %d %s", r.Status, r.Response)
+	}
+	if r := response.Results[2]; r.Status != http.StatusBadRequest || !strings.Contains(string(r.Response), string(ErrCodeInvalidJSON)) {
+		t.Errorf("Expected INVALID_JSON for third result, got %d %s", r.Status, r.Response)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected only the valid request to reach Bedrock, got %d calls", calls.Load())
+	}
+}
+
+func TestHandleBatchReservesQuotaForWholeBatch(t *testing.T) {
+	setupTestLogger(t)
+	var calls atomic.Int32
+	client := newBatchTestClient(t, &calls)
+	reserver := &fakeQuotaReserver{remaining: 2}
+	client.batchQuota = reserver
+
+	batch := `[{"messages": [{"role": "user", "content": "a"}]}, {"messages": [{"role": "user", "content": "b"}]}, {"messages": [{"role": "user", "content": "c"}]}]`
+
+	// La request HTTP ya la cuenta el middleware: se reservan las 2 restantes
+	rec := httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(batch))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected batch within quota to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(reserver.reserved) != 1 || reserver.reserved[0] != 2 {
+		t.Errorf("Expected a single reservation of 2 requests, got %v", reserver.reserved)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 Bedrock calls, got %d", calls.Load())
+	}
+
+	// Sin cuota para el batch completo no se procesa ninguna request
+	calls.Store(0)
+	rec = httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(batch))
+	if rec.Code != ErrCodeBatchQuotaExceeded.StatusCode() {
+		t.Fatalf("Expected %d when quota cannot cover the batch, got %d", ErrCodeBatchQuotaExceeded.StatusCode(), rec.Code)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("Expected no Bedrock calls for a rejected batch, got %d", calls.Load())
+	}
+}
+
+func TestHandleBatchRefundsQuotaOfFailedRequests(t *testing.T) {
+	setupTestLogger(t)
+	var calls atomic.Int32
+	client := newBatchTestClient(t, &calls)
+	reserver := &fakeQuotaReserver{remaining: 5}
+	client.batchQuota = reserver
+
+	rec := httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(`[
+		{"messages": [{"role": "user", "content": "hola"}]},
+		{"top_k": -1, "messages": [{"role": "user", "content": "hola"}]},
+		"not a request"
+	]`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a batch with partial failures, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(reserver.released) != 1 || reserver.released[0] != 2 || reserver.remaining != 5 {
+		t.Errorf("Expected the 2 failed requests to be refunded, got %v (remaining %d)", reserver.released, reserver.remaining)
+	}
+
+	// La request HTTP la cuenta el middleware: si fallan todas solo se devuelven las reservadas
+	reserver.released = nil
+	rec = httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(`["a", "b", "c"]`))
+	if len(reserver.released) != 1 || reserver.released[0] != 2 {
+		t.Errorf("Expected at most the 2 reserved requests to be refunded, got %v", reserver.released)
+	}
+
+	// Sin fallos no se devuelve nada
+	reserver.released = nil
+	rec = httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(`[{"messages": [{"role": "user", "content": "a"}]}, {"messages": [{"role": "user", "content": "b"}]}]`))
+	if len(reserver.released) != 0 {
+		t.Errorf("Expected no refund for a fully successful batch, got %v", reserver.released)
+	}
+}
+
+func TestHandleBatchRejectsOversizedBatch(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.BatchMaxRequests = 1
+
+	rec := httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(`[{}, {}]`))
+	if rec.Code != ErrCodeBatchTooLarge.StatusCode() {
+		t.Errorf("Expected %d for oversized batch, got %d", ErrCodeBatchTooLarge.StatusCode(), rec.Code)
+	}
+}
+
+func TestHandleBatchRecordsQueueWait(t *testing.T) {
+	logs := setupTestLogger(t)
+	var calls atomic.Int32
+	client := newBatchTestClient(t, &calls)
+	client.config.BatchConcurrency = 1
+	client.config.PhaseTracingSample = 1
+	observed := batchQueueWaitCount()
+
+	// Bedrock lento: con concurrencia 1 las requests siguientes esperan a la anterior
+	body := newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"ok"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+	})
+	client.client = bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			time.Sleep(20 * time.Millisecond)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		})},
+	})
+
+	rec := httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(`[{"messages": [{"role": "user", "content": "a"}]}, {"messages": [{"role": "user", "content": "b"}]}, {"messages": [{"role": "user", "content": "c"}]}]`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected batch to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	queued := 0
+	var p95 float64
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		switch entry["event.name"] {
+		case EventProxyRequestEnd:
+			phases, _ := entry["phases_ms"].(map[string]interface{})
+			if wait, ok := phases["queue_wait"].(float64); ok && wait > 0 {
+				queued++
+			}
+		case EventBatchComplete:
+			p95, _ = entry["batch.queue_wait_p95_ms"].(float64)
+		}
+	}
+	if queued != 2 {
+		t.Errorf("Expected the 2 queued requests to record a non-zero queue_wait, got %d", queued)
+	}
+	if p95 <= 0 {
+		t.Errorf("Expected a non-zero queue wait p95 in %s, got %v", EventBatchComplete, p95)
+	}
+	if got := batchQueueWaitCount() - observed; got != 3 {
+		t.Errorf("Expected the 3 batch requests observed in the queue wait histogram, got %d", got)
+	}
+}
+
+// batchQueueWaitCount devuelve las esperas registradas en el histograma del proceso
+func batchQueueWaitCount() int64 {
+	batchQueueWaits.mu.Lock()
+	defer batchQueueWaits.mu.Unlock()
+	return batchQueueWaits.count
+}
+
+func TestHandleBatchGivesEachRequestItsOwnDeadline(t *testing.T) {
+	setupTestLogger(t)
+	var calls atomic.Int32
+	client := newBatchTestClient(t, &calls)
+	client.config.RequestTimeout = time.Minute
+
+	var missingDeadline atomic.Int32
+	inner := client.client
+	client.client = bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if _, ok := req.Context().Deadline(); !ok {
+				missingDeadline.Add(1)
+			}
+			return inner.Options().HTTPClient.Do(req)
+		})},
+	})
+
+	rec := httptest.NewRecorder()
+	client.HandleBatch(rec, newTestProxyRequest(`[{"messages": [{"role": "user", "content": "a"}]}, {"messages": [{"role": "user", "content": "b"}]}]`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected batch to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := missingDeadline.Load(); got != 0 {
+		t.Errorf("Expected every batch request to run with its own deadline, %d ran without one", got)
+	}
+}
+
+func TestMetricsCaptureRecordsBatchID(t *testing.T) {
+	setupTestLogger(t)
+
+	r := newTestProxyRequest(`{}`)
+	r.Header.Set(ConversationIDHeader, "conv-1")
+	r = r.WithContext(context.WithValue(r.Context(), batchIDContextKey{}, "batch-1"))
+
+	metric := NewMetricsCapture(httptest.NewRecorder(), "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", "req-1", r).GetMetrics()
+	if metric.BatchID != "batch-1" {
+		t.Errorf("Expected batch ID batch-1, got %q", metric.BatchID)
+	}
+	if metric.ConversationID != "conv-1" {
+		t.Errorf("Expected the client conversation ID to be kept, got %q", metric.ConversationID)
+	}
+}
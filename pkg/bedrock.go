@@ -4,19 +4,22 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
@@ -25,6 +28,7 @@ import (
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 	"github.com/google/uuid"
 
@@ -39,6 +43,22 @@ const (
 	DefaultMaxTokens      = 8192
 	DefaultTemperature    = 0.0
 	MaxMessagesPerRequest = 1000
+	DefaultMaxTools       = 128
+	DefaultMaxToolSchema  = 64 * 1024
+)
+
+// DefaultAnthropicVersion es el anthropic_version que acepta Bedrock para los modelos Claude
+// Se usa si AWS_BEDROCK_ANTHROPIC_DEFAULT_VERSION no está configurado
+const DefaultAnthropicVersion = "bedrock-2023-05-31"
+
+// DefaultForwardResponseHeaders son las cabeceras de Bedrock que se reenvían al cliente en el path
+// no-stream si FORWARD_RESPONSE_HEADERS no está configurado
+var DefaultForwardResponseHeaders = []string{"Content-Type"}
+
+// Timeouts por defecto de la request completa y del post-processing de métricas
+const (
+	DefaultRequestTimeout     = 10 * time.Minute
+	DefaultPostProcessTimeout = 30 * time.Second
 )
 
 type BedrockConfig struct {
@@ -54,6 +74,56 @@ type BedrockConfig struct {
 	ReasonBudgetTokens       int               `json:"reason_budget_tokens"`
 	MaxTokens                int               `json:"max_tokens"`
 	ForcePromptCaching       bool              `json:"force_prompt_caching"`
+	RequireMetricsForStream  bool              `json:"require_metrics_for_stream"`
+	RequestTimeout           time.Duration     `json:"request_timeout"`
+	PostProcessTimeout       time.Duration     `json:"post_process_timeout"`
+	MaxTools                 int               `json:"max_tools"`
+	MaxToolSchemaBytes       int               `json:"max_tool_schema_bytes"`
+	MaxSystemBlocks          int               `json:"max_system_blocks"`
+	MaxSystemBytes           int               `json:"max_system_bytes"`
+	LatencyOptimized         bool              `json:"latency_optimized"`
+	MaxToolResultBytes       int               `json:"max_tool_result_bytes"`
+	RejectNonStreamTools     bool              `json:"reject_non_stream_tools"`
+	NonStreamRawHTTP         bool              `json:"non_stream_raw_http"`
+	NativeTools              bool              `json:"native_tools"`
+	NativeToolModels         []string          `json:"native_tool_models,omitempty"`
+	ToolPromptFormat         ToolPromptFormat  `json:"tool_prompt_format"`
+	ToolFormatByUserAgent    ToolFormatRules   `json:"tool_format_by_user_agent,omitempty"`
+	XMLBufferDisabled        bool              `json:"xml_buffer_disabled"`
+	XMLBufferByUserAgent     XMLBufferRules    `json:"xml_buffer_by_user_agent,omitempty"`
+	ReasoningModels          []string          `json:"reasoning_models,omitempty"`
+	HedgingEnabled           bool              `json:"hedging_enabled"`
+	HedgingDelay             time.Duration     `json:"hedging_delay"`
+	HedgingPercentile        int               `json:"hedging_percentile"`
+	TLSMinVersion            uint16            `json:"tls_min_version"`
+	TLSCipherSuites          []uint16          `json:"tls_cipher_suites,omitempty"`
+	SystemPromptInjections   TeamSystemPrompts `json:"system_prompt_injections,omitempty"`
+	StreamingDisabled        bool              `json:"streaming_disabled"`
+	StreamWriteTimeout       time.Duration     `json:"stream_write_timeout"`
+	SlowRequestThreshold     time.Duration     `json:"slow_request_threshold"`
+	SSEMaxDeltaBytes         int               `json:"sse_max_delta_bytes"`
+	BedrockTimeout           time.Duration     `json:"bedrock_timeout"`
+	StreamRetryMax           int               `json:"stream_retry_max"`
+	StreamRetryBaseDelay     time.Duration     `json:"stream_retry_base_delay"`
+	ModelDefaultMaxTokens    map[string]int    `json:"model_default_max_tokens,omitempty"`
+	ModelMinCacheTokens      map[string]int    `json:"model_min_cache_tokens,omitempty"`
+	ContextTrimMaxTokens     int               `json:"context_trim_max_tokens"`
+	MaintenanceMode          bool              `json:"maintenance_mode"`
+	StripRequestFields       []string          `json:"strip_request_fields,omitempty"`
+	BatchMaxRequests         int               `json:"batch_max_requests"`
+	BatchConcurrency         int               `json:"batch_concurrency"`
+	StrictVersionMappings    bool              `json:"strict_version_mappings"`
+	IdempotencyTTL           time.Duration     `json:"idempotency_ttl"`
+	IdempotencyMaxBodyBytes  int               `json:"idempotency_max_body_bytes"`
+	ModelListCacheMaxAge     time.Duration     `json:"model_list_cache_max_age"`
+	PhaseTracingSample       float64           `json:"phase_tracing_sample"`
+	AllowedProfileRegions    []string          `json:"allowed_profile_regions,omitempty"`
+	ForwardResponseHeaders   []string          `json:"forward_response_headers,omitempty"`
+	MaxRequestCostUSD        float64           `json:"max_request_cost_usd"`
+	ProjectIDHeader          string            `json:"project_id_header"`
+	ProjectIDMaxLength       int               `json:"project_id_max_length"`
+	RequestHashLog           bool              `json:"request_hash_log"`
+	RequestHashHeader        bool              `json:"request_hash_header"`
 	DEBUG                    bool              `json:"debug,omitempty"`
 }
 
@@ -86,6 +156,12 @@ func LoadBedrockConfigWithEnv() *BedrockConfig {
 		forcePromptCaching = forceCachingStr == "true"
 	}
 	
+	// RequireMetricsForStream por defecto es true (no aceptar trabajo que no se puede facturar)
+	requireMetricsForStream := true
+	if requireMetricsStr := os.Getenv("AWS_BEDROCK_REQUIRE_METRICS_FOR_STREAM"); requireMetricsStr != "" {
+		requireMetricsForStream = requireMetricsStr == "true"
+	}
+	
 	config := &BedrockConfig{
 		AccessKey:                os.Getenv("AWS_BEDROCK_ACCESS_KEY"),
 		SecretKey:                os.Getenv("AWS_BEDROCK_SECRET_KEY"),
@@ -99,6 +175,42 @@ func LoadBedrockConfigWithEnv() *BedrockConfig {
 		ReasonBudgetTokens:       1024,
 		MaxTokens:                0,
 		ForcePromptCaching:       forcePromptCaching,
+		RequireMetricsForStream:  requireMetricsForStream,
+		RequestTimeout:           DefaultRequestTimeout,
+		BedrockTimeout:           loadBedrockTimeout(),
+		PostProcessTimeout:       DefaultPostProcessTimeout,
+		MaxTools:                 DefaultMaxTools,
+		MaxToolSchemaBytes:       DefaultMaxToolSchema,
+		MaxSystemBlocks:          DefaultMaxSystemBlocks,
+		MaxSystemBytes:           DefaultMaxSystemBytes,
+		LatencyOptimized:         os.Getenv("LATENCY_OPTIMIZED") == "true",
+		RejectNonStreamTools:     os.Getenv("AWS_BEDROCK_REJECT_NONSTREAM_TOOLS") == "true",
+		NonStreamRawHTTP:         os.Getenv("AWS_BEDROCK_NONSTREAM_RAW_HTTP") == "true",
+		NativeTools:              os.Getenv("BEDROCK_NATIVE_TOOLS") == "true",
+		NativeToolModels:         splitCommaList(os.Getenv("NATIVE_TOOL_MODELS")),
+		ReasoningModels:          splitCommaList(os.Getenv("REASONING_MODELS")),
+		HedgingEnabled:           os.Getenv("HEDGING_ENABLED") == "true",
+		HedgingDelay:             DefaultHedgingDelay,
+		HedgingPercentile:        DefaultHedgingPercentile,
+		TLSMinVersion:            DefaultTLSMinVersion,
+		StreamingDisabled:        os.Getenv("STREAMING_DISABLED") == "true",
+		StreamWriteTimeout:       DefaultStreamWriteTimeout,
+		StreamRetryMax:           DefaultStreamRetryMax,
+		StreamRetryBaseDelay:     DefaultStreamRetryBaseDelay,
+		MaintenanceMode:          os.Getenv("MAINTENANCE_MODE") == "true",
+		StripRequestFields:       splitCommaList(os.Getenv("STRIP_REQUEST_FIELDS")),
+		ForwardResponseHeaders:   splitCommaList(os.Getenv("FORWARD_RESPONSE_HEADERS")),
+		AllowedProfileRegions:    splitCommaList(os.Getenv("ALLOWED_PROFILE_REGIONS")),
+		BatchMaxRequests:         DefaultBatchMaxRequests,
+		BatchConcurrency:         DefaultBatchConcurrency,
+		StrictVersionMappings:    os.Getenv("AWS_BEDROCK_STRICT_VERSION_MAPPINGS") == "true",
+		IdempotencyMaxBodyBytes:  DefaultIdempotencyMaxBodyBytes,
+		ModelListCacheMaxAge:     DefaultModelListCacheMaxAge,
+		PhaseTracingSample:       1,
+		ProjectIDHeader:          DefaultProjectIDHeader,
+		ProjectIDMaxLength:       DefaultProjectIDMaxLength,
+		RequestHashLog:           os.Getenv("DEBUG_REQUEST_HASH") == "true",
+		RequestHashHeader:        os.Getenv("DEBUG_REQUEST_HASH_HEADER") == "true",
 		DEBUG:                    os.Getenv("AWS_BEDROCK_DEBUG") == "true",
 	}
 
@@ -116,6 +228,216 @@ func LoadBedrockConfigWithEnv() *BedrockConfig {
 		}
 	}
 
+	// Sin anthropic_version Bedrock rechaza la request con un error poco claro: usar el default conocido
+	if config.AnthropicDefaultVersion == "" {
+		config.AnthropicDefaultVersion = DefaultAnthropicVersion
+		logMissingAnthropicVersion()
+	}
+
+	// Formato de las tools en el system prompt: default global y reglas por User-Agent (Cline usa texto)
+	config.ToolPromptFormat = ToolPromptFormatJSON
+	if format, ok := ParseToolPromptFormat(os.Getenv("TOOL_PROMPT_FORMAT")); ok {
+		config.ToolPromptFormat = format
+	}
+	config.ToolFormatByUserAgent = parseToolFormatByUserAgent(getEnvOrDefault("TOOL_PROMPT_FORMAT_BY_USER_AGENT", DefaultToolPromptFormatByUserAgent))
+
+	// Buffer XML por cliente: solo los que parsean tools en XML (Cline) lo necesitan
+	if size, ok := parseXMLBufferSize(os.Getenv("XML_BUFFER_DEFAULT")); ok && size == xmlBufferOff {
+		config.XMLBufferDisabled = true
+	}
+	config.XMLBufferByUserAgent = parseXMLBufferByUserAgent(os.Getenv("XML_BUFFER_BY_USER_AGENT"))
+
+	// max_tokens por defecto por modelo (fragmento de model ID=tokens) cuando la request no lo envía
+	config.ModelDefaultMaxTokens = parseModelIntMap(os.Getenv("MODEL_DEFAULT_MAX_TOKENS"))
+
+	// Mínimo de tokens cacheables por modelo (opt-in): los cache points forzados con un prefijo menor se descartan
+	config.ModelMinCacheTokens = parseModelIntMap(os.Getenv("MODEL_MIN_CACHE_TOKENS"))
+
+	// Deadline de la request completa (0 desactiva el límite)
+	requestTimeout := os.Getenv("REQUEST_TIMEOUT_SECONDS")
+	if len(requestTimeout) > 0 {
+		if seconds, err := strconv.Atoi(requestTimeout); err == nil && seconds >= 0 {
+			config.RequestTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	postProcessTimeout := os.Getenv("POST_PROCESS_TIMEOUT_SECONDS")
+	if len(postProcessTimeout) > 0 {
+		if seconds, err := strconv.Atoi(postProcessTimeout); err == nil && seconds > 0 {
+			config.PostProcessTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Límites de tools (0 desactiva el límite)
+	maxTools := os.Getenv("MAX_TOOLS")
+	if len(maxTools) > 0 {
+		if limit, err := strconv.Atoi(maxTools); err == nil && limit >= 0 {
+			config.MaxTools = limit
+		}
+	}
+
+	batchMaxRequests := os.Getenv("BATCH_MAX_REQUESTS")
+	if len(batchMaxRequests) > 0 {
+		if limit, err := strconv.Atoi(batchMaxRequests); err == nil && limit > 0 {
+			config.BatchMaxRequests = limit
+		}
+	}
+
+	// Techo de coste por request en USD (0 desactiva)
+	maxRequestCost := os.Getenv("MAX_REQUEST_COST_USD")
+	if len(maxRequestCost) > 0 {
+		if usd, err := strconv.ParseFloat(maxRequestCost, 64); err == nil && usd >= 0 {
+			config.MaxRequestCostUSD = usd
+		}
+	}
+
+	// Deduplicación por Idempotency-Key (0 desactiva)
+	idempotencyTTL := os.Getenv("IDEMPOTENCY_TTL_SECONDS")
+	if len(idempotencyTTL) > 0 {
+		if seconds, err := strconv.Atoi(idempotencyTTL); err == nil && seconds >= 0 {
+			config.IdempotencyTTL = time.Duration(seconds) * time.Second
+		}
+	}
+	if maxBody, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_MAX_BODY_BYTES")); err == nil && maxBody >= 0 {
+		config.IdempotencyMaxBodyBytes = maxBody
+	}
+
+	// Tiempo máximo bloqueado en una escritura del stream antes de abortarlo (0 desactiva)
+	streamWriteTimeout := os.Getenv("STREAM_WRITE_TIMEOUT_SECONDS")
+	if len(streamWriteTimeout) > 0 {
+		if seconds, err := strconv.Atoi(streamWriteTimeout); err == nil && seconds >= 0 {
+			config.StreamWriteTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Reintentos de ConverseStream ante throttling antes de empezar el stream (0 desactiva)
+	if retries, err := strconv.Atoi(os.Getenv("BEDROCK_STREAM_RETRY_MAX")); err == nil && retries >= 0 {
+		config.StreamRetryMax = retries
+	}
+	if delayMs, err := strconv.Atoi(os.Getenv("BEDROCK_STREAM_RETRY_BASE_DELAY_MS")); err == nil && delayMs > 0 {
+		config.StreamRetryBaseDelay = time.Duration(delayMs) * time.Millisecond
+	}
+
+	// Duración a partir de la cual una request se registra como SLOW_REQUEST (0 desactiva)
+	if thresholdMs, err := strconv.Atoi(os.Getenv("SLOW_REQUEST_THRESHOLD_MS")); err == nil && thresholdMs > 0 {
+		config.SlowRequestThreshold = time.Duration(thresholdMs) * time.Millisecond
+	}
+
+	// Etiquetado de gasto por proyecto: header de la petición y longitud máxima del ID
+	if header := strings.TrimSpace(os.Getenv("PROJECT_ID_HEADER")); header != "" {
+		config.ProjectIDHeader = header
+	}
+	if maxLength, err := strconv.Atoi(os.Getenv("PROJECT_ID_MAX_LENGTH")); err == nil && maxLength > 0 {
+		config.ProjectIDMaxLength = maxLength
+	}
+
+	// Tamaño máximo del texto de un content_block_delta; los deltas mayores se parten (0 desactiva)
+	if maxDeltaBytes, err := strconv.Atoi(os.Getenv("SSE_MAX_DELTA_BYTES")); err == nil && maxDeltaBytes > 0 {
+		config.SSEMaxDeltaBytes = maxDeltaBytes
+	}
+
+	// Antigüedad máxima de la última lista de modelos de Bedrock servida como fallback (0 desactiva)
+	modelListCacheMaxAge := os.Getenv("MODEL_LIST_CACHE_MAX_AGE_SECONDS")
+	if len(modelListCacheMaxAge) > 0 {
+		if seconds, err := strconv.Atoi(modelListCacheMaxAge); err == nil && seconds >= 0 {
+			config.ModelListCacheMaxAge = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Fracción de requests con timing por fase y REQUEST_SUMMARY (0-1; el resto solo logs de inicio/fin)
+	phaseTracingSample := os.Getenv("PHASE_TRACING_SAMPLE")
+	if len(phaseTracingSample) > 0 {
+		if rate, err := strconv.ParseFloat(phaseTracingSample, 64); err == nil && rate >= 0 && rate <= 1 {
+			config.PhaseTracingSample = rate
+		}
+	}
+
+	batchConcurrency := os.Getenv("BATCH_CONCURRENCY")
+	if len(batchConcurrency) > 0 {
+		if limit, err := strconv.Atoi(batchConcurrency); err == nil && limit > 0 {
+			config.BatchConcurrency = limit
+		}
+	}
+
+	maxToolSchemaBytes := os.Getenv("MAX_TOOL_SCHEMA_BYTES")
+	if len(maxToolSchemaBytes) > 0 {
+		if limit, err := strconv.Atoi(maxToolSchemaBytes); err == nil && limit >= 0 {
+			config.MaxToolSchemaBytes = limit
+		}
+	}
+
+	// Recorte opt-in del historial para que el input estimado quepa en este presupuesto (0 desactiva)
+	contextTrimMaxTokens := os.Getenv("CONTEXT_TRIM_MAX_TOKENS")
+	if len(contextTrimMaxTokens) > 0 {
+		if limit, err := strconv.Atoi(contextTrimMaxTokens); err == nil && limit >= 0 {
+			config.ContextTrimMaxTokens = limit
+		}
+	}
+
+	// Límites del system del cliente (0 desactiva el límite)
+	maxSystemBlocks := os.Getenv("MAX_SYSTEM_BLOCKS")
+	if len(maxSystemBlocks) > 0 {
+		if limit, err := strconv.Atoi(maxSystemBlocks); err == nil && limit >= 0 {
+			config.MaxSystemBlocks = limit
+		}
+	}
+
+	maxSystemBytes := os.Getenv("MAX_SYSTEM_BYTES")
+	if len(maxSystemBytes) > 0 {
+		if limit, err := strconv.Atoi(maxSystemBytes); err == nil && limit >= 0 {
+			config.MaxSystemBytes = limit
+		}
+	}
+
+	// Truncado de tool_result muy grandes (0 desactiva el truncado)
+	maxToolResultBytes := os.Getenv("MAX_TOOL_RESULT_BYTES")
+	if len(maxToolResultBytes) > 0 {
+		if limit, err := strconv.Atoi(maxToolResultBytes); err == nil && limit >= 0 {
+			config.MaxToolResultBytes = limit
+		}
+	}
+
+	// Hedging no-stream: delay fijo hasta tener muestras y percentil de latencia (0 usa siempre el delay fijo)
+	hedgingDelay := os.Getenv("HEDGING_DELAY_MS")
+	if len(hedgingDelay) > 0 {
+		if ms, err := strconv.Atoi(hedgingDelay); err == nil && ms > 0 {
+			config.HedgingDelay = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	hedgingPercentile := os.Getenv("HEDGING_PERCENTILE")
+	if len(hedgingPercentile) > 0 {
+		if p, err := strconv.Atoi(hedgingPercentile); err == nil && p >= 0 && p <= 100 {
+			config.HedgingPercentile = p
+		}
+	}
+
+	// TLS de las conexiones salientes; un valor inválido mantiene el default seguro (TLS 1.2, suites de Go)
+	if tlsMinVersion := os.Getenv("OUTBOUND_TLS_MIN_VERSION"); len(tlsMinVersion) > 0 {
+		if version, err := parseTLSMinVersion(tlsMinVersion); err == nil {
+			config.TLSMinVersion = version
+		} else {
+			logInvalidTLSConfig("OUTBOUND_TLS_MIN_VERSION", err)
+		}
+	}
+
+	if tlsCipherSuites := os.Getenv("OUTBOUND_TLS_CIPHER_SUITES"); len(tlsCipherSuites) > 0 {
+		if suites, err := parseTLSCipherSuites(tlsCipherSuites); err == nil {
+			config.TLSCipherSuites = suites
+		} else {
+			logInvalidTLSConfig("OUTBOUND_TLS_CIPHER_SUITES", err)
+		}
+	}
+
+	// Prefijo/sufijo obligatorio del system prompt por equipo ("*" aplica al resto de equipos)
+	if systemPromptInjections := os.Getenv("SYSTEM_PROMPT_INJECTIONS"); len(systemPromptInjections) > 0 {
+		if injections, err := parseSystemPromptInjections(systemPromptInjections); err == nil {
+			config.SystemPromptInjections = injections
+		} else {
+			logInvalidSystemPromptConfig(err)
+		}
+	}
+
 	return config
 }
 
@@ -125,6 +447,28 @@ type BedrockClient struct {
 	db            *database.Database
 	metricsWorker *metrics.MetricsWorker
 	modelResolver *metrics.ModelResolver
+
+	httpClient     *http.Client    // Cliente HTTP saliente con la configuración TLS
+	hedgeLatencies *latencyTracker // Latencias no-stream para calcular el delay del hedging
+
+	filteredResponses atomic.Int64 // Respuestas cortadas por filtro de contenido o guardrail
+	maintenance       atomic.Bool  // Modo mantenimiento: HandleProxy responde 503 (conmutable en runtime)
+
+	batchQuota batchQuotaReserver // Reserva atómica de cuota para /v1/messages/batch (nil sin BD)
+
+	idempotency *idempotencyCache          // Respuestas por (usuario, Idempotency-Key) (nil si IDEMPOTENCY_TTL_SECONDS=0)
+	dlp         *DLPFilter                 // Patrones prohibidos en el contenido de la request (nil sin DLP_PATTERNS)
+	costFormat  *metrics.CostFormatOptions // Formato de los costes mostrados al usuario (nil = dólares)
+
+	modelList modelListCache // Última lista de modelos válida de Bedrock (fallback de /v1/models)
+
+	effectiveConfig EffectiveConfig // Configuración de arranque expuesta en /admin/config
+
+	postProcessing sync.WaitGroup // Post-processing de métricas en curso (el apagado ordenado lo espera)
+
+	credentials      aws.CredentialsProvider // Credenciales del SDK, comprobadas periódicamente (nil = sin comprobación)
+	credentialProbe  func(ctx context.Context) error // Llamada firmada de la comprobación (nil = ListFoundationModels)
+	credentialHealth credentialHealth        // Resultado de la última comprobación (expuesto en /ready)
 }
 
 type ModelInfo struct {
@@ -157,21 +501,29 @@ type ModelValidationResult struct {
 	Available      bool   `json:"available"`
 }
 
+// ListModels devuelve los modelos de configuración ordenados por ID. Name es el ID del modelo
+// (el nombre que envían los clientes), sin concatenar la versión
 func (this *BedrockClient) ListModels() []ModelInfo {
 	models := make([]ModelInfo, 0, len(this.config.AnthropicVersionMappings))
 	for name, version := range this.config.AnthropicVersionMappings {
-		models = append(models, ModelInfo{ID: name, Version: version, Name: fmt.Sprintf("%s-%s", name, version)})
+		models = append(models, ModelInfo{ID: name, Version: version, Name: name})
 	}
+	sortModelInfos(models)
 	return models
 }
 
 // GetBedrockAvailableModels fetches available models from Bedrock API
 func (this *BedrockClient) GetBedrockAvailableModels() ([]BedrockFoundationModel, error) {
+	return this.listFoundationModels(context.Background())
+}
+
+// listFoundationModels llama a ListFoundationModels; ctx limita la duración de la llamada
+func (this *BedrockClient) listFoundationModels(ctx context.Context) ([]BedrockFoundationModel, error) {
 	// Create the API endpoint URL - use bedrock service, not bedrock-runtime
 	apiEndpoint := fmt.Sprintf("https://bedrock.%s.amazonaws.com/foundation-models", this.config.Region)
 
 	// Create HTTP request
-	req, err := http.NewRequest("GET", apiEndpoint, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiEndpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -211,16 +563,7 @@ func (this *BedrockClient) GetBedrockAvailableModels() ([]BedrockFoundationModel
 	}
 
 	// Execute the request
-	httpClient := http.DefaultClient
-	if this.config.DEBUG {
-		httpClient = &http.Client{
-			Transport: loggingRoundTripper{
-				wrapped: http.DefaultTransport,
-			},
-		}
-	}
-
-	resp, err := httpClient.Do(req)
+	resp, err := this.outboundClient().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %v", err)
 	}
@@ -289,8 +632,8 @@ func (this *BedrockClient) GetMergedModelList() ([]ModelInfo, error) {
 				},
 			})
 		}
-		// Fall back to config-only models
-		return this.ListModels(), nil
+		// Fall back to the last Bedrock result, then to config-only models
+		return this.fallbackModelList(), nil
 	}
 
 	// Create model list from validation results
@@ -328,9 +671,11 @@ func (this *BedrockClient) GetMergedModelList() ([]ModelInfo, error) {
 				Message: "No valid models found from Bedrock API, falling back to config models",
 			})
 		}
-		return this.ListModels(), nil
+		return this.fallbackModelList(), nil
 	}
 
+	sortModelInfos(models)
+	this.modelList.store(models, time.Now())
 	return models, nil
 }
 
@@ -382,14 +727,9 @@ func NewBedrockClient(config *BedrockConfig) *BedrockClient {
 		awsConfig.WithCredentialsProvider(staticProvider),
 	}
 
-	if config.DEBUG {
-		httpClient := &http.Client{
-			Transport: loggingRoundTripper{
-				wrapped: http.DefaultTransport,
-			},
-		}
-		opt = append(opt, awsConfig.WithHTTPClient(httpClient))
-	}
+	// Mismo cliente HTTP (TLS mínimo y cipher suites configurados) para el SDK y el path no-stream
+	httpClient := newOutboundHTTPClient(config)
+	opt = append(opt, awsConfig.WithHTTPClient(httpClient))
 
 	cfg, err := awsConfig.LoadDefaultConfig(context.TODO(), opt...)
 
@@ -397,10 +737,26 @@ func NewBedrockClient(config *BedrockConfig) *BedrockClient {
 		log.Fatalf("unable to load SDK config, %v", err)
 	}
 
-	return &BedrockClient{
-		config: config,
-		client: bedrockRuntime.NewFromConfig(cfg),
+	client := &BedrockClient{
+		config:         config,
+		client:         bedrockRuntime.NewFromConfig(cfg),
+		httpClient:     httpClient,
+		hedgeLatencies: newLatencyTracker(),
+		credentials:    cfg.Credentials,
+	}
+	client.maintenance.Store(config.MaintenanceMode)
+	if config.IdempotencyTTL > 0 {
+		client.idempotency = newIdempotencyCache(config.IdempotencyTTL, config.IdempotencyMaxBodyBytes)
+	}
+	return client
+}
+
+// outboundClient devuelve el cliente HTTP para llamadas directas a Bedrock
+func (this *BedrockClient) outboundClient() *http.Client {
+	if this.httpClient != nil {
+		return this.httpClient
 	}
+	return http.DefaultClient
 }
 
 // SetDependencies establece las dependencias para post-processing
@@ -410,9 +766,81 @@ func (this *BedrockClient) SetDependencies(db *database.Database, mw *metrics.Me
 	// Crear ModelResolver si tenemos BD
 	if db != nil {
 		this.modelResolver = metrics.NewModelResolver(db.GetPool())
+		this.batchQuota = db
 	}
 }
 
+// SetPricingConfig configura el mapa ARN -> modelo, el modelo de pricing por defecto y los modelos cuyo
+// input incluye la caché del ModelResolver (llamar después de SetDependencies, que crea el resolver con la BD)
+func (this *BedrockClient) SetPricingConfig(config PricingConfig) {
+	if this.modelResolver == nil {
+		this.modelResolver = metrics.NewModelResolver(nil)
+	}
+	this.modelResolver.SetProfileModels(config.ProfileModels)
+	this.modelResolver.SetDefaultPricingModel(config.DefaultModel)
+	this.modelResolver.SetInputIncludesCache(config.InputIncludesCache)
+	this.modelResolver.SetDefaultPricingHook(logDefaultPricing)
+}
+
+// logDefaultPricing registra que un modelo sin pricing propio se factura con PRICING_DEFAULT_MODEL:
+// el coste es aproximado hasta que se añada a PRICING_PROFILE_MODELS o a la tabla de precios
+func logDefaultPricing(modelID, resolvedModelID, defaultModelID string) {
+	Logger.Warning(amslog.Event{
+		Name:    EventPricingFallback,
+		Message: "Pricing not found for model, applying the default pricing model",
+		Fields: map[string]interface{}{
+			"model.id":              modelID,
+			"model.resolved_id":     resolvedModelID,
+			"pricing.default_model": defaultModelID,
+		},
+	})
+}
+
+// forwardResponseHeaders retorna las cabeceras de Bedrock que se reenvían al cliente
+func (this *BedrockClient) forwardResponseHeaders() []string {
+	if len(this.config.ForwardResponseHeaders) > 0 {
+		return this.config.ForwardResponseHeaders
+	}
+	return DefaultForwardResponseHeaders
+}
+
+// copyAllowedHeaders copia de src las cabeceras de allowed que dst aún no tiene. El resto
+// (x-amzn-RequestId, cabeceras internas de AWS...) no llega al cliente
+func copyAllowedHeaders(dst, src http.Header, allowed []string) {
+	for _, name := range allowed {
+		values := src.Values(name)
+		if len(values) == 0 || dst.Get(name) != "" {
+			continue
+		}
+		dst[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+	}
+}
+
+// postProcessTimeout retorna el límite de tiempo del post-processing de métricas
+func (this *BedrockClient) postProcessTimeout() time.Duration {
+	if this.config.PostProcessTimeout > 0 {
+		return this.config.PostProcessTimeout
+	}
+	return DefaultPostProcessTimeout
+}
+
+// newPostProcessContext crea un contexto desacoplado de la cancelación de la request
+// que conserva trace/request IDs para correlación en logs y tiene su propio timeout
+func (this *BedrockClient) newPostProcessContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := amslog.WithTraceID(context.Background(), amslog.TraceIDFromContext(ctx))
+	detached = amslog.WithRequestID(detached, amslog.RequestIDFromContext(ctx))
+	return context.WithTimeout(detached, this.postProcessTimeout())
+}
+
+// IsMetricsHealthy indica si las métricas de uso pueden registrarse
+// Sin MetricsWorker configurado no hay facturación que proteger y se considera sano
+func (this *BedrockClient) IsMetricsHealthy() bool {
+	if this.metricsWorker == nil {
+		return true
+	}
+	return !this.metricsWorker.IsStopped()
+}
+
 func (this *BedrockClient) GetModelMappings(source string) (string, error) {
 	if len(this.config.ModelMappings) > 0 {
 		if target, ok := this.config.ModelMappings[source]; ok {
@@ -432,7 +860,44 @@ func getKeys(m map[string]interface{}) []string {
 	return keys
 }
 
+// alwaysStrippedFields son los campos del body que Bedrock rechaza y se eliminan siempre antes de reenviar
+var alwaysStrippedFields = []string{"model", "stream", PrimeCacheField}
+
+// stripRequestFields elimina del body los campos incompatibles con Bedrock y los de STRIP_REQUEST_FIELDS
+func (this *BedrockClient) stripRequestFields(wrapper map[string]interface{}) {
+	for _, field := range alwaysStrippedFields {
+		delete(wrapper, field)
+	}
+	for _, field := range this.config.StripRequestFields {
+		delete(wrapper, field)
+	}
+}
+
+// reasoningEnabled indica si se activa extended thinking para el modelo. Requiere AWS_BEDROCK_ENABLE_OUTPUT_REASON
+// y, si REASONING_MODELS no está vacío, que el profile o el modelo pedido contenga alguna de sus entradas
+func (this *BedrockClient) reasoningEnabled(modelIDs ...string) bool {
+	if !this.config.EnableOutputReason {
+		return false
+	}
+	if len(this.config.ReasoningModels) == 0 {
+		return true
+	}
+	for _, reasoningModel := range this.config.ReasoningModels {
+		for _, modelID := range modelIDs {
+			if reasoningModel != "" && modelID != "" && strings.Contains(modelID, reasoningModel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (this *BedrockClient) SignRequest(request *http.Request, inferenceProfileARN string) (*http.Request, bool, error) {
+	return this.signRequest(request, inferenceProfileARN, this.config.MaxTokens)
+}
+
+// signRequest firma la request fijando max_tokens en el body cuando maxTokens > 0
+func (this *BedrockClient) signRequest(request *http.Request, inferenceProfileARN string, maxTokens int) (*http.Request, bool, error) {
 	contentType := request.Header.Get("Content-Type")
 	cloneReq := request
 	isStream := false
@@ -469,28 +934,34 @@ func (this *BedrockClient) SignRequest(request *http.Request, inferenceProfileAR
 			}
 		}
 
-		wrapper["anthropic_version"] = this.config.AnthropicDefaultVersion
-		delete(wrapper, "model")
-		delete(wrapper, "stream")
+		model, _ := wrapper["model"].(string)
+		clientVersion, _ := wrapper["anthropic_version"].(string)
+		if clientVersion == "" {
+			clientVersion = request.Header.Get("anthropic-version")
+		}
+		wrapper["anthropic_version"] = this.anthropicVersion(model, clientVersion)
+		this.stripRequestFields(wrapper)
 
 		if this.config.EnableComputerUse {
 			wrapper["anthropic_beta"] = "computer-use-2024-10-22"
 		}
 
-		if _, ok := wrapper["thinking"]; !ok && this.config.EnableOutputReason {
+		// Bedrock rechaza thinking en modelos sin extended thinking: solo se inyecta (o se deja pasar) si el modelo lo soporta
+		reasoning := this.reasoningEnabled(inferenceProfileARN, model)
+		if _, ok := wrapper["thinking"]; !ok && reasoning {
 			wrapper["thinking"] = &ThinkingConfig{
 				Type:         "enabled",
 				BudgetTokens: this.config.ReasonBudgetTokens,
 			}
 		}
 
-		if !this.config.EnableOutputReason {
+		if !reasoning {
 			delete(wrapper, "thinking")
 		}
 
-		// Apply max_tokens logic: use config value if it exists and is > 0, otherwise keep the incoming value
-		if this.config.MaxTokens > 0 {
-			wrapper["max_tokens"] = this.config.MaxTokens
+		// Apply max_tokens logic: use config value (or the cost-ceiling clamp) if it is > 0, otherwise keep the incoming value
+		if maxTokens > 0 {
+			wrapper["max_tokens"] = maxTokens
 		}
 
 		newBody, err := json.Marshal(wrapper)
@@ -557,9 +1028,76 @@ func (this *BedrockClient) SignRequest(request *http.Request, inferenceProfileAR
 	return preSignReq, isStream, nil
 }
 
+// anthropicVersion resuelve el anthropic_version para Bedrock. AWS_BEDROCK_ANTHROPIC_VERSION_MAPPINGS
+// puede mapear el modelo o la versión enviada por el cliente (p.ej. 2023-06-01=bedrock-2023-05-31)
+// Prioridad: mapeo del modelo > mapeo de la versión del cliente > default configurado > DefaultAnthropicVersion
+func (this *BedrockClient) anthropicVersion(model, clientVersion string) string {
+	for _, key := range []string{model, clientVersion} {
+		if version := this.config.AnthropicVersionMappings[key]; key != "" && version != "" {
+			return version
+		}
+	}
+	if this.config.AnthropicDefaultVersion != "" {
+		return this.config.AnthropicDefaultVersion
+	}
+	return DefaultAnthropicVersion
+}
+
+// logMissingAnthropicVersion avisa de que se usa el anthropic_version por defecto (el logger puede no estar inicializado)
+func logMissingAnthropicVersion() {
+	if Logger == nil {
+		return
+	}
+	Logger.Warning(amslog.Event{
+		Name:    "ANTHROPIC_VERSION_DEFAULTED",
+		Message: "AWS_BEDROCK_ANTHROPIC_DEFAULT_VERSION not set, using built-in default",
+		Fields: map[string]interface{}{
+			"anthropic_version": DefaultAnthropicVersion,
+		},
+	})
+}
+
+// ValidateVersionMappings avisa de cada modelo de ModelMappings sin anthropic_version explícito en
+// AnthropicVersionMappings (se sirve con AnthropicDefaultVersion). En modo estricto devuelve error
+// para abortar el arranque
+func ValidateVersionMappings(config *BedrockConfig) error {
+	var unmapped []string
+	for model := range config.ModelMappings {
+		if config.AnthropicVersionMappings[model] == "" {
+			unmapped = append(unmapped, model)
+		}
+	}
+	if len(unmapped) == 0 {
+		return nil
+	}
+	sort.Strings(unmapped)
+
+	if Logger != nil {
+		for _, model := range unmapped {
+			Logger.Warning(amslog.Event{
+				Name:    "ANTHROPIC_VERSION_MAPPING_MISSING",
+				Message: "Mapped model has no anthropic_version mapping, using default version",
+				Fields: map[string]interface{}{
+					"config_model":      model,
+					"bedrock_model_id":  config.ModelMappings[model],
+					"anthropic_version": config.AnthropicDefaultVersion,
+					"strict":            config.StrictVersionMappings,
+				},
+			})
+		}
+	}
+
+	if config.StrictVersionMappings {
+		return fmt.Errorf("models without AWS_BEDROCK_ANTHROPIC_VERSION_MAPPINGS entry: %s", strings.Join(unmapped, ", "))
+	}
+	return nil
+}
+
 // convertSystemBlocksWithCache convierte bloques de system de Anthropic a Bedrock con soporte para cache_control
+// Nunca se añaden más de MaxSystemCachePoints cache points (los siguientes cache_control se ignoran)
 func convertSystemBlocksWithCache(systemBlocks []interface{}, forcePromptCaching bool) []types.SystemContentBlock {
 	var result []types.SystemContentBlock
+	cachePoints := 0
 	
 	for i, block := range systemBlocks {
 		blockMap, ok := block.(map[string]interface{})
@@ -583,14 +1121,11 @@ func convertSystemBlocksWithCache(systemBlocks []interface{}, forcePromptCaching
 			shouldAddCachePoint = (i == len(systemBlocks)-1)
 		} else {
 			// Si no está forzado, respetar lo que envía el cliente
-			if cacheControl, ok := blockMap["cache_control"].(map[string]interface{}); ok {
-				if cacheType, ok := cacheControl["type"].(string); ok && cacheType == "ephemeral" {
-					shouldAddCachePoint = true
-				}
-			}
+			shouldAddCachePoint = hasEphemeralCacheControl(blockMap)
 		}
 		
-		if shouldAddCachePoint {
+		if shouldAddCachePoint && cachePoints < MaxSystemCachePoints {
+			cachePoints++
 			// Insertar cache point como bloque separado DESPUÉS del texto
 			cachePointBlock := &types.SystemContentBlockMemberCachePoint{
 				Value: types.CachePointBlock{
@@ -605,7 +1140,9 @@ func convertSystemBlocksWithCache(systemBlocks []interface{}, forcePromptCaching
 }
 
 // convertAnthropicToBedrockMessages convierte mensajes de formato Anthropic a formato Bedrock con soporte para cache_control
-func convertAnthropicToBedrockMessages(anthropicMessages []interface{}, forcePromptCaching bool) ([]types.Message, error) {
+// Los tool_result se convierten a texto y se truncan a maxToolResultBytes (0 = sin límite)
+// Con nativeTools los bloques tool_use/tool_result se envían como bloques nativos de Converse
+func convertAnthropicToBedrockMessages(anthropicMessages []interface{}, forcePromptCaching bool, maxToolResultBytes int, nativeTools bool) ([]types.Message, error) {
 	var bedrockMessages []types.Message
 	
 	for msgIdx, msg := range anthropicMessages {
@@ -679,42 +1216,48 @@ func convertAnthropicToBedrockMessages(anthropicMessages []interface{}, forcePro
 							contentBlocks = append(contentBlocks, cachePointBlock)
 						}
 					}
-				case "image":
-					// Manejar imágenes - convertir formato Anthropic a Bedrock
-					if source, ok := blockMap["source"].(map[string]interface{}); ok {
-						sourceType, _ := source["type"].(string)
-						
-						if sourceType == "base64" {
-							// Extraer datos de la imagen
-							mediaType, _ := source["media_type"].(string)
-							data, _ := source["data"].(string)
-							
-							// Decodificar base64 a bytes
-							imageBytes, err := base64.StdEncoding.DecodeString(data)
-							if err != nil {
-								Logger.Error(amslog.Event{
-									Name:    "IMAGE_DECODE_ERROR",
-									Message: "Failed to decode base64 image",
-									Error: &amslog.ErrorInfo{
-										Type:    "DecodeError",
-										Message: err.Error(),
-									},
-								})
-								continue
-							}
-							
-							// Crear bloque de imagen para Bedrock
-							imageBlock := &types.ContentBlockMemberImage{
-								Value: types.ImageBlock{
-									Format: types.ImageFormat(strings.TrimPrefix(mediaType, "image/")),
-									Source: &types.ImageSourceMemberBytes{
-										Value: imageBytes,
-									},
-								},
-							}
-							contentBlocks = append(contentBlocks, imageBlock)
+				case "thinking", "redacted_thinking":
+					// Thinking de un turno anterior del assistant (debe preceder a su tool_use)
+					if role == types.ConversationRoleAssistant {
+						if thinkingBlock := convertThinkingBlock(blockMap); thinkingBlock != nil {
+							contentBlocks = append(contentBlocks, thinkingBlock)
 						}
 					}
+				case "tool_use":
+					// Solo en modo nativo: en modo XML la llamada ya viaja como texto del assistant
+					if nativeTools {
+						contentBlocks = append(contentBlocks, convertToolUseBlock(blockMap))
+					}
+				case "tool_result":
+					// Sin toolConfig en Bedrock, el resultado de la tool viaja como texto
+					text := extractToolResultText(blockMap)
+					truncated, wasTruncated := truncateToolResult(text, maxToolResultBytes)
+					if wasTruncated {
+						toolUseID, _ := blockMap["tool_use_id"].(string)
+						Logger.Warning(amslog.Event{
+							Name:    EventToolResultTruncated,
+							Message: "Oversized tool_result content truncated",
+							Fields: map[string]interface{}{
+								"tool_use_id":    toolUseID,
+								"original_bytes": len(text),
+								"max_bytes":      maxToolResultBytes,
+							},
+						})
+					}
+					if nativeTools {
+						contentBlocks = append(contentBlocks, convertToolResultBlock(blockMap, truncated))
+					} else if len(truncated) > 0 {
+						contentBlocks = append(contentBlocks, &types.ContentBlockMemberText{
+							Value: truncated,
+						})
+					}
+				case "image":
+					// Imagen base64 de Anthropic -> bloque de imagen de Bedrock (error si no se soporta)
+					imageBlock, err := convertImageBlock(blockMap)
+					if err != nil {
+						return nil, fmt.Errorf("message %d, content block %d: %w", msgIdx, blockIdx, err)
+					}
+					contentBlocks = append(contentBlocks, imageBlock)
 				}
 			}
 		}
@@ -730,6 +1273,13 @@ func convertAnthropicToBedrockMessages(anthropicMessages []interface{}, forcePro
 	return bedrockMessages, nil
 }
 
+// writeBedrockErrorResponse responde con un error JSON en formato Anthropic antes de iniciar el stream
+func writeBedrockErrorResponse(w http.ResponseWriter, class BedrockErrorClass) {
+	w.Header().Del("Cache-Control")
+	w.Header().Del("X-Accel-Buffering")
+	writeErrorResponse(w, class.Code, class.Message)
+}
+
 // sendSSEError envía un error en formato SSE compatible con Anthropic
 func sendSSEError(w http.ResponseWriter, errorType, errorMessage string) {
 	errorEvent := map[string]interface{}{
@@ -748,22 +1298,167 @@ func sendSSEError(w http.ResponseWriter, errorType, errorMessage string) {
 	}
 }
 
-func (this *BedrockClient) handleBedrockStreamConverse(w http.ResponseWriter, client *bedrockRuntime.Client, modelID string, systemBlocks []types.SystemContentBlock, messages []types.Message, maxTokens int32, toolConfig *types.ToolConfiguration, toolChoice types.ToolChoice) error {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no")
-	
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		return fmt.Errorf("streaming unsupported")
+// latencyOptimizedModels son los fragmentos de model ID que admiten inferencia optimizada en latencia
+var latencyOptimizedModels = []string{
+	"anthropic.claude-3-5-haiku",
+	"meta.llama3-1-70b",
+	"meta.llama3-1-405b",
+	"amazon.nova-pro",
+}
+
+// supportsLatencyOptimized indica si el modelo (o inference profile) admite latency "optimized"
+func supportsLatencyOptimized(modelID string) bool {
+	for _, fragment := range latencyOptimizedModels {
+		if strings.Contains(modelID, fragment) {
+			return true
+		}
 	}
-	
-	flusher.Flush()
+	return false
+}
 
-	// Crear comando ConverseStream con system blocks que incluyen cache points
-	// IMPORTANTE: NO enviamos toolConfig porque Cline no lo hace cuando se conecta directamente
-	// Cline usa prompt engineering (tools descritas en system prompt) + XML parsing
+// parseModelIntMap parsea un mapa de fragmento de model ID a entero ("haiku=4096,sonnet-4=16384"),
+// como MODEL_DEFAULT_MAX_TOKENS o MODEL_MIN_CACHE_TOKENS; se ignoran los valores no positivos
+func parseModelIntMap(raw string) map[string]int {
+	values := map[string]int{}
+	for model, value := range ParseMappingsFromStr(raw) {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 && model != "" {
+			values[model] = n
+		}
+	}
+	return values
+}
+
+// defaultMaxTokens devuelve el max_tokens por defecto del modelo (o inference profile)
+// Si varios fragmentos coinciden gana el más largo (el más específico)
+func (this *BedrockClient) defaultMaxTokens(modelID string) int32 {
+	matched := ""
+	tokens := DefaultMaxTokens
+	for model, modelTokens := range this.config.ModelDefaultMaxTokens {
+		if strings.Contains(modelID, model) && len(model) > len(matched) {
+			matched = model
+			tokens = modelTokens
+		}
+	}
+	return int32(tokens)
+}
+
+// convertStopReason traduce el stop reason de Bedrock al de Anthropic. Los bloqueos por filtro
+// de contenido o guardrail se devuelven como "refusal" e indican filtered=true
+func convertStopReason(reason types.StopReason) (string, bool) {
+	switch reason {
+	case "":
+		return "end_turn", false
+	case types.StopReasonContentFiltered, types.StopReasonGuardrailIntervened:
+		return "refusal", true
+	default:
+		return string(reason), false
+	}
+}
+
+// recordContentFiltered contabiliza una respuesta filtrada y la marca en las métricas de uso
+func (this *BedrockClient) recordContentFiltered(ctx context.Context, w http.ResponseWriter, modelID string, reason types.StopReason) {
+	total := this.filteredResponses.Add(1)
+	if mc, ok := w.(*MetricsCapture); ok {
+		mc.MarkFiltered()
+	}
+	Logger.WarningContext(ctx, amslog.Event{
+		Name:    EventBedrockContentFiltered,
+		Message: "Response blocked by content filter or guardrail",
+		Fields: map[string]interface{}{
+			"model.id":                 modelID,
+			"bedrock.stop_reason":      string(reason),
+			"filtered_responses.total": total,
+		},
+	})
+}
+
+// FilteredResponseCount devuelve cuántas respuestas se han cortado por filtro de contenido o guardrail
+func (this *BedrockClient) FilteredResponseCount() int64 {
+	return this.filteredResponses.Load()
+}
+
+// resolveLatencyMode determina el modo de latencia pedido por el cliente
+// Prioridad: performance_config.latency > service_tier > LATENCY_OPTIMIZED
+func resolveLatencyMode(payload map[string]interface{}, defaultOptimized bool) types.PerformanceConfigLatency {
+	if pc, ok := payload["performance_config"].(map[string]interface{}); ok {
+		switch pc["latency"] {
+		case string(types.PerformanceConfigLatencyOptimized):
+			return types.PerformanceConfigLatencyOptimized
+		case string(types.PerformanceConfigLatencyStandard):
+			return types.PerformanceConfigLatencyStandard
+		}
+	}
+
+	switch payload["service_tier"] {
+	case "latency_optimized", "optimized":
+		return types.PerformanceConfigLatencyOptimized
+	case "standard", "standard_only":
+		return types.PerformanceConfigLatencyStandard
+	}
+
+	if defaultOptimized {
+		return types.PerformanceConfigLatencyOptimized
+	}
+	return types.PerformanceConfigLatencyStandard
+}
+
+// newMessageID genera el id del mensaje Anthropic ("msg_" + request ID), estable para toda la respuesta
+// y correlacionable con los logs de la request
+func newMessageID(requestID string) string {
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+	return "msg_" + requestID
+}
+
+// writeMessageEnd envía message_delta con stop_reason, la stop sequence que cortó la generación (null si
+// ninguna) y el usage final (formato Anthropic) seguido de message_stop sin usage. El usage de
+// message_delta es acumulado y prevalece sobre el de message_start
+func writeMessageEnd(w http.ResponseWriter, stopReason, stopSequence string, inputTokens, outputTokens, cacheWriteTokens, cacheReadTokens int32) {
+	stopSequenceJSON := []byte("null")
+	if stopSequence != "" {
+		stopSequenceJSON, _ = json.Marshal(stopSequence)
+	}
+	fmt.Fprintf(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"%s\",\"stop_sequence\":%s},\"usage\":{\"input_tokens\":%d,\"output_tokens\":%d,\"cache_creation_input_tokens\":%d,\"cache_read_input_tokens\":%d}}\n\n",
+		stopReason, stopSequenceJSON, inputTokens, outputTokens, cacheWriteTokens, cacheReadTokens)
+	fmt.Fprintf(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+}
+
+// converseOptions agrupa los parámetros opcionales de inferencia que se añaden al input de Converse
+type converseOptions struct {
+	Latency    types.PerformanceConfigLatency
+	TopK       int                       // 0 = no enviar top_k
+	ToolConfig *types.ToolConfiguration // nil = tools inyectadas en el system prompt (XML)
+	PrimeCache bool                     // la request pidió prime_cache: registrar los tokens escritos en caché
+	TextBuffer textChunkBuffer          // nil = buffer XML global (XML_BUFFER_MAX_SIZE)
+
+	StopSequences []string        // stop_sequences del cliente (InferenceConfiguration.StopSequences)
+	Thinking      *ThinkingConfig // nil = sin extended thinking (ver converseThinking)
+}
+
+// extractTopK obtiene top_k del payload Anthropic y valida que sea un entero positivo
+// Retorna 0 si no se especifica
+func extractTopK(payload map[string]interface{}) (int, error) {
+	raw, ok := payload["top_k"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+
+	value, ok := raw.(float64)
+	if !ok || value < 1 || value != math.Trunc(value) || value > math.MaxInt32 {
+		return 0, fmt.Errorf("top_k must be a positive integer")
+	}
+
+	return int(value), nil
+}
+
+// buildConverseStreamInput construye el input de ConverseStream
+// El modo optimizado solo se envía si el modelo lo admite; si no, se ignora silenciosamente.
+// top_k no forma parte de InferenceConfiguration: para Claude en Bedrock viaja en AdditionalModelRequestFields.
+// El modelo aplica primero top_k, después top_p sobre los candidatos restantes y por último temperature;
+// con la temperature fija del proxy (DefaultTemperature = 0) el muestreo es prácticamente determinista.
+// Con extended thinking Claude solo admite la temperature por defecto, así que no se envía
+func buildConverseStreamInput(modelID string, systemBlocks []types.SystemContentBlock, messages []types.Message, maxTokens int32, opts converseOptions) *bedrockRuntime.ConverseStreamInput {
 	input := &bedrockRuntime.ConverseStreamInput{
 		ModelId:  &modelID,
 		Messages: messages,
@@ -774,26 +1469,91 @@ func (this *BedrockClient) handleBedrockStreamConverse(w http.ResponseWriter, cl
 		},
 	}
 
-	// Ejecutar streaming
-	output, err := client.ConverseStream(context.Background(), input)
+	if opts.Latency == types.PerformanceConfigLatencyOptimized && supportsLatencyOptimized(modelID) {
+		input.PerformanceConfig = &types.PerformanceConfiguration{
+			Latency: types.PerformanceConfigLatencyOptimized,
+		}
+	}
+
+	if opts.ToolConfig != nil {
+		input.ToolConfig = opts.ToolConfig
+	}
+
+	// Claude devuelve la secuencia que cortó la generación en los campos adicionales de la respuesta
+	if len(opts.StopSequences) > 0 {
+		input.InferenceConfig.StopSequences = opts.StopSequences
+		input.AdditionalModelResponseFieldPaths = []string{stopSequenceFieldPath}
+	}
+
+	additionalFields := make(map[string]interface{})
+	if opts.TopK > 0 {
+		additionalFields["top_k"] = opts.TopK
+	}
+	if opts.Thinking != nil {
+		additionalFields["thinking"] = map[string]interface{}{
+			"type":          opts.Thinking.Type,
+			"budget_tokens": opts.Thinking.BudgetTokens,
+		}
+		input.InferenceConfig.Temperature = nil
+	}
+	if len(additionalFields) > 0 {
+		input.AdditionalModelRequestFields = document.NewLazyDocument(additionalFields)
+	}
+
+	return input
+}
+
+func (this *BedrockClient) handleBedrockStreamConverse(ctx context.Context, w http.ResponseWriter, client *bedrockRuntime.Client, modelID string, systemBlocks []types.SystemContentBlock, messages []types.Message, maxTokens int32, toolConfig *types.ToolConfiguration, toolChoice types.ToolChoice, opts converseOptions) error {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming unsupported")
+	}
+
+	// Crear comando ConverseStream con system blocks que incluyen cache points
+	// IMPORTANTE: por defecto NO enviamos toolConfig porque Cline no lo hace cuando se conecta directamente
+	// Cline usa prompt engineering (tools descritas en system prompt) + XML parsing
+	// Solo los modelos de NATIVE_TOOL_MODELS reciben toolConfig (vía opts.ToolConfig)
+	input := buildConverseStreamInput(modelID, systemBlocks, messages, maxTokens, opts)
+
+	// Ejecutar streaming (el deadline de Bedrock cubre el arranque, reintentos incluidos)
+	callCtx, stopDeadline, cancelCall := this.startStreamDeadline(ctx)
+	defer cancelCall()
+	output, err := this.converseStreamWithRetry(callCtx, client, input)
+	stopDeadline()
+	err = asBedrockTimeout(ctx, callCtx, err)
 	if err != nil {
-		// Enviar error como evento SSE antes de retornar
-		errorMsg := fmt.Sprintf("failed to start converse stream: %v", err)
-		sendSSEError(w, "api_error", errorMsg)
+		// Aún no se han enviado cabeceras: responder con el status que corresponda al tipo de error
+		writeBedrockErrorResponse(w, classifyBedrockError(err))
 		return fmt.Errorf("failed to start converse stream: %w", err)
 	}
+	
+	// Enviar cabeceras SSE solo cuando Bedrock ha aceptado la request
+	flusher.Flush()
 
 	eventCount := 0
 	stream := output.GetStream()
+	messageID := newMessageID(amslog.RequestIDFromContext(ctx))
 	
-	// Variables para capturar métricas de uso y buffering selectivo
+	// Variables para capturar métricas de uso. message_delta/message_stop se retrasan hasta
+	// Metadata (llega después de messageStop) para que el usage final sea el definitivo
 	var inputTokens, outputTokens, cacheReadTokens, cacheWriteTokens int32
-	var messageStartReceived bool
-	var messageStartSent bool
+	var stopReason, stopSequence string
+	var messageStopPending bool
+	
+	// Crear buffer para evitar cortar tags XML (salvo que la request use otro o ninguno)
+	xmlBuffer := opts.TextBuffer
+	if xmlBuffer == nil {
+		bufferConfig := LoadXMLBufferConfigWithEnv()
+		xmlBuffer = NewXMLTagBuffer(bufferConfig.MaxBufferSize)
+	}
 	
-	// Crear buffer para evitar cortar tags XML con configuración
-	bufferConfig := LoadXMLBufferConfigWithEnv()
-	xmlBuffer := NewXMLTagBuffer(bufferConfig.MaxBufferSize)
+	// Bloques de reasoning (extended thinking) ya abiertos con content_block_start
+	thinkingBlocks := make(map[int32]bool)
 	
 	for {
 		event, ok := <-stream.Events()
@@ -805,12 +1565,22 @@ func (this *BedrockClient) handleBedrockStreamConverse(w http.ResponseWriter, cl
 
 		switch e := event.(type) {
 		case *types.ConverseStreamOutputMemberMessageStart:
-			// NO enviar message_start aún - esperar a tener tokens reales de Metadata
-			messageStartReceived = true
+			// Enviar message_start en cuanto llega con el usage disponible en ese momento.
+			// El messageStart de Converse no trae usage: los tokens definitivos se envían en message_delta
+			fmt.Fprintf(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"%s\",\"type\":\"message\",\"role\":\"assistant\",\"content\":[],\"model\":\"%s\",\"stop_reason\":null,\"stop_sequence\":null,\"usage\":{\"input_tokens\":%d,\"output_tokens\":%d,\"cache_creation_input_tokens\":%d,\"cache_read_input_tokens\":%d}}}\n\n",
+				messageID, modelID, inputTokens, outputTokens, cacheWriteTokens, cacheReadTokens)
+			flusher.Flush()
 
 		case *types.ConverseStreamOutputMemberContentBlockStart:
-			// Enviar evento content_block_start
-			fmt.Fprintf(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n")
+			// Bloques tool_use (solo en modo nativo): se reenvían con su índice, id y nombre de la tool
+			if toolStart, ok := e.Value.Start.(*types.ContentBlockStartMemberToolUse); ok {
+				writeToolUseBlockStart(w, aws.ToInt32(e.Value.ContentBlockIndex), toolStart.Value)
+				flusher.Flush()
+				continue
+			}
+			
+			// Enviar evento content_block_start (en modo nativo el texto puede ir después de un tool_use)
+			fmt.Fprintf(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":%d,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n", aws.ToInt32(e.Value.ContentBlockIndex))
 			flusher.Flush()
 
 		case *types.ConverseStreamOutputMemberContentBlockDelta:
@@ -824,13 +1594,20 @@ func (this *BedrockClient) handleBedrockStreamConverse(w http.ResponseWriter, cl
 					
 					// Solo enviar si hay texto procesado
 					if len(processedText) > 0 {
-						textJSON, err := json.Marshal(processedText)
-						if err != nil {
-							continue
-						}
-						fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":%s}}\n\n", string(textJSON))
-						flusher.Flush()
+						writeTextDeltas(w, flusher, aws.ToInt32(e.Value.ContentBlockIndex), processedText, this.config.SSEMaxDeltaBytes)
 					}
+				} else if toolDelta, ok := e.Value.Delta.(*types.ContentBlockDeltaMemberToolUse); ok && toolDelta.Value.Input != nil {
+					// Fragmento del input JSON de la tool (modo nativo)
+					partialJSON, err := json.Marshal(*toolDelta.Value.Input)
+					if err != nil {
+						continue
+					}
+					fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":%d,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":%s}}\n\n", aws.ToInt32(e.Value.ContentBlockIndex), string(partialJSON))
+					flusher.Flush()
+				} else if reasoningDelta, ok := e.Value.Delta.(*types.ContentBlockDeltaMemberReasoningContent); ok && reasoningDelta.Value != nil {
+					// Reasoning del modelo (extended thinking): bloques thinking, sin pasar por el buffer XML
+					writeReasoningDelta(w, thinkingBlocks, aws.ToInt32(e.Value.ContentBlockIndex), reasoningDelta.Value)
+					flusher.Flush()
 				}
 			}
 
@@ -839,19 +1616,25 @@ func (this *BedrockClient) handleBedrockStreamConverse(w http.ResponseWriter, cl
 			if xmlBuffer.HasBufferedContent() {
 				remainingText := xmlBuffer.Flush()
 				if len(remainingText) > 0 {
-					textJSON, err := json.Marshal(remainingText)
-					if err == nil {
-						fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":%s}}\n\n", string(textJSON))
-						flusher.Flush()
-					}
+					writeTextDeltas(w, flusher, aws.ToInt32(e.Value.ContentBlockIndex), remainingText, this.config.SSEMaxDeltaBytes)
 				}
 			}
 			
-			// Enviar evento content_block_stop
-			fmt.Fprintf(w, "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n")
+			// Enviar evento content_block_stop (con el índice del bloque: en modo nativo puede haber varios)
+			fmt.Fprintf(w, "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":%d}\n\n", aws.ToInt32(e.Value.ContentBlockIndex))
 			flusher.Flush()
 
 		case *types.ConverseStreamOutputMemberMetadata:
+			// Capturar el modelo concreto servido y la latencia que informa Bedrock (solo para métricas)
+			if mc, ok := w.(*MetricsCapture); ok {
+				if servedModelID := extractServedModelID(e.Value); servedModelID != "" {
+					mc.SetServedModelID(servedModelID)
+				}
+				if latencyMs := extractBedrockLatencyMs(e.Value); latencyMs > 0 {
+					mc.SetBedrockLatencyMs(latencyMs)
+				}
+			}
+			
 			// Capturar tokens finales desde metadata
 			usage := e.Value.Usage
 			if usage != nil {
@@ -868,13 +1651,8 @@ func (this *BedrockClient) handleBedrockStreamConverse(w http.ResponseWriter, cl
 				if usage.CacheWriteInputTokens != nil {
 					cacheWriteTokens = *usage.CacheWriteInputTokens
 				}
-				
-				// AHORA enviar message_start con tokens REALES (buffering selectivo)
-				if messageStartReceived && !messageStartSent {
-					fmt.Fprintf(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"\",\"type\":\"message\",\"role\":\"assistant\",\"content\":[],\"model\":\"%s\",\"stop_reason\":null,\"stop_sequence\":null,\"usage\":{\"input_tokens\":%d,\"output_tokens\":%d,\"cache_creation_input_tokens\":%d,\"cache_read_input_tokens\":%d}}}\n\n", 
-						modelID, inputTokens, outputTokens, cacheWriteTokens, cacheReadTokens)
-					flusher.Flush()
-					messageStartSent = true
+				if opts.PrimeCache {
+					logCacheWrite(ctx, modelID, cacheWriteTokens, cacheReadTokens)
 				}
 				
 				// Enviar evento ping con tokens reales para MetricsCapture (backup)
@@ -882,28 +1660,37 @@ func (this *BedrockClient) handleBedrockStreamConverse(w http.ResponseWriter, cl
 					inputTokens, outputTokens, cacheWriteTokens, cacheReadTokens)
 				flusher.Flush()
 			}
-			// NO enviar message_stop aquí - se envía en MessageStop
+			
+			// Con el usage definitivo ya se puede cerrar el mensaje
+			if messageStopPending {
+				writeMessageEnd(w, stopReason, stopSequence, inputTokens, outputTokens, cacheWriteTokens, cacheReadTokens)
+				flusher.Flush()
+				messageStopPending = false
+			}
 
 		case *types.ConverseStreamOutputMemberMessageStop:
-			// Enviar evento message_delta con stop_reason y tokens finales (formato Anthropic)
-			stopReason := "end_turn"
-			if e.Value.StopReason != "" {
-				stopReason = string(e.Value.StopReason)
+			// message_delta/message_stop se envían al recibir Metadata, con los tokens finales
+			var filtered bool
+			stopReason, filtered = convertStopReason(e.Value.StopReason)
+			if filtered {
+				this.recordContentFiltered(ctx, w, modelID, e.Value.StopReason)
 			}
-			fmt.Fprintf(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"%s\",\"stop_sequence\":null},\"usage\":{\"output_tokens\":%d}}\n\n", stopReason, outputTokens)
-			flusher.Flush()
-			
-			// Enviar evento message_stop SIN usage (formato Anthropic)
-			fmt.Fprintf(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
-			flusher.Flush()
+			stopSequence = matchedStopSequence(e.Value.StopReason, e.Value.AdditionalModelResponseFields, opts.StopSequences)
+			messageStopPending = true
 		}
 	}
 
+	// Stream sin Metadata tras messageStop: cerrar el mensaje con el usage disponible
+	if messageStopPending && stream.Err() == nil {
+		writeMessageEnd(w, stopReason, stopSequence, inputTokens, outputTokens, cacheWriteTokens, cacheReadTokens)
+		flusher.Flush()
+	}
+
 	// Verificar errores del stream
 	if err := stream.Err(); err != nil {
 		// Enviar error como evento SSE en formato Anthropic
 		errorMsg := fmt.Sprintf("Bedrock stream error: %v", err)
-		sendSSEError(w, "api_error", errorMsg)
+		sendSSEError(w, classifyBedrockError(err).ErrorType, errorMsg)
 		return fmt.Errorf("stream error: %w", err)
 	}
 
@@ -911,9 +1698,18 @@ func (this *BedrockClient) handleBedrockStreamConverse(w http.ResponseWriter, cl
 }
 
 func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
+	// Reintentos con la misma Idempotency-Key reutilizan la respuesta en lugar de invocar a Bedrock
+	if userID, key, ok := this.idempotencyKey(r); ok {
+		this.idempotency.serve(w, r, userID, key, this.handleProxy)
+		return
+	}
+	this.handleProxy(w, r)
+}
+
+func (this *BedrockClient) handleProxy(w http.ResponseWriter, r *http.Request) {
 	// Crear contexto de request con timing
 	requestID := uuid.New().String()
-	reqCtx := NewRequestContext(requestID)
+	reqCtx := NewSampledRequestContext(requestID, this.config.PhaseTracingSample)
 	startTime := reqCtx.StartTime
 	
 	// Crear contexto con trazabilidad
@@ -927,9 +1723,27 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx = amslog.WithTraceID(ctx, traceID)
 	
+	// Span raíz de OTel con el mismo trace ID que los logs (no-op sin OTEL_EXPORTER_OTLP_ENDPOINT)
+	ctx = reqCtx.StartTrace(ctx)
+	defer reqCtx.EndTrace()
+	
+	// Las salidas por error también pueden ser lentas; las completadas se comprueban en el log final
+	slowChecked := false
+	defer func() {
+		if !slowChecked {
+			reqCtx.LogIfSlow(ctx, this.config.SlowRequestThreshold)
+		}
+	}()
+	
 	// Propagar contexto al request
 	r = r.WithContext(ctx)
 	
+	// Espera de admisión (requests encoladas, p.ej. items de un batch)
+	queueWait, _ := QueueWaitFromContext(ctx)
+	if queueWait > 0 {
+		reqCtx.RecordPhase("queue_wait", queueWait)
+	}
+	
 	// Log de inicio con nuevo logger
 	Logger.InfoContext(ctx, amslog.Event{
 		Name:    EventProxyRequestStart,
@@ -941,6 +1755,22 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 		},
 	})
 	
+	// Modo mantenimiento: no se sirven requests de modelo (health y admin siguen activos)
+	if this.IsMaintenanceMode() {
+		Logger.InfoContext(ctx, amslog.Event{
+			Name:    EventProxyMaintenance,
+			Message: "Request rejected: maintenance mode enabled",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    "ServiceUnavailable",
+				Message: "maintenance mode enabled",
+				Code:    string(ErrCodeMaintenanceMode),
+			},
+		})
+		writeMaintenanceResponse(w)
+		return
+	}
+	
 	// Obtener usuario del contexto (si está autenticado)
 	var user *auth.UserContext
 	if u, err := auth.GetUserFromContext(ctx); err == nil {
@@ -970,11 +1800,32 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 			Error: &amslog.ErrorInfo{
 				Type:    "ValidationError",
 				Message: "User must have default_inference_profile configured in JWT",
-				Code:    "NO_INFERENCE_PROFILE",
+				Code:    string(ErrCodeNoInferenceProfile),
 			},
 		})
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, `{"error": "User must have default_inference_profile configured in JWT"}`, http.StatusForbidden)
+		writeErrorResponse(w, ErrCodeNoInferenceProfile, "User must have default_inference_profile configured in JWT")
+		return
+	}
+	
+	// Solo se sirven perfiles de las regiones permitidas (coste/compliance)
+	if profileRegion := inferenceProfileRegion(user.DefaultInferenceProfile, this.config.Region); !profileRegionAllowed(profileRegion, this.config.AllowedProfileRegions) {
+		message := fmt.Sprintf("Inference profile region %s is not allowed; allowed regions: %s", profileRegion, strings.Join(this.config.AllowedProfileRegions, ", "))
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventProxyRequestError,
+			Message: "Inference profile region not allowed",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    "PermissionError",
+				Message: message,
+				Code:    string(ErrCodeProfileRegionNotAllowed),
+			},
+			Fields: map[string]interface{}{
+				"user.id":           user.UserID,
+				"inference_profile": user.DefaultInferenceProfile,
+				"cloud.region":      profileRegion,
+			},
+		})
+		writeErrorResponse(w, ErrCodeProfileRegionNotAllowed, message)
 		return
 	}
 	
@@ -983,6 +1834,14 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 	r.Body.Close()
 	// Restaurar el body para SignRequest
 	r.Body = io.NopCloser(bytes.NewBuffer(originalBodyBytes))
+	// SignRequest elimina "model" del body: guardar el modelo pedido por el cliente para las métricas
+	requestedModel := extractRequestedModel(originalBodyBytes)
+	
+	// DLP: rechazar contenido que coincide con patrones prohibidos (sin registrar el contenido) antes de
+	// elegir path, para que cubra tanto Converse como el path HTTP firmado
+	if this.rejectDLP(ctx, w, originalBodyBytes, user.UserID) {
+		return
+	}
 	
 	// FASE 1: Firma de request usando el ARN del usuario
 	endPhase := reqCtx.StartPhase("sign_request")
@@ -998,11 +1857,10 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 			Error: &amslog.ErrorInfo{
 				Type:    "SigningError",
 				Message: err.Error(),
-				Code:    "SIGN_REQUEST_FAILED",
+				Code:    string(ErrCodeSignRequestFailed),
 			},
 		})
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, fmt.Sprintf(`{"error": "%s"}`, err.Error()), http.StatusBadGateway)
+		writeErrorResponse(w, ErrCodeSignRequestFailed, err.Error())
 		return
 	}
 	
@@ -1012,12 +1870,34 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 		Outcome:    amslog.OutcomeSuccess,
 		DurationMs: reqCtx.PhaseTimings["sign_request"].Milliseconds(),
 		Fields: map[string]interface{}{
-			"is_stream":         isStream,
-			"inference_profile": user.DefaultInferenceProfile,
+			"is_stream":          isStream,
+			"inference_profile":  user.DefaultInferenceProfile,
+			"streaming_disabled": this.config.StreamingDisabled,
 		},
 	})
 
-	if isStream {
+	// Con STREAMING_DISABLED todas las requests usan el path Converse y se agregan en una única respuesta JSON.
+	// Las requests con stream:false usan el mismo pipeline de ConverseStream (conversión de tools y cache
+	// points, reintentos, reasoning, buffer XML) agregado en un único JSON, salvo con
+	// AWS_BEDROCK_NONSTREAM_RAW_HTTP, que mantiene la llamada HTTP firmada
+	converseStream := isStream || this.config.StreamingDisabled
+	if converseStream || !this.config.NonStreamRawHTTP {
+		// Rechazar streaming si el MetricsWorker está detenido (shutdown): no aceptar trabajo que no podemos facturar
+		if converseStream && this.config.RequireMetricsForStream && !this.IsMetricsHealthy() {
+			Logger.WarningContext(ctx, amslog.Event{
+				Name:    EventProxyRequestError,
+				Message: "Metrics worker stopped, refusing streaming request",
+				Outcome: amslog.OutcomeFailure,
+				Error: &amslog.ErrorInfo{
+					Type:    "ServiceUnavailable",
+					Message: "metrics worker is stopped",
+					Code:    string(ErrCodeMetricsUnavailable),
+				},
+			})
+			writeErrorResponse(w, ErrCodeMetricsUnavailable, "Service is shutting down, please retry")
+			return
+		}
+		
 		// FASE 2: Parsear request body ORIGINAL para extraer system, messages y tools
 		endPhase = reqCtx.StartPhase("parse_request")
 		
@@ -1032,233 +1912,27 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 				Error: &amslog.ErrorInfo{
 					Type:    "ParseError",
 					Message: err.Error(),
-					Code:    "INVALID_JSON",
+					Code:    string(ErrCodeInvalidJSON),
 				},
 			})
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, fmt.Sprintf(`{"error": "Failed to parse request: %s"}`, err.Error()), http.StatusBadRequest)
+			writeErrorResponse(w, ErrCodeInvalidJSON, "Failed to parse request: "+err.Error())
 			return
 		}
 		
-		// Extraer tools y convertirlas a texto PRIMERO (para añadir al system prompt)
-		var toolConfig *types.ToolConfiguration
-		var toolChoice types.ToolChoice
-		var toolsTextForSystemPrompt string
-		
-		if tools, ok := payload["tools"].([]interface{}); ok {
-			// Convertir tools a JSON estructurado para añadir al system prompt
-			var convErr error
-			toolsTextForSystemPrompt, convErr = convertAnthropicToolsToJSON(tools)
-			
-			if convErr != nil {
-				Logger.ErrorContext(ctx, amslog.Event{
-					Name:    EventProxyRequestError,
-					Message: "Failed to convert tools to JSON",
-					Outcome: amslog.OutcomeFailure,
-					Error: &amslog.ErrorInfo{
-						Type:    "ToolConversionError",
-						Message: convErr.Error(),
-						Code:    "TOOL_JSON_CONVERSION_FAILED",
-					},
-				})
-				w.Header().Set("Content-Type", "application/json")
-				http.Error(w, fmt.Sprintf(`{"error": "Failed to convert tools to JSON: %s"}`, convErr.Error()), http.StatusBadRequest)
-				return
-			}
-			
-			if toolsTextForSystemPrompt != "" {
-				Logger.InfoContext(ctx, amslog.Event{
-					Name:    "BEDROCK_TOOLS_TO_JSON",
-					Message: "Tools converted to JSON for system prompt",
-					Fields: map[string]interface{}{
-						"tools_count": len(tools),
-						"json_length": len(toolsTextForSystemPrompt),
-					},
-				})
-			}
-			
-			// También convertir a ToolConfiguration (aunque NO se enviará a Bedrock)
-			toolConfig, err = convertAnthropicToolsToBedrock(tools)
-			if err != nil {
-				Logger.ErrorContext(ctx, amslog.Event{
-					Name:    EventProxyRequestError,
-					Message: "Failed to convert tools",
-					Outcome: amslog.OutcomeFailure,
-					Error: &amslog.ErrorInfo{
-						Type:    "ToolConversionError",
-						Message: err.Error(),
-						Code:    "TOOL_CONVERSION_FAILED",
-					},
-				})
-				w.Header().Set("Content-Type", "application/json")
-				http.Error(w, fmt.Sprintf(`{"error": "Failed to convert tools: %s"}`, err.Error()), http.StatusBadRequest)
-				return
-			}
-		}
-		
-		// Extraer system blocks (puede ser string o array de bloques)
-		var systemBlocks []types.SystemContentBlock
-		if sys, ok := payload["system"].(string); ok {
-			// System es un string simple (legacy) - añadir tools al final
-			systemText := sys
-			if toolsTextForSystemPrompt != "" {
-				systemText = sys + toolsTextForSystemPrompt
-			}
-			systemBlocks = []types.SystemContentBlock{
-				&types.SystemContentBlockMemberText{
-					Value: systemText,
-				},
-			}
-			Logger.DebugContext(ctx, amslog.Event{
-				Name:    "BEDROCK_PARSE_SYSTEM",
-				Message: "Using legacy string format for system",
-				Fields: map[string]interface{}{
-					"tools_added": toolsTextForSystemPrompt != "",
-				},
-			})
-		} else if sysArray, ok := payload["system"].([]interface{}); ok {
-			// System es un array de bloques (con posible cache_control)
-			systemBlocks = convertSystemBlocksWithCache(sysArray, this.config.ForcePromptCaching)
-			
-			// Añadir tools al final del system prompt si existen
-			if toolsTextForSystemPrompt != "" {
-				systemBlocks = append(systemBlocks, &types.SystemContentBlockMemberText{
-					Value: toolsTextForSystemPrompt,
-				})
-			}
-			
-		Logger.InfoContext(ctx, amslog.Event{
-			Name:    "BEDROCK_PARSE_SYSTEM",
-			Message: "Converted system blocks with cache support",
-			Fields: map[string]interface{}{
-				"system_blocks_count":   len(systemBlocks),
-				"tools_added":           toolsTextForSystemPrompt != "",
-				"force_prompt_caching":  this.config.ForcePromptCaching,
-			},
-		})
-	}
-	
-	// LOG DETALLADO: Volcar el contenido completo de los system blocks
-	if len(systemBlocks) > 0 {
-		for i, block := range systemBlocks {
-			if textBlock, ok := block.(*types.SystemContentBlockMemberText); ok {
-				Logger.InfoContext(ctx, amslog.Event{
-					Name:    "BEDROCK_SYSTEM_BLOCK_CONTENT",
-					Message: fmt.Sprintf("System block %d content", i),
-					Fields: map[string]interface{}{
-						"block_index":   i,
-						"content_length": len(textBlock.Value),
-						"content_preview": func() string {
-							if len(textBlock.Value) > 500 {
-								return textBlock.Value[:500] + "... (truncated)"
-							}
-							return textBlock.Value
-						}(),
-						"full_content": textBlock.Value, // Contenido completo para debugging
-					},
-				})
-			} else if _, ok := block.(*types.SystemContentBlockMemberCachePoint); ok {
-				Logger.InfoContext(ctx, amslog.Event{
-					Name:    "BEDROCK_SYSTEM_BLOCK_CONTENT",
-					Message: fmt.Sprintf("System block %d is cache point", i),
-					Fields: map[string]interface{}{
-						"block_index": i,
-						"block_type":  "cache_point",
-					},
-				})
-			}
+		// Mismo builder que /admin/preview: tools, system, max_tokens, messages, recorte, techo de coste y cache points
+		team := ""
+		if user != nil {
+			team = user.Team
 		}
-	}
-		
-		// Extraer max_tokens (prioridad: config > payload > default)
-		maxTokens := int32(DefaultMaxTokens)
-		if this.config.MaxTokens > 0 {
-			maxTokens = int32(this.config.MaxTokens)
-			Logger.DebugContext(ctx, amslog.Event{
-				Name:    "BEDROCK_CONFIG_MAX_TOKENS",
-				Message: "Using max_tokens from config",
-				Fields: map[string]interface{}{
-					"max_tokens": maxTokens,
-				},
-			})
-		} else if mt, ok := payload["max_tokens"].(float64); ok {
-			maxTokens = int32(mt)
-			Logger.DebugContext(ctx, amslog.Event{
-				Name:    "BEDROCK_REQUEST_MAX_TOKENS",
-				Message: "Using max_tokens from request",
-				Fields: map[string]interface{}{
-					"max_tokens": maxTokens,
-				},
-			})
-		}
-
-		// Respetar SIEMPRE el tool_choice que envía el cliente (principio de transparencia)
-		if tc, ok := payload["tool_choice"]; ok {
-			toolChoice = convertAnthropicToolChoiceToBedrock(tc)
-			if toolChoice != nil && toolConfig != nil {
-				// Aplicar el ToolChoice del cliente al ToolConfiguration
-				toolConfig.ToolChoice = toolChoice
-				if this.config.DEBUG {
-					Logger.DebugContext(ctx, amslog.Event{
-						Name:    "BEDROCK_TOOL_CHOICE_APPLIED",
-						Message: "Tool choice from client applied to ToolConfiguration",
-					})
-				}
-			}
-		} else {
-			// Si no se especifica, usar 'auto' por defecto
-			if toolConfig != nil {
-				toolConfig.ToolChoice = &types.ToolChoiceMemberAuto{
-					Value: types.AutoToolChoice{},
-				}
-			}
-		}
-
-		// Extraer y convertir messages
-		var bedrockMessages []types.Message
-		if messages, ok := payload["messages"].([]interface{}); ok {
-			// Validar límite de mensajes
-			if len(messages) > MaxMessagesPerRequest {
-				Logger.ErrorContext(ctx, amslog.Event{
-					Name:    EventProxyRequestError,
-					Message: "Too many messages in request",
-					Outcome: amslog.OutcomeFailure,
-					Error: &amslog.ErrorInfo{
-						Type:    "ValidationError",
-						Message: fmt.Sprintf("Too many messages: %d (max: %d)", len(messages), MaxMessagesPerRequest),
-						Code:    "TOO_MANY_MESSAGES",
-					},
-					Fields: map[string]interface{}{
-						"message_count": len(messages),
-						"max_allowed":   MaxMessagesPerRequest,
-					},
-				})
-				w.Header().Set("Content-Type", "application/json")
-				http.Error(w, fmt.Sprintf(`{"error": "Too many messages: %d (max: %d)"}`, 
-					len(messages), MaxMessagesPerRequest), http.StatusBadRequest)
-				return
-			}
-			
-			bedrockMessages, err = convertAnthropicToBedrockMessages(messages, this.config.ForcePromptCaching)
-			if err != nil {
-				Logger.ErrorContext(ctx, amslog.Event{
-					Name:       EventProxyRequestError,
-					Message:    "Failed to convert messages",
-					Outcome:    amslog.OutcomeFailure,
-					DurationMs: reqCtx.PhaseTimings["parse_request"].Milliseconds(),
-					Error: &amslog.ErrorInfo{
-						Type:    "ConversionError",
-						Message: err.Error(),
-						Code:    "MESSAGE_CONVERSION_FAILED",
-					},
-				})
-				w.Header().Set("Content-Type", "application/json")
-				http.Error(w, fmt.Sprintf(`{"error": "Failed to convert messages: %s"}`, err.Error()), http.StatusBadRequest)
-				return
-			}
+		built, buildErr := this.buildConverseRequest(withToolPromptFormat(ctx, this.toolPromptFormat(r)), payload, modelID, team, requestedModel)
+		endPhase()
+		if buildErr != nil {
+			writeConverseRequestError(ctx, w, buildErr)
+			return
 		}
+		systemBlocks, bedrockMessages, maxTokens := built.SystemBlocks, built.Messages, built.MaxTokens
+		toolConfig, toolChoice, nativeTools := built.ToolConfig, built.ToolChoice, built.NativeTools
 		
-		endPhase()
 		Logger.InfoContext(ctx, amslog.Event{
 			Name:       "BEDROCK_PARSE_COMPLETE",
 			Message:    "Request parsing completed",
@@ -1268,23 +1942,60 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 				"messages_count":      len(bedrockMessages),
 				"system_blocks_count": len(systemBlocks),
 				"max_tokens":          maxTokens,
+				"native_tools":        nativeTools,
 			},
 		})
 
+		opts := built.Options
+		opts.TextBuffer = this.xmlBufferFor(r, nativeTools)
+
+		// Hash del input de Converse para comparar turnos al depurar la caché de prompts
+		this.recordRequestHash(ctx, w, modelID, systemBlocks, bedrockMessages, maxTokens, opts)
+
 		// FASE 3: Streaming con Converse API
 		endPhase = reqCtx.StartPhase("streaming")
+		streamStart := time.Now()
+		
+		// Un cliente que deja de leer no puede retener el stream de Bedrock: abortar si una escritura se bloquea
+		streamCtx := ctx
+		if isStream && !this.config.StreamingDisabled && this.config.StreamWriteTimeout > 0 {
+			var cancelStream context.CancelFunc
+			streamCtx, cancelStream = context.WithCancel(ctx)
+			defer cancelStream()
+			guard := newStallGuardWriter(w, this.config.StreamWriteTimeout, func() {
+				// Registrar antes de cancelar: al cancelar, el handler termina y el stream deja de ser nuestro
+				logClientStalled(ctx, this.config.StreamWriteTimeout)
+				cancelStream()
+			})
+			defer guard.release()
+			w = guard
+		}
+		
+		// Con streaming desactivado (o stream:false) el SSE se acumula en memoria y se envía agregado al terminar
+		var aggregator *streamAggregator
+		var streamWriter http.ResponseWriter = w
+		if !isStream || this.config.StreamingDisabled {
+			aggregator = newStreamAggregator()
+			streamWriter = aggregator
+		}
 		
 		// Crear wrapper para capturar métricas (si hay BD y usuario)
 		var metricsCapture *MetricsCapture
-		var finalWriter http.ResponseWriter = w
+		var finalWriter http.ResponseWriter = streamWriter
 		
 		if this.db != nil && this.metricsWorker != nil && user != nil {
-			metricsCapture = NewMetricsCapture(w, modelID, requestID, r)
+			metricsCapture = NewMetricsCapture(streamWriter, modelID, requestID, r)
+			metricsCapture.SetProjectID(this.resolveProjectID(r, user))
+			metricsCapture.SetMaxTokens(int(maxTokens))
+			metricsCapture.SetRequestedModel(requestedModel)
+			metricsCapture.SetQueueWaitMs(queueWait.Milliseconds())
 			finalWriter = metricsCapture
 		}
 
 		// Usar Converse API directamente con system blocks
-		if err := this.handleBedrockStreamConverse(finalWriter, this.client, modelID, systemBlocks, bedrockMessages, maxTokens, toolConfig, toolChoice); err != nil {
+		streamErr := this.handleBedrockStreamConverse(streamCtx, finalWriter, this.client, modelID, systemBlocks, bedrockMessages, maxTokens, toolConfig, toolChoice, opts)
+		if streamErr != nil {
+			errorClass := classifyBedrockError(streamErr)
 			Logger.ErrorContext(ctx, amslog.Event{
 				Name:       EventBedrockError,
 				Message:    "Streaming failed",
@@ -1292,21 +2003,36 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 				DurationMs: reqCtx.PhaseTimings["streaming"].Milliseconds(),
 				Error: &amslog.ErrorInfo{
 					Type:    "StreamingError",
-					Message: err.Error(),
-					Code:    "BEDROCK_STREAM_FAILED",
+					Message: streamErr.Error(),
+					Code:    string(errorClass.Code),
+				},
+				Fields: map[string]interface{}{
+					"http.response.status_code": errorClass.StatusCode,
 				},
 			})
 			
 			// Marcar error en MetricsCapture si existe
 			if metricsCapture != nil {
-				metricsCapture.MarkError(err.Error())
+				metricsCapture.MarkError(streamErr.Error())
 			}
 			
-			// IMPORTANTE: No retornar aquí, el error ya fue enviado como evento SSE
+			// IMPORTANTE: No retornar aquí, el error ya fue enviado como evento SSE (o irá en la respuesta agregada)
 			// El cliente (Cline) recibirá el evento de error y lo procesará
 		}
 		
+		// Respuesta agregada: un error a mitad de stream se devuelve con el status de su clase
+		if aggregator != nil {
+			errorStatus := http.StatusBadGateway
+			if streamErr != nil {
+				errorStatus = classifyBedrockError(streamErr).StatusCode
+			}
+			aggregator.writeResponse(w, errorStatus)
+		}
+		
 		endPhase()
+		if metricsCapture != nil {
+			metricsCapture.SetStreamDurationMs(time.Since(streamStart).Milliseconds())
+		}
 		
 		Logger.InfoContext(ctx, amslog.Event{
 			Name:       EventBedrockStreamComplete,
@@ -1316,13 +2042,19 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 		})
 		
 		// POST-PROCESSING: Procesar métricas en goroutine (si hay captura)
+		slowChecked = true
 		if metricsCapture != nil && user != nil {
+			// El post-processing sobrevive a la request pero con su propio límite de tiempo
+			postCtx, postCancel := this.newPostProcessContext(ctx)
+			this.postProcessing.Add(1)
 			go func() {
+				defer this.postProcessing.Done()
+				defer postCancel()
 				endPhase := reqCtx.StartPhase("post_processing")
-				this.processMetrics(context.Background(), user, metricsCapture, startTime)
+				this.processMetrics(postCtx, user, metricsCapture, startTime)
 				endPhase()
 				
-				Logger.InfoContext(ctx, amslog.Event{
+				Logger.InfoContext(postCtx, amslog.Event{
 					Name:       "METRICS_POST_PROCESS",
 					Message:    "Metrics post-processing completed",
 					Outcome:    amslog.OutcomeSuccess,
@@ -1330,45 +2062,104 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 				})
 				
 				// Log final con resumen
-				reqCtx.LogSummary()
-				Logger.InfoContext(ctx, amslog.Event{
+				reqCtx.LogSummary(postCtx)
+				reqCtx.LogIfSlow(postCtx, this.config.SlowRequestThreshold)
+				Logger.InfoContext(postCtx, amslog.Event{
 					Name:       EventProxyRequestEnd,
 					Message:    "Request completed successfully",
 					Outcome:    amslog.OutcomeSuccess,
 					DurationMs: reqCtx.GetTotalDuration().Milliseconds(),
 					Fields: map[string]interface{}{
-						"user.id": user.UserID,
+						"user.id":   user.UserID,
+						"phases_ms": reqCtx.PhaseTimingsMs(),
 					},
 				})
 			}()
 		} else {
-			reqCtx.LogSummary()
+			reqCtx.LogSummary(ctx)
+			reqCtx.LogIfSlow(ctx, this.config.SlowRequestThreshold)
 			Logger.InfoContext(ctx, amslog.Event{
 				Name:       EventProxyRequestEnd,
 				Message:    "Request completed successfully",
 				Outcome:    amslog.OutcomeSuccess,
 				DurationMs: reqCtx.GetTotalDuration().Milliseconds(),
+				Fields: map[string]interface{}{
+					"phases_ms": reqCtx.PhaseTimingsMs(),
+				},
 			})
 		}
 		
 		return
 	}
 
-	// FASE 2: Llamada HTTP a Bedrock (no-stream)
-	endPhase = reqCtx.StartPhase("bedrock_call")
-	
-	httpClient := http.DefaultClient
-	if this.config.DEBUG {
-		httpClient = &http.Client{
-			Transport: loggingRoundTripper{
-				wrapped: http.DefaultTransport,
+	// El path no-stream no inyecta tools en el system prompt: avisar y, si se configura, rechazar
+	if hasTools(originalBodyBytes) {
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventNonStreamToolsUnsupported,
+			Message: "Non-streaming request with tools: tools are not injected in the non-stream path",
+			Fields: map[string]interface{}{
+				"rejected": this.config.RejectNonStreamTools,
 			},
+		})
+		if this.config.RejectNonStreamTools {
+			writeErrorResponse(w, ErrCodeNonStreamToolsUnsupported, "non-streaming tool requests not yet supported")
+			return
 		}
 	}
 
-	resp, err := httpClient.Do(cloneReq)
+	// Techo de coste por request: el max_tokens recortado se escribe en el body y se vuelve a firmar
+	if clampedMaxTokens, clamped, ceilingErr := this.enforceRawCostCeiling(ctx, modelID, originalBodyBytes); ceilingErr != nil {
+		writeCostCeilingRejection(ctx, w, ceilingErr)
+		return
+	} else if clamped {
+		r.Body = io.NopCloser(bytes.NewReader(originalBodyBytes))
+		if cloneReq, _, err = this.signRequest(r, user.DefaultInferenceProfile, int(clampedMaxTokens)); err != nil {
+			writeErrorResponse(w, ErrCodeSignRequestFailed, err.Error())
+			return
+		}
+	}
+
+	// FASE 2: Llamada HTTP a Bedrock (no-stream)
+	endPhase = reqCtx.StartPhase("bedrock_call")
+	
+	httpClient := this.outboundClient()
+
+	// El deadline de Bedrock cubre la llamada y la lectura del body (se cancela al salir del handler)
+	callCtx, cancelCall := this.bedrockCallContext(ctx)
+	defer cancelCall()
+
+	var resp *http.Response
+	hedged := false
+	callStart := time.Now()
+	if this.config.HedgingEnabled {
+		resp, hedged, err = doHedged(callCtx, httpClient, cloneReq, this.hedgingDelay())
+	} else {
+		resp, err = httpClient.Do(cloneReq.WithContext(callCtx))
+	}
 	endPhase()
 	
+	if err == nil && this.hedgeLatencies != nil {
+		this.hedgeLatencies.record(time.Since(callStart))
+	}
+	
+	if err = asBedrockTimeout(ctx, callCtx, err); errors.Is(err, errBedrockTimeout) {
+		Logger.ErrorContext(ctx, amslog.Event{
+			Name:       EventBedrockError,
+			Message:    "Bedrock API call timed out",
+			Outcome:    amslog.OutcomeFailure,
+			DurationMs: reqCtx.PhaseTimings["bedrock_call"].Milliseconds(),
+			Error: &amslog.ErrorInfo{
+				Type:    "BedrockTimeout",
+				Message: err.Error(),
+				Code:    string(ErrCodeBedrockTimeout),
+			},
+			Fields: map[string]interface{}{
+				"bedrock.timeout_ms": this.config.BedrockTimeout.Milliseconds(),
+			},
+		})
+		writeErrorResponse(w, ErrCodeBedrockTimeout, err.Error())
+		return
+	}
 	if err != nil {
 		Logger.ErrorContext(ctx, amslog.Event{
 			Name:       EventBedrockError,
@@ -1378,11 +2169,10 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 			Error: &amslog.ErrorInfo{
 				Type:    "BedrockAPIError",
 				Message: err.Error(),
-				Code:    "BEDROCK_CALL_FAILED",
+				Code:    string(ErrCodeBedrockCallFailed),
 			},
 		})
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		writeErrorResponse(w, ErrCodeBedrockCallFailed, err.Error())
 		return
 	}
 	
@@ -1393,15 +2183,36 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 		DurationMs: reqCtx.PhaseTimings["bedrock_call"].Milliseconds(),
 		Fields: map[string]interface{}{
 			"http.response.status_code": resp.StatusCode,
+			"hedged":                    hedged,
 		},
 	})
 
-	// Write modified response
-	for k, v := range resp.Header {
-		w.Header()[k] = v
+	// Normalizar el status de errores de Bedrock según el tipo de excepción
+	// Las excepciones no reconocidas conservan el status original de Bedrock
+	statusCode := resp.StatusCode
+	if statusCode >= 400 {
+		errorCode := parseAmznErrorType(resp.Header.Get("X-Amzn-ErrorType"))
+		if code := classifyBedrockErrorCode(errorCode); code != ErrCodeBedrockStreamFailed {
+			statusCode = code.StatusCode()
+		}
+	}
+
+	// Capturar el usage de la respuesta JSON para facturar igual que en streaming (si hay BD)
+	var metricsCapture *MetricsCapture
+	var responseWriter http.ResponseWriter = w
+	if this.db != nil && this.metricsWorker != nil {
+		metricsCapture = NewMetricsCapture(w, modelID, requestID, r)
+		metricsCapture.SetProjectID(this.resolveProjectID(r, user))
+		metricsCapture.SetJSONResponse()
+		metricsCapture.SetRequestedModel(requestedModel)
+		metricsCapture.SetQueueWaitMs(queueWait.Milliseconds())
+		responseWriter = metricsCapture
 	}
-	w.WriteHeader(resp.StatusCode)
-	_, err = io.Copy(w, resp.Body)
+
+	// Write modified response: solo las cabeceras permitidas, sin sobrescribir las del proxy
+	copyAllowedHeaders(w.Header(), resp.Header, this.forwardResponseHeaders())
+	responseWriter.WriteHeader(statusCode)
+	_, err = io.Copy(responseWriter, resp.Body)
 	if err != nil {
 		Logger.ErrorContext(ctx, amslog.Event{
 			Name:    EventProxyRequestError,
@@ -1410,13 +2221,29 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 			Error: &amslog.ErrorInfo{
 				Type:    "ResponseCopyError",
 				Message: err.Error(),
-				Code:    "RESPONSE_COPY_FAILED",
+				Code:    string(ErrCodeResponseCopyFailed),
 			},
 		})
+		if metricsCapture != nil {
+			metricsCapture.MarkError(err.Error())
+		}
+	}
+	
+	// POST-PROCESSING: facturar el usage de la respuesta (mismo processMetrics que streaming)
+	if metricsCapture != nil {
+		postCtx, postCancel := this.newPostProcessContext(ctx)
+		this.postProcessing.Add(1)
+		go func() {
+			defer this.postProcessing.Done()
+			defer postCancel()
+			this.processMetrics(postCtx, user, metricsCapture, startTime)
+		}()
 	}
 	
 	// Log final
-	reqCtx.LogSummary()
+	slowChecked = true
+	reqCtx.LogSummary(ctx)
+	reqCtx.LogIfSlow(ctx, this.config.SlowRequestThreshold)
 	Logger.InfoContext(ctx, amslog.Event{
 		Name:       EventProxyRequestEnd,
 		Message:    "Request completed successfully",
@@ -1424,6 +2251,7 @@ func (this *BedrockClient) HandleProxy(w http.ResponseWriter, r *http.Request) {
 		DurationMs: reqCtx.GetTotalDuration().Milliseconds(),
 		Fields: map[string]interface{}{
 			"http.response.status_code": resp.StatusCode,
+			"phases_ms":                 reqCtx.PhaseTimingsMs(),
 		},
 	})
 }
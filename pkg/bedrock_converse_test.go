@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+	"bedrock-proxy-test/pkg/metrics"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// newRecordingConverseClient crea un cliente de Bedrock Runtime que responde con el stream dado
+// y guarda el body de la última request en requestBody
+func newRecordingConverseClient(body []byte, requestBody *string) *bedrockRuntime.Client {
+	return bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			received, _ := io.ReadAll(req.Body)
+			*requestBody = string(received)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+				Body:       io.NopCloser(strings.NewReader(string(body))),
+			}, nil
+		})},
+	})
+}
+
+// testNonStreamEvents es un stream de Converse con reasoning, texto y una tool call nativa
+var testNonStreamEvents = [][2]string{
+	{"messageStart", `{"role":"assistant"}`},
+	{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"text":"Necesito leer el fichero"}}}`},
+	{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"signature":"sig-123"}}}`},
+	{"contentBlockStop", `{"contentBlockIndex":0}`},
+	{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"text":"Leyendo el fichero"}}`},
+	{"contentBlockStop", `{"contentBlockIndex":1}`},
+	{"contentBlockStart", `{"contentBlockIndex":2,"start":{"toolUse":{"toolUseId":"tool-1","name":"read_file"}}}`},
+	{"contentBlockDelta", `{"contentBlockIndex":2,"delta":{"toolUse":{"input":"{\"path\": \"main.go\"}"}}}`},
+	{"contentBlockStop", `{"contentBlockIndex":2}`},
+	{"messageStop", `{"stopReason":"tool_use"}`},
+	{"metadata", `{"usage":{"inputTokens":42,"outputTokens":7,"totalTokens":49,"cacheReadInputTokens":100,"cacheWriteInputTokens":3},"metrics":{"latencyMs":120}}`},
+}
+
+// decodeAggregatedResponse decodifica la respuesta JSON de una request con stream:false
+func decodeAggregatedResponse(t *testing.T, rec *httptest.ResponseRecorder) aggregatedMessage {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Expected JSON response, got %q", got)
+	}
+	var response aggregatedMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid response: %v (%s)", err, rec.Body.String())
+	}
+	return response
+}
+
+func TestHandleProxyNonStreamAggregatesConverseStream(t *testing.T) {
+	setupTestLogger(t)
+
+	var requestBody string
+	client := newTestBedrockClient()
+	client.config.NativeTools = true
+	client.client = newRecordingConverseClient(newConverseStreamBody(t, testNonStreamEvents), &requestBody)
+	client.db = &database.Database{}
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"stream":     false,
+		"max_tokens": 100,
+		"system":     "Eres un asistente",
+		"messages":   []interface{}{map[string]interface{}{"role": "user", "content": "lee main.go"}},
+		"tools":      buildTestTools(1, ""),
+	})
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(string(payload)))
+
+	response := decodeAggregatedResponse(t, rec)
+	if response.Usage.InputTokens != 42 || response.Usage.OutputTokens != 7 ||
+		response.Usage.CacheReadInputTokens != 100 || response.Usage.CacheCreationInputTokens != 3 {
+		t.Errorf("Expected usage with input/output tokens, got %+v", response.Usage)
+	}
+	if response.StopReason == nil || *response.StopReason != "tool_use" {
+		t.Errorf("Expected tool_use stop reason, got %v", response.StopReason)
+	}
+	if len(response.Content) != 3 {
+		t.Fatalf("Expected thinking, text and tool_use blocks, got %+v", response.Content)
+	}
+	if response.Content[0]["type"] != "thinking" || response.Content[0]["signature"] != "sig-123" {
+		t.Errorf("Expected the thinking block with its signature, got %+v", response.Content[0])
+	}
+	if response.Content[1]["text"] != "Leyendo el fichero" {
+		t.Errorf("Expected the text block, got %+v", response.Content[1])
+	}
+	if response.Content[2]["type"] != "tool_use" || response.Content[2]["name"] != "read_file" {
+		t.Errorf("Expected the tool_use block, got %+v", response.Content[2])
+	}
+
+	// Las tools se envían igual que en streaming
+	if !strings.Contains(requestBody, "tool_0") || !strings.Contains(requestBody, "Eres un asistente") {
+		t.Errorf("Expected tools and system prompt in the Bedrock request, got %s", requestBody)
+	}
+
+	// El usage se factura con el mismo post-processing (asíncrono)
+	client.postProcessing.Wait()
+	if got := client.metricsWorker.Stats().BufferedCount; got != 1 {
+		t.Fatalf("Expected non-stream usage to be recorded, got %d records", got)
+	}
+}
+
+func TestHandleProxyNonStreamKeepsXMLToolCallsWhole(t *testing.T) {
+	setupTestLogger(t)
+
+	// En modo XML las tool calls llegan como texto partido entre chunks
+	client := newTestBedrockClient()
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Voy a leerlo\n<read_"}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"file>\n<path>main.go</pa"}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"th>\n</read_file>"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":5,"outputTokens":9,"totalTokens":14},"metrics":{"latencyMs":10}}`},
+	}))
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"stream":     false,
+		"max_tokens": 100,
+		"messages":   []interface{}{map[string]interface{}{"role": "user", "content": "lee main.go"}},
+		"tools":      buildTestTools(1, ""),
+	})
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(string(payload)))
+
+	response := decodeAggregatedResponse(t, rec)
+	want := "Voy a leerlo\n<read_file>\n<path>main.go</path>\n</read_file>"
+	if len(response.Content) != 1 || response.Content[0]["text"] != want {
+		t.Errorf("Expected the XML tool call as a single text block, got %+v", response.Content)
+	}
+}
+
+func TestHandleProxyNonStreamRetriesThrottling(t *testing.T) {
+	setupTestLogger(t)
+
+	var calls atomic.Int32
+	client := newTestBedrockClient()
+	client.config.StreamRetryMax = 2
+	client.config.StreamRetryBaseDelay = time.Millisecond
+	client.client = newFlakyConverseClient(1, "ThrottlingException", newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hola"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":5,"outputTokens":1,"totalTokens":6},"metrics":{"latencyMs":10}}`},
+	}), &calls)
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": false, "max_tokens": 100, "messages": [{"role": "user", "content": "Hola"}]}`))
+
+	response := decodeAggregatedResponse(t, rec)
+	if len(response.Content) != 1 || response.Content[0]["text"] != "Hola" {
+		t.Errorf("Expected the retried response, got %+v", response.Content)
+	}
+	if got := calls.Load(); got < 2 {
+		t.Errorf("Expected the throttled call to be retried, got %d calls", got)
+	}
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/smithy-go"
+)
+
+// BedrockErrorClass describe cómo se expone al cliente un error de Bedrock
+type BedrockErrorClass struct {
+	StatusCode int       // Código HTTP devuelto al cliente
+	ErrorType  string    // Tipo de error en formato Anthropic
+	Code       ErrorCode // Código estable (logs y respuesta)
+	Message    string    // Mensaje original de Bedrock
+}
+
+// IsClientError indica si el error lo ha causado la request del cliente (4xx)
+func (c BedrockErrorClass) IsClientError() bool {
+	return c.StatusCode >= 400 && c.StatusCode < 500
+}
+
+// classifyBedrockErrorCode mapea el código de excepción de Bedrock a un código del registro
+// (que determina status y tipo Anthropic). Los errores no reconocidos se tratan como fallo
+// genuino del upstream (502). También clasifica las excepciones que ConverseStream envía a mitad de
+// stream, que se reenvían al cliente como evento SSE error
+func classifyBedrockErrorCode(errorCode string) ErrorCode {
+	switch errorCode {
+	case "ValidationException":
+		return ErrCodeBedrockValidation
+	case "AccessDeniedException":
+		return ErrCodeBedrockAccessDenied
+	case "ResourceNotFoundException":
+		return ErrCodeBedrockResourceNotFound
+	case "ThrottlingException", "ServiceQuotaExceededException":
+		return ErrCodeBedrockThrottled
+	case "ServiceUnavailableException", "ModelNotReadyException":
+		return ErrCodeBedrockUnavailable
+	case "ModelTimeoutException":
+		return ErrCodeBedrockTimeout
+	case "InternalServerException", "ModelStreamErrorException":
+		return ErrCodeBedrockStreamFailed
+	default:
+		return ErrCodeBedrockStreamFailed
+	}
+}
+
+// classifyBedrockError clasifica un error devuelto por el SDK de Bedrock
+func classifyBedrockError(err error) BedrockErrorClass {
+	if errors.Is(err, errBedrockTimeout) {
+		code := ErrCodeBedrockTimeout
+		return BedrockErrorClass{StatusCode: code.StatusCode(), ErrorType: code.ErrorType(), Code: code, Message: err.Error()}
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := classifyBedrockErrorCode(apiErr.ErrorCode())
+		message := apiErr.ErrorMessage()
+		if message == "" {
+			message = err.Error()
+		}
+		return BedrockErrorClass{StatusCode: code.StatusCode(), ErrorType: code.ErrorType(), Code: code, Message: message}
+	}
+
+	code := classifyBedrockErrorCode("")
+	return BedrockErrorClass{StatusCode: code.StatusCode(), ErrorType: code.ErrorType(), Code: code, Message: err.Error()}
+}
+
+// parseAmznErrorType extrae el nombre de la excepción del header X-Amzn-ErrorType
+// Formato: "ValidationException:http://internal.amazon.com/coral/..."
+func parseAmznErrorType(header string) string {
+	if idx := strings.Index(header, ":"); idx >= 0 {
+		header = header[:idx]
+	}
+	return strings.TrimSpace(header)
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func TestClassifyBedrockError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedType   string
+	}{
+		{"validation", &types.ValidationException{Message: aws.String("bad schema")}, http.StatusBadRequest, "invalid_request_error"},
+		{"access denied", &types.AccessDeniedException{Message: aws.String("no access")}, http.StatusForbidden, "permission_error"},
+		{"resource not found", &types.ResourceNotFoundException{Message: aws.String("no model")}, http.StatusNotFound, "not_found_error"},
+		{"throttling", &types.ThrottlingException{Message: aws.String("slow down")}, http.StatusTooManyRequests, "rate_limit_error"},
+		{"service unavailable", &types.ServiceUnavailableException{Message: aws.String("down")}, http.StatusServiceUnavailable, "overloaded_error"},
+		{"internal server", &types.InternalServerException{Message: aws.String("boom")}, http.StatusBadGateway, "api_error"},
+		{"model stream error", &types.ModelStreamErrorException{Message: aws.String("stream broke")}, http.StatusBadGateway, "api_error"},
+		{"model timeout", &types.ModelTimeoutException{Message: aws.String("too slow")}, http.StatusGatewayTimeout, "api_error"},
+		{"network", errors.New("connection reset"), http.StatusBadGateway, "api_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class := classifyBedrockError(fmt.Errorf("failed to start converse stream: %w", tt.err))
+			if class.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, class.StatusCode)
+			}
+			if class.ErrorType != tt.expectedType {
+				t.Errorf("Expected type %s, got %s", tt.expectedType, class.ErrorType)
+			}
+		})
+	}
+}
+
+func TestClassifyBedrockErrorKeepsBedrockMessage(t *testing.T) {
+	class := classifyBedrockError(&types.ValidationException{Message: aws.String("tools.0.input_schema is invalid")})
+	if class.Message != "tools.0.input_schema is invalid" {
+		t.Errorf("Expected Bedrock message, got %q", class.Message)
+	}
+	if !class.IsClientError() {
+		t.Error("Expected validation error to be a client error")
+	}
+}
+
+func TestParseAmznErrorType(t *testing.T) {
+	if got := parseAmznErrorType("ValidationException:http://internal.amazon.com/coral/com.amazon.bedrock/"); got != "ValidationException" {
+		t.Errorf("Expected ValidationException, got %s", got)
+	}
+	if got := parseAmznErrorType("AccessDeniedException"); got != "AccessDeniedException" {
+		t.Errorf("Expected AccessDeniedException, got %s", got)
+	}
+}
+
+func TestWriteBedrockErrorResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeBedrockErrorResponse(rec, classifyBedrockError(&types.ResourceNotFoundException{Message: aws.String("model not found")}))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected JSON body: %v", err)
+	}
+	errorBody := body["error"].(map[string]interface{})
+	if errorBody["type"] != "not_found_error" || errorBody["message"] != "model not found" {
+		t.Errorf("Unexpected error body: %v", errorBody)
+	}
+}
+
+// newConverseStreamException codifica una excepción de ConverseStream a mitad de stream
+func newConverseStreamException(t *testing.T, exceptionType, message string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	msg := eventstream.Message{Payload: []byte(fmt.Sprintf(`{"message":%q}`, message))}
+	msg.Headers.Set(":message-type", eventstream.StringValue("exception"))
+	msg.Headers.Set(":exception-type", eventstream.StringValue(exceptionType))
+	msg.Headers.Set(":content-type", eventstream.StringValue("application/json"))
+	if err := eventstream.NewEncoder().Encode(&buf, msg); err != nil {
+		t.Fatalf("Failed to encode exception %s: %v", exceptionType, err)
+	}
+	return buf.Bytes()
+}
+
+func TestConverseStreamMidStreamErrorEvent(t *testing.T) {
+	tests := []struct {
+		exceptionType string
+		expectedType  string
+	}{
+		{"throttlingException", "rate_limit_error"},
+		{"serviceUnavailableException", "overloaded_error"},
+		{"modelStreamErrorException", "api_error"},
+		{"internalServerException", "api_error"},
+		{"validationException", "invalid_request_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exceptionType, func(t *testing.T) {
+			setupTestLogger(t)
+
+			// El stream ya ha enviado contenido cuando llega la excepción
+			body := newConverseStreamBody(t, [][2]string{
+				{"messageStart", `{"role":"assistant"}`},
+				{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hola"}}`},
+			})
+			body = append(body, newConverseStreamException(t, tt.exceptionType, "stream interrupted")...)
+
+			client := newTestBedrockClient()
+			rec := httptest.NewRecorder()
+			err := client.handleBedrockStreamConverse(context.Background(), rec, newStubConverseClient(body), "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, nil, nil, converseOptions{})
+			if err == nil {
+				t.Fatal("Expected the stream error to be returned")
+			}
+
+			output := rec.Body.String()
+			if !strings.Contains(output, `"text":"Hola"`) {
+				t.Errorf("Expected content sent before the failure, got\n%s", output)
+			}
+			if strings.Contains(output, "message_stop") {
+				t.Errorf("Expected no message_stop after a mid-stream failure, got\n%s", output)
+			}
+
+			lastEvent := output[strings.LastIndex(output, "event: "):]
+			data, ok := strings.CutPrefix(strings.SplitN(lastEvent, "\n", 3)[1], "data: ")
+			if !strings.HasPrefix(lastEvent, "event: error\n") || !ok {
+				t.Fatalf("Expected the stream to end with an error event, got\n%s", output)
+			}
+			var event struct {
+				Type  string `json:"type"`
+				Error struct {
+					Type    string `json:"type"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatalf("Invalid error event %q: %v", data, err)
+			}
+			if event.Type != "error" || event.Error.Type != tt.expectedType || !strings.Contains(event.Error.Message, "stream interrupted") {
+				t.Errorf("Expected error event of type %s, got %+v", tt.expectedType, event)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Valores por defecto del hedging de requests no-stream
+const (
+	DefaultHedgingDelay      = 2 * time.Second
+	DefaultHedgingPercentile = 95
+	hedgingLatencyWindow     = 200 // Latencias recientes usadas para calcular el percentil
+	hedgingMinSamples        = 20  // Muestras mínimas antes de usar el percentil en vez del delay fijo
+)
+
+// latencyTracker guarda las latencias recientes de Bedrock (no-stream) en un buffer circular
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: make([]time.Duration, 0, hedgingLatencyWindow)}
+}
+
+// record añade una latencia, sobrescribiendo la más antigua si la ventana está llena
+func (t *latencyTracker) record(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < hedgingLatencyWindow {
+		t.samples = append(t.samples, latency)
+		return
+	}
+	t.samples[t.next] = latency
+	t.next = (t.next + 1) % hedgingLatencyWindow
+}
+
+// percentile devuelve el percentil p de las latencias registradas (false si no hay muestras suficientes)
+func (t *latencyTracker) percentile(p int) (time.Duration, bool) {
+	t.mu.Lock()
+	sorted := make([]time.Duration, len(t.samples))
+	copy(sorted, t.samples)
+	t.mu.Unlock()
+
+	if len(sorted) < hedgingMinSamples || p <= 0 || p > 100 {
+		return 0, false
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := (len(sorted)*p + 99) / 100
+	if idx > 0 {
+		idx--
+	}
+	return sorted[idx], true
+}
+
+// hedgingDelay calcula cuánto esperar antes de lanzar la request de respaldo
+// Usa el percentil configurado de las latencias observadas; sin muestras suficientes, el delay fijo
+func (this *BedrockClient) hedgingDelay() time.Duration {
+	if this.hedgeLatencies != nil {
+		if delay, ok := this.hedgeLatencies.percentile(this.config.HedgingPercentile); ok {
+			return delay
+		}
+	}
+	if this.config.HedgingDelay > 0 {
+		return this.config.HedgingDelay
+	}
+	return DefaultHedgingDelay
+}
+
+// hedgeResult es el resultado de uno de los intentos de una request con hedging
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	hedged bool
+}
+
+// cancelOnCloseBody cancela el contexto del intento ganador cuando se termina de leer su body
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// doHedged envía la request firmada y, si Bedrock no responde en delay, lanza una segunda copia idéntica.
+// Gana la primera respuesta sin error de transporte; el perdedor se cancela y su body se descarta,
+// por lo que solo se reenvía (y se factura) la respuesta ganadora.
+// Solo debe usarse con invocaciones no-stream, que son idempotentes.
+func doHedged(ctx context.Context, httpClient *http.Client, req *http.Request, delay time.Duration) (*http.Response, bool, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+
+	launch := func(hedged bool) {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+
+		attempt := req.Clone(attemptCtx)
+		attempt.Body = io.NopCloser(bytes.NewReader(body))
+		attempt.ContentLength = int64(len(body))
+
+		go func() {
+			resp, err := httpClient.Do(attempt)
+			results <- hedgeResult{resp: resp, err: err, hedged: hedged}
+		}()
+	}
+
+	launch(false)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if len(cancels) == 1 {
+				launch(true)
+				pending++
+			}
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				lastErr = result.err
+				continue
+			}
+
+			// Cancelar el resto de intentos y descartar sus respuestas
+			winner := 0
+			if result.hedged {
+				winner = 1
+			}
+			for i, cancel := range cancels {
+				if i != winner {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				go drainHedgeResults(results, pending)
+			}
+
+			result.resp.Body = &cancelOnCloseBody{ReadCloser: result.resp.Body, cancel: cancels[winner]}
+			return result.resp, result.hedged, nil
+		}
+	}
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return nil, len(cancels) > 1, lastErr
+}
+
+// drainHedgeResults cierra los bodies de los intentos perdedores cuando terminan
+func drainHedgeResults(results <-chan hedgeResult, pending int) {
+	for i := 0; i < pending; i++ {
+		if result := <-results; result.resp != nil {
+			result.resp.Body.Close()
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripFunc permite stubear el transporte HTTP en los tests
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func newStubResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestDoHedgedHedgeWinsWhenPrimaryIsSlow(t *testing.T) {
+	var calls int32
+	primaryCancelled := make(chan struct{})
+
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		if string(body) != `{"prompt":"hola"}` {
+			t.Errorf("Expected identical body on every attempt, got %s", body)
+		}
+
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// Primer intento lento: solo termina cuando se cancela
+			select {
+			case <-req.Context().Done():
+				close(primaryCancelled)
+				return nil, req.Context().Err()
+			case <-time.After(5 * time.Second):
+				return newStubResponse(`{"from":"primary"}`), nil
+			}
+		}
+		return newStubResponse(`{"from":"hedge"}`), nil
+	})}
+
+	req, _ := http.NewRequest(http.MethodPost, "https://bedrock-runtime.eu-west-1.amazonaws.com/model/x/invoke", strings.NewReader(`{"prompt":"hola"}`))
+
+	resp, hedged, err := doHedged(context.Background(), httpClient, req, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if !hedged {
+		t.Error("Expected the hedge request to win")
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != `{"from":"hedge"}` {
+		t.Errorf("Expected hedge response, got %s", body)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("Expected 2 attempts, got %d", got)
+	}
+
+	select {
+	case <-primaryCancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slow primary request to be cancelled")
+	}
+}
+
+func TestDoHedgedSkipsHedgeWhenPrimaryIsFast(t *testing.T) {
+	var calls int32
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return newStubResponse(`{"from":"primary"}`), nil
+	})}
+
+	req, _ := http.NewRequest(http.MethodPost, "https://bedrock-runtime.eu-west-1.amazonaws.com/model/x/invoke", strings.NewReader(`{}`))
+
+	resp, hedged, err := doHedged(context.Background(), httpClient, req, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if hedged {
+		t.Error("Expected primary request to win")
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Expected a single attempt, got %d", got)
+	}
+}
+
+func TestHedgingDelayUsesPercentile(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.HedgingDelay = 3 * time.Second
+	client.config.HedgingPercentile = 90
+	client.hedgeLatencies = newLatencyTracker()
+
+	if got := client.hedgingDelay(); got != 3*time.Second {
+		t.Errorf("Expected fixed delay without samples, got %s", got)
+	}
+
+	for i := 1; i <= 100; i++ {
+		client.hedgeLatencies.record(time.Duration(i) * time.Millisecond)
+	}
+	if got := client.hedgingDelay(); got != 90*time.Millisecond {
+		t.Errorf("Expected p90 delay of 90ms, got %s", got)
+	}
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// imageFormats mapea los media_type de imagen de Anthropic al formato de Bedrock
+var imageFormats = map[string]types.ImageFormat{
+	"image/png":  types.ImageFormatPng,
+	"image/jpeg": types.ImageFormatJpeg,
+	"image/jpg":  types.ImageFormatJpeg, // Alias habitual en clientes
+	"image/gif":  types.ImageFormatGif,
+	"image/webp": types.ImageFormatWebp,
+}
+
+// convertImageBlock convierte un bloque image de Anthropic (source base64 con media_type) en un bloque
+// de imagen de Bedrock. Los formatos o fuentes no soportados devuelven error en vez de descartar la
+// imagen: el modelo respondería sin ella sin que el cliente lo sepa
+func convertImageBlock(blockMap map[string]interface{}) (types.ContentBlock, error) {
+	source, ok := blockMap["source"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("image block without source")
+	}
+
+	sourceType, _ := source["type"].(string)
+	if sourceType != "base64" {
+		return nil, fmt.Errorf("unsupported image source type %q: only base64 images are supported", sourceType)
+	}
+
+	mediaType, _ := source["media_type"].(string)
+	format, ok := imageFormats[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image media_type %q: supported types are image/png, image/jpeg, image/gif and image/webp", mediaType)
+	}
+
+	data, _ := source["data"].(string)
+	imageBytes, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 image data: %w", err)
+	}
+	if len(imageBytes) == 0 {
+		return nil, fmt.Errorf("empty image data")
+	}
+
+	return &types.ContentBlockMemberImage{
+		Value: types.ImageBlock{
+			Format: format,
+			Source: &types.ImageSourceMemberBytes{
+				Value: imageBytes,
+			},
+		},
+	}, nil
+}
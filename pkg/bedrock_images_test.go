@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// encodeTestImage genera una imagen de 2x2 píxeles en el formato indicado
+func encodeTestImage(t *testing.T, format string) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("Failed to encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+// imageMessage construye un mensaje user con texto y una imagen base64
+func imageMessage(mediaType string, data []byte) []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{"type": "text", "text": "¿Qué hay en la imagen?"},
+				map[string]interface{}{
+					"type": "image",
+					"source": map[string]interface{}{
+						"type":       "base64",
+						"media_type": mediaType,
+						"data":       base64.StdEncoding.EncodeToString(data),
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestConvertImageBlocks(t *testing.T) {
+	cases := []struct {
+		mediaType string
+		format    types.ImageFormat
+		data      []byte
+	}{
+		{"image/png", types.ImageFormatPng, encodeTestImage(t, "png")},
+		{"image/jpeg", types.ImageFormatJpeg, encodeTestImage(t, "jpeg")},
+	}
+	for _, tc := range cases {
+		messages, err := convertAnthropicToBedrockMessages(imageMessage(tc.mediaType, tc.data), false, 0, false)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.mediaType, err)
+		}
+		if len(messages) != 1 || len(messages[0].Content) != 2 {
+			t.Fatalf("%s: expected text and image blocks, got %+v", tc.mediaType, messages)
+		}
+		imageBlock, ok := messages[0].Content[1].(*types.ContentBlockMemberImage)
+		if !ok {
+			t.Fatalf("%s: expected ContentBlockMemberImage, got %T", tc.mediaType, messages[0].Content[1])
+		}
+		if imageBlock.Value.Format != tc.format {
+			t.Errorf("%s: expected format %s, got %s", tc.mediaType, tc.format, imageBlock.Value.Format)
+		}
+		source, ok := imageBlock.Value.Source.(*types.ImageSourceMemberBytes)
+		if !ok || !bytes.Equal(source.Value, tc.data) {
+			t.Errorf("%s: expected the decoded image bytes", tc.mediaType)
+		}
+	}
+}
+
+func TestConvertImageBlockRejectsUnsupportedImages(t *testing.T) {
+	pngData := encodeTestImage(t, "png")
+	cases := map[string][]interface{}{
+		`unsupported image media_type "image/bmp"`: imageMessage("image/bmp", pngData),
+		"invalid base64 image data": {map[string]interface{}{
+			"role": "user",
+			"content": []interface{}{map[string]interface{}{
+				"type":   "image",
+				"source": map[string]interface{}{"type": "base64", "media_type": "image/png", "data": "no es base64!"},
+			}},
+		}},
+		`unsupported image source type "url"`: {map[string]interface{}{
+			"role": "user",
+			"content": []interface{}{map[string]interface{}{
+				"type":   "image",
+				"source": map[string]interface{}{"type": "url", "url": "https://example.com/a.png"},
+			}},
+		}},
+	}
+	for want, messages := range cases {
+		_, err := convertAnthropicToBedrockMessages(messages, false, 0, false)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error containing %q, got %v", want, err)
+		}
+	}
+}
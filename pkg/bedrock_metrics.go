@@ -9,40 +9,68 @@ import (
 	"strings"
 	"time"
 
+	"bedrock-proxy-test/pkg/amslog"
 	"bedrock-proxy-test/pkg/auth"
 	"bedrock-proxy-test/pkg/database"
 	"bedrock-proxy-test/pkg/metrics"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 )
 
 // processMetrics procesa las métricas en una goroutine separada
 func (this *BedrockClient) processMetrics(ctx context.Context, user *auth.UserContext, mc *MetricsCapture, startTime time.Time) {
+	// No procesar si el contexto ya fue cancelado o expiró
+	if err := ctx.Err(); err != nil {
+		logPostProcessAborted(ctx, user, err)
+		return
+	}
+	
 	// Finalizar captura de métricas
-	mc.Finalize()
+	mc.Finalize(ctx)
 	
 	// Calcular tiempo total de procesamiento
 	processingTimeMS := int(time.Since(startTime).Milliseconds())
 	
-	// Obtener métricas capturadas
+	// Obtener métricas capturadas y ajustar usos implausibles antes de facturar
 	metric := mc.GetMetrics()
+	raw := *metric
+	if anomalies := sanitizeUsage(metric); len(anomalies) > 0 {
+		logUsageAnomalies(ctx, user, raw, anomalies)
+	}
 	
 	// Calcular coste con soporte para tokens de caché y resolución de ARNs
-	cost, err := metrics.CalculateCostWithCacheAndResolver(
-		metric.ModelID,
-		int64(metric.TokensInput),
-		int64(metric.TokensOutput),
-		int64(metric.TokensCacheRead),
-		int64(metric.TokensCacheWriteTokens),
-		this.modelResolver,
-	)
+	// Se tarifica por la versión concreta servida si se conoce y tiene precio; si no, por el profile
+	cost, err := this.calculateMetricCost(metric)
 	if err != nil {
-		Log.Errorf("Failed to calculate cost: %v", err)
+		Logger.ErrorContext(ctx, amslog.Event{
+			Name:    EventCostCalculate,
+			Message: "Failed to calculate cost",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    "PricingError",
+				Message: err.Error(),
+				Code:    "COST_CALCULATION_FAILED",
+			},
+			Fields: map[string]interface{}{
+				"model.id": metric.ModelID,
+			},
+		})
 		cost = 0.0
 	}
 	
 	// Log de debug para verificar cálculo de coste
-	Log.Infof("[COST_DEBUG] Model: %s | Input: %d | Output: %d | CacheRead: %d | CacheWrite: %d | Cost: $%.8f",
-		metric.ModelID, metric.TokensInput, metric.TokensOutput, 
-		metric.TokensCacheRead, metric.TokensCacheWriteTokens, cost)
+	Logger.DebugContext(ctx, amslog.Event{
+		Name:    EventCostCalculate,
+		Message: "Request cost calculated",
+		Fields: map[string]interface{}{
+			"model.id":           metric.ModelID,
+			"tokens.input":       metric.TokensInput,
+			"tokens.output":      metric.TokensOutput,
+			"tokens.cache_read":  metric.TokensCacheRead,
+			"tokens.cache_write": metric.TokensCacheWriteTokens,
+			"cost_usd":           cost,
+		},
+	})
 	
 	// Crear datos de tracking de uso
 	usageData := &database.UsageTrackingData{
@@ -63,20 +91,126 @@ func (this *BedrockClient) processMetrics(ctx context.Context, user *auth.UserCo
 		ProcessingTimeMS:    processingTimeMS,
 		ResponseStatus:      metric.ResponseStatus,
 		ErrorMessage:        metric.ErrorMessage,
+		ConversationID:      metric.ConversationID,
+		BatchID:             metric.BatchID,
+		ServedModelID:       metric.ServedModelID,
+		RequestedModel:      metric.RequestedModel,
+		BedrockLatencyMS:    metric.BedrockLatencyMs,
+		StreamDurationMS:    metric.StreamDurationMs,
+		ProjectID:           metric.ProjectID,
+		RequestID:           metric.RequestID,
+	}
+	
+	// Re-verificar el contexto antes de encolar (el cálculo de coste puede consultar BD)
+	if err := ctx.Err(); err != nil {
+		logPostProcessAborted(ctx, user, err)
+		return
 	}
 	
 	// Guardar tracking de uso (asíncrono via worker)
 	if err := this.metricsWorker.RecordUsageTracking(usageData); err != nil {
-		Log.Errorf("Failed to record usage tracking: %v", err)
+		Logger.ErrorContext(ctx, amslog.Event{
+			Name:    EventMetricsRecord,
+			Message: "Failed to record usage tracking",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    "TrackingError",
+				Message: err.Error(),
+				Code:    "USAGE_TRACKING_FAILED",
+			},
+			Fields: map[string]interface{}{
+				"user.id":               user.UserID,
+				"metrics.dropped_total": this.metricsWorker.Stats().DroppedCount,
+			},
+		})
+		return
 	}
 	
 	// NOTA: La verificación y actualización de cuota ya se hizo en el middleware
 	// No es necesario llamar a UpdateQuotaAndCounters ni CheckAndBlockUser aquí
 	
-	Log.Infof("[METRICS] User: %s | Tokens: %d/%d | Cost: $%.6f | Time: %dms",
-		user.UserID, metric.TokensInput, metric.TokensOutput, cost, processingTimeMS)
+	Logger.InfoContext(ctx, amslog.Event{
+		Name:       EventMetricsRecord,
+		Message:    "Usage tracking recorded",
+		Outcome:    amslog.OutcomeSuccess,
+		DurationMs: int64(processingTimeMS),
+		Fields: map[string]interface{}{
+			"user.id":            user.UserID,
+			"model.id":           metric.ModelID,
+			"model.requested":    metric.RequestedModel,
+			"tokens.input":       metric.TokensInput,
+			"tokens.output":      metric.TokensOutput,
+			"tokens.cache_read":  metric.TokensCacheRead,
+			"tokens.cache_write": metric.TokensCacheWriteTokens,
+			"cost.usd":           metrics.FormatCost(cost),
+			"bedrock.latency_ms": metric.BedrockLatencyMs,
+			"stream.duration_ms": metric.StreamDurationMs,
+			"queue.wait_ms":      metric.QueueWaitMs,
+		},
+	})
 }
 
+// logPostProcessAborted registra que el post-processing se abortó por cancelación o timeout
+func logPostProcessAborted(ctx context.Context, user *auth.UserContext, err error) {
+	Logger.WarningContext(ctx, amslog.Event{
+		Name:    "METRICS_POST_PROCESS_ABORTED",
+		Message: "Metrics post-processing aborted by context",
+		Outcome: amslog.OutcomeFailure,
+		Error: &amslog.ErrorInfo{
+			Type:    "ContextError",
+			Message: err.Error(),
+			Code:    "POST_PROCESS_ABORTED",
+		},
+		Fields: map[string]interface{}{
+			"user.id": user.UserID,
+		},
+	})
+}
+
+// calculateMetricCost calcula el coste de una métrica priorizando el modelo servido
+func (this *BedrockClient) calculateMetricCost(metric *MetricData) (float64, error) {
+	calculate := func(modelID string) (float64, error) {
+		return metrics.CalculateCostWithCacheAndResolver(
+			modelID,
+			int64(metric.TokensInput),
+			int64(metric.TokensOutput),
+			int64(metric.TokensCacheRead),
+			int64(metric.TokensCacheWriteTokens),
+			this.modelResolver,
+		)
+	}
+
+	// El modelo servido solo se usa si tiene precio propio: sin él se tarifica por el profile y no por
+	// PRICING_DEFAULT_MODEL
+	if _, priced := metrics.PricingTable[metric.ServedModelID]; priced && metric.ServedModelID != metric.ModelID {
+		if cost, err := calculate(metric.ServedModelID); err == nil {
+			return cost, nil
+		}
+	}
+	return calculate(metric.ModelID)
+}
+
+// extractServedModelID obtiene el modelo concreto invocado a partir de la metadata del stream
+// Bedrock solo lo informa en la traza cuando la petición pasa por un prompt router
+func extractServedModelID(metadata types.ConverseStreamMetadataEvent) string {
+	if metadata.Trace != nil && metadata.Trace.PromptRouter != nil && metadata.Trace.PromptRouter.InvokedModelId != nil {
+		return *metadata.Trace.PromptRouter.InvokedModelId
+	}
+	return ""
+}
+
+// extractBedrockLatencyMs obtiene la latencia del modelo que informa Bedrock en la metadata del stream
+// (0 si no viene), distinta de la duración del streaming medida por el proxy
+func extractBedrockLatencyMs(metadata types.ConverseStreamMetadataEvent) int64 {
+	if metadata.Metrics != nil && metadata.Metrics.LatencyMs != nil {
+		return *metadata.Metrics.LatencyMs
+	}
+	return 0
+}
+
+// ConversationIDHeader es el header con el que el cliente agrupa peticiones de una misma conversación
+const ConversationIDHeader = auth.ConversationIDHeader
+
 // MetricsCapture captura información de métricas mientras hace streaming
 type MetricsCapture struct {
 	http.ResponseWriter
@@ -90,8 +224,19 @@ type MetricsCapture struct {
 	requestID        string
 	sourceIP         string
 	userAgent        string
+	conversationID   string
+	batchID          string
+	servedModelID    string
+	requestedModel   string
+	maxTokens        int
+	bedrockLatencyMs int64
+	streamDurationMs int64
+	queueWaitMs      int64
+	projectID        string
+	jsonResponse     bool
 	hasError         bool
 	errorMessage     string
+	filtered         bool
 }
 
 func NewMetricsCapture(w http.ResponseWriter, modelID, requestID string, r *http.Request) *MetricsCapture {
@@ -107,6 +252,8 @@ func NewMetricsCapture(w http.ResponseWriter, modelID, requestID string, r *http
 		requestID:      requestID,
 		sourceIP:       sourceIP,
 		userAgent:      r.Header.Get("User-Agent"),
+		conversationID: r.Header.Get(ConversationIDHeader),
+		batchID:        batchIDFromContext(r.Context()),
 	}
 }
 
@@ -130,10 +277,107 @@ func (mc *MetricsCapture) Flush() {
 	}
 }
 
-func (mc *MetricsCapture) Finalize() {
-	mc.parseSSEEvent(mc.buffer.String())
-	Log.Debugf("Metrics finalized: input=%d, output=%d, cache_read=%d, cache_write=%d",
-		mc.inputTokens, mc.outputTokens, mc.cacheReadTokens, mc.cacheWriteTokens)
+// SetMaxTokens registra el max_tokens enviado a Bedrock (para detectar anomalías de uso)
+func (mc *MetricsCapture) SetMaxTokens(maxTokens int) {
+	mc.maxTokens = maxTokens
+}
+
+// SetRequestedModel registra el campo "model" tal como lo envió el cliente (solo para analítica)
+func (mc *MetricsCapture) SetRequestedModel(model string) {
+	mc.requestedModel = model
+}
+
+// extractRequestedModel devuelve el campo "model" del body original ("" si no es JSON o no lo trae)
+func extractRequestedModel(body []byte) string {
+	var request struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+	return request.Model
+}
+
+// SetJSONResponse indica que la respuesta es un único JSON (path no-stream) en lugar de SSE
+func (mc *MetricsCapture) SetJSONResponse() {
+	mc.jsonResponse = true
+}
+
+// SetBedrockLatencyMs registra la latencia informada por Bedrock (metadata.metrics.latencyMs)
+func (mc *MetricsCapture) SetBedrockLatencyMs(latencyMs int64) {
+	mc.bedrockLatencyMs = latencyMs
+}
+
+// SetStreamDurationMs registra la duración del streaming medida por el proxy
+func (mc *MetricsCapture) SetStreamDurationMs(durationMs int64) {
+	mc.streamDurationMs = durationMs
+}
+
+// SetQueueWaitMs registra la espera de la request hasta ser admitida (0 si no pasó por una cola)
+func (mc *MetricsCapture) SetQueueWaitMs(waitMs int64) {
+	mc.queueWaitMs = waitMs
+}
+
+// SetProjectID registra el proyecto al que se imputa la petición (ya normalizado)
+func (mc *MetricsCapture) SetProjectID(projectID string) {
+	mc.projectID = projectID
+}
+
+// SetServedModelID registra el modelo concreto que sirvió Bedrock (metadata del stream)
+func (mc *MetricsCapture) SetServedModelID(modelID string) {
+	mc.servedModelID = modelID
+}
+
+// Finalize extrae los tokens del stream (o del JSON no-stream) capturado y emite un evento METRICS_CAPTURE
+func (mc *MetricsCapture) Finalize(ctx context.Context) {
+	if mc.jsonResponse {
+		mc.parseJSONResponse(mc.buffer.Bytes())
+	} else {
+		mc.parseSSEEvent(mc.buffer.String())
+	}
+	Logger.DebugContext(ctx, amslog.Event{
+		Name:    EventMetricsCapture,
+		Message: "Stream metrics captured",
+		Fields: map[string]interface{}{
+			"request.id":         mc.requestID,
+			"model.id":           mc.modelID,
+			"tokens.input":       mc.inputTokens,
+			"tokens.output":      mc.outputTokens,
+			"tokens.cache_read":  mc.cacheReadTokens,
+			"tokens.cache_write": mc.cacheWriteTokens,
+			"response.status":    mc.getStatusString(),
+		},
+	})
+}
+
+// parseJSONResponse extrae los tokens del bloque usage de una respuesta no-stream en formato Anthropic
+func (mc *MetricsCapture) parseJSONResponse(data []byte) {
+	var response map[string]interface{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return
+	}
+	if usage, ok := response["usage"].(map[string]interface{}); ok {
+		mc.applyUsage(usage)
+	}
+	if response["type"] == "error" {
+		mc.extractTokensFromEvent("error", string(data))
+	}
+}
+
+// applyUsage aplica un bloque usage acumulado (input, output y tokens de caché)
+func (mc *MetricsCapture) applyUsage(usage map[string]interface{}) {
+	if inputTokens, ok := usage["input_tokens"].(float64); ok {
+		mc.inputTokens = int(inputTokens)
+	}
+	if outputTokens, ok := usage["output_tokens"].(float64); ok {
+		mc.outputTokens = int(outputTokens)
+	}
+	if cacheCreation, ok := usage["cache_creation_input_tokens"].(float64); ok {
+		mc.cacheWriteTokens = int(cacheCreation)
+	}
+	if cacheRead, ok := usage["cache_read_input_tokens"].(float64); ok {
+		mc.cacheReadTokens = int(cacheRead)
+	}
 }
 
 func (mc *MetricsCapture) parseSSEEvent(data string) {
@@ -159,54 +403,28 @@ func (mc *MetricsCapture) extractTokensFromEvent(eventType, jsonData string) {
 	
 	switch eventType {
 	case "message_start":
+		// input_tokens y los tokens de caché son contadores independientes (cache_read puede superar a
+		// input_tokens): se guardan tal cual y la facturación los suma según el pricing del modelo
 		if message, ok := event["message"].(map[string]interface{}); ok {
 			if usage, ok := message["usage"].(map[string]interface{}); ok {
-				if inputTokens, ok := usage["input_tokens"].(float64); ok {
-					mc.inputTokens = int(inputTokens)
-				}
-				// Capturar cache tokens de message_start
-				if cacheRead, ok := usage["cache_read_input_tokens"].(float64); ok {
-					mc.cacheReadTokens = int(cacheRead)
-				}
-				if cacheCreation, ok := usage["cache_creation_input_tokens"].(float64); ok {
-					mc.cacheWriteTokens = int(cacheCreation)
-				}
+				mc.applyUsage(usage)
 			}
 		}
 		
 	case "message_delta":
-		// Capturar tokens finales de output desde message_delta (formato Anthropic)
+		// Capturar tokens finales desde message_delta (formato Anthropic, usage acumulado)
 		if usage, ok := event["usage"].(map[string]interface{}); ok {
-			if outputTokens, ok := usage["output_tokens"].(float64); ok {
-				mc.outputTokens = int(outputTokens)
-				Log.Infof("[METRICS_CAPTURE] Output tokens from message_delta: %d", mc.outputTokens)
-			}
+			mc.applyUsage(usage)
 		}
 
 	case "ping":
 		// Evento ping contiene todos los tokens finales (para captura de métricas)
 		if usage, ok := event["usage"].(map[string]interface{}); ok {
-			if inputTokens, ok := usage["input_tokens"].(float64); ok {
-				mc.inputTokens = int(inputTokens)
-			}
-			if outputTokens, ok := usage["output_tokens"].(float64); ok {
-				mc.outputTokens = int(outputTokens)
-			}
-			if cacheCreation, ok := usage["cache_creation_input_tokens"].(float64); ok {
-				mc.cacheWriteTokens = int(cacheCreation)
-			}
-			if cacheRead, ok := usage["cache_read_input_tokens"].(float64); ok {
-				mc.cacheReadTokens = int(cacheRead)
-			}
-			Log.Infof("[METRICS_CAPTURE] Tokens from ping: input=%d, output=%d, cache_read=%d, cache_write=%d",
-				mc.inputTokens, mc.outputTokens, mc.cacheReadTokens, mc.cacheWriteTokens)
+			mc.applyUsage(usage)
 		}
 
-	case "message_stop":
-		// message_stop ya no contiene usage en formato Anthropic
-		// Los tokens finales ya fueron capturados en ping o message_delta
-		Log.Infof("[METRICS_CAPTURE] Final metrics: input=%d, output=%d, cache_read=%d, cache_write=%d",
-			mc.inputTokens, mc.outputTokens, mc.cacheReadTokens, mc.cacheWriteTokens)
+	// message_stop ya no contiene usage en formato Anthropic: los tokens finales
+	// se capturan en ping o message_delta y se loguean una sola vez en Finalize
 
 	case "error":
 		mc.hasError = true
@@ -232,6 +450,15 @@ func (mc *MetricsCapture) GetMetrics() *MetricData {
 		TokensCacheWriteTokens: mc.cacheWriteTokens,
 		ResponseStatus:      mc.getStatusString(),
 		ErrorMessage:        mc.errorMessage,
+		ConversationID:      mc.conversationID,
+		BatchID:             mc.batchID,
+		ServedModelID:       mc.servedModelID,
+		RequestedModel:      mc.requestedModel,
+		MaxTokens:           mc.maxTokens,
+		BedrockLatencyMs:    mc.bedrockLatencyMs,
+		StreamDurationMs:    mc.streamDurationMs,
+		QueueWaitMs:         mc.queueWaitMs,
+		ProjectID:           mc.projectID,
 	}
 }
 
@@ -247,18 +474,35 @@ type MetricData struct {
 	TokensCacheWriteTokens int
 	ResponseStatus      string
 	ErrorMessage        string
+	ConversationID      string
+	BatchID             string // Batch de /v1/messages/batch al que pertenece la request (vacío fuera de un batch)
+	ServedModelID       string
+	RequestedModel      string // Campo "model" enviado por el cliente (sin resolver)
+	MaxTokens           int
+	BedrockLatencyMs    int64 // Latencia del modelo informada por Bedrock
+	StreamDurationMs    int64 // Duración del streaming medida por el proxy (incluye red y overhead)
+	QueueWaitMs         int64 // Espera desde la llegada hasta la admisión (0 si no pasó por una cola)
+	ProjectID           string // Proyecto normalizado (X-Project-Id o claim project)
 }
 
 func (mc *MetricsCapture) getStatusString() string {
 	if mc.hasError {
 		return "error"
 	}
+	if mc.filtered {
+		return "filtered"
+	}
 	if mc.statusCode >= 200 && mc.statusCode < 300 {
 		return "success"
 	}
 	return fmt.Sprintf("http_%d", mc.statusCode)
 }
 
+// MarkFiltered marca la respuesta como bloqueada por el filtro de contenido o un guardrail
+func (mc *MetricsCapture) MarkFiltered() {
+	mc.filtered = true
+}
+
 // MarkError marca explícitamente un error en la captura de métricas
 func (mc *MetricsCapture) MarkError(errorMsg string) {
 	mc.hasError = true
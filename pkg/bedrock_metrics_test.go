@@ -0,0 +1,415 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+	"bedrock-proxy-test/pkg/database"
+	"bedrock-proxy-test/pkg/metrics"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// newTestMetricsCapture crea un MetricsCapture con un stream SSE ya escrito
+func newTestMetricsCapture(t *testing.T) *MetricsCapture {
+	req := newTestProxyRequest(`{}`)
+	mc := NewMetricsCapture(httptest.NewRecorder(), "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", "req-1", req)
+	mc.Write([]byte("event: ping\ndata: {\"type\":\"ping\",\"usage\":{\"input_tokens\":10,\"output_tokens\":20,\"cache_creation_input_tokens\":0,\"cache_read_input_tokens\":0}}\n\n"))
+	return mc
+}
+
+func TestProcessMetricsRecordsUsage(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+	user := &auth.UserContext{UserID: "user-1"}
+
+	client.processMetrics(context.Background(), user, newTestMetricsCapture(t), time.Now())
+
+	if got := client.metricsWorker.Stats().BufferedCount; got != 1 {
+		t.Errorf("Expected 1 usage record queued, got %d", got)
+	}
+}
+
+func TestProcessMetricsRespectsCancelledContext(t *testing.T) {
+	buf := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+	user := &auth.UserContext{UserID: "user-1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client.processMetrics(ctx, user, newTestMetricsCapture(t), time.Now())
+
+	if got := client.metricsWorker.Stats().BufferedCount; got != 0 {
+		t.Errorf("Expected no usage record with cancelled context, got %d", got)
+	}
+
+	Logger.Close()
+	if !containsEvent(buf.String(), "METRICS_POST_PROCESS_ABORTED") {
+		t.Errorf("Expected METRICS_POST_PROCESS_ABORTED log, got: %s", buf.String())
+	}
+}
+
+func TestProcessMetricsRespectsTimedOutContext(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+	user := &auth.UserContext{UserID: "user-1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	client.processMetrics(ctx, user, newTestMetricsCapture(t), time.Now())
+
+	if got := client.metricsWorker.Stats().BufferedCount; got != 0 {
+		t.Errorf("Expected no usage record with timed-out context, got %d", got)
+	}
+}
+
+func TestPostProcessContextKeepsTraceIDs(t *testing.T) {
+	buf := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+	user := &auth.UserContext{UserID: "user-1"}
+
+	reqCtx, cancelReq := context.WithCancel(context.Background())
+	reqCtx = amslog.WithTraceID(reqCtx, "trace-original")
+	reqCtx = amslog.WithRequestID(reqCtx, "request-original")
+
+	postCtx, cancel := client.newPostProcessContext(reqCtx)
+	defer cancel()
+
+	// La cancelación de la request no debe cancelar el post-processing
+	cancelReq()
+	if postCtx.Err() != nil {
+		t.Fatal("Expected post-process context to survive request cancellation")
+	}
+	if _, ok := postCtx.Deadline(); !ok {
+		t.Error("Expected post-process context to have a deadline")
+	}
+
+	client.processMetrics(postCtx, user, newTestMetricsCapture(t), time.Now())
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		if entry["event.name"] != EventMetricsRecord {
+			continue
+		}
+		found = true
+		if entry["trace.id"] != "trace-original" {
+			t.Errorf("Expected trace.id trace-original, got %v", entry["trace.id"])
+		}
+		if entry["request.id"] != "request-original" {
+			t.Errorf("Expected request.id request-original, got %v", entry["request.id"])
+		}
+	}
+	if !found {
+		t.Errorf("Expected %s log entry, got: %s", EventMetricsRecord, buf.String())
+	}
+}
+
+func TestCalculateMetricCostWarnsOnDefaultPricing(t *testing.T) {
+	logs := setupTestLogger(t)
+	client := newTestBedrockClient()
+	client.SetPricingConfig(PricingConfig{DefaultModel: "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"})
+
+	arn := "arn:aws:bedrock:eu-west-1:123456789012:application-inference-profile/unmapped0003"
+	if cost, err := client.calculateMetricCost(&MetricData{ModelID: arn, TokensInput: 1000}); err != nil || cost == 0 {
+		t.Fatalf("Expected default pricing to be applied, got %v (err %v)", cost, err)
+	}
+	if !containsEvent(logs.String(), EventPricingFallback) || !strings.Contains(logs.String(), arn) {
+		t.Errorf("Expected %s warning with the unresolved model ID, got: %s", EventPricingFallback, logs.String())
+	}
+
+	// Un modelo servido sin precio propio se tarifica por el profile, no por el modelo por defecto
+	logs.Reset()
+	metric := &MetricData{ModelID: "anthropic.claude-3-5-haiku-20241022-v1:0", ServedModelID: "anthropic.unpriced-model-v1:0", TokensInput: 1000}
+	if cost, err := client.calculateMetricCost(metric); err != nil || cost != 0.001 {
+		t.Errorf("Expected the profile's pricing, got %v (err %v)", cost, err)
+	}
+	if containsEvent(logs.String(), EventPricingFallback) {
+		t.Errorf("Expected no %s warning when the profile has pricing", EventPricingFallback)
+	}
+}
+
+func TestExtractServedModelID(t *testing.T) {
+	served := "anthropic.claude-sonnet-4-5-20250929-v1:0"
+	metadata := types.ConverseStreamMetadataEvent{
+		Usage: &types.TokenUsage{InputTokens: aws.Int32(10), OutputTokens: aws.Int32(20)},
+		Trace: &types.ConverseStreamTrace{
+			PromptRouter: &types.PromptRouterTrace{InvokedModelId: aws.String(served)},
+		},
+	}
+
+	if got := extractServedModelID(metadata); got != served {
+		t.Errorf("Expected served model %q, got %q", served, got)
+	}
+
+	// Sin traza no se conoce la versión servida
+	if got := extractServedModelID(types.ConverseStreamMetadataEvent{}); got != "" {
+		t.Errorf("Expected empty served model without trace, got %q", got)
+	}
+}
+
+func TestMetricsCaptureStoresServedModelID(t *testing.T) {
+	mc := newTestMetricsCapture(t)
+	if got := mc.GetMetrics().ServedModelID; got != "" {
+		t.Errorf("Expected empty served model by default, got %q", got)
+	}
+
+	mc.SetServedModelID("anthropic.claude-sonnet-4-5-20250929-v1:0")
+	if got := mc.GetMetrics().ServedModelID; got != "anthropic.claude-sonnet-4-5-20250929-v1:0" {
+		t.Errorf("Expected served model in MetricData, got %q", got)
+	}
+}
+
+func TestExtractRequestedModel(t *testing.T) {
+	cases := map[string]string{
+		`{"model":"claude-sonnet-4-5","max_tokens":10}`: "claude-sonnet-4-5",
+		`{"max_tokens":10}`:                             "",
+		`not json`:                                      "",
+	}
+	for body, want := range cases {
+		if got := extractRequestedModel([]byte(body)); got != want {
+			t.Errorf("extractRequestedModel(%s) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestMetricsCaptureStoresRequestedModel(t *testing.T) {
+	setupTestLogger(t)
+
+	// Streaming: el campo model se elimina del body antes de firmar, pero se conserva en la métrica
+	mc := newTestMetricsCapture(t)
+	mc.SetRequestedModel("claude-sonnet-4-5")
+	if got := mc.GetMetrics().RequestedModel; got != "claude-sonnet-4-5" {
+		t.Errorf("Expected requested model in stream MetricData, got %q", got)
+	}
+
+	// No streaming
+	mc = NewMetricsCapture(httptest.NewRecorder(), "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", "req-2", newTestProxyRequest(`{}`))
+	mc.SetJSONResponse()
+	mc.SetRequestedModel("claude-3-5-haiku")
+	mc.Write([]byte(`{"type":"message","usage":{"input_tokens":5,"output_tokens":2}}`))
+	mc.Finalize(context.Background())
+	if got := mc.GetMetrics().RequestedModel; got != "claude-3-5-haiku" {
+		t.Errorf("Expected requested model in non-stream MetricData, got %q", got)
+	}
+}
+
+func TestStreamCapturesBedrockLatency(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	stub := newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":5,"outputTokens":2,"totalTokens":7},"metrics":{"latencyMs":1234}}`},
+	}))
+
+	modelID := "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"
+	mc := NewMetricsCapture(httptest.NewRecorder(), modelID, "req-1", newTestProxyRequest(`{}`))
+	if err := client.handleBedrockStreamConverse(context.Background(), mc, stub, modelID, nil, nil, 1024, nil, nil, converseOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mc.SetStreamDurationMs(1500)
+
+	metric := mc.GetMetrics()
+	if metric.BedrockLatencyMs != 1234 {
+		t.Errorf("Expected Bedrock latency 1234ms, got %d", metric.BedrockLatencyMs)
+	}
+	if metric.StreamDurationMs != 1500 {
+		t.Errorf("Expected stream duration kept separately, got %d", metric.StreamDurationMs)
+	}
+
+	// Sin metrics en la metadata la latencia queda sin informar
+	if got := extractBedrockLatencyMs(types.ConverseStreamMetadataEvent{}); got != 0 {
+		t.Errorf("Expected 0 latency without metrics, got %d", got)
+	}
+}
+
+func TestConvertStopReason(t *testing.T) {
+	tests := []struct {
+		reason   types.StopReason
+		expected string
+		filtered bool
+	}{
+		{"", "end_turn", false},
+		{types.StopReasonEndTurn, "end_turn", false},
+		{types.StopReasonToolUse, "tool_use", false},
+		{types.StopReasonMaxTokens, "max_tokens", false},
+		{types.StopReasonContentFiltered, "refusal", true},
+		{types.StopReasonGuardrailIntervened, "refusal", true},
+	}
+	for _, tt := range tests {
+		got, filtered := convertStopReason(tt.reason)
+		if got != tt.expected || filtered != tt.filtered {
+			t.Errorf("convertStopReason(%q) = (%q, %v), expected (%q, %v)", tt.reason, got, filtered, tt.expected, tt.filtered)
+		}
+	}
+}
+
+func TestStreamFilteredStopReasonMarksMetrics(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	stub := newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Lo siento"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"guardrail_intervened"}`},
+		{"metadata", `{"usage":{"inputTokens":8,"outputTokens":3,"totalTokens":11},"metrics":{"latencyMs":50}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	modelID := "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"
+	mc := NewMetricsCapture(rec, modelID, "req-1", newTestProxyRequest(`{}`))
+
+	err := client.handleBedrockStreamConverse(context.Background(), mc, stub, modelID, nil, nil, 1024, nil, nil, converseOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !strings.Contains(rec.Body.String(), `"stop_reason":"refusal"`) {
+		t.Errorf("Expected refusal stop_reason in stream, got %s", rec.Body.String())
+	}
+
+	mc.Finalize(context.Background())
+	metric := mc.GetMetrics()
+	if metric.ResponseStatus != "filtered" {
+		t.Errorf("Expected response status filtered, got %q", metric.ResponseStatus)
+	}
+	if metric.TokensInput != 8 || metric.TokensOutput != 3 {
+		t.Errorf("Expected tokens to still be captured, got %d/%d", metric.TokensInput, metric.TokensOutput)
+	}
+	if got := client.FilteredResponseCount(); got != 1 {
+		t.Errorf("Expected filtered counter 1, got %d", got)
+	}
+	if !containsEvent(logs.String(), EventBedrockContentFiltered) {
+		t.Error("Expected BEDROCK_CONTENT_FILTERED event")
+	}
+}
+
+func TestHandleProxyNonStreamBillsUsage(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.db = &database.Database{}
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+	client.config.NonStreamRawHTTP = true
+	client.httpClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"type":"message","content":[{"type":"text","text":"hola"}],` +
+				`"usage":{"input_tokens":42,"output_tokens":7,"cache_creation_input_tokens":3,"cache_read_input_tokens":100}}`)),
+		}, nil
+	})}
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"messages": [{"role": "user", "content": "hola"}]}`))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"text":"hola"`) {
+		t.Fatalf("Expected response passed through, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// El post-processing es asíncrono: esperarlo para que no escriba en el logger del siguiente test
+	client.postProcessing.Wait()
+	if got := client.metricsWorker.Stats().BufferedCount; got != 1 {
+		t.Fatalf("Expected non-stream usage to be recorded, got %d records", got)
+	}
+}
+
+func TestMetricsCaptureParsesJSONUsage(t *testing.T) {
+	setupTestLogger(t)
+
+	mc := NewMetricsCapture(httptest.NewRecorder(), "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", "req-1", newTestProxyRequest(`{}`))
+	mc.SetJSONResponse()
+	mc.Write([]byte(`{"type":"message","usage":{"input_tokens":42,"output_tokens":7,"cache_creation_input_tokens":3,"cache_read_input_tokens":100}}`))
+	mc.Finalize(context.Background())
+
+	metric := mc.GetMetrics()
+	if metric.TokensInput != 42 || metric.TokensOutput != 7 || metric.TokensCacheWriteTokens != 3 || metric.TokensCacheRead != 100 {
+		t.Errorf("Unexpected usage parsed: %+v", metric)
+	}
+	if metric.ResponseStatus != "success" {
+		t.Errorf("Expected success status, got %q", metric.ResponseStatus)
+	}
+
+	// Respuesta de error de Bedrock: sin tokens y con el status HTTP
+	mc = NewMetricsCapture(httptest.NewRecorder(), "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", "req-2", newTestProxyRequest(`{}`))
+	mc.SetJSONResponse()
+	mc.WriteHeader(http.StatusBadRequest)
+	mc.Write([]byte(`{"message":"Malformed input request"}`))
+	mc.Finalize(context.Background())
+	if metric := mc.GetMetrics(); metric.TokensInput != 0 || metric.ResponseStatus != "http_400" {
+		t.Errorf("Unexpected error metric: %+v", metric)
+	}
+}
+
+func TestMetricsCaptureMessageStartCacheExceedsInput(t *testing.T) {
+	mc := NewMetricsCapture(httptest.NewRecorder(), "model", "req-1", httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	mc.extractTokensFromEvent("message_start", `{"type":"message_start","message":{"usage":{"input_tokens":12,"output_tokens":1,"cache_read_input_tokens":9000,"cache_creation_input_tokens":300}}}`)
+
+	// cache_read mayor que input_tokens es válido: no se mezcla ni se recorta
+	if mc.inputTokens != 12 || mc.cacheReadTokens != 9000 || mc.cacheWriteTokens != 300 {
+		t.Errorf("Expected independent usage counters, got in=%d read=%d write=%d", mc.inputTokens, mc.cacheReadTokens, mc.cacheWriteTokens)
+	}
+}
+
+func TestHandleProxyRecordsRequestedModel(t *testing.T) {
+	for _, stream := range []bool{true, false} {
+		logs := setupTestLogger(t)
+		client := newTestBedrockClient()
+		client.client = newStubConverseClient(newConverseStreamBody(t, testNonStreamEvents))
+		client.db = &database.Database{}
+		client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"model":      "claude-3-5-haiku-latest",
+			"stream":     stream,
+			"max_tokens": 100,
+			"messages":   []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+		})
+		rec := httptest.NewRecorder()
+		client.HandleProxy(rec, newTestProxyRequest(string(payload)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stream=%v: expected status 200, got %d: %s", stream, rec.Code, rec.Body.String())
+		}
+		client.postProcessing.Wait()
+
+		var recorded map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &entry); err == nil && entry["event.name"] == EventMetricsRecord {
+				recorded = entry
+			}
+		}
+		if recorded == nil {
+			t.Fatalf("stream=%v: expected %s log entry, got: %s", stream, EventMetricsRecord, logs.String())
+		}
+		if recorded["model.requested"] != "claude-3-5-haiku-latest" {
+			t.Errorf("stream=%v: expected the client's model recorded, got %v", stream, recorded["model.requested"])
+		}
+		if recorded["model.id"] == recorded["model.requested"] || recorded["model.id"] == "" {
+			t.Errorf("stream=%v: expected the resolved model ID to differ from the requested one, got %v", stream, recorded["model.id"])
+		}
+	}
+}
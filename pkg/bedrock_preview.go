@@ -0,0 +1,254 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// ConversePreview es la representación JSON del input de Converse que se enviaría a Bedrock
+type ConversePreview struct {
+	ModelID                      string                   `json:"model_id"`
+	System                       []map[string]interface{} `json:"system"`
+	Messages                     []map[string]interface{} `json:"messages"`
+	InferenceConfig              map[string]interface{}   `json:"inference_config"`
+	AdditionalModelRequestFields map[string]interface{}   `json:"additional_model_request_fields,omitempty"`
+	PerformanceConfig            string                   `json:"performance_config,omitempty"`
+	ToolConfig                   *ConversePreviewTools    `json:"tool_config,omitempty"`
+	NativeTools                  bool                     `json:"native_tools"`
+}
+
+// ConversePreviewTools representa el ToolConfiguration calculado (solo se envía a Bedrock en modo nativo)
+type ConversePreviewTools struct {
+	Tools      []map[string]interface{} `json:"tools"`
+	ToolChoice string                   `json:"tool_choice,omitempty"`
+}
+
+// BuildConverseInput convierte un payload Anthropic al input de ConverseStream con el mismo builder que
+// HandleProxy (inyección de system prompt del equipo, recorte del historial, techo de coste y cache points),
+// sin invocar Bedrock
+func (this *BedrockClient) BuildConverseInput(ctx context.Context, payload map[string]interface{}, modelID string, team string) (*bedrockRuntime.ConverseStreamInput, *types.ToolConfiguration, error) {
+	requestedModel, _ := payload["model"].(string)
+	req, err := this.buildConverseRequest(ctx, payload, modelID, team, requestedModel)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buildConverseStreamInput(modelID, req.SystemBlocks, req.Messages, req.MaxTokens, req.Options), req.ToolConfig, nil
+}
+
+// newConversePreview convierte el input de Converse a una estructura JSON legible
+func newConversePreview(input *bedrockRuntime.ConverseStreamInput, toolConfig *types.ToolConfiguration) *ConversePreview {
+	preview := &ConversePreview{
+		System:          []map[string]interface{}{},
+		Messages:        []map[string]interface{}{},
+		InferenceConfig: map[string]interface{}{},
+	}
+
+	if input.ModelId != nil {
+		preview.ModelID = *input.ModelId
+	}
+
+	for _, block := range input.System {
+		switch b := block.(type) {
+		case *types.SystemContentBlockMemberText:
+			preview.System = append(preview.System, map[string]interface{}{"text": b.Value})
+		case *types.SystemContentBlockMemberCachePoint:
+			preview.System = append(preview.System, map[string]interface{}{"cache_point": string(b.Value.Type)})
+		}
+	}
+
+	for _, msg := range input.Messages {
+		content := []map[string]interface{}{}
+		for _, block := range msg.Content {
+			content = append(content, previewContentBlock(block))
+		}
+		preview.Messages = append(preview.Messages, map[string]interface{}{
+			"role":    string(msg.Role),
+			"content": content,
+		})
+	}
+
+	if ic := input.InferenceConfig; ic != nil {
+		if ic.MaxTokens != nil {
+			preview.InferenceConfig["max_tokens"] = *ic.MaxTokens
+		}
+		if ic.Temperature != nil {
+			preview.InferenceConfig["temperature"] = *ic.Temperature
+		}
+		if ic.TopP != nil {
+			preview.InferenceConfig["top_p"] = *ic.TopP
+		}
+		if len(ic.StopSequences) > 0 {
+			preview.InferenceConfig["stop_sequences"] = ic.StopSequences
+		}
+	}
+
+	if input.AdditionalModelRequestFields != nil {
+		if additional, err := documentToMap(input.AdditionalModelRequestFields); err == nil {
+			preview.AdditionalModelRequestFields = additional
+		}
+	}
+
+	if input.PerformanceConfig != nil {
+		preview.PerformanceConfig = string(input.PerformanceConfig.Latency)
+	}
+
+	preview.NativeTools = input.ToolConfig != nil
+
+	if toolConfig != nil {
+		preview.ToolConfig = &ConversePreviewTools{Tools: []map[string]interface{}{}}
+		for _, tool := range toolConfig.Tools {
+			spec, ok := tool.(*types.ToolMemberToolSpec)
+			if !ok {
+				continue
+			}
+			toolPreview := map[string]interface{}{}
+			if spec.Value.Name != nil {
+				toolPreview["name"] = *spec.Value.Name
+			}
+			if spec.Value.Description != nil {
+				toolPreview["description"] = *spec.Value.Description
+			}
+			if schema, ok := spec.Value.InputSchema.(*types.ToolInputSchemaMemberJson); ok && schema.Value != nil {
+				if schemaMap, err := documentToMap(schema.Value); err == nil {
+					toolPreview["input_schema"] = schemaMap
+				}
+			}
+			preview.ToolConfig.Tools = append(preview.ToolConfig.Tools, toolPreview)
+		}
+
+		switch tc := toolConfig.ToolChoice.(type) {
+		case *types.ToolChoiceMemberAuto:
+			preview.ToolConfig.ToolChoice = "auto"
+		case *types.ToolChoiceMemberAny:
+			preview.ToolConfig.ToolChoice = "any"
+		case *types.ToolChoiceMemberTool:
+			if tc.Value.Name != nil {
+				preview.ToolConfig.ToolChoice = "tool:" + *tc.Value.Name
+			}
+		}
+	}
+
+	return preview
+}
+
+// documentToMap convierte un documento Smithy a map vía JSON
+// (UnmarshalSmithyDocument no admite destinos genéricos en documentos lazy)
+func documentToMap(doc document.Interface) (map[string]interface{}, error) {
+	raw, err := doc.MarshalSmithyDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// previewContentBlock representa un bloque de contenido de Bedrock en JSON
+// Las imágenes se resumen con su formato y tamaño para no devolver el binario
+func previewContentBlock(block types.ContentBlock) map[string]interface{} {
+	switch b := block.(type) {
+	case *types.ContentBlockMemberText:
+		return map[string]interface{}{"text": b.Value}
+	case *types.ContentBlockMemberCachePoint:
+		return map[string]interface{}{"cache_point": string(b.Value.Type)}
+	case *types.ContentBlockMemberImage:
+		image := map[string]interface{}{"format": string(b.Value.Format)}
+		if source, ok := b.Value.Source.(*types.ImageSourceMemberBytes); ok {
+			image["bytes"] = len(source.Value)
+		}
+		return map[string]interface{}{"image": image}
+	case *types.ContentBlockMemberToolUse:
+		toolUse := map[string]interface{}{"tool_use_id": aws.ToString(b.Value.ToolUseId), "name": aws.ToString(b.Value.Name)}
+		if b.Value.Input != nil {
+			if input, err := documentToMap(b.Value.Input); err == nil {
+				toolUse["input"] = input
+			}
+		}
+		return map[string]interface{}{"tool_use": toolUse}
+	case *types.ContentBlockMemberToolResult:
+		return map[string]interface{}{"tool_result": map[string]interface{}{
+			"tool_use_id": aws.ToString(b.Value.ToolUseId),
+			"status":      string(b.Value.Status),
+			"blocks":      len(b.Value.Content),
+		}}
+	default:
+		return map[string]interface{}{"unknown": fmt.Sprintf("%T", block)}
+	}
+}
+
+// HandlePreview devuelve el input de Converse convertido sin invocar Bedrock (endpoint de administración)
+func (this *BedrockClient) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		writeErrorResponse(w, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrorResponse(w, ErrCodeRequestReadFailed, "Failed to read request: "+err.Error())
+		return
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeErrorResponse(w, ErrCodeInvalidJSON, "Failed to parse request: "+err.Error())
+		return
+	}
+
+	// Mismo modelo que usaría HandleProxy: el inference profile del usuario
+	modelID, _ := payload["model"].(string)
+	team, userID := "", ""
+	if user, err := auth.GetUserFromContext(ctx); err == nil {
+		team, userID = user.Team, user.UserID
+		if user.DefaultInferenceProfile != "" {
+			modelID = user.DefaultInferenceProfile
+		}
+	}
+
+	// Mismo DLP que HandleProxy: el preview no muestra contenido que se rechazaría
+	if this.rejectDLP(ctx, w, body, userID) {
+		return
+	}
+
+	// Mismo formato de tools que recibiría el cliente de la request
+	ctx = withToolPromptFormat(ctx, this.toolPromptFormat(r))
+	input, toolConfig, err := this.BuildConverseInput(ctx, payload, modelID, team)
+	var requestErr *converseRequestError
+	if errors.As(err, &requestErr) {
+		writeConverseRequestError(ctx, w, requestErr)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, ErrCodeMessageConversionFailed, err.Error())
+		return
+	}
+
+	Logger.InfoContext(ctx, amslog.Event{
+		Name:    "ADMIN_CONVERSE_PREVIEW",
+		Message: "Converse input preview generated",
+		Fields: map[string]interface{}{
+			"model_id":       modelID,
+			"messages_count": len(input.Messages),
+		},
+	})
+
+	json.NewEncoder(w).Encode(newConversePreview(input, toolConfig))
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/smithy-go"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/retrystats"
+)
+
+// Valores por defecto de los reintentos de ConverseStream ante throttling
+const (
+	DefaultStreamRetryMax       = 2
+	DefaultStreamRetryBaseDelay = 200 * time.Millisecond
+	maxStreamRetryDelay         = 5 * time.Second
+)
+
+// isRetryableBedrockError indica si el error de Bedrock es transitorio y merece reintentarse: throttling,
+// indisponibilidad y, como hacía el retryer del SDK (desactivado en el bucle), 5xx y errores de red.
+// ServiceQuotaExceededException no se reintenta: no se resuelve esperando
+func isRetryableBedrockError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ThrottlingException", "ServiceUnavailableException", "ModelNotReadyException", "InternalServerException":
+			return true
+		case "ServiceQuotaExceededException":
+			return false
+		}
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// streamRetryDelay calcula la espera antes del reintento attempt (1..n): backoff exponencial sobre
+// baseDelay, acotado a maxStreamRetryDelay, con jitter en [delay/2, delay]
+func streamRetryDelay(baseDelay time.Duration, attempt int) time.Duration {
+	if baseDelay <= 0 {
+		return 0
+	}
+	delay := baseDelay << (attempt - 1)
+	if delay <= 0 || delay > maxStreamRetryDelay {
+		delay = maxStreamRetryDelay
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// converseStreamWithRetry inicia el ConverseStream reintentando los errores transitorios con backoff.
+// Solo cubre el arranque: todavía no se ha enviado nada al cliente, así que reintentar es seguro. Con
+// reintentos configurados se desactivan los del SDK para que el número de intentos sea el configurado
+func (this *BedrockClient) converseStreamWithRetry(ctx context.Context, client *bedrockRuntime.Client, input *bedrockRuntime.ConverseStreamInput) (*bedrockRuntime.ConverseStreamOutput, error) {
+	maxRetries := this.config.StreamRetryMax
+	if maxRetries <= 0 {
+		return client.ConverseStream(ctx, input)
+	}
+	disableSDKRetries := func(o *bedrockRuntime.Options) {
+		o.RetryMaxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		retrystats.Attempt(retrystats.CategoryBedrock, attempt)
+		output, err := client.ConverseStream(ctx, input, disableSDKRetries)
+		if err == nil {
+			retrystats.Succeeded(retrystats.CategoryBedrock, attempt)
+			return output, nil
+		}
+		if !isRetryableBedrockError(err) {
+			return output, err
+		}
+		if attempt > maxRetries {
+			retrystats.Exhausted(retrystats.CategoryBedrock)
+			return output, err
+		}
+
+		delay := streamRetryDelay(this.config.StreamRetryBaseDelay, attempt)
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventBedrockRetry,
+			Message: "Transient Bedrock error starting the stream, retrying",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    classifyBedrockError(err).ErrorType,
+				Message: err.Error(),
+			},
+			Fields: map[string]interface{}{
+				"retry.attempt":  attempt,
+				"retry.max":      maxRetries,
+				"retry.delay_ms": delay.Milliseconds(),
+				"model.id":       aws.ToString(input.ModelId),
+			},
+		})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
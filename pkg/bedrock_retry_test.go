@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// newFlakyConverseClient crea un cliente de Bedrock cuyas primeras failures llamadas fallan con la
+// excepción indicada y las siguientes devuelven body como stream
+func newFlakyConverseClient(failures int, exception string, body []byte, calls *atomic.Int32) *bedrockRuntime.Client {
+	return bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if int(calls.Add(1)) <= failures {
+				return &http.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Header: http.Header{
+						"Content-Type":     []string{"application/json"},
+						"X-Amzn-Errortype": []string{exception},
+					},
+					Body: io.NopCloser(strings.NewReader(`{"message":"Too many requests, please wait before trying again."}`)),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		})},
+	})
+}
+
+func TestConverseStreamRetriesThrottlingBeforeStreaming(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.StreamRetryMax = 3
+	client.config.StreamRetryBaseDelay = time.Millisecond
+	var calls atomic.Int32
+	stub := newFlakyConverseClient(2, "ThrottlingException", newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"hola"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":5,"outputTokens":1,"totalTokens":6},"metrics":{"latencyMs":10}}`},
+	}), &calls)
+
+	rec := httptest.NewRecorder()
+	if err := client.handleBedrockStreamConverse(context.Background(), rec, stub, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, nil, nil, converseOptions{}); err != nil {
+		t.Fatalf("Expected the stream to succeed after retries, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 ConverseStream calls (2 throttled + 1 ok), got %d", calls.Load())
+	}
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"text":"hola"`) {
+		t.Errorf("Expected streamed response after retries, got %d %s", rec.Code, rec.Body.String())
+	}
+	if got := strings.Count(logs.String(), EventBedrockRetry); got != 2 {
+		t.Errorf("Expected 2 %s events, got %d: %s", EventBedrockRetry, got, logs.String())
+	}
+	if !strings.Contains(logs.String(), `"retry.attempt":2`) {
+		t.Errorf("Expected attempt number in retry log, got %s", logs.String())
+	}
+}
+
+func TestConverseStreamGivesUpAfterMaxRetries(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.StreamRetryMax = 1
+	client.config.StreamRetryBaseDelay = time.Millisecond
+	var calls atomic.Int32
+	stub := newFlakyConverseClient(5, "ThrottlingException", nil, &calls)
+
+	rec := httptest.NewRecorder()
+	if err := client.handleBedrockStreamConverse(context.Background(), rec, stub, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, nil, nil, converseOptions{}); err == nil {
+		t.Fatal("Expected error after exhausting retries")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected 1 retry (2 calls), got %d calls", calls.Load())
+	}
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected throttling status for the client, got %d", rec.Code)
+	}
+}
+
+func TestConverseStreamDoesNotRetryValidationErrors(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.StreamRetryMax = 3
+	client.config.StreamRetryBaseDelay = time.Millisecond
+	var calls atomic.Int32
+	stub := newFlakyConverseClient(5, "ValidationException", nil, &calls)
+
+	rec := httptest.NewRecorder()
+	client.handleBedrockStreamConverse(context.Background(), rec, stub, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, nil, nil, converseOptions{})
+	if calls.Load() != 1 {
+		t.Errorf("Expected no retries for a validation error, got %d calls", calls.Load())
+	}
+}
+
+func TestStreamRetryDelayIsBoundedExponential(t *testing.T) {
+	for attempt := 1; attempt <= 3; attempt++ {
+		full := 100 * time.Millisecond << (attempt - 1)
+		if got := streamRetryDelay(100*time.Millisecond, attempt); got < full/2 || got > full {
+			t.Errorf("attempt %d: expected delay in [%v, %v], got %v", attempt, full/2, full, got)
+		}
+	}
+	if got := streamRetryDelay(time.Second, 20); got > maxStreamRetryDelay {
+		t.Errorf("Expected delay capped at %v, got %v", maxStreamRetryDelay, got)
+	}
+}
+
+func TestConverseStreamRetriesServerAndNetworkErrors(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.StreamRetryMax = 2
+	client.config.StreamRetryBaseDelay = time.Millisecond
+	body := newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"hola"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+	})
+
+	// Un 500 (InternalServerException) y una conexión reseteada antes de la respuesta buena
+	var calls atomic.Int32
+	stub := bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			switch calls.Add(1) {
+			case 1:
+				return &http.Response{
+					StatusCode: http.StatusInternalServerError,
+					Header: http.Header{
+						"Content-Type":     []string{"application/json"},
+						"X-Amzn-Errortype": []string{"InternalServerException"},
+					},
+					Body: io.NopCloser(strings.NewReader(`{"message":"internal error"}`)),
+				}, nil
+			case 2:
+				return nil, syscall.ECONNRESET
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		})},
+	})
+
+	rec := httptest.NewRecorder()
+	if err := client.handleBedrockStreamConverse(context.Background(), rec, stub, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, nil, nil, converseOptions{}); err != nil {
+		t.Fatalf("Expected the stream to succeed after retrying 5xx and network errors, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 ConverseStream calls, got %d", calls.Load())
+	}
+}
@@ -0,0 +1,555 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+	"bedrock-proxy-test/pkg/metrics"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// setupTestLogger configura el logger global para tests y retorna el buffer de salida
+func setupTestLogger(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	Logger = amslog.NewLogger(amslog.Config{
+		ServiceName:    "bedrock-proxy",
+		ServiceVersion: "1.0.0",
+		Environment:    "dev",
+		Output:         &buf,
+		MinLevel:       amslog.LevelDebug,
+	})
+	t.Cleanup(func() { Logger.Close() })
+	return &buf
+}
+
+// newTestProxyRequest crea una request autenticada para HandleProxy
+func newTestProxyRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	user := auth.UserContext{
+		UserID:                  "user-1",
+		Email:                   "user@example.com",
+		DefaultInferenceProfile: "eu.anthropic.claude-sonnet-4-5-20250929-v1:0",
+	}
+	ctx := context.WithValue(req.Context(), auth.UserContextKey, user)
+	return req.WithContext(ctx)
+}
+
+// containsEvent indica si la salida del logger contiene un evento con el nombre dado
+func containsEvent(output, eventName string) bool {
+	return strings.Contains(output, `"event.name":"`+eventName+`"`)
+}
+
+func newTestBedrockClient() *BedrockClient {
+	return &BedrockClient{
+		config: &BedrockConfig{
+			AccessKey:               "test-access-key",
+			SecretKey:               "test-secret-key",
+			Region:                  "eu-west-1",
+			RequireMetricsForStream: true,
+		},
+	}
+}
+
+func TestIsMetricsHealthy(t *testing.T) {
+	client := newTestBedrockClient()
+	if !client.IsMetricsHealthy() {
+		t.Error("Expected client without metrics worker to be healthy")
+	}
+
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+	client.metricsWorker.Start()
+	if !client.IsMetricsHealthy() {
+		t.Error("Expected client with running metrics worker to be healthy")
+	}
+
+	client.metricsWorker.Stop()
+	if client.IsMetricsHealthy() {
+		t.Error("Expected client with stopped metrics worker to be unhealthy")
+	}
+}
+
+func TestHandleProxyRefusesStreamAfterMetricsStop(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+	client.metricsWorker.Start()
+	client.metricsWorker.Stop()
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "hola"}]}`))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", rec.Code)
+	}
+}
+
+func TestBuildConverseStreamInputLatencyOptimized(t *testing.T) {
+	modelID := "eu.anthropic.claude-3-5-haiku-20241022-v1:0"
+
+	input := buildConverseStreamInput(modelID, nil, nil, 1024, converseOptions{Latency: types.PerformanceConfigLatencyOptimized})
+	if input.PerformanceConfig == nil || input.PerformanceConfig.Latency != types.PerformanceConfigLatencyOptimized {
+		t.Fatalf("Expected PerformanceConfig latency optimized, got %+v", input.PerformanceConfig)
+	}
+
+	input = buildConverseStreamInput(modelID, nil, nil, 1024, converseOptions{Latency: types.PerformanceConfigLatencyStandard})
+	if input.PerformanceConfig != nil {
+		t.Errorf("Expected no PerformanceConfig for standard latency, got %+v", input.PerformanceConfig)
+	}
+}
+
+func TestBuildConverseStreamInputLatencyUnsupportedModel(t *testing.T) {
+	input := buildConverseStreamInput("eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, converseOptions{Latency: types.PerformanceConfigLatencyOptimized})
+	if input.PerformanceConfig != nil {
+		t.Errorf("Expected PerformanceConfig to be omitted for unsupported model, got %+v", input.PerformanceConfig)
+	}
+}
+
+func TestResolveLatencyMode(t *testing.T) {
+	tests := []struct {
+		name             string
+		payload          map[string]interface{}
+		defaultOptimized bool
+		expected         types.PerformanceConfigLatency
+	}{
+		{"default standard", map[string]interface{}{}, false, types.PerformanceConfigLatencyStandard},
+		{"default optimized from config", map[string]interface{}{}, true, types.PerformanceConfigLatencyOptimized},
+		{"performance_config optimized", map[string]interface{}{"performance_config": map[string]interface{}{"latency": "optimized"}}, false, types.PerformanceConfigLatencyOptimized},
+		{"performance_config overrides config", map[string]interface{}{"performance_config": map[string]interface{}{"latency": "standard"}}, true, types.PerformanceConfigLatencyStandard},
+		{"service_tier optimized", map[string]interface{}{"service_tier": "latency_optimized"}, false, types.PerformanceConfigLatencyOptimized},
+		{"service_tier standard_only", map[string]interface{}{"service_tier": "standard_only"}, true, types.PerformanceConfigLatencyStandard},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveLatencyMode(tt.payload, tt.defaultOptimized); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestBuildConverseStreamInputForwardsTopK(t *testing.T) {
+	input := buildConverseStreamInput("eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, converseOptions{TopK: 40})
+	if input.AdditionalModelRequestFields == nil {
+		t.Fatal("Expected AdditionalModelRequestFields to be set")
+	}
+
+	fields, err := documentToMap(input.AdditionalModelRequestFields)
+	if err != nil {
+		t.Fatalf("Failed to decode AdditionalModelRequestFields: %v", err)
+	}
+	if fmt.Sprint(fields["top_k"]) != "40" {
+		t.Errorf("Expected top_k 40, got %v", fields["top_k"])
+	}
+
+	input = buildConverseStreamInput("eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, converseOptions{})
+	if input.AdditionalModelRequestFields != nil {
+		t.Error("Expected no AdditionalModelRequestFields without top_k")
+	}
+}
+
+func TestExtractTopK(t *testing.T) {
+	if topK, err := extractTopK(map[string]interface{}{}); err != nil || topK != 0 {
+		t.Errorf("Expected no top_k, got %d (%v)", topK, err)
+	}
+	if topK, err := extractTopK(map[string]interface{}{"top_k": float64(5)}); err != nil || topK != 5 {
+		t.Errorf("Expected top_k 5, got %d (%v)", topK, err)
+	}
+
+	for _, invalid := range []interface{}{float64(0), float64(-3), float64(2.5), "10", true} {
+		if _, err := extractTopK(map[string]interface{}{"top_k": invalid}); err == nil {
+			t.Errorf("Expected error for top_k %v", invalid)
+		}
+	}
+}
+
+func TestHandleProxyRejectsInvalidTopK(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	body := `{"stream": true, "top_k": -1, "messages": [{"role": "user", "content": "hola"}]}`
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(body))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "top_k must be a positive integer") {
+		t.Errorf("Unexpected body: %s", rec.Body.String())
+	}
+}
+
+func TestHandleProxyNonStreamForwardsAllowedHeaders(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.NonStreamRawHTTP = true
+	client.httpClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Content-Type":                      []string{"application/json"},
+				"X-Amzn-Requestid":                  []string{"aws-internal-id"},
+				"X-Amzn-Bedrock-Invocation-Latency": []string{"120"},
+				"X-Trace-Id":                        []string{"bedrock-trace"},
+			},
+			Body: io.NopCloser(strings.NewReader(`{"content":[]}`)),
+		}, nil
+	})}
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Trace-ID", "proxy-trace")
+	client.HandleProxy(rec, newTestProxyRequest(`{"messages": [{"role": "user", "content": "hola"}]}`))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Expected Content-Type to be forwarded, got %q", got)
+	}
+	if rec.Header().Get("X-Amzn-RequestId") != "" || rec.Header().Get("X-Amzn-Bedrock-Invocation-Latency") != "" {
+		t.Errorf("Expected internal AWS headers not to be forwarded, got %v", rec.Header())
+	}
+	if got := rec.Header().Get("X-Trace-ID"); got != "proxy-trace" {
+		t.Errorf("Expected proxy header to be kept, got %q", got)
+	}
+
+	// Cabeceras adicionales configurables
+	client.config.ForwardResponseHeaders = []string{"Content-Type", "x-amzn-bedrock-invocation-latency"}
+	rec = httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"messages": [{"role": "user", "content": "hola"}]}`))
+	if got := rec.Header().Get("X-Amzn-Bedrock-Invocation-Latency"); got != "120" {
+		t.Errorf("Expected allowlisted header to be forwarded, got %q", got)
+	}
+}
+
+func TestDefaultMaxTokensPerModel(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.ModelDefaultMaxTokens = parseModelIntMap("haiku=4096, claude-sonnet-4=16384, claude-sonnet-4-5=32000, bad=abc, zero=0")
+
+	tests := []struct {
+		modelID  string
+		expected int32
+	}{
+		{"eu.anthropic.claude-3-5-haiku-20241022-v1:0", 4096},
+		{"eu.anthropic.claude-sonnet-4-20250514-v1:0", 16384},
+		{"eu.anthropic.claude-sonnet-4-5-20250929-v1:0", 32000},
+		{"eu.amazon.nova-pro-v1:0", DefaultMaxTokens},
+	}
+	for _, tt := range tests {
+		if got := client.defaultMaxTokens(tt.modelID); got != tt.expected {
+			t.Errorf("defaultMaxTokens(%s) = %d, expected %d", tt.modelID, got, tt.expected)
+		}
+	}
+
+	if _, ok := client.config.ModelDefaultMaxTokens["bad"]; ok {
+		t.Error("Expected non-numeric value to be ignored")
+	}
+	if _, ok := client.config.ModelDefaultMaxTokens["zero"]; ok {
+		t.Error("Expected non-positive value to be ignored")
+	}
+}
+
+func TestBuildConverseInputMaxTokensPriority(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.ModelDefaultMaxTokens = map[string]int{"haiku": 4096}
+
+	input, _, err := client.BuildConverseInput(context.Background(), map[string]interface{}{}, "eu.anthropic.claude-3-5-haiku-20241022-v1:0", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := *input.InferenceConfig.MaxTokens; got != 4096 {
+		t.Errorf("Expected per-model default 4096, got %d", got)
+	}
+
+	input, _, err = client.BuildConverseInput(context.Background(), map[string]interface{}{}, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := *input.InferenceConfig.MaxTokens; got != DefaultMaxTokens {
+		t.Errorf("Expected global default %d, got %d", DefaultMaxTokens, got)
+	}
+
+	input, _, err = client.BuildConverseInput(context.Background(), map[string]interface{}{"max_tokens": float64(1000)}, "eu.anthropic.claude-3-5-haiku-20241022-v1:0", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := *input.InferenceConfig.MaxTokens; got != 1000 {
+		t.Errorf("Expected request max_tokens 1000 to win, got %d", got)
+	}
+}
+
+func TestLoadBedrockConfigDefaultsAnthropicVersion(t *testing.T) {
+	logs := setupTestLogger(t)
+	t.Setenv("AWS_BEDROCK_ANTHROPIC_DEFAULT_VERSION", "")
+
+	config := LoadBedrockConfigWithEnv()
+	if config.AnthropicDefaultVersion != DefaultAnthropicVersion {
+		t.Errorf("Expected built-in default %q, got %q", DefaultAnthropicVersion, config.AnthropicDefaultVersion)
+	}
+	if !containsEvent(logs.String(), "ANTHROPIC_VERSION_DEFAULTED") {
+		t.Error("Expected ANTHROPIC_VERSION_DEFAULTED warning")
+	}
+
+	t.Setenv("AWS_BEDROCK_ANTHROPIC_DEFAULT_VERSION", "bedrock-2099-01-01")
+	if got := LoadBedrockConfigWithEnv().AnthropicDefaultVersion; got != "bedrock-2099-01-01" {
+		t.Errorf("Expected configured version, got %q", got)
+	}
+}
+
+func TestAnthropicVersionResolution(t *testing.T) {
+	client := newTestBedrockClient()
+
+	// Config sin default (p.ej. construida a mano): nunca se envía un anthropic_version vacío
+	if got := client.anthropicVersion("", ""); got != DefaultAnthropicVersion {
+		t.Errorf("Expected built-in default with empty config, got %q", got)
+	}
+
+	client.config.AnthropicDefaultVersion = "bedrock-default"
+	client.config.AnthropicVersionMappings = map[string]string{
+		"claude-custom": "bedrock-model-version",
+		"2023-06-01":    "bedrock-2023-05-31",
+	}
+
+	tests := []struct {
+		model, clientVersion, expected string
+	}{
+		{"claude-custom", "2023-06-01", "bedrock-model-version"},
+		{"claude-other", "2023-06-01", "bedrock-2023-05-31"},
+		{"claude-other", "", "bedrock-default"},
+	}
+	for _, tt := range tests {
+		if got := client.anthropicVersion(tt.model, tt.clientVersion); got != tt.expected {
+			t.Errorf("anthropicVersion(%q, %q) = %q, expected %q", tt.model, tt.clientVersion, got, tt.expected)
+		}
+	}
+}
+
+func TestValidateVersionMappingsWarnsOnDefaultedModels(t *testing.T) {
+	logs := setupTestLogger(t)
+	config := &BedrockConfig{
+		ModelMappings: map[string]string{
+			"claude-mapped":   "anthropic.claude-mapped-v1:0",
+			"claude-unmapped": "anthropic.claude-unmapped-v1:0",
+		},
+		AnthropicVersionMappings: map[string]string{"claude-mapped": "bedrock-2023-05-31"},
+		AnthropicDefaultVersion:  DefaultAnthropicVersion,
+	}
+
+	if err := ValidateVersionMappings(config); err != nil {
+		t.Fatalf("Expected only a warning outside strict mode, got %v", err)
+	}
+	output := logs.String()
+	if strings.Count(output, "ANTHROPIC_VERSION_MAPPING_MISSING") != 1 || !strings.Contains(output, "claude-unmapped") {
+		t.Errorf("Expected a single warning for claude-unmapped, got %s", output)
+	}
+	if strings.Contains(output, `"claude-mapped"`) {
+		t.Errorf("Expected no warning for model with version mapping, got %s", output)
+	}
+
+	config.StrictVersionMappings = true
+	if err := ValidateVersionMappings(config); err == nil || !strings.Contains(err.Error(), "claude-unmapped") {
+		t.Errorf("Expected strict mode error naming claude-unmapped, got %v", err)
+	}
+
+	config.AnthropicVersionMappings["claude-unmapped"] = "bedrock-2023-05-31"
+	logs.Reset()
+	if err := ValidateVersionMappings(config); err != nil || containsEvent(logs.String(), "ANTHROPIC_VERSION_MAPPING_MISSING") {
+		t.Errorf("Expected no warning or error when every model is mapped, got %v", err)
+	}
+}
+
+func TestSignRequestNeverSendsEmptyAnthropicVersion(t *testing.T) {
+	client := newTestBedrockClient()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude","max_tokens":10,"messages":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	signed, _, err := client.SignRequest(req, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	body, _ := io.ReadAll(signed.Body)
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Invalid signed body: %v", err)
+	}
+	if payload["anthropic_version"] != DefaultAnthropicVersion {
+		t.Errorf("Expected anthropic_version %q, got %v", DefaultAnthropicVersion, payload["anthropic_version"])
+	}
+}
+
+// signedThinking firma una request contra el profile y devuelve el campo thinking enviado a Bedrock
+func signedThinking(t *testing.T, client *BedrockClient, profile, body string) (interface{}, bool) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	signed, _, err := client.SignRequest(req, profile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	signedBody, _ := io.ReadAll(signed.Body)
+	var payload map[string]interface{}
+	if err := json.Unmarshal(signedBody, &payload); err != nil {
+		t.Fatalf("Invalid signed body: %v", err)
+	}
+	thinking, ok := payload["thinking"]
+	return thinking, ok
+}
+
+func TestSignRequestReasoningPerModel(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.EnableOutputReason = true
+	client.config.ReasonBudgetTokens = 2048
+	client.config.ReasoningModels = []string{"claude-sonnet-4-5", "claude-3-7-sonnet"}
+
+	body := `{"model":"claude","max_tokens":4096,"messages":[]}`
+
+	// Modelo con extended thinking: se inyecta el bloque thinking
+	thinking, ok := signedThinking(t, client, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", body)
+	if !ok {
+		t.Fatal("Expected thinking to be injected for reasoning-capable model")
+	}
+	if budget := thinking.(map[string]interface{})["budget_tokens"]; budget != float64(2048) {
+		t.Errorf("Expected budget_tokens 2048, got %v", budget)
+	}
+
+	// Modelo sin extended thinking: ni se inyecta ni se reenvía el del cliente
+	if _, ok := signedThinking(t, client, "eu.anthropic.claude-3-5-haiku-20241022-v1:0", body); ok {
+		t.Error("Expected no thinking for model without reasoning support")
+	}
+	clientThinking := `{"model":"claude","max_tokens":4096,"messages":[],"thinking":{"type":"enabled","budget_tokens":1024}}`
+	if _, ok := signedThinking(t, client, "eu.anthropic.claude-3-5-haiku-20241022-v1:0", clientThinking); ok {
+		t.Error("Expected client thinking to be dropped for model without reasoning support")
+	}
+
+	// Sin allowlist se mantiene el comportamiento global
+	client.config.ReasoningModels = nil
+	if _, ok := signedThinking(t, client, "eu.anthropic.claude-3-5-haiku-20241022-v1:0", body); !ok {
+		t.Error("Expected global flag to apply to every model without REASONING_MODELS")
+	}
+}
+
+func TestSignRequestStripsConfiguredFields(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.StripRequestFields = []string{"metadata_custom", "client_flag"}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(
+		`{"model":"claude","stream":false,"max_tokens":10,"messages":[],"metadata_custom":{"a":1},"client_flag":true,"prime_cache":true}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	signed, _, err := client.SignRequest(req, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	body, _ := io.ReadAll(signed.Body)
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Invalid signed body: %v", err)
+	}
+	for _, field := range []string{"metadata_custom", "client_flag", "model", "stream", "prime_cache"} {
+		if _, ok := payload[field]; ok {
+			t.Errorf("Expected %q to be stripped from forwarded body", field)
+		}
+	}
+	if payload["max_tokens"] != float64(10) {
+		t.Errorf("Expected max_tokens to be preserved, got %v", payload["max_tokens"])
+	}
+}
+
+func TestConverseStreamSendsMessageStartBeforeContent(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"hola"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":1234,"outputTokens":5,"totalTokens":1239,"cacheReadInputTokens":1000},"metrics":{"latencyMs":100}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "hola"}]}`))
+
+	body := rec.Body.String()
+	start := strings.Index(body, "event: message_start")
+	delta := strings.Index(body, "event: content_block_delta")
+	if start == -1 || delta == -1 || start > delta {
+		t.Fatalf("Expected message_start before the first content delta, got %s", body)
+	}
+
+	// El usage definitivo (Metadata) viaja en message_delta, justo antes de message_stop
+	messageDelta := strings.Index(body, "event: message_delta")
+	messageStop := strings.Index(body, "event: message_stop")
+	if messageDelta == -1 || messageStop < messageDelta {
+		t.Fatalf("Expected message_delta followed by message_stop, got %s", body)
+	}
+	final := body[messageDelta:messageStop]
+	for _, expected := range []string{`"stop_reason":"end_turn"`, `"input_tokens":1234`, `"output_tokens":5`, `"cache_read_input_tokens":1000`} {
+		if !strings.Contains(final, expected) {
+			t.Errorf("Expected %s in final message_delta, got %s", expected, final)
+		}
+	}
+}
+
+func TestMetricsCaptureUsesMessageDeltaUsage(t *testing.T) {
+	mc := NewMetricsCapture(httptest.NewRecorder(), "model", "req-1", httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	mc.extractTokensFromEvent("message_start", `{"type":"message_start","message":{"usage":{"input_tokens":0,"output_tokens":0}}}`)
+	mc.extractTokensFromEvent("message_delta", `{"type":"message_delta","usage":{"input_tokens":42,"output_tokens":7,"cache_creation_input_tokens":3,"cache_read_input_tokens":9}}`)
+
+	if mc.inputTokens != 42 || mc.outputTokens != 7 || mc.cacheWriteTokens != 3 || mc.cacheReadTokens != 9 {
+		t.Errorf("Expected final usage from message_delta, got in=%d out=%d write=%d read=%d",
+			mc.inputTokens, mc.outputTokens, mc.cacheWriteTokens, mc.cacheReadTokens)
+	}
+}
+
+func TestConverseStreamMessageIDFromRequestID(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.RequireMetricsForStream = false
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "hola"}]}`))
+
+	var start struct {
+		Message struct {
+			ID string `json:"id"`
+		} `json:"message"`
+	}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, `data: {"type":"message_start"`) {
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &start)
+		}
+	}
+	if !strings.HasPrefix(start.Message.ID, "msg_") || start.Message.ID == "msg_" {
+		t.Fatalf("Expected non-empty msg_ id in message_start, got %q (%s)", start.Message.ID, rec.Body.String())
+	}
+
+	// El id se deriva del request ID que aparece en los logs
+	requestID := strings.TrimPrefix(start.Message.ID, "msg_")
+	if !strings.Contains(logs.String(), requestID) {
+		t.Errorf("Expected message id to match the logged request ID %q", requestID)
+	}
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// converseThinking devuelve el extended thinking de una petición Converse, igual que SignRequest con
+// InvokeModel: el thinking del cliente si lo envía o, si no, AWS_BEDROCK_REASON_BUDGET_TOKENS.
+// nil si el reasoning está desactivado, el modelo no está en REASONING_MODELS o el cliente lo desactiva
+func (this *BedrockClient) converseThinking(payload map[string]interface{}, modelIDs ...string) *ThinkingConfig {
+	if !this.reasoningEnabled(modelIDs...) {
+		return nil
+	}
+	thinking := &ThinkingConfig{Type: "enabled", BudgetTokens: this.config.ReasonBudgetTokens}
+	if requested, ok := payload["thinking"].(map[string]interface{}); ok {
+		if thinkingType, _ := requested["type"].(string); thinkingType != "enabled" {
+			return nil
+		}
+		if budget, ok := requested["budget_tokens"].(float64); ok && budget > 0 {
+			thinking.BudgetTokens = int(budget)
+		}
+	}
+	return thinking
+}
+
+// convertThinkingBlock convierte un bloque thinking o redacted_thinking que el cliente devuelve en un turno
+// del assistant a reasoningContent de Converse. Con thinking activo, Bedrock exige que el turno que contiene
+// un tool_use empiece por su thinking con la firma original. nil si el bloque no tiene contenido válido
+func convertThinkingBlock(blockMap map[string]interface{}) types.ContentBlock {
+	if blockType, _ := blockMap["type"].(string); blockType == "redacted_thinking" {
+		data, _ := blockMap["data"].(string)
+		redacted, err := base64.StdEncoding.DecodeString(data)
+		if err != nil || len(redacted) == 0 {
+			return nil
+		}
+		return &types.ContentBlockMemberReasoningContent{
+			Value: &types.ReasoningContentBlockMemberRedactedContent{Value: redacted},
+		}
+	}
+
+	thinking, _ := blockMap["thinking"].(string)
+	reasoning := types.ReasoningTextBlock{Text: aws.String(thinking)}
+	if signature, _ := blockMap["signature"].(string); signature != "" {
+		reasoning.Signature = aws.String(signature)
+	}
+	return &types.ContentBlockMemberReasoningContent{
+		Value: &types.ReasoningContentBlockMemberReasoningText{Value: reasoning},
+	}
+}
+
+// writeReasoningDelta traduce un delta de reasoning de Converse a eventos SSE de Anthropic: el texto como
+// thinking_delta y la firma como signature_delta. Converse no envía contentBlockStart para estos bloques,
+// así que el primer delta de cada índice abre el bloque (started registra los ya abiertos). El contenido
+// redactado llega completo y se envía como bloque redacted_thinking con sus datos en base64
+func writeReasoningDelta(w io.Writer, started map[int32]bool, index int32, delta types.ReasoningContentBlockDelta) {
+	if redacted, ok := delta.(*types.ReasoningContentBlockDeltaMemberRedactedContent); ok {
+		if !started[index] {
+			started[index] = true
+			writeContentBlockStart(w, index, map[string]interface{}{
+				"type": "redacted_thinking",
+				"data": base64.StdEncoding.EncodeToString(redacted.Value),
+			})
+		}
+		return
+	}
+
+	if !started[index] {
+		started[index] = true
+		writeContentBlockStart(w, index, map[string]interface{}{
+			"type":     "thinking",
+			"thinking": "",
+		})
+	}
+
+	switch d := delta.(type) {
+	case *types.ReasoningContentBlockDeltaMemberText:
+		thinking, _ := json.Marshal(d.Value)
+		fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":%d,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":%s}}\n\n", index, thinking)
+	case *types.ReasoningContentBlockDeltaMemberSignature:
+		signature, _ := json.Marshal(d.Value)
+		fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":%d,\"delta\":{\"type\":\"signature_delta\",\"signature\":%s}}\n\n", index, signature)
+	}
+}
+
+// writeContentBlockStart emite content_block_start con el bloque dado en formato Anthropic
+func writeContentBlockStart(w io.Writer, index int32, block map[string]interface{}) {
+	blockJSON, _ := json.Marshal(block)
+	fmt.Fprintf(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":%d,\"content_block\":%s}\n\n", index, blockJSON)
+}
@@ -0,0 +1,228 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConverseStreamEmitsThinkingDeltas(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"text":"El usuario saluda, "}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"text":"respondo en español"}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"signature":"sig-123"}}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"text":"Hola"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":1}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":10,"outputTokens":8,"totalTokens":18},"metrics":{"latencyMs":40}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "Hola"}]}`))
+
+	var events []map[string]interface{}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("Invalid SSE data %q: %v", data, err)
+		}
+		if index, ok := event["index"].(float64); ok && index == 0 {
+			events = append(events, event)
+		}
+	}
+
+	// content_block_start thinking, dos thinking_delta, signature_delta y content_block_stop
+	if len(events) != 5 {
+		t.Fatalf("Expected 5 events for the thinking block, got %d\n%s", len(events), rec.Body.String())
+	}
+	start, _ := events[0]["content_block"].(map[string]interface{})
+	if events[0]["type"] != "content_block_start" || start["type"] != "thinking" || start["thinking"] != "" {
+		t.Errorf("Expected thinking content_block_start, got %v", events[0])
+	}
+	var thinking strings.Builder
+	for _, event := range events[1:3] {
+		delta, _ := event["delta"].(map[string]interface{})
+		if event["type"] != "content_block_delta" || delta["type"] != "thinking_delta" {
+			t.Errorf("Expected thinking_delta, got %v", event)
+		}
+		thinking.WriteString(delta["thinking"].(string))
+	}
+	if thinking.String() != "El usuario saluda, respondo en español" {
+		t.Errorf("Unexpected thinking text %q", thinking.String())
+	}
+	if delta, _ := events[3]["delta"].(map[string]interface{}); delta["type"] != "signature_delta" || delta["signature"] != "sig-123" {
+		t.Errorf("Expected signature_delta, got %v", events[3])
+	}
+	if events[4]["type"] != "content_block_stop" {
+		t.Errorf("Expected content_block_stop, got %v", events[4])
+	}
+
+	// El texto sigue con su propio bloque y sin cambios
+	if !strings.Contains(rec.Body.String(), `"index":1,"delta":{"type":"text_delta","text":"Hola"}`) {
+		t.Errorf("Expected text delta after the thinking block, got\n%s", rec.Body.String())
+	}
+}
+
+func TestStreamingDisabledAggregatesThinkingBlocks(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.StreamingDisabled = true
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"text":"Pienso"}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"signature":"sig"}}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"reasoningContent":{"redactedContent":"AQID"}}}`},
+		{"contentBlockStop", `{"contentBlockIndex":1}`},
+		{"contentBlockDelta", `{"contentBlockIndex":2,"delta":{"text":"Hola"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":2}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":10,"outputTokens":8,"totalTokens":18},"metrics":{"latencyMs":40}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "Hola"}]}`))
+
+	var response struct {
+		Content []map[string]interface{} `json:"content"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid JSON response: %v\n%s", err, rec.Body.String())
+	}
+	if len(response.Content) != 3 {
+		t.Fatalf("Expected 3 content blocks, got %v", response.Content)
+	}
+	if block := response.Content[0]; block["type"] != "thinking" || block["thinking"] != "Pienso" || block["signature"] != "sig" {
+		t.Errorf("Expected aggregated thinking block, got %v", block)
+	}
+	if block := response.Content[1]; block["type"] != "redacted_thinking" || block["data"] != "AQID" {
+		t.Errorf("Expected redacted_thinking block, got %v", block)
+	}
+	if block := response.Content[2]; block["type"] != "text" || block["text"] != "Hola" {
+		t.Errorf("Expected text block, got %v", block)
+	}
+}
+
+func TestBuildConverseStreamInputSendsThinking(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.EnableOutputReason = true
+	client.config.ReasonBudgetTokens = 2048
+
+	opts := converseOptions{TopK: 40, Thinking: client.converseThinking(map[string]interface{}{})}
+	input := buildConverseStreamInput("eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 4096, opts)
+	fields, err := documentToMap(input.AdditionalModelRequestFields)
+	if err != nil {
+		t.Fatalf("Failed to decode AdditionalModelRequestFields: %v", err)
+	}
+	thinking, _ := fields["thinking"].(map[string]interface{})
+	if thinking["type"] != "enabled" || fmt.Sprint(thinking["budget_tokens"]) != "2048" {
+		t.Errorf("Expected thinking with the configured budget, got %v", fields["thinking"])
+	}
+	if fmt.Sprint(fields["top_k"]) != "40" {
+		t.Errorf("Expected top_k alongside thinking, got %v", fields["top_k"])
+	}
+	if input.InferenceConfig.Temperature != nil {
+		t.Errorf("Expected no temperature with thinking, got %v", *input.InferenceConfig.Temperature)
+	}
+
+	// El thinking del cliente tiene prioridad; si lo desactiva no se envía
+	requested := client.converseThinking(map[string]interface{}{"thinking": map[string]interface{}{"type": "enabled", "budget_tokens": float64(8000)}})
+	if requested == nil || requested.BudgetTokens != 8000 {
+		t.Errorf("Expected client budget, got %+v", requested)
+	}
+	if got := client.converseThinking(map[string]interface{}{"thinking": map[string]interface{}{"type": "disabled"}}); got != nil {
+		t.Errorf("Expected thinking disabled by the client, got %+v", got)
+	}
+
+	client.config.EnableOutputReason = false
+	if got := client.converseThinking(map[string]interface{}{}); got != nil {
+		t.Errorf("Expected no thinking with reasoning disabled, got %+v", got)
+	}
+}
+
+func TestConverseThinkingHonoursReasoningModels(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.EnableOutputReason = true
+	client.config.ReasoningModels = []string{"claude-sonnet-4"}
+
+	if got := client.converseThinking(map[string]interface{}{}, "eu.anthropic.claude-3-5-haiku-20241022-v1:0", "claude-3-5-haiku"); got != nil {
+		t.Errorf("Expected no thinking for a model outside REASONING_MODELS, got %+v", got)
+	}
+	// Tampoco si el cliente lo pide: Bedrock lo rechazaría
+	requested := map[string]interface{}{"thinking": map[string]interface{}{"type": "enabled", "budget_tokens": float64(2048)}}
+	if got := client.converseThinking(requested, "eu.anthropic.claude-3-5-haiku-20241022-v1:0"); got != nil {
+		t.Errorf("Expected client thinking to be dropped for a non-reasoning model, got %+v", got)
+	}
+	if got := client.converseThinking(map[string]interface{}{}, "arn:aws:bedrock:eu-west-1:123:application-inference-profile/abc", "claude-sonnet-4-5"); got == nil {
+		t.Error("Expected thinking when the requested model is in REASONING_MODELS")
+	}
+}
+
+func TestHandleProxyToolLoopSendsThinkingBack(t *testing.T) {
+	setupTestLogger(t)
+
+	var sent []byte
+	client := newCeilingTestClient(t, &sent)
+	client.config.EnableOutputReason = true
+	client.config.ReasonBudgetTokens = 2048
+	client.config.NativeTools = true
+
+	// Segundo turno de un bucle de tools: thinking -> tool_use del assistant y tool_result del usuario
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "max_tokens": 4096,
+		"tools": [{"name": "read_file", "description": "Read a file", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": "Lee a.go"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "Necesito leer el fichero", "signature": "sig-123"},
+				{"type": "redacted_thinking", "data": "cmVkYWN0ZWQ="},
+				{"type": "tool_use", "id": "toolu_1", "name": "read_file", "input": {"path": "a.go"}}
+			]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "package main"}]}
+		]}`))
+	if rec.Code != 200 {
+		t.Fatalf("Expected the tool loop to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var input struct {
+		Messages []struct {
+			Role    string                   `json:"role"`
+			Content []map[string]interface{} `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(sent, &input); err != nil {
+		t.Fatalf("Invalid Converse request: %v (%s)", err, sent)
+	}
+	if len(input.Messages) != 3 || len(input.Messages[1].Content) != 3 {
+		t.Fatalf("Expected the assistant turn with thinking, redacted thinking and tool_use, got %s", sent)
+	}
+
+	assistant := input.Messages[1].Content
+	reasoning, _ := assistant[0]["reasoningContent"].(map[string]interface{})
+	text, _ := reasoning["reasoningText"].(map[string]interface{})
+	if text["text"] != "Necesito leer el fichero" || text["signature"] != "sig-123" {
+		t.Errorf("Expected the assistant turn to start with the signed thinking, got %v", assistant[0])
+	}
+	redacted, _ := assistant[1]["reasoningContent"].(map[string]interface{})
+	if redacted["redactedContent"] != "cmVkYWN0ZWQ=" {
+		t.Errorf("Expected the redacted thinking bytes, got %v", assistant[1])
+	}
+	if _, ok := assistant[2]["toolUse"]; !ok {
+		t.Errorf("Expected the tool_use after the thinking, got %v", assistant[2])
+	}
+	if _, ok := input.Messages[2].Content[0]["toolResult"]; !ok {
+		t.Errorf("Expected the native tool_result, got %v", input.Messages[2].Content[0])
+	}
+}
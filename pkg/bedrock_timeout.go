@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBedrockTimeout es el tiempo máximo de una llamada a Bedrock (arranque del stream o respuesta
+// completa sin stream). Es menor que REQUEST_TIMEOUT_SECONDS, que cubre la request entera
+const DefaultBedrockTimeout = 120 * time.Second
+
+// errBedrockTimeout indica que Bedrock no respondió dentro de BEDROCK_REQUEST_TIMEOUT
+var errBedrockTimeout = errors.New("bedrock call timed out")
+
+// parseBedrockTimeout interpreta BEDROCK_REQUEST_TIMEOUT como duración ("90s", "2m") o segundos ("120").
+// 0 desactiva el límite; un valor inválido conserva el valor por defecto
+func parseBedrockTimeout(value string, fallback time.Duration) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if timeout, err := time.ParseDuration(value); err == nil && timeout >= 0 {
+		return timeout
+	}
+	return fallback
+}
+
+// loadBedrockTimeout lee BEDROCK_REQUEST_TIMEOUT
+func loadBedrockTimeout() time.Duration {
+	return parseBedrockTimeout(os.Getenv("BEDROCK_REQUEST_TIMEOUT"), DefaultBedrockTimeout)
+}
+
+// bedrockCallContext aplica el deadline de Bedrock a una llamada que termina al recibir la respuesta
+// completa (Converse y path HTTP sin stream)
+func (this *BedrockClient) bedrockCallContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if this.config.BedrockTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, this.config.BedrockTimeout)
+}
+
+// startStreamDeadline aplica el deadline de Bedrock solo al arranque de un stream: el contexto devuelto
+// se cancela si stop no se llama antes de BedrockTimeout, y sigue vivo durante el stream si se llama a
+// tiempo (un stream largo no es un Bedrock colgado; lo limita REQUEST_TIMEOUT_SECONDS)
+func (this *BedrockClient) startStreamDeadline(ctx context.Context) (streamCtx context.Context, stop func(), cancel context.CancelFunc) {
+	streamCtx, cancel = context.WithCancel(ctx)
+	if this.config.BedrockTimeout <= 0 {
+		return streamCtx, func() {}, cancel
+	}
+	timer := time.AfterFunc(this.config.BedrockTimeout, cancel)
+	return streamCtx, func() { timer.Stop() }, cancel
+}
+
+// asBedrockTimeout convierte err en errBedrockTimeout si lo causó el deadline de Bedrock (y no el del
+// cliente o el de la request completa, que tienen su propio tratamiento)
+func asBedrockTimeout(parent, callCtx context.Context, err error) error {
+	if err == nil || parent.Err() != nil || callCtx.Err() == nil {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errBedrockTimeout, err)
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// newHangingConverseClient crea un cliente de Bedrock que no responde hasta que se cancela la request
+func newHangingConverseClient() *bedrockRuntime.Client {
+	return bedrockRuntime.New(bedrockRuntime.Options{
+		Region:           "eu-west-1",
+		Credentials:      credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		RetryMaxAttempts: 1,
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})},
+	})
+}
+
+// assertBedrockTimeoutResponse comprueba que el cliente recibió un 504 BEDROCK_TIMEOUT
+func assertBedrockTimeoutResponse(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != string(ErrCodeBedrockTimeout) {
+		t.Errorf("Expected %s error, got %s", ErrCodeBedrockTimeout, rec.Body.String())
+	}
+}
+
+func TestConverseStreamTimesOutOnHungBedrock(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.BedrockTimeout = 50 * time.Millisecond
+	client.config.StreamRetryMax = 0
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	err := client.handleBedrockStreamConverse(context.Background(), rec, newHangingConverseClient(), "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, nil, nil, converseOptions{})
+	if err == nil || classifyBedrockError(err).Code != ErrCodeBedrockTimeout {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the call to be cut at the deadline, took %v", elapsed)
+	}
+	assertBedrockTimeoutResponse(t, rec)
+}
+
+func TestNonStreamTimesOutOnHungBedrock(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.BedrockTimeout = 50 * time.Millisecond
+	client.config.StreamRetryMax = 0
+	client.client = newHangingConverseClient()
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": false, "max_tokens": 100, "messages": [{"role": "user", "content": "Hola"}]}`))
+	assertBedrockTimeoutResponse(t, rec)
+}
+
+func TestBedrockTimeoutDoesNotCutStartedStream(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.BedrockTimeout = 50 * time.Millisecond
+	streamCtx, stop, cancel := client.startStreamDeadline(context.Background())
+	defer cancel()
+	stop()
+
+	time.Sleep(100 * time.Millisecond)
+	if streamCtx.Err() != nil {
+		t.Error("Expected the stream context to stay alive once the stream started")
+	}
+}
+
+func TestParseBedrockTimeout(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":     DefaultBedrockTimeout,
+		"90":   90 * time.Second,
+		"2m":   2 * time.Minute,
+		"0":    0,
+		"nope": DefaultBedrockTimeout,
+		"-5":   DefaultBedrockTimeout,
+	}
+	for value, want := range cases {
+		if got := parseBedrockTimeout(value, DefaultBedrockTimeout); got != want {
+			t.Errorf("parseBedrockTimeout(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -1,16 +1,195 @@
 package pkg
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
 	"strings"
+	"unicode/utf8"
+
+	"bedrock-proxy-test/pkg/amslog"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 )
 
+// validateToolLimits verifica el número de tools y el tamaño de cada input_schema
+// Un límite <= 0 desactiva la comprobación correspondiente
+func validateToolLimits(anthropicTools []interface{}, maxTools, maxSchemaBytes int) error {
+	if maxTools > 0 && len(anthropicTools) > maxTools {
+		return fmt.Errorf("too many tools: %d (max: %d)", len(anthropicTools), maxTools)
+	}
+
+	if maxSchemaBytes <= 0 {
+		return nil
+	}
+
+	for _, tool := range anthropicTools {
+		toolMap, ok := tool.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		inputSchema, ok := toolMap["input_schema"]
+		if !ok {
+			continue
+		}
+
+		schemaBytes, err := json.Marshal(inputSchema)
+		if err != nil {
+			continue
+		}
+
+		if len(schemaBytes) > maxSchemaBytes {
+			name, _ := toolMap["name"].(string)
+			return fmt.Errorf("input_schema of tool %s too large: %d bytes (max: %d)", name, len(schemaBytes), maxSchemaBytes)
+		}
+	}
+
+	return nil
+}
+
+// hasTools indica si el body de una request Anthropic incluye tools
+func hasTools(body []byte) bool {
+	var probe struct {
+		Tools []json.RawMessage `json:"tools"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return false
+	}
+	return len(probe.Tools) > 0
+}
+
+// toolResultTruncatedMarker se añade al final de un tool_result truncado
+const toolResultTruncatedMarker = "\n\n[... tool_result truncated by proxy: %d of %d bytes shown ...]"
+
+// extractToolResultText obtiene el texto de un bloque tool_result (content string o array de bloques text)
+func extractToolResultText(blockMap map[string]interface{}) string {
+	switch content := blockMap["content"].(type) {
+	case string:
+		return content
+	case []interface{}:
+		var parts []string
+		for _, item := range content {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := itemMap["text"].(string); ok {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// truncateToolResult recorta text a maxBytes (respetando UTF-8) y añade un marcador visible
+// Retorna el texto original si maxBytes <= 0 o si no supera el límite
+func truncateToolResult(text string, maxBytes int) (string, bool) {
+	if maxBytes <= 0 || len(text) <= maxBytes {
+		return text, false
+	}
+
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+
+	return text[:cut] + fmt.Sprintf(toolResultTruncatedMarker, cut, len(text)), true
+}
+
+// useNativeTools indica si el modelo/profile usa ToolConfiguration nativa en vez de la inyección XML
+// BEDROCK_NATIVE_TOOLS=true la activa para todos los modelos; si no, cada entrada de NATIVE_TOOL_MODELS
+// puede ser el ID completo o una parte (p.ej. el model ID dentro de un ARN)
+func (this *BedrockClient) useNativeTools(modelID string) bool {
+	if this.config.NativeTools {
+		return true
+	}
+	for _, nativeModel := range this.config.NativeToolModels {
+		if nativeModel != "" && strings.Contains(modelID, nativeModel) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertToolUseBlock convierte un bloque tool_use de Anthropic a bloque nativo de Converse
+func convertToolUseBlock(blockMap map[string]interface{}) types.ContentBlock {
+	toolUseID, _ := blockMap["id"].(string)
+	name, _ := blockMap["name"].(string)
+
+	input, ok := blockMap["input"].(map[string]interface{})
+	if !ok {
+		input = map[string]interface{}{}
+	}
+
+	return &types.ContentBlockMemberToolUse{
+		Value: types.ToolUseBlock{
+			ToolUseId: aws.String(toolUseID),
+			Name:      aws.String(name),
+			Input:     document.NewLazyDocument(input),
+		},
+	}
+}
+
+// convertToolResultBlock convierte un bloque tool_result de Anthropic (ya truncado) a bloque nativo de Converse
+func convertToolResultBlock(blockMap map[string]interface{}, text string) types.ContentBlock {
+	toolUseID, _ := blockMap["tool_use_id"].(string)
+
+	result := types.ToolResultBlock{
+		ToolUseId: aws.String(toolUseID),
+		Content: []types.ToolResultContentBlock{
+			&types.ToolResultContentBlockMemberText{Value: text},
+		},
+	}
+	if isError, _ := blockMap["is_error"].(bool); isError {
+		result.Status = types.ToolResultStatusError
+	}
+
+	return &types.ContentBlockMemberToolResult{Value: result}
+}
+
+// writeToolUseBlockStart emite content_block_start de un bloque tool_use en formato Anthropic
+func writeToolUseBlockStart(w io.Writer, index int32, start types.ToolUseBlockStart) {
+	block, _ := json.Marshal(map[string]interface{}{
+		"type":  "tool_use",
+		"id":    aws.ToString(start.ToolUseId),
+		"name":  aws.ToString(start.Name),
+		"input": map[string]interface{}{},
+	})
+	fmt.Fprintf(w, "event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":%d,\"content_block\":%s}\n\n", index, block)
+}
+
+// logToolConversion emite un evento TOOLS_CONVERSION de aviso (LevelWarn) o error al convertir una tool
+func logToolConversion(ctx context.Context, level amslog.LogLevel, message, toolName string, err error) {
+	event := amslog.Event{
+		Name:    EventToolsConversion,
+		Message: message,
+		Fields: map[string]interface{}{
+			"tool.name": toolName,
+		},
+	}
+	if err != nil {
+		event.Error = &amslog.ErrorInfo{
+			Type:    "ToolConversionError",
+			Message: err.Error(),
+			Code:    "TOOL_SCHEMA_INVALID",
+		}
+	}
+
+	if level == amslog.LevelError {
+		Logger.ErrorContext(ctx, event)
+	} else {
+		Logger.WarningContext(ctx, event)
+	}
+}
+
 // convertAnthropicToolsToBedrock convierte tools de formato Anthropic a formato Bedrock ToolConfiguration
-func convertAnthropicToolsToBedrock(anthropicTools []interface{}) (*types.ToolConfiguration, error) {
+func convertAnthropicToolsToBedrock(ctx context.Context, anthropicTools []interface{}) (*types.ToolConfiguration, error) {
 	if len(anthropicTools) == 0 {
 		return nil, nil
 	}
@@ -28,14 +207,14 @@ func convertAnthropicToolsToBedrock(anthropicTools []interface{}) (*types.ToolCo
 		description, _ := toolMap["description"].(string)
 
 		if name == "" {
-			Log.Warningf("[TOOLS_CONVERSION] Skipping tool without name")
+			logToolConversion(ctx, amslog.LevelWarn, "Skipping tool without name", "", nil)
 			continue
 		}
 		
 		// Bedrock requiere que description no esté vacía
 		if description == "" {
 			description = name // Usar el nombre como descripción si está vacía
-			Log.Warningf("[TOOLS_CONVERSION] Tool %s has empty description, using name as description", name)
+			logToolConversion(ctx, amslog.LevelWarn, "Tool has empty description, using name as description", name, nil)
 		}
 
 		// Extraer input_schema
@@ -44,7 +223,7 @@ func convertAnthropicToolsToBedrock(anthropicTools []interface{}) (*types.ToolCo
 			// Convertir el map a JSON
 			schemaBytes, err := json.Marshal(inputSchema)
 			if err != nil {
-				Log.Errorf("[TOOLS_CONVERSION] Failed to marshal input_schema for tool %s: %v", name, err)
+				logToolConversion(ctx, amslog.LevelError, "Failed to marshal input_schema", name, err)
 				continue
 			}
 			inputSchemaJSON = json.RawMessage(schemaBytes)
@@ -63,7 +242,7 @@ func convertAnthropicToolsToBedrock(anthropicTools []interface{}) (*types.ToolCo
 			// Parsear el JSON a un map[string]interface{}
 			var schemaMap map[string]interface{}
 			if err := json.Unmarshal(inputSchemaJSON, &schemaMap); err != nil {
-				Log.Errorf("[TOOLS_CONVERSION] Failed to parse input_schema for tool %s: %v", name, err)
+				logToolConversion(ctx, amslog.LevelError, "Failed to parse input_schema, using empty schema", name, err)
 				// Usar schema vacío si falla
 				schemaInterface = map[string]interface{}{
 					"type": "object",
@@ -90,8 +269,14 @@ func convertAnthropicToolsToBedrock(anthropicTools []interface{}) (*types.ToolCo
 			Value: toolSpec,
 		})
 
-		Log.Infof("[TOOLS_CONVERSION] Converted tool: %s (description: %s, has_schema: %v)",
-			name, description, len(inputSchemaJSON) > 0)
+		Logger.DebugContext(ctx, amslog.Event{
+			Name:    EventToolsConversion,
+			Message: "Tool converted",
+			Fields: map[string]interface{}{
+				"tool.name":  name,
+				"has_schema": len(inputSchemaJSON) > 0,
+			},
+		})
 	}
 
 	if len(bedrockTools) == 0 {
@@ -155,8 +340,8 @@ func convertAnthropicToolChoiceToBedrock(toolChoice interface{}) types.ToolChoic
 	return nil
 }
 
-// convertAnthropicToolsToJSON convierte tools de formato Anthropic a formato XML
-// compatible con Cline (que espera sintaxis XML para tool calls)
+// convertAnthropicToolsToJSON describe las tools como un array JSON en el system prompt
+// (las llamadas siguen usando sintaxis XML)
 func convertAnthropicToolsToJSON(anthropicTools []interface{}) (string, error) {
 	if len(anthropicTools) == 0 {
 		return "", nil
@@ -203,6 +388,80 @@ func convertAnthropicToolsToJSON(anthropicTools []interface{}) (string, error) {
 	}
 	
 	result.WriteString("\n]\n```\n\n")
+	writeXMLToolUsageRules(&result)
+
+	return result.String(), nil
+}
+
+// convertAnthropicToolsToText describe las tools en texto plano con el formato del system prompt de Cline
+// (descripción, parámetros y ejemplo de uso XML por tool)
+func convertAnthropicToolsToText(anthropicTools []interface{}) (string, error) {
+	if len(anthropicTools) == 0 {
+		return "", nil
+	}
+
+	var result strings.Builder
+	result.WriteString("\n\n# Available Tools\n\n")
+
+	for _, tool := range anthropicTools {
+		toolMap, ok := tool.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name, _ := toolMap["name"].(string)
+		if name == "" {
+			continue
+		}
+		description, _ := toolMap["description"].(string)
+		inputSchema, _ := toolMap["input_schema"].(map[string]interface{})
+		properties, _ := inputSchema["properties"].(map[string]interface{})
+
+		required := map[string]bool{}
+		if requiredList, ok := inputSchema["required"].([]interface{}); ok {
+			for _, param := range requiredList {
+				if paramName, ok := param.(string); ok {
+					required[paramName] = true
+				}
+			}
+		}
+
+		// Orden estable de parámetros para que el system prompt sea cacheable
+		params := make([]string, 0, len(properties))
+		for param := range properties {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+
+		fmt.Fprintf(&result, "## %s\nDescription: %s\n", name, description)
+		if len(params) > 0 {
+			result.WriteString("Parameters:\n")
+			for _, param := range params {
+				paramDescription := ""
+				if paramSchema, ok := properties[param].(map[string]interface{}); ok {
+					paramDescription, _ = paramSchema["description"].(string)
+				}
+				requirement := "optional"
+				if required[param] {
+					requirement = "required"
+				}
+				fmt.Fprintf(&result, "- %s: (%s) %s\n", param, requirement, paramDescription)
+			}
+		}
+		fmt.Fprintf(&result, "Usage:\n<%s>\n", name)
+		for _, param := range params {
+			fmt.Fprintf(&result, "<%s>%s value here</%s>\n", param, param, param)
+		}
+		fmt.Fprintf(&result, "</%s>\n\n", name)
+	}
+
+	writeXMLToolUsageRules(&result)
+
+	return result.String(), nil
+}
+
+// writeXMLToolUsageRules añade las instrucciones de invocación de tools con sintaxis XML
+func writeXMLToolUsageRules(result *strings.Builder) {
 	result.WriteString("## How to Use Tools\n\n")
 	result.WriteString("To use a tool, you MUST use XML tags in this EXACT format:\n\n")
 	result.WriteString("<tool_name>\n")
@@ -228,6 +487,4 @@ func convertAnthropicToolsToJSON(anthropicTools []interface{}) (string, error) {
 	result.WriteString("<parameter name=\"task_progress\">- [ ] Read file</parameter>\n")
 	result.WriteString("</invoke>\n\n")
 	result.WriteString("Remember: Use the tool name directly as the XML tag, with each parameter as a nested XML tag.\n")
-
-	return result.String(), nil
 }
@@ -0,0 +1,455 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// buildTestTools crea n tools con un input_schema cuya descripción es description
+func buildTestTools(n int, description string) []interface{} {
+	tools := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		tools = append(tools, map[string]interface{}{
+			"name":        fmt.Sprintf("tool_%d", i),
+			"description": "test tool",
+			"input_schema": map[string]interface{}{
+				"type":        "object",
+				"description": description,
+			},
+		})
+	}
+	return tools
+}
+
+func TestValidateToolLimitsCount(t *testing.T) {
+	if err := validateToolLimits(buildTestTools(3, ""), 3, 0); err != nil {
+		t.Errorf("Expected 3 tools to be allowed with max 3, got %v", err)
+	}
+
+	err := validateToolLimits(buildTestTools(4, ""), 3, 0)
+	if err == nil || !strings.Contains(err.Error(), "too many tools") {
+		t.Errorf("Expected too many tools error, got %v", err)
+	}
+
+	if err := validateToolLimits(buildTestTools(1000, ""), 0, 0); err != nil {
+		t.Errorf("Expected no limit when maxTools is 0, got %v", err)
+	}
+}
+
+func TestValidateToolLimitsSchemaSize(t *testing.T) {
+	tools := buildTestTools(1, "x")
+	schemaBytes, _ := json.Marshal(tools[0].(map[string]interface{})["input_schema"])
+	size := len(schemaBytes)
+
+	if err := validateToolLimits(tools, 0, size); err != nil {
+		t.Errorf("Expected schema of exactly %d bytes to be allowed, got %v", size, err)
+	}
+
+	err := validateToolLimits(tools, 0, size-1)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Expected schema too large error, got %v", err)
+	}
+}
+
+func TestHandleProxyRejectsTooManyTools(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.MaxTools = 2
+
+	payload := map[string]interface{}{
+		"stream":   true,
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+		"tools":    buildTestTools(3, ""),
+	}
+	body, _ := json.Marshal(payload)
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(string(body)))
+
+	if rec.Code != 400 {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+}
+
+func TestTruncateToolResultAtThreshold(t *testing.T) {
+	text := strings.Repeat("a", 100)
+
+	got, truncated := truncateToolResult(text, 100)
+	if truncated || got != text {
+		t.Errorf("Expected content at the threshold to be kept intact")
+	}
+
+	got, truncated = truncateToolResult(text, 0)
+	if truncated || got != text {
+		t.Errorf("Expected no truncation when the limit is disabled")
+	}
+}
+
+func TestTruncateToolResultAboveThreshold(t *testing.T) {
+	text := strings.Repeat("a", 101)
+
+	got, truncated := truncateToolResult(text, 100)
+	if !truncated {
+		t.Fatal("Expected content above the threshold to be truncated")
+	}
+	if !strings.HasPrefix(got, strings.Repeat("a", 100)) {
+		t.Errorf("Expected the first 100 bytes to be kept")
+	}
+	if !strings.Contains(got, "tool_result truncated by proxy: 100 of 101 bytes shown") {
+		t.Errorf("Expected truncation marker, got %q", got[100:])
+	}
+}
+
+func TestTruncateToolResultKeepsValidUTF8(t *testing.T) {
+	// "ñ" ocupa 2 bytes: cortar en un byte impar no debe partir el carácter
+	text := strings.Repeat("ñ", 10)
+
+	got, truncated := truncateToolResult(text, 5)
+	if !truncated {
+		t.Fatal("Expected truncation")
+	}
+	if !strings.HasPrefix(got, "ññ\n") {
+		t.Errorf("Expected cut at rune boundary, got %q", got)
+	}
+}
+
+func TestConvertMessagesTruncatesToolResult(t *testing.T) {
+	setupTestLogger(t)
+
+	messages := []interface{}{
+		map[string]interface{}{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": "toolu_01",
+					"content":     []interface{}{map[string]interface{}{"type": "text", "text": strings.Repeat("x", 500)}},
+				},
+			},
+		},
+	}
+
+	converted, err := convertAnthropicToBedrockMessages(messages, false, 200, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(converted) != 1 || len(converted[0].Content) != 1 {
+		t.Fatalf("Expected one message with one block, got %+v", converted)
+	}
+
+	textBlock, ok := converted[0].Content[0].(*types.ContentBlockMemberText)
+	if !ok {
+		t.Fatalf("Expected text block, got %T", converted[0].Content[0])
+	}
+	if !strings.Contains(textBlock.Value, "truncated by proxy") || len(textBlock.Value) > 300 {
+		t.Errorf("Expected truncated tool_result, got %d bytes", len(textBlock.Value))
+	}
+}
+
+func TestHandleProxyNonStreamToolsRejected(t *testing.T) {
+	output := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.NonStreamRawHTTP = true
+	client.config.RejectNonStreamTools = true
+
+	payload := map[string]interface{}{
+		"stream":   false,
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+		"tools":    buildTestTools(1, ""),
+	}
+	body, _ := json.Marshal(payload)
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(string(body)))
+
+	if rec.Code != 400 {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "non-streaming tool requests not yet supported") {
+		t.Errorf("Expected clear rejection message, got %s", rec.Body.String())
+	}
+	if !containsEvent(output.String(), EventNonStreamToolsUnsupported) {
+		t.Error("Expected NONSTREAM_TOOLS_UNSUPPORTED warning to be logged")
+	}
+}
+
+func TestHasTools(t *testing.T) {
+	if !hasTools([]byte(`{"tools":[{"name":"read_file"}]}`)) {
+		t.Error("Expected tools to be detected")
+	}
+	if hasTools([]byte(`{"tools":[]}`)) || hasTools([]byte(`{"messages":[]}`)) || hasTools([]byte(`not json`)) {
+		t.Error("Expected no tools to be detected")
+	}
+}
+
+// systemText concatena el texto de los system blocks
+func systemText(blocks []types.SystemContentBlock) string {
+	var sb strings.Builder
+	for _, block := range blocks {
+		if text, ok := block.(*types.SystemContentBlockMemberText); ok {
+			sb.WriteString(text.Value)
+		}
+	}
+	return sb.String()
+}
+
+func TestNativeToolModelsListedModelUsesToolConfig(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.NativeToolModels = []string{"anthropic.claude-sonnet-4-5"}
+
+	payload := map[string]interface{}{
+		"system":   "You are helpful.",
+		"tools":    buildTestTools(2, "schema"),
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+	}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "arn:aws:bedrock:eu-west-1:123456789012:inference-profile/eu.anthropic.claude-sonnet-4-5-20250929-v1:0", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if input.ToolConfig == nil || len(input.ToolConfig.Tools) != 2 {
+		t.Fatalf("Expected native ToolConfig with 2 tools, got %+v", input.ToolConfig)
+	}
+	if got := systemText(input.System); got != "You are helpful." {
+		t.Errorf("Expected system prompt without tools injection, got %q", got)
+	}
+}
+
+func TestNativeToolModelsUnlistedModelUsesXML(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.NativeToolModels = []string{"anthropic.claude-sonnet-4-5"}
+
+	payload := map[string]interface{}{
+		"system":   "You are helpful.",
+		"tools":    buildTestTools(2, "schema"),
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+	}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "eu.anthropic.claude-3-5-sonnet-20240620-v1:0", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if input.ToolConfig != nil {
+		t.Error("Expected no ToolConfig for a model not in NATIVE_TOOL_MODELS")
+	}
+	if got := systemText(input.System); !strings.Contains(got, "tool_0") {
+		t.Errorf("Expected tools injected in system prompt, got %q", got)
+	}
+}
+
+func TestConvertMessagesNativeToolBlocks(t *testing.T) {
+	setupTestLogger(t)
+
+	messages := []interface{}{
+		map[string]interface{}{
+			"role": "assistant",
+			"content": []interface{}{
+				map[string]interface{}{"type": "tool_use", "id": "toolu_1", "name": "read_file", "input": map[string]interface{}{"path": "a.go"}},
+			},
+		},
+		map[string]interface{}{
+			"role": "user",
+			"content": []interface{}{
+				map[string]interface{}{"type": "tool_result", "tool_use_id": "toolu_1", "content": "package main", "is_error": true},
+			},
+		},
+	}
+
+	converted, err := convertAnthropicToBedrockMessages(messages, false, 0, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(converted) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(converted))
+	}
+
+	toolUse, ok := converted[0].Content[0].(*types.ContentBlockMemberToolUse)
+	if !ok || *toolUse.Value.ToolUseId != "toolu_1" || *toolUse.Value.Name != "read_file" {
+		t.Errorf("Expected native tool_use block, got %#v", converted[0].Content[0])
+	}
+
+	toolResult, ok := converted[1].Content[0].(*types.ContentBlockMemberToolResult)
+	if !ok || *toolResult.Value.ToolUseId != "toolu_1" || toolResult.Value.Status != types.ToolResultStatusError {
+		t.Errorf("Expected native tool_result block with error status, got %#v", converted[1].Content[0])
+	}
+}
+
+func TestConverseStreamNativeToolUseInputJSONDelta(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.NativeToolModels = []string{"anthropic.claude-sonnet-4-5"}
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Consultando el tiempo"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"contentBlockStart", `{"contentBlockIndex":1,"start":{"toolUse":{"toolUseId":"tooluse_1","name":"get_weather"}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":""}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"{\"city\": \"Ma"}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"drid\", \"units\""}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":": [\"c\\\\n\"]}"}}}`},
+		{"contentBlockStop", `{"contentBlockIndex":1}`},
+		{"contentBlockDelta", `{"contentBlockIndex":2,"delta":{"text":"Listo"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":2}`},
+		{"messageStop", `{"stopReason":"tool_use"}`},
+		{"metadata", `{"usage":{"inputTokens":20,"outputTokens":15,"totalTokens":35},"metrics":{"latencyMs":80}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "tools": [{"name": "get_weather", "input_schema": {"type": "object"}}], "messages": [{"role": "user", "content": "¿Qué tiempo hace?"}]}`))
+
+	var toolStart map[string]interface{}
+	var partialJSON strings.Builder
+	stopped := false
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Type         string                 `json:"type"`
+			Index        int                    `json:"index"`
+			ContentBlock map[string]interface{} `json:"content_block"`
+			Delta        struct {
+				Type        string `json:"type"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("Invalid SSE data %q: %v", data, err)
+		}
+		switch {
+		case event.Type == "content_block_start" && event.Index == 1:
+			toolStart = event.ContentBlock
+		case event.Type == "content_block_delta" && event.Index == 1:
+			if event.Delta.Type != "input_json_delta" {
+				t.Errorf("Expected input_json_delta for the tool_use block, got %q", event.Delta.Type)
+			}
+			partialJSON.WriteString(event.Delta.PartialJSON)
+		case event.Type == "content_block_stop" && event.Index == 1:
+			stopped = true
+		case event.Type == "message_delta" && event.Delta.StopReason != "tool_use":
+			t.Errorf("Expected stop_reason tool_use, got %q", event.Delta.StopReason)
+		}
+	}
+
+	if toolStart["type"] != "tool_use" || toolStart["id"] != "tooluse_1" || toolStart["name"] != "get_weather" {
+		t.Fatalf("Expected tool_use content_block_start at index 1, got %v\n%s", toolStart, rec.Body.String())
+	}
+	if !stopped {
+		t.Error("Expected content_block_stop for the tool_use block")
+	}
+	if !strings.Contains(rec.Body.String(), `"index":2,"delta":{"type":"text_delta","text":"Listo"}`) {
+		t.Error("Expected text after the tool_use block to keep its own index")
+	}
+
+	// Los fragmentos partial_json concatenados deben formar el input completo de la tool
+	var input map[string]interface{}
+	if err := json.Unmarshal([]byte(partialJSON.String()), &input); err != nil {
+		t.Fatalf("Reassembled partial_json is not valid JSON %q: %v", partialJSON.String(), err)
+	}
+	units, _ := input["units"].([]interface{})
+	if input["city"] != "Madrid" || len(units) != 1 || units[0] != "c\\n" {
+		t.Errorf("Unexpected reassembled tool input: %v", input)
+	}
+}
+
+func TestNativeToolsEnabledAppliesToAllModels(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	payload := map[string]interface{}{
+		"tools":    buildTestTools(1, "schema"),
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+	}
+
+	// Sin BEDROCK_NATIVE_TOOLS se mantiene la inyección XML por defecto
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "eu.anthropic.claude-3-5-sonnet-20240620-v1:0", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if input.ToolConfig != nil {
+		t.Fatal("Expected XML tools injection by default")
+	}
+
+	client.config.NativeTools = true
+	input, _, err = client.BuildConverseInput(context.Background(), payload, "eu.anthropic.claude-3-5-sonnet-20240620-v1:0", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if input.ToolConfig == nil || len(input.ToolConfig.Tools) != 1 {
+		t.Errorf("Expected native ToolConfig with BEDROCK_NATIVE_TOOLS, got %+v", input.ToolConfig)
+	}
+}
+
+func TestNativeToolsEnabledStreamsToolUseBlocks(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.NativeTools = true
+	body := newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockStart", `{"contentBlockIndex":0,"start":{"toolUse":{"toolUseId":"tooluse_1","name":"read_file"}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"toolUse":{"input":"{\"path\":"}}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"toolUse":{"input":"\"a.go\"}"}}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"tool_use"}`},
+		{"metadata", `{"usage":{"inputTokens":10,"outputTokens":5,"totalTokens":15},"metrics":{"latencyMs":50}}`},
+	})
+
+	// El request a ConverseStream debe llevar toolConfig
+	var requestBody []byte
+	client.client = bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			requestBody, _ = io.ReadAll(req.Body)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		})},
+	})
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "tools": [{"name": "read_file", "input_schema": {"type": "object"}}], "messages": [{"role": "user", "content": "Lee a.go"}]}`))
+
+	if !strings.Contains(string(requestBody), `"toolConfig"`) {
+		t.Errorf("Expected toolConfig in the ConverseStream request, got %s", requestBody)
+	}
+	output := rec.Body.String()
+	for _, want := range []string{
+		`"content_block":{"id":"tooluse_1","input":{},"name":"read_file","type":"tool_use"}`,
+		`{"type":"input_json_delta","partial_json":"{\"path\":"}`,
+		`{"type":"input_json_delta","partial_json":"\"a.go\"}"}`,
+		`"stop_reason":"tool_use"`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %s in SSE output, got\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "<read_file>") {
+		t.Error("Expected no XML tool markup in native mode")
+	}
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// ClientFilterConfig contiene las listas de User-Agent permitidos y denegados
+// Las entradas se comparan como subcadenas sin distinguir mayúsculas (p.ej. "Cline/")
+type ClientFilterConfig struct {
+	Allowlist []string
+	Denylist  []string
+}
+
+// Enabled indica si hay alguna lista configurada
+func (c ClientFilterConfig) Enabled() bool {
+	return len(c.Allowlist) > 0 || len(c.Denylist) > 0
+}
+
+// IsAllowed decide si un User-Agent puede usar el proxy
+// La denylist tiene prioridad; con allowlist configurada, solo pasan los que coinciden
+func (c ClientFilterConfig) IsAllowed(userAgent string) (bool, string) {
+	ua := strings.ToLower(userAgent)
+
+	for _, pattern := range c.Denylist {
+		if strings.Contains(ua, strings.ToLower(pattern)) {
+			return false, "denylisted"
+		}
+	}
+
+	if len(c.Allowlist) == 0 {
+		return true, "no_allowlist"
+	}
+
+	for _, pattern := range c.Allowlist {
+		if strings.Contains(ua, strings.ToLower(pattern)) {
+			return true, "allowlisted"
+		}
+	}
+
+	return false, "not_allowlisted"
+}
+
+// ClientFilterMiddleware rechaza con 403 los clientes cuyo User-Agent no está permitido
+// Debe ir después del middleware de autenticación para registrar el usuario en la decisión
+func ClientFilterMiddleware(config ClientFilterConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !config.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			userAgent := r.Header.Get("User-Agent")
+			allowed, reason := config.IsAllowed(userAgent)
+
+			fields := map[string]interface{}{
+				"user_agent.original": userAgent,
+				"decision_reason":     reason,
+			}
+			if user, err := auth.GetUserFromContext(ctx); err == nil {
+				fields["user.id"] = user.UserID
+			}
+
+			if !allowed {
+				Logger.WarningContext(ctx, amslog.Event{
+					Name:    EventClientRejected,
+					Message: "Client User-Agent not allowed",
+					Outcome: amslog.OutcomeFailure,
+					Fields:  fields,
+				})
+				writeErrorResponse(w, ErrCodeClientNotAllowed, "Client not allowed")
+				return
+			}
+
+			Logger.DebugContext(ctx, amslog.Event{
+				Name:    EventClientAllowed,
+				Message: "Client User-Agent allowed",
+				Outcome: amslog.OutcomeSuccess,
+				Fields:  fields,
+			})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveWithUserAgent(t *testing.T, config ClientFilterConfig, userAgent string) int {
+	t.Helper()
+	handler := ClientFilterMiddleware(config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := newTestProxyRequest(`{}`)
+	req.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestClientFilterAllowsListedUserAgent(t *testing.T) {
+	setupTestLogger(t)
+	config := ClientFilterConfig{Allowlist: []string{"Cline/", "internal-tool"}}
+
+	if code := serveWithUserAgent(t, config, "Cline/3.2.1 (vscode)"); code != http.StatusOK {
+		t.Errorf("Expected allowlisted client to pass, got %d", code)
+	}
+	if code := serveWithUserAgent(t, config, "INTERNAL-TOOL/1.0"); code != http.StatusOK {
+		t.Errorf("Expected case-insensitive match to pass, got %d", code)
+	}
+}
+
+func TestClientFilterDeniesUnlistedUserAgent(t *testing.T) {
+	output := setupTestLogger(t)
+	config := ClientFilterConfig{Allowlist: []string{"Cline/"}}
+
+	if code := serveWithUserAgent(t, config, "curl/8.0"); code != http.StatusForbidden {
+		t.Errorf("Expected unlisted client to be rejected, got %d", code)
+	}
+	if !containsEvent(output.String(), EventClientRejected) {
+		t.Error("Expected CLIENT_REJECTED decision to be logged")
+	}
+}
+
+func TestClientFilterDenylistTakesPrecedence(t *testing.T) {
+	setupTestLogger(t)
+	config := ClientFilterConfig{
+		Allowlist: []string{"Cline/"},
+		Denylist:  []string{"Cline/1."},
+	}
+
+	if code := serveWithUserAgent(t, config, "Cline/1.0"); code != http.StatusForbidden {
+		t.Errorf("Expected denylisted client to be rejected, got %d", code)
+	}
+	if code := serveWithUserAgent(t, config, "Cline/3.0"); code != http.StatusOK {
+		t.Errorf("Expected allowlisted client to pass, got %d", code)
+	}
+}
+
+func TestClientFilterDisabledByDefault(t *testing.T) {
+	setupTestLogger(t)
+
+	if code := serveWithUserAgent(t, ClientFilterConfig{}, ""); code != http.StatusOK {
+		t.Errorf("Expected filter to be disabled without lists, got %d", code)
+	}
+}
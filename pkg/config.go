@@ -6,8 +6,16 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 	
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/apierror"
+	"bedrock-proxy-test/pkg/auth"
 	"bedrock-proxy-test/pkg/database"
+	"bedrock-proxy-test/pkg/metrics"
+	"bedrock-proxy-test/pkg/quota"
+	"bedrock-proxy-test/pkg/scheduler"
 )
 
 // JWTConfig contiene la configuración JWT
@@ -15,6 +23,10 @@ type JWTConfig struct {
 	SecretKey string
 	Issuer    string
 	Audience  string
+
+	JWKSURL                string        // JWKS del proveedor de identidad (vacío = solo HMAC)
+	JWKSRefreshInterval    time.Duration // Antigüedad máxima de las claves cacheadas
+	JWKSMinRefreshInterval time.Duration // Intervalo mínimo entre descargas por kids desconocidos
 }
 
 // LoadJWTConfigWithEnv carga configuración JWT desde AWS Secrets Manager o variables de entorno
@@ -69,9 +81,12 @@ func LoadJWTConfigWithEnv() (*JWTConfig, error) {
 	}
 	
 	return &JWTConfig{
-		SecretKey: secretKey,
-		Issuer:    getEnvOrDefault("JWT_ISSUER", "identity-manager"),
-		Audience:  getEnvOrDefault("JWT_AUDIENCE", "bedrock-proxy"),
+		SecretKey:              secretKey,
+		Issuer:                 getEnvOrDefault("JWT_ISSUER", "identity-manager"),
+		Audience:               getEnvOrDefault("JWT_AUDIENCE", "bedrock-proxy"),
+		JWKSURL:                os.Getenv("JWT_JWKS_URL"),
+		JWKSRefreshInterval:    time.Duration(getEnvInt("JWT_JWKS_REFRESH_SECONDS", int(auth.DefaultJWKSRefreshInterval/time.Second))) * time.Second,
+		JWKSMinRefreshInterval: time.Duration(getEnvInt("JWT_JWKS_MIN_REFRESH_SECONDS", int(auth.DefaultJWKSMinRefreshInterval/time.Second))) * time.Second,
 	}, nil
 }
 
@@ -102,6 +117,286 @@ func LoadXMLBufferConfigWithEnv() *XMLBufferConfig {
 	}
 }
 
+// LoadAdminGroupsWithEnv carga los grupos IAM con acceso a los endpoints de administración
+// ADMIN_GROUPS es una lista separada por comas (por defecto "admin")
+func LoadAdminGroupsWithEnv() []string {
+	return splitCommaList(getEnvOrDefault("ADMIN_GROUPS", "admin"))
+}
+
+// LoadClientFilterConfigWithEnv carga las listas de User-Agent desde variables de entorno
+// USER_AGENT_ALLOWLIST y USER_AGENT_DENYLIST son listas separadas por comas (vacías = filtro desactivado)
+func LoadClientFilterConfigWithEnv() ClientFilterConfig {
+	return ClientFilterConfig{
+		Allowlist: splitCommaList(os.Getenv("USER_AGENT_ALLOWLIST")),
+		Denylist:  splitCommaList(os.Getenv("USER_AGENT_DENYLIST")),
+	}
+}
+
+// splitCommaList separa una lista por comas descartando entradas vacías
+func splitCommaList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// LoadCostFormatConfigWithEnv carga el formato de costes mostrados a usuarios desde variables de entorno
+// Los logs internos siguen usando metrics.FormatCost
+func LoadCostFormatConfigWithEnv() metrics.CostFormatOptions {
+	opts := metrics.CostFormatOptions{
+		DecimalPlaces: 2,
+		CurrencyLabel: getEnvOrDefault("COST_DISPLAY_CURRENCY", "$"),
+		LabelAsSuffix: os.Getenv("COST_DISPLAY_CURRENCY_SUFFIX") == "true",
+	}
+	if decimalsStr := os.Getenv("COST_DISPLAY_DECIMALS"); decimalsStr != "" {
+		if decimals, err := strconv.Atoi(decimalsStr); err == nil && decimals >= 0 {
+			opts.DecimalPlaces = decimals
+		}
+	}
+
+	return opts
+}
+
+// LoadQuotaResetConfigWithEnv carga la hora y zona horaria del reset de cuotas desde variables de entorno
+func LoadQuotaResetConfigWithEnv() quota.ResetConfig {
+	config := quota.DefaultResetConfig()
+	if tz := os.Getenv("QUOTA_RESET_TIMEZONE"); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			config.Location = loc
+		}
+	}
+	if hourStr := os.Getenv("QUOTA_RESET_HOUR"); hourStr != "" {
+		if hour, err := strconv.Atoi(hourStr); err == nil && hour >= 0 && hour <= 23 {
+			config.Hour = hour
+		}
+	}
+
+	return config
+}
+
+// LoadQuotaBypassConfigWithEnv carga los usuarios y equipos exentos de cuotas (por defecto ninguno)
+func LoadQuotaBypassConfigWithEnv() quota.BypassConfig {
+	return quota.BypassConfig{
+		UserIDs: splitCommaList(os.Getenv("QUOTA_BYPASS_USER_IDS")),
+		Teams:   splitCommaList(os.Getenv("QUOTA_BYPASS_TEAMS")),
+	}
+}
+
+// LoadQuotaZeroLimitConfigWithEnv carga cómo se interpreta el límite diario a 0/NULL de un usuario.
+// QUOTA_ZERO_LIMIT_POLICY=unlimited (por defecto) o default (usa QUOTA_DEFAULT_DAILY_REQUESTS)
+func LoadQuotaZeroLimitConfigWithEnv() quota.ZeroLimitConfig {
+	config := quota.ZeroLimitConfig{Policy: quota.ParseZeroLimitPolicy(os.Getenv("QUOTA_ZERO_LIMIT_POLICY"))}
+	if value, err := strconv.Atoi(os.Getenv("QUOTA_DEFAULT_DAILY_REQUESTS")); err == nil && value > 0 {
+		config.DailyRequestLimit = value
+	}
+	return config
+}
+
+// LoadDuplicateCredentialsModeWithEnv carga qué hacer si Authorization y x-api-key traen tokens distintos
+// AUTH_DUPLICATE_CREDENTIALS=warn (por defecto) usa Authorization y avisa; reject responde 401
+func LoadDuplicateCredentialsModeWithEnv() auth.DuplicateCredentialsMode {
+	return auth.ParseDuplicateCredentialsMode(os.Getenv("AUTH_DUPLICATE_CREDENTIALS"))
+}
+
+// LoadMissingClaimsConfigWithEnv carga el tratamiento de tokens sin claims team/person
+// AUTH_DEFAULT_TEAM/AUTH_DEFAULT_PERSON ("unassigned" por defecto); AUTH_REQUIRE_TEAM=true rechaza con 403
+func LoadMissingClaimsConfigWithEnv() auth.MissingClaimsConfig {
+	return auth.MissingClaimsConfig{
+		DefaultTeam:   getEnvOrDefault("AUTH_DEFAULT_TEAM", auth.DefaultUnassignedTeam),
+		DefaultPerson: getEnvOrDefault("AUTH_DEFAULT_PERSON", auth.DefaultUnassignedTeam),
+		RequireTeam:   os.Getenv("AUTH_REQUIRE_TEAM") == "true",
+	}
+}
+
+// LoadRateLimitBackendPolicyWithEnv carga la política ante errores del backend compartido del rate limiter
+// RATE_LIMIT_BACKEND_FAILURE_POLICY=fail-open (por defecto, prioriza disponibilidad) o fail-closed (503)
+func LoadRateLimitBackendPolicyWithEnv() auth.BackendFailurePolicy {
+	return auth.ParseBackendFailurePolicy(os.Getenv("RATE_LIMIT_BACKEND_FAILURE_POLICY"))
+}
+
+// LoadTokenPropagationGraceWithEnv carga la ventana tras la emisión en la que un token válido que aún
+// no está en BD se acepta. TOKEN_DB_GRACE_SECONDS=0 (por defecto) exige que exista (estricto)
+func LoadTokenPropagationGraceWithEnv() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("TOKEN_DB_GRACE_SECONDS")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// PricingConfig configura el pricing de los application inference profiles que no están en la tabla
+type PricingConfig struct {
+	ProfileModels      map[string]string // ARN -> model_id base cuyo pricing se aplica
+	DefaultModel       string            // Modelo cuyo pricing se aplica si no se resuelve el del ARN ("" = error)
+	InputIncludesCache []string          // Modelos cuyo input reportado ya incluye los tokens de caché
+}
+
+// LoadPricingConfigWithEnv carga PRICING_PROFILE_MODELS ("arn=model_id,..."), PRICING_DEFAULT_MODEL y
+// PRICING_INPUT_INCLUDES_CACHE (model_id o ARN separados por coma; vacío = modelo de Anthropic para todos)
+func LoadPricingConfigWithEnv() PricingConfig {
+	return PricingConfig{
+		ProfileModels:      ParseMappingsFromStr(os.Getenv("PRICING_PROFILE_MODELS")),
+		DefaultModel:       os.Getenv("PRICING_DEFAULT_MODEL"),
+		InputIncludesCache: splitCommaList(os.Getenv("PRICING_INPUT_INCLUDES_CACHE")),
+	}
+}
+
+// LoadErrorTypesByStatusWithEnv carga el tipo de error Anthropic forzado por status HTTP
+// ERROR_TYPE_BY_STATUS="status=tipo,..." (p.ej. 503=api_error); vacío usa los tipos de la API de Anthropic
+func LoadErrorTypesByStatusWithEnv() map[int]string {
+	return apierror.ParseStatusTypes(ParseMappingsFromStr(os.Getenv("ERROR_TYPE_BY_STATUS")))
+}
+
+// LoadQuotaWebhookConfigWithEnv carga el webhook de bloqueos y umbrales de cuota
+// QUOTA_WEBHOOK_URL vacío (por defecto) lo desactiva; QUOTA_WEBHOOK_THRESHOLDS son porcentajes separados por coma
+func LoadQuotaWebhookConfigWithEnv() auth.QuotaWebhookConfig {
+	config := auth.QuotaWebhookConfig{
+		URL:     os.Getenv("QUOTA_WEBHOOK_URL"),
+		Timeout: auth.DefaultQuotaWebhookTimeout,
+		Retries: auth.DefaultQuotaWebhookRetries,
+	}
+	if seconds, err := strconv.Atoi(os.Getenv("QUOTA_WEBHOOK_TIMEOUT_SECONDS")); err == nil && seconds > 0 {
+		config.Timeout = time.Duration(seconds) * time.Second
+	}
+	if retries, err := strconv.Atoi(os.Getenv("QUOTA_WEBHOOK_RETRIES")); err == nil && retries >= 0 {
+		config.Retries = retries
+	}
+	for _, value := range splitCommaList(os.Getenv("QUOTA_WEBHOOK_THRESHOLDS")) {
+		if threshold, err := strconv.Atoi(value); err == nil && threshold > 0 && threshold <= 100 {
+			config.Thresholds = append(config.Thresholds, threshold)
+		}
+	}
+	return config
+}
+
+// LoadQuotaGraceConfigWithEnv carga el modo de gracia de cuota para conversaciones en curso
+// GRACE_TURNS=0 (por defecto) lo desactiva
+func LoadQuotaGraceConfigWithEnv() auth.QuotaGraceConfig {
+	config := auth.QuotaGraceConfig{ActiveWindow: auth.DefaultQuotaGraceWindow}
+	if turnsStr := os.Getenv("GRACE_TURNS"); turnsStr != "" {
+		if turns, err := strconv.Atoi(turnsStr); err == nil && turns >= 0 {
+			config.Turns = turns
+		}
+	}
+	if windowStr := os.Getenv("GRACE_WINDOW_MINUTES"); windowStr != "" {
+		if minutes, err := strconv.Atoi(windowStr); err == nil && minutes > 0 {
+			config.ActiveWindow = time.Duration(minutes) * time.Minute
+		}
+	}
+	return config
+}
+
+// LoadMetricsWorkerConfigWithEnv carga la configuración del MetricsWorker
+// METRICS_BUFFER_SIZE, METRICS_BATCH_SIZE y METRICS_FLUSH_INTERVAL ("5s" o segundos): buffer, tamaño de
+// batch y ventana de volcado; los valores inválidos conservan los de DefaultConfig
+// METRICS_INSERT_PARALLELISM: inserts concurrentes por batch (se limita a una fracción del pool de BD)
+func LoadMetricsWorkerConfigWithEnv() metrics.Config {
+	config := metrics.DefaultConfig()
+	config.BufferSize = metricsWorkerIntEnv("METRICS_BUFFER_SIZE", config.BufferSize)
+	config.BatchSize = metricsWorkerIntEnv("METRICS_BATCH_SIZE", config.BatchSize)
+	if intervalStr := os.Getenv("METRICS_FLUSH_INTERVAL"); intervalStr != "" {
+		if seconds, err := strconv.Atoi(intervalStr); err == nil && seconds > 0 {
+			config.FlushInterval = time.Duration(seconds) * time.Second
+		} else if interval, err := time.ParseDuration(intervalStr); err == nil && interval > 0 {
+			config.FlushInterval = interval
+		} else {
+			logInvalidMetricsWorkerConfig("METRICS_FLUSH_INTERVAL", intervalStr)
+		}
+	}
+	// Un batch mayor que el buffer no se llenaría nunca: solo se volcaría por intervalo
+	if config.BatchSize > config.BufferSize {
+		logInvalidMetricsWorkerConfig("METRICS_BATCH_SIZE", strconv.Itoa(config.BatchSize))
+		config.BatchSize = config.BufferSize
+	}
+	if parallelismStr := os.Getenv("METRICS_INSERT_PARALLELISM"); parallelismStr != "" {
+		if parallelism, err := strconv.Atoi(parallelismStr); err == nil && parallelism > 0 {
+			config.InsertParallelism = parallelism
+		}
+	}
+	return config
+}
+
+// metricsWorkerIntEnv lee un entero positivo de envVar; vacío o inválido conserva fallback
+func metricsWorkerIntEnv(envVar string, fallback int) int {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		logInvalidMetricsWorkerConfig(envVar, value)
+		return fallback
+	}
+	return parsed
+}
+
+// logInvalidMetricsWorkerConfig avisa de una variable del worker de métricas inválida (el logger puede
+// no estar inicializado)
+func logInvalidMetricsWorkerConfig(envVar, value string) {
+	if Logger == nil {
+		return
+	}
+	Logger.Warning(amslog.Event{
+		Name:    "METRICS_WORKER_CONFIG_INVALID",
+		Message: "Invalid metrics worker configuration, using a valid value instead",
+		Fields: map[string]interface{}{
+			"env_var": envVar,
+			"value":   value,
+		},
+	})
+}
+
+// LoadMetricsExportConfigWithEnv carga el export diario de métricas a S3
+// Sin METRICS_EXPORT_BUCKET el export queda desactivado
+func LoadMetricsExportConfigWithEnv() scheduler.MetricsExportConfig {
+	config := scheduler.MetricsExportConfig{
+		Bucket:      os.Getenv("METRICS_EXPORT_BUCKET"),
+		Prefix:      getEnvOrDefault("METRICS_EXPORT_PREFIX", "bedrock-proxy/usage"),
+		Region:      getEnvOrDefault("METRICS_EXPORT_REGION", getEnvOrDefault("AWS_BEDROCK_REGION", "eu-west-1")),
+		Hour:        scheduler.DefaultMetricsExportHour,
+		CatchUpDays: scheduler.DefaultMetricsExportCatchUpDays,
+	}
+	if hourStr := os.Getenv("METRICS_EXPORT_HOUR_UTC"); hourStr != "" {
+		if hour, err := strconv.Atoi(hourStr); err == nil && hour >= 0 && hour <= 23 {
+			config.Hour = hour
+		}
+	}
+	if daysStr := os.Getenv("METRICS_EXPORT_CATCHUP_DAYS"); daysStr != "" {
+		if days, err := strconv.Atoi(daysStr); err == nil && days >= 1 {
+			config.CatchUpDays = days
+		}
+	}
+	return config
+}
+
+// LoadRetentionConfigWithEnv carga la retención de particiones de métricas
+// Sin RETENTION_DAYS la retención queda desactivada; RETENTION_DRY_RUN=true solo registra lo que se eliminaría
+func LoadRetentionConfigWithEnv() scheduler.RetentionConfig {
+	config := scheduler.RetentionConfig{
+		Hour:   scheduler.DefaultRetentionHour,
+		DryRun: strings.EqualFold(os.Getenv("RETENTION_DRY_RUN"), "true"),
+	}
+	if daysStr := os.Getenv("RETENTION_DAYS"); daysStr != "" {
+		if days, err := strconv.Atoi(daysStr); err == nil && days > 0 {
+			config.Days = days
+		}
+	}
+	if hourStr := os.Getenv("RETENTION_HOUR_UTC"); hourStr != "" {
+		if hour, err := strconv.Atoi(hourStr); err == nil && hour >= 0 && hour <= 23 {
+			config.Hour = hour
+		}
+	}
+	return config
+}
+
+// LoadTeamSchemasWithEnv carga los equipos cuyas métricas van a un schema dedicado
+// METRICS_TEAM_SCHEMAS=team=schema,... (vacío = todos los equipos en la tabla compartida)
+func LoadTeamSchemasWithEnv() map[string]string {
+	return ParseMappingsFromStr(os.Getenv("METRICS_TEAM_SCHEMAS"))
+}
+
 // DatabaseConnectionConfig contiene la configuración para conectar a la base de datos
 type DatabaseConnectionConfig struct {
 	UseSecretsManager bool
@@ -109,6 +404,9 @@ type DatabaseConnectionConfig struct {
 	SSLMode           string
 	MaxConns          int32
 	MinConns          int32
+	// Réplica de lectura opcional (mismas credenciales que el primario)
+	ReplicaHost string
+	ReplicaPort int
 	// Legacy: variables de entorno directas
 	Host     string
 	Port     int
@@ -150,6 +448,14 @@ func LoadDatabaseConnectionConfig() *DatabaseConnectionConfig {
 		}
 	}
 	
+	// Réplica de lectura (vacío = todas las consultas al primario)
+	config.ReplicaHost = os.Getenv("DB_REPLICA_HOST")
+	if portStr := os.Getenv("DB_REPLICA_PORT"); portStr != "" {
+		if p, err := strconv.Atoi(portStr); err == nil {
+			config.ReplicaPort = p
+		}
+	}
+	
 	// Legacy: cargar desde variables de entorno si no se usa Secrets Manager
 	if !config.UseSecretsManager {
 		config.Host = os.Getenv("DB_HOST")
@@ -167,6 +473,12 @@ func LoadDatabaseConnectionConfig() *DatabaseConnectionConfig {
 	return config
 }
 
+// Configured indica si hay una BD configurada (DB_SECRET_ARN o alguna de DB_HOST/DB_USER/DB_PASSWORD).
+// Sin BD configurada el proxy arranca en modo legacy; con ella, un fallo de conexión impide arrancar
+func (c *DatabaseConnectionConfig) Configured() bool {
+	return c.UseSecretsManager || c.Host != "" || c.User != "" || c.Password != ""
+}
+
 // LoadDatabaseConfigWithEnv carga la configuración de base de datos desde variables de entorno (LEGACY)
 // Deprecated: Use LoadDatabaseConnectionConfig() instead
 func LoadDatabaseConfigWithEnv() *database.DatabaseConfig {
@@ -213,6 +525,33 @@ func LoadDatabaseConfigWithEnv() *database.DatabaseConfig {
 func InitializeDatabase(ctx context.Context) (*database.Database, error) {
 	config := LoadDatabaseConnectionConfig()
 	
+	db, err := connectPrimaryDatabase(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	
+	// La réplica es opcional: si falla, las lecturas siguen yendo al primario
+	if config.ReplicaHost != "" {
+		if err := db.ConnectReplica(config.ReplicaHost, config.ReplicaPort); err != nil {
+			Logger.Warning(amslog.Event{
+				Name:    "DB_REPLICA_UNAVAILABLE",
+				Message: "Read replica connection failed, using primary for reads",
+				Error: &amslog.ErrorInfo{
+					Type:    "DatabaseError",
+					Message: err.Error(),
+				},
+				Fields: map[string]interface{}{
+					"db.replica_host": config.ReplicaHost,
+				},
+			})
+		}
+	}
+	
+	return db, nil
+}
+
+// connectPrimaryDatabase conecta al primario vía Secrets Manager o variables de entorno
+func connectPrimaryDatabase(ctx context.Context, config *DatabaseConnectionConfig) (*database.Database, error) {
 	if config.UseSecretsManager {
 		return database.NewDatabaseFromSecret(
 			ctx,
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/metrics"
+)
+
+func TestLoadMetricsWorkerConfigDefaults(t *testing.T) {
+	setupTestLogger(t)
+	for _, envVar := range []string{"METRICS_BUFFER_SIZE", "METRICS_BATCH_SIZE", "METRICS_FLUSH_INTERVAL"} {
+		t.Setenv(envVar, "")
+	}
+
+	config := LoadMetricsWorkerConfigWithEnv()
+	defaults := metrics.DefaultConfig()
+	if config.BufferSize != defaults.BufferSize || config.BatchSize != defaults.BatchSize || config.FlushInterval != defaults.FlushInterval {
+		t.Errorf("Expected defaults %+v, got %+v", defaults, config)
+	}
+}
+
+func TestLoadMetricsWorkerConfigOverrides(t *testing.T) {
+	setupTestLogger(t)
+	t.Setenv("METRICS_BUFFER_SIZE", "5000")
+	t.Setenv("METRICS_BATCH_SIZE", "200")
+	t.Setenv("METRICS_FLUSH_INTERVAL", "750ms")
+
+	config := LoadMetricsWorkerConfigWithEnv()
+	if config.BufferSize != 5000 || config.BatchSize != 200 || config.FlushInterval != 750*time.Millisecond {
+		t.Errorf("Expected overrides to apply, got %+v", config)
+	}
+
+	// El intervalo también se admite en segundos
+	t.Setenv("METRICS_FLUSH_INTERVAL", "10")
+	if config := LoadMetricsWorkerConfigWithEnv(); config.FlushInterval != 10*time.Second {
+		t.Errorf("Expected flush interval of 10s, got %v", config.FlushInterval)
+	}
+}
+
+func TestLoadMetricsWorkerConfigRejectsInvalidValues(t *testing.T) {
+	logs := setupTestLogger(t)
+	t.Setenv("METRICS_BUFFER_SIZE", "-1")
+	t.Setenv("METRICS_BATCH_SIZE", "abc")
+	t.Setenv("METRICS_FLUSH_INTERVAL", "0s")
+
+	config := LoadMetricsWorkerConfigWithEnv()
+	defaults := metrics.DefaultConfig()
+	if config.BufferSize != defaults.BufferSize || config.BatchSize != defaults.BatchSize || config.FlushInterval != defaults.FlushInterval {
+		t.Errorf("Expected invalid values to keep defaults %+v, got %+v", defaults, config)
+	}
+	if strings.Count(logs.String(), "METRICS_WORKER_CONFIG_INVALID") != 3 {
+		t.Errorf("Expected one warning per invalid variable, got %s", logs.String())
+	}
+}
+
+func TestLoadMetricsWorkerConfigCapsBatchToBuffer(t *testing.T) {
+	setupTestLogger(t)
+	t.Setenv("METRICS_BUFFER_SIZE", "100")
+	t.Setenv("METRICS_BATCH_SIZE", "500")
+	t.Setenv("METRICS_FLUSH_INTERVAL", "")
+
+	if config := LoadMetricsWorkerConfigWithEnv(); config.BatchSize != 100 {
+		t.Errorf("Expected batch size capped to buffer size 100, got %d", config.BatchSize)
+	}
+}
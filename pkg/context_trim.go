@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"context"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// contextTrimResult resume el recorte del historial de una request
+type contextTrimResult struct {
+	DroppedMessages int // Mensajes más antiguos eliminados
+	TokensBefore    int // Tokens de input estimados antes del recorte
+	TokensAfter     int // Tokens de input estimados tras el recorte
+	FitsBudget      bool
+}
+
+// trimConversation elimina los mensajes más antiguos hasta que el input estimado (system + mensajes,
+// con CharsPerTokenEstimate) cabe en maxTokens. El system y el último mensaje (el turno actual del
+// usuario) se conservan siempre. Solo se corta delante de un mensaje de usuario sin tool_result, de
+// modo que cada tool_result conserva su tool_use (aunque el último turno sea un tool_result) y la
+// conversación empieza por un mensaje de usuario, como exige Bedrock. Si no hay un corte válido que
+// quepa, se devuelve el menor recorte posible con FitsBudget=false. maxTokens <= 0 desactiva el recorte
+func trimConversation(systemBlocks []types.SystemContentBlock, messages []types.Message, maxTokens int) ([]types.Message, contextTrimResult) {
+	result := contextTrimResult{TokensBefore: estimateInputTokens(systemBlocks, messages)}
+	result.TokensAfter = result.TokensBefore
+	result.FitsBudget = maxTokens <= 0 || result.TokensBefore <= maxTokens
+	if result.FitsBudget {
+		return messages, result
+	}
+
+	// Caracteres de cada mensaje, calculados una vez para ir restando los eliminados
+	chars := 0
+	visitRequestText(systemBlocks, messages, func(text string) bool {
+		chars += len(text)
+		return true
+	})
+	messageChars := make([]int, len(messages))
+	for i, message := range messages {
+		for _, block := range message.Content {
+			visitContentBlockText(block, func(text string) bool {
+				messageChars[i] += len(text)
+				return true
+			})
+		}
+	}
+	tokens := func() int { return (chars + CharsPerTokenEstimate - 1) / CharsPerTokenEstimate }
+
+	start := 0
+	for tokens() > maxTokens {
+		next := start + 1
+		for next < len(messages) && !isConversationStart(messages[next]) {
+			next++
+		}
+		if next >= len(messages) {
+			break
+		}
+		for ; start < next; start++ {
+			chars -= messageChars[start]
+		}
+	}
+
+	result.DroppedMessages = start
+	result.TokensAfter = tokens()
+	result.FitsBudget = result.TokensAfter <= maxTokens
+	return messages[start:], result
+}
+
+// isConversationStart indica si el mensaje puede abrir la conversación: de usuario y sin tool_result
+// (su tool_use estaría en un mensaje ya eliminado)
+func isConversationStart(message types.Message) bool {
+	if message.Role != types.ConversationRoleUser {
+		return false
+	}
+	for _, block := range message.Content {
+		if _, ok := block.(*types.ContentBlockMemberToolResult); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// trimContext aplica CONTEXT_TRIM_MAX_TOKENS a la conversación y registra lo recortado
+func (this *BedrockClient) trimContext(ctx context.Context, systemBlocks []types.SystemContentBlock, messages []types.Message) []types.Message {
+	if this.config.ContextTrimMaxTokens <= 0 {
+		return messages
+	}
+
+	trimmed, result := trimConversation(systemBlocks, messages, this.config.ContextTrimMaxTokens)
+	if result.DroppedMessages == 0 && result.FitsBudget {
+		return trimmed
+	}
+
+	Logger.WarningContext(ctx, amslog.Event{
+		Name:    EventContextTrimmed,
+		Message: "Conversation history trimmed to fit the context budget",
+		Fields: map[string]interface{}{
+			"messages.dropped":   result.DroppedMessages,
+			"messages.kept":      len(trimmed),
+			"tokens.before":      result.TokensBefore,
+			"tokens.after":       result.TokensAfter,
+			"tokens.budget":      this.config.ContextTrimMaxTokens,
+			"tokens.fits_budget": result.FitsBudget,
+		},
+	})
+	return trimmed
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func newTextMessage(role types.ConversationRole, text string) types.Message {
+	return types.Message{Role: role, Content: []types.ContentBlock{&types.ContentBlockMemberText{Value: text}}}
+}
+
+func TestTrimConversationFitsBudget(t *testing.T) {
+	system := []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: strings.Repeat("s", 40)}}
+	messages := []types.Message{
+		newTextMessage(types.ConversationRoleUser, strings.Repeat("a", 400)),
+		newTextMessage(types.ConversationRoleAssistant, strings.Repeat("b", 400)),
+		newTextMessage(types.ConversationRoleUser, strings.Repeat("c", 400)),
+		newTextMessage(types.ConversationRoleAssistant, strings.Repeat("d", 400)),
+		newTextMessage(types.ConversationRoleUser, "último turno"),
+	}
+
+	// 10 tokens de system + 100 por mensaje: con 250 solo caben los dos últimos turnos completos
+	trimmed, result := trimConversation(system, messages, 250)
+	if !result.FitsBudget || result.TokensAfter > 250 {
+		t.Fatalf("Expected trimmed conversation to fit, got %+v", result)
+	}
+	if result.DroppedMessages != 2 || len(trimmed) != 3 {
+		t.Fatalf("Expected 2 oldest messages dropped, got %+v (%d kept)", result, len(trimmed))
+	}
+	if trimmed[0].Role != types.ConversationRoleUser {
+		t.Errorf("Expected trimmed conversation to start with a user message, got %s", trimmed[0].Role)
+	}
+	if last := trimmed[len(trimmed)-1].Content[0].(*types.ContentBlockMemberText); last.Value != "último turno" {
+		t.Errorf("Expected latest user turn to be kept, got %q", last.Value)
+	}
+
+	// Dentro del presupuesto no se toca nada
+	if untouched, result := trimConversation(system, messages, 10000); len(untouched) != len(messages) || result.DroppedMessages != 0 {
+		t.Errorf("Expected conversation within budget to be kept, got %d messages", len(untouched))
+	}
+}
+
+// newToolExchange crea el par tool_use (asistente) / tool_result (usuario) con el id dado
+func newToolExchange(id string, resultSize int) (types.Message, types.Message) {
+	toolUse := types.Message{Role: types.ConversationRoleAssistant, Content: []types.ContentBlock{
+		&types.ContentBlockMemberToolUse{Value: types.ToolUseBlock{ToolUseId: aws.String(id), Name: aws.String("read_file")}},
+	}}
+	toolResult := types.Message{Role: types.ConversationRoleUser, Content: []types.ContentBlock{
+		&types.ContentBlockMemberToolResult{Value: types.ToolResultBlock{ToolUseId: aws.String(id), Content: []types.ToolResultContentBlock{
+			&types.ToolResultContentBlockMemberText{Value: strings.Repeat("r", resultSize)},
+		}}},
+	}}
+	return toolUse, toolResult
+}
+
+func TestTrimConversationSkipsOrphanToolResult(t *testing.T) {
+	toolUse, toolResult := newToolExchange("t1", 400)
+	messages := []types.Message{
+		newTextMessage(types.ConversationRoleUser, strings.Repeat("a", 400)),
+		toolUse,
+		toolResult,
+		newTextMessage(types.ConversationRoleAssistant, "ok"),
+		newTextMessage(types.ConversationRoleUser, "siguiente"),
+	}
+
+	// El tool_result sin su tool_use no puede abrir la conversación: se elimina también
+	trimmed, result := trimConversation(nil, messages, 50)
+	if result.DroppedMessages != 4 || len(trimmed) != 1 {
+		t.Fatalf("Expected only the latest user turn to be kept, got %+v (%d kept)", result, len(trimmed))
+	}
+}
+
+func TestTrimConversationKeepsToolExchangeTogether(t *testing.T) {
+	oldUse, oldResult := newToolExchange("t1", 400)
+	currentUse, currentResult := newToolExchange("t2", 40)
+	messages := []types.Message{
+		newTextMessage(types.ConversationRoleUser, strings.Repeat("a", 400)),
+		oldUse,
+		oldResult,
+		newTextMessage(types.ConversationRoleAssistant, "ok"),
+		newTextMessage(types.ConversationRoleUser, "lee main.go"),
+		currentUse,
+		currentResult,
+	}
+
+	// El turno actual es un tool_result: el corte cae dentro del intercambio, pero su tool_use se conserva
+	trimmed, result := trimConversation(nil, messages, 50)
+	if !result.FitsBudget || result.DroppedMessages != 4 || len(trimmed) != 3 {
+		t.Fatalf("Expected the cut before the last plain user turn, got %+v (%d kept)", result, len(trimmed))
+	}
+	if _, ok := trimmed[1].Content[0].(*types.ContentBlockMemberToolUse); !ok {
+		t.Errorf("Expected the tool_use of the current tool_result to be kept, got %+v", trimmed[1])
+	}
+
+	// Sin ningún corte válido que quepa, no se deja un tool_result huérfano
+	trimmed, result = trimConversation(nil, messages[3:], 5)
+	if result.FitsBudget || len(trimmed) != 3 {
+		t.Fatalf("Expected the tool exchange to be kept over the budget, got %+v (%d kept)", result, len(trimmed))
+	}
+	if !isConversationStart(trimmed[0]) {
+		t.Errorf("Expected the conversation to start with a plain user message, got %+v", trimmed[0])
+	}
+}
+
+func TestTrimContextLogsWarning(t *testing.T) {
+	logs := setupTestLogger(t)
+	client := newTestBedrockClient()
+	client.config.ContextTrimMaxTokens = 50
+
+	messages := []types.Message{
+		newTextMessage(types.ConversationRoleUser, strings.Repeat("a", 400)),
+		newTextMessage(types.ConversationRoleAssistant, strings.Repeat("b", 400)),
+		newTextMessage(types.ConversationRoleUser, "hola"),
+	}
+	trimmed := client.trimContext(context.Background(), nil, messages)
+	if len(trimmed) != 1 {
+		t.Fatalf("Expected 1 message after trimming, got %d", len(trimmed))
+	}
+	if !containsEvent(logs.String(), EventContextTrimmed) || !strings.Contains(logs.String(), `"messages.dropped":2`) {
+		t.Errorf("Expected %s event with dropped messages, got %s", EventContextTrimmed, logs.String())
+	}
+
+	// Sin CONTEXT_TRIM_MAX_TOKENS no se recorta (opt-in)
+	client.config.ContextTrimMaxTokens = 0
+	if kept := client.trimContext(context.Background(), nil, messages); len(kept) != 3 {
+		t.Errorf("Expected no trimming when disabled, got %d messages", len(kept))
+	}
+}
@@ -0,0 +1,308 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// converseRequest es el input de Converse construido desde un payload Anthropic. Lo usan HandleProxy
+// y /admin/preview, de modo que el preview muestra exactamente lo que se enviaría a Bedrock
+type converseRequest struct {
+	SystemBlocks []types.SystemContentBlock
+	Messages     []types.Message
+	MaxTokens    int32
+	ToolConfig   *types.ToolConfiguration // Tools convertidas (solo se envían a Bedrock en modo nativo)
+	ToolChoice   types.ToolChoice         // tool_choice del cliente (nil si no lo envía)
+	NativeTools  bool
+	Options      converseOptions // Sin TextBuffer, que depende del cliente de la request
+}
+
+// converseRequestError es un error de validación o conversión del payload con el código que se devuelve al cliente
+type converseRequestError struct {
+	Code       ErrorCode
+	Type       string // error.type del log
+	LogMessage string // Mensaje del evento de log
+	Err        error  // Detalle devuelto al cliente
+}
+
+func (e *converseRequestError) Error() string { return e.Err.Error() }
+
+func (e *converseRequestError) Unwrap() error { return e.Err }
+
+// newConverseRequestError crea un error de construcción del input de Converse
+func newConverseRequestError(code ErrorCode, errorType, logMessage string, err error) *converseRequestError {
+	return &converseRequestError{Code: code, Type: errorType, LogMessage: logMessage, Err: err}
+}
+
+// writeConverseRequestError registra y responde un error de buildConverseRequest
+func writeConverseRequestError(ctx context.Context, w http.ResponseWriter, err *converseRequestError) {
+	if errors.Is(err, errCostCeilingExceeded) {
+		writeCostCeilingRejection(ctx, w, err)
+		return
+	}
+	Logger.ErrorContext(ctx, amslog.Event{
+		Name:    EventProxyRequestError,
+		Message: err.LogMessage,
+		Outcome: amslog.OutcomeFailure,
+		Error: &amslog.ErrorInfo{
+			Type:    err.Type,
+			Message: err.Error(),
+			Code:    string(err.Code),
+		},
+	})
+	writeErrorResponse(w, err.Code, err.Error())
+}
+
+// buildConverseRequest convierte un payload Anthropic al input de Converse: tools (XML en el system prompt
+// o nativas), system con la inyección del equipo, max_tokens, tool_choice, messages, recorte del historial,
+// techo de coste y cache points. El formato de las tools se toma del contexto (withToolPromptFormat)
+func (this *BedrockClient) buildConverseRequest(ctx context.Context, payload map[string]interface{}, modelID, team, requestedModel string) (*converseRequest, *converseRequestError) {
+	req := &converseRequest{NativeTools: this.useNativeTools(modelID)}
+
+	// Extraer tools y convertirlas a texto PRIMERO (para añadir al system prompt)
+	var toolsTextForSystemPrompt string
+	if tools, ok := payload["tools"].([]interface{}); ok {
+		// Validar límites de número de tools y tamaño de schemas antes de convertir
+		if err := validateToolLimits(tools, this.config.MaxTools, this.config.MaxToolSchemaBytes); err != nil {
+			return nil, newConverseRequestError(ErrCodeToolLimitsExceeded, "ValidationError", "Tool limits exceeded", err)
+		}
+
+		// Describir las tools en el system prompt con el formato del cliente (solo en modo XML)
+		toolFormat := this.toolPromptFormatFromContext(ctx)
+		if !req.NativeTools {
+			var err error
+			toolsTextForSystemPrompt, err = convertToolsForSystemPrompt(tools, toolFormat)
+			if err != nil {
+				return nil, newConverseRequestError(ErrCodeToolJSONConversionFailed, "ToolConversionError",
+					"Failed to convert tools to JSON", fmt.Errorf("Failed to convert tools to JSON: %w", err))
+			}
+		}
+
+		if toolsTextForSystemPrompt != "" {
+			Logger.InfoContext(ctx, amslog.Event{
+				Name:    "BEDROCK_TOOLS_TO_JSON",
+				Message: "Tools converted to JSON for system prompt",
+				Fields: map[string]interface{}{
+					"tools_count":  len(tools),
+					"tools_format": string(toolFormat),
+					"json_length":  len(toolsTextForSystemPrompt),
+				},
+			})
+		}
+
+		// También convertir a ToolConfiguration (solo se envía a Bedrock en modo nativo)
+		toolConfig, err := convertAnthropicToolsToBedrock(ctx, tools)
+		if err != nil {
+			return nil, newConverseRequestError(ErrCodeToolConversionFailed, "ToolConversionError",
+				"Failed to convert tools", fmt.Errorf("Failed to convert tools: %w", err))
+		}
+		req.ToolConfig = toolConfig
+	}
+
+	// Validar número de bloques, tamaño y cache points del system antes de convertir
+	if err := validateSystemLimits(payload["system"], this.config.MaxSystemBlocks, this.config.MaxSystemBytes, this.config.ForcePromptCaching); err != nil {
+		return nil, newConverseRequestError(ErrCodeSystemLimitsExceeded, "ValidationError", "System limits exceeded", err)
+	}
+
+	// Extraer system blocks (puede ser string o array de bloques)
+	if sys, ok := payload["system"].(string); ok {
+		// System es un string simple (legacy) - añadir tools al final
+		req.SystemBlocks = []types.SystemContentBlock{
+			&types.SystemContentBlockMemberText{
+				Value: sys + toolsTextForSystemPrompt,
+			},
+		}
+		Logger.DebugContext(ctx, amslog.Event{
+			Name:    "BEDROCK_PARSE_SYSTEM",
+			Message: "Using legacy string format for system",
+			Fields: map[string]interface{}{
+				"tools_added": toolsTextForSystemPrompt != "",
+			},
+		})
+	} else if sysArray, ok := payload["system"].([]interface{}); ok {
+		// System es un array de bloques (con posible cache_control)
+		req.SystemBlocks = convertSystemBlocksWithCache(sysArray, this.config.ForcePromptCaching)
+
+		// Añadir tools al final del system prompt si existen
+		if toolsTextForSystemPrompt != "" {
+			req.SystemBlocks = append(req.SystemBlocks, &types.SystemContentBlockMemberText{
+				Value: toolsTextForSystemPrompt,
+			})
+		}
+
+		Logger.InfoContext(ctx, amslog.Event{
+			Name:    "BEDROCK_PARSE_SYSTEM",
+			Message: "Converted system blocks with cache support",
+			Fields: map[string]interface{}{
+				"system_blocks_count":  len(req.SystemBlocks),
+				"tools_added":          toolsTextForSystemPrompt != "",
+				"force_prompt_caching": this.config.ForcePromptCaching,
+			},
+		})
+	}
+
+	// Prefijo/sufijo obligatorio del equipo (tras las tools, para que el sufijo quede siempre al final)
+	if injection, ok := this.systemPromptInjection(team); ok {
+		req.SystemBlocks = applySystemPromptInjection(req.SystemBlocks, injection)
+		Logger.DebugContext(ctx, amslog.Event{
+			Name:    "BEDROCK_SYSTEM_PROMPT_INJECTED",
+			Message: "Team system prompt prefix/suffix injected",
+			Fields: map[string]interface{}{
+				"user.team":  team,
+				"has_prefix": injection.Prefix != "",
+				"has_suffix": injection.Suffix != "",
+			},
+		})
+	}
+
+	// LOG DETALLADO: Volcar el contenido completo de los system blocks
+	for i, block := range req.SystemBlocks {
+		if textBlock, ok := block.(*types.SystemContentBlockMemberText); ok {
+			preview := textBlock.Value
+			if len(preview) > 500 {
+				preview = preview[:500] + "... (truncated)"
+			}
+			Logger.InfoContext(ctx, amslog.Event{
+				Name:    "BEDROCK_SYSTEM_BLOCK_CONTENT",
+				Message: fmt.Sprintf("System block %d content", i),
+				Fields: map[string]interface{}{
+					"block_index":     i,
+					"content_length":  len(textBlock.Value),
+					"content_preview": preview,
+					"full_content":    textBlock.Value, // Contenido completo para debugging
+				},
+			})
+		} else if _, ok := block.(*types.SystemContentBlockMemberCachePoint); ok {
+			Logger.InfoContext(ctx, amslog.Event{
+				Name:    "BEDROCK_SYSTEM_BLOCK_CONTENT",
+				Message: fmt.Sprintf("System block %d is cache point", i),
+				Fields: map[string]interface{}{
+					"block_index": i,
+					"block_type":  "cache_point",
+				},
+			})
+		}
+	}
+
+	// Extraer max_tokens (prioridad: config > payload > default del modelo > default global)
+	req.MaxTokens = this.defaultMaxTokens(modelID)
+	if this.config.MaxTokens > 0 {
+		req.MaxTokens = int32(this.config.MaxTokens)
+		Logger.DebugContext(ctx, amslog.Event{
+			Name:    "BEDROCK_CONFIG_MAX_TOKENS",
+			Message: "Using max_tokens from config",
+			Fields: map[string]interface{}{
+				"max_tokens": req.MaxTokens,
+			},
+		})
+	} else if mt, ok := payload["max_tokens"].(float64); ok {
+		req.MaxTokens = int32(mt)
+		Logger.DebugContext(ctx, amslog.Event{
+			Name:    "BEDROCK_REQUEST_MAX_TOKENS",
+			Message: "Using max_tokens from request",
+			Fields: map[string]interface{}{
+				"max_tokens": req.MaxTokens,
+			},
+		})
+	}
+
+	// Respetar SIEMPRE el tool_choice que envía el cliente (principio de transparencia)
+	if tc, ok := payload["tool_choice"]; ok {
+		req.ToolChoice = convertAnthropicToolChoiceToBedrock(tc)
+		if req.ToolChoice != nil && req.ToolConfig != nil {
+			// Aplicar el ToolChoice del cliente al ToolConfiguration
+			req.ToolConfig.ToolChoice = req.ToolChoice
+			if this.config.DEBUG {
+				Logger.DebugContext(ctx, amslog.Event{
+					Name:    "BEDROCK_TOOL_CHOICE_APPLIED",
+					Message: "Tool choice from client applied to ToolConfiguration",
+				})
+			}
+		}
+	} else if req.ToolConfig != nil {
+		// Si no se especifica, usar 'auto' por defecto
+		req.ToolConfig.ToolChoice = &types.ToolChoiceMemberAuto{
+			Value: types.AutoToolChoice{},
+		}
+	}
+
+	// top_k (se envía vía AdditionalModelRequestFields)
+	topK, err := extractTopK(payload)
+	if err != nil {
+		return nil, newConverseRequestError(ErrCodeInvalidTopK, "ValidationError", "Invalid top_k", err)
+	}
+
+	// Modo de latencia (optimized solo si el modelo lo admite)
+	latency := resolveLatencyMode(payload, this.config.LatencyOptimized)
+	if latency == types.PerformanceConfigLatencyOptimized && !supportsLatencyOptimized(modelID) {
+		Logger.DebugContext(ctx, amslog.Event{
+			Name:    "BEDROCK_LATENCY_OPTIMIZED_UNSUPPORTED",
+			Message: "Latency-optimized inference not supported by model, using standard",
+			Fields: map[string]interface{}{
+				"model_id": modelID,
+			},
+		})
+	}
+
+	// Extraer y convertir messages
+	if messages, ok := payload["messages"].([]interface{}); ok {
+		// Validar límite de mensajes
+		if len(messages) > MaxMessagesPerRequest {
+			return nil, newConverseRequestError(ErrCodeTooManyMessages, "ValidationError", "Too many messages in request",
+				fmt.Errorf("Too many messages: %d (max: %d)", len(messages), MaxMessagesPerRequest))
+		}
+
+		req.Messages, err = convertAnthropicToBedrockMessages(messages, this.config.ForcePromptCaching, this.config.MaxToolResultBytes, req.NativeTools)
+		if err != nil {
+			return nil, newConverseRequestError(ErrCodeMessageConversionFailed, "ConversionError",
+				"Failed to convert messages", fmt.Errorf("Failed to convert messages: %w", err))
+		}
+	}
+
+	// Recorte opt-in del historial más antiguo para no superar la ventana de contexto
+	req.Messages = this.trimContext(ctx, req.SystemBlocks, req.Messages)
+
+	// Techo de coste por request: recortar max_tokens o rechazar si el input estimado ya lo supera
+	req.MaxTokens, err = this.enforceCostCeiling(ctx, modelID, estimateInputTokens(req.SystemBlocks, req.Messages), req.MaxTokens)
+	if err != nil {
+		return nil, newConverseRequestError(ErrCodeCostCeilingExceeded, "ValidationError", "Request rejected by per-request cost ceiling", err)
+	}
+
+	// Cache points forzados por debajo del mínimo cacheable del modelo: se descartan en vez de pagar una
+	// escritura inútil. Solo con ForcePromptCaching (los cache_control del cliente se ignoran y todos los
+	// cache points son del proxy) y antes de prime_cache, para no descartar nunca uno pedido por el cliente
+	if minTokens := this.minCacheTokens(modelID); minTokens > 0 && this.config.ForcePromptCaching {
+		var dropped int
+		req.SystemBlocks, req.Messages, dropped = dropSmallCachePoints(req.SystemBlocks, req.Messages, minTokens)
+		if dropped > 0 {
+			logDroppedCachePoints(ctx, modelID, dropped, minTokens)
+		}
+	}
+
+	// prime_cache: asegurar un cache point tras el system prompt (o el primer mensaje)
+	primeCache := wantsCachePrime(payload)
+	if primeCache {
+		var inserted bool
+		req.SystemBlocks, req.Messages, inserted = primeCachePoint(req.SystemBlocks, req.Messages)
+		Logger.DebugContext(ctx, amslog.Event{
+			Name:    "BEDROCK_CACHE_PRIME",
+			Message: "Cache write requested by client",
+			Fields: map[string]interface{}{
+				"cache_point_inserted": inserted,
+			},
+		})
+	}
+
+	req.Options = converseOptions{Latency: latency, TopK: topK, PrimeCache: primeCache, StopSequences: extractStopSequences(payload)}
+	req.Options.Thinking = this.converseThinking(payload, modelID, requestedModel)
+	if req.NativeTools {
+		req.Options.ToolConfig = req.ToolConfig
+	}
+	return req, nil
+}
@@ -0,0 +1,273 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/metrics"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// CharsPerTokenEstimate es la aproximación de caracteres por token para estimar el input antes de invocar
+const CharsPerTokenEstimate = 4
+
+// errCostCeilingExceeded identifica las requests rechazadas por MAX_REQUEST_COST_USD
+var errCostCeilingExceeded = errors.New("estimated request cost exceeds the per-request ceiling")
+
+// visitRequestText recorre el texto de system y messages ya convertidos (incluidas las tools inyectadas en
+// el system prompt, tool_use y tool_result) hasta que visit devuelve false. Imágenes y documentos se omiten
+func visitRequestText(systemBlocks []types.SystemContentBlock, messages []types.Message, visit func(text string) bool) {
+	for _, block := range systemBlocks {
+		if text, ok := block.(*types.SystemContentBlockMemberText); ok && !visit(text.Value) {
+			return
+		}
+	}
+	for _, message := range messages {
+		for _, block := range message.Content {
+			if !visitContentBlockText(block, visit) {
+				return
+			}
+		}
+	}
+}
+
+// visitContentBlockText recorre el texto de un bloque de mensaje; devuelve false si visit pide parar
+func visitContentBlockText(block types.ContentBlock, visit func(text string) bool) bool {
+	switch b := block.(type) {
+	case *types.ContentBlockMemberText:
+		return visit(b.Value)
+	case *types.ContentBlockMemberToolUse:
+		if b.Value.Name != nil && !visit(*b.Value.Name) {
+			return false
+		}
+		if b.Value.Input != nil {
+			if input, err := b.Value.Input.MarshalSmithyDocument(); err == nil && !visit(string(input)) {
+				return false
+			}
+		}
+	case *types.ContentBlockMemberToolResult:
+		for _, content := range b.Value.Content {
+			switch c := content.(type) {
+			case *types.ToolResultContentBlockMemberText:
+				if !visit(c.Value) {
+					return false
+				}
+			case *types.ToolResultContentBlockMemberJson:
+				if c.Value != nil {
+					if value, err := c.Value.MarshalSmithyDocument(); err == nil && !visit(string(value)) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// estimateInputTokens estima los tokens de input a partir del texto de la request
+func estimateInputTokens(systemBlocks []types.SystemContentBlock, messages []types.Message) int {
+	chars := 0
+	visitRequestText(systemBlocks, messages, func(text string) bool {
+		chars += len(text)
+		return true
+	})
+	return (chars + CharsPerTokenEstimate - 1) / CharsPerTokenEstimate
+}
+
+// visitPayloadText recorre el texto de un payload Anthropic sin convertir (system, messages y tools)
+// hasta que visit devuelve false. Los inputs de tool_use y los schemas se visitan como JSON
+func visitPayloadText(payload map[string]interface{}, visit func(text string) bool) {
+	if !visitAnthropicContent(payload["system"], visit) {
+		return
+	}
+	messages, _ := payload["messages"].([]interface{})
+	for _, message := range messages {
+		if msg, ok := message.(map[string]interface{}); ok && !visitAnthropicContent(msg["content"], visit) {
+			return
+		}
+	}
+	if tools, ok := payload["tools"].([]interface{}); ok && len(tools) > 0 {
+		if raw, err := json.Marshal(tools); err == nil {
+			visit(string(raw))
+		}
+	}
+}
+
+// visitAnthropicContent recorre el texto de un content Anthropic (string o array de bloques)
+func visitAnthropicContent(content interface{}, visit func(text string) bool) bool {
+	switch c := content.(type) {
+	case string:
+		return visit(c)
+	case []interface{}:
+		for _, item := range c {
+			block, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := block["text"].(string); ok && !visit(text) {
+				return false
+			}
+			if name, ok := block["name"].(string); ok && !visit(name) {
+				return false
+			}
+			if input, ok := block["input"]; ok {
+				if raw, err := json.Marshal(input); err == nil && !visit(string(raw)) {
+					return false
+				}
+			}
+			if nested, ok := block["content"]; ok && !visitAnthropicContent(nested, visit) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// estimatePayloadTokens estima los tokens de input de un payload Anthropic sin convertir
+func estimatePayloadTokens(payload map[string]interface{}) int {
+	chars := 0
+	visitPayloadText(payload, func(text string) bool {
+		chars += len(text)
+		return true
+	})
+	return (chars + CharsPerTokenEstimate - 1) / CharsPerTokenEstimate
+}
+
+// costCeilingResult es la decisión del techo de coste para una request
+type costCeilingResult struct {
+	MaxTokens          int32   // max_tokens a enviar (igual al original si no hay que recortar)
+	EstimatedInputCost float64 // Coste estimado del input en USD
+	Clamped            bool    // max_tokens se redujo para no superar el techo
+	Rejected           bool    // El input estimado por sí solo alcanza el techo
+}
+
+// applyCostCeiling limita max_tokens para que input estimado + output máximo no superen ceiling (USD).
+// Si el input estimado ya alcanza el techo, o no queda presupuesto para un solo token de output, se rechaza
+func applyCostCeiling(ceiling float64, pricing metrics.ModelPricing, inputTokens int, maxTokens int32) costCeilingResult {
+	result := costCeilingResult{
+		MaxTokens:          maxTokens,
+		EstimatedInputCost: float64(inputTokens) / 1000.0 * pricing.InputPer1KTokens,
+	}
+	if result.EstimatedInputCost >= ceiling {
+		result.Rejected = true
+		return result
+	}
+	if pricing.OutputPer1KTokens <= 0 {
+		return result
+	}
+
+	allowedOutput := int64((ceiling - result.EstimatedInputCost) / pricing.OutputPer1KTokens * 1000.0)
+	if allowedOutput < 1 {
+		result.Rejected = true
+		return result
+	}
+	if allowedOutput < int64(maxTokens) {
+		result.MaxTokens = int32(allowedOutput)
+		result.Clamped = true
+	}
+	return result
+}
+
+// SetCostFormatOptions establece el formato de los costes mostrados al usuario (COST_DISPLAY_*)
+func (this *BedrockClient) SetCostFormatOptions(opts metrics.CostFormatOptions) {
+	this.costFormat = &opts
+}
+
+// displayCost formatea un coste para el usuario: con COST_DISPLAY_* si está configurado o, si no, en
+// dólares. decimals es la precisión mínima (COST_DISPLAY_DECIMALS no la reduce). Los logs siguen usando metrics.FormatCost
+func (this *BedrockClient) displayCost(cost float64, decimals int) string {
+	opts := metrics.CostFormatOptions{DecimalPlaces: decimals, CurrencyLabel: "$"}
+	if this.costFormat != nil {
+		opts = *this.costFormat
+		opts.DecimalPlaces = max(opts.DecimalPlaces, decimals)
+	}
+	return metrics.FormatCostWithOptions(cost, opts)
+}
+
+// enforceCostCeiling aplica MAX_REQUEST_COST_USD a la request. Devuelve el max_tokens a usar o un error
+// si la request debe rechazarse. Sin precio conocido para el modelo el techo no se puede aplicar
+func (this *BedrockClient) enforceCostCeiling(ctx context.Context, modelID string, inputTokens int, maxTokens int32) (int32, error) {
+	ceiling := this.config.MaxRequestCostUSD
+	if ceiling <= 0 {
+		return maxTokens, nil
+	}
+
+	pricing, err := metrics.ResolvePricing(modelID, this.modelResolver)
+	if err != nil {
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventCostCeilingSkipped,
+			Message: "Cost ceiling not applied: pricing unknown for model",
+			Fields: map[string]interface{}{
+				"model.id":         modelID,
+				"cost.ceiling_usd": ceiling,
+			},
+		})
+		return maxTokens, nil
+	}
+
+	result := applyCostCeiling(ceiling, pricing, inputTokens, maxTokens)
+	if result.Rejected {
+		return maxTokens, fmt.Errorf("%w of %s (estimated input cost %s)", errCostCeilingExceeded,
+			this.displayCost(ceiling, 2), this.displayCost(result.EstimatedInputCost, 4))
+	}
+	if result.Clamped {
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventCostCeilingClamped,
+			Message: "max_tokens reduced to stay under the per-request cost ceiling",
+			Fields: map[string]interface{}{
+				"model.id":                 modelID,
+				"cost.ceiling_usd":         ceiling,
+				"cost.estimated_input_usd": result.EstimatedInputCost,
+				"tokens.estimated_input":   inputTokens,
+				"max_tokens.requested":     maxTokens,
+				"max_tokens.clamped":       result.MaxTokens,
+			},
+		})
+	}
+	return result.MaxTokens, nil
+}
+
+// enforceRawCostCeiling aplica MAX_REQUEST_COST_USD a una request del path HTTP firmado
+// (AWS_BEDROCK_NONSTREAM_RAW_HTTP), estimando el input desde el body original. Devuelve el max_tokens
+// a usar y si se ha recortado (el body debe volver a firmarse con el nuevo valor)
+func (this *BedrockClient) enforceRawCostCeiling(ctx context.Context, modelID string, body []byte) (int32, bool, error) {
+	if this.config.MaxRequestCostUSD <= 0 {
+		return 0, false, nil
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return 0, false, nil
+	}
+
+	// Mismo max_tokens que enviará SignRequest (config > payload > default del modelo)
+	maxTokens := this.defaultMaxTokens(modelID)
+	if this.config.MaxTokens > 0 {
+		maxTokens = int32(this.config.MaxTokens)
+	} else if mt, ok := payload["max_tokens"].(float64); ok {
+		maxTokens = int32(mt)
+	}
+
+	clamped, err := this.enforceCostCeiling(ctx, modelID, estimatePayloadTokens(payload), maxTokens)
+	return clamped, err == nil && clamped != maxTokens, err
+}
+
+// writeCostCeilingRejection registra y responde el rechazo de una request por MAX_REQUEST_COST_USD
+func writeCostCeilingRejection(ctx context.Context, w http.ResponseWriter, err error) {
+	Logger.WarningContext(ctx, amslog.Event{
+		Name:    EventProxyRequestError,
+		Message: "Request rejected by per-request cost ceiling",
+		Outcome: amslog.OutcomeFailure,
+		Error: &amslog.ErrorInfo{
+			Type:    "ValidationError",
+			Message: err.Error(),
+			Code:    string(ErrCodeCostCeilingExceeded),
+		},
+	})
+	writeErrorResponse(w, ErrCodeCostCeilingExceeded, err.Error())
+}
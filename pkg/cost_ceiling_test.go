@@ -0,0 +1,246 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bedrock-proxy-test/pkg/metrics"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func TestApplyCostCeiling(t *testing.T) {
+	pricing := metrics.ModelPricing{InputPer1KTokens: 0.003, OutputPer1KTokens: 0.015}
+
+	// $0.03 de techo, 1000 tokens de input ($0.003): quedan $0.027 = 1800 tokens de output
+	result := applyCostCeiling(0.03, pricing, 1000, 8192)
+	if !result.Clamped || result.Rejected || result.MaxTokens != 1800 {
+		t.Errorf("Expected max_tokens clamped to 1800, got %+v", result)
+	}
+
+	// max_tokens ya dentro del techo: sin cambios
+	if result := applyCostCeiling(0.03, pricing, 1000, 1024); result.Clamped || result.MaxTokens != 1024 {
+		t.Errorf("Expected max_tokens unchanged, got %+v", result)
+	}
+
+	// El input estimado por sí solo supera el techo
+	if result := applyCostCeiling(0.03, pricing, 20000, 1024); !result.Rejected {
+		t.Errorf("Expected rejection when input cost exceeds the ceiling, got %+v", result)
+	}
+}
+
+func TestEstimateInputTokens(t *testing.T) {
+	system := []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: strings.Repeat("a", 400)}}
+	messages := []types.Message{{
+		Role: types.ConversationRoleUser,
+		Content: []types.ContentBlock{
+			&types.ContentBlockMemberText{Value: strings.Repeat("b", 399)},
+			&types.ContentBlockMemberToolResult{Value: types.ToolResultBlock{
+				ToolUseId: aws.String("t1"),
+				Content:   []types.ToolResultContentBlock{&types.ToolResultContentBlockMemberText{Value: strings.Repeat("c", 200)}},
+			}},
+		},
+	}}
+
+	if got := estimateInputTokens(system, messages); got != 250 {
+		t.Errorf("Expected 250 estimated tokens, got %d", got)
+	}
+
+	// El mismo contenido sin convertir (path HTTP firmado) da la misma estimación
+	payload := map[string]interface{}{
+		"system": strings.Repeat("a", 400),
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": strings.Repeat("b", 399)},
+			map[string]interface{}{"type": "tool_result", "tool_use_id": "t1", "content": strings.Repeat("c", 200)},
+		}}},
+	}
+	if got := estimatePayloadTokens(payload); got != 250 {
+		t.Errorf("Expected 250 estimated tokens from the raw payload, got %d", got)
+	}
+}
+
+// newCeilingTestClient crea un cliente cuyo Bedrock stub guarda el body de la request Converse
+func newCeilingTestClient(t *testing.T, requestBody *[]byte) *BedrockClient {
+	body := newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+	})
+
+	client := newTestBedrockClient()
+	client.client = bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			*requestBody, _ = io.ReadAll(req.Body)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		})},
+	})
+	return client
+}
+
+func TestHandleProxyCostCeilingClampsMaxTokens(t *testing.T) {
+	buf := setupTestLogger(t)
+
+	var sent []byte
+	client := newCeilingTestClient(t, &sent)
+	client.config.MaxRequestCostUSD = 0.015
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "max_tokens": 4096, "messages": [{"role": "user", "content": "hola"}]}`))
+
+	var input struct {
+		InferenceConfig struct {
+			MaxTokens int `json:"maxTokens"`
+		} `json:"inferenceConfig"`
+	}
+	if err := json.Unmarshal(sent, &input); err != nil {
+		t.Fatalf("Invalid Converse request: %v (%s)", err, sent)
+	}
+	// hola = 1 token estimado ($0.000003): quedan $0.014997 = 999 tokens de output
+	if input.InferenceConfig.MaxTokens != 999 {
+		t.Errorf("Expected max_tokens clamped to 999, got %d", input.InferenceConfig.MaxTokens)
+	}
+
+	Logger.Close()
+	if !containsEvent(buf.String(), EventCostCeilingClamped) {
+		t.Errorf("Expected %s event, got: %s", EventCostCeilingClamped, buf.String())
+	}
+}
+
+func TestHandleProxyCostCeilingRejectsExpensiveInput(t *testing.T) {
+	setupTestLogger(t)
+
+	var sent []byte
+	client := newCeilingTestClient(t, &sent)
+	client.config.MaxRequestCostUSD = 0.001
+
+	// ~1000 tokens estimados a $3/1M = $0.003 > $0.001
+	prompt := strings.Repeat("x", 4000)
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "`+prompt+`"}]}`))
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), string(ErrCodeCostCeilingExceeded)) {
+		t.Errorf("Expected 400 %s, got %d: %s", ErrCodeCostCeilingExceeded, rec.Code, rec.Body.String())
+	}
+	if sent != nil {
+		t.Error("Expected Bedrock not to be invoked")
+	}
+	if !strings.Contains(rec.Body.String(), "ceiling of $0.00 (estimated input cost $0.0") {
+		t.Errorf("Expected costs in dollars by default, got %s", rec.Body.String())
+	}
+
+	// Con COST_DISPLAY_* el mensaje usa el formato configurado
+	client.SetCostFormatOptions(metrics.CostFormatOptions{DecimalPlaces: 3, CurrencyLabel: "USD", LabelAsSuffix: true})
+	rec = httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "`+prompt+`"}]}`))
+	if !strings.Contains(rec.Body.String(), "ceiling of 0.001 USD") {
+		t.Errorf("Expected configured cost format in the error, got %s", rec.Body.String())
+	}
+
+	// Con la configuración por defecto (COST_DISPLAY_* sin definir) el mensaje no cambia: dólares y 4 decimales
+	for _, envVar := range []string{"COST_DISPLAY_DECIMALS", "COST_DISPLAY_CURRENCY", "COST_DISPLAY_CURRENCY_SUFFIX"} {
+		t.Setenv(envVar, "")
+	}
+	client.SetCostFormatOptions(LoadCostFormatConfigWithEnv())
+	rec = httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "`+prompt+`"}]}`))
+	if !strings.Contains(rec.Body.String(), "ceiling of $0.00 (estimated input cost $0.0030") {
+		t.Errorf("Expected the default cost format to keep dollars and precision, got %s", rec.Body.String())
+	}
+}
+
+func TestHandleProxyRawHTTPCostCeilingClampsMaxTokens(t *testing.T) {
+	setupTestLogger(t)
+
+	var sent []byte
+	client := newTestBedrockClient()
+	client.config.NonStreamRawHTTP = true
+	client.config.MaxRequestCostUSD = 0.015
+	client.httpClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent, _ = io.ReadAll(req.Body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"type":"message","content":[]}`)),
+		}, nil
+	})}
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"max_tokens": 4096, "messages": [{"role": "user", "content": "hola"}]}`))
+
+	var body struct {
+		MaxTokens int `json:"max_tokens"`
+	}
+	if err := json.Unmarshal(sent, &body); err != nil {
+		t.Fatalf("Invalid signed body: %v (%s)", err, sent)
+	}
+	if body.MaxTokens != 999 {
+		t.Errorf("Expected max_tokens clamped to 999 in the signed body, got %d", body.MaxTokens)
+	}
+
+	// Un input que ya supera el techo se rechaza sin invocar Bedrock
+	sent = nil
+	client.config.MaxRequestCostUSD = 0.001
+	rec = httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"messages": [{"role": "user", "content": "`+strings.Repeat("x", 4000)+`"}]}`))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), string(ErrCodeCostCeilingExceeded)) {
+		t.Errorf("Expected 400 %s, got %d: %s", ErrCodeCostCeilingExceeded, rec.Code, rec.Body.String())
+	}
+	if sent != nil {
+		t.Error("Expected Bedrock not to be invoked")
+	}
+}
+
+func TestHandlePreviewAppliesCostCeiling(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.MaxRequestCostUSD = 0.015
+
+	rec := httptest.NewRecorder()
+	client.HandlePreview(rec, newTestProxyRequest(`{"max_tokens": 4096, "messages": [{"role": "user", "content": "hola"}]}`))
+	var preview ConversePreview
+	if err := json.Unmarshal(rec.Body.Bytes(), &preview); err != nil {
+		t.Fatalf("Invalid preview: %v (%s)", err, rec.Body.String())
+	}
+	if got := preview.InferenceConfig["max_tokens"]; got != float64(999) {
+		t.Errorf("Expected the preview to show max_tokens clamped to 999, got %v", got)
+	}
+
+	client.config.MaxRequestCostUSD = 0.001
+	rec = httptest.NewRecorder()
+	client.HandlePreview(rec, newTestProxyRequest(`{"messages": [{"role": "user", "content": "`+strings.Repeat("x", 4000)+`"}]}`))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), string(ErrCodeCostCeilingExceeded)) {
+		t.Errorf("Expected 400 %s, got %d: %s", ErrCodeCostCeilingExceeded, rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleStatsShowsFormattedCostCeiling(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.MaxRequestCostUSD = 1.5
+	client.SetCostFormatOptions(metrics.CostFormatOptions{DecimalPlaces: 2, CurrencyLabel: "EUR", LabelAsSuffix: true})
+
+	rec := httptest.NewRecorder()
+	client.HandleStats(rec, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	var stats map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Invalid stats response: %v (%s)", err, rec.Body.String())
+	}
+	if stats["cost_ceiling"] != "1.50 EUR" {
+		t.Errorf("Expected the cost ceiling in the configured format, got %v", stats["cost_ceiling"])
+	}
+}
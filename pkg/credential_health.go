@@ -0,0 +1,258 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// DefaultCredentialCheckInterval es el intervalo por defecto de la comprobación de credenciales de AWS
+const DefaultCredentialCheckInterval = 5 * time.Minute
+
+// DefaultCredentialCheckTimeout limita cada comprobación para que un endpoint colgado no la bloquee
+const DefaultCredentialCheckTimeout = 10 * time.Second
+
+// DefaultCredentialCheckFailureThreshold son los fallos consecutivos antes de marcar las credenciales como inválidas
+const DefaultCredentialCheckFailureThreshold = 3
+
+// credentialErrorCodes son las respuestas de AWS que indican credenciales revocadas, rotadas o caducadas.
+// Otros fallos de la llamada firmada (red, 5xx, falta de permiso para listar modelos) no las invalidan
+var credentialErrorCodes = []string{
+	"UnrecognizedClientException",
+	"InvalidSignatureException",
+	"SignatureDoesNotMatch",
+	"InvalidClientTokenId",
+	"ExpiredToken",
+	"security token included in the request is invalid",
+}
+
+// CredentialCheckConfig configura la comprobación periódica de las credenciales de AWS
+type CredentialCheckConfig struct {
+	Interval         time.Duration // Intervalo entre comprobaciones (0 = desactivada)
+	SignedCall       bool          // Además de obtener las credenciales, hace una llamada firmada barata (ListFoundationModels)
+	Timeout          time.Duration // Duración máxima de cada comprobación
+	FailureThreshold int           // Fallos consecutivos antes de marcar las credenciales como inválidas
+}
+
+// LoadCredentialCheckConfigWithEnv carga la comprobación de credenciales
+// CREDENTIAL_CHECK_INTERVAL_SECONDS (por defecto 300; 0 la desactiva), CREDENTIAL_CHECK_SIGNED_CALL
+// (por defecto true; false solo comprueba que se pueden obtener), CREDENTIAL_CHECK_TIMEOUT_SECONDS
+// (por defecto 10) y CREDENTIAL_CHECK_FAILURE_THRESHOLD (por defecto 3)
+func LoadCredentialCheckConfigWithEnv() CredentialCheckConfig {
+	config := CredentialCheckConfig{
+		Interval:         DefaultCredentialCheckInterval,
+		SignedCall:       os.Getenv("CREDENTIAL_CHECK_SIGNED_CALL") != "false",
+		Timeout:          DefaultCredentialCheckTimeout,
+		FailureThreshold: DefaultCredentialCheckFailureThreshold,
+	}
+	if intervalStr := os.Getenv("CREDENTIAL_CHECK_INTERVAL_SECONDS"); intervalStr != "" {
+		if seconds, err := strconv.Atoi(intervalStr); err == nil && seconds >= 0 {
+			config.Interval = time.Duration(seconds) * time.Second
+		}
+	}
+	if timeoutStr := os.Getenv("CREDENTIAL_CHECK_TIMEOUT_SECONDS"); timeoutStr != "" {
+		if seconds, err := strconv.Atoi(timeoutStr); err == nil && seconds > 0 {
+			config.Timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if thresholdStr := os.Getenv("CREDENTIAL_CHECK_FAILURE_THRESHOLD"); thresholdStr != "" {
+		if threshold, err := strconv.Atoi(thresholdStr); err == nil && threshold > 0 {
+			config.FailureThreshold = threshold
+		}
+	}
+	return config
+}
+
+// credentialHealth guarda el resultado de la última comprobación de credenciales
+type credentialHealth struct {
+	mu        sync.RWMutex
+	invalid   bool
+	failures  int // Fallos consecutivos
+	lastError string
+	checkedAt time.Time
+}
+
+// CredentialStatus es el estado de las credenciales expuesto en /admin/stats
+type CredentialStatus struct {
+	Valid     bool      `json:"valid"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// isCredentialError indica si el error de la llamada firmada se debe a las credenciales
+func isCredentialError(err error) bool {
+	message := err.Error()
+	for _, code := range credentialErrorCodes {
+		if strings.Contains(message, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCredentials comprueba, con el timeout configurado, que las credenciales se pueden obtener y, con
+// SignedCall, que AWS acepta una llamada firmada con ellas. Registra el resultado y lo devuelve; las
+// credenciales solo se marcan inválidas (CREDENTIALS_INVALID) tras FailureThreshold fallos consecutivos
+func (this *BedrockClient) CheckCredentials(ctx context.Context, config CredentialCheckConfig) error {
+	if this.credentials == nil {
+		return nil
+	}
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	var err error
+	if _, err = this.credentials.Retrieve(ctx); err == nil && config.SignedCall {
+		if probeErr := this.signedCredentialCheck(ctx); probeErr != nil {
+			if isCredentialError(probeErr) {
+				err = probeErr
+			} else {
+				// La llamada falló por otro motivo: no dice nada de las credenciales
+				Logger.WarningContext(ctx, amslog.Event{
+					Name:    EventCredentialsCheck,
+					Message: "Signed credentials check inconclusive",
+					Error: &amslog.ErrorInfo{
+						Type:    "CredentialsCheckError",
+						Message: probeErr.Error(),
+					},
+				})
+			}
+		}
+	}
+	this.recordCredentialCheck(ctx, err, config.FailureThreshold)
+	return err
+}
+
+// signedCredentialCheck hace la llamada firmada de la comprobación (ListFoundationModels)
+func (this *BedrockClient) signedCredentialCheck(ctx context.Context) error {
+	if this.credentialProbe != nil {
+		return this.credentialProbe(ctx)
+	}
+	_, err := this.listFoundationModels(ctx)
+	return err
+}
+
+// recordCredentialCheck actualiza el estado de las credenciales y emite los eventos de fallo y recuperación.
+// Un fallo aislado (p.ej. un corte breve del endpoint de STS) solo se avisa hasta alcanzar threshold
+func (this *BedrockClient) recordCredentialCheck(ctx context.Context, err error, threshold int) {
+	this.credentialHealth.mu.Lock()
+	wasInvalid := this.credentialHealth.invalid
+	if err != nil {
+		this.credentialHealth.failures++
+	} else {
+		this.credentialHealth.failures = 0
+	}
+	failures := this.credentialHealth.failures
+	this.credentialHealth.invalid = err != nil && failures >= max(threshold, 1)
+	this.credentialHealth.checkedAt = time.Now()
+	this.credentialHealth.lastError = ""
+	if err != nil {
+		this.credentialHealth.lastError = err.Error()
+	}
+	invalid := this.credentialHealth.invalid
+	this.credentialHealth.mu.Unlock()
+
+	if err != nil && !invalid {
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventCredentialsCheck,
+			Message: "AWS credentials check failed, not yet marked invalid",
+			Error: &amslog.ErrorInfo{
+				Type:    "CredentialsError",
+				Message: err.Error(),
+			},
+			Fields: map[string]interface{}{
+				"credentials.consecutive_failures": failures,
+				"credentials.failure_threshold":    threshold,
+			},
+		})
+		return
+	}
+	if err != nil {
+		Logger.ErrorContext(ctx, amslog.Event{
+			Name:    EventCredentialsInvalid,
+			Message: "AWS credentials check failed",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    "CredentialsError",
+				Message: err.Error(),
+				Code:    EventCredentialsInvalid,
+			},
+		})
+		return
+	}
+	if wasInvalid {
+		Logger.InfoContext(ctx, amslog.Event{
+			Name:    EventCredentialsValid,
+			Message: "AWS credentials valid again",
+			Outcome: amslog.OutcomeSuccess,
+		})
+	}
+}
+
+// CredentialsHealthy indica si la última comprobación de credenciales fue correcta (sin comprobar aún = sanas)
+func (this *BedrockClient) CredentialsHealthy() bool {
+	this.credentialHealth.mu.RLock()
+	defer this.credentialHealth.mu.RUnlock()
+	return !this.credentialHealth.invalid
+}
+
+// CredentialStatus devuelve el resultado de la última comprobación de credenciales
+func (this *BedrockClient) CredentialStatus() CredentialStatus {
+	this.credentialHealth.mu.RLock()
+	defer this.credentialHealth.mu.RUnlock()
+	return CredentialStatus{
+		Valid:     !this.credentialHealth.invalid,
+		CheckedAt: this.credentialHealth.checkedAt,
+		LastError: this.credentialHealth.lastError,
+	}
+}
+
+// StartCredentialCheck comprueba las credenciales al arrancar y después cada config.Interval en segundo
+// plano. Devuelve la función que detiene la comprobación (no-op si está desactivada)
+func (this *BedrockClient) StartCredentialCheck(config CredentialCheckConfig) (stop func()) {
+	if config.Interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			this.CheckCredentials(context.Background(), config)
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// HandleReady es el endpoint de readiness: no aceptar tráfico si no podemos facturar (MetricsWorker
+// detenido) o si AWS no acepta las credenciales (rotadas o revocadas)
+func (this *BedrockClient) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if !this.IsMetricsHealthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("METRICS_UNAVAILABLE"))
+		return
+	}
+	if !this.CredentialsHealthy() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(EventCredentialsInvalid))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("READY"))
+}
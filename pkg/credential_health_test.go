@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// failingCredentials simula un proveedor de credenciales que no puede obtenerlas mientras fail sea true
+type failingCredentials struct {
+	fail bool
+}
+
+func (f *failingCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if f.fail {
+		return aws.Credentials{}, errors.New("failed to refresh cached credentials: token revoked")
+	}
+	return aws.Credentials{AccessKeyID: "test-access-key", SecretAccessKey: "test-secret-key"}, nil
+}
+
+func TestCheckCredentialsRetrieveFailure(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	provider := &failingCredentials{fail: true}
+	client := newTestBedrockClient()
+	client.credentials = provider
+
+	config := CredentialCheckConfig{FailureThreshold: 2}
+
+	// Un fallo aislado solo se avisa: /ready sigue aceptando tráfico
+	if err := client.CheckCredentials(context.Background(), config); err == nil {
+		t.Fatal("Expected credentials check to fail")
+	}
+	if !client.CredentialsHealthy() {
+		t.Error("Expected a single failure to keep credentials healthy")
+	}
+	if containsEvent(logs.String(), EventCredentialsInvalid) {
+		t.Errorf("Expected no CREDENTIALS_INVALID event below the threshold, got %s", logs.String())
+	}
+
+	if err := client.CheckCredentials(context.Background(), config); err == nil {
+		t.Fatal("Expected credentials check to fail")
+	}
+	if client.CredentialsHealthy() {
+		t.Error("Expected credentials to be reported unhealthy")
+	}
+	if !containsEvent(logs.String(), EventCredentialsInvalid) {
+		t.Errorf("Expected CREDENTIALS_INVALID event, got %s", logs.String())
+	}
+
+	rec := httptest.NewRecorder()
+	client.HandleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "CREDENTIALS_INVALID" {
+		t.Errorf("Expected 503 CREDENTIALS_INVALID from /ready, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// Al recuperarse, /ready vuelve a aceptar tráfico
+	provider.fail = false
+	if err := client.CheckCredentials(context.Background(), config); err != nil {
+		t.Fatalf("Expected credentials check to pass, got %v", err)
+	}
+	if !containsEvent(logs.String(), EventCredentialsValid) {
+		t.Errorf("Expected CREDENTIALS_VALID event on recovery, got %s", logs.String())
+	}
+	rec = httptest.NewRecorder()
+	client.HandleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 from /ready after recovery, got %d", rec.Code)
+	}
+}
+
+func TestCheckCredentialsSignedCall(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.credentials = &failingCredentials{}
+	config := CredentialCheckConfig{SignedCall: true, FailureThreshold: 1}
+
+	// Credenciales revocadas: AWS rechaza la firma
+	client.credentialProbe = func(ctx context.Context) error {
+		return errors.New(`API request failed with status 403: {"message":"The security token included in the request is invalid."}`)
+	}
+	if err := client.CheckCredentials(context.Background(), config); err == nil || client.CredentialsHealthy() {
+		t.Error("Expected a rejected signed call to invalidate the credentials")
+	}
+
+	// Falta de permiso para listar modelos u otros fallos no dicen nada de las credenciales
+	client.credentialProbe = func(ctx context.Context) error {
+		return errors.New(`API request failed with status 403: {"message":"User is not authorized to perform: bedrock:ListFoundationModels"}`)
+	}
+	if err := client.CheckCredentials(context.Background(), config); err != nil || !client.CredentialsHealthy() {
+		t.Errorf("Expected an unrelated signed call failure to keep credentials healthy, got %v", err)
+	}
+
+	// Sin signedCall no se hace la llamada firmada
+	client.credentialProbe = func(ctx context.Context) error {
+		t.Error("Expected no signed call")
+		return nil
+	}
+	client.CheckCredentials(context.Background(), CredentialCheckConfig{FailureThreshold: 1})
+}
+
+func TestCheckCredentialsTimeout(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.credentials = &failingCredentials{}
+	client.credentialProbe = func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// Un endpoint colgado no bloquea la comprobación más allá del timeout
+	start := time.Now()
+	client.CheckCredentials(context.Background(), CredentialCheckConfig{
+		SignedCall:       true,
+		Timeout:          50 * time.Millisecond,
+		FailureThreshold: 1,
+	})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the check to give up after the timeout, took %v", elapsed)
+	}
+}
+
+func TestLoadCredentialCheckConfigDefaults(t *testing.T) {
+	t.Setenv("CREDENTIAL_CHECK_SIGNED_CALL", "")
+	t.Setenv("CREDENTIAL_CHECK_TIMEOUT_SECONDS", "")
+	t.Setenv("CREDENTIAL_CHECK_FAILURE_THRESHOLD", "")
+
+	config := LoadCredentialCheckConfigWithEnv()
+	if !config.SignedCall {
+		t.Error("Expected the signed call to be on by default")
+	}
+	if config.Timeout != DefaultCredentialCheckTimeout || config.FailureThreshold != DefaultCredentialCheckFailureThreshold {
+		t.Errorf("Expected default timeout and threshold, got %v and %d", config.Timeout, config.FailureThreshold)
+	}
+
+	t.Setenv("CREDENTIAL_CHECK_SIGNED_CALL", "false")
+	if LoadCredentialCheckConfigWithEnv().SignedCall {
+		t.Error("Expected CREDENTIAL_CHECK_SIGNED_CALL=false to disable the signed call")
+	}
+}
@@ -26,8 +26,11 @@ type DatabaseConfig struct {
 
 // Database representa la conexión al pool de PostgreSQL
 type Database struct {
-	pool   *pgxpool.Pool
-	config *DatabaseConfig
+	pool             *pgxpool.Pool
+	replica          *pgxpool.Pool // Pool opcional de réplica de lectura (nil = usar primario)
+	config           *DatabaseConfig
+	teamSchemas      map[string]string // Equipos con métricas en un schema dedicado (team -> schema)
+	usageColumnCache usageColumnCache  // Columnas de usage tracking presentes en cada tabla (ver usageColumns)
 }
 
 // DBSecret representa la estructura del secreto en AWS Secrets Manager
@@ -82,6 +85,54 @@ func NewDatabaseFromSecret(ctx context.Context, secretARN string, sslMode string
 
 // NewDatabase crea una nueva instancia de Database y establece la conexión
 func NewDatabase(config *DatabaseConfig) (*Database, error) {
+	pool, err := newPool(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Database{
+		pool:   pool,
+		config: config,
+	}, nil
+}
+
+// ConnectReplica conecta un pool de réplica de lectura con las mismas credenciales que el primario
+// Las consultas de solo lectura usarán la réplica; las escrituras siguen en el primario
+func (db *Database) ConnectReplica(host string, port int) error {
+	if db.config == nil {
+		return fmt.Errorf("primary database config not available")
+	}
+
+	replicaConfig := *db.config
+	replicaConfig.Host = host
+	if port > 0 {
+		replicaConfig.Port = port
+	}
+
+	replica, err := newPool(&replicaConfig)
+	if err != nil {
+		return fmt.Errorf("error connecting to read replica: %w", err)
+	}
+
+	db.replica = replica
+	return nil
+}
+
+// HasReplica indica si hay una réplica de lectura configurada
+func (db *Database) HasReplica() bool {
+	return db.replica != nil
+}
+
+// readPool retorna el pool para consultas de solo lectura (réplica si existe, sino primario)
+func (db *Database) readPool() *pgxpool.Pool {
+	if db.replica != nil {
+		return db.replica
+	}
+	return db.pool
+}
+
+// newPool crea un pool de conexiones y verifica la conexión
+func newPool(config *DatabaseConfig) (*pgxpool.Pool, error) {
 	// Construir connection string
 	connString := fmt.Sprintf(
 		"host=%s port=%d dbname=%s user=%s password=%s sslmode=%s pool_max_conns=%d pool_min_conns=%d",
@@ -121,14 +172,14 @@ func NewDatabase(config *DatabaseConfig) (*Database, error) {
 		return nil, fmt.Errorf("error pinging database: %w", err)
 	}
 
-	return &Database{
-		pool:   pool,
-		config: config,
-	}, nil
+	return pool, nil
 }
 
 // Close cierra el pool de conexiones
 func (db *Database) Close() {
+	if db.replica != nil {
+		db.replica.Close()
+	}
 	if db.pool != nil {
 		db.pool.Close()
 	}
@@ -144,6 +195,14 @@ func (db *Database) Ping(ctx context.Context) error {
 	return db.pool.Ping(ctx)
 }
 
+// MaxConns retorna el máximo de conexiones del pool primario (0 si no hay pool)
+func (db *Database) MaxConns() int32 {
+	if db.pool == nil {
+		return 0
+	}
+	return db.pool.Config().MaxConns
+}
+
 // Stats retorna estadísticas del pool de conexiones
 func (db *Database) Stats() *pgxpool.Stat {
 	return db.pool.Stat()
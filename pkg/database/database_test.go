@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newLazyTestPool crea un pool sin conexiones iniciales (no requiere PostgreSQL)
+func newLazyTestPool(t *testing.T, host string) *pgxpool.Pool {
+	t.Helper()
+	poolConfig, err := pgxpool.ParseConfig("host=" + host + " port=5432 dbname=test user=test password=test sslmode=disable pool_min_conns=0")
+	if err != nil {
+		t.Fatalf("Failed to parse pool config: %v", err)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		t.Fatalf("Failed to create pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestReadPoolUsesReplicaWhenConfigured(t *testing.T) {
+	primary := newLazyTestPool(t, "primary.local")
+	replica := newLazyTestPool(t, "replica.local")
+
+	db := &Database{pool: primary, replica: replica}
+
+	if !db.HasReplica() {
+		t.Error("Expected HasReplica to be true")
+	}
+	if db.readPool() != replica {
+		t.Error("Expected reads to go to the replica pool")
+	}
+	if db.GetPool() != primary {
+		t.Error("Expected writes to stay on the primary pool")
+	}
+}
+
+func TestReadPoolFallsBackToPrimary(t *testing.T) {
+	primary := newLazyTestPool(t, "primary.local")
+
+	db := &Database{pool: primary}
+
+	if db.HasReplica() {
+		t.Error("Expected HasReplica to be false")
+	}
+	if db.readPool() != primary {
+		t.Error("Expected reads to fall back to the primary pool")
+	}
+}
+
+func TestConnectReplicaRequiresPrimaryConfig(t *testing.T) {
+	db := &Database{pool: newLazyTestPool(t, "primary.local")}
+
+	if err := db.ConnectReplica("replica.local", 5432); err == nil {
+		t.Error("Expected error without primary config")
+	}
+	if db.HasReplica() {
+		t.Error("Expected no replica after failed connection")
+	}
+}
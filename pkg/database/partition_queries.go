@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UsageTrackingTable es la tabla (particionada por fecha) donde se registran las métricas de uso
+const UsageTrackingTable = "bedrock-proxy-usage-tracking-tbl"
+
+// partitionTable devuelve el identificador escapado de una tabla, cualificado con el schema si lo hay
+func partitionTable(schema, table string) string {
+	if schema == "" {
+		return pgx.Identifier{table}.Sanitize()
+	}
+	return pgx.Identifier{schema, table}.Sanitize()
+}
+
+// ListPartitions devuelve los nombres de las particiones hijas (en el mismo schema) de una tabla
+// particionada del schema indicado ("" = el schema actual)
+func (db *Database) ListPartitions(ctx context.Context, schema, parentTable string) ([]string, error) {
+	query := `
+		SELECT child.relname
+		FROM pg_inherits i
+		JOIN pg_class parent ON parent.oid = i.inhparent
+		JOIN pg_class child ON child.oid = i.inhrelid
+		JOIN pg_namespace ns ON ns.oid = parent.relnamespace
+		WHERE parent.relname = $1
+		  AND ns.nspname = COALESCE(NULLIF($2, ''), current_schema())
+		  AND child.relnamespace = parent.relnamespace
+		ORDER BY child.relname
+	`
+
+	rows, err := db.pool.Query(ctx, query, parentTable, schema)
+	if err != nil {
+		return nil, fmt.Errorf("error listing partitions of %s: %w", parentTable, err)
+	}
+	defer rows.Close()
+
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning partition name: %w", err)
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, rows.Err()
+}
+
+// DropPartition desvincula y elimina una partición del schema indicado ("" = el schema actual) en una
+// única transacción. Es idempotente: si la tabla ya no es partición del padre (eliminada o desvinculada
+// a mano) no se toca
+func (db *Database) DropPartition(ctx context.Context, schema, parentTable, partition string) error {
+	parent := partitionTable(schema, parentTable)
+	child := partitionTable(schema, partition)
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var attached bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM pg_inherits i
+			JOIN pg_class parent ON parent.oid = i.inhparent
+			JOIN pg_class child ON child.oid = i.inhrelid
+			JOIN pg_namespace ns ON ns.oid = parent.relnamespace
+			WHERE parent.relname = $1 AND child.relname = $2
+			  AND ns.nspname = COALESCE(NULLIF($3, ''), current_schema())
+			  AND child.relnamespace = parent.relnamespace
+		)
+	`, parentTable, partition, schema).Scan(&attached)
+	if err != nil {
+		return fmt.Errorf("error checking partition %s: %w", partition, err)
+	}
+
+	if !attached {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", parent, child)); err != nil {
+		return fmt.Errorf("error detaching partition %s: %w", partition, err)
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf("DROP TABLE %s", child)); err != nil {
+		return fmt.Errorf("error dropping partition %s: %w", partition, err)
+	}
+
+	return tx.Commit(ctx)
+}
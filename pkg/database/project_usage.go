@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProjectUsage es el uso agregado de un proyecto en un intervalo
+type ProjectUsage struct {
+	ProjectID           string // Vacío = peticiones sin proyecto
+	Requests            int
+	TokensInput         int64
+	TokensOutput        int64
+	TokensCacheRead     int64
+	TokensCacheCreation int64
+	CostUSD             float64
+}
+
+// usageByProjectQuery es la plantilla de GetUsageByProject (ver scopedUsageQuery). project_id es
+// opcional (migrations/004): en las tablas que aún no la tienen se sustituye por NULL
+const usageByProjectQuery = `
+		SELECT 
+			COALESCE(project_id, ''),
+			COUNT(*),
+			COALESCE(SUM(tokens_input), 0),
+			COALESCE(SUM(tokens_output), 0),
+			COALESCE(SUM(tokens_cache_read), 0),
+			COALESCE(SUM(tokens_cache_creation), 0),
+			COALESCE(SUM(cost_usd), 0)
+		FROM %s
+		WHERE request_timestamp >= $1
+			AND request_timestamp < $2
+			%s
+		GROUP BY COALESCE(project_id, '')
+	`
+
+// GetUsageByProject agrega el uso por proyecto en [from, to) del scope indicado, ordenado por coste
+// descendente. Con AllTeams se suman los agregados de la tabla compartida y de los schemas dedicados
+func (db *Database) GetUsageByProject(ctx context.Context, scope TeamScope, from, to time.Time) ([]ProjectUsage, error) {
+	queries, args, err := db.projectUsageQueries(ctx, scope, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var partials []ProjectUsage
+	for _, query := range queries {
+		tableUsage, err := db.queryProjectUsage(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+		partials = append(partials, tableUsage...)
+	}
+
+	return mergeProjectUsage(partials), nil
+}
+
+// projectUsageQueries construye la agregación por proyecto de cada tabla del scope; en las tablas sin
+// la columna project_id todas las peticiones se agregan como "sin proyecto"
+func (db *Database) projectUsageQueries(ctx context.Context, scope TeamScope, from, to time.Time) ([]string, []interface{}, error) {
+	queries, args, err := db.scopedUsageQuery(scope, usageByProjectQuery, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, table := range db.scopeTables(scope) {
+		if !db.hasUsageColumn(ctx, table, "project_id") {
+			queries[i] = strings.ReplaceAll(queries[i], "project_id", "NULL")
+		}
+	}
+	return queries, args, nil
+}
+
+// queryProjectUsage ejecuta la agregación por proyecto sobre una tabla de usage tracking
+func (db *Database) queryProjectUsage(ctx context.Context, query string, args []interface{}) ([]ProjectUsage, error) {
+	rows, err := db.readPool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying usage by project: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []ProjectUsage
+	for rows.Next() {
+		var project ProjectUsage
+		err := rows.Scan(
+			&project.ProjectID,
+			&project.Requests,
+			&project.TokensInput,
+			&project.TokensOutput,
+			&project.TokensCacheRead,
+			&project.TokensCacheCreation,
+			&project.CostUSD,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning project usage: %w", err)
+		}
+		usage = append(usage, project)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating project usage: %w", err)
+	}
+
+	return usage, nil
+}
+
+// mergeProjectUsage suma los agregados parciales (uno por tabla) del mismo proyecto y los ordena por
+// coste descendente (a igual coste, por ID de proyecto)
+func mergeProjectUsage(partials []ProjectUsage) []ProjectUsage {
+	positions := make(map[string]int)
+	var merged []ProjectUsage
+	for _, partial := range partials {
+		position, ok := positions[partial.ProjectID]
+		if !ok {
+			position = len(merged)
+			positions[partial.ProjectID] = position
+			merged = append(merged, ProjectUsage{ProjectID: partial.ProjectID})
+		}
+		total := &merged[position]
+		total.Requests += partial.Requests
+		total.TokensInput += partial.TokensInput
+		total.TokensOutput += partial.TokensOutput
+		total.TokensCacheRead += partial.TokensCacheRead
+		total.TokensCacheCreation += partial.TokensCacheCreation
+		total.CostUSD += partial.CostUSD
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].CostUSD != merged[j].CostUSD {
+			return merged[i].CostUSD > merged[j].CostUSD
+		}
+		return merged[i].ProjectID < merged[j].ProjectID
+	})
+	return merged
+}
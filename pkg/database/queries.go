@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -24,6 +25,12 @@ type TokenInfo struct {
 	CognitoGroupName string    // Grupo de Cognito
 }
 
+// ErrTokenNotFound indica que el token no existe en BD
+var ErrTokenNotFound = errors.New("token not found or invalid")
+
+// ErrProfileInactive indica que el token existe pero su perfil de aplicación está desactivado
+var ErrProfileInactive = errors.New("token profile is not active")
+
 // QuotaInfo contiene información de uso de quotas
 type QuotaInfo struct {
 	UserID           string
@@ -53,15 +60,16 @@ func (db *Database) ValidateTokenAllowExpired(ctx context.Context, tokenHash str
 			p.profile_name,
 			p.cognito_group_name,
 			m.id,
-			m.model_name
+			m.model_name,
+			p.is_active
 		FROM "identity-manager-tokens-tbl" t
 		JOIN "identity-manager-profiles-tbl" p ON t.application_profile_id = p.id
 		JOIN "identity-manager-models-tbl" m ON p.model_id = m.id
 		WHERE t.token_hash = $1
-			AND p.is_active = true
 	`
 
 	var info TokenInfo
+	var profileActive bool
 	err := db.pool.QueryRow(ctx, query, tokenHash).Scan(
 		&info.JTI,
 		&info.UserID,
@@ -73,14 +81,18 @@ func (db *Database) ValidateTokenAllowExpired(ctx context.Context, tokenHash str
 		&info.CognitoGroupName,
 		&info.ModelID,
 		&info.ModelName,
+		&profileActive,
 	)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("token not found or invalid")
+			return nil, ErrTokenNotFound
 		}
 		return nil, fmt.Errorf("error validating token: %w", err)
 	}
+	if !profileActive {
+		return nil, ErrProfileInactive
+	}
 
 	// Update last_used_at timestamp (silent operation)
 	updateQuery := `
@@ -103,9 +115,9 @@ func (db *Database) CheckQuota(ctx context.Context, userID string) (*QuotaInfo,
 	query := `
 		SELECT 
 			u.email,
-			u.monthly_quota_usd,
-			u.daily_limit_usd,
-			u.daily_request_limit,
+			COALESCE(u.monthly_quota_usd, 0) as monthly_quota_usd,
+			COALESCE(u.daily_limit_usd, 0) as daily_limit_usd,
+			COALESCE(u.daily_request_limit, 0) as daily_request_limit,
 			COALESCE(qu.total_cost_usd, 0) as monthly_used_usd,
 			COALESCE(qu.total_requests, 0) as monthly_requests,
 			COALESCE(ubs.daily_cost_usd, 0) as daily_used_usd,
@@ -120,7 +132,7 @@ func (db *Database) CheckQuota(ctx context.Context, userID string) (*QuotaInfo,
 	`
 
 	var info QuotaInfo
-	err := db.pool.QueryRow(ctx, query, userID).Scan(
+	err := db.readPool().QueryRow(ctx, query, userID).Scan(
 		&info.UserID,
 		&info.MonthlyQuotaUSD,
 		&info.DailyLimitUSD,
@@ -162,24 +174,20 @@ type MetricData struct {
 	ProcessingTimeMS    int
 	ResponseStatus      string
 	ErrorMessage        string
+	ProjectID           string
 }
 
 // InsertMetric inserta una métrica de request en la base de datos
 // Deprecated: Use InsertUsageTracking() from quota_queries.go instead
 // Esta función usa la tabla antigua request_metrics y será eliminada
 func (db *Database) InsertMetric(ctx context.Context, metric *MetricData) error {
-	query := `
-		INSERT INTO request_metrics (
+	columns := `
 			user_id, team, person, request_timestamp, model_id, request_id,
 			source_ip, user_agent, aws_region, tokens_input, tokens_output,
 			tokens_cache_read, tokens_cache_creation, cost_usd, processing_time_ms,
-			response_status, error_message
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17
-		)
-	`
-
-	_, err := db.pool.Exec(ctx, query,
+			response_status, error_message`
+	values := "$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17"
+	args := []interface{}{
 		metric.UserID,
 		metric.Team,
 		metric.Person,
@@ -197,8 +205,23 @@ func (db *Database) InsertMetric(ctx context.Context, metric *MetricData) error
 		metric.ProcessingTimeMS,
 		metric.ResponseStatus,
 		metric.ErrorMessage,
-	)
+	}
+
+	// project_id lo añade migrations/004: hasta que se aplique no se escribe
+	if db.tableHasColumn(ctx, "request_metrics", "project_id") {
+		columns += ", project_id"
+		values += ", NULLIF($18, '')"
+		args = append(args, metric.ProjectID)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO request_metrics (%s
+		) VALUES (
+			%s
+		)
+	`, columns, values)
 
+	_, err := db.pool.Exec(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("error inserting metric: %w", err)
 	}
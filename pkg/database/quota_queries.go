@@ -3,7 +3,11 @@ package database
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 // QuotaCheckResult contiene el resultado de la verificación de cuota
@@ -48,6 +52,14 @@ type UsageTrackingData struct {
 	ProcessingTimeMS    int
 	ResponseStatus      string
 	ErrorMessage        string
+	ConversationID      string    // ID de conversación enviado por el cliente (X-Conversation-ID)
+	ServedModelID       string    // Modelo concreto que sirvió Bedrock (vacío si no se conoce)
+	RequestedModel      string    // Campo "model" tal como lo envió el cliente (vacío si no lo envió)
+	BedrockLatencyMS    int64     // Latencia del modelo informada por Bedrock (0 si no se conoce)
+	StreamDurationMS    int64     // Duración del streaming medida por el proxy (0 si no aplica)
+	ProjectID           string    // Proyecto al que se imputa el gasto (X-Project-Id o claim project; vacío si no hay)
+	BatchID             string    // Batch de /v1/messages/batch al que pertenece la request (vacío fuera de un batch)
+	RequestID           string    // ID único de la request: clave de idempotencia del INSERT (vacío si no se conoce)
 }
 
 // CheckAndUpdateQuota verifica la cuota del usuario e incrementa el contador
@@ -77,13 +89,23 @@ func (db *Database) CheckAndUpdateQuota(ctx context.Context, cognitoUserID, cogn
 	return &result, nil
 }
 
+// DatabaseTimeZone devuelve la zona horaria de la sesión (SHOW TimeZone). Es la que usa CURRENT_DATE en
+// check_and_update_quota() para decidir que ha cambiado el día y resetear los contadores
+func (db *Database) DatabaseTimeZone(ctx context.Context) (string, error) {
+	var timeZone string
+	if err := db.pool.QueryRow(ctx, "SHOW TimeZone").Scan(&timeZone); err != nil {
+		return "", fmt.Errorf("error reading database timezone: %w", err)
+	}
+	return timeZone, nil
+}
+
 // GetUserQuotaStatus obtiene el estado completo de cuota de un usuario
 func (db *Database) GetUserQuotaStatus(ctx context.Context, cognitoUserID string) (*QuotaStatus, error) {
 	query := `SELECT * FROM get_user_quota_status($1)`
 	
 	var status QuotaStatus
 	
-	err := db.pool.QueryRow(ctx, query, cognitoUserID).Scan(
+	err := db.readPool().QueryRow(ctx, query, cognitoUserID).Scan(
 		&status.CognitoUserID,
 		&status.CognitoEmail,
 		&status.DailyLimit,
@@ -103,6 +125,54 @@ func (db *Database) GetUserQuotaStatus(ctx context.Context, cognitoUserID string
 	return &status, nil
 }
 
+// ReserveRequests reserva de forma atómica count peticiones de la cuota diaria del usuario.
+// Es todo o nada: si no caben todas, requests_today no se modifica y Allowed es false
+func (db *Database) ReserveRequests(ctx context.Context, cognitoUserID string, count int) (*QuotaCheckResult, error) {
+	query := `
+		UPDATE "bedrock-proxy-user-quotas-tbl" q
+		SET requests_today = q.requests_today + $2
+		FROM (
+			SELECT COALESCE(daily_request_limit,
+				(SELECT config_value::INTEGER FROM "identity-manager-config-tbl" 
+				 WHERE config_key = 'default_daily_request_limit'), 
+				1000) AS daily_limit
+			FROM "bedrock-proxy-user-quotas-tbl"
+			WHERE cognito_user_id = $1
+		) l
+		WHERE q.cognito_user_id = $1
+			AND (q.administrative_safe = true
+				OR (q.is_blocked = false AND q.requests_today + $2 <= l.daily_limit))
+		RETURNING q.requests_today, l.daily_limit
+	`
+	
+	result := QuotaCheckResult{Allowed: true}
+	err := db.pool.QueryRow(ctx, query, cognitoUserID, count).Scan(&result.RequestsToday, &result.DailyLimit)
+	if err == pgx.ErrNoRows {
+		return &QuotaCheckResult{Allowed: false, BlockReason: fmt.Sprintf("daily request quota cannot fit %d requests", count)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reserving quota: %w", err)
+	}
+	
+	return &result, nil
+}
+
+// ReleaseRequests devuelve a la cuota diaria del usuario count peticiones reservadas con ReserveRequests
+// que no se llegaron a servir (requests fallidas de un batch). El contador nunca baja de 0
+func (db *Database) ReleaseRequests(ctx context.Context, cognitoUserID string, count int) error {
+	query := `
+		UPDATE "bedrock-proxy-user-quotas-tbl"
+		SET requests_today = GREATEST(requests_today - $2, 0)
+		WHERE cognito_user_id = $1
+	`
+	
+	if _, err := db.pool.Exec(ctx, query, cognitoUserID, count); err != nil {
+		return fmt.Errorf("error releasing quota: %w", err)
+	}
+	
+	return nil
+}
+
 // AdministrativeUnblockUser desbloquea un usuario administrativamente
 // Activa el flag administrative_safe que permite al usuario continuar hasta medianoche
 func (db *Database) AdministrativeUnblockUser(ctx context.Context, cognitoUserID, adminUserID, reason string) error {
@@ -167,56 +237,251 @@ func (db *Database) AdministrativeBlockUser(ctx context.Context, cognitoUserID,
 	return nil
 }
 
+// usageTrackingColumn es una columna que escriben InsertUsageTracking e InsertUsageTrackingBatch
+type usageTrackingColumn struct {
+	name        string
+	placeholder string                                    // Placeholder con %d para el número de parámetro
+	field       func(data *UsageTrackingData) interface{} // Puntero al campo de data
+	zero        string                                    // Valor SQL con el que se leen los NULL (vacío = NOT NULL)
+	optional    bool                                      // Añadida por una migración (migrations/): solo se escribe si la tabla ya la tiene
+}
+
+// usageTrackingSchema son las columnas de usage tracking en el orden del INSERT y de las lecturas completas
+var usageTrackingSchema = []usageTrackingColumn{
+	{name: "cognito_user_id", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.CognitoUserID }},
+	{name: "cognito_email", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.CognitoEmail }},
+	{name: "team", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.Team }, zero: "''"},
+	{name: "person", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.Person }, zero: "''"},
+	{name: "request_timestamp", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.RequestTimestamp }},
+	{name: "model_id", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.ModelID }},
+	{name: "source_ip", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.SourceIP }, zero: "''"},
+	{name: "user_agent", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.UserAgent }, zero: "''"},
+	{name: "aws_region", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.AWSRegion }, zero: "''"},
+	{name: "tokens_input", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.TokensInput }},
+	{name: "tokens_output", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.TokensOutput }},
+	{name: "tokens_cache_read", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.TokensCacheRead }},
+	{name: "tokens_cache_creation", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.TokensCacheCreation }},
+	{name: "cost_usd", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.CostUSD }},
+	{name: "processing_time_ms", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.ProcessingTimeMS }},
+	{name: "response_status", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.ResponseStatus }, zero: "''"},
+	{name: "error_message", placeholder: "$%d", field: func(d *UsageTrackingData) interface{} { return &d.ErrorMessage }, zero: "''"},
+	{name: "conversation_id", placeholder: "NULLIF($%d, '')", field: func(d *UsageTrackingData) interface{} { return &d.ConversationID }, zero: "''", optional: true},
+	{name: "served_model_id", placeholder: "NULLIF($%d, '')", field: func(d *UsageTrackingData) interface{} { return &d.ServedModelID }, zero: "''", optional: true},
+	{name: "bedrock_latency_ms", placeholder: "NULLIF($%d, 0)", field: func(d *UsageTrackingData) interface{} { return &d.BedrockLatencyMS }, zero: "0", optional: true},
+	{name: "stream_duration_ms", placeholder: "NULLIF($%d, 0)", field: func(d *UsageTrackingData) interface{} { return &d.StreamDurationMS }, zero: "0", optional: true},
+	{name: "requested_model", placeholder: "NULLIF($%d, '')", field: func(d *UsageTrackingData) interface{} { return &d.RequestedModel }, zero: "''", optional: true},
+	{name: "project_id", placeholder: "NULLIF($%d, '')", field: func(d *UsageTrackingData) interface{} { return &d.ProjectID }, zero: "''", optional: true},
+	{name: "batch_id", placeholder: "NULLIF($%d, '')", field: func(d *UsageTrackingData) interface{} { return &d.BatchID }, zero: "''", optional: true},
+	{name: "request_id", placeholder: "NULLIF($%d, '')", field: func(d *UsageTrackingData) interface{} { return &d.RequestID }, zero: "''", optional: true},
+}
+
+// usageTrackingParams es el número de parámetros por registro con todas las columnas
+var usageTrackingParams = len(usageTrackingSchema)
+
+// usageTrackingColumnNames devuelve la lista de columnas del INSERT
+func usageTrackingColumnNames(columns []usageTrackingColumn) string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// usageTrackingValues devuelve los placeholders de un registro cuyos parámetros empiezan en $offset+1
+func usageTrackingValues(columns []usageTrackingColumn, offset int) string {
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		placeholders[i] = fmt.Sprintf(column.placeholder, offset+i+1)
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")"
+}
+
+// usageTrackingConflictClause devuelve el ON CONFLICT del INSERT: con request_id (índice único de
+// migrations/005) un registro ya insertado se ignora, así que reintentar un INSERT no duplica el uso
+func usageTrackingConflictClause(columns []usageTrackingColumn) string {
+	for _, column := range columns {
+		if column.name == "request_id" {
+			return " ON CONFLICT DO NOTHING"
+		}
+	}
+	return ""
+}
+
+// usageTrackingArgs devuelve los parámetros de un registro en el orden de columns
+func usageTrackingArgs(columns []usageTrackingColumn, data *UsageTrackingData) []interface{} {
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		args[i] = reflect.ValueOf(column.field(data)).Elem().Interface()
+	}
+	return args
+}
+
 // InsertUsageTracking registra el uso detallado de una petición
 // Esta función debe llamarse de manera asíncrona después de procesar la petición
 func (db *Database) InsertUsageTracking(ctx context.Context, data *UsageTrackingData) error {
+	table := db.usageTable(data.Team)
+	columns := db.usageColumns(ctx, table)
 	query := `
-		INSERT INTO "bedrock-proxy-usage-tracking-tbl" (
+		INSERT INTO ` + table + ` (` + usageTrackingColumnNames(columns) + `) VALUES ` + usageTrackingValues(columns, 0) +
+		usageTrackingConflictClause(columns)
+	
+	_, err := db.pool.Exec(ctx, query, usageTrackingArgs(columns, data)...)
+	
+	if err != nil {
+		return fmt.Errorf("error inserting usage tracking: %w", err)
+	}
+	
+	return nil
+}
+
+// conversationTurnsQuery es la plantilla de GetConversationTurns (ver scopedUsageQuery)
+const conversationTurnsQuery = `
+		SELECT 
 			cognito_user_id,
-			cognito_email,
-			team,
-			person,
 			request_timestamp,
 			model_id,
-			source_ip,
-			user_agent,
-			aws_region,
 			tokens_input,
 			tokens_output,
 			tokens_cache_read,
 			tokens_cache_creation,
 			cost_usd,
-			processing_time_ms,
-			response_status,
-			error_message
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+			response_status
+		FROM %s
+		WHERE cognito_user_id = $1
+			AND conversation_id = $2
+			%s
+		ORDER BY request_timestamp ASC
 	`
+
+// GetConversationTurns obtiene los registros de uso de una conversación ordenados por turno
+// Se filtra también por usuario para no exponer conversaciones de otros usuarios
+func (db *Database) GetConversationTurns(ctx context.Context, scope TeamScope, cognitoUserID, conversationID string) ([]UsageTrackingData, error) {
+	queries, args, err := db.scopedUsageQuery(scope, conversationTurnsQuery, cognitoUserID, conversationID)
+	if err != nil {
+		return nil, err
+	}
 	
-	_, err := db.pool.Exec(ctx, query,
-		data.CognitoUserID,
-		data.CognitoEmail,
-		data.Team,
-		data.Person,
-		data.RequestTimestamp,
-		data.ModelID,
-		data.SourceIP,
-		data.UserAgent,
-		data.AWSRegion,
-		data.TokensInput,
-		data.TokensOutput,
-		data.TokensCacheRead,
-		data.TokensCacheCreation,
-		data.CostUSD,
-		data.ProcessingTimeMS,
-		data.ResponseStatus,
-		data.ErrorMessage,
-	)
+	var turns []UsageTrackingData
+	for _, query := range queries {
+		tableTurns, err := db.queryConversationTurns(ctx, query, conversationID, args)
+		if err != nil {
+			return nil, err
+		}
+		turns = append(turns, tableTurns...)
+	}
+	sortUsageByTimestamp(turns)
 	
+	return turns, nil
+}
+
+// queryConversationTurns ejecuta la lectura de turnos sobre una tabla de usage tracking
+func (db *Database) queryConversationTurns(ctx context.Context, query, conversationID string, args []interface{}) ([]UsageTrackingData, error) {
+	rows, err := db.readPool().Query(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("error inserting usage tracking: %w", err)
+		return nil, fmt.Errorf("error querying conversation turns: %w", err)
 	}
+	defer rows.Close()
 	
-	return nil
+	var turns []UsageTrackingData
+	for rows.Next() {
+		turn := UsageTrackingData{ConversationID: conversationID}
+		err := rows.Scan(
+			&turn.CognitoUserID,
+			&turn.RequestTimestamp,
+			&turn.ModelID,
+			&turn.TokensInput,
+			&turn.TokensOutput,
+			&turn.TokensCacheRead,
+			&turn.TokensCacheCreation,
+			&turn.CostUSD,
+			&turn.ResponseStatus,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning conversation turn: %w", err)
+		}
+		turns = append(turns, turn)
+	}
+	
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating conversation turns: %w", err)
+	}
+	
+	return turns, nil
+}
+
+// usageForDayQuery es la plantilla de GetUsageTrackingForDay (ver scopedUsageQuery). El %%s de la
+// lista de columnas se rellena por tabla con usageSelectList, tras aplicar el scope
+const usageForDayQuery = `
+		SELECT %%s
+		FROM %s
+		WHERE request_timestamp >= $1
+			AND request_timestamp < $2
+			%s
+		ORDER BY request_timestamp ASC
+	`
+
+// usageSelectList devuelve la lista de columnas de una lectura completa, leyendo los NULL como su valor cero
+func usageSelectList(columns []usageTrackingColumn) string {
+	expressions := make([]string, len(columns))
+	for i, column := range columns {
+		expressions[i] = column.name
+		if column.zero != "" {
+			expressions[i] = fmt.Sprintf("COALESCE(%s, %s)", column.name, column.zero)
+		}
+	}
+	return strings.Join(expressions, ",\n\t\t\t")
+}
+
+// GetUsageTrackingForDay obtiene los registros de uso de un día UTC [day, day+24h) del scope indicado
+// Se usa para el export diario al data warehouse (lectura en réplica si está configurada). Lee todas
+// las columnas de usageTrackingSchema que ya existen en cada tabla
+func (db *Database) GetUsageTrackingForDay(ctx context.Context, scope TeamScope, day time.Time) ([]UsageTrackingData, error) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	queries, args, err := db.scopedUsageQuery(scope, usageForDayQuery, start, start.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	
+	var records []UsageTrackingData
+	for i, table := range db.scopeTables(scope) {
+		columns := db.usageColumns(ctx, table)
+		tableRecords, err := db.queryUsageRecords(ctx, fmt.Sprintf(queries[i], usageSelectList(columns)), columns, args)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, tableRecords...)
+	}
+	sortUsageByTimestamp(records)
+	
+	return records, nil
+}
+
+// queryUsageRecords ejecuta la lectura de registros completos sobre una tabla de usage tracking
+func (db *Database) queryUsageRecords(ctx context.Context, query string, columns []usageTrackingColumn, args []interface{}) ([]UsageTrackingData, error) {
+	rows, err := db.readPool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying usage tracking for day: %w", err)
+	}
+	defer rows.Close()
+	
+	var records []UsageTrackingData
+	for rows.Next() {
+		var record UsageTrackingData
+		targets := make([]interface{}, len(columns))
+		for i, column := range columns {
+			targets[i] = column.field(&record)
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, fmt.Errorf("error scanning usage tracking record: %w", err)
+		}
+		records = append(records, record)
+	}
+	
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating usage tracking records: %w", err)
+	}
+	
+	return records, nil
 }
 
 // GetBlockedUsers obtiene la lista de usuarios actualmente bloqueados
@@ -237,7 +502,7 @@ func (db *Database) GetBlockedUsers(ctx context.Context) ([]QuotaStatus, error)
 		ORDER BY blocked_at DESC
 	`
 	
-	rows, err := db.pool.Query(ctx, query)
+	rows, err := db.readPool().Query(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("error querying blocked users: %w", err)
 	}
@@ -272,7 +537,7 @@ func (db *Database) GetBlockedUsers(ctx context.Context) ([]QuotaStatus, error)
 func (db *Database) GetUsersNearLimit(ctx context.Context) ([]QuotaStatus, error) {
 	query := `SELECT * FROM "v_users_near_limit"`
 	
-	rows, err := db.pool.Query(ctx, query)
+	rows, err := db.readPool().Query(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("error querying users near limit: %w", err)
 	}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrUnscopedQuery se devuelve cuando una lectura de uso no indica equipo ni pide explícitamente todos
+var ErrUnscopedQuery = errors.New("usage query must be scoped to a team")
+
+// TeamScope delimita una lectura de métricas de uso a un equipo (tenant). El valor cero no es válido:
+// toda lectura debe usar ForTeam o, de forma explícita, AllTeams
+type TeamScope struct {
+	team     string
+	allTeams bool
+}
+
+// ForTeam limita la lectura a los registros de un equipo
+func ForTeam(team string) TeamScope {
+	return TeamScope{team: team}
+}
+
+// AllTeams permite leer los registros de todos los equipos (solo para export y administración)
+func AllTeams() TeamScope {
+	return TeamScope{allTeams: true}
+}
+
+// Team devuelve el equipo del scope (vacío con AllTeams)
+func (s TeamScope) Team() string {
+	return s.team
+}
+
+// IsAllTeams indica si el scope cubre todos los equipos
+func (s TeamScope) IsAllTeams() bool {
+	return s.allTeams
+}
+
+func (s TeamScope) validate() error {
+	if s.allTeams || s.team != "" {
+		return nil
+	}
+	return ErrUnscopedQuery
+}
+
+// SetTeamSchemas enruta las métricas de los equipos indicados (team -> schema) a una tabla de usage
+// tracking en un schema dedicado. El resto de equipos sigue en la tabla compartida
+func (db *Database) SetTeamSchemas(schemas map[string]string) {
+	db.teamSchemas = schemas
+}
+
+// usageTable devuelve la tabla de usage tracking (identificador ya escapado) de un equipo
+func (db *Database) usageTable(team string) string {
+	if schema := db.teamSchemas[team]; team != "" && schema != "" {
+		return pgx.Identifier{schema, UsageTrackingTable}.Sanitize()
+	}
+	return pgx.Identifier{UsageTrackingTable}.Sanitize()
+}
+
+// usageTables devuelve la tabla compartida y las de los schemas dedicados, sin duplicados
+func (db *Database) usageTables() []string {
+	tables := []string{db.usageTable("")}
+	seen := map[string]bool{tables[0]: true}
+
+	teams := make([]string, 0, len(db.teamSchemas))
+	for team := range db.teamSchemas {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	for _, team := range teams {
+		if table := db.usageTable(team); !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// UsageSchemas devuelve los schemas con tabla de usage tracking: "" (el schema actual, tabla
+// compartida) y después los schemas dedicados de METRICS_TEAM_SCHEMAS, ordenados y sin duplicados
+func (db *Database) UsageSchemas() []string {
+	schemas := []string{""}
+	seen := map[string]bool{"": true}
+	for _, schema := range db.teamSchemas {
+		if !seen[schema] {
+			seen[schema] = true
+			schemas = append(schemas, schema)
+		}
+	}
+	sort.Strings(schemas[1:])
+	return schemas
+}
+
+// scopeTables devuelve las tablas de usage tracking que lee un scope, en el orden de las consultas
+// de scopedUsageQuery
+func (db *Database) scopeTables(scope TeamScope) []string {
+	if scope.allTeams {
+		return db.usageTables()
+	}
+	return []string{db.usageTable(scope.team)}
+}
+
+// scopedUsageQuery es el único punto de construcción de lecturas de usage tracking. La plantilla
+// lleva dos %s: la tabla (FROM %s) y el filtro de equipo (tras el resto de condiciones del WHERE).
+// Con un equipo se añade siempre "AND team = $n" aunque la tabla esté en un schema dedicado;
+// AllTeams devuelve una consulta por tabla (compartida y dedicadas)
+func (db *Database) scopedUsageQuery(scope TeamScope, template string, args ...interface{}) ([]string, []interface{}, error) {
+	if err := scope.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if scope.allTeams {
+		var queries []string
+		for _, table := range db.scopeTables(scope) {
+			queries = append(queries, fmt.Sprintf(template, table, ""))
+		}
+		return queries, args, nil
+	}
+
+	args = append(args, scope.team)
+	query := fmt.Sprintf(template, db.usageTable(scope.team), fmt.Sprintf("AND team = $%d", len(args)))
+	return []string{query}, args, nil
+}
+
+// sortUsageByTimestamp ordena los registros combinados de varias tablas por timestamp
+func sortUsageByTimestamp(records []UsageTrackingData) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].RequestTimestamp.Before(records[j].RequestTimestamp)
+	})
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usageQueryTemplates son todas las lecturas de usage tracking: cada una debe pasar por scopedUsageQuery
+var usageQueryTemplates = map[string]string{
+	"conversationTurns": conversationTurnsQuery,
+	"usageForDay":       usageForDayQuery,
+	"usageByProject":    usageByProjectQuery,
+}
+
+func TestUsageQueriesAreAlwaysTeamScoped(t *testing.T) {
+	db := &Database{}
+	for name, template := range usageQueryTemplates {
+		queries, args, err := db.scopedUsageQuery(ForTeam("data"), template, "a", "b")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if len(queries) != 1 || !strings.Contains(queries[0], "AND team = $3") {
+			t.Errorf("%s: expected team filter on $3, got %v", name, queries)
+		}
+		if len(args) != 3 || args[2] != "data" {
+			t.Errorf("%s: expected team as last argument, got %v", name, args)
+		}
+		if strings.Contains(queries[0], "%!") {
+			t.Errorf("%s: malformed template: %s", name, queries[0])
+		}
+	}
+}
+
+func TestUnscopedUsageQueriesAreRejected(t *testing.T) {
+	db := &Database{}
+	for name, template := range usageQueryTemplates {
+		if _, _, err := db.scopedUsageQuery(TeamScope{}, template); !errors.Is(err, ErrUnscopedQuery) {
+			t.Errorf("%s: expected ErrUnscopedQuery, got %v", name, err)
+		}
+	}
+	if _, _, err := db.scopedUsageQuery(ForTeam(""), conversationTurnsQuery); !errors.Is(err, ErrUnscopedQuery) {
+		t.Errorf("Expected empty team to be rejected, got %v", err)
+	}
+
+	// Las APIs públicas rechazan la lectura antes de tocar la BD
+	if _, err := db.GetConversationTurns(context.Background(), TeamScope{}, "user-1", "conv-1"); !errors.Is(err, ErrUnscopedQuery) {
+		t.Errorf("GetConversationTurns: expected ErrUnscopedQuery, got %v", err)
+	}
+	if _, err := db.GetUsageTrackingForDay(context.Background(), TeamScope{}, time.Now()); !errors.Is(err, ErrUnscopedQuery) {
+		t.Errorf("GetUsageTrackingForDay: expected ErrUnscopedQuery, got %v", err)
+	}
+}
+
+func TestTeamSchemaRouting(t *testing.T) {
+	db := &Database{}
+	db.SetTeamSchemas(map[string]string{"finance": "tenant_finance"})
+
+	queries, _, err := db.scopedUsageQuery(ForTeam("finance"), usageForDayQuery)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(queries[0], `FROM "tenant_finance"."bedrock-proxy-usage-tracking-tbl"`) || !strings.Contains(queries[0], "AND team = $1") {
+		t.Errorf("Expected dedicated schema with team filter, got %s", queries[0])
+	}
+
+	queries, _, _ = db.scopedUsageQuery(ForTeam("data"), usageForDayQuery)
+	if !strings.Contains(queries[0], `FROM "bedrock-proxy-usage-tracking-tbl"`) {
+		t.Errorf("Expected shared table for team without schema, got %s", queries[0])
+	}
+
+	// AllTeams lee explícitamente la tabla compartida y las dedicadas, sin filtro de equipo
+	queries, args, _ := db.scopedUsageQuery(AllTeams(), usageForDayQuery, "start", "end")
+	if len(queries) != 2 || len(args) != 2 {
+		t.Fatalf("Expected one query per table and no team argument, got %d queries %v", len(queries), args)
+	}
+	for _, query := range queries {
+		if strings.Contains(query, "team =") {
+			t.Errorf("Expected no team filter for AllTeams, got %s", query)
+		}
+	}
+	if db.usageTable("finance") == db.usageTable("") {
+		t.Error("Expected inserts for finance to go to its dedicated schema")
+	}
+}
+
+func TestUsageSchemasForPartitionRetention(t *testing.T) {
+	db := &Database{}
+	db.SetTeamSchemas(map[string]string{"legal": "tenant_shared", "finance": "tenant_finance", "ops": "tenant_shared"})
+
+	schemas := db.UsageSchemas()
+	if !reflect.DeepEqual(schemas, []string{"", "tenant_finance", "tenant_shared"}) {
+		t.Errorf("Expected the current schema and each dedicated schema once, got %v", schemas)
+	}
+	if got := partitionTable("tenant_finance", UsageTrackingTable+"_2025_01"); got != `"tenant_finance"."bedrock-proxy-usage-tracking-tbl_2025_01"` {
+		t.Errorf("Expected a schema-qualified partition, got %s", got)
+	}
+	if got := partitionTable("", UsageTrackingTable+"_2025_01"); got != `"bedrock-proxy-usage-tracking-tbl_2025_01"` {
+		t.Errorf("Expected an unqualified partition in the current schema, got %s", got)
+	}
+}
+
+func TestUsageTrackingBatchIsSingleStatementPerTable(t *testing.T) {
+	db := &Database{}
+	batch := make([]*UsageTrackingData, 50)
+	for i := range batch {
+		batch[i] = &UsageTrackingData{CognitoUserID: "user-1", Team: "data"}
+	}
+
+	statements := db.buildUsageTrackingBatch(context.Background(), batch)
+	if len(statements) != 1 {
+		t.Fatalf("Expected a single INSERT for the batch, got %d", len(statements))
+	}
+	if len(statements[0].args) != 50*usageTrackingParams {
+		t.Errorf("Expected %d args, got %d", 50*usageTrackingParams, len(statements[0].args))
+	}
+	if strings.Count(statements[0].query, "NULLIF($") != 50*8 || !strings.Contains(statements[0].query, "$1250") {
+		t.Errorf("Expected 50 value tuples up to $1250, got %s", statements[0].query)
+	}
+	if !strings.HasSuffix(statements[0].query, "ON CONFLICT DO NOTHING") {
+		t.Errorf("Expected the INSERT to ignore rows already inserted, got %s", statements[0].query)
+	}
+
+	// Los equipos con schema propio van en su propio INSERT
+	db.SetTeamSchemas(map[string]string{"finance": "tenant_finance"})
+	batch[0].Team = "finance"
+	statements = db.buildUsageTrackingBatch(context.Background(), batch)
+	if len(statements) != 2 || !strings.Contains(statements[0].query, `"tenant_finance"`) {
+		t.Errorf("Expected one INSERT per table, got %d", len(statements))
+	}
+	if len(statements[1].args) != 49*usageTrackingParams {
+		t.Errorf("Expected 49 rows in the shared table INSERT, got %d args", len(statements[1].args))
+	}
+}
+
+func TestUsageTrackingBatchSkipsColumnsMissingFromTable(t *testing.T) {
+	db := &Database{}
+	table := db.usageTable("")
+	// Tabla sin migrar: solo las columnas del esquema inicial
+	db.usageColumnCache.tables = map[string]usageColumnEntry{
+		table: {columns: requiredUsageColumns(), checkedAt: time.Now()},
+	}
+
+	statements := db.buildUsageTrackingBatch(context.Background(), []*UsageTrackingData{
+		{CognitoUserID: "user-1", Team: "data", ProjectID: "apollo", ConversationID: "conv-1"},
+	})
+	if len(statements) != 1 {
+		t.Fatalf("Expected a single INSERT, got %d", len(statements))
+	}
+	query := statements[0].query
+	for _, column := range usageTrackingSchema {
+		if column.optional && strings.Contains(query, column.name) {
+			t.Errorf("Expected %s to be omitted before the migration, got %s", column.name, query)
+		}
+	}
+	if strings.Contains(query, "ON CONFLICT") {
+		t.Errorf("Expected no ON CONFLICT without the request_id unique index, got %s", query)
+	}
+	required := len(requiredUsageColumns())
+	if len(statements[0].args) != required || strings.Contains(query, fmt.Sprintf("$%d", required+1)) {
+		t.Errorf("Expected %d parameters, got %d: %s", required, len(statements[0].args), query)
+	}
+}
+
+func TestUsageForDayReadsSchemaColumns(t *testing.T) {
+	db := &Database{}
+	queries, _, err := db.scopedUsageQuery(ForTeam("data"), usageForDayQuery, "start", "end")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Tabla migrada: se leen todas las columnas del esquema, los NULL como valor cero
+	query := fmt.Sprintf(queries[0], usageSelectList(usageTrackingSchema))
+	if strings.Contains(query, "%!") || strings.Contains(query, "%s") {
+		t.Fatalf("Malformed query: %s", query)
+	}
+	for _, column := range usageTrackingSchema {
+		if !strings.Contains(query, column.name) {
+			t.Errorf("Expected %s to be read, got %s", column.name, query)
+		}
+	}
+	if !strings.Contains(query, "COALESCE(batch_id, '')") || !strings.Contains(query, "COALESCE(stream_duration_ms, 0)") {
+		t.Errorf("Expected nullable columns to be read with COALESCE, got %s", query)
+	}
+
+	// Tabla sin migrar: las columnas opcionales no se leen
+	query = fmt.Sprintf(queries[0], usageSelectList(requiredUsageColumns()))
+	if strings.Contains(query, "project_id") {
+		t.Errorf("Expected project_id to be skipped before the migration, got %s", query)
+	}
+
+	// Los parámetros del INSERT son valores, no los punteros de field
+	args := usageTrackingArgs(usageTrackingSchema, &UsageTrackingData{CognitoUserID: "user-1", BedrockLatencyMS: 42})
+	if args[0] != "user-1" || args[19] != int64(42) {
+		t.Errorf("Expected plain values as INSERT arguments, got %v and %v", args[0], args[19])
+	}
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxInsertParams es el máximo de parámetros que admite PostgreSQL en una sentencia; limita los registros por INSERT
+const maxInsertParams = 65535
+
+// usageBatchStatement es un INSERT multi-fila sobre una tabla de usage tracking
+type usageBatchStatement struct {
+	query string
+	args  []interface{}
+}
+
+// buildUsageTrackingBatch agrupa los registros por tabla (los equipos con schema propio van a la suya)
+// y genera un único INSERT multi-fila por tabla, partido solo si supera maxUsageRowsPerInsert
+func (db *Database) buildUsageTrackingBatch(ctx context.Context, batch []*UsageTrackingData) []usageBatchStatement {
+	var tables []string
+	rowsByTable := make(map[string][]*UsageTrackingData)
+	for _, data := range batch {
+		table := db.usageTable(data.Team)
+		if _, ok := rowsByTable[table]; !ok {
+			tables = append(tables, table)
+		}
+		rowsByTable[table] = append(rowsByTable[table], data)
+	}
+
+	var statements []usageBatchStatement
+	for _, table := range tables {
+		rows := rowsByTable[table]
+		columns := db.usageColumns(ctx, table)
+		rowsPerInsert := maxInsertParams / len(columns)
+		for start := 0; start < len(rows); start += rowsPerInsert {
+			chunk := rows[start:min(start+rowsPerInsert, len(rows))]
+
+			values := make([]string, len(chunk))
+			args := make([]interface{}, 0, len(chunk)*len(columns))
+			for i, data := range chunk {
+				values[i] = usageTrackingValues(columns, i*len(columns))
+				args = append(args, usageTrackingArgs(columns, data)...)
+			}
+			statements = append(statements, usageBatchStatement{
+				query: `
+		INSERT INTO ` + table + ` (` + usageTrackingColumnNames(columns) + `) VALUES
+			` + strings.Join(values, ",\n\t\t\t") + usageTrackingConflictClause(columns),
+				args: args,
+			})
+		}
+	}
+	return statements
+}
+
+// InsertUsageTrackingBatch inserta un batch de registros de uso con un INSERT multi-fila por tabla, todo
+// en una transacción: si falla no queda ningún registro insertado y el caller puede reintentar uno a uno
+func (db *Database) InsertUsageTrackingBatch(ctx context.Context, batch []*UsageTrackingData) error {
+	statements := db.buildUsageTrackingBatch(ctx, batch)
+	if len(statements) == 0 {
+		return nil
+	}
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement.query, statement.args...); err != nil {
+			return fmt.Errorf("error inserting usage tracking batch: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing usage tracking batch: %w", err)
+	}
+	return nil
+}
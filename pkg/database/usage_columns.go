@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// usageColumnsRecheckInterval es cada cuánto se vuelve a comprobar una tabla a la que le faltan columnas,
+// para empezar a escribirlas en cuanto se aplique la migración sin reiniciar el proxy
+const usageColumnsRecheckInterval = 5 * time.Minute
+
+// usageColumnsQuery devuelve las columnas existentes de una tabla (identificador ya saneado, con schema si lo hay)
+const usageColumnsQuery = `
+		SELECT attname FROM pg_attribute
+		WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped
+	`
+
+// usageColumnCache guarda por tabla las columnas de usage tracking que se pueden escribir y, para el
+// resto de tablas con columnas opcionales (request_metrics), el conjunto de columnas existentes
+type usageColumnCache struct {
+	mu     sync.Mutex
+	tables map[string]usageColumnEntry
+	sets   map[string]columnSetEntry
+}
+
+type usageColumnEntry struct {
+	columns   []usageTrackingColumn
+	checkedAt time.Time
+}
+
+type columnSetEntry struct {
+	columns   map[string]bool
+	checkedAt time.Time
+}
+
+// usageColumns devuelve las columnas de usage tracking que existen en la tabla: las opcionales que aún no
+// ha añadido la migración se omiten del INSERT. Una tabla completa se cachea para siempre; una a la que
+// le faltan columnas se vuelve a comprobar cada usageColumnsRecheckInterval. Sin pool (tests) devuelve
+// todas las columnas
+func (db *Database) usageColumns(ctx context.Context, table string) []usageTrackingColumn {
+	db.usageColumnCache.mu.Lock()
+	entry, ok := db.usageColumnCache.tables[table]
+	db.usageColumnCache.mu.Unlock()
+	if ok && (len(entry.columns) == len(usageTrackingSchema) || time.Since(entry.checkedAt) < usageColumnsRecheckInterval) {
+		return entry.columns
+	}
+	if db.pool == nil {
+		return usageTrackingSchema
+	}
+
+	existing, err := db.tableColumns(ctx, table)
+	if err != nil {
+		// Sin poder comprobarlo, escribir solo las columnas del esquema inicial (siempre presentes)
+		return requiredUsageColumns()
+	}
+
+	columns := make([]usageTrackingColumn, 0, len(usageTrackingSchema))
+	for _, column := range usageTrackingSchema {
+		if !column.optional || existing[column.name] {
+			columns = append(columns, column)
+		}
+	}
+
+	db.usageColumnCache.mu.Lock()
+	if db.usageColumnCache.tables == nil {
+		db.usageColumnCache.tables = make(map[string]usageColumnEntry)
+	}
+	db.usageColumnCache.tables[table] = usageColumnEntry{columns: columns, checkedAt: time.Now()}
+	db.usageColumnCache.mu.Unlock()
+	return columns
+}
+
+// hasUsageColumn indica si la tabla de usage tracking tiene la columna (las opcionales dependen de las migraciones)
+func (db *Database) hasUsageColumn(ctx context.Context, table, name string) bool {
+	for _, column := range db.usageColumns(ctx, table) {
+		if column.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tableHasColumn indica si una tabla fuera del esquema de usage tracking tiene la columna. Como en
+// usageColumns, el resultado se vuelve a comprobar cada usageColumnsRecheckInterval. Sin pool (tests)
+// o si no se puede comprobar se asume que no existe
+func (db *Database) tableHasColumn(ctx context.Context, table, name string) bool {
+	db.usageColumnCache.mu.Lock()
+	entry, ok := db.usageColumnCache.sets[table]
+	db.usageColumnCache.mu.Unlock()
+	if ok && time.Since(entry.checkedAt) < usageColumnsRecheckInterval {
+		return entry.columns[name]
+	}
+	if db.pool == nil {
+		return false
+	}
+
+	existing, err := db.tableColumns(ctx, table)
+	if err != nil {
+		return false
+	}
+
+	db.usageColumnCache.mu.Lock()
+	if db.usageColumnCache.sets == nil {
+		db.usageColumnCache.sets = make(map[string]columnSetEntry)
+	}
+	db.usageColumnCache.sets[table] = columnSetEntry{columns: existing, checkedAt: time.Now()}
+	db.usageColumnCache.mu.Unlock()
+	return existing[name]
+}
+
+// tableColumns devuelve el conjunto de columnas de una tabla
+func (db *Database) tableColumns(ctx context.Context, table string) (map[string]bool, error) {
+	rows, err := db.pool.Query(ctx, usageColumnsQuery, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	return existing, rows.Err()
+}
+
+// requiredUsageColumns devuelve las columnas del esquema inicial de la tabla de usage tracking
+func requiredUsageColumns() []usageTrackingColumn {
+	columns := make([]usageTrackingColumn, 0, len(usageTrackingSchema))
+	for _, column := range usageTrackingSchema {
+		if !column.optional {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
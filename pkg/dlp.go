@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// DefaultDLPMaxScanBytes es el máximo de texto de una request que se revisa contra los patrones DLP
+const DefaultDLPMaxScanBytes = 1 << 20
+
+// DLPFilter bloquea requests cuyo contenido coincide con algún patrón prohibido (secretos, palabras clave).
+// Los patrones se compilan una vez al arrancar y solo se revisan los primeros maxScanBytes de texto
+type DLPFilter struct {
+	patterns     []*regexp.Regexp
+	maxScanBytes int
+}
+
+// NewDLPFilter compila los patrones; un patrón inválido es un error de configuración
+func NewDLPFilter(patterns []string, maxScanBytes int) (*DLPFilter, error) {
+	if maxScanBytes <= 0 {
+		maxScanBytes = DefaultDLPMaxScanBytes
+	}
+	filter := &DLPFilter{maxScanBytes: maxScanBytes}
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DLP pattern #%d: %w", i, err)
+		}
+		filter.patterns = append(filter.patterns, re)
+	}
+	return filter, nil
+}
+
+// LoadDLPFilterWithEnv carga DLP_PATTERNS (array JSON de regex) y DLP_MAX_SCAN_BYTES.
+// Sin patrones devuelve nil (DLP desactivado)
+func LoadDLPFilterWithEnv() (*DLPFilter, error) {
+	raw := os.Getenv("DLP_PATTERNS")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var patterns []string
+	if err := json.Unmarshal([]byte(raw), &patterns); err != nil {
+		return nil, fmt.Errorf("DLP_PATTERNS must be a JSON array of regular expressions: %w", err)
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	maxScanBytes := DefaultDLPMaxScanBytes
+	if value := os.Getenv("DLP_MAX_SCAN_BYTES"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit > 0 {
+			maxScanBytes = limit
+		}
+	}
+	return NewDLPFilter(patterns, maxScanBytes)
+}
+
+// Match revisa el texto del payload Anthropic (system, messages y tools) y devuelve el índice del primer
+// patrón que coincide (-1 si ninguno). Un texto que supera el límite restante solo se revisa hasta el límite
+func (f *DLPFilter) Match(payload map[string]interface{}) int {
+	matched := -1
+	remaining := f.maxScanBytes
+	visitPayloadText(payload, func(text string) bool {
+		if len(text) > remaining {
+			text = text[:remaining]
+		}
+		remaining -= len(text)
+		for i, re := range f.patterns {
+			if re.MatchString(text) {
+				matched = i
+				return false
+			}
+		}
+		return remaining > 0
+	})
+	return matched
+}
+
+// dlpMatch revisa el body de una request con el filtro DLP configurado (-1 si no hay filtro o no coincide).
+// Un body que no es JSON no se revisa: se rechaza después al parsearlo
+func (this *BedrockClient) dlpMatch(body []byte) int {
+	if this.dlp == nil {
+		return -1
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return -1
+	}
+	return this.dlp.Match(payload)
+}
+
+// rejectDLP responde 422 DLP_BLOCKED (sin registrar el contenido) si el body coincide con un patrón DLP.
+// Devuelve true si la request se ha rechazado
+func (this *BedrockClient) rejectDLP(ctx context.Context, w http.ResponseWriter, body []byte, userID string) bool {
+	pattern := this.dlpMatch(body)
+	if pattern < 0 {
+		return false
+	}
+	Logger.WarningContext(ctx, amslog.Event{
+		Name:    EventDLPBlock,
+		Message: "Request blocked by DLP pattern",
+		Outcome: amslog.OutcomeFailure,
+		Error: &amslog.ErrorInfo{
+			Type:    "DLPError",
+			Message: "request content matches a forbidden pattern",
+			Code:    string(ErrCodeDLPBlocked),
+		},
+		Fields: map[string]interface{}{
+			"user.id":     userID,
+			"dlp.pattern": pattern,
+		},
+	})
+	writeErrorResponse(w, ErrCodeDLPBlocked, "Request content matches a forbidden pattern")
+	return true
+}
+
+// SetDLPFilter configura el filtro DLP de las requests (nil lo desactiva)
+func (this *BedrockClient) SetDLPFilter(filter *DLPFilter) {
+	this.dlp = filter
+}
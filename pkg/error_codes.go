@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"net/http"
+
+	"bedrock-proxy-test/pkg/apierror"
+)
+
+// ErrorCode es el código estable de un error del proxy. Se usa en los logs (error.code) y se
+// devuelve al cliente dentro del error en formato Anthropic, así que sus valores no deben cambiar
+type ErrorCode string
+
+// Errores de la request del cliente
+const (
+	ErrCodeNoInferenceProfile        ErrorCode = "NO_INFERENCE_PROFILE"
+	ErrCodeProfileRegionNotAllowed   ErrorCode = "PROFILE_REGION_NOT_ALLOWED"
+	ErrCodeInvalidJSON               ErrorCode = "INVALID_JSON"
+	ErrCodeToolLimitsExceeded        ErrorCode = "TOOL_LIMITS_EXCEEDED"
+	ErrCodeSystemLimitsExceeded      ErrorCode = "SYSTEM_LIMITS_EXCEEDED"
+	ErrCodeToolJSONConversionFailed  ErrorCode = "TOOL_JSON_CONVERSION_FAILED"
+	ErrCodeToolConversionFailed      ErrorCode = "TOOL_CONVERSION_FAILED"
+	ErrCodeInvalidTopK               ErrorCode = "INVALID_TOP_K"
+	ErrCodeTooManyMessages           ErrorCode = "TOO_MANY_MESSAGES"
+	ErrCodeMessageConversionFailed   ErrorCode = "MESSAGE_CONVERSION_FAILED"
+	ErrCodeNonStreamToolsUnsupported ErrorCode = "NONSTREAM_TOOLS_UNSUPPORTED"
+	ErrCodeInvalidBatch              ErrorCode = "INVALID_BATCH"
+	ErrCodeBatchTooLarge             ErrorCode = "BATCH_TOO_LARGE"
+	ErrCodeBatchQuotaExceeded        ErrorCode = "BATCH_QUOTA_EXCEEDED"
+	ErrCodeCostCeilingExceeded       ErrorCode = "COST_CEILING_EXCEEDED"
+	ErrCodeDLPBlocked                ErrorCode = "DLP_BLOCKED"
+	ErrCodeRequestReadFailed         ErrorCode = "REQUEST_READ_FAILED"
+	ErrCodeMethodNotAllowed          ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrCodeClientNotAllowed          ErrorCode = "CLIENT_NOT_ALLOWED"
+	ErrCodeIdempotencyKeyReused      ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+)
+
+// Errores del proxy
+const (
+	ErrCodeSignRequestFailed  ErrorCode = "SIGN_REQUEST_FAILED"
+	ErrCodeMetricsUnavailable ErrorCode = "METRICS_UNAVAILABLE"
+	ErrCodeMaintenanceMode    ErrorCode = "MAINTENANCE_MODE"
+	ErrCodeResponseCopyFailed ErrorCode = "RESPONSE_COPY_FAILED"
+	ErrCodeQuotaCheckFailed   ErrorCode = "QUOTA_CHECK_FAILED"
+)
+
+// Errores de Bedrock
+const (
+	ErrCodeBedrockCallFailed       ErrorCode = "BEDROCK_CALL_FAILED"
+	ErrCodeBedrockValidation       ErrorCode = "BEDROCK_VALIDATION_ERROR"
+	ErrCodeBedrockAccessDenied     ErrorCode = "BEDROCK_ACCESS_DENIED"
+	ErrCodeBedrockResourceNotFound ErrorCode = "BEDROCK_RESOURCE_NOT_FOUND"
+	ErrCodeBedrockThrottled        ErrorCode = "BEDROCK_THROTTLED"
+	ErrCodeBedrockUnavailable      ErrorCode = "BEDROCK_UNAVAILABLE"
+	ErrCodeBedrockStreamFailed     ErrorCode = "BEDROCK_STREAM_FAILED"
+	ErrCodeBedrockTimeout          ErrorCode = "BEDROCK_TIMEOUT"
+)
+
+// errorCodeClass es el status HTTP y el tipo de error Anthropic asociados a un código
+type errorCodeClass struct {
+	StatusCode int
+	ErrorType  string
+}
+
+// errorCodes es el registro central de códigos de error
+var errorCodes = map[ErrorCode]errorCodeClass{
+	ErrCodeNoInferenceProfile:        {http.StatusForbidden, "permission_error"},
+	ErrCodeProfileRegionNotAllowed:   {http.StatusForbidden, "permission_error"},
+	ErrCodeInvalidJSON:               {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeToolLimitsExceeded:        {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeSystemLimitsExceeded:      {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeToolJSONConversionFailed:  {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeToolConversionFailed:      {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeInvalidTopK:               {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeTooManyMessages:           {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeMessageConversionFailed:   {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeNonStreamToolsUnsupported: {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeInvalidBatch:              {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeBatchTooLarge:             {http.StatusRequestEntityTooLarge, "request_too_large"},
+	ErrCodeBatchQuotaExceeded:        {http.StatusTooManyRequests, "rate_limit_error"},
+	ErrCodeCostCeilingExceeded:       {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeDLPBlocked:                {http.StatusUnprocessableEntity, "invalid_request_error"},
+	ErrCodeRequestReadFailed:         {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeMethodNotAllowed:          {http.StatusMethodNotAllowed, "invalid_request_error"},
+	ErrCodeClientNotAllowed:          {http.StatusForbidden, "permission_error"},
+	ErrCodeIdempotencyKeyReused:      {http.StatusUnprocessableEntity, "invalid_request_error"},
+	ErrCodeSignRequestFailed:         {http.StatusBadGateway, "api_error"},
+	ErrCodeMetricsUnavailable:        {http.StatusServiceUnavailable, "overloaded_error"},
+	ErrCodeMaintenanceMode:           {http.StatusServiceUnavailable, "overloaded_error"},
+	ErrCodeResponseCopyFailed:        {http.StatusBadGateway, "api_error"},
+	ErrCodeQuotaCheckFailed:          {http.StatusInternalServerError, "api_error"},
+	ErrCodeBedrockCallFailed:         {http.StatusBadGateway, "api_error"},
+	ErrCodeBedrockValidation:         {http.StatusBadRequest, "invalid_request_error"},
+	ErrCodeBedrockAccessDenied:       {http.StatusForbidden, "permission_error"},
+	ErrCodeBedrockResourceNotFound:   {http.StatusNotFound, "not_found_error"},
+	ErrCodeBedrockThrottled:          {http.StatusTooManyRequests, "rate_limit_error"},
+	ErrCodeBedrockUnavailable:        {http.StatusServiceUnavailable, "overloaded_error"},
+	ErrCodeBedrockStreamFailed:       {http.StatusBadGateway, "api_error"},
+	ErrCodeBedrockTimeout:            {http.StatusGatewayTimeout, "api_error"},
+}
+
+// class devuelve el status y tipo del código; un código no registrado se trata como error interno
+func (c ErrorCode) class() errorCodeClass {
+	if class, ok := errorCodes[c]; ok {
+		return class
+	}
+	return errorCodeClass{http.StatusInternalServerError, "api_error"}
+}
+
+// StatusCode devuelve el status HTTP con el que se responde al cliente
+func (c ErrorCode) StatusCode() int {
+	return c.class().StatusCode
+}
+
+// ErrorType devuelve el tipo de error en formato Anthropic; el configurado para el status
+// (ERROR_TYPE_BY_STATUS) tiene prioridad sobre el del registro
+func (c ErrorCode) ErrorType() string {
+	class := c.class()
+	return apierror.ResolveType(class.StatusCode, class.ErrorType)
+}
+
+// writeErrorResponse responde con el error en formato Anthropic y el status del código:
+// {"type":"error","error":{"type":"...","code":"...","message":"..."}}
+func writeErrorResponse(w http.ResponseWriter, code ErrorCode, message string) {
+	apierror.Write(w, code.StatusCode(), code.ErrorType(), string(code), message, nil)
+}
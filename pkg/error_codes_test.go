@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// anthropicErrorTypes son los tipos de error que define la API de Anthropic
+var anthropicErrorTypes = map[string]bool{
+	"invalid_request_error": true,
+	"authentication_error":  true,
+	"permission_error":      true,
+	"not_found_error":       true,
+	"request_too_large":     true,
+	"rate_limit_error":      true,
+	"api_error":             true,
+	"overloaded_error":      true,
+}
+
+func TestErrorCodesMapToValidStatus(t *testing.T) {
+	for code, class := range errorCodes {
+		if class.StatusCode < 400 || class.StatusCode > 599 || http.StatusText(class.StatusCode) == "" {
+			t.Errorf("%s: invalid status %d", code, class.StatusCode)
+		}
+		if !anthropicErrorTypes[class.ErrorType] {
+			t.Errorf("%s: unknown Anthropic error type %q", code, class.ErrorType)
+		}
+	}
+
+	if status := ErrorCode("NOT_REGISTERED").StatusCode(); status != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for unregistered code, got %d", status)
+	}
+}
+
+func TestWriteErrorResponseShape(t *testing.T) {
+	for code := range errorCodes {
+		rec := httptest.NewRecorder()
+		writeErrorResponse(rec, code, "something failed")
+
+		if rec.Code != code.StatusCode() {
+			t.Errorf("%s: expected status %d, got %d", code, code.StatusCode(), rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: expected application/json, got %q", code, ct)
+		}
+
+		var body struct {
+			Type  string `json:"type"`
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: invalid JSON body: %v", code, err)
+		}
+		if body.Type != "error" || body.Error.Type != code.ErrorType() || body.Error.Code != string(code) || body.Error.Message != "something failed" {
+			t.Errorf("%s: unexpected error body %s", code, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleProxyErrorsUseRegisteredCodes(t *testing.T) {
+	setupTestLogger(t)
+	client := newTestBedrockClient()
+	client.config.RequireMetricsForStream = false
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "top_k": -1, "messages": []}`))
+
+	if rec.Code != ErrCodeInvalidTopK.StatusCode() {
+		t.Fatalf("Expected status %d, got %d", ErrCodeInvalidTopK.StatusCode(), rec.Code)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON body: %v (%s)", err, rec.Body.String())
+	}
+	if body.Error.Code != string(ErrCodeInvalidTopK) {
+		t.Errorf("Expected code %s, got %s", ErrCodeInvalidTopK, body.Error.Code)
+	}
+}
@@ -5,14 +5,28 @@ const (
 	EventProxyRequestStart = "PROXY_REQUEST_START"
 	EventProxyRequestEnd   = "PROXY_REQUEST_END"
 	EventProxyRequestError = "PROXY_REQUEST_ERROR"
+	EventRequestSummary    = "REQUEST_SUMMARY"
+	EventSlowRequest       = "SLOW_REQUEST"
+	EventProxyMaintenance  = "PROXY_MAINTENANCE_REJECTED"
+	EventBatchStart        = "BATCH_START"
+	EventBatchComplete     = "BATCH_COMPLETE"
+	EventIdempotentReplay  = "IDEMPOTENCY_REPLAY"
+	EventDLPBlock          = "DLP_BLOCK"
 )
 
 // Eventos de Bedrock
 const (
-	EventBedrockInvoke         = "BEDROCK_INVOKE"
-	EventBedrockStreamStart    = "BEDROCK_STREAM_START"
-	EventBedrockStreamComplete = "BEDROCK_STREAM_COMPLETE"
-	EventBedrockError          = "BEDROCK_ERROR"
+	EventBedrockInvoke             = "BEDROCK_INVOKE"
+	EventBedrockStreamStart        = "BEDROCK_STREAM_START"
+	EventBedrockStreamComplete     = "BEDROCK_STREAM_COMPLETE"
+	EventStreamClientStalled       = "STREAM_CLIENT_STALLED"
+	EventBedrockError              = "BEDROCK_ERROR"
+	EventBedrockRetry              = "BEDROCK_RETRY"
+	EventToolResultTruncated       = "TOOL_RESULT_TRUNCATED"
+	EventNonStreamToolsUnsupported = "NONSTREAM_TOOLS_UNSUPPORTED"
+	EventBedrockContentFiltered    = "BEDROCK_CONTENT_FILTERED"
+	EventToolsConversion           = "TOOLS_CONVERSION"
+	EventConverseRequestHash       = "CONVERSE_REQUEST_HASH"
 )
 
 // Eventos de Autenticación
@@ -22,6 +36,8 @@ const (
 	EventAuthLogin       = "AUTH_LOGIN"
 	EventAuthSuccess     = "AUTH_SUCCESS"
 	EventAuthFailure     = "AUTH_FAILURE"
+	EventClientAllowed   = "CLIENT_ALLOWED"
+	EventClientRejected  = "CLIENT_REJECTED"
 )
 
 // Eventos de Quota
@@ -33,8 +49,14 @@ const (
 
 // Eventos de Métricas
 const (
-	EventMetricsRecord = "METRICS_RECORD"
-	EventCostCalculate = "COST_CALCULATE"
+	EventMetricsRecord  = "METRICS_RECORD"
+	EventCostCalculate  = "COST_CALCULATE"
+	EventMetricsCapture = "METRICS_CAPTURE"
+	EventUsageAnomaly   = "USAGE_ANOMALY"
+
+	EventCostCeilingClamped = "COST_CEILING_CLAMPED"
+	EventCostCeilingSkipped = "COST_CEILING_SKIPPED"
+	EventPricingFallback    = "PRICING_DEFAULT_MODEL_APPLIED"
 )
 
 // Eventos de Base de Datos
@@ -46,13 +68,25 @@ const (
 
 // Eventos de Cache
 const (
-	EventCacheRead  = "CACHE_READ"
-	EventCacheWrite = "CACHE_WRITE"
+	EventCacheRead         = "CACHE_READ"
+	EventCacheWrite        = "CACHE_WRITE"
+	EventCachePointDropped = "CACHE_POINT_DROPPED"
+)
+
+// Eventos de contexto
+const (
+	EventContextTrimmed = "CONTEXT_TRIMMED"
 )
 
 // Eventos de Sistema
 const (
-	EventLoggerInit     = "LOGGER_INIT"
-	EventServerStart    = "SERVER_START"
-	EventServerShutdown = "SERVER_SHUTDOWN"
+	EventLoggerInit        = "LOGGER_INIT"
+	EventServerStart       = "SERVER_START"
+	EventServerShutdown    = "SERVER_SHUTDOWN"
+	EventMaintenanceSet    = "MAINTENANCE_MODE_CHANGED"
+	EventAdminConfigExport = "ADMIN_CONFIG_EXPORT"
+
+	EventCredentialsInvalid = "CREDENTIALS_INVALID"
+	EventCredentialsValid   = "CREDENTIALS_VALID"
+	EventCredentialsCheck   = "CREDENTIALS_CHECK"
 )
\ No newline at end of file
@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// Cabeceras de deduplicación por idempotency key
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// DefaultIdempotencyMaxBodyBytes es el tamaño máximo por defecto de una respuesta guardada por Idempotency-Key
+const DefaultIdempotencyMaxBodyBytes = 1 << 20
+
+// idempotentResponse es una respuesta completa (status, cabeceras y body) de una request con
+// Idempotency-Key. done se cierra cuando la request original termina; replayable indica si se
+// puede replicar (falso si el handler hizo panic, el cliente se desconectó, el stream no llegó a
+// message_stop o la respuesta superó el límite de tamaño). bodyHash es el hash del body de la request
+type idempotentResponse struct {
+	done       chan struct{}
+	bodyHash   string
+	replayable bool
+	statusCode int
+	header     http.Header
+	body       []byte
+	expires    time.Time
+}
+
+// idempotencyCache deduplica requests por (usuario, Idempotency-Key): la primera invoca a Bedrock
+// y su respuesta se reutiliza durante el TTL. Las duplicadas concurrentes esperan a la original
+// (single-flight) en lugar de lanzar otra generación, así que las métricas se registran una vez
+type idempotencyCache struct {
+	ttl     time.Duration
+	maxBody int // Tamaño máximo de body guardado (0 = sin límite)
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+}
+
+func newIdempotencyCache(ttl time.Duration, maxBody int) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		maxBody: maxBody,
+		now:     time.Now,
+		entries: make(map[string]*idempotentResponse),
+	}
+}
+
+// serve atiende la request con handler o, si ya hay una con la misma clave, replica su respuesta.
+// Las respuestas 429 y 5xx no se guardan para que el reintento vuelva a llegar a Bedrock, ni las que
+// superan maxBody o quedaron incompletas. Si la original no se puede replicar, la duplicada se atiende
+// de nuevo. Reutilizar la clave con otro body es un error del cliente (422), no un duplicado
+func (c *idempotencyCache) serve(w http.ResponseWriter, r *http.Request, userID, key string, handler http.HandlerFunc) {
+	cacheKey := userID + "\x00" + key
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrorResponse(w, ErrCodeRequestReadFailed, "Failed to read request body")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	bodyHash := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	entry, ok := c.entries[cacheKey]
+	if ok && !entry.expires.IsZero() && c.now().After(entry.expires) {
+		delete(c.entries, cacheKey)
+		ok = false
+	}
+	if !ok {
+		c.purgeExpiredLocked()
+		entry = &idempotentResponse{done: make(chan struct{}), bodyHash: bodyHash}
+		c.entries[cacheKey] = entry
+	}
+	c.mu.Unlock()
+
+	if ok && entry.bodyHash != bodyHash {
+		writeErrorResponse(w, ErrCodeIdempotencyKeyReused, "Idempotency-Key was already used with a different request body")
+		return
+	}
+	if ok {
+		select {
+		case <-entry.done:
+		case <-r.Context().Done():
+			return
+		}
+		if !entry.replayable {
+			c.serve(w, r, userID, key, handler)
+			return
+		}
+		replayIdempotentResponse(w, r, entry, key)
+		return
+	}
+
+	// La entrada se cierra aunque el handler haga panic: si no, las duplicadas esperarían para siempre
+	// y la entrada (sin expires) no se purgaría nunca
+	recorder := &idempotencyRecorder{ResponseWriter: w, statusCode: http.StatusOK, maxBody: c.maxBody}
+	defer func() {
+		c.mu.Lock()
+		if !entry.replayable || entry.statusCode == http.StatusTooManyRequests || entry.statusCode >= 500 {
+			delete(c.entries, cacheKey)
+		} else {
+			entry.expires = c.now().Add(c.ttl)
+		}
+		c.mu.Unlock()
+		close(entry.done)
+	}()
+
+	handler(recorder, r)
+
+	entry.statusCode = recorder.statusCode
+	entry.header = recorder.Header().Clone()
+	entry.body = recorder.body.Bytes()
+	entry.replayable = !recorder.overflow && r.Context().Err() == nil && recorder.complete()
+}
+
+// purgeExpiredLocked elimina las respuestas caducadas (requiere c.mu)
+func (c *idempotencyCache) purgeExpiredLocked() {
+	now := c.now()
+	for k, entry := range c.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// replayIdempotentResponse escribe la respuesta guardada sin invocar a Bedrock
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, entry *idempotentResponse, key string) {
+	Logger.InfoContext(r.Context(), amslog.Event{
+		Name:    EventIdempotentReplay,
+		Message: "Duplicate request served from idempotency cache",
+		Fields: map[string]interface{}{
+			"idempotency.key":           key,
+			"http.response.status_code": entry.statusCode,
+		},
+	})
+
+	for k, v := range entry.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(IdempotencyReplayedHeader, "true")
+	w.WriteHeader(entry.statusCode)
+	w.Write(entry.body)
+}
+
+// idempotencyRecorder envía la respuesta al cliente (incluidos los flush del streaming SSE)
+// mientras guarda una copia para las requests duplicadas. Si la copia supera maxBody se descarta
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	maxBody     int
+	overflow    bool
+	body        bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(statusCode int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *idempotencyRecorder) Write(data []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if rec.maxBody > 0 && rec.body.Len()+len(data) > rec.maxBody {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(data)
+		}
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+// complete indica si la respuesta guardada está entera: un stream SSE debe llegar a message_stop sin
+// ningún evento de error (un stream cortado o fallido a mitad también termina con status 200)
+func (rec *idempotencyRecorder) complete() bool {
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/event-stream") {
+		return true
+	}
+	body := rec.body.String()
+	return strings.Contains(body, "event: message_stop\n") && !strings.Contains(body, "event: error\n")
+}
+
+func (rec *idempotencyRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// idempotencyKey devuelve el usuario y la Idempotency-Key de la request si la deduplicación aplica
+func (this *BedrockClient) idempotencyKey(r *http.Request) (string, string, bool) {
+	if this.idempotency == nil {
+		return "", "", false
+	}
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return "", "", false
+	}
+	user, err := auth.GetUserFromContext(r.Context())
+	if err != nil || user == nil {
+		return "", "", false
+	}
+	return user.UserID, key, true
+}
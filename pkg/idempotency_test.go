@@ -0,0 +1,265 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// newIdempotentRequest crea una request autenticada del usuario con la Idempotency-Key dada
+func newIdempotentRequest(userID, key string) *http.Request {
+	req := newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "hola"}]}`)
+	req.Header.Set(IdempotencyKeyHeader, key)
+	user := auth.UserContext{UserID: userID, DefaultInferenceProfile: "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"}
+	return req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, user))
+}
+
+func TestHandleProxyIdempotencyKeyDeduplicates(t *testing.T) {
+	setupTestLogger(t)
+
+	var calls atomic.Int32
+	client := newBatchTestClient(t, &calls)
+	client.idempotency = newIdempotencyCache(time.Minute, DefaultIdempotencyMaxBodyBytes)
+
+	first := httptest.NewRecorder()
+	client.HandleProxy(first, newIdempotentRequest("user-1", "retry-1"))
+	second := httptest.NewRecorder()
+	client.HandleProxy(second, newIdempotentRequest("user-1", "retry-1"))
+
+	if calls.Load() != 1 {
+		t.Fatalf("Expected a single Bedrock invocation, got %d", calls.Load())
+	}
+	if second.Body.String() != first.Body.String() || second.Code != first.Code {
+		t.Errorf("Expected replayed response to match original:\n%s\n---\n%s", first.Body.String(), second.Body.String())
+	}
+	if second.Header().Get(IdempotencyReplayedHeader) != "true" || first.Header().Get(IdempotencyReplayedHeader) != "" {
+		t.Error("Expected only the replayed response to carry the replay header")
+	}
+
+	// Otro usuario con la misma clave no comparte respuesta
+	client.HandleProxy(httptest.NewRecorder(), newIdempotentRequest("user-2", "retry-1"))
+	if calls.Load() != 2 {
+		t.Errorf("Expected keys to be scoped per user, got %d invocations", calls.Load())
+	}
+}
+
+func TestIdempotencyConcurrentDuplicatesSingleFlight(t *testing.T) {
+	setupTestLogger(t)
+
+	cache := newIdempotencyCache(time.Minute, DefaultIdempotencyMaxBodyBytes)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("generated"))
+	}
+
+	const duplicates = 5
+	recorders := make([]*httptest.ResponseRecorder, duplicates)
+	var wg sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			cache.serve(rec, newIdempotentRequest("user-1", "k"), "user-1", "k", handler)
+		}(recorders[i])
+	}
+
+	// Dar tiempo a que todas las duplicadas lleguen mientras la original sigue en curso
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("Expected handler to run once for concurrent duplicates, got %d", calls.Load())
+	}
+	for i, rec := range recorders {
+		if rec.Body.String() != "generated" || rec.Code != http.StatusOK {
+			t.Errorf("Duplicate %d got %d %q", i, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestIdempotencyExpiryAndErrors(t *testing.T) {
+	setupTestLogger(t)
+
+	cache := newIdempotencyCache(time.Minute, DefaultIdempotencyMaxBodyBytes)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	var calls atomic.Int32
+	status := http.StatusInternalServerError
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(status)
+	}
+	serve := func() {
+		cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", handler)
+	}
+
+	// Los errores 5xx no se guardan: el reintento vuelve a ejecutarse
+	serve()
+	status = http.StatusOK
+	serve()
+	serve()
+	if calls.Load() != 2 {
+		t.Fatalf("Expected failed response not to be cached, got %d calls", calls.Load())
+	}
+
+	// Pasado el TTL la clave se vuelve a ejecutar
+	now = now.Add(2 * time.Minute)
+	serve()
+	if calls.Load() != 3 {
+		t.Errorf("Expected expired entry to be re-executed, got %d calls", calls.Load())
+	}
+}
+
+func TestIdempotencyPanicReleasesEntry(t *testing.T) {
+	setupTestLogger(t)
+
+	cache := newIdempotencyCache(time.Minute, DefaultIdempotencyMaxBodyBytes)
+	var calls atomic.Int32
+	panicking := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		panic("handler failed")
+	}
+
+	func() {
+		defer func() { recover() }()
+		cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", panicking)
+	}()
+
+	cache.mu.Lock()
+	remaining := len(cache.entries)
+	cache.mu.Unlock()
+	if remaining != 0 {
+		t.Fatalf("Expected the entry of a panicked request to be removed, got %d entries", remaining)
+	}
+
+	// El reintento se ejecuta de nuevo en lugar de esperar a una request que nunca termina
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected retry after a panic not to block")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected the retry to run the handler, got %d calls", calls.Load())
+	}
+}
+
+func TestIdempotencySkipsOversizedResponses(t *testing.T) {
+	setupTestLogger(t)
+
+	cache := newIdempotencyCache(time.Minute, 8)
+	var calls atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("event: ping\n\n"))
+	}
+
+	first := httptest.NewRecorder()
+	cache.serve(first, newIdempotentRequest("user-1", "k"), "user-1", "k", handler)
+	if first.Body.String() != "event: ping\n\n" {
+		t.Errorf("Expected the full response to reach the client, got %q", first.Body.String())
+	}
+	cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", handler)
+	if calls.Load() != 2 {
+		t.Errorf("Expected a response above the size limit not to be cached, got %d calls", calls.Load())
+	}
+}
+
+func TestIdempotencySkipsIncompleteStreams(t *testing.T) {
+	setupTestLogger(t)
+
+	fixtures := []struct {
+		name string
+		body string
+	}{
+		{"truncated", "event: message_start\ndata: {}\n\n"},
+		{"error midway", "event: message_start\ndata: {}\n\nevent: error\ndata: {}\n\nevent: message_stop\ndata: {}\n\n"},
+	}
+	for _, fixture := range fixtures {
+		cache := newIdempotencyCache(time.Minute, DefaultIdempotencyMaxBodyBytes)
+		var calls atomic.Int32
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(fixture.body))
+		}
+
+		cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", handler)
+		cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", handler)
+		if calls.Load() != 2 {
+			t.Errorf("%s: expected an incomplete stream not to be replayed, got %d calls", fixture.name, calls.Load())
+		}
+	}
+
+	// Un stream completo sí se replica
+	cache := newIdempotencyCache(time.Minute, DefaultIdempotencyMaxBodyBytes)
+	var calls atomic.Int32
+	complete := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: message_start\ndata: {}\n\nevent: message_stop\ndata: {}\n\n"))
+	}
+	cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", complete)
+	cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", complete)
+	if calls.Load() != 1 {
+		t.Errorf("Expected a complete stream to be replayed, got %d calls", calls.Load())
+	}
+}
+
+func TestIdempotencySkipsDisconnectedClients(t *testing.T) {
+	setupTestLogger(t)
+
+	cache := newIdempotencyCache(time.Minute, DefaultIdempotencyMaxBodyBytes)
+	var calls atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("partial"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k").WithContext(ctx), "user-1", "k", handler)
+	cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", handler)
+	if calls.Load() != 2 {
+		t.Errorf("Expected the response of a disconnected client not to be replayed, got %d calls", calls.Load())
+	}
+}
+
+func TestIdempotencyKeyReusedWithDifferentBody(t *testing.T) {
+	setupTestLogger(t)
+
+	cache := newIdempotencyCache(time.Minute, DefaultIdempotencyMaxBodyBytes)
+	var calls atomic.Int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("generated"))
+	}
+	cache.serve(httptest.NewRecorder(), newIdempotentRequest("user-1", "k"), "user-1", "k", handler)
+
+	other := newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "adiós"}]}`)
+	other.Header.Set(IdempotencyKeyHeader, "k")
+	rec := httptest.NewRecorder()
+	cache.serve(rec, other, "user-1", "k", handler)
+	if rec.Code != http.StatusUnprocessableEntity || calls.Load() != 1 {
+		t.Errorf("Expected 422 without invoking the handler, got %d (%d calls): %s", rec.Code, calls.Load(), rec.Body.String())
+	}
+}
@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// DefaultLegacyUserID es el usuario con el que se atienden las requests en modo legacy
+const DefaultLegacyUserID = "legacy"
+
+// LegacyModeConfig configura el modo legacy: sin base de datos no hay autenticación JWT ni cuotas,
+// y /v1/messages usa un inference profile fijo para todas las requests
+type LegacyModeConfig struct {
+	InferenceProfile string // Inference profile (ARN o ID) usado para todas las requests
+	UserID           string // Usuario que aparece en los logs
+}
+
+// LoadLegacyModeConfigWithEnv carga LEGACY_INFERENCE_PROFILE y LEGACY_USER_ID
+func LoadLegacyModeConfigWithEnv() LegacyModeConfig {
+	return LegacyModeConfig{
+		InferenceProfile: os.Getenv("LEGACY_INFERENCE_PROFILE"),
+		UserID:           getEnvOrDefault("LEGACY_USER_ID", DefaultLegacyUserID),
+	}
+}
+
+// Validate comprueba que el modo legacy puede atender requests: sin inference profile por defecto
+// HandleProxy rechazaría todas con 403, así que es preferible no arrancar
+func (c LegacyModeConfig) Validate() error {
+	if c.InferenceProfile == "" {
+		return errors.New("authentication is disabled (no database) and LEGACY_INFERENCE_PROFILE is not set: " +
+			"/v1/messages would reject every request. Configure the database for JWT authentication " +
+			"or set LEGACY_INFERENCE_PROFILE to serve unauthenticated requests")
+	}
+	return nil
+}
+
+// LegacyUserMiddleware añade al contexto el usuario del modo legacy con el inference profile configurado
+func LegacyUserMiddleware(config LegacyModeConfig) func(http.Handler) http.Handler {
+	user := auth.UserContext{
+		UserID:                  config.UserID,
+		DefaultInferenceProfile: config.InferenceProfile,
+	}
+	if user.UserID == "" {
+		user.UserID = DefaultLegacyUserID
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), auth.UserContextKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
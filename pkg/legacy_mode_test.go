@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newLegacyRequest crea una request sin JWT, como llega en modo legacy
+func newLegacyRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"stream": true, "messages": [{"role": "user", "content": "hola"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestLegacyModeWithDefaultProfile(t *testing.T) {
+	setupTestLogger(t)
+	t.Setenv("LEGACY_INFERENCE_PROFILE", "eu.anthropic.claude-sonnet-4-5-20250929-v1:0")
+	t.Setenv("LEGACY_USER_ID", "")
+
+	config := LoadLegacyModeConfigWithEnv()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected legacy config with profile to be valid, got %v", err)
+	}
+	if config.UserID != DefaultLegacyUserID {
+		t.Errorf("Expected default legacy user, got %q", config.UserID)
+	}
+
+	var calls atomic.Int32
+	client := newBatchTestClient(t, &calls)
+	handler := LegacyUserMiddleware(config)(http.HandlerFunc(client.HandleProxy))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newLegacyRequest())
+
+	if rec.Code != http.StatusOK || calls.Load() != 1 {
+		t.Errorf("Expected request to reach Bedrock without JWT, got %d (%d calls): %s", rec.Code, calls.Load(), rec.Body.String())
+	}
+}
+
+func TestLegacyModeWithoutDefaultProfile(t *testing.T) {
+	setupTestLogger(t)
+	t.Setenv("LEGACY_INFERENCE_PROFILE", "")
+
+	err := LoadLegacyModeConfigWithEnv().Validate()
+	if err == nil || !strings.Contains(err.Error(), "LEGACY_INFERENCE_PROFILE") {
+		t.Fatalf("Expected descriptive startup error, got %v", err)
+	}
+
+	// Sin el middleware legacy la request no tiene usuario y HandleProxy la rechaza
+	rec := httptest.NewRecorder()
+	newTestBedrockClient().HandleProxy(rec, newLegacyRequest())
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without inference profile, got %d", rec.Code)
+	}
+}
+
+func TestDatabaseConnectionConfigConfigured(t *testing.T) {
+	for _, envVar := range []string{"DB_SECRET_ARN", "DB_HOST", "DB_USER", "DB_PASSWORD"} {
+		t.Setenv(envVar, "")
+	}
+	if LoadDatabaseConnectionConfig().Configured() {
+		t.Error("Expected no database configured without DB_* variables (legacy mode)")
+	}
+
+	// Con la BD configurada el modo legacy no aplica: si no conecta, el proxy no arranca
+	t.Setenv("DB_HOST", "db.internal")
+	if !LoadDatabaseConnectionConfig().Configured() {
+		t.Error("Expected DB_HOST to mark the database as configured")
+	}
+	t.Setenv("DB_HOST", "")
+	t.Setenv("DB_SECRET_ARN", "arn:aws:secretsmanager:eu-west-1:123456789012:secret:db")
+	if !LoadDatabaseConnectionConfig().Configured() {
+		t.Error("Expected DB_SECRET_ARN to mark the database as configured")
+	}
+}
@@ -2,6 +2,8 @@ package pkg
 
 import (
 	"os"
+	"strconv"
+	"strings"
 
 	"bedrock-proxy-test/pkg/amslog"
 )
@@ -27,6 +29,13 @@ func InitLogger() {
 		Output:             os.Stdout, // Escribir a stdout para CloudWatch
 		Async:              true,
 		BufferSize:         10000,
+		SampleRate:         getLogSampleRate(),
+		SampledEvents:      getLogSampledEvents(),
+		SanitizeMaxDepth:   getEnvInt("LOG_SANITIZE_MAX_DEPTH", amslog.DefaultSanitizeMaxDepth),
+		SanitizeMaxFields:  getEnvInt("LOG_SANITIZE_MAX_FIELDS", amslog.DefaultSanitizeMaxFields),
+		OnOutputFailure:    amslog.OutputFailureMode(getEnv("LOG_OUTPUT_FAILURE_MODE", string(amslog.OutputFailureFallback))),
+		FallbackOutput:     os.Stderr,
+		MaxOutputFailures:  getEnvInt("LOG_MAX_OUTPUT_FAILURES", amslog.DefaultMaxOutputFailures),
 	}
 
 	Logger = amslog.NewLogger(config)
@@ -60,6 +69,38 @@ func getLogLevel() amslog.LogLevel {
 	}
 }
 
+// defaultSampledEvents son los eventos INFO por request de mayor volumen
+var defaultSampledEvents = []string{
+	EventProxyRequestStart,
+	EventProxyRequestEnd,
+	"BEDROCK_SIGN_REQUEST",
+}
+
+// getLogSampleRate obtiene N de LOG_SAMPLE_RATE (1 de cada N eventos; 1 = sin muestreo)
+func getLogSampleRate() int {
+	rate, err := strconv.Atoi(getEnv("LOG_SAMPLE_RATE", "1"))
+	if err != nil || rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// getLogSampledEvents obtiene la lista de eventos muestreables de LOG_SAMPLED_EVENTS (separados por comas)
+func getLogSampledEvents() []string {
+	raw := os.Getenv("LOG_SAMPLED_EVENTS")
+	if raw == "" {
+		return defaultSampledEvents
+	}
+
+	var events []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			events = append(events, name)
+		}
+	}
+	return events
+}
+
 // getEnv obtiene una variable de entorno con valor por defecto
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -68,6 +109,15 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt obtiene una variable de entorno entera positiva con valor por defecto
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < 1 {
+		return defaultValue
+	}
+	return value
+}
+
 // CloseLogger cierra el logger y espera a que se procesen logs pendientes
 func CloseLogger() {
 	if Logger != nil {
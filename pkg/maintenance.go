@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// MaintenanceRetryAfterSeconds es el Retry-After que se devuelve mientras el modo mantenimiento está activo
+const MaintenanceRetryAfterSeconds = 120
+
+// IsMaintenanceMode indica si el proxy está en modo mantenimiento
+func (this *BedrockClient) IsMaintenanceMode() bool {
+	return this.maintenance.Load()
+}
+
+// SetMaintenanceMode activa o desactiva el modo mantenimiento y devuelve el estado anterior
+func (this *BedrockClient) SetMaintenanceMode(enabled bool) bool {
+	return this.maintenance.Swap(enabled)
+}
+
+// writeMaintenanceResponse responde 503 con Retry-After a las requests de modelo
+func writeMaintenanceResponse(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(MaintenanceRetryAfterSeconds))
+	writeErrorResponse(w, ErrCodeMaintenanceMode, "Service under maintenance, please retry later")
+}
+
+// maintenanceStatus es el body de GET/POST /admin/maintenance
+type maintenanceStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+// HandleMaintenance consulta (GET) o conmuta (POST {"enabled": bool}) el modo mantenimiento en runtime
+func (this *BedrockClient) HandleMaintenance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var status maintenanceStatus
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			writeErrorResponse(w, ErrCodeInvalidJSON, "Failed to parse request: "+err.Error())
+			return
+		}
+
+		previous := this.SetMaintenanceMode(status.Enabled)
+		userID := ""
+		if user, err := auth.GetUserFromContext(ctx); err == nil {
+			userID = user.UserID
+		}
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventMaintenanceSet,
+			Message: "Maintenance mode changed",
+			Fields: map[string]interface{}{
+				"maintenance.enabled":  status.Enabled,
+				"maintenance.previous": previous,
+				"user.id":              userID,
+			},
+		})
+	default:
+		writeErrorResponse(w, ErrCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	json.NewEncoder(w).Encode(maintenanceStatus{Enabled: this.IsMaintenanceMode()})
+}
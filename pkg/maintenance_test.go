@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceModeTogglesProxyButNotAdmin(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+	}))
+	proxyBody := `{"stream": true, "messages": [{"role": "user", "content": "hola"}]}`
+
+	setMaintenance := func(body string) {
+		t.Helper()
+		req := newTestProxyRequest(body)
+		req.URL.Path = "/admin/maintenance"
+		rec := httptest.NewRecorder()
+		client.HandleMaintenance(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected admin toggle to succeed, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	setMaintenance(`{"enabled": true}`)
+	if !client.IsMaintenanceMode() {
+		t.Fatal("Expected maintenance mode to be enabled")
+	}
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(proxyBody))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 in maintenance mode, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header in maintenance response")
+	}
+	if !strings.Contains(rec.Body.String(), "maintenance") {
+		t.Errorf("Expected maintenance message, got %s", rec.Body.String())
+	}
+
+	// Los endpoints de administración siguen funcionando
+	rec = httptest.NewRecorder()
+	client.HandlePreview(rec, newTestProxyRequest(testPreviewBody))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected admin preview to keep working, got %d", rec.Code)
+	}
+
+	setMaintenance(`{"enabled": false}`)
+	rec = httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(proxyBody))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 after disabling maintenance mode, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleMaintenanceStatus(t *testing.T) {
+	client := newTestBedrockClient()
+	client.SetMaintenanceMode(true)
+
+	rec := httptest.NewRecorder()
+	client.HandleMaintenance(rec, httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"enabled":true`) {
+		t.Errorf("Expected enabled status, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	client.HandleMaintenance(rec, httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader("not json")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid body, got %d", rec.Code)
+	}
+	if !client.IsMaintenanceMode() {
+		t.Error("Expected invalid request to leave maintenance mode unchanged")
+	}
+}
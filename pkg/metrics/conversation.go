@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"bedrock-proxy-test/pkg/database"
+)
+
+// ConversationCostSummary contiene el uso y coste agregado de una conversación multi-turno
+// Los costes de caché se atribuyen al turno que los generó: la escritura al turno que crea
+// el prefijo cacheado y la lectura (con descuento) a cada turno posterior que lo reutiliza
+type ConversationCostSummary struct {
+	ConversationID   string
+	Turns            int
+	InputTokens      int64
+	OutputTokens     int64
+	CacheReadTokens  int64
+	CacheWriteTokens int64
+	InputCost        float64
+	OutputCost       float64
+	CacheReadCost    float64
+	CacheWriteCost   float64
+	TotalCost        float64
+	CacheSavings     float64 // Ahorro de las lecturas de caché frente a pagarlas como input normal
+	TurnCosts        []float64
+}
+
+// AggregateConversationCost suma los turnos de una conversación recalculando el desglose de costes
+// Si un turno no tiene pricing conocido se usa el coste almacenado sin desglose
+func AggregateConversationCost(conversationID string, turns []database.UsageTrackingData, resolver *ModelResolver) *ConversationCostSummary {
+	summary := &ConversationCostSummary{
+		ConversationID: conversationID,
+		Turns:          len(turns),
+		TurnCosts:      make([]float64, 0, len(turns)),
+	}
+
+	for _, turn := range turns {
+		summary.InputTokens += int64(turn.TokensInput)
+		summary.OutputTokens += int64(turn.TokensOutput)
+		summary.CacheReadTokens += int64(turn.TokensCacheRead)
+		summary.CacheWriteTokens += int64(turn.TokensCacheCreation)
+
+		breakdown, err := CalculateCacheCostBreakdown(
+			turn.ModelID,
+			int64(turn.TokensInput),
+			int64(turn.TokensOutput),
+			int64(turn.TokensCacheRead),
+			int64(turn.TokensCacheCreation),
+			resolver,
+		)
+		if err != nil {
+			// Sin pricing (p.ej. errores tempranos con model_id "unknown"): usar coste almacenado
+			summary.TotalCost += turn.CostUSD
+			summary.TurnCosts = append(summary.TurnCosts, turn.CostUSD)
+			continue
+		}
+
+		summary.InputCost += breakdown.InputCost
+		summary.OutputCost += breakdown.OutputCost
+		summary.CacheReadCost += breakdown.CacheReadCost
+		summary.CacheWriteCost += breakdown.CacheWriteCost
+		summary.TotalCost += breakdown.TotalCost
+		summary.TurnCosts = append(summary.TurnCosts, breakdown.TotalCost)
+
+		// Ahorro: lo que habrían costado las lecturas de caché como input normal
+		if turn.TokensCacheRead > 0 {
+			if asInput, err := CalculateCacheCostBreakdown(turn.ModelID, int64(turn.TokensCacheRead), 0, 0, 0, resolver); err == nil {
+				summary.CacheSavings += asInput.InputCost - breakdown.CacheReadCost
+			}
+		}
+	}
+
+	return summary
+}
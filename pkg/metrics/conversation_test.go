@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestAggregateConversationCostTwoTurns(t *testing.T) {
+	model := "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"
+	start := time.Now()
+
+	// Turno 1: escribe 10000 tokens en caché
+	// Turno 2: reutiliza esos 10000 tokens desde caché
+	turns := []database.UsageTrackingData{
+		{
+			ModelID:             model,
+			RequestTimestamp:    start,
+			TokensInput:         100,
+			TokensOutput:        500,
+			TokensCacheCreation: 10000,
+			ConversationID:      "conv-1",
+		},
+		{
+			ModelID:          model,
+			RequestTimestamp: start.Add(time.Minute),
+			TokensInput:      200,
+			TokensOutput:     300,
+			TokensCacheRead:  10000,
+			ConversationID:   "conv-1",
+		},
+	}
+
+	summary := AggregateConversationCost("conv-1", turns, nil)
+
+	if summary.Turns != 2 {
+		t.Errorf("Expected 2 turns, got %d", summary.Turns)
+	}
+	if summary.InputTokens != 300 || summary.OutputTokens != 800 {
+		t.Errorf("Expected 300/800 input/output tokens, got %d/%d", summary.InputTokens, summary.OutputTokens)
+	}
+	if summary.CacheWriteTokens != 10000 || summary.CacheReadTokens != 10000 {
+		t.Errorf("Expected 10000/10000 cache write/read tokens, got %d/%d", summary.CacheWriteTokens, summary.CacheReadTokens)
+	}
+
+	// Cache write: 10 * 0.00375, cache read: 10 * 0.0003
+	if !almostEqual(summary.CacheWriteCost, 0.0375) {
+		t.Errorf("Expected cache write cost 0.0375, got %v", summary.CacheWriteCost)
+	}
+	if !almostEqual(summary.CacheReadCost, 0.003) {
+		t.Errorf("Expected cache read cost 0.003, got %v", summary.CacheReadCost)
+	}
+
+	turn1 := 0.1*0.003 + 0.5*0.015 + 10*0.00375
+	turn2 := 0.2*0.003 + 0.3*0.015 + 10*0.0003
+	if !almostEqual(summary.TurnCosts[0], turn1) || !almostEqual(summary.TurnCosts[1], turn2) {
+		t.Errorf("Expected turn costs [%v %v], got %v", turn1, turn2, summary.TurnCosts)
+	}
+	if !almostEqual(summary.TotalCost, turn1+turn2) {
+		t.Errorf("Expected total cost %v, got %v", turn1+turn2, summary.TotalCost)
+	}
+
+	// Ahorro: 10000 tokens a precio de input (0.03) menos coste de lectura (0.003)
+	if !almostEqual(summary.CacheSavings, 0.027) {
+		t.Errorf("Expected cache savings 0.027, got %v", summary.CacheSavings)
+	}
+}
+
+func TestAggregateConversationCostUnknownModel(t *testing.T) {
+	turns := []database.UsageTrackingData{
+		{ModelID: "unknown", CostUSD: 0.5},
+	}
+
+	summary := AggregateConversationCost("conv-2", turns, nil)
+	if !almostEqual(summary.TotalCost, 0.5) {
+		t.Errorf("Expected stored cost to be used for unpriced turn, got %v", summary.TotalCost)
+	}
+}
@@ -2,6 +2,8 @@ package metrics
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -11,6 +13,8 @@ type ModelPricing struct {
 	OutputPer1KTokens     float64 // Precio por 1000 tokens de output
 	CacheWritePer1KTokens float64 // Precio por 1000 tokens de cache write (5m)
 	CacheReadPer1KTokens  float64 // Precio por 1000 tokens de cache read
+	NoPromptCaching       bool    // El modelo no soporta prompt caching: nunca se estima coste de caché
+	InputIncludesCache    bool    // El input reportado ya incluye los tokens de caché (se descuentan al facturar)
 }
 
 // PricingTable contiene los precios de todos los modelos Bedrock
@@ -64,8 +68,8 @@ var PricingTable = map[string]ModelPricing{
 	},
 
 	// Application Inference Profiles (ARNs) - Claude Sonnet 4.5
-	// TODO FUTURO: Implementar mapeo automático basado en el nombre del Application Profile
-	// Ver MEJORA_FUTURA_MAPEO_ARN_PRICING.md para detalles de la implementación propuesta
+	// Los profiles nuevos no necesitan entrada: ModelResolver los mapea a su modelo base
+	// (PRICING_PROFILE_MODELS o BD) y, si no, se aplica PRICING_DEFAULT_MODEL
 	"arn:aws:bedrock:eu-west-1:701055077130:application-inference-profile/hjy3duh3aoos": {
 		InputPer1KTokens:      0.003,   // $3 per 1M input tokens (Claude Sonnet 4.5)
 		OutputPer1KTokens:     0.015,   // $15 per 1M output tokens
@@ -89,66 +93,84 @@ var PricingTable = map[string]ModelPricing{
 	"amazon.titan-text-express-v1": {
 		InputPer1KTokens:  0.0002,  // $0.20 per 1M input tokens
 		OutputPer1KTokens: 0.0006,  // $0.60 per 1M output tokens
+		NoPromptCaching:   true,
 	},
 	"amazon.titan-text-lite-v1": {
 		InputPer1KTokens:  0.00015, // $0.15 per 1M input tokens
 		OutputPer1KTokens: 0.0002,  // $0.20 per 1M output tokens
+		NoPromptCaching:   true,
 	},
 	"amazon.titan-text-premier-v1:0": {
 		InputPer1KTokens:  0.0005, // $0.50 per 1M input tokens
 		OutputPer1KTokens: 0.0015, // $1.50 per 1M output tokens
+		NoPromptCaching:   true,
 	},
 
 	// AI21 Labs Jurassic
 	"ai21.j2-ultra-v1": {
 		InputPer1KTokens:  0.0188, // $18.80 per 1M tokens
 		OutputPer1KTokens: 0.0188, // $18.80 per 1M tokens
+		NoPromptCaching:   true,
 	},
 	"ai21.j2-mid-v1": {
 		InputPer1KTokens:  0.0125, // $12.50 per 1M tokens
 		OutputPer1KTokens: 0.0125, // $12.50 per 1M tokens
+		NoPromptCaching:   true,
 	},
 
 	// Cohere
 	"cohere.command-text-v14": {
 		InputPer1KTokens:  0.0015, // $1.50 per 1M tokens
 		OutputPer1KTokens: 0.002,  // $2.00 per 1M tokens
+		NoPromptCaching:   true,
 	},
 	"cohere.command-light-text-v14": {
 		InputPer1KTokens:  0.0003, // $0.30 per 1M tokens
 		OutputPer1KTokens: 0.0006, // $0.60 per 1M tokens
+		NoPromptCaching:   true,
 	},
 
 	// Meta Llama
 	"meta.llama3-8b-instruct-v1:0": {
 		InputPer1KTokens:  0.0003, // $0.30 per 1M tokens
 		OutputPer1KTokens: 0.0006, // $0.60 per 1M tokens
+		NoPromptCaching:   true,
 	},
 	"meta.llama3-70b-instruct-v1:0": {
 		InputPer1KTokens:  0.00265, // $2.65 per 1M tokens
 		OutputPer1KTokens: 0.0035,  // $3.50 per 1M tokens
+		NoPromptCaching:   true,
 	},
 
 	// Mistral AI
 	"mistral.mistral-7b-instruct-v0:2": {
 		InputPer1KTokens:  0.00015, // $0.15 per 1M tokens
 		OutputPer1KTokens: 0.0002,  // $0.20 per 1M tokens
+		NoPromptCaching:   true,
 	},
 	"mistral.mixtral-8x7b-instruct-v0:1": {
 		InputPer1KTokens:  0.00045, // $0.45 per 1M tokens
 		OutputPer1KTokens: 0.0007,  // $0.70 per 1M tokens
+		NoPromptCaching:   true,
 	},
 	"mistral.mistral-large-2402-v1:0": {
 		InputPer1KTokens:  0.008, // $8 per 1M tokens
 		OutputPer1KTokens: 0.024, // $24 per 1M tokens
+		NoPromptCaching:   true,
 	},
 }
 
 // CalculateCost calcula el coste de un request basado en tokens y modelo
 func CalculateCost(modelID string, inputTokens, outputTokens int64) (float64, error) {
-	pricing, exists := PricingTable[modelID]
-	if !exists {
-		return 0, fmt.Errorf("pricing not found for model: %s", modelID)
+	return CalculateCostWithResolver(modelID, inputTokens, outputTokens, nil)
+}
+
+// CalculateCostWithResolver calcula el coste resolviendo los ARNs de application inference profile
+// al modelo base (o al modelo de pricing por defecto del resolver)
+func CalculateCostWithResolver(modelID string, inputTokens, outputTokens int64, resolver *ModelResolver) (float64, error) {
+	pricing, err := ResolvePricing(modelID, resolver)
+	if err != nil {
+		return 0, err
 	}
 
 	// Calcular coste
@@ -178,6 +200,50 @@ func FormatCost(cost float64) string {
 	return fmt.Sprintf("$%.6f", cost)
 }
 
+// CostFormatOptions contiene las opciones de formato de costes para mostrar a usuarios
+type CostFormatOptions struct {
+	DecimalPlaces int    // Número de decimales (negativo se trata como 0)
+	CurrencyLabel string // Símbolo o etiqueta de moneda (ej: "$", "USD", "€")
+	LabelAsSuffix bool   // Si es true la etiqueta va detrás del importe (ej: "1.50 USD")
+}
+
+// DefaultCostFormatOptions retorna las opciones por defecto (equivalentes a FormatCost)
+func DefaultCostFormatOptions() CostFormatOptions {
+	return CostFormatOptions{
+		DecimalPlaces: 6,
+		CurrencyLabel: "$",
+	}
+}
+
+// FormatCostWithOptions formatea un coste con decimales y etiqueta de moneda configurables
+// Siempre usa notación decimal fija (nunca científica), incluso para costes muy pequeños
+func FormatCostWithOptions(cost float64, opts CostFormatOptions) string {
+	decimals := opts.DecimalPlaces
+	if decimals < 0 {
+		decimals = 0
+	}
+
+	sign := ""
+	if cost < 0 {
+		sign = "-"
+		cost = math.Abs(cost)
+	}
+	amount := strconv.FormatFloat(cost, 'f', decimals, 64)
+
+	// Evitar "-0.00" cuando el redondeo deja el importe a cero
+	if strings.Trim(amount, "0.") == "" {
+		sign = ""
+	}
+
+	if opts.CurrencyLabel == "" {
+		return sign + amount
+	}
+	if opts.LabelAsSuffix {
+		return sign + amount + " " + opts.CurrencyLabel
+	}
+	return sign + opts.CurrencyLabel + amount
+}
+
 // CostBreakdown contiene el desglose de costes
 type CostBreakdown struct {
 	ModelID      string
@@ -209,8 +275,8 @@ func CalculateCostBreakdown(modelID string, inputTokens, outputTokens int64) (*C
 }
 
 // CalculateCostWithCache calcula el coste considerando tokens de caché
-// IMPORTANTE: Según la API de Bedrock:
-// - inputTokens: tokens normales de entrada (NO incluye tokens de caché)
+// IMPORTANTE: Según la API de Bedrock (mismo modelo de facturación que Anthropic):
+// - inputTokens: tokens normales de entrada (NO incluye tokens de caché, salvo InputIncludesCache)
 // - outputTokens: tokens de salida generados
 // - cacheReadTokens: tokens leídos desde caché (separados de inputTokens)
 // - cacheWriteTokens: tokens escritos en caché (separados de inputTokens)
@@ -228,47 +294,118 @@ func CalculateCostWithCache(modelID string, inputTokens, outputTokens, cacheRead
 
 // CalculateCostWithCacheAndResolver calcula el coste con soporte para resolver ARNs
 func CalculateCostWithCacheAndResolver(modelID string, inputTokens, outputTokens, cacheReadTokens, cacheWriteTokens int64, resolver *ModelResolver) (float64, error) {
-	// Si tenemos un resolver y el modelID es un ARN, intentar resolver
-	resolvedModelID := modelID
-	if resolver != nil && strings.HasPrefix(modelID, "arn:aws:bedrock:") {
-		var err error
-		resolvedModelID, err = resolver.ResolveModelID(modelID)
-		if err != nil {
-			// Si falla la resolución, intentar con el ARN directamente
-			// (fallback a la tabla de precios con ARNs)
-			resolvedModelID = modelID
+	breakdown, err := CalculateCacheCostBreakdown(modelID, inputTokens, outputTokens, cacheReadTokens, cacheWriteTokens, resolver)
+	if err != nil {
+		return 0, err
+	}
+	return breakdown.TotalCost, nil
+}
+
+// CacheCostBreakdown contiene el desglose de costes incluyendo tokens de caché
+type CacheCostBreakdown struct {
+	InputCost      float64
+	OutputCost     float64
+	CacheReadCost  float64
+	CacheWriteCost float64
+	TotalCost      float64
+}
+
+// ResolvePricing retorna el pricing de un modelo, resolviendo los ARNs de inference profile si hay resolver.
+// Orden: modelo resuelto, ARN en la tabla y, si el resolver lo tiene configurado, el modelo por defecto
+// (mejor un coste aproximado que un error que deja el coste a 0). Si el resolver tiene el modelo (o el ARN)
+// en PRICING_INPUT_INCLUDES_CACHE, el pricing se devuelve con InputIncludesCache
+func ResolvePricing(modelID string, resolver *ModelResolver) (ModelPricing, error) {
+	resolvedModelID := resolvedPricingModel(modelID, resolver)
+	pricing, err := tablePricing(modelID, resolvedModelID, resolver)
+	if err == nil && resolver != nil && resolver.InputIncludesCache(modelID, resolvedModelID) {
+		pricing.InputIncludesCache = true
+	}
+	return pricing, err
+}
+
+// resolvedPricingModel devuelve el model_id base de un ARN si hay resolver. Si falla la resolución
+// devuelve el ARN, para buscarlo directamente en la tabla de precios
+func resolvedPricingModel(modelID string, resolver *ModelResolver) string {
+	if resolver == nil || !strings.HasPrefix(modelID, "arn:aws:bedrock:") {
+		return modelID
+	}
+	if resolvedModelID, err := resolver.ResolveModelID(modelID); err == nil {
+		return resolvedModelID
+	}
+	return modelID
+}
+
+// tablePricing busca en PricingTable el modelo resuelto, el original y el modelo por defecto del resolver
+func tablePricing(modelID, resolvedModelID string, resolver *ModelResolver) (ModelPricing, error) {
+	if pricing, exists := PricingTable[resolvedModelID]; exists {
+		return pricing, nil
+	}
+	if pricing, exists := PricingTable[modelID]; exists {
+		return pricing, nil
+	}
+	if resolver != nil {
+		if defaultModelID := resolver.DefaultPricingModel(); defaultModelID != "" {
+			if pricing, exists := PricingTable[defaultModelID]; exists {
+				resolver.notifyDefaultPricing(modelID, resolvedModelID, defaultModelID)
+				return pricing, nil
+			}
 		}
 	}
+	return ModelPricing{}, fmt.Errorf("pricing not found for model: %s (resolved from: %s)", resolvedModelID, modelID)
+}
 
-	pricing, exists := PricingTable[resolvedModelID]
-	if !exists {
-		return 0, fmt.Errorf("pricing not found for model: %s (resolved from: %s)", resolvedModelID, modelID)
+// CalculateCacheCostBreakdown calcula el coste desglosado por tipo de token (input, output, cache read, cache write)
+func CalculateCacheCostBreakdown(modelID string, inputTokens, outputTokens, cacheReadTokens, cacheWriteTokens int64, resolver *ModelResolver) (*CacheCostBreakdown, error) {
+	pricing, err := ResolvePricing(modelID, resolver)
+	if err != nil {
+		return nil, err
 	}
 
 	// Calcular costes individuales
-	// NOTA: inputTokens ya son solo los tokens normales, NO incluyen cache
-	inputCost := (float64(inputTokens) / 1000.0) * pricing.InputPer1KTokens
+	// NOTA: salvo InputIncludesCache, inputTokens ya son solo los tokens normales y la caché se suma aparte
+	inputCost := (float64(billableInputTokens(pricing, inputTokens, cacheReadTokens, cacheWriteTokens)) / 1000.0) * pricing.InputPer1KTokens
 	outputCost := (float64(outputTokens) / 1000.0) * pricing.OutputPer1KTokens
 	
 	// Para cache read y write, usar precios específicos si están disponibles
 	// Si no están disponibles (modelos antiguos), usar precio normal de input
+	// Los modelos sin soporte de caching no tienen fallback: sus tokens de caché (siempre 0) no cuestan
 	cacheReadCost := 0.0
-	if pricing.CacheReadPer1KTokens > 0 {
+	switch {
+	case pricing.CacheReadPer1KTokens > 0:
 		cacheReadCost = (float64(cacheReadTokens) / 1000.0) * pricing.CacheReadPer1KTokens
-	} else {
+	case pricing.NoPromptCaching:
+	default:
 		// Fallback: aplicar 10% del precio normal (90% descuento)
 		cacheReadCost = (float64(cacheReadTokens) / 1000.0) * pricing.InputPer1KTokens * 0.1
 	}
 	
 	cacheWriteCost := 0.0
-	if pricing.CacheWritePer1KTokens > 0 {
+	switch {
+	case pricing.CacheWritePer1KTokens > 0:
 		cacheWriteCost = (float64(cacheWriteTokens) / 1000.0) * pricing.CacheWritePer1KTokens
-	} else {
+	case pricing.NoPromptCaching:
+	default:
 		// Fallback: usar precio normal de input
 		cacheWriteCost = (float64(cacheWriteTokens) / 1000.0) * pricing.InputPer1KTokens
 	}
 
-	totalCost := inputCost + outputCost + cacheReadCost + cacheWriteCost
+	return &CacheCostBreakdown{
+		InputCost:      inputCost,
+		OutputCost:     outputCost,
+		CacheReadCost:  cacheReadCost,
+		CacheWriteCost: cacheWriteCost,
+		TotalCost:      inputCost + outputCost + cacheReadCost + cacheWriteCost,
+	}, nil
+}
 
-	return totalCost, nil
+// billableInputTokens devuelve los tokens de input que se facturan a precio normal. Con el modelo de
+// Anthropic (por defecto) la caché se factura además del input. Con InputIncludesCache la caché se descuenta
+// del input, pero si la caché supera al input reportado este no puede incluirla: se factura el input completo
+// en lugar de recortarlo a 0 y ocultar coste real
+func billableInputTokens(pricing ModelPricing, inputTokens, cacheReadTokens, cacheWriteTokens int64) int64 {
+	cachedTokens := cacheReadTokens + cacheWriteTokens
+	if !pricing.InputIncludesCache || cachedTokens > inputTokens {
+		return inputTokens
+	}
+	return inputTokens - cachedTokens
 }
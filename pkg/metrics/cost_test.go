@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormatCostWithOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		cost     float64
+		opts     CostFormatOptions
+		expected string
+	}{
+		{"default matches FormatCost", 0.0123456, DefaultCostFormatOptions(), "$0.012346"},
+		{"two decimals with symbol", 1.5, CostFormatOptions{DecimalPlaces: 2, CurrencyLabel: "$"}, "$1.50"},
+		{"label as suffix", 1.5, CostFormatOptions{DecimalPlaces: 2, CurrencyLabel: "USD", LabelAsSuffix: true}, "1.50 USD"},
+		{"no label", 42, CostFormatOptions{DecimalPlaces: 0}, "42"},
+		{"very small cost without scientific notation", 0.0000003, CostFormatOptions{DecimalPlaces: 8, CurrencyLabel: "$"}, "$0.00000030"},
+		{"very small cost rounded", 0.0000003, CostFormatOptions{DecimalPlaces: 2, CurrencyLabel: "€"}, "€0.00"},
+		{"negative cost", -2.25, CostFormatOptions{DecimalPlaces: 2, CurrencyLabel: "$"}, "-$2.25"},
+		{"negative rounded to zero", -0.001, CostFormatOptions{DecimalPlaces: 2, CurrencyLabel: "$"}, "$0.00"},
+		{"negative decimals treated as zero", 3.7, CostFormatOptions{DecimalPlaces: -1, CurrencyLabel: "$"}, "$4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FormatCostWithOptions(tt.cost, tt.opts)
+			if got != tt.expected {
+				t.Errorf("FormatCostWithOptions(%v, %+v) = %q, expected %q", tt.cost, tt.opts, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatCostUnchanged(t *testing.T) {
+	if got := FormatCost(0.5); got != "$0.500000" {
+		t.Errorf("Expected FormatCost to keep 6 decimals, got %q", got)
+	}
+}
+
+func TestCacheCostSkippedForNonCachingModel(t *testing.T) {
+	// Modelo sin precios de caché y sin soporte de caching: ni con tokens 0 ni con valores espurios hay coste de caché
+	for _, cacheTokens := range []int64{0, 1000} {
+		breakdown, err := CalculateCacheCostBreakdown("meta.llama3-8b-instruct-v1:0", 1000, 1000, cacheTokens, cacheTokens, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if breakdown.CacheReadCost != 0 || breakdown.CacheWriteCost != 0 {
+			t.Errorf("Expected no cache cost with %d cache tokens, got read=%v write=%v", cacheTokens, breakdown.CacheReadCost, breakdown.CacheWriteCost)
+		}
+		if breakdown.TotalCost != breakdown.InputCost+breakdown.OutputCost {
+			t.Errorf("Expected total to be input+output, got %+v", breakdown)
+		}
+	}
+
+	// Modelo con caching pero sin precios específicos: se mantiene el fallback
+	breakdown, err := CalculateCacheCostBreakdown("anthropic.claude-3-5-haiku-20241022-v1:0", 0, 0, 1000, 1000, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if breakdown.CacheReadCost == 0 || breakdown.CacheWriteCost == 0 {
+		t.Errorf("Expected fallback cache cost for caching model, got %+v", breakdown)
+	}
+}
+
+func TestCacheCostBilledOnTopOfInput(t *testing.T) {
+	// Semántica de Anthropic: input_tokens excluye la caché, así que cache_read puede superar al input
+	// y cada tipo de token se factura por separado (Sonnet 4.5: $3 input, $15 output, $0.30 read, $3.75 write)
+	breakdown, err := CalculateCacheCostBreakdown("eu.anthropic.claude-sonnet-4-5-v2:0", 100, 50, 10000, 2000, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string][2]float64{
+		"input":       {breakdown.InputCost, 100 * 3.0 / 1e6},
+		"output":      {breakdown.OutputCost, 50 * 15.0 / 1e6},
+		"cache_read":  {breakdown.CacheReadCost, 10000 * 0.30 / 1e6},
+		"cache_write": {breakdown.CacheWriteCost, 2000 * 3.75 / 1e6},
+	}
+	for name, got := range expected {
+		if math.Abs(got[0]-got[1]) > 1e-12 {
+			t.Errorf("Expected %s cost %v, got %v", name, got[1], got[0])
+		}
+	}
+	if total := 100*3.0/1e6 + 50*15.0/1e6 + 10000*0.30/1e6 + 2000*3.75/1e6; math.Abs(breakdown.TotalCost-total) > 1e-12 {
+		t.Errorf("Expected total %v, got %v", total, breakdown.TotalCost)
+	}
+}
+
+func TestBillableInputTokensWhenInputIncludesCache(t *testing.T) {
+	inclusive := ModelPricing{InputPer1KTokens: 0.003, InputIncludesCache: true}
+
+	if got := billableInputTokens(inclusive, 1000, 600, 100); got != 300 {
+		t.Errorf("Expected cache tokens to be deducted from inclusive input, got %d", got)
+	}
+	// Si la caché supera al input reportado, el input no puede incluirla: no se recorta a 0
+	if got := billableInputTokens(inclusive, 100, 10000, 0); got != 100 {
+		t.Errorf("Expected full input billed when cache exceeds it, got %d", got)
+	}
+	// Por defecto (Anthropic) el input nunca se reduce
+	if got := billableInputTokens(ModelPricing{InputPer1KTokens: 0.003}, 1000, 600, 100); got != 1000 {
+		t.Errorf("Expected input billed in full by default, got %d", got)
+	}
+}
+
+func TestResolvePricingAppliesConfiguredInputIncludesCache(t *testing.T) {
+	arn := "arn:aws:bedrock:eu-west-1:123456789012:application-inference-profile/inclusive01"
+	resolver := NewModelResolver(nil)
+	resolver.SetProfileModels(map[string]string{arn: "anthropic.claude-3-5-haiku-20241022-v1:0"})
+
+	pricing, err := ResolvePricing(arn, resolver)
+	if err != nil || pricing.InputIncludesCache {
+		t.Fatalf("Expected Anthropic billing by default, got %+v (err %v)", pricing, err)
+	}
+
+	// Configurado por el modelo base: aplica también al ARN que resuelve a él
+	resolver.SetInputIncludesCache([]string{"anthropic.claude-3-5-haiku-20241022-v1:0"})
+	if pricing, _ := ResolvePricing(arn, resolver); !pricing.InputIncludesCache {
+		t.Error("Expected InputIncludesCache for a profile resolving to a configured model")
+	}
+	breakdown, err := CalculateCacheCostBreakdown(arn, 1000, 0, 600, 0, resolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := 400 * 0.001 / 1000; math.Abs(breakdown.InputCost-expected) > 1e-12 {
+		t.Errorf("Expected cache deducted from input (%v), got %v", expected, breakdown.InputCost)
+	}
+	if pricing, _ := ResolvePricing("anthropic.claude-3-haiku-20240307-v1:0", resolver); pricing.InputIncludesCache {
+		t.Error("Expected other models to keep Anthropic billing")
+	}
+}
+
+func TestResolvePricingMapsProfileARN(t *testing.T) {
+	arn := "arn:aws:bedrock:eu-west-1:123456789012:application-inference-profile/newprofile01"
+	if _, err := CalculateCost(arn, 1000, 1000); err == nil {
+		t.Fatal("Expected unknown ARN without resolver to fail")
+	}
+
+	resolver := NewModelResolver(nil)
+	resolver.SetProfileModels(map[string]string{arn: "anthropic.claude-3-haiku-20240307-v1:0"})
+	cost, err := CalculateCostWithResolver(arn, 1000, 1000, resolver)
+	if err != nil {
+		t.Fatalf("Expected ARN to resolve to its base model, got %v", err)
+	}
+	if expected := 0.00025 + 0.00125; math.Abs(cost-expected) > 1e-12 {
+		t.Errorf("Expected Haiku pricing %v, got %v", expected, cost)
+	}
+
+	// Los ARNs de la tabla siguen funcionando aunque no estén en el mapa
+	known := "arn:aws:bedrock:eu-west-1:701055077130:application-inference-profile/hjy3duh3aoos"
+	if _, err := ResolvePricing(known, resolver); err != nil {
+		t.Errorf("Expected hardcoded ARN to keep its pricing, got %v", err)
+	}
+}
+
+func TestResolvePricingFallsBackToDefaultModel(t *testing.T) {
+	arn := "arn:aws:bedrock:eu-west-1:123456789012:application-inference-profile/unmapped0001"
+	resolver := NewModelResolver(nil)
+	if _, err := ResolvePricing(arn, resolver); err == nil {
+		t.Fatal("Expected error without default pricing model")
+	}
+
+	resolver.SetDefaultPricingModel("eu.anthropic.claude-sonnet-4-5-20250929-v1:0")
+	pricing, err := ResolvePricing(arn, resolver)
+	if err != nil {
+		t.Fatalf("Expected fallback to default model pricing, got %v", err)
+	}
+	if pricing.InputPer1KTokens != 0.003 || pricing.CacheReadPer1KTokens != 0.0003 {
+		t.Errorf("Expected Sonnet 4.5 pricing, got %+v", pricing)
+	}
+	if cost, err := CalculateCostWithResolver(arn, 1000, 0, resolver); err != nil || cost == 0 {
+		t.Errorf("Expected non-zero cost with fallback pricing, got %v (err %v)", cost, err)
+	}
+}
+
+func TestResolvePricingNotifiesDefaultModelFallback(t *testing.T) {
+	arn := "arn:aws:bedrock:eu-west-1:123456789012:application-inference-profile/unmapped0002"
+	resolver := NewModelResolver(nil)
+	resolver.SetDefaultPricingModel("eu.anthropic.claude-sonnet-4-5-20250929-v1:0")
+	var notified []string
+	resolver.SetDefaultPricingHook(func(modelID, resolvedModelID, defaultModelID string) {
+		notified = append(notified, modelID+"|"+defaultModelID)
+	})
+
+	if _, err := ResolvePricing("anthropic.claude-3-haiku-20240307-v1:0", resolver); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(notified) != 0 {
+		t.Fatalf("Expected no notification for a model with its own pricing, got %v", notified)
+	}
+
+	if _, err := ResolvePricing(arn, resolver); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(notified) != 1 || notified[0] != arn+"|eu.anthropic.claude-sonnet-4-5-20250929-v1:0" {
+		t.Errorf("Expected the unresolved model reported once, got %v", notified)
+	}
+}
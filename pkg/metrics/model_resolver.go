@@ -16,6 +16,13 @@ type ModelResolver struct {
 	cache map[string]string // ARN -> model_id
 	mu    sync.RWMutex
 	ttl   time.Duration
+
+	profileModels      map[string]string // ARN -> model_id configurados (prioridad sobre la BD)
+	defaultModelID     string            // Modelo cuyo pricing se aplica si no se resuelve el del ARN
+	inputIncludesCache map[string]bool   // Modelos cuyo input reportado ya incluye los tokens de caché
+
+	// onDefaultPricing se llama cuando a un modelo sin pricing propio se le aplica el del modelo por defecto
+	onDefaultPricing func(modelID, resolvedModelID, defaultModelID string)
 }
 
 // NewModelResolver crea un nuevo resolver de modelos
@@ -27,8 +34,71 @@ func NewModelResolver(db *pgxpool.Pool) *ModelResolver {
 	}
 }
 
+// SetProfileModels configura el mapa ARN -> model_id que se consulta antes que la BD
+func (mr *ModelResolver) SetProfileModels(profileModels map[string]string) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.profileModels = profileModels
+}
+
+// SetDefaultPricingModel configura el modelo cuyo pricing se usa cuando no se encuentra el del modelo
+func (mr *ModelResolver) SetDefaultPricingModel(modelID string) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.defaultModelID = modelID
+}
+
+// SetDefaultPricingHook configura la función a la que se avisa cada vez que se aplica el pricing del
+// modelo por defecto (para registrar el modelo sin resolver en lugar de facturarlo en silencio)
+func (mr *ModelResolver) SetDefaultPricingHook(hook func(modelID, resolvedModelID, defaultModelID string)) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.onDefaultPricing = hook
+}
+
+// notifyDefaultPricing avisa al hook (si lo hay) de que se ha aplicado el pricing por defecto
+func (mr *ModelResolver) notifyDefaultPricing(modelID, resolvedModelID, defaultModelID string) {
+	mr.mu.RLock()
+	hook := mr.onDefaultPricing
+	mr.mu.RUnlock()
+	if hook != nil {
+		hook(modelID, resolvedModelID, defaultModelID)
+	}
+}
+
+// DefaultPricingModel retorna el modelo de pricing por defecto ("" si no hay)
+func (mr *ModelResolver) DefaultPricingModel() string {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	return mr.defaultModelID
+}
+
+// SetInputIncludesCache configura los modelos (model_id o ARN) cuyo input reportado ya incluye los
+// tokens de caché: su pricing se resuelve con InputIncludesCache y la caché se descuenta del input
+func (mr *ModelResolver) SetInputIncludesCache(modelIDs []string) {
+	models := make(map[string]bool, len(modelIDs))
+	for _, modelID := range modelIDs {
+		models[modelID] = true
+	}
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.inputIncludesCache = models
+}
+
+// InputIncludesCache indica si alguno de los modelos está configurado con el input incluyendo la caché
+func (mr *ModelResolver) InputIncludesCache(modelIDs ...string) bool {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	for _, modelID := range modelIDs {
+		if mr.inputIncludesCache[modelID] {
+			return true
+		}
+	}
+	return false
+}
+
 // ResolveModelID resuelve un ARN o model_id a su model_id base
-// Si es un ARN de inference profile, busca en BD el modelo base
+// Si es un ARN de inference profile, usa el mapa configurado o busca en BD el modelo base
 // Si ya es un model_id, lo retorna directamente
 func (mr *ModelResolver) ResolveModelID(modelIDOrARN string) (string, error) {
 	// Si no es un ARN, retornar directamente
@@ -36,14 +106,22 @@ func (mr *ModelResolver) ResolveModelID(modelIDOrARN string) (string, error) {
 		return modelIDOrARN, nil
 	}
 
-	// Verificar cache
+	// Verificar mapa configurado y cache
 	mr.mu.RLock()
+	if modelID, exists := mr.profileModels[modelIDOrARN]; exists {
+		mr.mu.RUnlock()
+		return modelID, nil
+	}
 	if cachedModelID, exists := mr.cache[modelIDOrARN]; exists {
 		mr.mu.RUnlock()
 		return cachedModelID, nil
 	}
 	mr.mu.RUnlock()
 
+	if mr.db == nil {
+		return "", fmt.Errorf("no model configured for ARN %s", modelIDOrARN)
+	}
+
 	// Buscar en base de datos
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -2,11 +2,14 @@ package metrics
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"bedrock-proxy-test/pkg/database"
+	"bedrock-proxy-test/pkg/retrystats"
 )
 
 // MetricsWorker gestiona la inserción asíncrona de métricas de uso
@@ -19,6 +22,12 @@ type MetricsWorker struct {
 	stopChan      chan struct{}
 	stopped       bool
 	mu            sync.Mutex
+	senders       sync.WaitGroup // Envíos bloqueantes en curso (Stop los espera antes de cerrar el canal)
+	droppedCount  atomic.Int64   // Métricas perdidas por canal lleno o espera agotada
+
+	insertParallelism int                                                        // Inserts concurrentes en flushBatch
+	insert            func(context.Context, *database.UsageTrackingData) error   // Inserción de un registro (BD por defecto)
+	insertBatch       func(context.Context, []*database.UsageTrackingData) error // Inserción multi-fila (nil = registro a registro)
 }
 
 // Config contiene la configuración del worker de métricas
@@ -26,20 +35,45 @@ type Config struct {
 	BufferSize    int           // Tamaño del canal buffered
 	BatchSize     int           // Número de métricas por batch
 	FlushInterval time.Duration // Intervalo de flush automático
+
+	// InsertParallelism es el número de inserts concurrentes al volcar un batch. Se limita a una
+	// fracción del pool (MaxConns / MaxPoolShareDivisor) para no dejar sin conexiones a las requests
+	InsertParallelism int
 }
 
+// DefaultInsertParallelism es el número de inserts concurrentes por defecto al volcar un batch
+const DefaultInsertParallelism = 4
+
+// MaxPoolShareDivisor limita los inserts concurrentes a MaxConns / MaxPoolShareDivisor
+const MaxPoolShareDivisor = 4
+
 // DefaultConfig retorna la configuración por defecto
 func DefaultConfig() Config {
 	return Config{
-		BufferSize:    1000,         // Buffer para 1000 métricas
-		BatchSize:     50,           // Insertar cada 50 métricas
-		FlushInterval: 5 * time.Second, // O cada 5 segundos
+		BufferSize:        1000,            // Buffer para 1000 métricas
+		BatchSize:         50,              // Insertar cada 50 métricas
+		FlushInterval:     5 * time.Second, // O cada 5 segundos
+		InsertParallelism: DefaultInsertParallelism,
+	}
+}
+
+// boundedInsertParallelism limita el paralelismo configurado a [1, maxConns/MaxPoolShareDivisor]
+// (maxConns <= 0 = pool desconocido, solo se exige un mínimo de 1)
+func boundedInsertParallelism(parallelism int, maxConns int32) int {
+	if maxConns > 0 {
+		if limit := int(maxConns) / MaxPoolShareDivisor; parallelism > limit {
+			parallelism = limit
+		}
+	}
+	if parallelism < 1 {
+		parallelism = 1
 	}
+	return parallelism
 }
 
 // NewMetricsWorker crea una nueva instancia del worker de métricas
 func NewMetricsWorker(db *database.Database, config Config) *MetricsWorker {
-	return &MetricsWorker{
+	mw := &MetricsWorker{
 		db:            db,
 		metricsChan:   make(chan *database.UsageTrackingData, config.BufferSize),
 		batchSize:     config.BatchSize,
@@ -47,6 +81,15 @@ func NewMetricsWorker(db *database.Database, config Config) *MetricsWorker {
 		stopChan:      make(chan struct{}),
 		stopped:       false,
 	}
+
+	var maxConns int32
+	if db != nil {
+		maxConns = db.MaxConns()
+		mw.insert = db.InsertUsageTracking
+		mw.insertBatch = db.InsertUsageTrackingBatch
+	}
+	mw.insertParallelism = boundedInsertParallelism(config.InsertParallelism, maxConns)
+	return mw
 }
 
 // Start inicia el worker de métricas
@@ -65,7 +108,15 @@ func (mw *MetricsWorker) run() {
 
 	for {
 		select {
-		case metric := <-mw.metricsChan:
+		case metric, ok := <-mw.metricsChan:
+			if !ok {
+				// Stop cerró el canal tras vaciarse de envíos: flush final de lo pendiente
+				if len(batch) > 0 {
+					mw.flushBatch(batch)
+				}
+				return
+			}
+
 			// Añadir métrica al batch
 			batch = append(batch, metric)
 
@@ -82,38 +133,72 @@ func (mw *MetricsWorker) run() {
 				batch = make([]*database.UsageTrackingData, 0, mw.batchSize)
 			}
 
-		case <-mw.stopChan:
-			// Flush final antes de cerrar
-			if len(batch) > 0 {
-				mw.flushBatch(batch)
-			}
-			return
 		}
 	}
 }
 
+// flushTimeout acota el INSERT multi-fila de un batch y, por separado, su fallback registro a registro
+const flushTimeout = 10 * time.Second
+
 // flushBatch inserta un batch de métricas en la base de datos
 func (mw *MetricsWorker) flushBatch(batch []*database.UsageTrackingData) {
 	if len(batch) == 0 {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Un único INSERT multi-fila por batch; es transaccional, así que si falla se reintenta registro a
+	// registro para insertar los válidos y contar los errores. Si el batch llegó a escribirse (p.ej. timeout
+	// tras el commit) el request_id único hace que los reintentos no dupliquen registros
+	attempt := 1
+	if mw.insertBatch != nil {
+		retrystats.Attempt(retrystats.CategoryDB, attempt)
+		batchCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		err := mw.insertBatch(batchCtx, batch)
+		cancel()
+		if err == nil {
+			return
+		}
+		fmt.Printf("[MetricsWorker] Batch insert failed, falling back to per-row inserts: %v\n", err)
+		attempt++
+	}
+	retrystats.Attempt(retrystats.CategoryDB, attempt)
+
+	// El fallback tiene su propio timeout: si el batch agotó el suyo no debe dejar sin tiempo a los registros
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
 	defer cancel()
 
-	// Insertar cada métrica usando la nueva tabla de usage tracking
-	successCount := 0
-	errorCount := 0
+	// Insertar cada métrica usando la nueva tabla de usage tracking, con como mucho
+	// insertParallelism inserts a la vez (cada uno ocupa una conexión del pool)
+	var successCount, errorCount int
+	var countMu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, mw.insertParallelism)
 
 	for _, metric := range batch {
-		// Log detallado de cada métrica antes de insertar
-		err := mw.db.InsertUsageTracking(ctx, metric)
-		if err != nil {
-			// Only log errors, not successes
-			errorCount++
-		} else {
-			successCount++
-		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(metric *database.UsageTrackingData) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			err := mw.insert(ctx, metric)
+
+			countMu.Lock()
+			defer countMu.Unlock()
+			if err != nil {
+				// Only log errors, not successes
+				errorCount++
+			} else {
+				successCount++
+			}
+		}(metric)
+	}
+	wg.Wait()
+
+	if errorCount > 0 {
+		retrystats.Exhausted(retrystats.CategoryDB)
+	} else {
+		retrystats.Succeeded(retrystats.CategoryDB, attempt)
 	}
 
 	// Only log batch summary if there were errors
@@ -122,11 +207,26 @@ func (mw *MetricsWorker) flushBatch(batch []*database.UsageTrackingData) {
 	}
 }
 
+// ErrMetricDropped indica que el canal estaba lleno y la métrica se ha perdido
+var ErrMetricDropped = errors.New("metrics channel is full, usage tracking dropped")
+
+// errWorkerStopped indica que el worker ya no acepta métricas
+var errWorkerStopped = errors.New("metrics worker is stopped")
+
 // RecordMetric añade una métrica al canal para procesamiento asíncrono
 // Deprecated: Use RecordUsageTracking instead
 func (mw *MetricsWorker) RecordMetric(metric *database.MetricData) error {
-	// Convertir MetricData a UsageTrackingData para compatibilidad
-	usageData := &database.UsageTrackingData{
+	return mw.RecordUsageTracking(usageFromMetric(metric))
+}
+
+// RecordMetricBlocking es RecordMetric esperando hueco en el canal hasta que venza ctx en lugar de descartar
+func (mw *MetricsWorker) RecordMetricBlocking(ctx context.Context, metric *database.MetricData) error {
+	return mw.RecordUsageTrackingBlocking(ctx, usageFromMetric(metric))
+}
+
+// usageFromMetric convierte MetricData a UsageTrackingData para compatibilidad
+func usageFromMetric(metric *database.MetricData) *database.UsageTrackingData {
+	return &database.UsageTrackingData{
 		CognitoUserID:       metric.UserID,
 		CognitoEmail:        "", // No disponible en MetricData antigua
 		Team:                metric.Team,
@@ -144,9 +244,9 @@ func (mw *MetricsWorker) RecordMetric(metric *database.MetricData) error {
 		ProcessingTimeMS:    metric.ProcessingTimeMS,
 		ResponseStatus:      metric.ResponseStatus,
 		ErrorMessage:        metric.ErrorMessage,
+		ProjectID:           metric.ProjectID,
+		RequestID:           metric.RequestID,
 	}
-	
-	return mw.RecordUsageTracking(usageData)
 }
 
 // RecordUsageTracking añade datos de uso al canal para procesamiento asíncrono
@@ -155,7 +255,7 @@ func (mw *MetricsWorker) RecordUsageTracking(data *database.UsageTrackingData) e
 	defer mw.mu.Unlock()
 
 	if mw.stopped {
-		return fmt.Errorf("metrics worker is stopped")
+		return errWorkerStopped
 	}
 
 	// Intentar enviar al canal sin bloquear
@@ -163,8 +263,39 @@ func (mw *MetricsWorker) RecordUsageTracking(data *database.UsageTrackingData) e
 	case mw.metricsChan <- data:
 		return nil
 	default:
-		// Canal lleno, métrica se pierde (o podríamos bloquear aquí)
-		return fmt.Errorf("metrics channel is full, usage tracking dropped")
+		// Canal lleno, métrica se pierde (RecordUsageTrackingBlocking espera en su lugar)
+		mw.droppedCount.Add(1)
+		return ErrMetricDropped
+	}
+}
+
+// RecordUsageTrackingBlocking espera hueco en el canal hasta que venza ctx; si vence, la métrica se
+// cuenta como perdida. Para callers que prefieren retrasarse a perder la métrica (p.ej. post-processing)
+func (mw *MetricsWorker) RecordUsageTrackingBlocking(ctx context.Context, data *database.UsageTrackingData) error {
+	mw.mu.Lock()
+	if mw.stopped {
+		mw.mu.Unlock()
+		return errWorkerStopped
+	}
+	mw.senders.Add(1)
+	mw.mu.Unlock()
+	defer mw.senders.Done()
+
+	// Si Stop ya ha empezado no se encola: el select de abajo elegiría al azar entre enviar y parar
+	select {
+	case <-mw.stopChan:
+		return errWorkerStopped
+	default:
+	}
+
+	select {
+	case mw.metricsChan <- data:
+		return nil
+	case <-mw.stopChan:
+		return errWorkerStopped
+	case <-ctx.Done():
+		mw.droppedCount.Add(1)
+		return fmt.Errorf("%w: %v", ErrMetricDropped, ctx.Err())
 	}
 }
 
@@ -178,14 +309,21 @@ func (mw *MetricsWorker) Stop() {
 	mw.stopped = true
 	mw.mu.Unlock()
 
-	// Señalar al worker que debe detenerse
+	// Despertar a los envíos bloqueantes y esperarlos: con stopped ya no empieza ninguno nuevo,
+	// así que después nadie más escribe en el canal
 	close(mw.stopChan)
+	mw.senders.Wait()
 
-	// Esperar a que termine el flush final
+	// Cerrar el canal de métricas: el worker vacía lo que quede encolado y hace el flush final
+	close(mw.metricsChan)
 	mw.wg.Wait()
+}
 
-	// Cerrar el canal de métricas
-	close(mw.metricsChan)
+// IsStopped indica si el worker ha sido detenido y ya no acepta métricas
+func (mw *MetricsWorker) IsStopped() bool {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	return mw.stopped
 }
 
 // Stats retorna estadísticas del worker
@@ -199,6 +337,7 @@ func (mw *MetricsWorker) Stats() WorkerStats {
 		BatchSize:      mw.batchSize,
 		FlushInterval:  mw.flushInterval,
 		IsStopped:      mw.stopped,
+		DroppedCount:   mw.droppedCount.Load(),
 	}
 }
 
@@ -209,4 +348,5 @@ type WorkerStats struct {
 	BatchSize      int
 	FlushInterval  time.Duration
 	IsStopped      bool
+	DroppedCount   int64 // Métricas perdidas desde el arranque
 }
@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+)
+
+// newTestBatch crea un batch de n registros de uso
+func newTestBatch(n int) []*database.UsageTrackingData {
+	batch := make([]*database.UsageTrackingData, n)
+	for i := range batch {
+		batch[i] = &database.UsageTrackingData{CognitoUserID: "user-1"}
+	}
+	return batch
+}
+
+// newTestWorker crea un worker cuyo insert tarda delay y registra la concurrencia máxima alcanzada
+func newTestWorker(parallelism int, delay time.Duration, inserted, maxActive *atomic.Int32) *MetricsWorker {
+	mw := NewMetricsWorker(nil, Config{BufferSize: 10, BatchSize: 10, FlushInterval: time.Second, InsertParallelism: parallelism})
+	var active atomic.Int32
+	mw.insert = func(ctx context.Context, data *database.UsageTrackingData) error {
+		current := active.Add(1)
+		for {
+			previous := maxActive.Load()
+			if current <= previous || maxActive.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		active.Add(-1)
+		inserted.Add(1)
+		return nil
+	}
+	return mw
+}
+
+func TestFlushBatchParallelismIsBounded(t *testing.T) {
+	var inserted, maxActive atomic.Int32
+	mw := newTestWorker(3, 5*time.Millisecond, &inserted, &maxActive)
+
+	mw.flushBatch(newTestBatch(30))
+
+	if inserted.Load() != 30 {
+		t.Errorf("Expected all 30 records inserted, got %d", inserted.Load())
+	}
+	if maxActive.Load() > 3 {
+		t.Errorf("Expected at most 3 concurrent inserts, got %d", maxActive.Load())
+	}
+	if maxActive.Load() < 2 {
+		t.Errorf("Expected inserts to run in parallel, max concurrency %d", maxActive.Load())
+	}
+}
+
+func TestBoundedInsertParallelism(t *testing.T) {
+	tests := []struct {
+		parallelism int
+		maxConns    int32
+		expected    int
+	}{
+		{4, 25, 4},
+		{16, 25, 6}, // 25 / 4
+		{4, 0, 4},   // Pool desconocido
+		{0, 25, 1},
+		{4, 2, 1}, // Pool muy pequeño: secuencial
+	}
+	for _, tt := range tests {
+		if got := boundedInsertParallelism(tt.parallelism, tt.maxConns); got != tt.expected {
+			t.Errorf("boundedInsertParallelism(%d, %d) = %d, expected %d", tt.parallelism, tt.maxConns, got, tt.expected)
+		}
+	}
+}
+
+func BenchmarkFlushBatch(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		parallelism int
+	}{
+		{"sequential", 1},
+		{"parallel-4", 4},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			var inserted, maxActive atomic.Int32
+			mw := newTestWorker(bm.parallelism, 200*time.Microsecond, &inserted, &maxActive)
+			batch := newTestBatch(50)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				mw.flushBatch(batch)
+			}
+		})
+	}
+}
+
+func TestRecordUsageTrackingCountsDroppedMetrics(t *testing.T) {
+	mw := NewMetricsWorker(nil, Config{BufferSize: 2, BatchSize: 10, FlushInterval: time.Second})
+
+	// Sin Start nadie consume: el canal se llena con 2 métricas
+	for i := 0; i < 2; i++ {
+		if err := mw.RecordUsageTracking(&database.UsageTrackingData{CognitoUserID: "user-1"}); err != nil {
+			t.Fatalf("Unexpected error filling the buffer: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := mw.RecordUsageTracking(&database.UsageTrackingData{CognitoUserID: "user-1"}); !errors.Is(err, ErrMetricDropped) {
+			t.Fatalf("Expected ErrMetricDropped with a full buffer, got %v", err)
+		}
+	}
+	if dropped := mw.Stats().DroppedCount; dropped != 3 {
+		t.Errorf("Expected 3 dropped metrics, got %d", dropped)
+	}
+
+	// La variante bloqueante espera hasta el deadline y también cuenta la pérdida
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := mw.RecordMetricBlocking(ctx, &database.MetricData{UserID: "user-1"}); !errors.Is(err, ErrMetricDropped) {
+		t.Fatalf("Expected ErrMetricDropped after the deadline, got %v", err)
+	}
+	if dropped := mw.Stats().DroppedCount; dropped != 4 {
+		t.Errorf("Expected 4 dropped metrics, got %d", dropped)
+	}
+}
+
+func TestRecordUsageTrackingBlockingWaitsForRoom(t *testing.T) {
+	mw := NewMetricsWorker(nil, Config{BufferSize: 1, BatchSize: 10, FlushInterval: time.Second})
+	mw.RecordUsageTracking(&database.UsageTrackingData{CognitoUserID: "user-1"})
+
+	// Se libera hueco mientras el envío bloqueante espera
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-mw.metricsChan
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := mw.RecordUsageTrackingBlocking(ctx, &database.UsageTrackingData{CognitoUserID: "user-2"}); err != nil {
+		t.Fatalf("Expected blocking record to succeed once there is room, got %v", err)
+	}
+	if dropped := mw.Stats().DroppedCount; dropped != 0 {
+		t.Errorf("Expected no dropped metrics, got %d", dropped)
+	}
+}
+
+func TestStopFlushesQueuedMetrics(t *testing.T) {
+	mw := NewMetricsWorker(nil, Config{BufferSize: 10, BatchSize: 10, FlushInterval: time.Hour})
+	var flushed atomic.Int32
+	mw.insertBatch = func(ctx context.Context, batch []*database.UsageTrackingData) error {
+		flushed.Add(int32(len(batch)))
+		return nil
+	}
+	mw.Start()
+
+	for i := 0; i < 3; i++ {
+		if err := mw.RecordUsageTrackingBlocking(context.Background(), &database.UsageTrackingData{CognitoUserID: "user-1"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	mw.Stop()
+
+	if flushed.Load() != 3 {
+		t.Errorf("Expected the 3 queued metrics flushed on Stop, got %d", flushed.Load())
+	}
+	if err := mw.RecordUsageTrackingBlocking(context.Background(), &database.UsageTrackingData{CognitoUserID: "user-1"}); !errors.Is(err, errWorkerStopped) {
+		t.Errorf("Expected errWorkerStopped after Stop, got %v", err)
+	}
+}
+
+func TestFlushBatchUsesSingleBatchInsert(t *testing.T) {
+	var inserted, maxActive atomic.Int32
+	mw := newTestWorker(4, 0, &inserted, &maxActive)
+	var batchCalls, batchRows int
+	mw.insertBatch = func(ctx context.Context, batch []*database.UsageTrackingData) error {
+		batchCalls++
+		batchRows += len(batch)
+		return nil
+	}
+
+	mw.flushBatch(newTestBatch(50))
+	if batchCalls != 1 || batchRows != 50 {
+		t.Errorf("Expected one batch insert with 50 rows, got %d calls with %d rows", batchCalls, batchRows)
+	}
+	if inserted.Load() != 0 {
+		t.Errorf("Expected no per-row inserts, got %d", inserted.Load())
+	}
+}
+
+func TestFlushBatchFallsBackToPerRowInserts(t *testing.T) {
+	var inserted, maxActive atomic.Int32
+	mw := newTestWorker(4, 0, &inserted, &maxActive)
+	mw.insertBatch = func(ctx context.Context, batch []*database.UsageTrackingData) error {
+		return errors.New("value too long for type character varying")
+	}
+
+	mw.flushBatch(newTestBatch(20))
+	if inserted.Load() != 20 {
+		t.Errorf("Expected per-row fallback to insert all 20 records, got %d", inserted.Load())
+	}
+}
+
+func TestFlushBatchFallbackUsesFreshContext(t *testing.T) {
+	mw := NewMetricsWorker(nil, Config{BufferSize: 10, BatchSize: 10, FlushInterval: time.Second, InsertParallelism: 2})
+	var batchCtx context.Context
+	mw.insertBatch = func(ctx context.Context, batch []*database.UsageTrackingData) error {
+		batchCtx = ctx
+		return context.DeadlineExceeded
+	}
+	var expired atomic.Int32
+	mw.insert = func(ctx context.Context, data *database.UsageTrackingData) error {
+		if ctx.Err() != nil || ctx == batchCtx {
+			expired.Add(1)
+		}
+		return nil
+	}
+
+	mw.flushBatch(newTestBatch(5))
+	if batchCtx == nil || batchCtx.Err() == nil {
+		t.Fatal("Expected the batch insert context to be released before the fallback")
+	}
+	if expired.Load() != 0 {
+		t.Errorf("Expected per-row inserts to get a fresh context, %d got the batch one", expired.Load())
+	}
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// DefaultModelListCacheMaxAge es el tiempo durante el que se sirve la última lista de modelos obtenida
+// de Bedrock si la API de control no responde
+const DefaultModelListCacheMaxAge = time.Hour
+
+// modelListCache guarda la última lista de modelos válida de Bedrock para servirla durante caídas
+// transitorias del plano de control en lugar de volver a la lista de configuración
+type modelListCache struct {
+	mu        sync.Mutex
+	models    []ModelInfo
+	fetchedAt time.Time
+}
+
+// store guarda una copia de la lista obtenida de Bedrock
+func (c *modelListCache) store(models []ModelInfo, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models = append([]ModelInfo(nil), models...)
+	c.fetchedAt = now
+}
+
+// get devuelve la lista guardada si existe y no supera maxAge (maxAge <= 0 desactiva la caché)
+func (c *modelListCache) get(maxAge time.Duration, now time.Time) ([]ModelInfo, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if maxAge <= 0 || c.models == nil {
+		return nil, 0, false
+	}
+	age := now.Sub(c.fetchedAt)
+	if age > maxAge {
+		return nil, 0, false
+	}
+	return append([]ModelInfo(nil), c.models...), age, true
+}
+
+// sortModelInfos ordena la lista por ID para que /v1/models sea estable entre llamadas
+func sortModelInfos(models []ModelInfo) {
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+}
+
+// fallbackModelList devuelve la última lista de Bedrock en caché o, si no hay, la de configuración
+func (this *BedrockClient) fallbackModelList() []ModelInfo {
+	if models, age, ok := this.modelList.get(this.config.ModelListCacheMaxAge, time.Now()); ok {
+		Logger.Warning(amslog.Event{
+			Name:    "MODEL_LIST_CACHED_FALLBACK",
+			Message: "Bedrock model list unavailable, serving last successful result",
+			Fields: map[string]interface{}{
+				"models.count":  len(models),
+				"models.age_ms": age.Milliseconds(),
+			},
+		})
+		return models
+	}
+	return this.ListModels()
+}
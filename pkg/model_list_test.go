@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestModelListClient(available *bool) *BedrockClient {
+	client := newTestBedrockClient()
+	client.config.ModelMappings = map[string]string{
+		"claude-sonnet-4-5": "anthropic.claude-sonnet-4-5-20250929-v1:0",
+		"claude-haiku-4-5":  "anthropic.claude-haiku-4-5-20251001-v1:0",
+	}
+	client.config.AnthropicVersionMappings = map[string]string{
+		"claude-sonnet-4-5": "bedrock-2023-05-31",
+		"claude-haiku-4-5":  "bedrock-2023-05-31",
+	}
+	client.config.ModelListCacheMaxAge = time.Hour
+	client.httpClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !*available {
+			return nil, errors.New("control plane unavailable")
+		}
+		body := `{"modelSummaries":[
+			{"modelId":"anthropic.claude-sonnet-4-5-20250929-v1:0","modelName":"Claude Sonnet 4.5"},
+			{"modelId":"anthropic.claude-haiku-4-5-20251001-v1:0","modelName":"Claude Haiku 4.5"}]}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})}
+	return client
+}
+
+func TestListModelsIsStableAndClean(t *testing.T) {
+	client := newTestModelListClient(new(bool))
+	models := client.ListModels()
+	if len(models) != 2 || models[0].ID != "claude-haiku-4-5" || models[1].ID != "claude-sonnet-4-5" {
+		t.Fatalf("Expected config models sorted by ID, got %+v", models)
+	}
+	for _, model := range models {
+		if model.Name != model.ID {
+			t.Errorf("Expected Name to be the model ID without version, got %+v", model)
+		}
+	}
+}
+
+func TestGetMergedModelListServesCachedResultDuringOutage(t *testing.T) {
+	setupTestLogger(t)
+	available := true
+	client := newTestModelListClient(&available)
+
+	models, err := client.GetMergedModelList()
+	if err != nil || len(models) != 2 || models[0].Name != "Claude Haiku 4.5" {
+		t.Fatalf("Expected Bedrock model list, got %+v (err %v)", models, err)
+	}
+
+	// Caída del plano de control: se sirve la última lista de Bedrock, no la de configuración
+	available = false
+	cached, err := client.GetMergedModelList()
+	if err != nil || len(cached) != 2 || cached[0].Name != "Claude Haiku 4.5" || cached[1].Name != "Claude Sonnet 4.5" {
+		t.Fatalf("Expected cached Bedrock model list, got %+v (err %v)", cached, err)
+	}
+
+	// Caché caducada: fallback a la configuración
+	client.modelList.fetchedAt = time.Now().Add(-2 * time.Hour)
+	fallback, _ := client.GetMergedModelList()
+	if len(fallback) != 2 || fallback[0].Name != "claude-haiku-4-5" {
+		t.Errorf("Expected config model list after cache expiry, got %+v", fallback)
+	}
+}
+
+func TestGetMergedModelListWithoutCacheFallsBackToConfig(t *testing.T) {
+	setupTestLogger(t)
+	available := true
+	client := newTestModelListClient(&available)
+	client.config.ModelListCacheMaxAge = 0
+
+	client.GetMergedModelList()
+	available = false
+	models, _ := client.GetMergedModelList()
+	if len(models) != 2 || models[0].Name != "claude-haiku-4-5" {
+		t.Errorf("Expected config model list with cache disabled, got %+v", models)
+	}
+}
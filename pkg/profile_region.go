@@ -0,0 +1,30 @@
+package pkg
+
+import (
+	"strings"
+)
+
+// inferenceProfileRegion devuelve la región del inference profile del usuario. Un ARN
+// (arn:aws:bedrock:<región>:<cuenta>:...) lleva la región en el cuarto campo; un ID de perfil o de
+// modelo se invoca en la región del proxy (defaultRegion)
+func inferenceProfileRegion(profile, defaultRegion string) string {
+	if strings.HasPrefix(profile, "arn:") {
+		if parts := strings.SplitN(profile, ":", 5); len(parts) == 5 && parts[3] != "" {
+			return parts[3]
+		}
+	}
+	return defaultRegion
+}
+
+// profileRegionAllowed indica si la región está en la lista permitida (lista vacía = sin restricción)
+func profileRegionAllowed(region string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, allowedRegion := range allowed {
+		if strings.EqualFold(region, allowedRegion) {
+			return true
+		}
+	}
+	return false
+}
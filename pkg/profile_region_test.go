@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+func newTestProfileRequest(profile string) *http.Request {
+	req := newTestProxyRequest(`{"messages":[{"role":"user","content":"hola"}],"max_tokens":10}`)
+	user := auth.UserContext{UserID: "user-1", DefaultInferenceProfile: profile}
+	return req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, user))
+}
+
+func TestInferenceProfileRegion(t *testing.T) {
+	cases := map[string]string{
+		"arn:aws:bedrock:us-east-1:701055077130:application-inference-profile/abc": "us-east-1",
+		"arn:aws:bedrock:eu-central-1:701055077130:inference-profile/eu.claude":    "eu-central-1",
+		"eu.anthropic.claude-sonnet-4-5-20250929-v1:0":                             "eu-west-1",
+	}
+	for profile, want := range cases {
+		if got := inferenceProfileRegion(profile, "eu-west-1"); got != want {
+			t.Errorf("inferenceProfileRegion(%q) = %q, want %q", profile, got, want)
+		}
+	}
+}
+
+func TestHandleProxyRejectsDisallowedProfileRegion(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.AllowedProfileRegions = []string{"eu-west-1", "eu-central-1"}
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProfileRequest("arn:aws:bedrock:us-east-1:701055077130:application-inference-profile/abc"))
+
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), string(ErrCodeProfileRegionNotAllowed)) {
+		t.Fatalf("Expected 403 %s, got %d: %s", ErrCodeProfileRegionNotAllowed, rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "us-east-1") {
+		t.Errorf("Expected rejected region in message, got %s", rec.Body.String())
+	}
+}
+
+func TestProfileRegionAllowed(t *testing.T) {
+	allowed := []string{"eu-west-1", "eu-central-1"}
+	if !profileRegionAllowed("eu-central-1", allowed) {
+		t.Error("Expected eu-central-1 to be allowed")
+	}
+	if profileRegionAllowed("us-east-1", allowed) {
+		t.Error("Expected us-east-1 to be rejected")
+	}
+	if !profileRegionAllowed("us-east-1", nil) {
+		t.Error("Expected any region to be allowed without allowlist")
+	}
+}
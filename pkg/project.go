@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// Valores por defecto del etiquetado de gasto por proyecto
+const (
+	DefaultProjectIDHeader    = "X-Project-Id"
+	DefaultProjectIDMaxLength = 64
+)
+
+// NormalizeProjectID valida y normaliza un ID de proyecto: sin espacios alrededor, en minúsculas y
+// recortado a maxLength. Solo admite letras, dígitos, '-', '_' y '.'; un ID inválido devuelve "" (la
+// petición se registra sin proyecto en vez de rechazarse)
+func NormalizeProjectID(raw string, maxLength int) string {
+	projectID := strings.ToLower(strings.TrimSpace(raw))
+	for _, c := range projectID {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' && c != '.' {
+			return ""
+		}
+	}
+	if maxLength > 0 && len(projectID) > maxLength {
+		projectID = projectID[:maxLength]
+	}
+	return projectID
+}
+
+// resolveProjectID obtiene el proyecto de la petición: el header configurado tiene prioridad sobre el
+// claim project del JWT, para poder imputar una petición concreta a otro proyecto
+func (this *BedrockClient) resolveProjectID(r *http.Request, user *auth.UserContext) string {
+	header := this.config.ProjectIDHeader
+	if header == "" {
+		header = DefaultProjectIDHeader
+	}
+	maxLength := this.config.ProjectIDMaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultProjectIDMaxLength
+	}
+
+	if raw := r.Header.Get(header); raw != "" {
+		return NormalizeProjectID(raw, maxLength)
+	}
+	if user != nil {
+		return NormalizeProjectID(user.Project, maxLength)
+	}
+	return ""
+}
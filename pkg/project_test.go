@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+func TestNormalizeProjectID(t *testing.T) {
+	cases := map[string]string{
+		"  Apollo-11 ":   "apollo-11",
+		"team.proj_2":    "team.proj_2",
+		"with space":     "",
+		"drop;table":     "",
+		"":               "",
+		"proyecto-ñandú": "",
+	}
+	for raw, want := range cases {
+		if got := NormalizeProjectID(raw, 64); got != want {
+			t.Errorf("NormalizeProjectID(%q) = %q, want %q", raw, got, want)
+		}
+	}
+	if got := NormalizeProjectID(strings.Repeat("a", 100), 10); got != strings.Repeat("a", 10) {
+		t.Errorf("Expected project ID capped at 10 chars, got %q", got)
+	}
+}
+
+func TestResolveProjectIDPrefersHeaderOverClaim(t *testing.T) {
+	client := newTestBedrockClient()
+	user := &auth.UserContext{UserID: "user-1", Project: "Apollo"}
+
+	r := httptest.NewRequest("POST", "/v1/messages", nil)
+	if got := client.resolveProjectID(r, user); got != "apollo" {
+		t.Errorf("Expected project from JWT claim, got %q", got)
+	}
+
+	r.Header.Set(DefaultProjectIDHeader, "Zeus")
+	if got := client.resolveProjectID(r, user); got != "zeus" {
+		t.Errorf("Expected header to override the claim, got %q", got)
+	}
+
+	mc := NewMetricsCapture(httptest.NewRecorder(), "model", "req-1", r)
+	mc.SetProjectID(client.resolveProjectID(r, nil))
+	if got := mc.GetMetrics().ProjectID; got != "zeus" {
+		t.Errorf("Expected project captured in metrics, got %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// PrimeCacheField es el campo del body con el que el cliente pide calentar la caché de prompt
+const PrimeCacheField = "prime_cache"
+
+// wantsCachePrime indica si la request pide forzar una escritura en la caché ("prime_cache": true)
+func wantsCachePrime(payload map[string]interface{}) bool {
+	prime, _ := payload[PrimeCacheField].(bool)
+	return prime
+}
+
+// primeCachePoint garantiza un cache point al final del system prompt o, si no hay system,
+// al final del primer mensaje, para que Bedrock escriba ese prefijo en la caché aunque el
+// cliente no haya enviado cache_control. Si ya existe un cache point en esa posición no añade otro
+func primeCachePoint(systemBlocks []types.SystemContentBlock, messages []types.Message) ([]types.SystemContentBlock, []types.Message, bool) {
+	if len(systemBlocks) > 0 {
+		if _, ok := systemBlocks[len(systemBlocks)-1].(*types.SystemContentBlockMemberCachePoint); ok {
+			return systemBlocks, messages, false
+		}
+		systemBlocks = append(systemBlocks, &types.SystemContentBlockMemberCachePoint{
+			Value: types.CachePointBlock{Type: types.CachePointTypeDefault},
+		})
+		return systemBlocks, messages, true
+	}
+
+	if len(messages) == 0 || len(messages[0].Content) == 0 {
+		return systemBlocks, messages, false
+	}
+	content := messages[0].Content
+	if _, ok := content[len(content)-1].(*types.ContentBlockMemberCachePoint); ok {
+		return systemBlocks, messages, false
+	}
+	messages[0].Content = append(content, &types.ContentBlockMemberCachePoint{
+		Value: types.CachePointBlock{Type: types.CachePointTypeDefault},
+	})
+	return systemBlocks, messages, true
+}
+
+// logCacheWrite registra los tokens escritos en caché por una request con prime_cache
+func logCacheWrite(ctx context.Context, modelID string, cacheWriteTokens, cacheReadTokens int32) {
+	Logger.InfoContext(ctx, amslog.Event{
+		Name:    EventCacheWrite,
+		Message: "Prompt cache primed",
+		Outcome: amslog.OutcomeSuccess,
+		Fields: map[string]interface{}{
+			"model_id":           modelID,
+			"cache_write_tokens": cacheWriteTokens,
+			"cache_read_tokens":  cacheReadTokens,
+		},
+	})
+}
+
+// minCacheTokens devuelve el mínimo de tokens cacheables del modelo (0 si no hay regla que coincida).
+// Si varios fragmentos coinciden gana el más largo (el más específico)
+func (this *BedrockClient) minCacheTokens(modelID string) int {
+	matched := ""
+	tokens := 0
+	for model, modelTokens := range this.config.ModelMinCacheTokens {
+		if strings.Contains(modelID, model) && len(model) > len(matched) {
+			matched = model
+			tokens = modelTokens
+		}
+	}
+	return tokens
+}
+
+// dropSmallCachePoints elimina los cache points cuyo prefijo (system y mensajes anteriores, estimado
+// con CharsPerTokenEstimate) no alcanza minTokens: Bedrock no los cachearía y la escritura se
+// desperdicia. El prefijo es acumulativo, así que solo se descartan los primeros cache points.
+// Las tools nativas (toolConfig) no se cuentan, por lo que la estimación es conservadora
+func dropSmallCachePoints(systemBlocks []types.SystemContentBlock, messages []types.Message, minTokens int) ([]types.SystemContentBlock, []types.Message, int) {
+	if minTokens <= 0 {
+		return systemBlocks, messages, 0
+	}
+
+	chars, dropped := 0, 0
+	belowMinimum := func() bool {
+		return (chars+CharsPerTokenEstimate-1)/CharsPerTokenEstimate < minTokens
+	}
+
+	keptSystem := systemBlocks[:0:0]
+	for _, block := range systemBlocks {
+		switch b := block.(type) {
+		case *types.SystemContentBlockMemberText:
+			chars += len(b.Value)
+		case *types.SystemContentBlockMemberCachePoint:
+			if belowMinimum() {
+				dropped++
+				continue
+			}
+		}
+		keptSystem = append(keptSystem, block)
+	}
+
+	for i, message := range messages {
+		content := message.Content[:0:0]
+		for _, block := range message.Content {
+			if _, ok := block.(*types.ContentBlockMemberCachePoint); ok && belowMinimum() {
+				dropped++
+				continue
+			}
+			visitContentBlockText(block, func(text string) bool {
+				chars += len(text)
+				return true
+			})
+			content = append(content, block)
+		}
+		messages[i].Content = content
+	}
+	return keptSystem, messages, dropped
+}
+
+// logDroppedCachePoints registra los cache points descartados por no alcanzar el mínimo del modelo
+func logDroppedCachePoints(ctx context.Context, modelID string, dropped, minTokens int) {
+	Logger.InfoContext(ctx, amslog.Event{
+		Name:    EventCachePointDropped,
+		Message: "Cache point dropped: prefix below the model's minimum cacheable size",
+		Fields: map[string]interface{}{
+			"model_id":         modelID,
+			"cache_points":     dropped,
+			"min_cache_tokens": minTokens,
+		},
+	})
+}
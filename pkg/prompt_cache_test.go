@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func TestPrimeCacheInsertsCachePointAfterSystem(t *testing.T) {
+	client := newTestBedrockClient()
+	payload := map[string]interface{}{
+		"system":      "large stable context",
+		"prime_cache": true,
+		"messages":    []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+	}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:large stable context", "cache")
+
+	// Sin el flag no se añade ningún cache point
+	delete(payload, "prime_cache")
+	input, _, err = client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:large stable context")
+}
+
+func TestPrimeCacheInsertsCachePointAfterFirstMessage(t *testing.T) {
+	client := newTestBedrockClient()
+	payload := map[string]interface{}{
+		"prime_cache": true,
+		"messages": []interface{}{
+			map[string]interface{}{"role": "user", "content": "documento largo"},
+			map[string]interface{}{"role": "assistant", "content": "ok"},
+			map[string]interface{}{"role": "user", "content": "pregunta"},
+		},
+	}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first := input.Messages[0].Content
+	if len(first) != 2 {
+		t.Fatalf("Expected text + cache point in first message, got %d blocks", len(first))
+	}
+	if _, ok := first[1].(*types.ContentBlockMemberCachePoint); !ok {
+		t.Errorf("Expected cache point after first message, got %T", first[1])
+	}
+	if len(input.Messages[2].Content) != 1 {
+		t.Errorf("Expected no cache point in later messages, got %d blocks", len(input.Messages[2].Content))
+	}
+}
+
+func TestPrimeCacheDoesNotDuplicateClientCachePoint(t *testing.T) {
+	systemBlocks := convertSystemBlocksWithCache([]interface{}{
+		map[string]interface{}{"type": "text", "text": "static", "cache_control": map[string]interface{}{"type": "ephemeral"}},
+	}, false)
+
+	systemBlocks, _, inserted := primeCachePoint(systemBlocks, nil)
+	if inserted {
+		t.Error("Expected no extra cache point when the system already ends with one")
+	}
+	assertSystemBlocks(t, systemBlocks, "text:static", "cache")
+}
+
+func TestHandleProxyPrimeCacheRecordsWriteTokens(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"ok"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":10,"outputTokens":1,"totalTokens":11,"cacheWriteInputTokens":2048},"metrics":{"latencyMs":100}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "prime_cache": true, "system": "contexto", "messages": [{"role": "user", "content": "hola"}]}`))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"cache_creation_input_tokens":2048`) {
+		t.Errorf("Expected cache write tokens in message_start, got %s", rec.Body.String())
+	}
+
+	output := logs.String()
+	if !containsEvent(output, EventCacheWrite) {
+		t.Fatalf("Expected %s event, got %s", EventCacheWrite, output)
+	}
+	if !strings.Contains(output, `"cache_write_tokens":2048`) {
+		t.Errorf("Expected cache_write_tokens in %s event", EventCacheWrite)
+	}
+}
+
+func newCacheSystemPayload(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"system": []interface{}{map[string]interface{}{
+			"type":          "text",
+			"text":          text,
+			"cache_control": map[string]interface{}{"type": "ephemeral"},
+		}},
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+	}
+}
+
+func TestCachePointDroppedBelowModelMinimum(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.ForcePromptCaching = true
+	client.config.ModelMinCacheTokens = parseModelIntMap("sonnet=1024,opus=1024,haiku=2048")
+
+	// 1000 tokens estimados: por debajo del mínimo de Sonnet (1024)
+	small := strings.Repeat("abcd", 1000)
+	input, _, err := client.BuildConverseInput(context.Background(), newCacheSystemPayload(small), "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:"+small)
+
+	// 1024 tokens: alcanza el mínimo y se mantiene
+	large := strings.Repeat("abcd", 1024)
+	input, _, err = client.BuildConverseInput(context.Background(), newCacheSystemPayload(large), "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:"+large, "cache")
+
+	// El mismo prefijo no alcanza el mínimo de Haiku (2048)
+	input, _, err = client.BuildConverseInput(context.Background(), newCacheSystemPayload(large), "anthropic.claude-3-5-haiku", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:"+large)
+}
+
+func TestClientCachePointsNeverDropped(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.ModelMinCacheTokens = parseModelIntMap("sonnet=1024")
+	small := strings.Repeat("abcd", 10)
+
+	// cache_control del cliente (sin ForcePromptCaching): se respeta aunque no alcance el mínimo
+	input, _, err := client.BuildConverseInput(context.Background(), newCacheSystemPayload(small), "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:"+small, "cache")
+
+	// prime_cache con ForcePromptCaching: el cache point forzado se descarta pero el pedido se reinserta
+	client.config.ForcePromptCaching = true
+	payload := newCacheSystemPayload(small)
+	payload[PrimeCacheField] = true
+	input, _, err = client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:"+small, "cache")
+}
+
+func TestModelMinCacheTokensOptIn(t *testing.T) {
+	t.Setenv("MODEL_MIN_CACHE_TOKENS", "")
+	if config := LoadBedrockConfigWithEnv(); len(config.ModelMinCacheTokens) != 0 {
+		t.Errorf("Expected no minimum cache tokens by default, got %v", config.ModelMinCacheTokens)
+	}
+}
+
+func TestDropSmallCachePointsCountsPrefixAcrossMessages(t *testing.T) {
+	cachePoint := &types.ContentBlockMemberCachePoint{Value: types.CachePointBlock{Type: types.CachePointTypeDefault}}
+	messages := []types.Message{
+		{Role: types.ConversationRoleUser, Content: []types.ContentBlock{&types.ContentBlockMemberText{Value: strings.Repeat("a", 40)}, cachePoint}},
+		{Role: types.ConversationRoleAssistant, Content: []types.ContentBlock{&types.ContentBlockMemberText{Value: strings.Repeat("b", 40)}, cachePoint}},
+	}
+	system := []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: strings.Repeat("s", 20)}}
+
+	// Mínimo de 20 tokens: el primer cache point cubre 15 tokens (se descarta) y el segundo 25
+	_, messages, dropped := dropSmallCachePoints(system, messages, 20)
+	if dropped != 1 {
+		t.Fatalf("Expected 1 dropped cache point, got %d", dropped)
+	}
+	if len(messages[0].Content) != 1 || len(messages[1].Content) != 2 {
+		t.Errorf("Expected only the first cache point dropped, got %d and %d blocks", len(messages[0].Content), len(messages[1].Content))
+	}
+}
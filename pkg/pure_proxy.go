@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// DefaultPureProxyUserID es el usuario con el que se atienden las requests en modo proxy puro
+const DefaultPureProxyUserID = "pure-proxy"
+
+// PureProxyConfig configura el modo proxy puro (PURE_PROXY_MODE): el proxy solo firma y convierte
+// a Converse, sin base de datos, autenticación JWT, cuotas ni métricas, aunque la BD esté configurada
+type PureProxyConfig struct {
+	Enabled          bool
+	InferenceProfile string // Inference profile (ARN o ID) usado para todas las requests
+}
+
+// LoadPureProxyConfigWithEnv carga PURE_PROXY_MODE y PURE_PROXY_INFERENCE_PROFILE
+// (si no está configurado se usa LEGACY_INFERENCE_PROFILE)
+func LoadPureProxyConfigWithEnv() PureProxyConfig {
+	return PureProxyConfig{
+		Enabled:          os.Getenv("PURE_PROXY_MODE") == "true",
+		InferenceProfile: getEnvOrDefault("PURE_PROXY_INFERENCE_PROFILE", os.Getenv("LEGACY_INFERENCE_PROFILE")),
+	}
+}
+
+// Validate comprueba que el modo proxy puro tiene un inference profile con el que invocar a Bedrock
+func (c PureProxyConfig) Validate() error {
+	if c.Enabled && c.InferenceProfile == "" {
+		return errors.New("PURE_PROXY_MODE is enabled but PURE_PROXY_INFERENCE_PROFILE is not set: " +
+			"/v1/messages would reject every request")
+	}
+	return nil
+}
+
+// PureProxyUserMiddleware añade al contexto el usuario del modo proxy puro con el inference profile
+// configurado si la request no trae uno, para que se atienda en lugar de rechazarse con 403
+func PureProxyUserMiddleware(config PureProxyConfig) func(http.Handler) http.Handler {
+	user := auth.UserContext{
+		UserID:                  DefaultPureProxyUserID,
+		DefaultInferenceProfile: config.InferenceProfile,
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := auth.GetUserFromContext(r.Context()); err == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := context.WithValue(r.Context(), auth.UserContextKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
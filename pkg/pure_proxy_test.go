@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+func newUnauthenticatedProxyRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestPureProxyModeServesRequestsWithoutUser(t *testing.T) {
+	setupTestLogger(t)
+	var calls atomic.Int32
+	client := newBatchTestClient(t, &calls)
+	body := `{"stream": true, "max_tokens": 10, "messages": [{"role": "user", "content": "hola"}]}`
+
+	// Sin modo proxy puro una request sin usuario se rechaza
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newUnauthenticatedProxyRequest(body))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 without user outside pure proxy mode, got %d", rec.Code)
+	}
+
+	handler := PureProxyUserMiddleware(PureProxyConfig{Enabled: true, InferenceProfile: "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"})(http.HandlerFunc(client.HandleProxy))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newUnauthenticatedProxyRequest(body))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"text":"ok"`) {
+		t.Fatalf("Expected streamed response in pure proxy mode, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected 1 Bedrock call, got %d", calls.Load())
+	}
+}
+
+func TestPureProxyModeKeepsExistingUser(t *testing.T) {
+	var user *auth.UserContext
+	var err error
+	handler := PureProxyUserMiddleware(PureProxyConfig{Enabled: true, InferenceProfile: "pure-profile"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err = auth.GetUserFromContext(r.Context())
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newTestProxyRequest(`{}`))
+	if err != nil || user.UserID != "user-1" {
+		t.Errorf("Expected request user to be kept, got %+v (err %v)", user, err)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), newUnauthenticatedProxyRequest(`{}`))
+	if err != nil || user.UserID != DefaultPureProxyUserID || user.DefaultInferenceProfile != "pure-profile" {
+		t.Errorf("Expected pure proxy user, got %+v (err %v)", user, err)
+	}
+}
+
+func TestPureProxyConfigValidate(t *testing.T) {
+	if err := (PureProxyConfig{Enabled: true}).Validate(); err == nil {
+		t.Error("Expected error when pure proxy mode has no inference profile")
+	}
+	if err := (PureProxyConfig{}).Validate(); err != nil {
+		t.Errorf("Expected disabled pure proxy mode to be valid, got %v", err)
+	}
+}
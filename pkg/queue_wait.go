@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// queueWaitBuckets son los límites superiores (en segundos) del histograma de esperas de admisión
+var queueWaitBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// queueWaitHistogram acumula las esperas de admisión para exponerlas en /metrics: el p95 se
+// calcula en Prometheus con histogram_quantile(0.95, ...). Es seguro para uso concurrente
+type queueWaitHistogram struct {
+	mu     sync.Mutex
+	counts []int64 // Observaciones por bucket (no acumuladas)
+	sum    float64
+	count  int64
+}
+
+// batchQueueWaits es el histograma del proceso con las esperas de admisión de /v1/messages/batch
+var batchQueueWaits = newQueueWaitHistogram()
+
+func newQueueWaitHistogram() *queueWaitHistogram {
+	return &queueWaitHistogram{counts: make([]int64, len(queueWaitBuckets))}
+}
+
+// Observe registra una espera de admisión
+func (h *queueWaitHistogram) Observe(wait time.Duration) {
+	seconds := wait.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := sort.SearchFloat64s(queueWaitBuckets, seconds); i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// WritePrometheus escribe el histograma en el formato de texto de Prometheus
+func (h *queueWaitHistogram) WritePrometheus(w io.Writer) error {
+	h.mu.Lock()
+	counts := append([]int64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	const name = "bedrock_proxy_batch_queue_wait_seconds"
+	if _, err := fmt.Fprintf(w, "# HELP %s Time batch requests waited for a concurrency slot.\n# TYPE %s histogram\n", name, name); err != nil {
+		return err
+	}
+	var cumulative int64
+	for i, bound := range queueWaitBuckets {
+		cumulative += counts[i]
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, count, name, sum, name, count)
+	return err
+}
+
+// queueWaitPercentile devuelve el percentil p de las esperas de admisión del batch
+func queueWaitPercentile(waits []time.Duration, p int) time.Duration {
+	if len(waits) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), waits...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := (len(sorted)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueWaitPercentile(t *testing.T) {
+	waits := []time.Duration{0, 30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}
+	if got := queueWaitPercentile(waits, 95); got != 30*time.Millisecond {
+		t.Errorf("Expected p95 of 30ms, got %v", got)
+	}
+	if got := queueWaitPercentile(nil, 95); got != 0 {
+		t.Errorf("Expected 0 without samples, got %v", got)
+	}
+}
+
+func TestQueueWaitHistogramWritePrometheus(t *testing.T) {
+	histogram := newQueueWaitHistogram()
+	histogram.Observe(0)
+	histogram.Observe(20 * time.Millisecond)
+	histogram.Observe(time.Minute)
+
+	var out strings.Builder
+	if err := histogram.WritePrometheus(&out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, line := range []string{
+		"# TYPE bedrock_proxy_batch_queue_wait_seconds histogram",
+		`bedrock_proxy_batch_queue_wait_seconds_bucket{le="0.005"} 1`,
+		`bedrock_proxy_batch_queue_wait_seconds_bucket{le="0.025"} 2`,
+		`bedrock_proxy_batch_queue_wait_seconds_bucket{le="30"} 2`,
+		`bedrock_proxy_batch_queue_wait_seconds_bucket{le="+Inf"} 3`,
+		"bedrock_proxy_batch_queue_wait_seconds_sum 60.02",
+		"bedrock_proxy_batch_queue_wait_seconds_count 3",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestPrometheusMetricsExposeBatchQueueWait(t *testing.T) {
+	rec := httptest.NewRecorder()
+	HandlePrometheusMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "bedrock_proxy_batch_queue_wait_seconds_count") {
+		t.Errorf("Expected the batch queue wait histogram on /metrics, got:\n%s", rec.Body.String())
+	}
+}
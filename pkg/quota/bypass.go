@@ -0,0 +1,33 @@
+package quota
+
+import (
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// BypassConfig define los usuarios y equipos exentos de la comprobación de cuotas
+// (cuentas de servicio internas: health-check bots, evaluadores, etc.). La aplica AuthMiddleware
+// (SetQuotaBypass), que registra QUOTA_BYPASS
+type BypassConfig struct {
+	UserIDs []string
+	Teams   []string
+}
+
+// Matches indica si el usuario está exento por UserID o por equipo
+func (bc BypassConfig) Matches(user *auth.UserContext) bool {
+	if user == nil {
+		return false
+	}
+	for _, userID := range bc.UserIDs {
+		if userID == user.UserID {
+			return true
+		}
+	}
+	if user.Team != "" {
+		for _, team := range bc.Teams {
+			if team == user.Team {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package quota
+
+import (
+	"testing"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+func TestBypassConfigMatches(t *testing.T) {
+	if (BypassConfig{}).Matches(&auth.UserContext{UserID: "user-1", Team: "dev"}) {
+		t.Error("Expected empty bypass config to match nobody")
+	}
+
+	bc := BypassConfig{UserIDs: []string{"eval-bot"}, Teams: []string{"platform"}}
+	if !bc.Matches(&auth.UserContext{UserID: "eval-bot", Team: "data"}) {
+		t.Error("Expected exempt user ID to match")
+	}
+	if !bc.Matches(&auth.UserContext{UserID: "user-1", Team: "platform"}) {
+		t.Error("Expected exempt team to match")
+	}
+	if bc.Matches(&auth.UserContext{UserID: "user-1", Team: "data"}) || bc.Matches(nil) {
+		t.Error("Expected other users not to match")
+	}
+}
@@ -0,0 +1,48 @@
+package quota
+
+import (
+	"time"
+)
+
+// ResetConfig define la hora y zona horaria en la que se resetean las cuotas
+type ResetConfig struct {
+	Location *time.Location // Zona horaria del reset (por defecto UTC)
+	Hour     int            // Hora local del reset (0-23)
+}
+
+// DefaultResetConfig retorna la configuración por defecto (medianoche UTC)
+func DefaultResetConfig() ResetConfig {
+	return ResetConfig{
+		Location: time.UTC,
+		Hour:     0,
+	}
+}
+
+// location retorna la zona horaria configurada o UTC si no hay ninguna
+func (rc ResetConfig) location() *time.Location {
+	if rc.Location == nil {
+		return time.UTC
+	}
+	return rc.Location
+}
+
+// TimeZone retorna la zona horaria del reset (UTC si no hay ninguna configurada)
+func (rc ResetConfig) TimeZone() *time.Location {
+	return rc.location()
+}
+
+// NextDailyReset retorna el instante del próximo reset diario posterior a now
+func (rc ResetConfig) NextDailyReset(now time.Time) time.Time {
+	local := now.In(rc.location())
+	reset := time.Date(local.Year(), local.Month(), local.Day(), rc.Hour, 0, 0, 0, rc.location())
+	if !reset.After(local) {
+		reset = time.Date(local.Year(), local.Month(), local.Day()+1, rc.Hour, 0, 0, 0, rc.location())
+	}
+	return reset
+}
+
+// DailyPeriodStart retorna el instante del último reset diario (inicio del día de cuota al que pertenece
+// now), en la zona horaria del reset. Su fecha identifica el día de cuota
+func (rc ResetConfig) DailyPeriodStart(now time.Time) time.Time {
+	return rc.NextDailyReset(now).AddDate(0, 0, -1)
+}
@@ -0,0 +1,59 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDailyResetUTC(t *testing.T) {
+	rc := DefaultResetConfig()
+	now := time.Date(2025, 3, 10, 22, 30, 0, 0, time.UTC)
+
+	if got := rc.NextDailyReset(now).Sub(now); got != 90*time.Minute {
+		t.Errorf("Expected 90 minutes until midnight UTC, got %v", got)
+	}
+}
+
+func TestNextDailyResetCustomHourAndTimezone(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	rc := ResetConfig{Location: madrid, Hour: 6}
+
+	// 05:00 en Madrid (CET, UTC+1) -> reset hoy a las 06:00, 1 hora
+	now := time.Date(2025, 1, 15, 4, 0, 0, 0, time.UTC)
+	if got := rc.NextDailyReset(now).Sub(now); got != time.Hour {
+		t.Errorf("Expected 1h, got %v", got)
+	}
+
+	// 06:00 exactas en Madrid -> el reset de hoy ya pasó, el siguiente es mañana
+	now = time.Date(2025, 1, 15, 5, 0, 0, 0, time.UTC)
+	if got := rc.NextDailyReset(now).Sub(now); got != 24*time.Hour {
+		t.Errorf("Expected 24h, got %v", got)
+	}
+}
+
+func TestDailyPeriodStartAroundBoundary(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	rc := ResetConfig{Location: madrid, Hour: 0}
+
+	// 23:59:59 en Madrid (22:59:59 UTC) -> sigue siendo el día 9
+	before := time.Date(2025, 3, 9, 22, 59, 59, 0, time.UTC)
+	if got := rc.DailyPeriodStart(before).Format("2006-01-02"); got != "2025-03-09" {
+		t.Errorf("Expected quota day 2025-03-09 just before midnight in Madrid, got %s", got)
+	}
+
+	// 00:00:01 en Madrid (23:00:01 UTC, aún día 9 en UTC) -> ya es el día 10
+	after := time.Date(2025, 3, 9, 23, 0, 1, 0, time.UTC)
+	start := rc.DailyPeriodStart(after)
+	if got := start.Format("2006-01-02"); got != "2025-03-10" {
+		t.Errorf("Expected quota day 2025-03-10 just after midnight in Madrid, got %s", got)
+	}
+	if !start.Equal(time.Date(2025, 3, 9, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected period to start at 23:00 UTC, got %v", start.UTC())
+	}
+}
@@ -0,0 +1,40 @@
+package quota
+
+import (
+	"strings"
+)
+
+// ZeroLimitPolicy define cómo se interpreta un límite a 0 o NULL en la fila del usuario (sin configurar)
+type ZeroLimitPolicy string
+
+const (
+	// ZeroLimitUnlimited trata el límite sin configurar como ilimitado (por defecto)
+	ZeroLimitUnlimited ZeroLimitPolicy = "unlimited"
+	// ZeroLimitDefault aplica los límites por defecto de ZeroLimitConfig
+	ZeroLimitDefault ZeroLimitPolicy = "default"
+)
+
+// ParseZeroLimitPolicy interpreta la política; un valor desconocido se trata como unlimited
+func ParseZeroLimitPolicy(value string) ZeroLimitPolicy {
+	if ZeroLimitPolicy(strings.ToLower(strings.TrimSpace(value))) == ZeroLimitDefault {
+		return ZeroLimitDefault
+	}
+	return ZeroLimitUnlimited
+}
+
+// ZeroLimitConfig define qué hacer con los usuarios recién dados de alta sin límite diario configurado,
+// que con un límite 0 recibirían 429 en su primera request (used >= 0). La aplica AuthMiddleware
+// (SetQuotaZeroLimit)
+type ZeroLimitConfig struct {
+	Policy            ZeroLimitPolicy
+	DailyRequestLimit int // Límite diario de requests por defecto (política default; <= 0 = ilimitado)
+}
+
+// ResolveDailyRequestLimit devuelve el límite diario de requests efectivo para el límite del usuario:
+// el suyo si está configurado, el por defecto con la política default y 0 (ilimitado) en otro caso
+func (c ZeroLimitConfig) ResolveDailyRequestLimit(limit int) int {
+	if limit > 0 || c.Policy != ZeroLimitDefault || c.DailyRequestLimit <= 0 {
+		return max(limit, 0)
+	}
+	return c.DailyRequestLimit
+}
@@ -0,0 +1,25 @@
+package quota
+
+import "testing"
+
+func TestResolveDailyRequestLimit(t *testing.T) {
+	defaults := ZeroLimitConfig{Policy: ZeroLimitDefault, DailyRequestLimit: 50}
+	if got := defaults.ResolveDailyRequestLimit(0); got != 50 {
+		t.Errorf("Expected default limit for an unconfigured user, got %d", got)
+	}
+	if got := defaults.ResolveDailyRequestLimit(200); got != 200 {
+		t.Errorf("Expected the user's own limit to be kept, got %d", got)
+	}
+	if got := (ZeroLimitConfig{Policy: ZeroLimitUnlimited, DailyRequestLimit: 50}).ResolveDailyRequestLimit(0); got != 0 {
+		t.Errorf("Expected unlimited policy to return 0, got %d", got)
+	}
+}
+
+func TestParseZeroLimitPolicy(t *testing.T) {
+	if ParseZeroLimitPolicy(" Default ") != ZeroLimitDefault {
+		t.Error("Expected default policy to be parsed case-insensitively")
+	}
+	if ParseZeroLimitPolicy("") != ZeroLimitUnlimited || ParseZeroLimitPolicy("bogus") != ZeroLimitUnlimited {
+		t.Error("Expected unknown values to fall back to unlimited")
+	}
+}
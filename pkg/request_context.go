@@ -1,8 +1,17 @@
 package pkg
 
 import (
+	"context"
+	"math/rand/v2"
+	"net/http"
 	"sync"
 	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // RequestContext mantiene el contexto y timing de una request
@@ -11,6 +20,9 @@ type RequestContext struct {
 	StartTime    time.Time
 	PhaseTimings map[string]time.Duration
 	mu           sync.RWMutex
+	traceCtx     context.Context // Contexto con el span raíz (nil si no se ha iniciado el trace)
+	rootSpan     trace.Span
+	detailed     bool // Spans por fase y REQUEST_SUMMARY (false si la request no entra en el muestreo)
 }
 
 // NewRequestContext crea un nuevo contexto de request
@@ -19,40 +31,151 @@ func NewRequestContext(requestID string) *RequestContext {
 		RequestID:    requestID,
 		StartTime:    time.Now(),
 		PhaseTimings: make(map[string]time.Duration),
+		detailed:     true,
+	}
+}
+
+// NewSampledRequestContext crea el contexto de request con spans por fase y REQUEST_SUMMARY solo para
+// la fracción sampleRate de las requests (PHASE_TRACING_SAMPLE). Las no muestreadas siguen midiendo
+// las fases (coste despreciable) para que SLOW_REQUEST tenga el desglose, pero sin spans ni resumen
+func NewSampledRequestContext(requestID string, sampleRate float64) *RequestContext {
+	rc := NewRequestContext(requestID)
+	rc.detailed = samplePhaseTracing(sampleRate)
+	return rc
+}
+
+// samplePhaseTracing decide si una request entra en el muestreo (rate >= 1 siempre, <= 0 nunca)
+func samplePhaseTracing(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	return rate > 0 && rand.Float64() < rate
+}
+
+// Detailed indica si la request entra en el muestreo (spans por fase y REQUEST_SUMMARY)
+func (rc *RequestContext) Detailed() bool {
+	return rc.detailed
+}
+
+// StartTrace crea el span raíz de la request (no-op si OTel no está configurado)
+// El trace ID se deriva del trace.id de los logs presente en ctx
+func (rc *RequestContext) StartTrace(ctx context.Context) context.Context {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "proxy.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("request.id", rc.RequestID)),
+	)
+
+	rc.mu.Lock()
+	rc.traceCtx = ctx
+	rc.rootSpan = span
+	rc.mu.Unlock()
+
+	return ctx
+}
+
+// EndTrace finaliza el span raíz de la request
+func (rc *RequestContext) EndTrace() {
+	rc.mu.RLock()
+	span := rc.rootSpan
+	rc.mu.RUnlock()
+
+	if span != nil {
+		span.End()
 	}
 }
 
 // StartPhase inicia el tracking de una fase y retorna una función para finalizarla
+// Si hay trace activo y la request está muestreada, cada fase se registra además como span hijo del span raíz
 func (rc *RequestContext) StartPhase(phase string) func() {
 	start := time.Now()
+
+	rc.mu.RLock()
+	traceCtx := rc.traceCtx
+	rc.mu.RUnlock()
+
+	var span trace.Span
+	if traceCtx != nil && rc.detailed {
+		_, span = otel.Tracer(tracerName).Start(traceCtx, phase)
+	}
+
 	return func() {
+		if span != nil {
+			span.End()
+		}
 		rc.mu.Lock()
 		defer rc.mu.Unlock()
 		rc.PhaseTimings[phase] = time.Since(start)
 	}
 }
 
+// RecordPhase registra una fase medida fuera del RequestContext (p.ej. la espera de admisión,
+// que ocurre antes de crearlo)
+func (rc *RequestContext) RecordPhase(phase string, duration time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.PhaseTimings[phase] = duration
+}
+
+type queueWaitContextKey struct{}
+
+// WithQueueWait guarda en el contexto el tiempo que la request esperó desde su llegada hasta ser admitida
+func WithQueueWait(ctx context.Context, wait time.Duration) context.Context {
+	return context.WithValue(ctx, queueWaitContextKey{}, wait)
+}
+
+// QueueWaitFromContext devuelve la espera de admisión de la request (false si no pasó por una cola)
+func QueueWaitFromContext(ctx context.Context) (time.Duration, bool) {
+	wait, ok := ctx.Value(queueWaitContextKey{}).(time.Duration)
+	return wait, ok
+}
+
 // GetTotalDuration retorna la duración total desde el inicio
 func (rc *RequestContext) GetTotalDuration() time.Duration {
 	return time.Since(rc.StartTime)
 }
 
-// LogSummary loguea un resumen de todos los timings
-func (rc *RequestContext) LogSummary() {
+// RequestDeadlineMiddleware aplica un deadline a la request completa (incluyendo las escrituras
+// síncronas de cuota en BD). Un timeout <= 0 desactiva el límite
+func RequestDeadlineMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// PhaseTimingsMs devuelve la duración de cada fase registrada en milisegundos
+// (campos estructurados para consultar la latencia por fase en CloudWatch Insights)
+func (rc *RequestContext) PhaseTimingsMs() map[string]int64 {
 	rc.mu.RLock()
 	defer rc.mu.RUnlock()
 	
-	totalMs := rc.GetTotalDuration().Milliseconds()
-	
-	// Construir string con timings de cada fase
-	phaseStr := ""
+	phases := make(map[string]int64, len(rc.PhaseTimings))
 	for phase, duration := range rc.PhaseTimings {
-		if phaseStr != "" {
-			phaseStr += ", "
-		}
-		phaseStr += phase + "=" + duration.String()
+		phases[phase] = duration.Milliseconds()
+	}
+	return phases
+}
+
+// LogSummary emite un evento REQUEST_SUMMARY con la duración total y la de cada fase (en ms)
+func (rc *RequestContext) LogSummary(ctx context.Context) {
+	if !rc.detailed {
+		return
 	}
+	phases := rc.PhaseTimingsMs()
 	
-	Log.Infof("[REQUEST_SUMMARY] id=%s total=%dms phases=[%s]",
-		rc.RequestID, totalMs, phaseStr)
+	Logger.InfoContext(ctx, amslog.Event{
+		Name:       EventRequestSummary,
+		Message:    "Request timing summary",
+		DurationMs: rc.GetTotalDuration().Milliseconds(),
+		Fields: map[string]interface{}{
+			"request.id": rc.RequestID,
+			"phases_ms":  phases,
+		},
+	})
 }
\ No newline at end of file
@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+func TestRequestDeadlineMiddleware(t *testing.T) {
+	var hasDeadline bool
+	handler := RequestDeadlineMiddleware(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline = r.Context().Deadline()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	if !hasDeadline {
+		t.Error("Expected request context to have a deadline")
+	}
+
+	handler = RequestDeadlineMiddleware(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline = r.Context().Deadline()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	if hasDeadline {
+		t.Error("Expected no deadline when timeout is disabled")
+	}
+}
+
+func TestLogSummaryEmitsRequestSummaryEvent(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	reqCtx := NewRequestContext("req-123")
+	endPhase := reqCtx.StartPhase("parse_request")
+	endPhase()
+
+	ctx := amslog.WithRequestID(context.Background(), "req-123")
+	reqCtx.LogSummary(ctx)
+
+	output := logs.String()
+	if !containsEvent(output, EventRequestSummary) {
+		t.Fatalf("Expected REQUEST_SUMMARY event, got %s", output)
+	}
+	if !strings.Contains(output, `"parse_request"`) {
+		t.Errorf("Expected phase timings in summary, got %s", output)
+	}
+}
+
+func TestMetricsCaptureFinalizeEmitsMetricsCaptureEvent(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	mc := newTestMetricsCapture(t)
+	mc.Finalize(context.Background())
+
+	output := logs.String()
+	if !containsEvent(output, EventMetricsCapture) {
+		t.Fatalf("Expected METRICS_CAPTURE event, got %s", output)
+	}
+	if !strings.Contains(output, `"tokens.output":20`) {
+		t.Errorf("Expected captured output tokens in event, got %s", output)
+	}
+}
+
+func TestToolsConversionEmitsToolsConversionEvent(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	tools := []interface{}{
+		map[string]interface{}{"name": "get_weather"},
+		map[string]interface{}{"description": "tool without name"},
+	}
+	if _, err := convertAnthropicToolsToBedrock(context.Background(), tools); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := logs.String()
+	if !containsEvent(output, EventToolsConversion) {
+		t.Fatalf("Expected TOOLS_CONVERSION event, got %s", output)
+	}
+	if !strings.Contains(output, "Skipping tool without name") {
+		t.Errorf("Expected warning for tool without name, got %s", output)
+	}
+}
+
+func TestPhaseTimingsMsContainsAllPhases(t *testing.T) {
+	reqCtx := NewRequestContext("req-123")
+	for _, phase := range []string{"sign_request", "parse_request", "streaming"} {
+		endPhase := reqCtx.StartPhase(phase)
+		endPhase()
+	}
+	reqCtx.mu.Lock()
+	reqCtx.PhaseTimings["streaming"] = 1500 * time.Millisecond
+	reqCtx.mu.Unlock()
+
+	phases := reqCtx.PhaseTimingsMs()
+	if len(phases) != 3 {
+		t.Fatalf("Expected 3 phases, got %v", phases)
+	}
+	for _, phase := range []string{"sign_request", "parse_request", "streaming"} {
+		if _, ok := phases[phase]; !ok {
+			t.Errorf("Expected phase %s in timings map", phase)
+		}
+	}
+	if phases["streaming"] != 1500 {
+		t.Errorf("Expected streaming=1500ms, got %d", phases["streaming"])
+	}
+}
+
+func TestPhaseTracingSampleRate(t *testing.T) {
+	const iterations = 20000
+	sampled := 0
+	for i := 0; i < iterations; i++ {
+		if NewSampledRequestContext("req", 0.1).Detailed() {
+			sampled++
+		}
+	}
+	if rate := float64(sampled) / iterations; rate < 0.08 || rate > 0.12 {
+		t.Errorf("Expected ~10%% of requests sampled, got %.3f", rate)
+	}
+
+	if !samplePhaseTracing(1) || samplePhaseTracing(0) {
+		t.Error("Expected rate 1 to always sample and rate 0 to never sample")
+	}
+}
+
+func TestUnsampledRequestContextSkipsSummary(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	reqCtx := NewSampledRequestContext("req-123", 0)
+	reqCtx.StartPhase("parse_request")()
+	reqCtx.LogSummary(context.Background())
+
+	// Las fases se miden igualmente para el desglose de SLOW_REQUEST
+	if _, ok := reqCtx.PhaseTimingsMs()["parse_request"]; !ok {
+		t.Errorf("Expected phase timings for unsampled request, got %v", reqCtx.PhaseTimingsMs())
+	}
+	if containsEvent(logs.String(), EventRequestSummary) {
+		t.Errorf("Expected no REQUEST_SUMMARY for unsampled request, got %s", logs.String())
+	}
+	if reqCtx.GetTotalDuration() <= 0 {
+		t.Error("Expected total duration to be tracked for unsampled request")
+	}
+}
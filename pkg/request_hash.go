@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// RequestHashHeader es la cabecera de respuesta con el hash del input de Converse (DEBUG_REQUEST_HASH_HEADER)
+const RequestHashHeader = "X-Converse-Request-Hash"
+
+// converseRequestHash calcula un sha256 estable de la parte del input de Converse que determina el prefijo
+// cacheable y la respuesta: modelo, tools enviadas como toolConfig, system, mensajes (cache points incluidos)
+// y AdditionalModelRequestFields (thinking, top_k). Se canonicaliza con la misma representación que
+// /admin/preview (JSON con claves ordenadas) más lo que el preview solo resume: los bytes de las imágenes
+// y el contenido completo de los tool_result
+func converseRequestHash(input *bedrockRuntime.ConverseStreamInput) string {
+	preview := newConversePreview(input, input.ToolConfig)
+	// Fuera del hash lo que no forma parte del prefijo cacheable
+	preview.InferenceConfig = nil
+
+	hash := sha256.New()
+	canonical, _ := json.Marshal(preview)
+	hash.Write(canonical)
+	for _, msg := range input.Messages {
+		for _, block := range msg.Content {
+			switch b := block.(type) {
+			case *types.ContentBlockMemberImage:
+				hashImage(hash, b.Value)
+			case *types.ContentBlockMemberToolResult:
+				hashToolResult(hash, b.Value)
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// hashImage añade al hash los bytes de una imagen
+func hashImage(hash io.Writer, image types.ImageBlock) {
+	if source, ok := image.Source.(*types.ImageSourceMemberBytes); ok {
+		hash.Write(source.Value)
+	}
+}
+
+// hashToolResult añade al hash el contenido completo de un tool_result (el preview solo cuenta sus bloques)
+func hashToolResult(hash io.Writer, result types.ToolResultBlock) {
+	for _, content := range result.Content {
+		switch c := content.(type) {
+		case *types.ToolResultContentBlockMemberText:
+			io.WriteString(hash, c.Value)
+		case *types.ToolResultContentBlockMemberJson:
+			if c.Value != nil {
+				if value, err := c.Value.MarshalSmithyDocument(); err == nil {
+					hash.Write(value)
+				}
+			}
+		case *types.ToolResultContentBlockMemberImage:
+			hashImage(hash, c.Value)
+		}
+		// Separador para que el reparto del contenido entre bloques también cambie el hash
+		hash.Write([]byte{0})
+	}
+}
+
+// recordRequestHash registra el hash del input de Converse (en INFO con DEBUG_REQUEST_HASH) y, con
+// RequestHashHeader activo, lo devuelve en la cabecera X-Converse-Request-Hash. Solo el hash: nunca el contenido
+func (this *BedrockClient) recordRequestHash(ctx context.Context, w http.ResponseWriter, modelID string, systemBlocks []types.SystemContentBlock, messages []types.Message, maxTokens int32, opts converseOptions) {
+	if !this.config.RequestHashLog && !this.config.RequestHashHeader {
+		return
+	}
+
+	hash := converseRequestHash(buildConverseStreamInput(modelID, systemBlocks, messages, maxTokens, opts))
+	if this.config.RequestHashLog {
+		Logger.InfoContext(ctx, amslog.Event{
+			Name:    EventConverseRequestHash,
+			Message: "Converse request hash",
+			Fields: map[string]interface{}{
+				"request.hash":   hash,
+				"model.id":       modelID,
+				"messages.count": len(messages),
+			},
+		})
+	}
+	if this.config.RequestHashHeader {
+		w.Header().Set(RequestHashHeader, hash)
+	}
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func buildHashTestInput(userText string, image []byte) ([]types.SystemContentBlock, []types.Message) {
+	system := []types.SystemContentBlock{
+		&types.SystemContentBlockMemberText{Value: "You are helpful."},
+		&types.SystemContentBlockMemberCachePoint{Value: types.CachePointBlock{Type: types.CachePointTypeDefault}},
+	}
+	content := []types.ContentBlock{&types.ContentBlockMemberText{Value: userText}}
+	if image != nil {
+		content = append(content, &types.ContentBlockMemberImage{Value: types.ImageBlock{
+			Format: types.ImageFormatPng,
+			Source: &types.ImageSourceMemberBytes{Value: image},
+		}})
+	}
+	return system, []types.Message{{Role: types.ConversationRoleUser, Content: content}}
+}
+
+func TestConverseRequestHashStable(t *testing.T) {
+	modelID := "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"
+
+	system, messages := buildHashTestInput("hola", []byte{1, 2, 3})
+	first := converseRequestHash(buildConverseStreamInput(modelID, system, messages, 1024, converseOptions{}))
+	system, messages = buildHashTestInput("hola", []byte{1, 2, 3})
+	if second := converseRequestHash(buildConverseStreamInput(modelID, system, messages, 1024, converseOptions{})); second != first {
+		t.Errorf("Expected identical inputs to hash the same, got %s and %s", first, second)
+	}
+	if len(first) != 64 {
+		t.Errorf("Expected a hex sha256, got %q", first)
+	}
+
+	system, messages = buildHashTestInput("hola!", []byte{1, 2, 3})
+	if changed := converseRequestHash(buildConverseStreamInput(modelID, system, messages, 1024, converseOptions{})); changed == first {
+		t.Error("Expected a changed message to change the hash")
+	}
+
+	// Misma longitud de imagen y distinto contenido
+	system, messages = buildHashTestInput("hola", []byte{1, 2, 4})
+	if changed := converseRequestHash(buildConverseStreamInput(modelID, system, messages, 1024, converseOptions{})); changed == first {
+		t.Error("Expected a changed image to change the hash")
+	}
+
+	system, messages = buildHashTestInput("hola", []byte{1, 2, 3})
+	if changed := converseRequestHash(buildConverseStreamInput("eu.anthropic.claude-3-5-haiku-20241022-v1:0", system, messages, 1024, converseOptions{})); changed == first {
+		t.Error("Expected a different model to change the hash")
+	}
+}
+
+func TestRecordRequestHashHeader(t *testing.T) {
+	logs := setupTestLogger(t)
+	system, messages := buildHashTestInput("hola", nil)
+
+	client := newTestBedrockClient()
+	rec := httptest.NewRecorder()
+	client.recordRequestHash(t.Context(), rec, "model", system, messages, 1024, converseOptions{})
+	if rec.Header().Get(RequestHashHeader) != "" || containsEvent(logs.String(), EventConverseRequestHash) {
+		t.Error("Expected no hash without the debug flags")
+	}
+
+	client.config.RequestHashHeader = true
+	client.recordRequestHash(t.Context(), rec, "model", system, messages, 1024, converseOptions{})
+	if got := rec.Header().Get(RequestHashHeader); got != converseRequestHash(buildConverseStreamInput("model", system, messages, 1024, converseOptions{})) {
+		t.Errorf("Expected the request hash in %s, got %q", RequestHashHeader, got)
+	}
+	if containsEvent(logs.String(), EventConverseRequestHash) {
+		t.Error("Expected the header alone not to log the hash")
+	}
+
+	// Con DEBUG_REQUEST_HASH el hash se registra en INFO (visible con el nivel por defecto)
+	client.config.RequestHashLog = true
+	client.recordRequestHash(t.Context(), rec, "model", system, messages, 1024, converseOptions{})
+	Logger.Close()
+	if !strings.Contains(logs.String(), `"log.level":"INFO"`) || !containsEvent(logs.String(), EventConverseRequestHash) {
+		t.Errorf("Expected an INFO %s event, got: %s", EventConverseRequestHash, logs.String())
+	}
+}
+
+func TestConverseRequestHashIncludesToolResultsAndAdditionalFields(t *testing.T) {
+	modelID := "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"
+	withToolResult := func(output string) []types.Message {
+		_, toolResult := newToolExchange("t1", 0)
+		toolResult.Content[0].(*types.ContentBlockMemberToolResult).Value.Content = []types.ToolResultContentBlock{
+			&types.ToolResultContentBlockMemberText{Value: output},
+		}
+		return []types.Message{toolResult}
+	}
+
+	// Mismo número de bloques y misma longitud: solo cambia el contenido del tool_result
+	first := converseRequestHash(buildConverseStreamInput(modelID, nil, withToolResult("main.go"), 1024, converseOptions{}))
+	if changed := converseRequestHash(buildConverseStreamInput(modelID, nil, withToolResult("util.go"), 1024, converseOptions{})); changed == first {
+		t.Error("Expected a changed tool_result content to change the hash")
+	}
+
+	// top_k y thinking viajan en AdditionalModelRequestFields
+	if changed := converseRequestHash(buildConverseStreamInput(modelID, nil, withToolResult("main.go"), 1024, converseOptions{TopK: 5})); changed == first {
+		t.Error("Expected additional model request fields to change the hash")
+	}
+}
@@ -0,0 +1,134 @@
+// Package retrystats contabiliza los reintentos de todas las capas del proxy (Bedrock, BD, webhooks)
+// por categoría, para exponerlos en /admin/stats y en formato Prometheus
+package retrystats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Categorías de reintento
+const (
+	CategoryBedrock = "bedrock"
+	CategoryDB      = "db"
+	CategoryWebhook = "webhook"
+)
+
+// Counts son los contadores acumulados de una categoría
+type Counts struct {
+	Attempts            int64 `json:"attempts"`              // Intentos totales (el primero incluido)
+	Retries             int64 `json:"retries"`               // Intentos a partir del segundo
+	SucceededAfterRetry int64 `json:"succeeded_after_retry"` // Operaciones que salieron bien tras reintentar
+	Exhausted           int64 `json:"exhausted"`             // Operaciones que agotaron los reintentos
+}
+
+// Recorder acumula los contadores por categoría; es seguro para uso concurrente
+type Recorder struct {
+	mu     sync.Mutex
+	counts map[string]*Counts
+}
+
+// NewRecorder crea un Recorder con las categorías conocidas a cero (las series existen desde el inicio)
+func NewRecorder() *Recorder {
+	r := &Recorder{counts: make(map[string]*Counts)}
+	for _, category := range []string{CategoryBedrock, CategoryDB, CategoryWebhook} {
+		r.counts[category] = &Counts{}
+	}
+	return r
+}
+
+// Default es el Recorder del proceso, usado por las funciones del paquete
+var Default = NewRecorder()
+
+func (r *Recorder) update(category string, fn func(*Counts)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts, ok := r.counts[category]
+	if !ok {
+		counts = &Counts{}
+		r.counts[category] = counts
+	}
+	fn(counts)
+}
+
+// Attempt registra el intento número attempt (1 = primer intento) de una operación reintentable
+func (r *Recorder) Attempt(category string, attempt int) {
+	r.update(category, func(c *Counts) {
+		c.Attempts++
+		if attempt > 1 {
+			c.Retries++
+		}
+	})
+}
+
+// Succeeded registra que la operación terminó bien en el intento attempt
+func (r *Recorder) Succeeded(category string, attempt int) {
+	if attempt <= 1 {
+		return
+	}
+	r.update(category, func(c *Counts) { c.SucceededAfterRetry++ })
+}
+
+// Exhausted registra que la operación falló tras agotar los reintentos
+func (r *Recorder) Exhausted(category string) {
+	r.update(category, func(c *Counts) { c.Exhausted++ })
+}
+
+// Snapshot devuelve una copia de los contadores por categoría
+func (r *Recorder) Snapshot() map[string]Counts {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshot := make(map[string]Counts, len(r.counts))
+	for category, counts := range r.counts {
+		snapshot[category] = *counts
+	}
+	return snapshot
+}
+
+// prometheusMetrics son las series expuestas, en orden, con el contador que lee cada una
+var prometheusMetrics = []struct {
+	name  string
+	help  string
+	value func(Counts) int64
+}{
+	{"bedrock_proxy_retry_attempts_total", "Attempts of retryable operations, first attempt included.", func(c Counts) int64 { return c.Attempts }},
+	{"bedrock_proxy_retries_total", "Retries of retryable operations.", func(c Counts) int64 { return c.Retries }},
+	{"bedrock_proxy_retry_succeeded_after_retry_total", "Operations that succeeded after at least one retry.", func(c Counts) int64 { return c.SucceededAfterRetry }},
+	{"bedrock_proxy_retry_exhausted_total", "Operations that failed after exhausting their retries.", func(c Counts) int64 { return c.Exhausted }},
+}
+
+// WritePrometheus escribe los contadores en el formato de texto de Prometheus
+func (r *Recorder) WritePrometheus(w io.Writer) error {
+	snapshot := r.Snapshot()
+	categories := make([]string, 0, len(snapshot))
+	for category := range snapshot {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	for _, metric := range prometheusMetrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name); err != nil {
+			return err
+		}
+		for _, category := range categories {
+			if _, err := fmt.Fprintf(w, "%s{category=%q} %d\n", metric.name, category, metric.value(snapshot[category])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Attempt registra un intento en el Recorder del proceso
+func Attempt(category string, attempt int) { Default.Attempt(category, attempt) }
+
+// Succeeded registra un éxito en el Recorder del proceso
+func Succeeded(category string, attempt int) { Default.Succeeded(category, attempt) }
+
+// Exhausted registra una operación agotada en el Recorder del proceso
+func Exhausted(category string) { Default.Exhausted(category) }
+
+// Snapshot devuelve los contadores del Recorder del proceso
+func Snapshot() map[string]Counts { return Default.Snapshot() }
@@ -0,0 +1,69 @@
+package retrystats
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRecorderAccumulatesPerCategory(t *testing.T) {
+	r := NewRecorder()
+
+	// Bedrock: dos throttles y éxito al tercer intento
+	for attempt := 1; attempt <= 3; attempt++ {
+		r.Attempt(CategoryBedrock, attempt)
+	}
+	r.Succeeded(CategoryBedrock, 3)
+
+	// BD: éxito al primer intento y otro batch que agota los reintentos
+	r.Attempt(CategoryDB, 1)
+	r.Succeeded(CategoryDB, 1)
+	r.Attempt(CategoryDB, 1)
+	r.Attempt(CategoryDB, 2)
+	r.Exhausted(CategoryDB)
+
+	// Webhook concurrente
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Attempt(CategoryWebhook, 1)
+		}()
+	}
+	wg.Wait()
+
+	snapshot := r.Snapshot()
+	if got := snapshot[CategoryBedrock]; got != (Counts{Attempts: 3, Retries: 2, SucceededAfterRetry: 1}) {
+		t.Errorf("Unexpected bedrock counts: %+v", got)
+	}
+	if got := snapshot[CategoryDB]; got != (Counts{Attempts: 3, Retries: 1, Exhausted: 1}) {
+		t.Errorf("Unexpected db counts: %+v", got)
+	}
+	if got := snapshot[CategoryWebhook]; got != (Counts{Attempts: 50}) {
+		t.Errorf("Unexpected webhook counts: %+v", got)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRecorder()
+	r.Attempt(CategoryBedrock, 1)
+	r.Attempt(CategoryBedrock, 2)
+	r.Exhausted(CategoryBedrock)
+
+	var out strings.Builder
+	if err := r.WritePrometheus(&out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, line := range []string{
+		"# TYPE bedrock_proxy_retries_total counter",
+		`bedrock_proxy_retry_attempts_total{category="bedrock"} 2`,
+		`bedrock_proxy_retries_total{category="bedrock"} 1`,
+		`bedrock_proxy_retry_exhausted_total{category="bedrock"} 1`,
+		`bedrock_proxy_retry_attempts_total{category="webhook"} 0`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package scheduler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+)
+
+// Formato y nombres de los objetos exportados
+const (
+	MetricsExportFormat       = "ndjson.gz"
+	metricsExportManifestName = "_manifest.json"
+	DefaultMetricsExportHour  = 1 // Hora UTC: margen tras medianoche para que lleguen las métricas rezagadas
+	// DefaultMetricsExportCatchUpDays es hasta cuántos días atrás se recuperan días sin exportar
+	DefaultMetricsExportCatchUpDays = 7
+)
+
+// MetricsExportConfig configura el export diario de métricas de uso a S3
+type MetricsExportConfig struct {
+	Bucket string // Bucket destino (vacío desactiva el export)
+	Prefix string // Prefijo de las claves (p.ej. "warehouse/bedrock-proxy/usage")
+	Region string // Región del bucket
+	Hour   int    // Hora UTC a la que se exporta el día anterior
+	// CatchUpDays es hasta cuántos días atrás se buscan días sin manifest (p.ej. tras una caída o un
+	// fallo de S3) para exportarlos en la siguiente ejecución
+	CatchUpDays int
+}
+
+// Enabled indica si el export está configurado
+func (c MetricsExportConfig) Enabled() bool {
+	return c.Bucket != ""
+}
+
+// ObjectWriter abstrae el almacenamiento de objetos (S3 en producción)
+type ObjectWriter interface {
+	PutObject(ctx context.Context, key string, body []byte, contentType string) error
+	ObjectExists(ctx context.Context, key string) (bool, error)
+}
+
+// ExportManifest describe un día exportado; se escribe al final y marca el día como completo
+type ExportManifest struct {
+	Date        string               `json:"date"`
+	Format      string               `json:"format"`
+	RowCount    int                  `json:"row_count"`
+	GeneratedAt string               `json:"generated_at"`
+	Files       []ExportManifestFile `json:"files"`
+}
+
+// ExportManifestFile describe un fichero de datos del export
+type ExportManifestFile struct {
+	Key      string `json:"key"`
+	Bytes    int    `json:"bytes"`
+	RowCount int    `json:"row_count"`
+	SHA256   string `json:"sha256"`
+}
+
+// exportRecord es la fila NDJSON exportada (nombres de columna estables para el warehouse)
+type exportRecord struct {
+	CognitoUserID       string  `json:"cognito_user_id"`
+	CognitoEmail        string  `json:"cognito_email"`
+	Team                string  `json:"team"`
+	Person              string  `json:"person"`
+	RequestTimestamp    string  `json:"request_timestamp"`
+	ModelID             string  `json:"model_id"`
+	ServedModelID       string  `json:"served_model_id,omitempty"`
+	SourceIP            string  `json:"source_ip"`
+	UserAgent           string  `json:"user_agent"`
+	AWSRegion           string  `json:"aws_region"`
+	TokensInput         int     `json:"tokens_input"`
+	TokensOutput        int     `json:"tokens_output"`
+	TokensCacheRead     int     `json:"tokens_cache_read"`
+	TokensCacheCreation int     `json:"tokens_cache_creation"`
+	CostUSD             float64 `json:"cost_usd"`
+	ProcessingTimeMS    int     `json:"processing_time_ms"`
+	ResponseStatus      string  `json:"response_status"`
+	ErrorMessage        string  `json:"error_message,omitempty"`
+	ConversationID      string  `json:"conversation_id,omitempty"`
+	RequestedModel      string  `json:"requested_model,omitempty"`
+	BedrockLatencyMS    int64   `json:"bedrock_latency_ms,omitempty"`
+	StreamDurationMS    int64   `json:"stream_duration_ms,omitempty"`
+	ProjectID           string  `json:"project_id,omitempty"`
+	BatchID             string  `json:"batch_id,omitempty"`
+}
+
+// MetricsExporter exporta los registros de uso de un día a S3 con un manifest
+type MetricsExporter struct {
+	config MetricsExportConfig
+	writer ObjectWriter
+	source func(ctx context.Context, day time.Time) ([]database.UsageTrackingData, error)
+	now    func() time.Time
+}
+
+// NewMetricsExporter crea un exporter que lee los registros de la BD (todos los equipos)
+func NewMetricsExporter(db *database.Database, writer ObjectWriter, config MetricsExportConfig) *MetricsExporter {
+	return &MetricsExporter{
+		config: config,
+		writer: writer,
+		source: func(ctx context.Context, day time.Time) ([]database.UsageTrackingData, error) {
+			return db.GetUsageTrackingForDay(ctx, database.AllTeams(), day)
+		},
+		now: time.Now,
+	}
+}
+
+// partitionPrefix devuelve el prefijo particionado por fecha (estilo Hive: dt=YYYY-MM-DD)
+func (e *MetricsExporter) partitionPrefix(day time.Time) string {
+	partition := "dt=" + day.UTC().Format("2006-01-02")
+	prefix := strings.Trim(e.config.Prefix, "/")
+	if prefix == "" {
+		return partition
+	}
+	return path.Join(prefix, partition)
+}
+
+// dataKey devuelve la clave determinista del fichero de datos de un día (re-ejecuciones lo sobrescriben)
+func (e *MetricsExporter) dataKey(day time.Time) string {
+	return path.Join(e.partitionPrefix(day), fmt.Sprintf("usage-%s.%s", day.UTC().Format("2006-01-02"), MetricsExportFormat))
+}
+
+// manifestKey devuelve la clave del manifest de un día
+func (e *MetricsExporter) manifestKey(day time.Time) string {
+	return path.Join(e.partitionPrefix(day), metricsExportManifestName)
+}
+
+// serializeUsageRecords convierte los registros a NDJSON comprimido con gzip
+func serializeUsageRecords(records []database.UsageTrackingData) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+
+	for _, record := range records {
+		row := exportRecord{
+			CognitoUserID:       record.CognitoUserID,
+			CognitoEmail:        record.CognitoEmail,
+			Team:                record.Team,
+			Person:              record.Person,
+			RequestTimestamp:    record.RequestTimestamp.UTC().Format(time.RFC3339Nano),
+			ModelID:             record.ModelID,
+			ServedModelID:       record.ServedModelID,
+			SourceIP:            record.SourceIP,
+			UserAgent:           record.UserAgent,
+			AWSRegion:           record.AWSRegion,
+			TokensInput:         record.TokensInput,
+			TokensOutput:        record.TokensOutput,
+			TokensCacheRead:     record.TokensCacheRead,
+			TokensCacheCreation: record.TokensCacheCreation,
+			CostUSD:             record.CostUSD,
+			ProcessingTimeMS:    record.ProcessingTimeMS,
+			ResponseStatus:      record.ResponseStatus,
+			ErrorMessage:        record.ErrorMessage,
+			ConversationID:      record.ConversationID,
+			RequestedModel:      record.RequestedModel,
+			BedrockLatencyMS:    record.BedrockLatencyMS,
+			StreamDurationMS:    record.StreamDurationMS,
+			ProjectID:           record.ProjectID,
+			BatchID:             record.BatchID,
+		}
+		if err := encoder.Encode(row); err != nil {
+			return nil, fmt.Errorf("error encoding usage record: %w", err)
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing usage records: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ExportDay exporta los registros de un día. Si el manifest ya existe el día se considera
+// exportado y no se vuelve a escribir (re-ejecuciones idempotentes); devuelve skipped=true
+func (e *MetricsExporter) ExportDay(ctx context.Context, day time.Time) (*ExportManifest, bool, error) {
+	manifestKey := e.manifestKey(day)
+	exists, err := e.writer.ObjectExists(ctx, manifestKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("error checking export manifest: %w", err)
+	}
+	if exists {
+		return nil, true, nil
+	}
+
+	records, err := e.source(ctx, day)
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, err := serializeUsageRecords(records)
+	if err != nil {
+		return nil, false, err
+	}
+
+	dataKey := e.dataKey(day)
+	if err := e.writer.PutObject(ctx, dataKey, data, "application/gzip"); err != nil {
+		return nil, false, fmt.Errorf("error writing export data: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	manifest := &ExportManifest{
+		Date:        day.UTC().Format("2006-01-02"),
+		Format:      MetricsExportFormat,
+		RowCount:    len(records),
+		GeneratedAt: e.now().UTC().Format(time.RFC3339),
+		Files: []ExportManifestFile{{
+			Key:      dataKey,
+			Bytes:    len(data),
+			RowCount: len(records),
+			SHA256:   hex.EncodeToString(sum[:]),
+		}},
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, false, fmt.Errorf("error encoding export manifest: %w", err)
+	}
+
+	// El manifest se escribe el último: si falla, la siguiente ejecución repite el día completo
+	if err := e.writer.PutObject(ctx, manifestKey, manifestJSON, "application/json"); err != nil {
+		return nil, false, fmt.Errorf("error writing export manifest: %w", err)
+	}
+
+	return manifest, false, nil
+}
+
+// ExportPendingDays exporta, del más antiguo al más reciente, los días desde el último con manifest
+// hasta el día UTC anterior a now, mirando como mucho CatchUpDays días atrás. Así un día que no se
+// exportó (proxy caído a la hora del export, fallo de S3) se recupera en la siguiente ejecución.
+// Se detiene en el primer error: los manifests ya escritos se devuelven y el resto se reintenta después
+func (e *MetricsExporter) ExportPendingDays(ctx context.Context) ([]*ExportManifest, error) {
+	pending, err := e.pendingDays(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []*ExportManifest
+	for _, day := range pending {
+		manifest, skipped, err := e.ExportDay(ctx, day)
+		if err != nil {
+			return manifests, fmt.Errorf("export of %s: %w", day.Format("2006-01-02"), err)
+		}
+		if !skipped {
+			manifests = append(manifests, manifest)
+		}
+	}
+	return manifests, nil
+}
+
+// pendingDays devuelve los días sin exportar posteriores al último manifest, en orden cronológico
+func (e *MetricsExporter) pendingDays(ctx context.Context) ([]time.Time, error) {
+	catchUp := e.config.CatchUpDays
+	if catchUp < 1 {
+		catchUp = 1
+	}
+
+	now := e.now().UTC()
+	yesterday := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, time.UTC)
+
+	var pending []time.Time
+	for day := yesterday; len(pending) < catchUp; day = day.AddDate(0, 0, -1) {
+		exists, err := e.writer.ObjectExists(ctx, e.manifestKey(day))
+		if err != nil {
+			return nil, fmt.Errorf("error checking export manifest: %w", err)
+		}
+		if exists {
+			break
+		}
+		pending = append([]time.Time{day}, pending...)
+	}
+	return pending, nil
+}
+
+// nextExportTime calcula la próxima ejecución del export a la hora UTC configurada
+func nextExportTime(now time.Time, hour int) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
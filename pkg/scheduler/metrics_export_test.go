@@ -0,0 +1,244 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+)
+
+// memoryObjectWriter guarda los objetos en memoria para los tests
+type memoryObjectWriter struct {
+	objects map[string][]byte
+	puts    int
+}
+
+func newMemoryObjectWriter() *memoryObjectWriter {
+	return &memoryObjectWriter{objects: make(map[string][]byte)}
+}
+
+func (w *memoryObjectWriter) PutObject(ctx context.Context, key string, body []byte, contentType string) error {
+	w.objects[key] = body
+	w.puts++
+	return nil
+}
+
+func (w *memoryObjectWriter) ObjectExists(ctx context.Context, key string) (bool, error) {
+	_, ok := w.objects[key]
+	return ok, nil
+}
+
+func newTestExporter(writer ObjectWriter, records []database.UsageTrackingData) (*MetricsExporter, *int) {
+	queries := 0
+	return &MetricsExporter{
+		config: MetricsExportConfig{Bucket: "warehouse", Prefix: "/bedrock-proxy/usage/"},
+		writer: writer,
+		source: func(ctx context.Context, day time.Time) ([]database.UsageTrackingData, error) {
+			queries++
+			return records, nil
+		},
+		now: func() time.Time { return time.Date(2025, 3, 2, 1, 0, 0, 0, time.UTC) },
+	}, &queries
+}
+
+func decodeNDJSON(t *testing.T, data []byte) []map[string]interface{} {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected gzip data: %v", err)
+	}
+
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var row map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("Invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestPartitionKeys(t *testing.T) {
+	exporter, _ := newTestExporter(newMemoryObjectWriter(), nil)
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if got := exporter.dataKey(day); got != "bedrock-proxy/usage/dt=2025-03-01/usage-2025-03-01.ndjson.gz" {
+		t.Errorf("Unexpected data key: %s", got)
+	}
+	if got := exporter.manifestKey(day); got != "bedrock-proxy/usage/dt=2025-03-01/_manifest.json" {
+		t.Errorf("Unexpected manifest key: %s", got)
+	}
+
+	exporter.config.Prefix = ""
+	if got := exporter.manifestKey(day); got != "dt=2025-03-01/_manifest.json" {
+		t.Errorf("Unexpected manifest key without prefix: %s", got)
+	}
+}
+
+func TestSerializeUsageRecords(t *testing.T) {
+	records := []database.UsageTrackingData{
+		{
+			CognitoUserID:    "user-1",
+			Team:             "data",
+			RequestTimestamp: time.Date(2025, 3, 1, 10, 30, 0, 0, time.UTC),
+			ModelID:          "anthropic.claude-sonnet",
+			TokensInput:      100,
+			TokensOutput:     50,
+			CostUSD:          0.0125,
+			ResponseStatus:   "success",
+		},
+		{CognitoUserID: "user-2", ResponseStatus: "error", ErrorMessage: "throttled"},
+	}
+
+	data, err := serializeUsageRecords(records)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rows := decodeNDJSON(t, data)
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 NDJSON rows, got %d", len(rows))
+	}
+	if rows[0]["cognito_user_id"] != "user-1" || rows[0]["team"] != "data" {
+		t.Errorf("Unexpected first row: %v", rows[0])
+	}
+	if rows[0]["request_timestamp"] != "2025-03-01T10:30:00Z" {
+		t.Errorf("Expected RFC3339 UTC timestamp, got %v", rows[0]["request_timestamp"])
+	}
+	if rows[0]["tokens_input"] != float64(100) || rows[0]["cost_usd"] != 0.0125 {
+		t.Errorf("Unexpected numeric columns: %v", rows[0])
+	}
+	if _, ok := rows[0]["error_message"]; ok {
+		t.Error("Expected empty error_message to be omitted")
+	}
+	if rows[1]["error_message"] != "throttled" {
+		t.Errorf("Expected error_message on second row, got %v", rows[1]["error_message"])
+	}
+}
+
+func TestExportPendingDaysWritesDataAndManifest(t *testing.T) {
+	writer := newMemoryObjectWriter()
+	exporter, _ := newTestExporter(writer, []database.UsageTrackingData{{CognitoUserID: "user-1"}})
+
+	manifests, err := exporter.ExportPendingDays(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(manifests) != 1 {
+		t.Fatalf("Expected only the previous day to be exported, got %d manifests", len(manifests))
+	}
+
+	manifest := manifests[0]
+	if manifest.Date != "2025-03-01" || manifest.RowCount != 1 || len(manifest.Files) != 1 {
+		t.Fatalf("Unexpected manifest: %+v", manifest)
+	}
+
+	stored, ok := writer.objects["bedrock-proxy/usage/dt=2025-03-01/_manifest.json"]
+	if !ok {
+		t.Fatal("Expected manifest to be written")
+	}
+	var decoded ExportManifest
+	if err := json.Unmarshal(stored, &decoded); err != nil {
+		t.Fatalf("Invalid manifest JSON: %v", err)
+	}
+	file := decoded.Files[0]
+	if len(writer.objects[file.Key]) != file.Bytes || file.SHA256 == "" {
+		t.Errorf("Manifest file entry does not match stored data: %+v", file)
+	}
+}
+
+func TestExportPendingDaysCatchesUpMissedDays(t *testing.T) {
+	writer := newMemoryObjectWriter()
+	exporter, queries := newTestExporter(writer, []database.UsageTrackingData{{CognitoUserID: "user-1"}})
+	exporter.config.CatchUpDays = 7
+	exporter.now = func() time.Time { return time.Date(2025, 3, 1, 1, 0, 0, 0, time.UTC) }
+	if _, err := exporter.ExportPendingDays(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// El proxy estuvo caído a la hora del export del 1 y 2 de marzo: el día 4 se exportan los dos
+	exporter.now = func() time.Time { return time.Date(2025, 3, 4, 1, 0, 0, 0, time.UTC) }
+	*queries = 0
+	manifests, err := exporter.ExportPendingDays(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var dates []string
+	for _, manifest := range manifests {
+		dates = append(dates, manifest.Date)
+	}
+	if strings.Join(dates, ",") != "2025-03-01,2025-03-02,2025-03-03" || *queries != 3 {
+		t.Errorf("Expected 01..03 to be exported in order, got %v (%d queries)", dates, *queries)
+	}
+
+	// Sin ningún manifest se mira como mucho CatchUpDays días atrás
+	empty := newMemoryObjectWriter()
+	exporter, _ = newTestExporter(empty, nil)
+	exporter.config.CatchUpDays = 3
+	manifests, err = exporter.ExportPendingDays(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(manifests) != 3 || manifests[0].Date != "2025-02-27" {
+		t.Errorf("Expected the last 3 days starting at 2025-02-27, got %d manifests", len(manifests))
+	}
+}
+
+func TestSerializeUsageRecordsIncludesTrackedColumns(t *testing.T) {
+	data, err := serializeUsageRecords([]database.UsageTrackingData{{
+		CognitoUserID:    "user-1",
+		RequestedModel:   "claude-3-5-sonnet-latest",
+		BedrockLatencyMS: 820,
+		ProjectID:        "apollo",
+		BatchID:          "batch-1",
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	row := decodeNDJSON(t, data)[0]
+	if row["requested_model"] != "claude-3-5-sonnet-latest" || row["bedrock_latency_ms"] != float64(820) ||
+		row["project_id"] != "apollo" || row["batch_id"] != "batch-1" {
+		t.Errorf("Expected the tracked columns to be exported, got %v", row)
+	}
+}
+
+func TestExportDayIsIdempotent(t *testing.T) {
+	writer := newMemoryObjectWriter()
+	exporter, queries := newTestExporter(writer, []database.UsageTrackingData{{CognitoUserID: "user-1"}})
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, _, err := exporter.ExportDay(context.Background(), day); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, skipped, err := exporter.ExportDay(context.Background(), day)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !skipped {
+		t.Error("Expected re-run to be skipped once the manifest exists")
+	}
+	if *queries != 1 || writer.puts != 2 {
+		t.Errorf("Expected a single export (1 query, 2 puts), got %d queries and %d puts", *queries, writer.puts)
+	}
+}
+
+func TestNextExportTime(t *testing.T) {
+	before := time.Date(2025, 3, 1, 0, 30, 0, 0, time.UTC)
+	if got := nextExportTime(before, 1); !got.Equal(time.Date(2025, 3, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected same-day run, got %v", got)
+	}
+
+	after := time.Date(2025, 3, 1, 1, 0, 0, 0, time.UTC)
+	if got := nextExportTime(after, 1); !got.Equal(time.Date(2025, 3, 2, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected next-day run, got %v", got)
+	}
+}
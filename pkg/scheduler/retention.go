@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bedrock-proxy-test/pkg/database"
+)
+
+// DefaultRetentionHour es la hora UTC a la que se ejecuta la retención (tras el export diario)
+const DefaultRetentionHour = 3
+
+// RetentionConfig configura la eliminación de particiones antiguas de métricas
+type RetentionConfig struct {
+	Days   int    // Días de métricas a conservar (<= 0 desactiva la retención)
+	DryRun bool   // Solo registra las particiones que se eliminarían
+	Hour   int    // Hora UTC de ejecución diaria
+	Table  string // Tabla particionada (por defecto la de usage tracking)
+}
+
+// Enabled indica si la retención está configurada
+func (c RetentionConfig) Enabled() bool {
+	return c.Days > 0
+}
+
+// partitionLayouts son los sufijos de fecha reconocidos en el nombre de una partición y el
+// tamaño del rango que cubren. Una partición con otro sufijo (p.ej. _default) nunca se elimina
+var partitionLayouts = []struct {
+	layout string
+	months int
+	days   int
+}{
+	{"2006_01_02", 0, 1},
+	{"20060102", 0, 1},
+	{"2006_01", 1, 0},
+	{"200601", 1, 0},
+}
+
+// partitionRange devuelve el rango [start, end) de fechas que cubre una partición a partir de su
+// nombre (<tabla>_<fecha> o <tabla>_p<fecha>)
+func partitionRange(table, partition string) (start, end time.Time, ok bool) {
+	suffix, found := strings.CutPrefix(partition, table+"_")
+	if !found {
+		return time.Time{}, time.Time{}, false
+	}
+	suffix = strings.TrimPrefix(suffix, "p")
+
+	for _, candidate := range partitionLayouts {
+		if len(suffix) != len(candidate.layout) {
+			continue
+		}
+		if start, err := time.Parse(candidate.layout, suffix); err == nil {
+			return start, start.AddDate(0, candidate.months, candidate.days), true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// selectExpiredPartitions devuelve, ordenadas, las particiones cuyo rango termina antes del corte.
+// Una partición que todavía contiene algún día dentro de la retención se conserva entera
+func selectExpiredPartitions(table string, partitions []string, cutoff time.Time) []string {
+	var expired []string
+	for _, partition := range partitions {
+		if _, end, ok := partitionRange(table, partition); ok && !end.After(cutoff) {
+			expired = append(expired, partition)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// retentionCutoff es el inicio del día más antiguo que se conserva
+func retentionCutoff(now time.Time, days int) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -days)
+}
+
+// RetentionResult resume una ejecución de la retención
+type RetentionResult struct {
+	Cutoff  time.Time
+	Expired []string // Particiones fuera de la ventana de retención (<schema>.<partición> en los schemas dedicados)
+	Dropped []string // Particiones eliminadas (vacío en dry-run)
+	DryRun  bool
+}
+
+// PartitionRetention elimina las particiones de métricas más antiguas que la ventana de retención,
+// en la tabla compartida y en las de los schemas dedicados por equipo
+type PartitionRetention struct {
+	config  RetentionConfig
+	schemas func() []string // Schemas con tabla de usage tracking ("" = el schema actual)
+	list    func(ctx context.Context, schema, table string) ([]string, error)
+	drop    func(ctx context.Context, schema, table, partition string) error
+	now     func() time.Time
+}
+
+// NewPartitionRetention crea la retención sobre las tablas de usage tracking de la BD
+func NewPartitionRetention(db *database.Database, config RetentionConfig) *PartitionRetention {
+	if config.Table == "" {
+		config.Table = database.UsageTrackingTable
+	}
+	return &PartitionRetention{
+		config:  config,
+		schemas: db.UsageSchemas,
+		list:    db.ListPartitions,
+		drop:    db.DropPartition,
+		now:     time.Now,
+	}
+}
+
+// qualifiedPartition es el nombre con el que se informa de una partición (con schema si no es el actual)
+func qualifiedPartition(schema, partition string) string {
+	if schema == "" {
+		return partition
+	}
+	return schema + "." + partition
+}
+
+// Run elimina (o, en dry-run, solo selecciona) las particiones expiradas de cada schema. Es idempotente:
+// una partición ya eliminada deja de listarse. Si falla un drop se detiene y devuelve lo eliminado hasta entonces
+func (r *PartitionRetention) Run(ctx context.Context) (RetentionResult, error) {
+	result := RetentionResult{
+		Cutoff: retentionCutoff(r.now(), r.config.Days),
+		DryRun: r.config.DryRun,
+	}
+
+	for _, schema := range r.schemas() {
+		partitions, err := r.list(ctx, schema, r.config.Table)
+		if err != nil {
+			return result, err
+		}
+		expired := selectExpiredPartitions(r.config.Table, partitions, result.Cutoff)
+		for _, partition := range expired {
+			result.Expired = append(result.Expired, qualifiedPartition(schema, partition))
+		}
+
+		if r.config.DryRun {
+			continue
+		}
+		for _, partition := range expired {
+			name := qualifiedPartition(schema, partition)
+			if err := r.drop(ctx, schema, r.config.Table, partition); err != nil {
+				return result, fmt.Errorf("error dropping partition %s: %w", name, err)
+			}
+			result.Dropped = append(result.Dropped, name)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testRetentionTable = "bedrock-proxy-usage-tracking-tbl"
+
+func TestSelectExpiredPartitions(t *testing.T) {
+	partitions := []string{
+		testRetentionTable + "_2025_02_27",
+		testRetentionTable + "_2025_02_28",
+		testRetentionTable + "_2025_03_01",
+		testRetentionTable + "_20250226",
+		testRetentionTable + "_p2025_01",
+		testRetentionTable + "_2025_02", // Contiene días dentro de la retención
+		testRetentionTable + "_default",
+		testRetentionTable + "_archive",
+		testRetentionTable + "_2025_13_01",
+		"other-tbl_2020_01_01",
+	}
+	cutoff := time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC)
+
+	got := selectExpiredPartitions(testRetentionTable, partitions, cutoff)
+	expected := []string{
+		testRetentionTable + "_20250226",
+		testRetentionTable + "_2025_02_27",
+		testRetentionTable + "_p2025_01",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Date(2025, 3, 10, 15, 30, 0, 0, time.UTC)
+	if got := retentionCutoff(now, 7); !got.Equal(time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected cutoff 2025-03-03, got %v", got)
+	}
+}
+
+// newTestRetention simula la BD: list devuelve las particiones que quedan y drop las elimina
+func newTestRetention(config RetentionConfig, partitions []string) (*PartitionRetention, *[]string) {
+	remaining := append([]string(nil), partitions...)
+	dropped := []string{}
+	config.Table = testRetentionTable
+	return &PartitionRetention{
+		config:  config,
+		schemas: func() []string { return []string{""} },
+		list: func(ctx context.Context, schema, table string) ([]string, error) {
+			return append([]string(nil), remaining...), nil
+		},
+		drop: func(ctx context.Context, schema, table, partition string) error {
+			for i, name := range remaining {
+				if name == partition {
+					remaining = append(remaining[:i], remaining[i+1:]...)
+					break
+				}
+			}
+			dropped = append(dropped, partition)
+			return nil
+		},
+		now: func() time.Time { return time.Date(2025, 3, 10, 3, 0, 0, 0, time.UTC) },
+	}, &dropped
+}
+
+func TestPartitionRetentionDryRunDropsNothing(t *testing.T) {
+	retention, dropped := newTestRetention(RetentionConfig{Days: 7, DryRun: true}, []string{
+		testRetentionTable + "_2025_03_01",
+		testRetentionTable + "_2025_03_05",
+	})
+
+	result, err := retention.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Expired) != 1 || result.Expired[0] != testRetentionTable+"_2025_03_01" {
+		t.Errorf("Expected 2025_03_01 to be selected, got %v", result.Expired)
+	}
+	if len(*dropped) != 0 || len(result.Dropped) != 0 {
+		t.Errorf("Expected no drops in dry-run, got %v", *dropped)
+	}
+}
+
+func TestPartitionRetentionIsIdempotent(t *testing.T) {
+	retention, dropped := newTestRetention(RetentionConfig{Days: 7}, []string{
+		testRetentionTable + "_2025_02_28",
+		testRetentionTable + "_2025_03_01",
+		testRetentionTable + "_2025_03_05",
+	})
+
+	for run := 0; run < 2; run++ {
+		if _, err := retention.Run(context.Background()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{testRetentionTable + "_2025_02_28", testRetentionTable + "_2025_03_01"}
+	if !reflect.DeepEqual(*dropped, expected) {
+		t.Errorf("Expected each expired partition dropped once %v, got %v", expected, *dropped)
+	}
+}
+
+func TestPartitionRetentionStopsOnDropError(t *testing.T) {
+	retention, _ := newTestRetention(RetentionConfig{Days: 7}, []string{
+		testRetentionTable + "_2025_02_28",
+		testRetentionTable + "_2025_03_01",
+	})
+	retention.drop = func(ctx context.Context, schema, table, partition string) error {
+		return errors.New("lock timeout")
+	}
+
+	result, err := retention.Run(context.Background())
+	if err == nil {
+		t.Fatal("Expected drop error")
+	}
+	if len(result.Dropped) != 0 {
+		t.Errorf("Expected nothing dropped, got %v", result.Dropped)
+	}
+}
+
+func TestPartitionRetentionCoversTeamSchemas(t *testing.T) {
+	partitions := map[string][]string{
+		"":               {testRetentionTable + "_2025_03_01", testRetentionTable + "_2025_03_05"},
+		"tenant_finance": {testRetentionTable + "_2025_02_28"},
+	}
+	var dropped []string
+	retention := &PartitionRetention{
+		config:  RetentionConfig{Days: 7, Table: testRetentionTable},
+		schemas: func() []string { return []string{"", "tenant_finance"} },
+		list: func(ctx context.Context, schema, table string) ([]string, error) {
+			return partitions[schema], nil
+		},
+		drop: func(ctx context.Context, schema, table, partition string) error {
+			dropped = append(dropped, schema+"|"+partition)
+			return nil
+		},
+		now: func() time.Time { return time.Date(2025, 3, 10, 3, 0, 0, 0, time.UTC) },
+	}
+
+	result, err := retention.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"|" + testRetentionTable + "_2025_03_01", "tenant_finance|" + testRetentionTable + "_2025_02_28"}
+	if !reflect.DeepEqual(dropped, expected) {
+		t.Errorf("Expected drops in every schema %v, got %v", expected, dropped)
+	}
+	if len(result.Dropped) != 2 || result.Dropped[1] != "tenant_finance."+testRetentionTable+"_2025_02_28" {
+		t.Errorf("Expected schema-qualified names in the result, got %v", result.Dropped)
+	}
+}
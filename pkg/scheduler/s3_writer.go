@@ -0,0 +1,110 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// S3ObjectWriter escribe objetos en S3 con peticiones firmadas SigV4 (sin depender del SDK de S3)
+type S3ObjectWriter struct {
+	bucket      string
+	region      string
+	credentials aws.CredentialsProvider
+	httpClient  *http.Client
+	signer      *v4.Signer
+}
+
+// NewS3ObjectWriter crea un writer para el bucket usando la cadena de credenciales por defecto de AWS
+func NewS3ObjectWriter(ctx context.Context, bucket, region string) (*S3ObjectWriter, error) {
+	cfg, err := awsConfig.LoadDefaultConfig(ctx, awsConfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+
+	return &S3ObjectWriter{
+		bucket:      bucket,
+		region:      cfg.Region,
+		credentials: cfg.Credentials,
+		httpClient:  &http.Client{Timeout: 5 * time.Minute},
+		signer:      v4.NewSigner(),
+	}, nil
+}
+
+// objectURL devuelve la URL virtual-hosted del objeto
+func (w *S3ObjectWriter) objectURL(key string) string {
+	var escaped []string
+	for _, segment := range strings.Split(key, "/") {
+		escaped = append(escaped, url.PathEscape(segment))
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", w.bucket, w.region, strings.Join(escaped, "/"))
+}
+
+// do firma y ejecuta una petición contra S3
+func (w *S3ObjectWriter) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, w.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	hash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(hash[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	credentialList, err := w.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve credentials: %v", err)
+	}
+	if err := w.signer.SignHTTP(ctx, credentialList, req, payloadHash, "s3", w.region, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %v", err)
+	}
+
+	return w.httpClient.Do(req)
+}
+
+// PutObject sube (o sobrescribe) un objeto
+func (w *S3ObjectWriter) PutObject(ctx context.Context, key string, body []byte, contentType string) error {
+	resp, err := w.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("S3 PUT %s returned status %d: %s", key, resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// ObjectExists comprueba con HEAD si el objeto existe
+func (w *S3ObjectWriter) ObjectExists(ctx context.Context, key string) (bool, error) {
+	resp, err := w.do(ctx, http.MethodHead, key, nil, "")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("S3 HEAD %s returned status %d", key, resp.StatusCode)
+	}
+}
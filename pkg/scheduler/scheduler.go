@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"bedrock-proxy-test/pkg/database"
+	"bedrock-proxy-test/pkg/quota"
 	"context"
 	"time"
 )
@@ -18,9 +19,19 @@ type Logger interface {
 
 // SchedulerService gestiona tareas programadas
 type SchedulerService struct {
-	db     *database.Database
-	logger Logger
-	stopCh chan struct{}
+	db        *database.Database
+	logger    Logger
+	stopCh    chan struct{}
+	exporter  *MetricsExporter
+	retention *PartitionRetention
+
+	now func() time.Time
+
+	// dbTimeZone devuelve la zona horaria con la que la BD corta los días (nil = sin comprobar)
+	dbTimeZone func(ctx context.Context) (string, error)
+
+	// resetConfig fija la hora y zona horaria del reset diario (por defecto medianoche UTC)
+	resetConfig quota.ResetConfig
 }
 
 // ResetResult contiene los resultados del reset diario
@@ -33,40 +44,113 @@ type ResetResult struct {
 
 // NewSchedulerService crea una nueva instancia del scheduler
 func NewSchedulerService(db *database.Database, logger Logger) *SchedulerService {
-	return &SchedulerService{
-		db:     db,
-		logger: logger,
-		stopCh: make(chan struct{}),
+	s := &SchedulerService{
+		db:          db,
+		logger:      logger,
+		stopCh:      make(chan struct{}),
+		now:         time.Now,
+		resetConfig: quota.DefaultResetConfig(),
+	}
+	if db != nil {
+		s.dbTimeZone = db.DatabaseTimeZone
 	}
+	return s
+}
+
+// SetResetConfig establece la hora y zona horaria del reset diario (QUOTA_RESET_TIMEZONE/QUOTA_RESET_HOUR)
+// para que el scheduler corte los días en la misma frontera que las cuotas (debe llamarse antes de Start)
+func (s *SchedulerService) SetResetConfig(rc quota.ResetConfig) {
+	s.resetConfig = rc
+}
+
+// checkResetBoundary avisa si la BD corta los días en otra frontera que QUOTA_RESET_TIMEZONE/QUOTA_RESET_HOUR.
+// Los contadores los resetea check_and_update_quota() con CURRENT_DATE en la zona horaria de la BD; esa
+// función vive en el esquema de la BD y no en este repositorio, así que aquí solo se puede detectar
+func (s *SchedulerService) checkResetBoundary(ctx context.Context) {
+	if s.dbTimeZone == nil {
+		return
+	}
+	timeZone, err := s.dbTimeZone(ctx)
+	if err != nil {
+		s.logger.Errorf("Could not check the database timezone against the quota reset: %v", err)
+		return
+	}
+	if resetBoundaryMismatch(timeZone, s.resetConfig, s.now()) {
+		s.logger.Errorf("Daily quota counters are reset by the database at midnight %s, not at %02d:00 %s: "+
+			"set the database TimeZone to match QUOTA_RESET_TIMEZONE and QUOTA_RESET_HOUR=0",
+			timeZone, s.resetConfig.Hour, s.resetConfig.TimeZone())
+	}
+}
+
+// resetBoundaryMismatch indica si la medianoche de la zona horaria de la BD no coincide con el reset
+// configurado. Compara los offsets en invierno y verano para detectar también las diferencias de DST
+func resetBoundaryMismatch(dbTimeZone string, rc quota.ResetConfig, now time.Time) bool {
+	if rc.Hour != 0 {
+		return true
+	}
+	dbLocation, err := time.LoadLocation(dbTimeZone)
+	if err != nil {
+		return true
+	}
+	resetLocation := rc.TimeZone()
+	for _, instant := range []time.Time{now, now.AddDate(0, 6, 0)} {
+		_, dbOffset := instant.In(dbLocation).Zone()
+		_, resetOffset := instant.In(resetLocation).Zone()
+		if dbOffset != resetOffset {
+			return true
+		}
+	}
+	return false
 }
 
 // Start inicia todos los schedulers
 func (s *SchedulerService) Start() {
 	s.logger.Info("Starting scheduler service...")
+	s.checkResetBoundary(context.Background())
 	
-	// Scheduler para reset diario a medianoche UTC
+	// Scheduler para reset diario (medianoche UTC salvo QUOTA_RESET_TIMEZONE/QUOTA_RESET_HOUR)
 	go s.runDailyResetScheduler()
 	
+	// Export diario de métricas a S3 (opcional)
+	if s.exporter != nil {
+		go s.runMetricsExportScheduler()
+	}
+	
+	// Retención de particiones de métricas (opcional)
+	if s.retention != nil {
+		go s.runRetentionScheduler()
+	}
+	
 	s.logger.Info("Scheduler service started successfully")
 }
 
+// SetMetricsExporter activa el export diario de métricas (debe llamarse antes de Start)
+func (s *SchedulerService) SetMetricsExporter(exporter *MetricsExporter) {
+	s.exporter = exporter
+}
+
+// SetPartitionRetention activa la eliminación diaria de particiones antiguas (debe llamarse antes de Start)
+func (s *SchedulerService) SetPartitionRetention(retention *PartitionRetention) {
+	s.retention = retention
+}
+
 // Stop detiene todos los schedulers
 func (s *SchedulerService) Stop() {
 	s.logger.Info("Stopping scheduler service...")
 	close(s.stopCh)
 }
 
-// runDailyResetScheduler ejecuta el reset diario a medianoche UTC
+// runDailyResetScheduler ejecuta el reset diario a la hora y zona horaria configuradas
 func (s *SchedulerService) runDailyResetScheduler() {
 	for {
-		// Calcular tiempo hasta medianoche UTC
-		now := time.Now().UTC()
-		nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
-		duration := nextMidnight.Sub(now)
+		// Calcular tiempo hasta el próximo reset en la zona horaria configurada
+		now := s.now()
+		nextReset := s.resetConfig.NextDailyReset(now)
+		duration := nextReset.Sub(now)
 		
-		s.logger.Infof("Next daily reset scheduled in %v (at %v UTC)", duration, nextMidnight.Format("2006-01-02 15:04:05"))
+		s.logger.Infof("Next daily reset scheduled in %v (at %v)", duration, nextReset.Format("2006-01-02 15:04:05 MST"))
 		
-		// Esperar hasta medianoche o hasta que se detenga el servicio
+		// Esperar hasta el reset o hasta que se detenga el servicio
 		select {
 		case <-time.After(duration):
 			// Ejecutar reset diario
@@ -83,14 +167,89 @@ func (s *SchedulerService) runDailyResetScheduler() {
 	}
 }
 
+// runMetricsExportScheduler exporta cada día, a la hora UTC configurada, las métricas del día anterior
+func (s *SchedulerService) runMetricsExportScheduler() {
+	for {
+		now := time.Now().UTC()
+		next := nextExportTime(now, s.exporter.config.Hour)
+		duration := next.Sub(now)
+		
+		s.logger.Infof("Next metrics export scheduled in %v (at %v UTC)", duration, next.Format("2006-01-02 15:04:05"))
+		
+		select {
+		case <-time.After(duration):
+			s.RunMetricsExport(context.Background())
+		case <-s.stopCh:
+			s.logger.Info("Metrics export scheduler stopped")
+			return
+		}
+	}
+}
+
+// RunMetricsExport exporta a S3 las métricas del día anterior y de los días anteriores que quedaran sin exportar
+func (s *SchedulerService) RunMetricsExport(ctx context.Context) {
+	startTime := time.Now()
+	manifests, err := s.exporter.ExportPendingDays(ctx)
+	for _, manifest := range manifests {
+		s.logger.Infof("Metrics export for %s completed (%d rows)", manifest.Date, manifest.RowCount)
+	}
+	switch {
+	case err != nil:
+		s.logger.Errorf("Failed to export metrics: %v", err)
+	case len(manifests) == 0:
+		s.logger.Info("Metrics export skipped: previous day already exported")
+	default:
+		s.logger.Infof("Metrics export of %d days completed in %v", len(manifests), time.Since(startTime))
+	}
+}
+
+// runRetentionScheduler elimina cada día, a la hora UTC configurada, las particiones expiradas
+func (s *SchedulerService) runRetentionScheduler() {
+	for {
+		now := time.Now().UTC()
+		next := nextExportTime(now, s.retention.config.Hour)
+		duration := next.Sub(now)
+		
+		s.logger.Infof("Next partition retention scheduled in %v (at %v UTC)", duration, next.Format("2006-01-02 15:04:05"))
+		
+		select {
+		case <-time.After(duration):
+			s.RunRetention(context.Background())
+		case <-s.stopCh:
+			s.logger.Info("Partition retention scheduler stopped")
+			return
+		}
+	}
+}
+
+// RunRetention elimina las particiones de métricas fuera de la ventana de retención
+// En dry-run solo registra las que se eliminarían
+func (s *SchedulerService) RunRetention(ctx context.Context) {
+	result, err := s.retention.Run(ctx)
+	cutoff := result.Cutoff.Format("2006-01-02")
+	switch {
+	case err != nil:
+		s.logger.Errorf("Partition retention failed (cutoff %s, dropped %v): %v", cutoff, result.Dropped, err)
+	case result.DryRun:
+		s.logger.Infof("Partition retention dry-run (cutoff %s): would drop %d partitions %v", cutoff, len(result.Expired), result.Expired)
+	default:
+		s.logger.Infof("Partition retention completed (cutoff %s): dropped %d partitions %v", cutoff, len(result.Dropped), result.Dropped)
+	}
+}
+
 // RunDailyReset ejecuta el reset de contadores diarios
 // NOTA: Con el nuevo sistema, el reset diario se hace automáticamente
 // mediante la función PostgreSQL check_and_update_quota() que detecta
 // cambios de día y resetea los contadores. Este scheduler se mantiene
 // para logging y monitoreo, pero no ejecuta acciones en BD.
+// La función corta el día con CURRENT_DATE en la zona horaria de la BD; Start avisa si no coincide
+// con el reset configurado (checkResetBoundary).
+// Como no escribe en BD, cada réplica lo ejecuta sin coordinarse con el resto.
+// La fecha es la del día de cuota en la zona horaria del reset, no la de la BD ni la UTC
 func (s *SchedulerService) RunDailyReset(ctx context.Context) error {
 	startTime := time.Now()
-	s.logger.Info("Daily reset checkpoint - counters reset automatically by PostgreSQL function")
+	date := s.resetConfig.DailyPeriodStart(s.now()).Format("2006-01-02")
+	s.logger.Infof("Daily reset checkpoint for %s - counters reset automatically by PostgreSQL function", date)
 	
 	// El reset real se hace automáticamente en check_and_update_quota()
 	// cuando detecta que es un nuevo día (current_date > last_reset_date)
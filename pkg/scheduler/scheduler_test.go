@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/quota"
+)
+
+// recordingLogger guarda los mensajes del scheduler
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func (l *recordingLogger) Info(args ...interface{}) { l.record(fmt.Sprint(args...)) }
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.record(fmt.Sprintf(format, args...))
+}
+func (l *recordingLogger) Error(args ...interface{}) { l.record(fmt.Sprint(args...)) }
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.record(fmt.Sprintf(format, args...))
+}
+func (l *recordingLogger) Debug(args ...interface{})                 {}
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {}
+
+func (l *recordingLogger) contains(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, msg := range l.messages {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDailyResetUsesResetTimezoneAroundBoundary(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+
+	// Con la BD en UTC, 23:00:01 UTC del día 9 ya es el día 10 en Madrid y debe resetearse
+	fixtures := []struct {
+		now      time.Time
+		wantDate string
+	}{
+		{time.Date(2025, 3, 9, 22, 59, 59, 0, time.UTC), "2025-03-09"},
+		{time.Date(2025, 3, 9, 23, 0, 1, 0, time.UTC), "2025-03-10"},
+		{time.Date(2025, 3, 10, 0, 0, 1, 0, time.UTC), "2025-03-10"},
+	}
+	for _, fixture := range fixtures {
+		logger := &recordingLogger{}
+		s := NewSchedulerService(nil, logger)
+		s.now = func() time.Time { return fixture.now }
+		s.SetResetConfig(quota.ResetConfig{Location: madrid, Hour: 0})
+
+		if err := s.RunDailyReset(context.Background()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !logger.contains("Daily reset checkpoint for " + fixture.wantDate) {
+			t.Errorf("At %v expected reset of %s, got %v", fixture.now, fixture.wantDate, logger.messages)
+		}
+	}
+
+	// El siguiente reset se programa a medianoche de Madrid, no de UTC
+	s := NewSchedulerService(nil, &recordingLogger{})
+	s.SetResetConfig(quota.ResetConfig{Location: madrid, Hour: 0})
+	now := time.Date(2025, 3, 9, 22, 30, 0, 0, time.UTC)
+	if next := s.resetConfig.NextDailyReset(now); !next.Equal(time.Date(2025, 3, 9, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected next reset at 23:00 UTC, got %v", next.UTC())
+	}
+}
+
+func TestResetBoundaryMismatch(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		dbTimeZone string
+		reset      quota.ResetConfig
+		mismatch   bool
+	}{
+		{"UTC", quota.DefaultResetConfig(), false},
+		{"Etc/UTC", quota.DefaultResetConfig(), false},
+		{"Europe/Madrid", quota.ResetConfig{Location: madrid}, false},
+		// Con la BD en UTC, los usuarios de Madrid se resetearían a la 01:00 (02:00 en verano)
+		{"UTC", quota.ResetConfig{Location: madrid}, true},
+		// Mismo offset en invierno pero sin DST: en verano cortan a horas distintas
+		{"Africa/Lagos", quota.ResetConfig{Location: madrid}, true},
+		{"UTC", quota.ResetConfig{Location: time.UTC, Hour: 6}, true},
+		{"Not/AZone", quota.DefaultResetConfig(), true},
+	}
+	for _, c := range cases {
+		if got := resetBoundaryMismatch(c.dbTimeZone, c.reset, now); got != c.mismatch {
+			t.Errorf("resetBoundaryMismatch(%q, %v, %d) = %v, want %v", c.dbTimeZone, c.reset.TimeZone(), c.reset.Hour, got, c.mismatch)
+		}
+	}
+
+	logger := &recordingLogger{}
+	s := NewSchedulerService(nil, logger)
+	s.SetResetConfig(quota.ResetConfig{Location: madrid})
+	s.dbTimeZone = func(ctx context.Context) (string, error) { return "UTC", nil }
+	s.checkResetBoundary(context.Background())
+	if !logger.contains("reset by the database at midnight UTC") {
+		t.Errorf("Expected a warning about the database timezone, got %v", logger.messages)
+	}
+}
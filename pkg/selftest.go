@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// Estados posibles de un check del self-test
+const (
+	SelfTestPass = "pass"
+	SelfTestFail = "fail"
+	SelfTestSkip = "skip"
+)
+
+// SelfTestCheck representa el resultado de una comprobación individual
+type SelfTestCheck struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// SelfTestReport es el informe estructurado que imprime `--selftest`
+type SelfTestReport struct {
+	Status    string          `json:"status"`
+	Timestamp string          `json:"timestamp"`
+	Checks    []SelfTestCheck `json:"checks"`
+}
+
+// Passed indica si ningún check ha fallado (los omitidos no cuentan como fallo)
+func (r *SelfTestReport) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Status == SelfTestFail {
+			return false
+		}
+	}
+	return true
+}
+
+// add añade un check al informe y recalcula el estado global
+func (r *SelfTestReport) add(check SelfTestCheck) {
+	r.Checks = append(r.Checks, check)
+	r.Status = SelfTestPass
+	if !r.Passed() {
+		r.Status = SelfTestFail
+	}
+}
+
+// runCheck ejecuta fn midiendo su duración
+func runCheck(name string, fn func() (string, string)) SelfTestCheck {
+	start := time.Now()
+	status, message := fn()
+	return SelfTestCheck{
+		Name:       name,
+		Status:     status,
+		Message:    message,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+}
+
+// CheckBedrockConfig valida que la configuración de Bedrock sea utilizable
+func CheckBedrockConfig(config *BedrockConfig) SelfTestCheck {
+	return runCheck("config", func() (string, string) {
+		if config == nil {
+			return SelfTestFail, "configuration not loaded"
+		}
+		if config.AccessKey == "" || config.SecretKey == "" || config.Region == "" {
+			return SelfTestFail, "missing AWS credentials (AWS_BEDROCK_ACCESS_KEY, AWS_BEDROCK_SECRET_KEY, AWS_BEDROCK_REGION)"
+		}
+		// 0 = sin límite configurado (se respeta el max_tokens del cliente)
+		if config.MaxTokens < 0 {
+			return SelfTestFail, fmt.Sprintf("invalid AWS_BEDROCK_MAX_TOKENS: %d", config.MaxTokens)
+		}
+		if config.RequestTimeout < 0 {
+			return SelfTestFail, fmt.Sprintf("invalid REQUEST_TIMEOUT_SECONDS: %s", config.RequestTimeout)
+		}
+		return SelfTestPass, ""
+	})
+}
+
+// CheckDatabaseConnectivity verifica la conexión a PostgreSQL mediante ping
+// Si ping es nil la BD no está configurada y el check se omite
+func CheckDatabaseConnectivity(ctx context.Context, ping func(context.Context) error) SelfTestCheck {
+	return runCheck("database", func() (string, string) {
+		if ping == nil {
+			return SelfTestSkip, "database not configured"
+		}
+		if err := ping(ctx); err != nil {
+			return SelfTestFail, err.Error()
+		}
+		return SelfTestPass, ""
+	})
+}
+
+// CheckModelMappingsAvailable comprueba que todos los model mappings existan en Bedrock
+func CheckModelMappingsAvailable(mappings map[string]string, fetch func() ([]BedrockFoundationModel, error)) SelfTestCheck {
+	return runCheck("model_mappings", func() (string, string) {
+		if len(mappings) == 0 {
+			return SelfTestSkip, "no model mappings configured"
+		}
+
+		availableModels, err := fetch()
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		available := make(map[string]bool, len(availableModels))
+		for _, model := range availableModels {
+			available[model.ModelId] = true
+		}
+
+		var missing []string
+		for configModel, bedrockModelId := range mappings {
+			if !available[bedrockModelId] {
+				missing = append(missing, fmt.Sprintf("%s=%s", configModel, bedrockModelId))
+			}
+		}
+		if len(missing) > 0 {
+			return SelfTestFail, fmt.Sprintf("model mappings not available in Bedrock: %v", missing)
+		}
+		return SelfTestPass, ""
+	})
+}
+
+// CheckJWTRoundTrip emite un JWT de prueba y lo valida con la misma configuración
+func CheckJWTRoundTrip(jwtConfig *JWTConfig) SelfTestCheck {
+	return runCheck("jwt", func() (string, string) {
+		if jwtConfig == nil {
+			return SelfTestSkip, "JWT not configured"
+		}
+
+		authConfig := auth.JWTConfig{
+			SecretKey: jwtConfig.SecretKey,
+			Issuer:    jwtConfig.Issuer,
+			Audience:  jwtConfig.Audience,
+		}
+
+		token, jti, err := auth.CreateToken(authConfig, "selftest", "selftest@localhost", "selftest", nil)
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+
+		claims, err := auth.ValidateToken(token, jwtConfig.SecretKey)
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+		if claims.ID != jti || claims.UserID != "selftest" {
+			return SelfTestFail, "validated claims do not match minted token"
+		}
+		return SelfTestPass, ""
+	})
+}
+
+// RunSelfTest ejecuta todas las comprobaciones sin arrancar el servidor HTTP
+func RunSelfTest(ctx context.Context) *SelfTestReport {
+	report := &SelfTestReport{
+		Status:    SelfTestPass,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Checks:    []SelfTestCheck{},
+	}
+
+	config := LoadBedrockConfigWithEnv()
+	report.add(CheckBedrockConfig(config))
+
+	// La BD es opcional: si no está configurada el check se omite
+	dbConnConfig := LoadDatabaseConnectionConfig()
+	db, dbErr := InitializeDatabase(ctx)
+	if dbErr != nil {
+		if dbConnConfig.UseSecretsManager || dbConnConfig.Host != "" {
+			report.add(runCheck("database", func() (string, string) {
+				return SelfTestFail, dbErr.Error()
+			}))
+		} else {
+			report.add(CheckDatabaseConnectivity(ctx, nil))
+		}
+	} else {
+		defer db.Close()
+		report.add(CheckDatabaseConnectivity(ctx, db.Ping))
+	}
+
+	client := NewBedrockClient(config)
+	report.add(CheckModelMappingsAvailable(config.ModelMappings, client.GetBedrockAvailableModels))
+
+	// El JWT solo es obligatorio cuando hay BD (autenticación activa)
+	jwtConfig, jwtErr := LoadJWTConfigWithEnv()
+	if jwtErr != nil {
+		if db != nil {
+			report.add(runCheck("jwt", func() (string, string) {
+				return SelfTestFail, jwtErr.Error()
+			}))
+		} else {
+			report.add(CheckJWTRoundTrip(nil))
+		}
+	} else {
+		report.add(CheckJWTRoundTrip(jwtConfig))
+	}
+
+	return report
+}
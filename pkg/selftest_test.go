@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckBedrockConfig(t *testing.T) {
+	config := newTestBedrockClient().config
+	config.MaxTokens = 1024
+	if check := CheckBedrockConfig(config); check.Status != SelfTestPass {
+		t.Errorf("Expected pass, got %s (%s)", check.Status, check.Message)
+	}
+
+	config.MaxTokens = 0
+	if check := CheckBedrockConfig(config); check.Status != SelfTestPass {
+		t.Errorf("Expected pass with unset max tokens, got %s (%s)", check.Status, check.Message)
+	}
+	config.MaxTokens = -1
+	if check := CheckBedrockConfig(config); check.Status != SelfTestFail {
+		t.Errorf("Expected fail with negative max tokens, got %s", check.Status)
+	}
+	config.MaxTokens = 1024
+
+	config.SecretKey = ""
+	if check := CheckBedrockConfig(config); check.Status != SelfTestFail {
+		t.Errorf("Expected fail with missing credentials, got %s", check.Status)
+	}
+
+	if check := CheckBedrockConfig(nil); check.Status != SelfTestFail {
+		t.Errorf("Expected fail with nil config, got %s", check.Status)
+	}
+}
+
+func TestCheckDatabaseConnectivity(t *testing.T) {
+	ctx := context.Background()
+
+	if check := CheckDatabaseConnectivity(ctx, nil); check.Status != SelfTestSkip {
+		t.Errorf("Expected skip without database, got %s", check.Status)
+	}
+
+	ok := func(context.Context) error { return nil }
+	if check := CheckDatabaseConnectivity(ctx, ok); check.Status != SelfTestPass {
+		t.Errorf("Expected pass, got %s", check.Status)
+	}
+
+	failing := func(context.Context) error { return errors.New("connection refused") }
+	check := CheckDatabaseConnectivity(ctx, failing)
+	if check.Status != SelfTestFail || check.Message != "connection refused" {
+		t.Errorf("Expected fail with ping error, got %s (%s)", check.Status, check.Message)
+	}
+}
+
+func TestCheckModelMappingsAvailable(t *testing.T) {
+	fetch := func() ([]BedrockFoundationModel, error) {
+		return []BedrockFoundationModel{{ModelId: "anthropic.claude-3-haiku-20240307-v1:0"}}, nil
+	}
+
+	if check := CheckModelMappingsAvailable(nil, fetch); check.Status != SelfTestSkip {
+		t.Errorf("Expected skip without mappings, got %s", check.Status)
+	}
+
+	valid := map[string]string{"claude-3-haiku": "anthropic.claude-3-haiku-20240307-v1:0"}
+	if check := CheckModelMappingsAvailable(valid, fetch); check.Status != SelfTestPass {
+		t.Errorf("Expected pass, got %s (%s)", check.Status, check.Message)
+	}
+
+	invalid := map[string]string{"claude-x": "anthropic.claude-x-v1:0"}
+	check := CheckModelMappingsAvailable(invalid, fetch)
+	if check.Status != SelfTestFail || !strings.Contains(check.Message, "claude-x") {
+		t.Errorf("Expected fail naming the missing mapping, got %s (%s)", check.Status, check.Message)
+	}
+
+	failingFetch := func() ([]BedrockFoundationModel, error) { return nil, errors.New("access denied") }
+	if check := CheckModelMappingsAvailable(valid, failingFetch); check.Status != SelfTestFail {
+		t.Errorf("Expected fail when Bedrock is unreachable, got %s", check.Status)
+	}
+}
+
+func TestCheckJWTRoundTrip(t *testing.T) {
+	if check := CheckJWTRoundTrip(nil); check.Status != SelfTestSkip {
+		t.Errorf("Expected skip without JWT config, got %s", check.Status)
+	}
+
+	jwtConfig := &JWTConfig{
+		SecretKey: strings.Repeat("s", 32),
+		Issuer:    "identity-manager",
+		Audience:  "bedrock-proxy",
+	}
+	if check := CheckJWTRoundTrip(jwtConfig); check.Status != SelfTestPass {
+		t.Errorf("Expected pass, got %s (%s)", check.Status, check.Message)
+	}
+}
+
+func TestSelfTestReportStatus(t *testing.T) {
+	report := &SelfTestReport{Status: SelfTestPass, Timestamp: time.Now().UTC().Format(time.RFC3339)}
+
+	report.add(SelfTestCheck{Name: "database", Status: SelfTestSkip})
+	if !report.Passed() || report.Status != SelfTestPass {
+		t.Errorf("Expected skipped checks not to fail the report, got %s", report.Status)
+	}
+
+	report.add(SelfTestCheck{Name: "jwt", Status: SelfTestFail})
+	if report.Passed() || report.Status != SelfTestFail {
+		t.Errorf("Expected report to fail, got %s", report.Status)
+	}
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Valores por defecto del listener HTTP
+const (
+	DefaultServerPort        = "8080"
+	DefaultServerIdleTimeout = 120 * time.Second // Mayor que el idle timeout típico del load balancer (60s)
+	DefaultShutdownTimeout   = 25 * time.Second  // Menor que el stopTimeout por defecto de ECS (30s) antes del SIGKILL
+)
+
+// ServerConfig configura el listener HTTP del proxy.
+//
+// Por defecto solo se sirve HTTP/1.1: el streaming SSE de /v1/messages depende de que cada
+// Flush llegue al cliente, y con HTTP/2 algunos clientes y proxies intermedios agrupan los
+// frames DATA o se quedan sin ventana de control de flujo en respuestas largas, de modo que
+// los eventos llegan en bloques o el stream se corta. HTTP2Enabled activa HTTP/2 sin TLS (h2c,
+// p.ej. detrás de un load balancer que habla h2c con el backend) además de HTTP/1.1
+type ServerConfig struct {
+	Port              string
+	HTTP2Enabled      bool          // Aceptar HTTP/2 (h2c) además de HTTP/1.1
+	KeepAlivesEnabled bool          // Reutilizar conexiones HTTP/1.1 entre requests
+	IdleTimeout       time.Duration // Tiempo máximo de una conexión keep-alive inactiva
+	ShutdownTimeout   time.Duration // Espera máxima a las requests en curso y a la limpieza al recibir SIGTERM
+}
+
+// LoadServerConfigWithEnv carga la configuración del listener
+// SERVER_HTTP2=true activa h2c; SERVER_KEEPALIVE=false cierra la conexión tras cada request
+func LoadServerConfigWithEnv() ServerConfig {
+	config := ServerConfig{
+		Port:              getEnvOrDefault("PORT", DefaultServerPort),
+		HTTP2Enabled:      os.Getenv("SERVER_HTTP2") == "true",
+		KeepAlivesEnabled: os.Getenv("SERVER_KEEPALIVE") != "false",
+		IdleTimeout:       DefaultServerIdleTimeout,
+		ShutdownTimeout:   DefaultShutdownTimeout,
+	}
+	if idleStr := os.Getenv("SERVER_IDLE_TIMEOUT_SECONDS"); idleStr != "" {
+		if seconds, err := strconv.Atoi(idleStr); err == nil && seconds > 0 {
+			config.IdleTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if shutdownStr := os.Getenv("SERVER_SHUTDOWN_TIMEOUT_SECONDS"); shutdownStr != "" {
+		if seconds, err := strconv.Atoi(shutdownStr); err == nil && seconds > 0 {
+			config.ShutdownTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+	return config
+}
+
+// NewHTTPServer crea el servidor HTTP con los protocolos y keep-alive configurados
+func NewHTTPServer(config ServerConfig, handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if config.HTTP2Enabled {
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	server := &http.Server{
+		Addr:        ":" + config.Port,
+		Handler:     handler,
+		Protocols:   protocols,
+		IdleTimeout: config.IdleTimeout,
+	}
+	server.SetKeepAlivesEnabled(config.KeepAlivesEnabled)
+	return server
+}
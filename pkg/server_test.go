@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startTestServer arranca el servidor configurado en un puerto local y devuelve su URL
+func startTestServer(t *testing.T, config ServerConfig) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := NewHTTPServer(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "http://" + listener.Addr().String()
+}
+
+// newH2CClient crea un cliente que habla HTTP/2 sin TLS (prior knowledge)
+func newH2CClient() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: protocols}, Timeout: 5 * time.Second}
+}
+
+func TestServerHTTP1OnlyByDefault(t *testing.T) {
+	t.Setenv("SERVER_HTTP2", "")
+	url := startTestServer(t, LoadServerConfigWithEnv())
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("HTTP/1.1 request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Proto"); got != "HTTP/1.1" {
+		t.Errorf("Expected HTTP/1.1, got %q", got)
+	}
+
+	if resp, err := newH2CClient().Get(url); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected h2c request to fail with HTTP/2 disabled, got %s", resp.Proto)
+	}
+}
+
+func TestServerHTTP2Enabled(t *testing.T) {
+	url := startTestServer(t, ServerConfig{HTTP2Enabled: true, KeepAlivesEnabled: true})
+
+	resp, err := newH2CClient().Get(url)
+	if err != nil {
+		t.Fatalf("h2c request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Proto"); got != "HTTP/2.0" {
+		t.Errorf("Expected HTTP/2.0, got %q", got)
+	}
+
+	// HTTP/1.1 sigue disponible para los clientes SSE
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatalf("HTTP/1.1 request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Proto"); got != "HTTP/1.1" {
+		t.Errorf("Expected HTTP/1.1, got %q", got)
+	}
+}
+
+func TestServerKeepAlivesDisabled(t *testing.T) {
+	url := startTestServer(t, ServerConfig{KeepAlivesEnabled: false})
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if !resp.Close {
+		t.Error("Expected server to close the connection with keep-alives disabled")
+	}
+}
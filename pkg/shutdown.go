@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// WaitPostProcessing espera a que terminen los post-processing de métricas en curso (facturación de
+// requests ya respondidas) o a que venza ctx
+func (this *BedrockClient) WaitPostProcessing(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		this.postProcessing.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ServeUntilSignal sirve en listener hasta recibir una señal y entonces apaga de forma ordenada: deja
+// de aceptar conexiones, espera a las requests en curso (streams incluidos) y ejecuta cleanup en orden.
+// timeout cubre el drenado y la limpieza; las conexiones que siguen abiertas al vencer se cierran
+func ServeUntilSignal(server *http.Server, listener net.Listener, signals <-chan os.Signal, timeout time.Duration, cleanup ...func(ctx context.Context)) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	var sig os.Signal
+	select {
+	case err := <-serveErr:
+		return err
+	case sig = <-signals:
+	}
+
+	start := time.Now()
+	Logger.Info(amslog.Event{
+		Name:    EventServerShutdown,
+		Message: "Shutdown signal received, draining active requests",
+		Fields: map[string]interface{}{
+			"signal":              sig.String(),
+			"shutdown.timeout_ms": timeout.Milliseconds(),
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		Logger.Warning(amslog.Event{
+			Name:    EventServerShutdown,
+			Message: "Shutdown timeout reached, closing remaining connections",
+			Outcome: amslog.OutcomeFailure,
+			Error: &amslog.ErrorInfo{
+				Type:    "ShutdownTimeout",
+				Message: err.Error(),
+			},
+		})
+		server.Close()
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	for _, fn := range cleanup {
+		fn(ctx)
+	}
+
+	Logger.Info(amslog.Event{
+		Name:       EventServerShutdown,
+		Message:    "Server stopped",
+		Outcome:    amslog.OutcomeSuccess,
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+	return nil
+}
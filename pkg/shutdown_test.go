@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServeUntilSignalDrainsRequestsBeforeCleanup(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	var mu sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+
+	// Request en curso que termina después de recibir la señal
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	server := NewHTTPServer(ServerConfig{KeepAlivesEnabled: true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		record("request")
+		w.Write([]byte("OK"))
+	}))
+	server.RegisterOnShutdown(func() { record("shutdown") })
+
+	// Post-processing pendiente de la request anterior
+	client := newTestBedrockClient()
+	client.postProcessing.Add(1)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeUntilSignal(server, listener, signals, 5*time.Second,
+			func(ctx context.Context) {
+				if err := client.WaitPostProcessing(ctx); err != nil {
+					t.Errorf("Expected post-processing to finish, got %v", err)
+				}
+				record("post_processing")
+			},
+			func(context.Context) { record("metrics_worker") },
+			func(context.Context) { record("scheduler") },
+			func(context.Context) { record("database") },
+		)
+	}()
+
+	response := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			t.Errorf("In-flight request failed: %v", err)
+			close(response)
+			return
+		}
+		response <- resp
+	}()
+	<-entered
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("Failed to send SIGTERM: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		record("post_processing_done")
+		client.postProcessing.Done()
+	}()
+
+	if resp, ok := <-response; ok {
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected in-flight request to complete with 200, got %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected server to stop after SIGTERM")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := "shutdown,request,post_processing_done,post_processing,metrics_worker,scheduler,database"
+	if got := strings.Join(steps, ","); got != want {
+		t.Errorf("Expected shutdown order %s, got %s", want, got)
+	}
+	if !strings.Contains(logs.String(), "signal") || !strings.Contains(logs.String(), "Server stopped") {
+		t.Errorf("Expected shutdown events, got %s", logs.String())
+	}
+}
+
+func TestServeUntilSignalForcesCloseAfterTimeout(t *testing.T) {
+	setupTestLogger(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	entered := make(chan struct{})
+	server := NewHTTPServer(ServerConfig{KeepAlivesEnabled: true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	cleaned := false
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeUntilSignal(server, listener, signals, 100*time.Millisecond, func(context.Context) { cleaned = true })
+	}()
+	go http.Get("http://" + listener.Addr().String())
+	<-entered
+
+	signals <- syscall.SIGINT
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Expected shutdown without error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected stuck request to be cut after the shutdown timeout")
+	}
+	if !cleaned {
+		t.Error("Expected cleanup to run after a forced close")
+	}
+}
@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"context"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// slowRequestPhases son las fases que por sí solas marcan una request como lenta
+var slowRequestPhases = []string{"bedrock_call", "streaming"}
+
+// LogIfSlow emite SLOW_REQUEST con el desglose por fases si la duración total o la de la llamada a
+// Bedrock supera threshold (<= 0 desactiva). Las fases se miden en todas las requests, muestreadas o
+// no (PHASE_TRACING_SAMPLE), así que el desglose está siempre disponible
+func (rc *RequestContext) LogIfSlow(ctx context.Context, threshold time.Duration) bool {
+	if threshold <= 0 {
+		return false
+	}
+
+	// Se indica la fase que lo dispara si la hay (más útil que "total" para localizar el retraso)
+	trigger := ""
+	rc.mu.RLock()
+	for _, phase := range slowRequestPhases {
+		if rc.PhaseTimings[phase] > threshold {
+			trigger = phase
+			break
+		}
+	}
+	rc.mu.RUnlock()
+	total := rc.GetTotalDuration()
+	if trigger == "" && total > threshold {
+		trigger = "total"
+	}
+	if trigger == "" {
+		return false
+	}
+
+	Logger.WarningContext(ctx, amslog.Event{
+		Name:       EventSlowRequest,
+		Message:    "Request exceeded the slow request threshold",
+		DurationMs: total.Milliseconds(),
+		Fields: map[string]interface{}{
+			"request.id":                rc.RequestID,
+			"slow_request.threshold_ms": threshold.Milliseconds(),
+			"slow_request.trigger":      trigger,
+			"phases_ms":                 rc.PhaseTimingsMs(),
+		},
+	})
+	return true
+}
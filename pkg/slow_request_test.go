@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogIfSlowEmitsWarningAboveThreshold(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	reqCtx := NewRequestContext("req-slow")
+	reqCtx.StartTime = time.Now().Add(-3 * time.Second)
+	reqCtx.RecordPhase("bedrock_call", 2500*time.Millisecond)
+
+	if !reqCtx.LogIfSlow(context.Background(), time.Second) {
+		t.Fatal("Expected request over the threshold to be logged as slow")
+	}
+	output := logs.String()
+	if !strings.Contains(output, EventSlowRequest) || !strings.Contains(output, `"slow_request.trigger":"bedrock_call"`) {
+		t.Errorf("Expected SLOW_REQUEST triggered by bedrock_call, got %s", output)
+	}
+	if !strings.Contains(output, `"bedrock_call":2500`) {
+		t.Errorf("Expected phase breakdown in SLOW_REQUEST, got %s", output)
+	}
+}
+
+func TestLogIfSlowIgnoresFastRequests(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	reqCtx := NewRequestContext("req-fast")
+	reqCtx.RecordPhase("streaming", 50*time.Millisecond)
+
+	if reqCtx.LogIfSlow(context.Background(), time.Second) {
+		t.Error("Expected fast request not to be logged as slow")
+	}
+	// Umbral 0: desactivado aunque la request sea lenta
+	reqCtx.StartTime = time.Now().Add(-time.Hour)
+	if reqCtx.LogIfSlow(context.Background(), 0) {
+		t.Error("Expected disabled threshold not to log")
+	}
+	if strings.Contains(logs.String(), EventSlowRequest) {
+		t.Errorf("Expected no SLOW_REQUEST event, got %s", logs.String())
+	}
+}
+
+func TestLogIfSlowIncludesPhasesForUnsampledRequests(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	reqCtx := NewSampledRequestContext("req-unsampled", 0)
+	reqCtx.StartTime = time.Now().Add(-3 * time.Second)
+	reqCtx.RecordPhase("streaming", 2500*time.Millisecond)
+
+	if !reqCtx.LogIfSlow(context.Background(), time.Second) {
+		t.Fatal("Expected unsampled request over the threshold to be logged as slow")
+	}
+	output := logs.String()
+	if !strings.Contains(output, `"slow_request.trigger":"streaming"`) || !strings.Contains(output, `"streaming":2500`) {
+		t.Errorf("Expected SLOW_REQUEST with the phase breakdown, got %s", output)
+	}
+}
+
+func TestHandleProxyLogsSlowRequestOnError(t *testing.T) {
+	logs := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.SlowRequestThreshold = time.Nanosecond
+	client.config.PhaseTracingSample = 0
+	client.SetMaintenanceMode(true)
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"messages":[]}`))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 in maintenance mode, got %d", rec.Code)
+	}
+	if strings.Count(logs.String(), `"event.name":"`+EventSlowRequest+`"`) != 1 {
+		t.Errorf("Expected one SLOW_REQUEST on the error path, got %s", logs.String())
+	}
+}
@@ -0,0 +1,48 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// splitTextDelta parte text en fragmentos de como mucho maxBytes bytes sin cortar caracteres UTF-8.
+// maxBytes <= 0 (o un texto que ya cabe) devuelve el texto entero en un solo fragmento
+func splitTextDelta(text string, maxBytes int) []string {
+	if maxBytes <= 0 || len(text) <= maxBytes {
+		return []string{text}
+	}
+	var chunks []string
+	for len(text) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			// maxBytes menor que un carácter: se envía el carácter completo
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	if len(text) > 0 {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// writeTextDeltas escribe text como uno o varios eventos content_block_delta de como mucho maxBytes
+// bytes de texto cada uno (antes del escapado JSON), en orden, para clientes que no toleran eventos
+// SSE muy grandes. Hace Flush tras cada evento
+func writeTextDeltas(w io.Writer, flusher interface{ Flush() }, index int32, text string, maxBytes int) error {
+	for _, chunk := range splitTextDelta(text, maxBytes) {
+		textJSON, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":%d,\"delta\":{\"type\":\"text_delta\",\"text\":%s}}\n\n", index, string(textJSON))
+		flusher.Flush()
+	}
+	return nil
+}
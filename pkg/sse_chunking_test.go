@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitTextDeltaKeepsRunesWhole(t *testing.T) {
+	text := strings.Repeat("añ€😀", 50)
+	chunks := splitTextDelta(text, 7)
+	if strings.Join(chunks, "") != text {
+		t.Fatal("Expected chunks to preserve the full text in order")
+	}
+	for _, chunk := range chunks {
+		if len(chunk) > 7 || !utf8.ValidString(chunk) {
+			t.Fatalf("Expected valid chunks of at most 7 bytes, got %q", chunk)
+		}
+	}
+	if got := splitTextDelta("hola", 0); len(got) != 1 || got[0] != "hola" {
+		t.Errorf("Expected no split when disabled, got %v", got)
+	}
+}
+
+func TestConverseStreamSplitsOversizedTextDelta(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.SSEMaxDeltaBytes = 1024
+	huge := strings.Repeat("0123456789", 10000)
+	hugeJSON, _ := json.Marshal(huge)
+	stub := newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":` + string(hugeJSON) + `}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":5,"outputTokens":3,"totalTokens":8},"metrics":{"latencyMs":10}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	if err := client.handleBedrockStreamConverse(context.Background(), rec, stub, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, nil, nil, converseOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var text strings.Builder
+	deltas := 0
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Text string `json:"text"`
+			} `json:"delta"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil || event.Type != "content_block_delta" {
+			continue
+		}
+		if len(event.Delta.Text) > 1024 {
+			t.Fatalf("Expected deltas of at most 1024 bytes, got %d", len(event.Delta.Text))
+		}
+		deltas++
+		text.WriteString(event.Delta.Text)
+	}
+	if text.String() != huge {
+		t.Errorf("Expected the concatenated deltas to match the original text (%d bytes), got %d bytes", len(huge), text.Len())
+	}
+	if deltas < len(huge)/1024 {
+		t.Errorf("Expected the delta to be split into at least %d events, got %d", len(huge)/1024, deltas)
+	}
+}
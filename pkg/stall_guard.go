@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// DefaultStreamWriteTimeout es el tiempo máximo que puede bloquearse una escritura del stream
+const DefaultStreamWriteTimeout = 30 * time.Second
+
+// errClientStalled indica que el cliente dejó de leer el stream y se abortó
+var errClientStalled = errors.New("client stopped reading the stream")
+
+// stallGuardWriter protege el stream SSE de un cliente que abre la conexión y no lee: el servidor no
+// puede usar un WriteTimeout global con streaming, así que cada Write/Flush fija un write deadline en
+// la conexión y arma un temporizador. Si la escritura sigue bloqueada al vencer se llama a onStall
+// (cancela Bedrock) y las escrituras siguientes fallan sin bloquear
+type stallGuardWriter struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	timeout    time.Duration
+	onStall    func()
+	stalled    atomic.Bool
+}
+
+func newStallGuardWriter(w http.ResponseWriter, timeout time.Duration, onStall func()) *stallGuardWriter {
+	return &stallGuardWriter{
+		ResponseWriter: w,
+		controller:     http.NewResponseController(w),
+		timeout:        timeout,
+		onStall:        onStall,
+	}
+}
+
+// guard ejecuta una escritura con deadline; los errores de deadline cuentan como cliente bloqueado
+func (g *stallGuardWriter) guard(write func() error) error {
+	if g.stalled.Load() {
+		return errClientStalled
+	}
+	// Sin soporte de deadlines (p.ej. writers envueltos) queda el temporizador
+	g.controller.SetWriteDeadline(time.Now().Add(g.timeout))
+	timer := time.AfterFunc(g.timeout, g.stall)
+	err := write()
+	timer.Stop()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		g.stall()
+	}
+	if g.stalled.Load() {
+		return errClientStalled
+	}
+	return err
+}
+
+func (g *stallGuardWriter) stall() {
+	if g.stalled.CompareAndSwap(false, true) && g.onStall != nil {
+		g.onStall()
+	}
+}
+
+func (g *stallGuardWriter) Write(data []byte) (int, error) {
+	var n int
+	err := g.guard(func() error {
+		var writeErr error
+		n, writeErr = g.ResponseWriter.Write(data)
+		return writeErr
+	})
+	return n, err
+}
+
+func (g *stallGuardWriter) Flush() {
+	g.guard(g.controller.Flush)
+}
+
+func (g *stallGuardWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Stalled indica si se abortó el stream por un cliente bloqueado
+func (g *stallGuardWriter) Stalled() bool {
+	return g.stalled.Load()
+}
+
+// release quita el write deadline de la conexión: sin WriteTimeout el servidor no lo restablece y
+// afectaría a la siguiente request keep-alive
+func (g *stallGuardWriter) release() {
+	g.controller.SetWriteDeadline(time.Time{})
+}
+
+// logClientStalled registra el aborto de un stream cuyo cliente dejó de leer
+func logClientStalled(ctx context.Context, timeout time.Duration) {
+	Logger.WarningContext(ctx, amslog.Event{
+		Name:    EventStreamClientStalled,
+		Message: "Client stopped reading the stream, aborting Bedrock stream",
+		Outcome: amslog.OutcomeFailure,
+		Error: &amslog.ErrorInfo{
+			Type:    "ClientStalled",
+			Message: errClientStalled.Error(),
+		},
+		Fields: map[string]interface{}{
+			"stream.write_timeout_ms": timeout.Milliseconds(),
+		},
+	})
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingResponseWriter simula un cliente que no lee: cada escritura se bloquea hasta cerrar release
+type blockingResponseWriter struct {
+	header  http.Header
+	release chan struct{}
+}
+
+func (w *blockingResponseWriter) Header() http.Header { return w.header }
+func (w *blockingResponseWriter) WriteHeader(int)     {}
+func (w *blockingResponseWriter) Flush()              { <-w.release }
+func (w *blockingResponseWriter) Write(data []byte) (int, error) {
+	<-w.release
+	return len(data), nil
+}
+
+func TestStallGuardAbortsBlockedStream(t *testing.T) {
+	logs := setupTestLogger(t)
+	blocked := &blockingResponseWriter{header: make(http.Header), release: make(chan struct{})}
+	defer close(blocked.release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	guard := newStallGuardWriter(blocked, 50*time.Millisecond, func() {
+		logClientStalled(ctx, 50*time.Millisecond)
+		cancel()
+	})
+
+	go guard.Write([]byte("event: content_block_delta\n\n"))
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected blocked write to cancel the Bedrock stream")
+	}
+	if !guard.Stalled() {
+		t.Error("Expected guard to report a stalled client")
+	}
+
+	// Las escrituras posteriores fallan sin bloquear
+	done := make(chan error, 1)
+	go func() {
+		_, err := guard.Write([]byte("more"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errClientStalled) {
+			t.Errorf("Expected errClientStalled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected writes after a stall not to block")
+	}
+	if !strings.Contains(logs.String(), EventStreamClientStalled) {
+		t.Errorf("Expected %s event, got %s", EventStreamClientStalled, logs.String())
+	}
+}
+
+func TestStallGuardPassesThroughFastWrites(t *testing.T) {
+	blocked := &blockingResponseWriter{header: make(http.Header), release: make(chan struct{})}
+	close(blocked.release) // El cliente lee: las escrituras no se bloquean
+
+	stalled := false
+	guard := newStallGuardWriter(blocked, time.Second, func() { stalled = true })
+	if n, err := guard.Write([]byte("hola")); err != nil || n != 4 {
+		t.Fatalf("Expected write to pass through, got %d, %v", n, err)
+	}
+	guard.Flush()
+	guard.release()
+	if stalled || guard.Stalled() {
+		t.Error("Expected no stall for a client that reads")
+	}
+}
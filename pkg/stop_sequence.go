@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// stopSequenceFieldPath es el campo adicional de la respuesta en el que Claude indica la stop sequence
+const stopSequenceFieldPath = "/stop_sequence"
+
+// extractStopSequences obtiene stop_sequences del payload Anthropic; se ignoran los valores vacíos o no string
+func extractStopSequences(payload map[string]interface{}) []string {
+	raw, ok := payload["stop_sequences"].([]interface{})
+	if !ok {
+		return nil
+	}
+	sequences := make([]string, 0, len(raw))
+	for _, value := range raw {
+		if sequence, ok := value.(string); ok && sequence != "" {
+			sequences = append(sequences, sequence)
+		}
+	}
+	if len(sequences) == 0 {
+		return nil
+	}
+	return sequences
+}
+
+// matchedStopSequence devuelve la stop sequence que cortó la generación ("" si no terminó por una).
+// Se toma de los campos adicionales de la respuesta; si no vienen y el cliente solo envió una, es esa
+func matchedStopSequence(reason types.StopReason, fields document.Interface, sequences []string) string {
+	if reason != types.StopReasonStopSequence {
+		return ""
+	}
+	if fields != nil {
+		var response map[string]interface{}
+		if err := fields.UnmarshalSmithyDocument(&response); err == nil {
+			if sequence, ok := response["stop_sequence"].(string); ok && sequence != "" {
+				return sequence
+			}
+		}
+	}
+	if len(sequences) == 1 {
+		return sequences[0]
+	}
+	return ""
+}
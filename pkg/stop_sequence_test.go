@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+func TestConverseStreamReportsMatchedStopSequence(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	stub := newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"1, 2, 3"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"stop_sequence","additionalModelResponseFields":{"stop_sequence":"FIN"}}`},
+		{"metadata", `{"usage":{"inputTokens":5,"outputTokens":3,"totalTokens":8},"metrics":{"latencyMs":10}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	opts := converseOptions{StopSequences: []string{"STOP", "FIN"}}
+	if err := client.handleBedrockStreamConverse(context.Background(), rec, stub, "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 1024, nil, nil, opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), `"delta":{"stop_reason":"stop_sequence","stop_sequence":"FIN"}`) {
+		t.Errorf("Expected matched stop sequence in message_delta, got %s", rec.Body.String())
+	}
+}
+
+func TestBuildConverseStreamInputForwardsStopSequences(t *testing.T) {
+	payload := map[string]interface{}{"stop_sequences": []interface{}{"STOP", "", 3, "FIN"}}
+	opts := converseOptions{StopSequences: extractStopSequences(payload)}
+
+	input := buildConverseStreamInput("eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 100, opts)
+	if got := input.InferenceConfig.StopSequences; len(got) != 2 || got[0] != "STOP" || got[1] != "FIN" {
+		t.Errorf("Expected stop sequences forwarded, got %v", got)
+	}
+	if len(input.AdditionalModelResponseFieldPaths) != 1 || input.AdditionalModelResponseFieldPaths[0] != stopSequenceFieldPath {
+		t.Errorf("Expected stop_sequence response field requested, got %v", input.AdditionalModelResponseFieldPaths)
+	}
+
+	if input := buildConverseStreamInput("eu.anthropic.claude-sonnet-4-5-20250929-v1:0", nil, nil, 100, converseOptions{}); input.AdditionalModelResponseFieldPaths != nil {
+		t.Errorf("Expected no response field paths without stop sequences, got %v", input.AdditionalModelResponseFieldPaths)
+	}
+}
+
+func TestMatchedStopSequenceFallback(t *testing.T) {
+	if got := matchedStopSequence(types.StopReasonStopSequence, nil, []string{"FIN"}); got != "FIN" {
+		t.Errorf("Expected the only stop sequence to be reported, got %q", got)
+	}
+	if got := matchedStopSequence(types.StopReasonStopSequence, nil, []string{"STOP", "FIN"}); got != "" {
+		t.Errorf("Expected no guess with several stop sequences, got %q", got)
+	}
+	if got := matchedStopSequence(types.StopReasonEndTurn, nil, []string{"FIN"}); got != "" {
+		t.Errorf("Expected no stop sequence for end_turn, got %q", got)
+	}
+}
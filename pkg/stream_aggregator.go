@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// streamAggregator captura en memoria la salida SSE del path Converse para devolverla como
+// una única respuesta JSON (STREAMING_DISABLED). Implementa http.Flusher para que
+// handleBedrockStreamConverse funcione sin cambios; los flush no envían nada al cliente
+type streamAggregator struct {
+	header     http.Header
+	statusCode int
+	buffer     bytes.Buffer
+}
+
+func newStreamAggregator() *streamAggregator {
+	return &streamAggregator{
+		header:     make(http.Header),
+		statusCode: http.StatusOK,
+	}
+}
+
+func (a *streamAggregator) Header() http.Header {
+	return a.header
+}
+
+func (a *streamAggregator) Write(data []byte) (int, error) {
+	return a.buffer.Write(data)
+}
+
+func (a *streamAggregator) WriteHeader(statusCode int) {
+	a.statusCode = statusCode
+}
+
+func (a *streamAggregator) Flush() {}
+
+// aggregatedBlock acumula un bloque de contenido (text, tool_use o thinking) a partir de sus deltas
+type aggregatedBlock struct {
+	blockType string
+	text      strings.Builder
+	id        string
+	name      string
+	inputJSON strings.Builder
+	signature strings.Builder
+	data      string
+}
+
+// aggregatedMessage es la respuesta no-stream en formato Anthropic Messages
+type aggregatedMessage struct {
+	ID           string                   `json:"id"`
+	Type         string                   `json:"type"`
+	Role         string                   `json:"role"`
+	Content      []map[string]interface{} `json:"content"`
+	Model        string                   `json:"model"`
+	StopReason   *string                  `json:"stop_reason"`
+	StopSequence *string                  `json:"stop_sequence"`
+	Usage        aggregatedUsage          `json:"usage"`
+}
+
+type aggregatedUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// sseEvent es el subconjunto de campos de los eventos SSE que se necesitan para agregar
+type sseEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		ID    string          `json:"id"`
+		Model string          `json:"model"`
+		Usage aggregatedUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Data string `json:"data"`
+	} `json:"content_block"`
+	Delta struct {
+		Type         string  `json:"type"`
+		Text         string  `json:"text"`
+		PartialJSON  string  `json:"partial_json"`
+		Thinking     string  `json:"thinking"`
+		Signature    string  `json:"signature"`
+		StopReason   *string `json:"stop_reason"`
+		StopSequence *string `json:"stop_sequence"`
+	} `json:"delta"`
+	Usage *sseDeltaUsage `json:"usage"`
+}
+
+// sseDeltaUsage distingue los campos ausentes del usage de message_delta (que no deben pisar los de message_start)
+type sseDeltaUsage struct {
+	InputTokens              *int `json:"input_tokens"`
+	OutputTokens             int  `json:"output_tokens"`
+	CacheCreationInputTokens *int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     *int `json:"cache_read_input_tokens"`
+}
+
+// aggregate reconstruye el mensaje completo a partir de los eventos SSE capturados.
+// Si el stream terminó con un evento de error devuelve su JSON (formato de error de Anthropic)
+func (a *streamAggregator) aggregate() (*aggregatedMessage, []byte) {
+	message := &aggregatedMessage{
+		Type:    "message",
+		Role:    "assistant",
+		Content: []map[string]interface{}{},
+	}
+	blocks := make(map[int]*aggregatedBlock)
+
+	lines := strings.Split(a.buffer.String(), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "event: ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "data: ") {
+			continue
+		}
+		eventType := strings.TrimPrefix(line, "event: ")
+		data := strings.TrimPrefix(lines[i+1], "data: ")
+
+		if eventType == "error" {
+			return nil, []byte(data)
+		}
+
+		var event sseEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue
+		}
+
+		switch eventType {
+		case "message_start":
+			message.ID = event.Message.ID
+			message.Model = event.Message.Model
+			message.Usage = event.Message.Usage
+		case "content_block_start":
+			if _, ok := blocks[event.Index]; !ok {
+				blocks[event.Index] = &aggregatedBlock{
+					blockType: event.ContentBlock.Type,
+					id:        event.ContentBlock.ID,
+					name:      event.ContentBlock.Name,
+					data:      event.ContentBlock.Data,
+				}
+			}
+		case "content_block_delta":
+			block, ok := blocks[event.Index]
+			if !ok {
+				block = &aggregatedBlock{blockType: "text"}
+				blocks[event.Index] = block
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				block.text.WriteString(event.Delta.Text)
+			case "input_json_delta":
+				block.inputJSON.WriteString(event.Delta.PartialJSON)
+			case "thinking_delta":
+				block.text.WriteString(event.Delta.Thinking)
+			case "signature_delta":
+				block.signature.WriteString(event.Delta.Signature)
+			}
+		case "message_delta":
+			message.StopReason = event.Delta.StopReason
+			message.StopSequence = event.Delta.StopSequence
+			if event.Usage != nil {
+				message.Usage.OutputTokens = event.Usage.OutputTokens
+				if event.Usage.InputTokens != nil {
+					message.Usage.InputTokens = *event.Usage.InputTokens
+				}
+				if event.Usage.CacheCreationInputTokens != nil {
+					message.Usage.CacheCreationInputTokens = *event.Usage.CacheCreationInputTokens
+				}
+				if event.Usage.CacheReadInputTokens != nil {
+					message.Usage.CacheReadInputTokens = *event.Usage.CacheReadInputTokens
+				}
+			}
+		}
+	}
+
+	indexes := make([]int, 0, len(blocks))
+	for index := range blocks {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		block := blocks[index]
+		if block.blockType == "tool_use" {
+			input := json.RawMessage("{}")
+			if raw := block.inputJSON.String(); raw != "" && json.Valid([]byte(raw)) {
+				input = json.RawMessage(raw)
+			}
+			message.Content = append(message.Content, map[string]interface{}{
+				"type":  "tool_use",
+				"id":    block.id,
+				"name":  block.name,
+				"input": input,
+			})
+			continue
+		}
+		if block.blockType == "thinking" {
+			message.Content = append(message.Content, map[string]interface{}{
+				"type":      "thinking",
+				"thinking":  block.text.String(),
+				"signature": block.signature.String(),
+			})
+			continue
+		}
+		if block.blockType == "redacted_thinking" {
+			message.Content = append(message.Content, map[string]interface{}{
+				"type": "redacted_thinking",
+				"data": block.data,
+			})
+			continue
+		}
+		message.Content = append(message.Content, map[string]interface{}{
+			"type": "text",
+			"text": block.text.String(),
+		})
+	}
+
+	return message, nil
+}
+
+// writeResponse envía al cliente la respuesta agregada. Los errores anteriores al stream
+// (status != 200) se reenvían tal cual; un error a mitad de stream usa errorStatus
+func (a *streamAggregator) writeResponse(w http.ResponseWriter, errorStatus int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.statusCode != http.StatusOK {
+		w.WriteHeader(a.statusCode)
+		w.Write(a.buffer.Bytes())
+		return
+	}
+
+	message, errorJSON := a.aggregate()
+	if errorJSON != nil {
+		w.WriteHeader(errorStatus)
+		w.Write(errorJSON)
+		return
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"type":"error","error":{"type":"api_error","message":"failed to encode aggregated response"}}`))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	bedrockRuntime "github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+)
+
+// newConverseStreamBody codifica eventos de ConverseStream en formato application/vnd.amazon.eventstream
+func newConverseStreamBody(t *testing.T, events [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder := eventstream.NewEncoder()
+	for _, event := range events {
+		msg := eventstream.Message{Payload: []byte(event[1])}
+		msg.Headers.Set(":message-type", eventstream.StringValue("event"))
+		msg.Headers.Set(":event-type", eventstream.StringValue(event[0]))
+		msg.Headers.Set(":content-type", eventstream.StringValue("application/json"))
+		if err := encoder.Encode(&buf, msg); err != nil {
+			t.Fatalf("Failed to encode event %s: %v", event[0], err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// newStubConverseClient crea un cliente de Bedrock Runtime que responde con el stream dado
+func newStubConverseClient(body []byte) *bedrockRuntime.Client {
+	return bedrockRuntime.New(bedrockRuntime.Options{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-access-key", "test-secret-key", ""),
+		HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+				Body:       io.NopCloser(bytes.NewReader(body)),
+			}, nil
+		})},
+	})
+}
+
+func TestHandleProxyStreamingDisabledReturnsSingleJSON(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.config.StreamingDisabled = true
+	client.client = newStubConverseClient(newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hola, "}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"mundo"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":12,"outputTokens":4,"totalTokens":16},"metrics":{"latencyMs":100}}`},
+	}))
+
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(`{"stream": true, "messages": [{"role": "user", "content": "hola"}]}`))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %q", ct)
+	}
+	if strings.Contains(rec.Body.String(), "event:") {
+		t.Fatalf("Expected no SSE events in the body, got %s", rec.Body.String())
+	}
+
+	var message aggregatedMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &message); err != nil {
+		t.Fatalf("Expected a single JSON body: %v (%s)", err, rec.Body.String())
+	}
+	if len(message.Content) != 1 || message.Content[0]["text"] != "Hola, mundo" {
+		t.Errorf("Unexpected aggregated content: %v", message.Content)
+	}
+	if message.StopReason == nil || *message.StopReason != "end_turn" {
+		t.Errorf("Expected stop_reason end_turn, got %v", message.StopReason)
+	}
+	if message.Usage.InputTokens != 12 || message.Usage.OutputTokens != 4 {
+		t.Errorf("Expected usage 12/4, got %+v", message.Usage)
+	}
+}
+
+func TestStreamAggregatorToolUse(t *testing.T) {
+	aggregator := newStreamAggregator()
+	aggregator.Write([]byte("event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"tool-1\",\"name\":\"get_weather\",\"input\":{}}}\n\n"))
+	aggregator.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\"}}\n\n"))
+	aggregator.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"Madrid\\\"}\"}}\n\n"))
+	aggregator.Write([]byte("event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\",\"stop_sequence\":null},\"usage\":{\"output_tokens\":7}}\n\n"))
+
+	message, errorJSON := aggregator.aggregate()
+	if errorJSON != nil {
+		t.Fatalf("Unexpected error event: %s", errorJSON)
+	}
+	if len(message.Content) != 1 {
+		t.Fatalf("Expected one tool_use block, got %v", message.Content)
+	}
+	block := message.Content[0]
+	if block["type"] != "tool_use" || block["id"] != "tool-1" || block["name"] != "get_weather" {
+		t.Errorf("Unexpected tool_use block: %v", block)
+	}
+	if input := string(block["input"].(json.RawMessage)); input != `{"city":"Madrid"}` {
+		t.Errorf("Expected accumulated tool input, got %s", input)
+	}
+	if message.Usage.OutputTokens != 7 {
+		t.Errorf("Expected output tokens from message_delta, got %d", message.Usage.OutputTokens)
+	}
+}
+
+func TestStreamAggregatorMidStreamError(t *testing.T) {
+	aggregator := newStreamAggregator()
+	aggregator.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"parcial\"}}\n\n"))
+	sendSSEError(aggregator, "overloaded_error", "Bedrock stream error: throttled")
+
+	rec := httptest.NewRecorder()
+	aggregator.writeResponse(rec, http.StatusTooManyRequests)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"overloaded_error"`) {
+		t.Errorf("Expected Anthropic error body, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// Límites por defecto del system del cliente (MAX_SYSTEM_BLOCKS, MAX_SYSTEM_BYTES; 0 desactiva)
+const (
+	DefaultMaxSystemBlocks = 64
+	DefaultMaxSystemBytes  = 1 << 20
+)
+
+// MaxSystemCachePoints es el máximo de cache points por request que admite Bedrock
+const MaxSystemCachePoints = 4
+
+// DefaultSystemPromptTeam es la clave de SYSTEM_PROMPT_INJECTIONS que aplica a los equipos sin entrada propia
+const DefaultSystemPromptTeam = "*"
+
+// SystemPromptInjection es el texto obligatorio que se antepone/añade al system prompt de un equipo
+type SystemPromptInjection struct {
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// TeamSystemPrompts asocia cada equipo (o "*") con su inyección de system prompt
+type TeamSystemPrompts map[string]SystemPromptInjection
+
+// parseSystemPromptInjections parsea SYSTEM_PROMPT_INJECTIONS: {"<team>": {"prefix": "...", "suffix": "..."}}
+func parseSystemPromptInjections(raw string) (TeamSystemPrompts, error) {
+	var injections TeamSystemPrompts
+	if err := json.Unmarshal([]byte(raw), &injections); err != nil {
+		return nil, fmt.Errorf("invalid SYSTEM_PROMPT_INJECTIONS JSON: %w", err)
+	}
+	return injections, nil
+}
+
+// validateSystemLimits verifica el número de bloques, el tamaño total del texto y los cache_control
+// del system del cliente (string o array de bloques). Un límite <= 0 desactiva la comprobación.
+// Con forcePromptCaching los cache_control del cliente se ignoran y no cuentan
+func validateSystemLimits(system interface{}, maxBlocks, maxBytes int, forcePromptCaching bool) error {
+	if text, ok := system.(string); ok {
+		if maxBytes > 0 && len(text) > maxBytes {
+			return fmt.Errorf("system prompt too large: %d bytes (max: %d)", len(text), maxBytes)
+		}
+		return nil
+	}
+
+	blocks, ok := system.([]interface{})
+	if !ok {
+		return nil
+	}
+	if maxBlocks > 0 && len(blocks) > maxBlocks {
+		return fmt.Errorf("too many system blocks: %d (max: %d)", len(blocks), maxBlocks)
+	}
+
+	totalBytes, cachePoints := 0, 0
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		text, _ := blockMap["text"].(string)
+		totalBytes += len(text)
+		if hasEphemeralCacheControl(blockMap) {
+			cachePoints++
+		}
+	}
+	if maxBytes > 0 && totalBytes > maxBytes {
+		return fmt.Errorf("system prompt too large: %d bytes (max: %d)", totalBytes, maxBytes)
+	}
+	if !forcePromptCaching && cachePoints > MaxSystemCachePoints {
+		return fmt.Errorf("too many system blocks with cache_control: %d (max: %d)", cachePoints, MaxSystemCachePoints)
+	}
+	return nil
+}
+
+// hasEphemeralCacheControl indica si el bloque pide un cache point (cache_control de tipo ephemeral)
+func hasEphemeralCacheControl(blockMap map[string]interface{}) bool {
+	cacheControl, ok := blockMap["cache_control"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	cacheType, _ := cacheControl["type"].(string)
+	return cacheType == "ephemeral"
+}
+
+// logInvalidSystemPromptConfig avisa de SYSTEM_PROMPT_INJECTIONS inválido (el logger puede no estar inicializado)
+func logInvalidSystemPromptConfig(err error) {
+	if Logger == nil {
+		return
+	}
+	Logger.Warning(amslog.Event{
+		Name:    "SYSTEM_PROMPT_CONFIG_INVALID",
+		Message: "Invalid system prompt injection configuration, injection disabled",
+		Fields: map[string]interface{}{
+			"env_var": "SYSTEM_PROMPT_INJECTIONS",
+			"error":   err.Error(),
+		},
+	})
+}
+
+// systemPromptInjection devuelve la inyección del equipo (o la de "*" si el equipo no tiene entrada)
+func (this *BedrockClient) systemPromptInjection(team string) (SystemPromptInjection, bool) {
+	if injection, ok := this.config.SystemPromptInjections[team]; ok && team != "" {
+		return injection, true
+	}
+	injection, ok := this.config.SystemPromptInjections[DefaultSystemPromptTeam]
+	return injection, ok
+}
+
+// applySystemPromptInjection añade el prefijo como primer bloque y el sufijo como último.
+// Se insertan como bloques de texto propios para no desplazar los cache points del cliente:
+// el prefijo es idéntico en cada request del equipo, así que queda dentro del prefijo cacheado,
+// y el sufijo va tras el último cache point (no invalida la caché, pero no se cachea)
+func applySystemPromptInjection(systemBlocks []types.SystemContentBlock, injection SystemPromptInjection) []types.SystemContentBlock {
+	if injection.Prefix == "" && injection.Suffix == "" {
+		return systemBlocks
+	}
+
+	result := make([]types.SystemContentBlock, 0, len(systemBlocks)+2)
+	if injection.Prefix != "" {
+		result = append(result, &types.SystemContentBlockMemberText{Value: injection.Prefix})
+	}
+	result = append(result, systemBlocks...)
+	if injection.Suffix != "" {
+		result = append(result, &types.SystemContentBlockMemberText{Value: injection.Suffix})
+	}
+	return result
+}
+
+// injectTeamSystemPrompt aplica la inyección configurada para el equipo, si existe
+func (this *BedrockClient) injectTeamSystemPrompt(systemBlocks []types.SystemContentBlock, team string) []types.SystemContentBlock {
+	injection, ok := this.systemPromptInjection(team)
+	if !ok {
+		return systemBlocks
+	}
+	return applySystemPromptInjection(systemBlocks, injection)
+}
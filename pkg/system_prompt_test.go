@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+)
+
+// systemBlockKinds describe los bloques de system como "text:<valor>" o "cache"
+func systemBlockKinds(blocks []types.SystemContentBlock) []string {
+	var kinds []string
+	for _, block := range blocks {
+		switch b := block.(type) {
+		case *types.SystemContentBlockMemberText:
+			kinds = append(kinds, "text:"+b.Value)
+		case *types.SystemContentBlockMemberCachePoint:
+			kinds = append(kinds, "cache")
+		}
+	}
+	return kinds
+}
+
+func assertSystemBlocks(t *testing.T, blocks []types.SystemContentBlock, expected ...string) {
+	t.Helper()
+	got := systemBlockKinds(blocks)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected system blocks %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected system blocks %v, got %v", expected, got)
+		}
+	}
+}
+
+func newInjectionTestClient() *BedrockClient {
+	client := newTestBedrockClient()
+	client.config.SystemPromptInjections = TeamSystemPrompts{
+		"legal": {Prefix: "COMPLIANCE", Suffix: "FOOTER"},
+		"data":  {Prefix: "DATA RULES"},
+		"ops":   {Suffix: "OPS FOOTER"},
+	}
+	return client
+}
+
+func TestSystemPromptInjectionPrefix(t *testing.T) {
+	client := newInjectionTestClient()
+	payload := map[string]interface{}{"system": "You are helpful"}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "data")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:DATA RULES", "text:You are helpful")
+}
+
+func TestSystemPromptInjectionSuffix(t *testing.T) {
+	client := newInjectionTestClient()
+	payload := map[string]interface{}{"system": "You are helpful"}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "ops")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:You are helpful", "text:OPS FOOTER")
+}
+
+func TestSystemPromptInjectionWithoutClientSystem(t *testing.T) {
+	client := newInjectionTestClient()
+
+	input, _, err := client.BuildConverseInput(context.Background(), map[string]interface{}{}, "anthropic.claude-sonnet", "legal")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:COMPLIANCE", "text:FOOTER")
+}
+
+func TestSystemPromptInjectionKeepsClientCachePoints(t *testing.T) {
+	client := newInjectionTestClient()
+	payload := map[string]interface{}{
+		"system": []interface{}{
+			map[string]interface{}{"type": "text", "text": "static instructions", "cache_control": map[string]interface{}{"type": "ephemeral"}},
+			map[string]interface{}{"type": "text", "text": "dynamic context"},
+		},
+	}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "legal")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// El cache point sigue justo después del bloque marcado por el cliente y el prefijo queda dentro del prefijo cacheado
+	assertSystemBlocks(t, input.System,
+		"text:COMPLIANCE", "text:static instructions", "cache", "text:dynamic context", "text:FOOTER")
+}
+
+func TestSystemPromptInjectionWithForcedCaching(t *testing.T) {
+	client := newInjectionTestClient()
+	client.config.ForcePromptCaching = true
+	payload := map[string]interface{}{
+		"system": []interface{}{
+			map[string]interface{}{"type": "text", "text": "first"},
+			map[string]interface{}{"type": "text", "text": "last"},
+		},
+	}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "legal")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// El cache point forzado sigue en el último bloque del cliente; el sufijo va detrás sin desplazarlo
+	assertSystemBlocks(t, input.System,
+		"text:COMPLIANCE", "text:first", "text:last", "cache", "text:FOOTER")
+}
+
+func TestSystemPromptInjectionDefaultTeam(t *testing.T) {
+	client := newInjectionTestClient()
+	payload := map[string]interface{}{"system": "base"}
+
+	input, _, err := client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "unknown-team")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:base")
+
+	client.config.SystemPromptInjections[DefaultSystemPromptTeam] = SystemPromptInjection{Prefix: "GLOBAL"}
+	input, _, err = client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "unknown-team")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertSystemBlocks(t, input.System, "text:GLOBAL", "text:base")
+}
+
+func TestParseSystemPromptInjections(t *testing.T) {
+	injections, err := parseSystemPromptInjections(`{"legal":{"prefix":"P","suffix":"S"},"*":{"prefix":"G"}}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if injections["legal"].Prefix != "P" || injections["legal"].Suffix != "S" || injections["*"].Prefix != "G" {
+		t.Errorf("Unexpected injections: %+v", injections)
+	}
+
+	if _, err := parseSystemPromptInjections(`not json`); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+}
+
+// newSystemBlocks construye n bloques de system de texto; los primeros cached llevan cache_control
+func newSystemBlocks(n, cached int, text string) []interface{} {
+	blocks := make([]interface{}, n)
+	for i := range blocks {
+		block := map[string]interface{}{"type": "text", "text": text}
+		if i < cached {
+			block["cache_control"] = map[string]interface{}{"type": "ephemeral"}
+		}
+		blocks[i] = block
+	}
+	return blocks
+}
+
+func TestValidateSystemLimitsAtLimits(t *testing.T) {
+	// Justo en el límite de bloques, bytes y cache points: se acepta
+	if err := validateSystemLimits(newSystemBlocks(4, MaxSystemCachePoints, "abcd"), 4, 16, false); err != nil {
+		t.Errorf("Expected system at the limits to be accepted, got %v", err)
+	}
+
+	// Un bloque, un byte o un cache point por encima del límite
+	cases := []struct {
+		system interface{}
+		want   string
+	}{
+		{newSystemBlocks(5, 0, "a"), "too many system blocks: 5"},
+		{newSystemBlocks(2, 0, "123456789"), "system prompt too large: 18 bytes"},
+	}
+	for _, tc := range cases {
+		err := validateSystemLimits(tc.system, 4, 16, false)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Expected error %q, got %v", tc.want, err)
+		}
+	}
+	if err := validateSystemLimits(newSystemBlocks(MaxSystemCachePoints+1, MaxSystemCachePoints+1, "a"), 0, 0, false); err == nil ||
+		!strings.Contains(err.Error(), "cache_control") {
+		t.Errorf("Expected cache_control limit error, got %v", err)
+	}
+
+	// System string: solo cuenta el tamaño
+	if err := validateSystemLimits("12345", 1, 4, false); err == nil {
+		t.Error("Expected oversized string system to be rejected")
+	}
+	// Con caching forzado los cache_control del cliente no cuentan
+	if err := validateSystemLimits(newSystemBlocks(6, 6, "a"), 0, 0, true); err != nil {
+		t.Errorf("Expected client cache_control to be ignored with forced caching, got %v", err)
+	}
+}
+
+func TestConvertSystemBlocksCapsCachePoints(t *testing.T) {
+	blocks := convertSystemBlocksWithCache(newSystemBlocks(6, 6, "a"), false)
+	cachePoints := 0
+	for _, kind := range systemBlockKinds(blocks) {
+		if kind == "cache" {
+			cachePoints++
+		}
+	}
+	if cachePoints != MaxSystemCachePoints {
+		t.Errorf("Expected %d cache points, got %d", MaxSystemCachePoints, cachePoints)
+	}
+}
+
+func TestHandleProxyRejectsTooManySystemBlocks(t *testing.T) {
+	setupTestLogger(t)
+	client := newTestBedrockClient()
+	client.config.MaxSystemBlocks = 2
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"stream":     true,
+		"max_tokens": 10,
+		"system":     newSystemBlocks(3, 0, "a"),
+		"messages":   []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+	})
+	rec := httptest.NewRecorder()
+	client.HandleProxy(rec, newTestProxyRequest(string(body)))
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), string(ErrCodeSystemLimitsExceeded)) {
+		t.Errorf("Expected 400 %s, got %d: %s", ErrCodeSystemLimitsExceeded, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"bedrock-proxy-test/pkg/amslog"
+)
+
+// DefaultTLSMinVersion es la versión mínima de TLS para las conexiones salientes a AWS
+const DefaultTLSMinVersion = tls.VersionTLS12
+
+// parseTLSMinVersion convierte "1.2" / "1.3" (o "TLS1.2", "TLSv1.3") a la constante de crypto/tls
+func parseTLSMinVersion(raw string) (uint16, error) {
+	version := strings.TrimSpace(strings.ToUpper(raw))
+	version = strings.TrimPrefix(strings.TrimPrefix(version, "TLS"), "V")
+
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS min version %q (allowed: 1.2, 1.3)", raw)
+	}
+}
+
+// parseTLSCipherSuites convierte una lista de nombres IANA separados por coma a IDs de cipher suite
+// Solo se admiten suites seguras de crypto/tls; las inseguras o desconocidas devuelven error
+func parseTLSCipherSuites(raw string) ([]uint16, error) {
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	for _, name := range splitCommaList(raw) {
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// logInvalidTLSConfig avisa de una variable TLS inválida (el logger puede no estar inicializado)
+func logInvalidTLSConfig(envVar string, err error) {
+	if Logger == nil {
+		return
+	}
+	Logger.Warning(amslog.Event{
+		Name:    "TLS_CONFIG_INVALID",
+		Message: "Invalid outbound TLS configuration, using default",
+		Fields: map[string]interface{}{
+			"env_var": envVar,
+			"error":   err.Error(),
+		},
+	})
+}
+
+// newOutboundTLSConfig construye la configuración TLS de las conexiones salientes a Bedrock
+// Go no permite restringir las suites de TLS 1.3: CipherSuites solo aplica a TLS 1.2
+func newOutboundTLSConfig(config *BedrockConfig) *tls.Config {
+	minVersion := config.TLSMinVersion
+	if minVersion == 0 {
+		minVersion = DefaultTLSMinVersion
+	}
+	return &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: config.TLSCipherSuites,
+	}
+}
+
+// newOutboundHTTPClient crea el http.Client compartido por el SDK y las llamadas no-stream
+func newOutboundHTTPClient(config *BedrockConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = newOutboundTLSConfig(config)
+
+	if config.DEBUG {
+		return &http.Client{
+			Transport: loggingRoundTripper{
+				wrapped: transport,
+			},
+		}
+	}
+	return &http.Client{Transport: transport}
+}
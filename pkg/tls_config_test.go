@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestNewOutboundHTTPClientSetsTLSConfig(t *testing.T) {
+	suites, err := parseTLSCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing cipher suites: %v", err)
+	}
+
+	config := &BedrockConfig{TLSMinVersion: tls.VersionTLS13, TLSCipherSuites: suites}
+	client := newOutboundHTTPClient(config)
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected *http.Transport, got %T", client.Transport)
+	}
+	if transport.TLSClientConfig == nil {
+		t.Fatal("Expected TLSClientConfig to be set")
+	}
+	if transport.TLSClientConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected MinVersion TLS 1.3, got %x", transport.TLSClientConfig.MinVersion)
+	}
+	if len(transport.TLSClientConfig.CipherSuites) != 2 || transport.TLSClientConfig.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Expected configured cipher suites, got %v", transport.TLSClientConfig.CipherSuites)
+	}
+}
+
+func TestNewOutboundHTTPClientDefaultsToTLS12(t *testing.T) {
+	client := newOutboundHTTPClient(&BedrockConfig{})
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSClientConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected default MinVersion TLS 1.2, got %x", transport.TLSClientConfig.MinVersion)
+	}
+}
+
+func TestParseTLSConfigRejectsInvalidValues(t *testing.T) {
+	if _, err := parseTLSMinVersion("1.0"); err == nil {
+		t.Error("Expected error for TLS 1.0")
+	}
+	if v, err := parseTLSMinVersion("TLSv1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3, got %x (%v)", v, err)
+	}
+	if _, err := parseTLSCipherSuites("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Error("Expected error for insecure cipher suite")
+	}
+}
+
+func TestLoadBedrockConfigTLSFromEnv(t *testing.T) {
+	t.Setenv("OUTBOUND_TLS_MIN_VERSION", "1.3")
+	t.Setenv("OUTBOUND_TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+
+	config := LoadBedrockConfigWithEnv()
+	if config.TLSMinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 from env, got %x", config.TLSMinVersion)
+	}
+	if len(config.TLSCipherSuites) != 1 {
+		t.Errorf("Expected 1 cipher suite from env, got %v", config.TLSCipherSuites)
+	}
+}
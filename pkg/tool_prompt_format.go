@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// ToolPromptFormat es el formato con el que se describen las tools en el system prompt (modo XML)
+type ToolPromptFormat string
+
+const (
+	// ToolPromptFormatText describe cada tool en texto con su ejemplo XML (formato que espera Cline)
+	ToolPromptFormatText ToolPromptFormat = "text"
+	// ToolPromptFormatJSON describe las tools como un array JSON compacto
+	ToolPromptFormatJSON ToolPromptFormat = "json"
+)
+
+// ToolPromptFormatHeader permite al cliente elegir el formato en cada request
+const ToolPromptFormatHeader = "X-Tool-Format"
+
+// DefaultToolPromptFormatByUserAgent asigna formato por User-Agent cuando no se configura otro
+const DefaultToolPromptFormatByUserAgent = "cline=text"
+
+// ParseToolPromptFormat interpreta un formato; ok es false si el valor no es text ni json
+func ParseToolPromptFormat(value string) (ToolPromptFormat, bool) {
+	switch format := ToolPromptFormat(strings.ToLower(strings.TrimSpace(value))); format {
+	case ToolPromptFormatText, ToolPromptFormatJSON:
+		return format, true
+	}
+	return "", false
+}
+
+// ToolFormatRules asigna formato por fragmento de User-Agent (en minúsculas)
+type ToolFormatRules map[string]ToolPromptFormat
+
+// parseToolFormatByUserAgent parsea "cline=text,otro-cliente=json"; se ignoran los formatos desconocidos
+func parseToolFormatByUserAgent(raw string) ToolFormatRules {
+	rules := make(ToolFormatRules)
+	for pattern, value := range ParseMappingsFromStr(raw) {
+		if format, ok := ParseToolPromptFormat(value); ok && pattern != "" {
+			rules[strings.ToLower(pattern)] = format
+		}
+	}
+	return rules
+}
+
+// toolPromptFormat elige el formato de las tools para el cliente de la request, por prioridad:
+// header X-Tool-Format, claim tool_format del JWT, regla de User-Agent (la coincidencia más larga) y default
+func (this *BedrockClient) toolPromptFormat(r *http.Request) ToolPromptFormat {
+	if format, ok := ParseToolPromptFormat(r.Header.Get(ToolPromptFormatHeader)); ok {
+		return format
+	}
+	if user, err := auth.GetUserFromContext(r.Context()); err == nil {
+		if format, ok := ParseToolPromptFormat(user.ToolFormat); ok {
+			return format
+		}
+	}
+
+	userAgent := strings.ToLower(r.Header.Get("User-Agent"))
+	matched := ""
+	format := this.config.ToolPromptFormat
+	for pattern, patternFormat := range this.config.ToolFormatByUserAgent {
+		if len(pattern) > len(matched) && strings.Contains(userAgent, pattern) {
+			matched, format = pattern, patternFormat
+		}
+	}
+	if format == "" {
+		format = ToolPromptFormatJSON
+	}
+	return format
+}
+
+type toolPromptFormatContextKey struct{}
+
+// withToolPromptFormat guarda en el contexto el formato elegido para la request
+func withToolPromptFormat(ctx context.Context, format ToolPromptFormat) context.Context {
+	return context.WithValue(ctx, toolPromptFormatContextKey{}, format)
+}
+
+// toolPromptFormatFromContext devuelve el formato de la request o el configurado por defecto
+func (this *BedrockClient) toolPromptFormatFromContext(ctx context.Context) ToolPromptFormat {
+	if format, ok := ctx.Value(toolPromptFormatContextKey{}).(ToolPromptFormat); ok && format != "" {
+		return format
+	}
+	if this.config.ToolPromptFormat != "" {
+		return this.config.ToolPromptFormat
+	}
+	return ToolPromptFormatJSON
+}
+
+// convertToolsForSystemPrompt describe las tools para el system prompt en el formato indicado
+func convertToolsForSystemPrompt(anthropicTools []interface{}, format ToolPromptFormat) (string, error) {
+	if format == ToolPromptFormatText {
+		return convertAnthropicToolsToText(anthropicTools)
+	}
+	return convertAnthropicToolsToJSON(anthropicTools)
+}
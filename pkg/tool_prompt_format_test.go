@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// newToolFormatRequest crea una request con el User-Agent, header X-Tool-Format y claim tool_format dados
+func newToolFormatRequest(userAgent, header, claim string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("User-Agent", userAgent)
+	if header != "" {
+		req.Header.Set(ToolPromptFormatHeader, header)
+	}
+	user := auth.UserContext{UserID: "user-1", ToolFormat: claim}
+	return req.WithContext(context.WithValue(req.Context(), auth.UserContextKey, user))
+}
+
+func TestToolPromptFormatSelectionPerClient(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.ToolPromptFormat = ToolPromptFormatJSON
+	client.config.ToolFormatByUserAgent = parseToolFormatByUserAgent(DefaultToolPromptFormatByUserAgent + ",cline-json-fork=json")
+
+	cases := []struct {
+		name      string
+		userAgent string
+		header    string
+		claim     string
+		expected  ToolPromptFormat
+	}{
+		{"cline user agent", "Cline/3.2.1 (vscode)", "", "", ToolPromptFormatText},
+		{"other client uses default", "acme-agent/1.0", "", "", ToolPromptFormatJSON},
+		{"longest user agent rule wins", "cline-json-fork/0.1", "", "", ToolPromptFormatJSON},
+		{"jwt claim over user agent", "Cline/3.2.1", "", "json", ToolPromptFormatJSON},
+		{"header over jwt claim", "acme-agent/1.0", "TEXT", "json", ToolPromptFormatText},
+		{"invalid header ignored", "Cline/3.2.1", "yaml", "", ToolPromptFormatText},
+	}
+	for _, tc := range cases {
+		if got := client.toolPromptFormat(newToolFormatRequest(tc.userAgent, tc.header, tc.claim)); got != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestConvertToolsForSystemPromptUsesSelectedConverter(t *testing.T) {
+	tools := []interface{}{
+		map[string]interface{}{
+			"name":        "read_file",
+			"description": "Read a file",
+			"input_schema": map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"path": map[string]interface{}{"type": "string", "description": "File path"}},
+				"required":   []interface{}{"path"},
+			},
+		},
+	}
+
+	text, err := convertToolsForSystemPrompt(tools, ToolPromptFormatText)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(text, "## read_file\nDescription: Read a file") || !strings.Contains(text, "- path: (required) File path") {
+		t.Errorf("Expected Cline text tool description, got %q", text)
+	}
+	if strings.Contains(text, "```json") {
+		t.Error("Expected text format without JSON schemas")
+	}
+
+	jsonText, err := convertToolsForSystemPrompt(tools, ToolPromptFormatJSON)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(jsonText, "```json") || !strings.Contains(jsonText, `"name": "read_file"`) {
+		t.Errorf("Expected JSON tool description, got %q", jsonText)
+	}
+}
+
+func TestBuildConverseInputToolFormatFromContext(t *testing.T) {
+	setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	payload := map[string]interface{}{
+		"system":   "You are helpful.",
+		"tools":    buildTestTools(1, "schema"),
+		"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hola"}},
+	}
+
+	ctx := withToolPromptFormat(context.Background(), ToolPromptFormatText)
+	input, _, err := client.BuildConverseInput(ctx, payload, "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := systemText(input.System); !strings.Contains(got, "## tool_0") {
+		t.Errorf("Expected text tool format in system prompt, got %q", got)
+	}
+
+	// Sin formato en el contexto se usa el default (JSON)
+	input, _, err = client.BuildConverseInput(context.Background(), payload, "anthropic.claude-sonnet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := systemText(input.System); !strings.Contains(got, "```json") {
+		t.Errorf("Expected JSON tool format by default, got %q", got)
+	}
+}
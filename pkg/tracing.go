@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifica los spans creados por el proxy
+const tracerName = "bedrock-proxy"
+
+// DefaultTracingServiceName es el service.name de los spans si no se define OTEL_SERVICE_NAME
+const DefaultTracingServiceName = "bedrock-proxy"
+
+// InitTracing activa el export OTLP/HTTP de spans si OTEL_EXPORTER_OTLP_ENDPOINT está configurado.
+// Sin endpoint el tracer global de OTel es un no-op y la función de cierre no hace nada.
+func InitTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// El exporter lee el endpoint, headers y TLS de las variables OTEL_EXPORTER_OTLP_*
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := getEnvOrDefault("OTEL_SERVICE_NAME", DefaultTracingServiceName)
+	provider := newTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// newTracerProvider crea un TracerProvider cuyos trace IDs coinciden con el trace.id de los logs
+func newTracerProvider(opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	opts = append(opts, sdktrace.WithIDGenerator(logTraceIDGenerator{}))
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// logTraceIDGenerator deriva el trace ID de OTel del trace ID de amslog presente en el contexto
+type logTraceIDGenerator struct{}
+
+func (logTraceIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	traceID, ok := traceIDFromLogTraceID(amslog.TraceIDFromContext(ctx))
+	if !ok {
+		rand.Read(traceID[:])
+	}
+	return traceID, newSpanID()
+}
+
+func (logTraceIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+func newSpanID() trace.SpanID {
+	var spanID trace.SpanID
+	for !spanID.IsValid() {
+		rand.Read(spanID[:])
+	}
+	return spanID
+}
+
+// traceIDFromLogTraceID convierte el trace ID de los logs (UUID o 32 hex) a un trace ID de OTel.
+// Los IDs con otro formato (p.ej. un X-Trace-ID arbitrario) se derivan con SHA-256 de forma estable.
+func traceIDFromLogTraceID(logTraceID string) (trace.TraceID, bool) {
+	var traceID trace.TraceID
+	if logTraceID == "" {
+		return traceID, false
+	}
+
+	if raw, err := hex.DecodeString(strings.ReplaceAll(logTraceID, "-", "")); err == nil && len(raw) == len(traceID) {
+		copy(traceID[:], raw)
+	} else {
+		sum := sha256.Sum256([]byte(logTraceID))
+		copy(traceID[:], sum[:len(traceID)])
+	}
+	return traceID, traceID.IsValid()
+}
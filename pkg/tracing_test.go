@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"bedrock-proxy-test/pkg/amslog"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setupTestTracer registra un TracerProvider con exporter en memoria durante el test
+func setupTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previous)
+	})
+	return exporter
+}
+
+func TestRequestContextPhaseSpans(t *testing.T) {
+	exporter := setupTestTracer(t)
+
+	logTraceID := "0f8fad5b-d9cb-469f-a165-70867728950e"
+	ctx := amslog.WithTraceID(context.Background(), logTraceID)
+
+	reqCtx := NewRequestContext("req-1")
+	reqCtx.StartTrace(ctx)
+	for _, phase := range []string{"sign_request", "parse_request", "streaming", "post_processing"} {
+		reqCtx.StartPhase(phase)()
+	}
+	reqCtx.EndTrace()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 5 {
+		t.Fatalf("Expected 5 spans (root + 4 phases), got %d", len(spans))
+	}
+
+	expectedTraceID, _ := traceIDFromLogTraceID(logTraceID)
+	if expectedTraceID.String() != "0f8fad5bd9cb469fa16570867728950e" {
+		t.Fatalf("Expected trace ID derived from log trace ID, got %s", expectedTraceID)
+	}
+
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = span
+		if span.SpanContext.TraceID() != expectedTraceID {
+			t.Errorf("Span %s has trace ID %s, expected %s", span.Name, span.SpanContext.TraceID(), expectedTraceID)
+		}
+	}
+
+	root, ok := byName["proxy.request"]
+	if !ok {
+		t.Fatal("Expected root span proxy.request")
+	}
+	for _, phase := range []string{"sign_request", "parse_request", "streaming", "post_processing"} {
+		span, ok := byName[phase]
+		if !ok {
+			t.Errorf("Expected span for phase %s", phase)
+			continue
+		}
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("Expected phase %s to be a child of the root span", phase)
+		}
+	}
+}
+
+func TestRequestContextWithoutTraceIsNoop(t *testing.T) {
+	exporter := setupTestTracer(t)
+
+	reqCtx := NewRequestContext("req-1")
+	reqCtx.StartPhase("sign_request")()
+	reqCtx.EndTrace()
+
+	if got := len(exporter.GetSpans()); got != 0 {
+		t.Errorf("Expected no spans without StartTrace, got %d", got)
+	}
+	if _, ok := reqCtx.PhaseTimings["sign_request"]; !ok {
+		t.Error("Expected phase timing to be recorded without tracing")
+	}
+}
+
+func TestTraceIDFromArbitraryLogTraceID(t *testing.T) {
+	first, ok := traceIDFromLogTraceID("client-trace-abc")
+	if !ok {
+		t.Fatal("Expected a valid trace ID for arbitrary log trace IDs")
+	}
+	second, _ := traceIDFromLogTraceID("client-trace-abc")
+	if first != second {
+		t.Error("Expected stable trace ID derivation")
+	}
+	if _, ok := traceIDFromLogTraceID(""); ok {
+		t.Error("Expected no trace ID for empty log trace ID")
+	}
+}
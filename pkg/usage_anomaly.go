@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"context"
+
+	"bedrock-proxy-test/pkg/amslog"
+	"bedrock-proxy-test/pkg/auth"
+)
+
+// Tipos de anomalía en el uso informado por Bedrock
+const (
+	UsageAnomalyNegativeTokens = "negative_tokens"           // Contador de tokens negativo
+	UsageAnomalyOutputOverMax  = "output_exceeds_max_tokens" // output_tokens mayor que el max_tokens enviado
+)
+
+// usageAnomaly es un valor de uso implausible y el valor con el que se factura
+type usageAnomaly struct {
+	Type     string
+	Field    string
+	Reported int
+	Billed   int
+}
+
+// sanitizeUsage detecta usos implausibles y ajusta la métrica a rangos válidos para facturar.
+// Los tokens de caché no se comparan con input: en Converse input_tokens no incluye los de caché,
+// así que cache_read + cache_write > input es normal (prompt mayormente cacheado)
+func sanitizeUsage(metric *MetricData) []usageAnomaly {
+	var anomalies []usageAnomaly
+
+	clampNegative := func(field string, value *int) {
+		if *value < 0 {
+			anomalies = append(anomalies, usageAnomaly{UsageAnomalyNegativeTokens, field, *value, 0})
+			*value = 0
+		}
+	}
+	clampNegative("tokens.input", &metric.TokensInput)
+	clampNegative("tokens.output", &metric.TokensOutput)
+	clampNegative("tokens.cache_read", &metric.TokensCacheRead)
+	clampNegative("tokens.cache_write", &metric.TokensCacheWriteTokens)
+
+	if metric.MaxTokens > 0 && metric.TokensOutput > metric.MaxTokens {
+		anomalies = append(anomalies, usageAnomaly{UsageAnomalyOutputOverMax, "tokens.output", metric.TokensOutput, metric.MaxTokens})
+		metric.TokensOutput = metric.MaxTokens
+	}
+
+	return anomalies
+}
+
+// logUsageAnomalies emite un USAGE_ANOMALY por anomalía con los valores originales de la métrica
+func logUsageAnomalies(ctx context.Context, user *auth.UserContext, raw MetricData, anomalies []usageAnomaly) {
+	for _, anomaly := range anomalies {
+		Logger.WarningContext(ctx, amslog.Event{
+			Name:    EventUsageAnomaly,
+			Message: "Implausible token usage reported by Bedrock, clamped for billing",
+			Fields: map[string]interface{}{
+				"anomaly.type":           anomaly.Type,
+				"anomaly.field":          anomaly.Field,
+				"anomaly.reported":       anomaly.Reported,
+				"anomaly.billed":         anomaly.Billed,
+				"user.id":                user.UserID,
+				"request.id":             raw.RequestID,
+				"model.id":               raw.ModelID,
+				"request.max_tokens":     raw.MaxTokens,
+				"raw.tokens.input":       raw.TokensInput,
+				"raw.tokens.output":      raw.TokensOutput,
+				"raw.tokens.cache_read":  raw.TokensCacheRead,
+				"raw.tokens.cache_write": raw.TokensCacheWriteTokens,
+			},
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bedrock-proxy-test/pkg/auth"
+	"bedrock-proxy-test/pkg/metrics"
+)
+
+func TestSanitizeUsageNegativeTokens(t *testing.T) {
+	metric := &MetricData{TokensInput: -5, TokensOutput: 20, TokensCacheRead: -1}
+
+	anomalies := sanitizeUsage(metric)
+	if len(anomalies) != 2 {
+		t.Fatalf("Expected 2 anomalies, got %+v", anomalies)
+	}
+	if anomalies[0].Type != UsageAnomalyNegativeTokens || anomalies[0].Field != "tokens.input" || anomalies[0].Reported != -5 {
+		t.Errorf("Unexpected anomaly: %+v", anomalies[0])
+	}
+	if metric.TokensInput != 0 || metric.TokensCacheRead != 0 || metric.TokensOutput != 20 {
+		t.Errorf("Expected negative tokens clamped to 0, got %+v", metric)
+	}
+}
+
+func TestSanitizeUsageOutputOverMaxTokens(t *testing.T) {
+	metric := &MetricData{TokensInput: 10, TokensOutput: 5000, MaxTokens: 1024}
+
+	anomalies := sanitizeUsage(metric)
+	if len(anomalies) != 1 || anomalies[0].Type != UsageAnomalyOutputOverMax || anomalies[0].Billed != 1024 {
+		t.Fatalf("Expected output_exceeds_max_tokens anomaly, got %+v", anomalies)
+	}
+	if metric.TokensOutput != 1024 {
+		t.Errorf("Expected output clamped to max_tokens, got %d", metric.TokensOutput)
+	}
+
+	// Sin max_tokens conocido no se compara
+	metric = &MetricData{TokensOutput: 5000}
+	if anomalies := sanitizeUsage(metric); len(anomalies) != 0 {
+		t.Errorf("Expected no anomalies without max_tokens, got %+v", anomalies)
+	}
+}
+
+func TestSanitizeUsageCacheAboveInputIsValid(t *testing.T) {
+	// En Converse input_tokens excluye la caché: un prompt mayormente cacheado no es una anomalía
+	metric := &MetricData{TokensInput: 3, TokensOutput: 100, TokensCacheRead: 12000, TokensCacheWriteTokens: 800, MaxTokens: 4096}
+
+	if anomalies := sanitizeUsage(metric); len(anomalies) != 0 {
+		t.Errorf("Expected no anomalies, got %+v", anomalies)
+	}
+	if metric.TokensCacheRead != 12000 || metric.TokensInput != 3 {
+		t.Errorf("Expected usage untouched, got %+v", metric)
+	}
+}
+
+func TestProcessMetricsLogsUsageAnomaly(t *testing.T) {
+	buf := setupTestLogger(t)
+
+	client := newTestBedrockClient()
+	client.metricsWorker = metrics.NewMetricsWorker(nil, metrics.DefaultConfig())
+	user := &auth.UserContext{UserID: "user-1"}
+
+	mc := NewMetricsCapture(httptest.NewRecorder(), "eu.anthropic.claude-sonnet-4-5-20250929-v1:0", "req-1", newTestProxyRequest(`{}`))
+	mc.SetMaxTokens(8)
+	mc.Write([]byte("event: ping\ndata: {\"type\":\"ping\",\"usage\":{\"input_tokens\":10,\"output_tokens\":20,\"cache_creation_input_tokens\":0,\"cache_read_input_tokens\":0}}\n\n"))
+
+	client.processMetrics(context.Background(), user, mc, time.Now())
+
+	Logger.Close()
+	if !containsEvent(buf.String(), EventUsageAnomaly) {
+		t.Errorf("Expected %s log, got: %s", EventUsageAnomaly, buf.String())
+	}
+	if got := client.metricsWorker.Stats().BufferedCount; got != 1 {
+		t.Errorf("Expected usage still recorded after clamping, got %d", got)
+	}
+}
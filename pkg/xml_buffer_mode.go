@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// XMLBufferHeader permite al cliente desactivar ("off") o ajustar (tamaño en caracteres) el buffer XML
+const XMLBufferHeader = "X-XML-Buffer"
+
+// xmlBufferOff es el tamaño que desactiva el buffer en reglas y header
+const xmlBufferOff = 0
+
+// textChunkBuffer procesa el texto del stream antes de enviarlo al cliente
+type textChunkBuffer interface {
+	ProcessChunk(chunk string) string
+	Flush() string
+	HasBufferedContent() bool
+}
+
+// passthroughTextBuffer envía cada chunk tal cual: para clientes que no parsean tools en XML
+type passthroughTextBuffer struct{}
+
+func (passthroughTextBuffer) ProcessChunk(chunk string) string { return chunk }
+func (passthroughTextBuffer) Flush() string                    { return "" }
+func (passthroughTextBuffer) HasBufferedContent() bool         { return false }
+
+// XMLBufferRules asigna tamaño de buffer por fragmento de User-Agent (en minúsculas); 0 lo desactiva
+type XMLBufferRules map[string]int
+
+// parseXMLBufferSize interpreta "off", "on" (tamaño global, -1) o un tamaño positivo
+func parseXMLBufferSize(value string) (int, bool) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case "off", "false", "0":
+		return xmlBufferOff, true
+	case "on", "true":
+		return -1, true
+	}
+	if size, err := strconv.Atoi(value); err == nil && size > 0 {
+		return size, true
+	}
+	return 0, false
+}
+
+// parseXMLBufferByUserAgent parsea "cline=250,curl=off"; se ignoran los valores no válidos
+func parseXMLBufferByUserAgent(raw string) XMLBufferRules {
+	rules := make(XMLBufferRules)
+	for pattern, value := range ParseMappingsFromStr(raw) {
+		if size, ok := parseXMLBufferSize(value); ok && pattern != "" {
+			rules[strings.ToLower(pattern)] = size
+		}
+	}
+	return rules
+}
+
+// xmlBufferFor elige el buffer de texto de la request, por prioridad: sin buffer con tools nativas
+// (no hay XML que proteger), header X-XML-Buffer, regla de User-Agent (la coincidencia más larga) y
+// XML_BUFFER_DEFAULT. nil = buffer global con XML_BUFFER_MAX_SIZE
+func (this *BedrockClient) xmlBufferFor(r *http.Request, nativeTools bool) textChunkBuffer {
+	if nativeTools {
+		return passthroughTextBuffer{}
+	}
+
+	size, ok := parseXMLBufferSize(r.Header.Get(XMLBufferHeader))
+	if !ok {
+		size = -1
+		if this.config.XMLBufferDisabled {
+			size = xmlBufferOff
+		}
+		userAgent := strings.ToLower(r.Header.Get("User-Agent"))
+		matched := ""
+		for pattern, patternSize := range this.config.XMLBufferByUserAgent {
+			if len(pattern) > len(matched) && strings.Contains(userAgent, pattern) {
+				matched, size = pattern, patternSize
+			}
+		}
+	}
+
+	switch {
+	case size == xmlBufferOff:
+		return passthroughTextBuffer{}
+	case size > 0:
+		return NewXMLTagBuffer(size)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestXMLBufferSelectionPerClient(t *testing.T) {
+	client := newTestBedrockClient()
+	client.config.XMLBufferByUserAgent = parseXMLBufferByUserAgent("cline=100,curl=off")
+
+	cases := []struct {
+		name        string
+		userAgent   string
+		header      string
+		nativeTools bool
+		expected    string // default, off o xml
+	}{
+		{"unmatched client uses global buffer", "acme-agent/1.0", "", false, "default"},
+		{"cline rule tunes buffer", "Cline/3.2.1", "", false, "xml"},
+		{"user agent rule disables buffer", "curl/8.0", "", false, "off"},
+		{"header over user agent rule", "Cline/3.2.1", "off", false, "off"},
+		{"native tools bypass buffer", "Cline/3.2.1", "on", true, "off"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("User-Agent", tc.userAgent)
+		if tc.header != "" {
+			req.Header.Set(XMLBufferHeader, tc.header)
+		}
+
+		got := "default"
+		switch buffer := client.xmlBufferFor(req, tc.nativeTools).(type) {
+		case passthroughTextBuffer:
+			got = "off"
+		case *XMLTagBuffer:
+			got = "xml"
+			if buffer.maxBufferSize != 100 {
+				t.Errorf("%s: expected buffer size 100, got %d", tc.name, buffer.maxBufferSize)
+			}
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected %s buffer, got %s", tc.name, tc.expected, got)
+		}
+	}
+
+	// XML_BUFFER_DEFAULT=off: sin buffer salvo para los clientes con regla
+	client.config.XMLBufferDisabled = true
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("User-Agent", "acme-agent/1.0")
+	if _, ok := client.xmlBufferFor(req, false).(passthroughTextBuffer); !ok {
+		t.Error("Expected no XML buffer when disabled by default")
+	}
+}
+
+func TestStreamBypassesXMLBufferForNonXMLClient(t *testing.T) {
+	setupTestLogger(t)
+	t.Setenv("XML_BUFFER_MAX_SIZE", "250")
+	body := newConverseStreamBody(t, [][2]string{
+		{"messageStart", `{"role":"assistant"}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"hola <rea"}}`},
+		{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"d_file>"}}`},
+		{"contentBlockStop", `{"contentBlockIndex":0}`},
+		{"messageStop", `{"stopReason":"end_turn"}`},
+		{"metadata", `{"usage":{"inputTokens":5,"outputTokens":2,"totalTokens":7},"metrics":{"latencyMs":10}}`},
+	})
+	modelID := "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"
+	client := newTestBedrockClient()
+
+	// Con el buffer global el tag incompleto se retiene hasta completarse
+	rec := httptest.NewRecorder()
+	if err := client.handleBedrockStreamConverse(context.Background(), rec, newStubConverseClient(body), modelID, nil, nil, 1024, nil, nil, converseOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(rec.Body.String(), `"text":"hola \u003crea"`) || !strings.Contains(rec.Body.String(), `"text":"\u003cread_file\u003e"`) {
+		t.Errorf("Expected XML buffer to keep the tag whole, got %s", rec.Body.String())
+	}
+
+	// Sin buffer cada chunk se envía tal cual, sin esperar
+	rec = httptest.NewRecorder()
+	opts := converseOptions{TextBuffer: passthroughTextBuffer{}}
+	if err := client.handleBedrockStreamConverse(context.Background(), rec, newStubConverseClient(body), modelID, nil, nil, 1024, nil, nil, opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), `"text":"hola \u003crea"`) || !strings.Contains(rec.Body.String(), `"text":"d_file\u003e"`) {
+		t.Errorf("Expected chunks to bypass the XML buffer, got %s", rec.Body.String())
+	}
+}